@@ -29,7 +29,7 @@ var _ = math.Inf
 // An Ann is a source code annotation.
 //
 // Annotations are unique on (Repo, CommitID, UnitType, Unit, File,
-// Start, End, Type).
+// Start, End, DefPath, Type).
 type Ann struct {
 	// Repo is the VCS repository in which this ann exists.
 	Repo string `protobuf:"bytes,1,opt,name=repo,proto3" json:"Repo,omitempty"`
@@ -57,6 +57,11 @@ type Ann struct {
 	// Data contains arbitrary JSON data that is specific to this
 	// annotation type (e.g., the link URL for Link annotations).
 	Data sourcegraph_com_sqs_pbtypes.RawMessage `protobuf:"bytes,9,opt,name=data,proto3,customtype=sourcegraph.com/sqs/pbtypes.RawMessage" json:"Data,omitempty"`
+	// DefPath is the path of the def (in the ann's source unit) that
+	// this ann is attached to, for anns that apply to a def as a
+	// whole (e.g., deprecation marks) instead of to a range of
+	// File. If File is also set, the ann is shown at that range.
+	DefPath string `protobuf:"bytes,10,opt,name=def_path,proto3" json:"DefPath,omitempty"`
 }
 
 func (m *Ann) Reset()         { *m = Ann{} }
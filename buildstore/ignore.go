@@ -0,0 +1,182 @@
+package buildstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/kr/fs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// IgnoreFilenames are the names of the files (at the top level of a
+// repository) that list paths to skip when scanning the repository
+// or walking its build data. They use .gitignore syntax.
+var IgnoreFilenames = []string{".gitignore", ".srclibignore"}
+
+// An Ignorer matches paths against a list of .gitignore-style
+// patterns. A nil *Ignorer ignores nothing.
+//
+// Only the common subset of the .gitignore syntax is supported:
+// comments, negation ("!"), directory-only patterns (trailing "/"),
+// anchored patterns (containing a "/"), a leading "**/", and a
+// trailing "/**". Other uses of "**" are not supported.
+type Ignorer struct {
+	patterns []ignorePattern
+}
+
+type ignorePattern struct {
+	pattern  string // glob pattern (for path.Match), without any "!" prefix or "/" suffix
+	negate   bool   // whether the pattern began with "!" (un-ignoring matching paths)
+	dirOnly  bool   // whether the pattern only matches dirs
+	anchored bool   // whether the pattern matches the whole path (not just the base name)
+	anyDepth bool   // whether the pattern (which began with "**/") matches the end of the path, below any dir
+}
+
+// ReadIgnoreFiles reads the patterns in all of the IgnoreFilenames
+// that exist in dir.
+func ReadIgnoreFiles(dir string) (*Ignorer, error) {
+	ig := &Ignorer{}
+	for _, name := range IgnoreFilenames {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		ig.addPatterns(string(data))
+	}
+	return ig, nil
+}
+
+// NewIgnorer returns an Ignorer for the patterns in s, which is in
+// .gitignore syntax (one pattern per line).
+func NewIgnorer(s string) *Ignorer {
+	ig := &Ignorer{}
+	ig.addPatterns(s)
+	return ig
+}
+
+func (ig *Ignorer) addPatterns(s string) {
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var p ignorePattern
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		var anyDepth bool
+		if strings.HasPrefix(line, "**/") {
+			anyDepth = true
+			line = strings.TrimPrefix(line, "**/")
+		} else if strings.Contains(line, "/") {
+			p.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if strings.HasSuffix(line, "/**") {
+			p.dirOnly = true
+			line = strings.TrimSuffix(line, "/**")
+		}
+		// After a leading "**/", a single name matches the base name,
+		// but a pattern with more segments must match whole path
+		// segments.
+		p.anyDepth = anyDepth && strings.Contains(line, "/")
+		if line == "" {
+			continue
+		}
+		p.pattern = line
+		ig.patterns = append(ig.patterns, p)
+	}
+}
+
+// Ignored returns whether the path (relative to the directory that
+// the ignore files apply to) is ignored. It does not check whether
+// any of the path's parent dirs are ignored; use IgnoredTree for
+// that.
+func (ig *Ignorer) Ignored(p string, isDir bool) bool {
+	if ig == nil {
+		return false
+	}
+	p = path.Clean(filepath.ToSlash(p))
+	var ignored bool
+	for _, pat := range ig.patterns {
+		if pat.dirOnly && !isDir {
+			continue
+		}
+		if pat.matches(p) {
+			ignored = !pat.negate
+		}
+	}
+	return ignored
+}
+
+// IgnoredTree returns whether the path or any of its parent dirs is
+// ignored.
+func (ig *Ignorer) IgnoredTree(p string, isDir bool) bool {
+	if ig == nil {
+		return false
+	}
+	p = path.Clean(filepath.ToSlash(p))
+	for dir := path.Dir(p); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if ig.Ignored(dir, true) {
+			return true
+		}
+	}
+	return ig.Ignored(p, isDir)
+}
+
+func (p ignorePattern) matches(name string) bool {
+	if p.anyDepth {
+		for {
+			if match, _ := path.Match(p.pattern, name); match {
+				return true
+			}
+			i := strings.Index(name, "/")
+			if i == -1 {
+				return false
+			}
+			name = name[i+1:]
+		}
+	}
+	if !p.anchored {
+		name = path.Base(name)
+	}
+	match, _ := path.Match(p.pattern, name)
+	return match
+}
+
+// WalkFiles calls walkFn with the path of each file in the tree
+// rooted at root in vfs, skipping files and dirs that ig ignores. If
+// walkFn returns an error, the walk stops and the error is returned.
+func WalkFiles(vfs rwvfs.WalkableFileSystem, root string, ig *Ignorer, walkFn func(path string) error) error {
+	w := fs.WalkFS(root, vfs)
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			return err
+		}
+		fi := w.Stat()
+		if w.Path() != root && ig.Ignored(w.Path(), fi.IsDir()) {
+			if fi.IsDir() {
+				w.SkipDir()
+			}
+			continue
+		}
+		if fi.Mode().IsRegular() {
+			if err := walkFn(w.Path()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
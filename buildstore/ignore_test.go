@@ -0,0 +1,84 @@
+package buildstore
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+func TestIgnorer(t *testing.T) {
+	ig := NewIgnorer(`
+# comment
+*.log
+/build
+vendor/
+!vendor/keep
+gen/**
+**/testdata
+**/third_party/gen
+`)
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"a.log", false, true},
+		{"a/b/c.log", false, true},
+		{"a.go", false, false},
+		{"build", true, true},
+		{"a/build", true, false},
+		{"vendor", true, true},
+		{"vendor", false, false},
+		{"a/vendor", true, true},
+		{"vendor/keep", true, false},
+		{"gen", true, true},
+		{"a/b/testdata", true, true},
+		{"./build/", true, true},
+		{"third_party/gen", true, true},
+		{"a/third_party/gen", true, true},
+		{"a/gen", true, false},
+		{"a/xthird_party/gen", true, false},
+	}
+	for _, test := range tests {
+		if ignored := ig.Ignored(test.path, test.isDir); ignored != test.ignored {
+			t.Errorf("%q (dir: %v): got ignored %v, want %v", test.path, test.isDir, ignored, test.ignored)
+		}
+	}
+
+	if !ig.IgnoredTree("a/vendor/b/c.json", false) {
+		t.Error("got file in ignored dir not ignored")
+	}
+	if !ig.IgnoredTree("a/third_party/gen/b.go", false) {
+		t.Error("got file in dir ignored by a multi-segment **/ pattern not ignored")
+	}
+	if ig.IgnoredTree("a/b/c.json", false) {
+		t.Error("got file in non-ignored dir ignored")
+	}
+
+	var nilIg *Ignorer
+	if nilIg.Ignored("a.log", false) || nilIg.IgnoredTree("vendor/a", false) {
+		t.Error("got nil Ignorer ignoring paths")
+	}
+}
+
+func TestWalkFiles(t *testing.T) {
+	fs := rwvfs.Map(map[string]string{
+		"a.json":          "",
+		"b.log":           "",
+		"vendor/c.json":   "",
+		"d/e.json":        "",
+		"d/vendor/f.json": "",
+	})
+	var files []string
+	err := WalkFiles(rwvfs.Walkable(fs), ".", NewIgnorer("*.log\nvendor/"), func(path string) error {
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.json", "d/e.json"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("got files %v, want %v", files, want)
+	}
+}
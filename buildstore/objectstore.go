@@ -0,0 +1,459 @@
+package buildstore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/godoc/vfs"
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// OpenURL opens the object storage location at rawurl as a filesystem,
+// so that build data and stores can be kept in (and shared through)
+// object storage. The URL is of the form s3://BUCKET/PREFIX (Amazon S3)
+// or gs://BUCKET/PREFIX (Google Cloud Storage); files are stored as
+// objects whose keys begin with PREFIX.
+//
+// Credentials are read from the environment. For S3, they are
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN (if
+// any), and AWS_REGION (default us-east-1); AWS_ENDPOINT_URL selects an
+// S3-compatible service other than Amazon's. For Cloud Storage, they
+// are the HMAC key GCS_ACCESS_KEY_ID and GCS_SECRET_ACCESS_KEY (Cloud
+// Storage is accessed through its S3-compatible XML API). Without
+// credentials, requests are unauthenticated, which only works for
+// public buckets.
+func OpenURL(rawurl string) (rwvfs.FileSystem, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("object storage URL %q has no bucket (use s3://BUCKET/PREFIX or gs://BUCKET/PREFIX)", rawurl)
+	}
+	fs := &objectFS{
+		client: http.DefaultClient,
+		bucket: u.Host,
+		prefix: strings.Trim(path.Clean("/"+u.Path), "/"),
+	}
+
+	var keyID, secret string
+	switch u.Scheme {
+	case "s3":
+		keyID, secret = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+		fs.sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+		if fs.region = os.Getenv("AWS_REGION"); fs.region == "" {
+			fs.region = "us-east-1"
+		}
+		if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+			if fs.endpoint, err = url.Parse(endpoint); err != nil {
+				return nil, fmt.Errorf("AWS_ENDPOINT_URL: %s", err)
+			}
+			fs.pathStyle = true
+		} else {
+			fs.endpoint = &url.URL{Scheme: "https", Host: "s3." + fs.region + ".amazonaws.com"}
+		}
+	case "gs":
+		keyID, secret = os.Getenv("GCS_ACCESS_KEY_ID"), os.Getenv("GCS_SECRET_ACCESS_KEY")
+		fs.region = "auto"
+		fs.endpoint = &url.URL{Scheme: "https", Host: "storage.googleapis.com"}
+		fs.pathStyle = true
+	default:
+		return nil, fmt.Errorf("unsupported object storage URL scheme %q (use s3 or gs)", u.Scheme)
+	}
+	if keyID != "" && secret != "" {
+		fs.keyID, fs.secret = keyID, secret
+	}
+	return fs, nil
+}
+
+// objectFS is a filesystem whose files are the objects in a bucket
+// (under prefix) of an S3-compatible object storage service. Object
+// storage has no directories: a dir exists if any object's key begins
+// with the dir's path.
+type objectFS struct {
+	client    *http.Client
+	endpoint  *url.URL
+	pathStyle bool // the bucket is in the URL path, not the host name
+	bucket    string
+	prefix    string
+
+	region                      string
+	keyID, secret, sessionToken string // if keyID is empty, requests aren't signed
+}
+
+var (
+	_ rwvfs.FetcherOpener     = (*objectFS)(nil)
+	_ rwvfs.RangeOpener       = (*objectFS)(nil)
+	_ rwvfs.MkdirAllOverrider = (*objectFS)(nil)
+)
+
+func (fs *objectFS) String() string {
+	return fmt.Sprintf("objectstore(%s/%s/%s)", fs.endpoint.Host, fs.bucket, fs.prefix)
+}
+
+// key returns the key of the object for the file at name. The root
+// dir's key is the prefix.
+func (fs *objectFS) key(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if fs.prefix == "" || name == "" {
+		return fs.prefix + name
+	}
+	return fs.prefix + "/" + name
+}
+
+func (fs *objectFS) Open(name string) (vfs.ReadSeekCloser, error) {
+	return fs.OpenRange(name, "")
+}
+
+func (fs *objectFS) OpenFetcher(name string) (vfs.ReadSeekCloser, error) {
+	return rwvfs.OpenFetcher(fs, name)
+}
+
+func (fs *objectFS) OpenRange(name, rangeHeader string) (vfs.ReadSeekCloser, error) {
+	h := http.Header{}
+	if rangeHeader != "" {
+		h.Set("Range", rangeHeader)
+	}
+	resp, err := fs.do("GET", fs.key(name), nil, h, nil)
+	if resp != nil && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// The range begins at or after the end of the file.
+		return nopCloser{bytes.NewReader(nil)}, nil
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return nopCloser{bytes.NewReader(data)}, nil
+}
+
+type nopCloser struct{ io.ReadSeeker }
+
+func (nopCloser) Close() error { return nil }
+
+func (fs *objectFS) Lstat(name string) (os.FileInfo, error) { return fs.Stat(name) }
+
+func (fs *objectFS) Stat(name string) (os.FileInfo, error) {
+	key := fs.key(name)
+	if key == "" {
+		return objectInfo{name: ".", dir: true}, nil
+	}
+	resp, err := fs.do("HEAD", key, nil, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+		fi := objectInfo{name: path.Base(key), size: resp.ContentLength}
+		fi.modTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+		return fi, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	// It's a dir if there are objects under it.
+	list, err := fs.list(key+"/", "", 1)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if len(list.Contents) == 0 && len(list.CommonPrefixes) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return objectInfo{name: path.Base(key), dir: true}, nil
+}
+
+func (fs *objectFS) ReadDir(name string) ([]os.FileInfo, error) {
+	prefix := fs.key(name)
+	if prefix != "" {
+		prefix += "/"
+	}
+	var fis []os.FileInfo
+	var token string
+	for {
+		list, err := fs.list(prefix, token, 0)
+		if err != nil {
+			return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+		}
+		for _, o := range list.Contents {
+			if o.Key == prefix {
+				continue // a "dir" placeholder object
+			}
+			fis = append(fis, objectInfo{name: path.Base(o.Key), size: o.Size, modTime: o.LastModified})
+		}
+		for _, p := range list.CommonPrefixes {
+			fis = append(fis, objectInfo{name: path.Base(p.Prefix), dir: true})
+		}
+		if !list.IsTruncated || list.NextContinuationToken == "" {
+			break
+		}
+		token = list.NextContinuationToken
+	}
+	if len(fis) == 0 && prefix != "" {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+	sort.Sort(objectInfosByName(fis))
+	return fis, nil
+}
+
+// Create returns a writer that buffers the file's contents and uploads
+// them when it is closed.
+func (fs *objectFS) Create(name string) (io.WriteCloser, error) {
+	return &objectWriter{fs: fs, name: name}, nil
+}
+
+type objectWriter struct {
+	fs   *objectFS
+	name string
+	bytes.Buffer
+}
+
+func (w *objectWriter) Close() error {
+	resp, err := w.fs.do("PUT", w.fs.key(w.name), nil, nil, w.Bytes())
+	if err != nil {
+		return &os.PathError{Op: "create", Path: w.name, Err: err}
+	}
+	return resp.Body.Close()
+}
+
+// Mkdir does nothing, because dirs only exist implicitly in object
+// storage.
+func (fs *objectFS) Mkdir(name string) error { return nil }
+
+// MkdirAll implements rwvfs.MkdirAllOverrider.
+func (fs *objectFS) MkdirAll(name string) error { return nil }
+
+func (fs *objectFS) Remove(name string) error {
+	resp, err := fs.do("DELETE", fs.key(name), nil, nil, nil)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Dirs have no object to delete.
+			return nil
+		}
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return resp.Body.Close()
+}
+
+// listBucketResult is the response to a ListObjectsV2 request.
+type listBucketResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// list lists the objects and "dirs" (common prefixes up to the next
+// "/") whose keys begin with prefix. If max is nonzero, at most max
+// keys are returned.
+func (fs *objectFS) list(prefix, continuationToken string, max int) (*listBucketResult, error) {
+	q := url.Values{"list-type": {"2"}, "delimiter": {"/"}, "prefix": {prefix}}
+	if continuationToken != "" {
+		q.Set("continuation-token", continuationToken)
+	}
+	if max != 0 {
+		q.Set("max-keys", strconv.Itoa(max))
+	}
+	resp, err := fs.do("GET", "", q, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var list listBucketResult
+	if err := xml.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// do sends a request for the object with the given key (or, if key is
+// empty, for the bucket) and returns the response if it is successful.
+// If the object doesn't exist, the error satisfies os.IsNotExist.
+func (fs *objectFS) do(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	host := fs.endpoint.Host
+	uriPath := "/" + uriEncode(key, false)
+	if fs.pathStyle {
+		uriPath = "/" + uriEncode(fs.bucket, true) + uriPath
+	} else {
+		host = fs.bucket + "." + host
+	}
+	u := &url.URL{
+		Scheme:   fs.endpoint.Scheme,
+		Host:     host,
+		Opaque:   "//" + host + uriPath, // send the path exactly as signed
+		RawQuery: canonicalQuery(query),
+	}
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.URL = u
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if body != nil {
+		req.ContentLength = int64(len(body))
+	}
+	if fs.keyID != "" {
+		fs.sign(req, uriPath, body, time.Now().UTC())
+	}
+
+	resp, err := fs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return resp, os.ErrNotExist
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return resp, fmt.Errorf("%s %s: %s: %s", method, key, resp.Status, bytes.TrimSpace(msg))
+}
+
+// sign signs req (whose escaped path is uriPath) with AWS Signature
+// Version 4, which both S3 and Cloud Storage's XML API accept.
+func (fs *objectFS) sign(req *http.Request, uriPath string, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if fs.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", fs.sessionToken)
+	}
+
+	// Sign the Host header and the headers that affect what the
+	// request does.
+	headers := map[string]string{"host": req.URL.Host}
+	for k, vs := range req.Header {
+		if k := strings.ToLower(k); k == "range" || strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(vs, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, k := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriPath,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	date := amzDate[:8]
+	scope := date + "/" + fs.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+fs.secret), date)
+	for _, s := range []string{fs.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", fs.keyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode percent-encodes s as AWS Signature Version 4 requires:
+// every byte except unreserved characters (and "/", unless
+// encodeSlash) is encoded.
+func uriEncode(s string, encodeSlash bool) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+// canonicalQuery returns the query string for q, with its parameters
+// sorted and encoded as AWS Signature Version 4 requires.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// objectInfo implements os.FileInfo for objects and dirs in object
+// storage.
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi objectInfo) Name() string       { return fi.name }
+func (fi objectInfo) Size() int64        { return fi.size }
+func (fi objectInfo) ModTime() time.Time { return fi.modTime }
+func (fi objectInfo) IsDir() bool        { return fi.dir }
+func (fi objectInfo) Sys() interface{}   { return nil }
+func (fi objectInfo) Mode() os.FileMode {
+	if fi.dir {
+		return 0755 | os.ModeDir
+	}
+	return 0644
+}
+
+type objectInfosByName []os.FileInfo
+
+func (fis objectInfosByName) Len() int           { return len(fis) }
+func (fis objectInfosByName) Swap(i, j int)      { fis[i], fis[j] = fis[j], fis[i] }
+func (fis objectInfosByName) Less(i, j int) bool { return fis[i].Name() < fis[j].Name() }
@@ -1,8 +1,10 @@
 package buildstore
 
 import (
+	"io"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -153,6 +155,48 @@ func RemoveAll(path string, vfs rwvfs.WalkableFileSystem) error {
 	return dirsPar.Wait()
 }
 
+// CopyAll copies the files in src to dst and returns how many it
+// copied. Files are copied in parallel, because either filesystem may
+// be in object storage (see OpenURL).
+func CopyAll(dst rwvfs.FileSystem, src rwvfs.WalkableFileSystem) (int, error) {
+	var files []string
+	err := WalkFiles(src, ".", nil, func(p string) error {
+		files = append(files, path.Clean(p))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	par := parallel.NewRun(8)
+	for _, file := range files {
+		file := file
+		par.Do(func() error { return copyFile(dst, src, file) })
+	}
+	return len(files), par.Wait()
+}
+
+func copyFile(dst, src rwvfs.FileSystem, name string) error {
+	if dir := path.Dir(name); dir != "." {
+		if err := rwvfs.MkdirAll(dst, dir); err != nil {
+			return err
+		}
+	}
+	in, err := src.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := dst.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
 func BuildDataExistsForCommit(s RepoBuildStore, commitID string) (bool, error) {
 	cfs := s.Commit(commitID)
 	_, err := cfs.Stat(".")
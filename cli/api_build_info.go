@@ -0,0 +1,239 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+type APIBuildInfoCmd struct {
+	Rev         string `long:"rev" description:"revision to check (default: the working tree's commit; with --repo, an imported commit ID or prefix)" value-name:"REV"`
+	Repo        string `long:"repo" description:"check a repo (by clone URL or URI) in the global store instead of the local repo at DIR" value-name:"CLONEURL"`
+	MaxDistance int    `long:"max-distance" description:"max number of first-parent ancestors to search for the nearest built commit" default:"100"`
+
+	Args struct {
+		Dir Directory `name:"DIR" default:"." description:"root directory of target project"`
+	} `positional-args:"yes"`
+}
+
+var apiBuildInfoCmd APIBuildInfoCmd
+
+// commitBuildStatus describes where a commit's build data is
+// available.
+type commitBuildStatus struct {
+	CommitID string
+
+	BuildData         bool `json:",omitempty"` // build data in the repo's .srclib-cache
+	BuildDataComplete bool `json:",omitempty"` // all of the commit's source units have been analyzed
+	LocalStore        bool `json:",omitempty"` // imported into the repo's .srclib-store
+	GlobalStore       bool `json:",omitempty"` // imported into the global store (SRCLIBSTORE)
+}
+
+// available reports whether the commit's data can be queried without
+// building anything.
+func (s *commitBuildStatus) available() bool {
+	return s.BuildDataComplete || s.LocalStore || s.GlobalStore
+}
+
+// buildInfo is the output of the build-info command.
+type buildInfo struct {
+	Repo string // repo URI
+	Rev  string `json:",omitempty"`
+
+	commitBuildStatus
+
+	// Nearest is the nearest first-parent ancestor of the commit
+	// whose data is available, if the commit's isn't.
+	Nearest *nearestBuild `json:",omitempty"`
+}
+
+type nearestBuild struct {
+	commitBuildStatus
+	Distance int // number of commits between it and the commit
+}
+
+// repoBuildInfo returns where the data of repo at commitID is available
+// and, if it isn't, the nearest first-parent ancestor (at most
+// maxDistance commits away) whose data is.
+func repoBuildInfo(repo *Repo, commitID string, maxDistance int) (*buildInfo, error) {
+	bs, err := buildstore.LocalRepo(repo.RootDir)
+	if err != nil {
+		return nil, err
+	}
+	local, err := importedCommits(filepath.Join(repo.RootDir, store.SrclibStoreDir), "")
+	if err != nil {
+		return nil, err
+	}
+	var global map[string]bool
+	if uri := repo.URI(); uri != "" {
+		if global, err = importedCommits(srclib.StoreDir, uri); err != nil {
+			return nil, err
+		}
+	}
+	status := func(commitID string) (commitBuildStatus, error) {
+		s := commitBuildStatus{CommitID: commitID, LocalStore: local[commitID], GlobalStore: global[commitID]}
+		units, built, err := buildProgress(bs, commitID)
+		if err != nil {
+			return s, err
+		}
+		if s.BuildData, err = buildstore.BuildDataExistsForCommit(bs, commitID); err != nil {
+			return s, err
+		}
+		s.BuildDataComplete = units > 0 && built == units
+		return s, nil
+	}
+
+	info := &buildInfo{Repo: repo.URI()}
+	if info.commitBuildStatus, err = status(commitID); err != nil {
+		return nil, err
+	}
+	if info.available() || maxDistance <= 0 {
+		return info, nil
+	}
+	ancestors, err := repo.firstParentAncestors(commitID, maxDistance)
+	if err != nil && len(ancestors) == 0 {
+		return nil, err
+	} else if err != nil {
+		// The repo is a shallow clone whose history couldn't be
+		// deepened, so search the ancestors that are available.
+		log.Printf("Warning: %s\n\nSearching only the %d ancestors in the shallow clone.", err, len(ancestors))
+	}
+	for i, c := range ancestors {
+		s, err := status(c)
+		if err != nil {
+			return nil, err
+		}
+		if s.available() {
+			info.Nearest = &nearestBuild{commitBuildStatus: s, Distance: i + 1}
+			break
+		}
+	}
+	return info, nil
+}
+
+// importedCommits returns the set of commits imported into the store
+// at root (of repoURI, if root is a MultiRepoStore, or of the repo
+// whose store it is, if repoURI is empty).
+func importedCommits(root, repoURI string) (map[string]bool, error) {
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	fs := rwvfs.Walkable(rwvfs.ReadOnly(rwvfs.OS(root)))
+	var versions []*store.Version
+	var err error
+	if repoURI == "" {
+		versions, err = store.NewFSRepoStore(fs).Versions()
+	} else {
+		versions, err = store.NewFSMultiRepoStore(fs, nil).Versions(store.ByRepos(repoURI))
+	}
+	if err != nil {
+		return nil, err
+	}
+	commits := make(map[string]bool, len(versions))
+	for _, v := range versions {
+		commits[v.CommitID] = true
+	}
+	return commits, nil
+}
+
+// firstParentAncestors returns up to n of commitID's first-parent
+// ancestors, nearest first (excluding commitID itself).
+func firstParentAncestors(vcsType, dir, commitID string, n int) ([]string, error) {
+	var cmd *exec.Cmd
+	switch vcsType {
+	case "git":
+		cmd = exec.Command("git", "rev-list", "--first-parent", "--max-count="+strconv.Itoa(n+1), commitID)
+	case "hg":
+		cmd = exec.Command("hg", "--config", "trusted.users=root", "log", "--template", "{node}\\n", "-r", fmt.Sprintf("limit(reverse(_firstancestors(%s)), %d)", commitID, n+1))
+	default:
+		return nil, fmt.Errorf("unknown vcs type: %q", vcsType)
+	}
+	cmd.Dir = dir
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing ancestors of %s failed: %s", commitID, err)
+	}
+	commits := strings.Fields(string(bytes.TrimSpace(out)))
+	if len(commits) > 0 && commits[0] == commitID {
+		commits = commits[1:]
+	}
+	return commits, nil
+}
+
+// globalBuildInfo returns whether the commit of repoURI that rev (a
+// commit ID or unambiguous prefix) refers to was imported into the
+// global store. The repo's history isn't available, so no nearest
+// commit is found.
+func globalBuildInfo(repoURI, rev string) (*buildInfo, error) {
+	commits, err := importedCommits(srclib.StoreDir, repoURI)
+	if err != nil {
+		return nil, err
+	}
+	info := &buildInfo{Repo: repoURI, Rev: rev}
+	info.CommitID = rev
+	var matches []string
+	for c := range commits {
+		if strings.HasPrefix(c, rev) {
+			matches = append(matches, c)
+		}
+	}
+	switch len(matches) {
+	case 0:
+	case 1:
+		info.CommitID, info.GlobalStore = matches[0], true
+	default:
+		return nil, fmt.Errorf("%q matches %d imported commits of %s; use a longer commit ID prefix", rev, len(matches), repoURI)
+	}
+	return info, nil
+}
+
+func (c *APIBuildInfoCmd) Execute(args []string) error {
+	if c.Repo != "" {
+		if c.Rev == "" {
+			return errors.New("--repo requires --rev (a commit ID or prefix)")
+		}
+		uri, err := graph.TryMakeURI(c.Repo)
+		if err != nil {
+			return err
+		}
+		info, err := globalBuildInfo(uri, c.Rev)
+		if err != nil {
+			return err
+		}
+		PrintJSON(info, "  ")
+		return nil
+	}
+
+	repo, err := OpenRepo(c.Args.Dir.String())
+	if err != nil {
+		return err
+	}
+	commitID := repo.CommitID
+	if c.Rev != "" {
+		if commitID, err = repo.resolveRevision(c.Rev); err != nil {
+			return err
+		}
+	}
+	info, err := repoBuildInfo(repo, commitID, c.MaxDistance)
+	if err != nil {
+		return err
+	}
+	info.Rev = c.Rev
+	PrintJSON(info, "  ")
+	return nil
+}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+)
+
+func TestRepoBuildInfo(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "srclib-build-info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	var commits []string
+	for i := 0; i < 3; i++ {
+		git("-c", "user.name=a", "-c", "user.email=a@example.com", "commit", "-q", "--allow-empty", "-m", "c")
+		commits = append(commits, git("rev-parse", "HEAD"))
+	}
+
+	// Only the first commit has (complete) build data.
+	commitDir := filepath.Join(dir, buildstore.BuildDataDirName, commits[0], "u")
+	if err := os.MkdirAll(commitDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for file, data := range map[string]string{"t.unit.json": `{"Name":"u","Type":"t"}`, "t.graph.json": "{}"} {
+		if err := ioutil.WriteFile(filepath.Join(commitDir, file), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo, err := OpenRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := repoBuildInfo(repo, commits[2], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CommitID != commits[2] || info.available() {
+		t.Errorf("got %+v, want commit %s to be unavailable", info.commitBuildStatus, commits[2])
+	}
+	if n := info.Nearest; n == nil || n.CommitID != commits[0] || n.Distance != 2 || !n.BuildDataComplete {
+		t.Errorf("got nearest %+v, want commit %s at distance 2", n, commits[0])
+	}
+
+	if info, err := repoBuildInfo(repo, commits[2], 1); err != nil || info.Nearest != nil {
+		t.Errorf("got nearest %+v (error %v) beyond the max distance, want none", info.Nearest, err)
+	}
+	if info, err := repoBuildInfo(repo, commits[0], 10); err != nil || !info.available() || info.Nearest != nil {
+		t.Errorf("got %+v (error %v), want commit %s to be available", info, err, commits[0])
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -42,7 +43,7 @@ func init() {
 		END APIDescribeCmdDoc OMIT */
 	_, err = c.AddCommand("describe",
 		"display documentation for the def under the cursor",
-		"Returns information about the definition referred to by the cursor's current position in a file.",
+		"Returns information about the definition referred to by the cursor's current position in a file. With --batch, many positions (or def keys) are read from stdin and described in one run.",
 		&apiDescribeCmd,
 	)
 	if err != nil {
@@ -88,6 +89,20 @@ func init() {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	/* START APIBuildInfoCmdDoc OMIT
+	This command reports whether a commit's data is available (as
+	build data, or imported into the local or global store) and, if it
+	isn't, the nearest ancestor commit whose data is.
+		END APIBuildInfoCmdDoc OMIT */
+	_, err = c.AddCommand("build-info",
+		"show whether a commit has been built",
+		"Return whether the commit of the repository at DIR (the working tree's commit, or --rev) has build data and has been imported into the repository's local store or the global store. If not, it also returns the nearest first-parent ancestor commit whose data is available. With --repo, only the global store is checked.",
+		&apiBuildInfoCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 type APICmd struct{}
@@ -97,8 +112,12 @@ var apiCmd APICmd
 func (c *APICmd) Execute(args []string) error { return nil }
 
 type APIDescribeCmd struct {
-	File      string `long:"file" required:"yes" value-name:"FILE"`
-	StartByte uint32 `long:"start-byte" required:"yes" value-name:"BYTE"`
+	File      string `long:"file" value-name:"FILE"`
+	StartByte uint32 `long:"start-byte" value-name:"BYTE"`
+
+	Batch bool `long:"batch" description:"read positions ({\"File\":..., \"StartByte\":...}) or def keys ({\"Def\":{...}}) as newline-delimited JSON from stdin and print a JSON response line for each"`
+
+	Paths string `long:"paths" description:"how to print the def's file path: absolute, repo-relative, or unit-relative" default:"absolute" value-name:"BASE"`
 }
 
 type APIListCmd struct {
@@ -106,6 +125,8 @@ type APIListCmd struct {
 	NoRefs bool   `long:"no-refs"`
 	NoDefs bool   `long:"no-defs"`
 	NoDocs bool   `long:"no-docs"`
+
+	PathsOpt
 }
 
 type APIDepsCmd struct {
@@ -230,6 +251,12 @@ func ensureBuild(buildStore buildstore.RepoBuildStore, repo *Repo) error {
 	}
 
 	// Always re-import.
+	return importBuildData(repo)
+}
+
+// importBuildData imports the build data of the repo's current commit
+// into the store.
+func importBuildData(repo *Repo) error {
 	i := &StoreImportCmd{
 		ImportOpt: ImportOpt{
 			Repo:     repo.CloneURL,
@@ -237,10 +264,7 @@ func ensureBuild(buildStore buildstore.RepoBuildStore, repo *Repo) error {
 		},
 		Quiet: true,
 	}
-	if err := i.Execute(nil); err != nil {
-		return err
-	}
-	return nil
+	return i.Execute(nil)
 }
 
 func getSourceUnits(commitFS rwvfs.WalkableFileSystem, repo *Repo) []string {
@@ -367,6 +391,22 @@ func (c *APIListCmd) Execute(args []string) error {
 
 	}
 
+	paths, err := newPathDisplay(c.Paths, context.repo.RootDir, func() ([]*unit.SourceUnit, error) { return units, nil })
+	if err != nil {
+		return err
+	}
+	if paths != nil {
+		for _, def := range output.Defs {
+			def.File = paths.def(def)
+		}
+		for _, ref := range output.Refs {
+			ref.File = paths.ref(ref)
+		}
+		for _, doc := range output.Docs {
+			doc.File = paths.path(doc.UnitType, doc.Unit, doc.File)
+		}
+	}
+
 	if err := json.NewEncoder(os.Stdout).Encode(output); err != nil {
 		return err
 	}
@@ -396,72 +436,32 @@ type apiDescribeCmdOutput struct {
 // END APIDescribeCmdOutputQuickHack OMIT
 
 func (c *APIDescribeCmd) Execute(args []string) error {
+	if c.Batch {
+		if c.File != "" || c.StartByte != 0 {
+			return errors.New("--file and --start-byte can't be used with --batch (positions are read from stdin)")
+		}
+		return c.executeBatch()
+	}
+	if c.File == "" {
+		return errors.New("--file is required (unless --batch is given)")
+	}
+
 	context, err := prepareCommandContext(c.File)
 	if err != nil {
 		return err
 	}
-	file := context.relativeFile
-	units, err := getSourceUnitsWithFile(context.buildStore, context.repo, file)
-	if err != nil {
+	d := newAPIDescriber(context)
+	if err := d.setPaths(c.Paths); err != nil {
 		return err
 	}
+	file := context.relativeFile
 
-	if GlobalOpt.Verbose {
-		if len(units) > 0 {
-			ids := make([]string, len(units))
-			for i, u := range units {
-				ids[i] = string(u.ID())
-			}
-			log.Printf("Position %s:%d is in %d source units %v.", file, c.StartByte, len(units), ids)
-		} else {
-			log.Printf("Position %s:%d is not in any source units.", file, c.StartByte)
-		}
-	}
-
-	// Find the ref(s) at the character position.
-	var ref *graph.Ref
-	var nearbyRefs []*graph.Ref // Find nearby refs to help with debugging.
-OuterLoop:
-	for _, u := range units {
-		var g graph.Output
-		graphFile := plan.SourceUnitDataFilename("graph", u)
-		f, err := context.commitFS.Open(graphFile)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		if err := json.NewDecoder(f).Decode(&g); err != nil {
-			return fmt.Errorf("%s: %s", graphFile, err)
-		}
-		for _, ref2 := range g.Refs {
-			if file == ref2.File {
-				if c.StartByte >= ref2.Start && c.StartByte <= ref2.End {
-					ref = ref2
-					if ref.DefUnit == "" {
-						ref.DefUnit = u.Name
-					}
-					if ref.DefUnitType == "" {
-						ref.DefUnitType = u.Type
-					}
-					break OuterLoop
-				} else if GlobalOpt.Verbose && abs(int(ref2.Start)-int(c.StartByte)) < 25 {
-					nearbyRefs = append(nearbyRefs, ref2)
-				}
-			}
-		}
+	ref, err := d.refAt(file, c.StartByte)
+	if err != nil {
+		return err
 	}
-
 	if ref == nil {
 		if GlobalOpt.Verbose {
-			log.Printf("No ref found at %s:%d.", file, c.StartByte)
-
-			if len(nearbyRefs) > 0 {
-				log.Printf("However, nearby refs were found in the same file:")
-				for _, nref := range nearbyRefs {
-					log.Printf("Ref at bytes %d-%d to %v", nref.Start, nref.End, nref.DefKey())
-				}
-			}
-
 			f, err := os.Open(file)
 			if err == nil {
 				defer f.Close()
@@ -486,50 +486,254 @@ OuterLoop:
 		return nil
 	}
 
-	// ref.DefRepo is *not* guaranteed to be non-empty, as
-	// repo.URI() will return the empty string if the repo's
-	// CloneURL is empty or malformed.
-	if ref.DefRepo == "" {
-		ref.DefRepo = context.repo.URI()
+	var resp apiDescribeCmdOutput
+	resp.Def, err = d.def(ref.DefKey())
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(resp); err != nil {
+		return err
+	}
+	return nil
+}
+
+// apiDescribeBatchInput is a single request (one line of NDJSON) read
+// by `src api describe --batch`. Either File and StartByte (the
+// position of a ref) or Def (the key of a def in the current repo)
+// must be set.
+type apiDescribeBatchInput struct {
+	File      string `json:",omitempty"`
+	StartByte uint32 `json:",omitempty"`
+
+	Def *graph.DefKey `json:",omitempty"`
+}
+
+// apiDescribeBatchOutput is the response to an apiDescribeBatchInput.
+// Def is nil if no ref or def was found. If the request failed, Error
+// holds the error message.
+type apiDescribeBatchOutput struct {
+	apiDescribeCmdOutput
+	Error string `json:",omitempty"`
+}
+
+// executeBatch reads NDJSON apiDescribeBatchInput requests from stdin
+// and writes a line of apiDescribeBatchOutput JSON to stdout for each
+// (in the same order). The repository is built (if needed) and each
+// source unit's graph data is read only once, so describing many
+// positions is much faster than running the command once per
+// position.
+func (c *APIDescribeCmd) executeBatch() error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	context, err := prepareCommandContext(".")
+	if err != nil {
+		return err
+	}
+	d := newAPIDescriber(context)
+	if err := d.setPaths(c.Paths); err != nil {
+		return err
 	}
 
-	var resp apiDescribeCmdOutput
-	// Now find the def for this ref.
+	dec := json.NewDecoder(os.Stdin)
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		var in apiDescribeBatchInput
+		if err := dec.Decode(&in); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading batch input: %s", err)
+		}
 
-	defInCurrentRepo := ref.DefRepo == context.repo.URI()
-	if defInCurrentRepo {
-		// Def is in the current repo.
-		var g graph.Output
-		graphFile := plan.SourceUnitDataFilename("graph", &unit.SourceUnit{Name: ref.DefUnit, Type: ref.DefUnitType})
-		f, err := context.commitFS.Open(graphFile)
-		if err != nil {
+		var out apiDescribeBatchOutput
+		if def, err := d.describeBatchInput(wd, in); err != nil {
+			out.Error = err.Error()
+		} else {
+			out.Def = def
+		}
+		if err := enc.Encode(out); err != nil {
 			return err
 		}
-		defer f.Close()
-		if err := json.NewDecoder(f).Decode(&g); err != nil {
-			return fmt.Errorf("%s: %s", graphFile, err)
+	}
+}
+
+// describeBatchInput returns the def described by in. File paths in
+// in are relative to wd (if not absolute).
+func (d *apiDescriber) describeBatchInput(wd string, in apiDescribeBatchInput) (*graph.Def, error) {
+	switch {
+	case in.Def != nil && in.File != "":
+		return nil, errors.New("only one of File (and StartByte) or Def may be set")
+	case in.Def != nil:
+		return d.def(*in.Def)
+	case in.File != "":
+		file := in.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(wd, file)
 		}
-		for _, def := range g.Defs {
-			if def.Path == ref.DefPath {
-				resp.Def = def
-				break
+		file, err := filepath.Rel(d.context.repo.RootDir, file)
+		if err != nil {
+			return nil, err
+		}
+		ref, err := d.refAt(file, in.StartByte)
+		if err != nil || ref == nil {
+			return nil, err
+		}
+		return d.def(ref.DefKey())
+	}
+	return nil, errors.New("either File (and StartByte) or Def must be set")
+}
+
+// apiDescriber looks up refs and defs in the build data of a
+// commandContext's commit. It caches the source units and graph data
+// it reads.
+type apiDescriber struct {
+	context commandContext
+
+	units  map[string][]*unit.SourceUnit // source units by file
+	graphs map[string]*graph.Output      // graph data by graph data file
+
+	paths *pathDisplay // how to print def files (absolute by default)
+}
+
+func newAPIDescriber(context commandContext) *apiDescriber {
+	return &apiDescriber{
+		context: context,
+		units:   map[string][]*unit.SourceUnit{},
+		graphs:  map[string]*graph.Output{},
+		paths:   &pathDisplay{base: "absolute", root: context.repo.RootDir},
+	}
+}
+
+// graph returns the graph data of the source unit u.
+func (d *apiDescriber) graph(u *unit.SourceUnit) (*graph.Output, error) {
+	graphFile := plan.SourceUnitDataFilename("graph", u)
+	if g, ok := d.graphs[graphFile]; ok {
+		return g, nil
+	}
+	f, err := d.context.commitFS.Open(graphFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var g graph.Output
+	if err := json.NewDecoder(f).Decode(&g); err != nil {
+		return nil, fmt.Errorf("%s: %s", graphFile, err)
+	}
+	d.graphs[graphFile] = &g
+	return &g, nil
+}
+
+// refAt returns the ref at the byte offset startByte in file (relative
+// to the repository root), or nil if there is none.
+func (d *apiDescriber) refAt(file string, startByte uint32) (*graph.Ref, error) {
+	file = filepath.Clean(file)
+	units, ok := d.units[file]
+	if !ok {
+		var err error
+		units, err = getSourceUnitsWithFile(d.context.buildStore, d.context.repo, file)
+		if err != nil {
+			return nil, err
+		}
+		d.units[file] = units
+	}
+
+	if GlobalOpt.Verbose {
+		if len(units) > 0 {
+			ids := make([]string, len(units))
+			for i, u := range units {
+				ids[i] = string(u.ID())
 			}
+			log.Printf("Position %s:%d is in %d source units %v.", file, startByte, len(units), ids)
+		} else {
+			log.Printf("Position %s:%d is not in any source units.", file, startByte)
+		}
+	}
+
+	// Find the ref(s) at the character position.
+	var nearbyRefs []*graph.Ref // Find nearby refs to help with debugging.
+	for _, u := range units {
+		g, err := d.graph(u)
+		if err != nil {
+			return nil, err
 		}
-		if resp.Def != nil {
-			// If Def is in the current Repo, transform that path to be an absolute path
-			resp.Def.File = filepath.ToSlash(filepath.Join(context.repo.RootDir, resp.Def.File))
+		for _, ref2 := range g.Refs {
+			if file == ref2.File {
+				if startByte >= ref2.Start && startByte <= ref2.End {
+					ref := *ref2
+					if ref.DefUnit == "" {
+						ref.DefUnit = u.Name
+					}
+					if ref.DefUnitType == "" {
+						ref.DefUnitType = u.Type
+					}
+					// ref.DefRepo is *not* guaranteed to be
+					// non-empty, as repo.URI() will return the
+					// empty string if the repo's CloneURL is
+					// empty or malformed.
+					if ref.DefRepo == "" {
+						ref.DefRepo = d.context.repo.URI()
+					}
+					return &ref, nil
+				} else if GlobalOpt.Verbose && abs(int(ref2.Start)-int(startByte)) < 25 {
+					nearbyRefs = append(nearbyRefs, ref2)
+				}
+			}
 		}
-		if resp.Def == nil && GlobalOpt.Verbose {
-			log.Printf("No definition found with path %q in unit %q type %q.", ref.DefPath, ref.DefUnit, ref.DefUnitType)
+	}
+
+	if GlobalOpt.Verbose {
+		log.Printf("No ref found at %s:%d.", file, startByte)
+		if len(nearbyRefs) > 0 {
+			log.Printf("However, nearby refs were found in the same file:")
+			for _, nref := range nearbyRefs {
+				log.Printf("Ref at bytes %d-%d to %v", nref.Start, nref.End, nref.DefKey())
+			}
 		}
 	}
+	return nil, nil
+}
 
-	if err := json.NewEncoder(os.Stdout).Encode(resp); err != nil {
+// def returns the def with the given key, or nil if it isn't found.
+// Only defs in the current repository can be found.
+// setPaths sets how def files are printed to the form given by
+// --paths=base.
+func (d *apiDescriber) setPaths(base string) error {
+	p, err := newPathDisplay(base, d.context.repo.RootDir, commitUnits(d.context.commitFS))
+	if err != nil {
 		return err
 	}
+	d.paths = p
 	return nil
 }
 
+func (d *apiDescriber) def(key graph.DefKey) (*graph.Def, error) {
+	repoURI := d.context.repo.URI()
+	if key.Repo != "" && key.Repo != repoURI {
+		return nil, nil
+	}
+	g, err := d.graph(&unit.SourceUnit{Name: key.Unit, Type: key.UnitType})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for _, def := range g.Defs {
+		if def.Path == key.Path {
+			// If Def is in the current Repo, transform that path to
+			// the form given by --paths (absolute by default).
+			def := *def
+			def.File = d.paths.def(&def)
+			return &def, nil
+		}
+	}
+	if GlobalOpt.Verbose {
+		log.Printf("No definition found with path %q in unit %q type %q.", key.Path, key.Unit, key.UnitType)
+	}
+	return nil, nil
+}
+
 func abs(n int) int {
 	if n < 0 {
 		return -1 * n
@@ -552,43 +756,72 @@ func (c *APIDepsCmd) Execute(args []string) error {
 		return err
 	}
 
+	depSlice, foundDepresolve, err := getDepResolutions(context)
+	if err != nil {
+		return err
+	}
+
+	if foundDepresolve == false {
+		return fmt.Errorf("No dependency information found. Try running `%s config` first.", srclib.CommandName)
+	}
+
+	return json.NewEncoder(os.Stdout).Encode(depSlice)
+}
+
+// getDepResolutions returns the distinct dep resolutions in the build
+// data of context's commit. The returned bool is false if there were
+// no dep resolution files at all (e.g., because the repo hasn't been
+// configured).
+func getDepResolutions(context commandContext) ([]*dep.Resolution, bool, error) {
 	var depSlice []*dep.Resolution
 	// TODO: Make DataTypeSuffix work with type of depSlice
 	depSuffix := buildstore.DataTypeSuffix([]*dep.ResolvedDep{})
 	depCache := make(map[string]struct{})
 	foundDepresolve := false
-	w := fs.WalkFS(".", context.commitFS)
-	for w.Step() {
-		depfile := w.Path()
-		if strings.HasSuffix(depfile, depSuffix) {
-			foundDepresolve = true
-			var deps []*dep.Resolution
-			f, err := context.commitFS.Open(depfile)
+	// Skip build data for vendored and generated dirs that the repo
+	// ignores.
+	ignorer, err := buildstore.ReadIgnoreFiles(context.repo.RootDir)
+	if err != nil {
+		return nil, false, err
+	}
+	err = buildstore.WalkFiles(context.commitFS, ".", ignorer, func(depfile string) error {
+		if !strings.HasSuffix(depfile, depSuffix) {
+			return nil
+		}
+		foundDepresolve = true
+		var deps []*dep.Resolution
+		f, err := context.commitFS.Open(depfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := json.NewDecoder(f).Decode(&deps); err != nil {
+			return fmt.Errorf("%s: %s", depfile, err)
+		}
+		for _, d := range deps {
+			key, err := d.RawKeyId()
 			if err != nil {
 				return err
 			}
-			defer f.Close()
-			if err := json.NewDecoder(f).Decode(&deps); err != nil {
-				return fmt.Errorf("%s: %s", depfile, err)
-			}
-			for _, d := range deps {
-				key, err := d.RawKeyId()
-				if err != nil {
-					return err
-				}
-				if _, ok := depCache[key]; !ok {
-					depCache[key] = struct{}{}
-					depSlice = append(depSlice, d)
-				}
+			if _, ok := depCache[key]; !ok {
+				depCache[key] = struct{}{}
+				depSlice = append(depSlice, d)
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
 	}
 
-	if foundDepresolve == false {
-		return fmt.Errorf("No dependency information found. Try running `%s config` first.", srclib.CommandName)
+	// Apply the Srcfile's dep overrides, in case they were added
+	// after the deps were resolved.
+	cfg, err := config.ReadRepository(context.repo.RootDir, context.repo.URI())
+	if err != nil {
+		return nil, false, err
 	}
-
-	return json.NewEncoder(os.Stdout).Encode(depSlice)
+	dep.ApplyOverrides(depSlice, cfg.DepOverrides)
+	return depSlice, foundDepresolve, nil
 }
 
 /* START APIUnitsCmdOutput OMIT
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"encoding/json"
+	"path"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/plan"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestAPIDescriber(t *testing.T) {
+	u := &unit.SourceUnit{Name: "u", Type: "t", Files: []string{"a.go"}}
+	g := &graph.Output{
+		Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "F"}, Name: "F", File: "a.go"}},
+		Refs: []*graph.Ref{{DefPath: "F", File: "a.go", Start: 10, End: 11}},
+	}
+	files := map[string]string{}
+	for _, f := range []struct{ data, v interface{} }{{unit.SourceUnit{}, u}, {"graph", g}} {
+		b, err := json.Marshal(f.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[path.Join("c", plan.SourceUnitDataFilename(f.data, u))] = string(b)
+	}
+	bs := buildstore.Repo(rwvfs.Walkable(rwvfs.Map(files)))
+	repo := &Repo{RootDir: "/r", CommitID: "c", CloneURL: "https://example.com/r"}
+	d := newAPIDescriber(commandContext{repo: repo, buildStore: bs, commitFS: bs.Commit("c")})
+
+	tests := []struct {
+		in       apiDescribeBatchInput
+		wantFile string
+		wantErr  bool
+	}{
+		{in: apiDescribeBatchInput{File: "a.go", StartByte: 10}, wantFile: "/r/a.go"},
+		{in: apiDescribeBatchInput{File: "/r/a.go", StartByte: 11}, wantFile: "/r/a.go"},
+		{in: apiDescribeBatchInput{File: "a.go", StartByte: 3}},
+		{in: apiDescribeBatchInput{Def: &graph.DefKey{UnitType: "t", Unit: "u", Path: "F"}}, wantFile: "/r/a.go"},
+		{in: apiDescribeBatchInput{Def: &graph.DefKey{Repo: "example.com/other", UnitType: "t", Unit: "u", Path: "F"}}},
+		{in: apiDescribeBatchInput{Def: &graph.DefKey{UnitType: "t", Unit: "u", Path: "G"}}},
+		{in: apiDescribeBatchInput{}, wantErr: true},
+		{in: apiDescribeBatchInput{File: "a.go", Def: &graph.DefKey{}}, wantErr: true},
+	}
+	for _, test := range tests {
+		def, err := d.describeBatchInput("/r", test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%+v: got error %v, want error %v", test.in, err, test.wantErr)
+			continue
+		}
+		var file string
+		if def != nil {
+			file = def.File
+		}
+		if file != test.wantFile {
+			t.Errorf("%+v: got def in file %q, want %q", test.in, file, test.wantFile)
+		}
+	}
+
+	// The def's file path is made absolute in a copy, not in the
+	// cached graph data (which would make it absolute again).
+	def, err := d.def(graph.DefKey{UnitType: "t", Unit: "u", Path: "F"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/r/a.go"; def.File != want {
+		t.Errorf("got def in file %q, want %q", def.File, want)
+	}
+}
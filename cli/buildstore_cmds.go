@@ -0,0 +1,333 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+)
+
+func init() {
+	c, err := CLI.AddCommand("buildstore",
+		"build data commands",
+		"The buildstore commands list and print the build data files (produced by `src make`) for a commit of the current repository. Use them instead of reading files under "+buildstore.BuildDataDirName+" directly, since the layout of that directory may change between versions.",
+		&buildstoreCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("ls",
+		"list build data files",
+		"The ls command lists the build data files for the commit. If PATTERNs are given, only files whose path or base name matches any of the PATTERNs (as a glob) are listed.",
+		&buildstoreLsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("cat",
+		"print a build data file",
+		"The cat command prints a build data file for the commit. FILE is the file's path (as listed by ls), a suffix of its path (such as its base name), or a glob that matches it; if more than one file matches, the matches are listed and nothing is printed. JSON files are pretty-printed unless --raw is given.",
+		&buildstoreCatCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("push",
+		"upload build data to object storage",
+		"The push command uploads the build data files for the commit to object storage at URL (s3://BUCKET/PREFIX or gs://BUCKET/PREFIX), under the repository's URI and the commit ID, so that other machines (such as CI workers) can pull them instead of rebuilding. Credentials are read from the environment: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN, and AWS_REGION for S3 (and AWS_ENDPOINT_URL for other S3-compatible services), or GCS_ACCESS_KEY_ID and GCS_SECRET_ACCESS_KEY (an HMAC key) for Cloud Storage.",
+		&buildstorePushCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("pull",
+		"download build data from object storage",
+		"The pull command downloads the build data files for the commit that were uploaded to URL with `src buildstore push`, replacing the commit's local build data (if any).",
+		&buildstorePullCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type BuildstoreCmd struct {
+	CommitID CommitID `long:"commit" description:"commit ID of the build data (default: the current commit)" value-name:"COMMIT"`
+}
+
+var buildstoreCmd BuildstoreCmd
+
+func (c *BuildstoreCmd) Execute(args []string) error { return nil }
+
+// localStore returns the current repository, its local build store,
+// and the commit ID of the build data.
+func (c *BuildstoreCmd) localStore() (*Repo, buildstore.RepoBuildStore, string, error) {
+	repo, err := OpenRepo(".")
+	if err != nil {
+		return nil, nil, "", err
+	}
+	bs, err := buildstore.LocalRepo(repo.RootDir)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	commitID := string(c.CommitID)
+	if commitID == "" {
+		commitID = repo.CommitID
+	}
+	return repo, bs, commitID, nil
+}
+
+// commitFS returns the build data filesystem for the commit.
+func (c *BuildstoreCmd) commitFS() (rwvfs.WalkableFileSystem, error) {
+	_, bs, commitID, err := c.localStore()
+	if err != nil {
+		return nil, err
+	}
+	exists, err := buildstore.BuildDataExistsForCommit(bs, commitID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("no build data for commit %s (run `src make` first)", commitID)
+	}
+	return bs.Commit(commitID), nil
+}
+
+// buildDataFiles returns the sorted paths of all build data files in
+// fs.
+func buildDataFiles(fs rwvfs.WalkableFileSystem) ([]string, error) {
+	var files []string
+	err := buildstore.WalkFiles(fs, ".", nil, func(p string) error {
+		files = append(files, path.Clean(p))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// matchBuildDataFile returns whether the build data file path p
+// matches the glob pattern, either in full or by its base name.
+func matchBuildDataFile(pattern, p string) bool {
+	if m, _ := path.Match(pattern, p); m {
+		return true
+	}
+	m, _ := path.Match(pattern, path.Base(p))
+	return m
+}
+
+// locateBuildDataFile returns the path in files of the build data
+// file named by name (see the cat command's description).
+func locateBuildDataFile(files []string, name string) (string, error) {
+	name = path.Clean(name)
+	var matches []string
+	for _, f := range files {
+		if f == name {
+			return f, nil
+		}
+		if strings.HasSuffix(f, "/"+name) || matchBuildDataFile(name, f) {
+			matches = append(matches, f)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no build data file matches %q (use `src buildstore ls` to list them)", name)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("%d build data files match %q:\n\t%s", len(matches), name, strings.Join(matches, "\n\t"))
+}
+
+type BuildstoreLsCmd struct {
+	Long bool `short:"l" long:"long" description:"also show each file's size and data type"`
+
+	Args struct {
+		Patterns []string `name:"PATTERN"`
+	} `positional-args:"yes"`
+}
+
+var buildstoreLsCmd BuildstoreLsCmd
+
+func (c *BuildstoreLsCmd) Execute(args []string) error {
+	fs, err := buildstoreCmd.commitFS()
+	if err != nil {
+		return err
+	}
+	files, err := buildDataFiles(fs)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if len(c.Args.Patterns) > 0 {
+			matched := false
+			for _, pattern := range c.Args.Patterns {
+				if matchBuildDataFile(pattern, f) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+		}
+		if !c.Long {
+			colorable.Println(f)
+			continue
+		}
+		fi, err := fs.Stat(f)
+		if err != nil {
+			return err
+		}
+		dataType, _ := buildstore.DataType(f)
+		if dataType == "" {
+			dataType = "-"
+		}
+		colorable.Printf("%10d  %-16s  %s\n", fi.Size(), dataType, f)
+	}
+	return nil
+}
+
+type BuildstoreCatCmd struct {
+	Raw bool `long:"raw" description:"print the file as is (don't pretty-print JSON)"`
+
+	Args struct {
+		File string `name:"FILE" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+var buildstoreCatCmd BuildstoreCatCmd
+
+func (c *BuildstoreCatCmd) Execute(args []string) error {
+	fs, err := buildstoreCmd.commitFS()
+	if err != nil {
+		return err
+	}
+	files, err := buildDataFiles(fs)
+	if err != nil {
+		return err
+	}
+	file, err := locateBuildDataFile(files, c.Args.File)
+	if err != nil {
+		return err
+	}
+
+	f, err := fs.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	if !c.Raw && path.Ext(file) == ".json" {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, data, "", "  "); err == nil {
+			data = append(bytes.TrimSpace(buf.Bytes()), '\n')
+		} else if GlobalOpt.Verbose {
+			log.Printf("Warning: %s is not valid JSON (%s); printing it as is.", file, err)
+		}
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+type BuildstorePushCmd struct {
+	Args struct {
+		URL string `name:"URL" required:"yes" description:"object storage URL (s3://BUCKET/PREFIX or gs://BUCKET/PREFIX)"`
+	} `positional-args:"yes"`
+}
+
+var buildstorePushCmd BuildstorePushCmd
+
+func (c *BuildstorePushCmd) Execute(args []string) error {
+	local, err := buildstoreCmd.commitFS()
+	if err != nil {
+		return err
+	}
+	repo, _, commitID, err := buildstoreCmd.localStore()
+	if err != nil {
+		return err
+	}
+	remote, err := remoteBuildStore(c.Args.URL, repo)
+	if err != nil {
+		return err
+	}
+	n, err := buildstore.CopyAll(remote.Commit(commitID), local)
+	if err != nil {
+		return err
+	}
+	colorable.Printf("Pushed %d build data files for commit %s to %s\n", n, commitID, c.Args.URL)
+	return nil
+}
+
+type BuildstorePullCmd struct {
+	Args struct {
+		URL string `name:"URL" required:"yes" description:"object storage URL (s3://BUCKET/PREFIX or gs://BUCKET/PREFIX)"`
+	} `positional-args:"yes"`
+}
+
+var buildstorePullCmd BuildstorePullCmd
+
+func (c *BuildstorePullCmd) Execute(args []string) error {
+	repo, bs, commitID, err := buildstoreCmd.localStore()
+	if err != nil {
+		return err
+	}
+	remote, err := remoteBuildStore(c.Args.URL, repo)
+	if err != nil {
+		return err
+	}
+	if exists, err := buildstore.BuildDataExistsForCommit(remote, commitID); err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("no build data for commit %s at %s (push it with `src buildstore push`)", commitID, c.Args.URL)
+	}
+	if exists, err := buildstore.BuildDataExistsForCommit(bs, commitID); err != nil {
+		return err
+	} else if exists {
+		if err := buildstore.RemoveAllDataForCommit(bs, commitID); err != nil {
+			return err
+		}
+	}
+	n, err := buildstore.CopyAll(bs.Commit(commitID), remote.Commit(commitID))
+	if err != nil {
+		return err
+	}
+	colorable.Printf("Pulled %d build data files for commit %s from %s\n", n, commitID, c.Args.URL)
+	return nil
+}
+
+// remoteBuildStore returns the build store for repo in the object
+// storage at rawurl. Repositories' build data are kept under their
+// URIs, so that one URL can be shared by many repositories.
+func remoteBuildStore(rawurl string, repo *Repo) (buildstore.RepoBuildStore, error) {
+	if err := checkOnline("accessing build data in object storage"); err != nil {
+		return nil, err
+	}
+	if repo.URI() == "" {
+		return nil, errors.New("the repository has no URI (set its remote origin) to store its build data under")
+	}
+	fs, err := buildstore.OpenURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return buildstore.NewMulti(fs).RepoBuildStore(repo.URI())
+}
@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+func TestBuildDataFiles(t *testing.T) {
+	fs := rwvfs.Walkable(rwvfs.Map(map[string]string{
+		"u/t.graph.json":    "{}",
+		"u/t.unit.json":     "{}",
+		"v/w/t.graph.json":  "{}",
+		"t.depresolve.json": "[]",
+	}))
+	files, err := buildDataFiles(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"t.depresolve.json", "u/t.graph.json", "u/t.unit.json", "v/w/t.graph.json"}
+	if !reflect.DeepEqual(files, want) {
+		t.Fatalf("got files %v, want %v", files, want)
+	}
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "u/t.graph.json", want: "u/t.graph.json"},
+		{name: "./u/t.unit.json", want: "u/t.unit.json"},
+		{name: "w/t.graph.json", want: "v/w/t.graph.json"},
+		{name: "*.unit.json", want: "u/t.unit.json"},
+		{name: "u/*.unit.json", want: "u/t.unit.json"},
+		{name: "t.depresolve.json", want: "t.depresolve.json"},
+		{name: "t.graph.json", wantErr: true}, // ambiguous
+		{name: "*.graph.json", wantErr: true}, // ambiguous
+		{name: "x.json", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := locateBuildDataFile(files, test.name)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: got error %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+)
+
+func init() {
+	c, err := CLI.AddCommand("check",
+		"check repository policies",
+		"The check subcommands enforce policies on a repository's history (e.g., in CI) and exit with a non-zero status when they're violated.",
+		&checkCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("acked",
+		"check that breaking changes are acknowledged in commit messages",
+		"The acked command finds the commits from base to head (following first parents) that make breaking changes to exported defs, as 'src delta breaking' would report them, and checks that each such commit's message ends with a trailer (by default, \"Breaking-Change-Approved-By: NAME\") acknowledging them. It exits with a non-zero status if any commit's breaking changes are unacknowledged.\n\nA commit without build data is checked together with the next built commit, and a trailer on either commit acknowledges their breaking changes.",
+		&checkAckedCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type CheckCmd struct{}
+
+var checkCmd CheckCmd
+
+func (c *CheckCmd) Execute(args []string) error { return nil }
+
+type CheckAckedCmd struct {
+	DeltaCmdCommon
+
+	Trailer string `long:"trailer" description:"commit message trailer that acknowledges a commit's breaking changes" default:"Breaking-Change-Approved-By" value-name:"KEY"`
+}
+
+var checkAckedCmd CheckAckedCmd
+
+// ackedCommit is a commit that makes breaking changes.
+type ackedCommit struct {
+	Commit  string
+	Subject string
+
+	// Commits lists the unbuilt commits whose changes are included in
+	// this commit's (since they can't be told apart), followed by
+	// this commit.
+	Commits []string
+
+	Breaking []*breakingChange
+
+	// AckedBy holds the values of the acknowledging trailers (e.g.,
+	// the approvers' names). It is empty if the breaking changes
+	// are unacknowledged.
+	AckedBy []string
+}
+
+func (c *CheckAckedCmd) Execute(args []string) error {
+	format, err := c.outputFormat()
+	if err != nil {
+		return err
+	}
+	if format != "text" && format != "json" {
+		return fmt.Errorf("the acked command doesn't support --format=%s", format)
+	}
+	if c.Trailer == "" || strings.ContainsAny(c.Trailer, ": \t") {
+		return fmt.Errorf("invalid --trailer value: %q", c.Trailer)
+	}
+	base, head, err := c.deltaSides()
+	if err != nil {
+		return err
+	}
+	if base.repo.RootDir != head.repo.RootDir {
+		return errors.New("the acked command requires the base and head revisions to be in the same repo")
+	}
+	commits, err := head.repo.firstParentCommits(base.commitID, head.commitID)
+	if err != nil {
+		return err
+	}
+
+	prevDefs, err := base.defs()
+	if err != nil {
+		return err
+	}
+	var results []*ackedCommit
+	var pending []revRangeCommit // commits whose changes are in the next built commit's
+	for _, commit := range commits {
+		pending = append(pending, commit)
+		s := *head
+		s.commitID = commit.ID
+		if exists, err := buildstore.BuildDataExistsForCommit(s.bs, s.commitID); err != nil {
+			return err
+		} else if !exists {
+			log.Printf("Warning: no build data for commit %s (%s); its changes are checked with the next built commit's.", s.commitID, commit.Subject)
+			continue
+		}
+		defs, err := s.defs()
+		if err != nil {
+			return err
+		}
+
+		b := findBreakingChanges(prevDefs, defs)
+		if len(b.Breaking) > 0 {
+			r := &ackedCommit{Commit: commit.ID, Subject: commit.Subject, Breaking: b.Breaking}
+			for _, pc := range pending {
+				msg, err := commitMessage(head.repo.VCSType, head.repo.RootDir, pc.ID)
+				if err != nil {
+					return err
+				}
+				r.Commits = append(r.Commits, pc.ID)
+				r.AckedBy = append(r.AckedBy, commitTrailers(msg, c.Trailer)...)
+			}
+			results = append(results, r)
+		}
+		prevDefs, pending = defs, nil
+	}
+	if len(pending) > 0 {
+		log.Printf("Warning: %d commits up to head have no build data and were not checked.", len(pending))
+	}
+
+	// The head commit's source unit dirs are used for every commit
+	// (as with 'src delta defs --per-commit').
+	var unacked int
+	for _, r := range results {
+		for _, bc := range r.Breaking {
+			head.relocate(bc.Def)
+		}
+		if len(r.AckedBy) == 0 {
+			unacked++
+		}
+	}
+
+	if format == "json" {
+		PrintJSON(results, "  ")
+	} else {
+		colorable.Printf("Commits from %s to %s: %d with breaking changes, %d unacknowledged\n", base, head, len(results), unacked)
+		for _, r := range results {
+			status := "acknowledged by " + strings.Join(r.AckedBy, ", ")
+			if len(r.AckedBy) == 0 {
+				status = fmt.Sprintf("NOT ACKNOWLEDGED (add a %q trailer)", c.Trailer+": NAME")
+			}
+			colorable.Printf("\n%s %s: %d breaking changes, %s\n", shortCommitID(r.Commit), r.Subject, len(r.Breaking), status)
+			for _, bc := range r.Breaking {
+				colorable.Printf("  %-20s %s\n", bc.Reason+":", formatDeltaDef(bc.Def))
+			}
+		}
+	}
+
+	if unacked > 0 {
+		return fmt.Errorf("found %d commits with unacknowledged breaking changes", unacked)
+	}
+	return nil
+}
+
+// commitTrailers returns the non-empty values of the trailers named
+// key (case-insensitively) in the last paragraph of the commit message
+// msg, like git-interpret-trailers.
+func commitTrailers(msg, key string) []string {
+	paras := strings.Split(strings.TrimSpace(strings.Replace(msg, "\r\n", "\n", -1)), "\n\n")
+	if len(paras) < 2 {
+		// A lone paragraph is the subject (and body), not trailers.
+		return nil
+	}
+	var values []string
+	for _, line := range strings.Split(paras[len(paras)-1], "\n") {
+		i := strings.Index(line, ":")
+		if i == -1 || !strings.EqualFold(strings.TrimSpace(line[:i]), key) {
+			continue
+		}
+		if v := strings.TrimSpace(line[i+1:]); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
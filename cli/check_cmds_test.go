@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCommitTrailers(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want []string
+	}{
+		{"Remove Foo\n\nBreaking-Change-Approved-By: Alice <alice@example.com>\n", []string{"Alice <alice@example.com>"}},
+		{"Remove Foo\n\nWhy.\n\nSigned-off-by: Bob\nbreaking-change-approved-by: Carol\nBreaking-Change-Approved-By: Dave\n", []string{"Carol", "Dave"}},
+		{"Remove Foo\r\n\r\nBreaking-Change-Approved-By: Erin\r\n", []string{"Erin"}},
+
+		// Not in the last paragraph.
+		{"Remove Foo\n\nBreaking-Change-Approved-By: Alice\n\nMore.\n", nil},
+		// Empty value.
+		{"Remove Foo\n\nBreaking-Change-Approved-By:\n", nil},
+		// The subject isn't a trailer.
+		{"Breaking-Change-Approved-By: Alice\n", nil},
+	}
+	for _, test := range tests {
+		got := commitTrailers(test.msg, "Breaking-Change-Approved-By")
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.msg, got, test.want)
+		}
+	}
+}
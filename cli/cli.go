@@ -2,6 +2,7 @@ package cli
 
 import (
 	"log"
+	"os"
 
 	"github.com/alexsaveliev/go-colorable-wrapper"
 
@@ -14,6 +15,7 @@ var CLI = flags.NewNamedParser(srclib.CommandName, flags.Default)
 // GlobalOpt contains global options.
 var GlobalOpt struct {
 	Verbose bool `short:"v" description:"show verbose output"`
+	Offline bool `long:"offline" description:"forbid network access; commands that need it fail immediately (also enabled by SRC_OFFLINE=1)"`
 }
 
 func init() {
@@ -26,6 +28,7 @@ func Main() error {
 	log.SetPrefix("")
 	log.SetOutput(colorable.Stderr)
 
+	applyLazyDefaults(os.Args[1:])
 	_, err := CLI.Parse()
 	return err
 }
@@ -0,0 +1,197 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/go-flags"
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+func init() {
+	_, err := CLI.AddCommand("completion",
+		"print a shell completion script",
+		"The completion command prints a script that sets up tab completion of commands, options, and arguments for SHELL (bash, zsh, or fish). Besides option names, the completions include repos in the global store (for --repo), commits with build data (for --commit, --base, and --head), and def names in the current repo (for `query` arguments). To enable completion, add the output to your shell's startup file; for example, for bash, add `eval \"$("+srclib.CommandName+" completion bash)\"` to ~/.bashrc.",
+		&completionCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type CompletionCmd struct {
+	Command string `long:"command" description:"name of the command to complete (if it is invoked by a different name or alias)" value-name:"NAME"`
+
+	Args struct {
+		Shell string `name:"SHELL" description:"bash, zsh, or fish" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+var completionCmd CompletionCmd
+
+// completionScripts are the shell completion scripts, keyed by shell.
+// In each, %[1]s is the command name and %[2]s is a version of it
+// that is safe to use in a function name. They all use go-flags'
+// completion, which prints completions (one per line) when the
+// GO_FLAGS_COMPLETION environment variable is set.
+var completionScripts = map[string]string{
+	"bash": `_%[2]s_complete() {
+	local args=("${COMP_WORDS[@]:1:$COMP_CWORD}")
+	local IFS=$'\n'
+	COMPREPLY=($(GO_FLAGS_COMPLETION=1 "${COMP_WORDS[0]}" "${args[@]}" 2>/dev/null))
+	return 0
+}
+complete -o default -F _%[2]s_complete %[1]s
+`,
+	"zsh": `autoload -U +X bashcompinit && bashcompinit
+_%[2]s_complete() {
+	local args=("${COMP_WORDS[@]:1:$COMP_CWORD}")
+	local IFS=$'\n'
+	COMPREPLY=($(GO_FLAGS_COMPLETION=1 "${COMP_WORDS[0]}" "${args[@]}" 2>/dev/null))
+	return 0
+}
+complete -o default -F _%[2]s_complete %[1]s
+`,
+	"fish": `function __%[2]s_complete
+	set -l args (commandline -opc)
+	set -e args[1]
+	env GO_FLAGS_COMPLETION=1 %[1]s $args (commandline -ct) 2>/dev/null
+end
+complete -c %[1]s -f -a '(__%[2]s_complete)'
+`,
+}
+
+func (c *CompletionCmd) Execute(args []string) error {
+	name := c.Command
+	if name == "" {
+		name = srclib.CommandName
+	}
+	script, err := completionScript(c.Args.Shell, name)
+	if err != nil {
+		return err
+	}
+	colorable.Print(script)
+	return nil
+}
+
+// completionScript returns the completion script for shell that
+// completes the command name.
+func completionScript(shell, name string) (string, error) {
+	script, ok := completionScripts[shell]
+	if !ok {
+		return "", fmt.Errorf("unsupported shell %q (supported shells are bash, zsh, and fish)", shell)
+	}
+	funcName := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, filepath.Base(name))
+	return fmt.Sprintf(script, name, funcName), nil
+}
+
+// maxCompletions is the maximum number of completions that the
+// completers below return. Completion must be fast, so they stop
+// looking after finding this many.
+const maxCompletions = 100
+
+// RepoURI is a flags.Completer that completes the URIs of repos in
+// the global store.
+type RepoURI string
+
+// Complete implements flags.Completer.
+func (RepoURI) Complete(match string) []flags.Completion {
+	s := store.NewFSMultiRepoStore(rwvfs.Walkable(rwvfs.ReadOnly(rwvfs.OS(srclib.StoreDir))), nil)
+	repos, err := s.Repos()
+	if err != nil {
+		return nil
+	}
+	var items []string
+	for _, repo := range repos {
+		if strings.HasPrefix(repo, match) {
+			items = append(items, repo)
+		}
+	}
+	return completionItems(items)
+}
+
+// CommitID is a flags.Completer that completes the IDs of commits of
+// the current repo that have build data.
+type CommitID string
+
+// Complete implements flags.Completer.
+func (CommitID) Complete(match string) []flags.Completion {
+	repo, err := OpenLocalRepo()
+	if err != nil || repo == nil {
+		return nil
+	}
+	// Don't use buildstore.LocalRepo, because it creates the build
+	// data dir.
+	fis, err := ioutil.ReadDir(filepath.Join(repo.RootDir, buildstore.BuildDataDirName))
+	if err != nil {
+		return nil
+	}
+	var items []string
+	for _, fi := range fis {
+		if strings.HasPrefix(fi.Name(), match) {
+			items = append(items, fi.Name())
+		}
+	}
+	return completionItems(items)
+}
+
+// DefName is a flags.Completer that completes the names of defs in
+// the current repo's store.
+type DefName string
+
+// Complete implements flags.Completer.
+func (DefName) Complete(match string) []flags.Completion {
+	if match == "" || strings.HasPrefix(match, ":") {
+		return nil
+	}
+	repo, err := OpenLocalRepo()
+	if err != nil || repo == nil {
+		return nil
+	}
+	fs := rwvfs.ReadOnly(rwvfs.OS(filepath.Join(repo.RootDir, store.SrclibStoreDir)))
+	defs, err := store.NewFSRepoStore(fs).Defs(
+		store.ByCommitIDs(repo.CommitID),
+		store.ByDefQuery(match),
+		store.Limit(maxCompletions, 0),
+	)
+	if err != nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var items []string
+	for _, def := range defs {
+		if !seen[def.Name] && strings.HasPrefix(def.Name, match) {
+			seen[def.Name] = true
+			items = append(items, def.Name)
+		}
+	}
+	return completionItems(items)
+}
+
+// completionItems returns up to maxCompletions of items, sorted, as
+// completions.
+func completionItems(items []string) []flags.Completion {
+	sort.Strings(items)
+	if len(items) > maxCompletions {
+		items = items[:maxCompletions]
+	}
+	completions := make([]flags.Completion, len(items))
+	for i, item := range items {
+		completions[i].Item = item
+	}
+	return completions
+}
@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompletionScript(t *testing.T) {
+	for shell := range completionScripts {
+		script, err := completionScript(shell, "/usr/local/bin/src-dev")
+		if err != nil {
+			t.Errorf("%s: %s", shell, err)
+			continue
+		}
+		if !strings.Contains(script, "_src_dev_complete") {
+			t.Errorf("%s: script doesn't define a completion function with a sanitized name:\n%s", shell, script)
+		}
+		if !strings.Contains(script, "GO_FLAGS_COMPLETION=1") {
+			t.Errorf("%s: script doesn't request completions from the command:\n%s", shell, script)
+		}
+		if strings.Contains(script, "%!") {
+			t.Errorf("%s: script has formatting errors:\n%s", shell, script)
+		}
+	}
+
+	if _, err := completionScript("csh", "src"); err == nil {
+		t.Error("got no error for an unsupported shell")
+	}
+}
@@ -147,6 +147,16 @@ func (c *ConfigCmd) Execute(args []string) error {
 		}
 	}
 
+	// Record the nested repositories, so that refs to their source
+	// units can be attributed to them when graphing.
+	if len(cfg.NestedRepos) > 0 {
+		if err := config.WriteNestedRepos(commitFS, cfg.NestedRepos); err != nil {
+			return err
+		}
+	} else if err := commitFS.Remove(config.NestedReposFilename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
 	if c.Output.Output == "json" {
 		PrintJSON(cfg, "")
 	} else {
@@ -162,6 +172,14 @@ func (c *ConfigCmd) Execute(args []string) error {
 		}
 		fmt.Fprintln(c.w)
 
+		if len(cfg.NestedRepos) > 0 {
+			fmt.Fprintf(c.w, "NESTED REPOSITORIES (%d)\n", len(cfg.NestedRepos))
+			for _, r := range cfg.NestedRepos {
+				fmt.Fprintf(c.w, " - %s: %s (%d source units)\n", r.Dir, r.URI, len(r.Units))
+			}
+			fmt.Fprintln(c.w)
+		}
+
 		fmt.Fprintf(c.w, "CONFIG PROPERTIES (%d)\n", len(cfg.Config))
 		for _, kv := range sortedMap(cfg.Config) {
 			fmt.Fprintf(c.w, " - %s: %s\n", kv[0], kv[1])
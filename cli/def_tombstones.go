@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+// maxTombstoneAncestors is the max number of first-parent ancestors
+// searched for the previous built commit when recording tombstones.
+const maxTombstoneAncestors = 50
+
+// recordDefTombstones records tombstones in the build data for
+// commitID for the defs that were removed since its nearest
+// first-parent ancestor with complete build data (carrying over that
+// commit's tombstones). It does nothing if no such ancestor exists.
+func recordDefTombstones(repo *Repo, bs buildstore.RepoBuildStore, commitID string) error {
+	ancestors, err := firstParentAncestors(repo.VCSType, repo.RootDir, commitID, maxTombstoneAncestors)
+	if err != nil {
+		return err
+	}
+	var parent string
+	for _, c := range ancestors {
+		units, built, err := buildProgress(bs, c)
+		if err != nil {
+			return err
+		}
+		if units > 0 && built == units {
+			parent = c
+			break
+		}
+	}
+	if parent == "" {
+		return nil
+	}
+
+	parentGraph, err := commitGraph(bs, parent)
+	if err != nil {
+		return err
+	}
+	g, err := commitGraph(bs, commitID)
+	if err != nil {
+		return err
+	}
+	parentTombstones, err := store.ReadDefTombstones(bs.Commit(parent))
+	if err != nil {
+		return fmt.Errorf("reading def tombstones of %s: %s", parent, err)
+	}
+	t := store.NextDefTombstones(parentTombstones, parent, parentGraph.Defs, commitID, g.Defs)
+	if GlobalOpt.Verbose {
+		log.Printf("# Recording %d def tombstones (since %s)", len(t), parent)
+	}
+	return store.WriteDefTombstones(bs.Commit(commitID), t)
+}
+
+// recordLocalDefTombstones records tombstones for commitID of the
+// current repo (see recordDefTombstones).
+func recordLocalDefTombstones(commitID string) error {
+	repo, err := OpenLocalRepo()
+	if err != nil {
+		return err
+	}
+	bs, err := buildstore.LocalRepo(repo.RootDir)
+	if err != nil {
+		return err
+	}
+	return recordDefTombstones(repo, bs, commitID)
+}
+
+// tombstones caches the def tombstones of the build data for
+// tombstonesCommitID.
+var (
+	tombstones         store.DefTombstones
+	tombstonesCommitID string
+)
+
+// activeTombstones returns the def tombstones recorded when the active
+// commit's build data was imported, or nil if there are none.
+func activeTombstones() store.DefTombstones {
+	if activeContext.commitFS == nil {
+		return nil
+	}
+	if commitID := activeCommitID(); commitID != tombstonesCommitID {
+		t, err := store.ReadDefTombstones(activeContext.commitFS)
+		if err != nil && GlobalOpt.Verbose {
+			log.Printf("Warning: reading def tombstones: %s", err)
+		}
+		tombstones, tombstonesCommitID = t, commitID
+	}
+	return tombstones
+}
+
+// removedDefsText describes the removal of the defs named any of
+// names that have tombstones in the active commit, or returns the
+// empty string if there are none.
+func removedDefsText(names []tokValue) string {
+	t := activeTombstones()
+	var lines []string
+	for _, name := range names {
+		for _, x := range t.ByName(string(name)) {
+			lines = append(lines, fmt.Sprintf("%s %s (%s %s) was removed in commit %s; it was last defined in %s at commit %s.", x.Kind, x.Path, x.UnitType, x.Unit, shortCommitID(x.RemovedIn), x.File, shortCommitID(x.LastCommitID)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"code.google.com/p/rog-go/parallel"
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+type DeltaAllCmd struct {
+	DeltaCmdCommon
+
+	Exported bool `long:"exported" description:"only show exported defs in the defs section"`
+	Jobs     int  `short:"j" long:"jobs" description:"max number of build data files to read concurrently" default:"4"`
+}
+
+var deltaAllCmd DeltaAllCmd
+
+// allDelta is the output of the all command.
+type allDelta struct {
+	Base, Head string
+
+	Defs     *defsDelta
+	Breaking *breakingDelta
+	Deps     *depsDelta
+	Docs     *docsDelta
+}
+
+// fetchDeltaData reads the graph and dependency data of both sides
+// concurrently, with at most jobs reads at a time.
+func fetchDeltaData(base, head *deltaSide, jobs int) (baseGraph, headGraph *graph.Output, baseDeps, headDeps []*dep.Resolution, err error) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	par := parallel.NewRun(jobs)
+	par.Do(func() (err error) { baseGraph, err = base.graph(); return })
+	par.Do(func() (err error) { headGraph, err = head.graph(); return })
+	par.Do(func() (err error) { baseDeps, err = base.deps(); return })
+	par.Do(func() (err error) { headDeps, err = head.deps(); return })
+	if err := par.Wait(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return baseGraph, headGraph, baseDeps, headDeps, nil
+}
+
+func (c *DeltaAllCmd) Execute(args []string) error {
+	format, err := c.outputFormat()
+	if err != nil {
+		return err
+	}
+	baseSide, headSide, err := c.deltaSides()
+	if err != nil {
+		return err
+	}
+	baseGraph, headGraph, baseDeps, headDeps, err := fetchDeltaData(baseSide, headSide, c.Jobs)
+	if err != nil {
+		return err
+	}
+
+	a := &allDelta{
+		Base:     baseSide.String(),
+		Head:     headSide.String(),
+		Defs:     computeDefsDelta(baseGraph.Defs, headGraph.Defs, c.Exported),
+		Breaking: findBreakingChanges(baseGraph.Defs, headGraph.Defs),
+		Deps:     computeDepsDelta(baseDeps, headDeps),
+		Docs:     computeDocsDelta(baseGraph, headGraph),
+	}
+	a.Defs.detectRenames()
+	a.Defs.Base, a.Defs.Head = a.Base, a.Head
+	a.Breaking.Base, a.Breaking.Head = a.Base, a.Head
+	a.Deps.Base, a.Deps.Head = a.Base, a.Head
+	a.Docs.Base, a.Docs.Head = a.Base, a.Head
+	a.Defs.relocate(baseSide, headSide)
+	for _, bc := range a.Breaking.Breaking {
+		baseSide.relocate(bc.Def)
+	}
+	a.Docs.relocate(headSide)
+
+	switch format {
+	case "json":
+		PrintJSON(a, "  ")
+	case "markdown":
+		baseURL, headURL := baseSide.defURL(c.LinkURL), headSide.defURL(c.LinkURL)
+		var buf bytes.Buffer
+		for i, write := range []func() error{
+			func() error { return writeDefsDeltaMarkdown(&buf, a.Defs, baseURL, headURL) },
+			func() error { return writeBreakingDeltaMarkdown(&buf, a.Breaking, baseURL) },
+			func() error { return writeDepsDeltaMarkdown(&buf, a.Deps, c.LinkURL) },
+			func() error { return writeDocsDeltaMarkdown(&buf, a.Docs, headURL) },
+		} {
+			if i > 0 {
+				fmt.Fprintln(&buf)
+			}
+			if err := write(); err != nil {
+				return err
+			}
+		}
+		if err := c.writeReport(func(w io.Writer) error {
+			_, err := buf.WriteTo(w)
+			return err
+		}); err != nil {
+			return err
+		}
+	case "html":
+		r := newHTMLReport(fmt.Sprintf("Changes from %s to %s", a.Base, a.Head))
+		r.defsDelta(fmt.Sprintf("Def changes from %s to %s", a.Base, a.Head), a.Defs, baseSide, headSide, c.LinkURL)
+		r.breakingDelta(a.Breaking, baseSide, c.LinkURL)
+		r.depsDelta(a.Deps)
+		r.docsDelta(a.Docs, headSide, c.LinkURL)
+		if err := c.writeReport(r.writeTo); err != nil {
+			return err
+		}
+	default:
+		colorable.Printf("Defs from %s to %s: %s\n", a.Base, a.Head, a.Defs.summary())
+		printDefsDelta(a.Defs)
+		colorable.Println()
+		printBreakingDelta(a.Breaking)
+		colorable.Println()
+		printDepsDelta(a.Deps)
+		colorable.Println()
+		printDocsDelta(a.Docs)
+	}
+
+	if len(a.Breaking.Breaking) > 0 {
+		return fmt.Errorf("found %d breaking changes", len(a.Breaking.Breaking))
+	}
+	return nil
+}
@@ -0,0 +1,1140 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/plan"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func init() {
+	c, err := CLI.AddCommand("delta",
+		"compare build data between commits",
+		"The delta commands compare the build data of two commits, usually of the current repository (but see --base-repo and --head-repo). They read the local build data (produced by `src make` with each commit checked out), so they work for any repository, without importing its data anywhere.",
+		&deltaCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("defs",
+		"list defs added, changed, or deleted between commits",
+		"The defs command lists the defs that were added, changed, or deleted between the --base and --head commits (which may be in different repos; see --base-repo and --head-repo). A def changed if its kind, name, or (toolchain-specific) signature data changed; moving a def doesn't change it. Local defs are omitted. With --per-commit, it lists the changes made by each commit in the range separately (for release notes); commits without build data are skipped, and their changes are attributed to the next commit that has build data.",
+		&deltaDefsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("breaking",
+		"detect breaking changes between commits",
+		"The breaking command lists the changes to exported defs between the --base and --head commits that may break code that uses them: exported defs that were removed or unexported, and exported defs whose kind or (toolchain-specific) signature data changed. Test defs are ignored. It exits with a non-zero status if there are any breaking changes, so it can be used to gate releases in CI.",
+		&deltaBreakingCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("deps",
+		"list dependencies added, removed, or changed between commits",
+		"The deps command compares the resolved dependencies of the --base and --head commits and lists the dependency repos that were added or removed, and those whose revision (or version) changed, with their old and new revisions. Unresolved dependencies are ignored.",
+		&deltaDepsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("docs",
+		"list defs whose docs changed between commits",
+		"The docs command lists the defs whose documentation changed between the --base and --head commits, and the exported defs that were added or changed at --head but have no documentation. With --check, it exits with an error if there are any such undocumented defs, for use in docs coverage checks on pull requests.",
+		&deltaDocsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("impact-score",
+		"score the impact of changes between commits on dependent repos",
+		"The impact-score command combines the number of defs changed, renamed, or deleted between the --base and --head commits, the number of refs to those defs from other repos in the global store, and the number of those repos into a single weighted score (see the --*-weight options). With --threshold, it exits with a non-zero status if the score exceeds the threshold, so it can be used as a merge gate in CI.",
+		&deltaImpactScoreCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("all",
+		"show all deltas between commits in one report",
+		"The all command reports the def, breaking, dependency, and doc changes between the --base and --head commits together (as the defs, breaking, deps, and docs commands would separately). The build data of both commits is read concurrently, by up to --jobs workers. It exits with a non-zero status if there are any breaking changes.",
+		&deltaAllCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("notify",
+		"notify people whose repos use defs that changed between commits",
+		"The notify command finds the defs changed, renamed, or deleted between the --base and --head commits that are referred to from other repos in the global store, and emits a digest of those changes for each person (listed in the --config file) responsible for any of those repos. With --post, it posts each digest to the config file's webhook instead of printing it.\n\nThe config file is JSON: {\"Webhook\": URL, \"Subscribers\": [{\"Name\": ..., \"Email\": ..., \"Slack\": \"@handle\", \"Repos\": [\"github.com/acme/*\", ...]}]}. Digests are posted as {\"channel\": Slack, \"email\": Email, \"text\": digest}, which a Slack incoming webhook accepts.",
+		&deltaNotifyCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type DeltaCmd struct{}
+
+var deltaCmd DeltaCmd
+
+func (c *DeltaCmd) Execute(args []string) error { return nil }
+
+// DeltaCmdCommon holds the options common to all delta subcommands.
+type DeltaCmdCommon struct {
+	Base     CommitID  `long:"base" description:"base revision (commit ID, branch, tag, etc.; default: the base repo's current commit)" value-name:"REV"`
+	BaseRepo Directory `long:"base-repo" description:"directory of the repo (e.g., a clone of the upstream repo) that the base revision is in (default: the current repo)" value-name:"DIR"`
+	Head     CommitID  `long:"head" description:"head revision (default: the head repo's current commit)" value-name:"REV"`
+	HeadRepo Directory `long:"head-repo" description:"directory of the repo (e.g., a clone of a fork) that the head revision is in (default: the current repo)" value-name:"DIR"`
+
+	JSON    bool   `long:"json" description:"print the delta as JSON (same as --format=json)"`
+	Format  string `long:"format" description:"output format: text, json, markdown (a report to paste into a pull request comment), or html (a standalone report with the definitions of changed defs)" default:"text" value-name:"FORMAT"`
+	Output  string `long:"output" short:"o" description:"write the markdown or HTML report to FILE instead of stdout" value-name:"FILE"`
+	LinkURL string `long:"link-url" description:"base URL of the Sourcegraph instance that markdown and HTML reports link defs and repos to (empty for no links)" default:"https://sourcegraph.com" value-name:"URL"`
+
+	PathsOpt
+
+	Wait        bool          `long:"wait" description:"wait until the base and head commits have been built (e.g., by 'src make' running elsewhere) instead of using incomplete build data"`
+	WaitTimeout time.Duration `long:"wait-timeout" description:"max time to wait with --wait" default:"10m"`
+}
+
+// outputFormat returns the output format given by --format or --json.
+func (c *DeltaCmdCommon) outputFormat() (string, error) {
+	format := c.Format
+	if c.JSON {
+		if c.Format != "text" && c.Format != "json" {
+			return "", fmt.Errorf("--json can't be used with --format=%s", c.Format)
+		}
+		format = "json"
+	}
+	switch format {
+	case "text", "json":
+		if c.Output != "" {
+			return "", errors.New("--output can only be used with --format=markdown or --format=html")
+		}
+		return format, nil
+	case "markdown", "html":
+		return format, nil
+	default:
+		return "", fmt.Errorf("unrecognized --format value: %q (valid values are text, json, markdown, html)", format)
+	}
+}
+
+// writeReport calls write to write a markdown or HTML report to the
+// file given by --output, or to stdout.
+func (c *DeltaCmdCommon) writeReport(write func(io.Writer) error) error {
+	if c.Output == "" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(c.Output)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// deltaSide is the base or head side of a delta: a commit in a repo
+// (whose build data is in the repo's build store).
+type deltaSide struct {
+	dir      Directory // as given on the command line ("" for the current repo)
+	repo     *Repo
+	bs       buildstore.RepoBuildStore
+	commitID string
+
+	files map[string][]byte // file contents by commit ID and path (see snippet)
+
+	paths     *pathDisplay          // --paths for the side's repo (nil for repo-relative)
+	repoFiles map[*graph.Def]string // repo-relative files of relocated defs
+}
+
+// String returns the commit ID, qualified by the repo's directory if
+// it isn't the current repo.
+func (s *deltaSide) String() string {
+	if s.dir == "" {
+		return s.commitID
+	}
+	return string(s.dir) + "@" + s.commitID
+}
+
+// deltaSides resolves the base and head revisions, each in its own
+// repo (so that a fork's branch can be compared to upstream).
+func (c *DeltaCmdCommon) deltaSides() (base, head *deltaSide, err error) {
+	if c.Base == "" && c.BaseRepo == "" {
+		return nil, nil, errors.New("specify the base with --base (and/or --base-repo)")
+	}
+	if err := checkPathBase(c.Paths); err != nil {
+		return nil, nil, err
+	}
+	if base, err = openDeltaSide(c.BaseRepo, string(c.Base)); err != nil {
+		return nil, nil, err
+	}
+	if head, err = openDeltaSide(c.HeadRepo, string(c.Head)); err != nil {
+		return nil, nil, err
+	}
+	for _, s := range []*deltaSide{base, head} {
+		if err := c.checkBuild(s); err != nil {
+			return nil, nil, err
+		}
+		if s.paths, err = newPathDisplay(c.Paths, s.repo.RootDir, commitUnits(s.bs.Commit(s.commitID))); err != nil {
+			return nil, nil, err
+		}
+		s.repoFiles = map[*graph.Def]string{}
+	}
+	return base, head, nil
+}
+
+// relocate converts the files of defs (which are at the side's commit)
+// to the form given by --paths. It must be called after the delta is
+// computed, since the delta compares the defs' files.
+func (s *deltaSide) relocate(defs ...*graph.Def) {
+	if s.paths == nil {
+		return
+	}
+	for _, def := range defs {
+		if _, done := s.repoFiles[def]; done {
+			continue
+		}
+		s.repoFiles[def] = def.File
+		def.File = s.paths.def(def)
+	}
+}
+
+// repoFile returns the repo-relative path of def's file, even if def
+// was relocated.
+func (s *deltaSide) repoFile(def *graph.Def) string {
+	if file, ok := s.repoFiles[def]; ok {
+		return file
+	}
+	return def.File
+}
+
+// relocate converts the files of the defs in d (see
+// (*deltaSide).relocate).
+func (d *defsDelta) relocate(base, head *deltaSide) {
+	head.relocate(d.Added...)
+	for _, c := range d.Changed {
+		base.relocate(c.Base)
+		head.relocate(c.Head)
+	}
+	for _, c := range d.Renamed {
+		base.relocate(c.Base)
+		head.relocate(c.Head)
+	}
+	base.relocate(d.Deleted...)
+}
+
+// openDeltaSide resolves rev (or, if empty, the current commit) in the
+// repo at dir (or, if empty, the current repo).
+func openDeltaSide(dir Directory, rev string) (*deltaSide, error) {
+	repo, err := OpenRepo(dir.String())
+	if err != nil {
+		return nil, err
+	}
+	s := &deltaSide{dir: dir, repo: repo, commitID: repo.CommitID}
+	if rev != "" {
+		if s.commitID, err = repo.resolveRevision(rev); err != nil {
+			return nil, err
+		}
+	}
+	if s.bs, err = buildstore.LocalRepo(repo.RootDir); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// graph returns the defs and docs in the build data of the side's
+// commit.
+func (s *deltaSide) graph() (*graph.Output, error) {
+	g, err := commitGraph(s.bs, s.commitID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", s.repo.RootDir, err)
+	}
+	return g, nil
+}
+
+// defs returns the defs in the build data of the side's commit.
+func (s *deltaSide) defs() ([]*graph.Def, error) {
+	g, err := s.graph()
+	if err != nil {
+		return nil, err
+	}
+	return g.Defs, nil
+}
+
+// commitGraph returns the defs and docs in the build data for
+// commitID, with their source unit set. Refs are omitted.
+func commitGraph(bs buildstore.RepoBuildStore, commitID string) (*graph.Output, error) {
+	exists, err := buildstore.BuildDataExistsForCommit(bs, commitID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("no build data for commit %s (check it out and run `src make` first)", commitID)
+	}
+
+	fs := bs.Commit(commitID)
+	unitSuffix := buildstore.DataTypeSuffix(unit.SourceUnit{})
+	var all graph.Output
+	err = buildstore.WalkFiles(fs, ".", nil, func(unitFile string) error {
+		if !strings.HasSuffix(unitFile, unitSuffix) {
+			return nil
+		}
+		var u unit.SourceUnit
+		if err := readJSONFileFS(fs, unitFile, &u); err != nil {
+			return err
+		}
+		var g graph.Output
+		if err := readJSONFileFS(fs, plan.SourceUnitDataFilename("graph", &u), &g); os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, def := range g.Defs {
+			if def.UnitType == "" {
+				def.UnitType = u.Type
+			}
+			if def.Unit == "" {
+				def.Unit = u.Name
+			}
+			all.Defs = append(all.Defs, def)
+		}
+		for _, doc := range g.Docs {
+			if doc.UnitType == "" {
+				doc.UnitType = u.Type
+			}
+			if doc.Unit == "" {
+				doc.Unit = u.Name
+			}
+			all.Docs = append(all.Docs, doc)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &all, nil
+}
+
+type DeltaDefsCmd struct {
+	DeltaCmdCommon
+
+	Exported  bool `long:"exported" description:"only show exported defs"`
+	NoRenames bool `long:"no-renames" description:"list renamed and moved defs as deleted and added instead of as renamed"`
+
+	Range     string `long:"range" description:"revision range to compare (instead of --base and --head)" value-name:"BASE..HEAD"`
+	PerCommit bool   `long:"per-commit" description:"list the changes made by each commit from base to head (following first parents), like a changelog"`
+
+	ShowDiff bool `long:"show-diff" description:"after the list of changes, print a diff of the definition of each changed (or renamed) def from base to head (text format only)"`
+
+	GroupBy string `long:"group-by" description:"group the changes by file, unit, or kind" value-name:"file|unit|kind"`
+	Sort    string `long:"sort" description:"sort the changes by name, by the number of refs to them from other repos in the global store (xrefs), or by their impact score (see impact-score) instead of by source unit and path" value-name:"name|xrefs|impact"`
+}
+
+var deltaDefsCmd DeltaDefsCmd
+
+// defsDelta is the set of defs that differ between two commits.
+type defsDelta struct {
+	Base, Head string
+
+	Added   []*graph.Def
+	Changed []*defChange
+	Deleted []*graph.Def
+
+	// Renamed is the defs that were renamed or moved (to another
+	// path or source unit). It is only set by detectRenames.
+	Renamed []*defChange `json:",omitempty"`
+
+	// Xrefs lists the changed, renamed, and deleted defs that other
+	// repos refer to, with the most referenced first. It is only set
+	// by sortDefsDelta (for --sort xrefs or impact).
+	Xrefs []*defImpact `json:",omitempty"`
+
+	// xrefsByDef holds the refs counted by sortDefsDelta, keyed by
+	// the base version of each changed, renamed, and deleted def.
+	xrefsByDef map[*graph.Def]*defImpact
+}
+
+// xrefsNote describes the refs from other repos to def (the base
+// version of a changed, renamed, or deleted def), or returns "" if
+// they weren't counted.
+func (d *defsDelta) xrefsNote(def *graph.Def) string {
+	di, ok := d.xrefsByDef[def]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%s from %s", pluralize(di.Xrefs, "ref"), pluralize(len(di.Dependents), "other repo"))
+}
+
+// withXrefsNote appends def's xrefsNote (if any) to the details shown
+// with it in reports.
+func (d *defsDelta) withXrefsNote(details []string, def *graph.Def) []string {
+	if n := d.xrefsNote(def); n != "" {
+		return append(details, "Xrefs: "+n)
+	}
+	return details
+}
+
+// defChange is a def that exists at both commits but changed.
+type defChange struct {
+	Base, Head *graph.Def
+}
+
+// defFingerprint returns a hash of the parts of def that a rename or
+// move doesn't change: its kind, the size of its definition, and its
+// data with its name elided. It returns the empty string if def has
+// no data, since unrelated defs of the same kind and size would
+// otherwise be mistaken for renames of each other.
+func defFingerprint(def *graph.Def) string {
+	if len(def.Data) == 0 {
+		return ""
+	}
+	data := def.Data
+	if def.Name != "" {
+		data = bytes.Replace(data, []byte(def.Name), nil, -1)
+	}
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00", def.UnitType, def.Kind, int(def.DefEnd)-int(def.DefStart)-len(def.Name))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// detectRenames moves each deleted def whose fingerprint (see
+// defFingerprint) matches exactly one added def, and no other deleted
+// def, from d.Deleted and d.Added to d.Renamed.
+func (d *defsDelta) detectRenames() {
+	added := map[string][]int{}
+	for i, def := range d.Added {
+		if fp := defFingerprint(def); fp != "" {
+			added[fp] = append(added[fp], i)
+		}
+	}
+	deleted := map[string][]int{}
+	for i, def := range d.Deleted {
+		if fp := defFingerprint(def); fp != "" {
+			deleted[fp] = append(deleted[fp], i)
+		}
+	}
+
+	renamedAdded, renamedDeleted := map[int]bool{}, map[int]bool{}
+	for fp, is := range deleted {
+		if len(is) != 1 || len(added[fp]) != 1 {
+			continue
+		}
+		i, j := is[0], added[fp][0]
+		d.Renamed = append(d.Renamed, &defChange{Base: d.Deleted[i], Head: d.Added[j]})
+		renamedDeleted[i], renamedAdded[j] = true, true
+	}
+	if len(d.Renamed) == 0 {
+		return
+	}
+
+	remaining := func(defs []*graph.Def, renamed map[int]bool) []*graph.Def {
+		var rest []*graph.Def
+		for i, def := range defs {
+			if !renamed[i] {
+				rest = append(rest, def)
+			}
+		}
+		return rest
+	}
+	d.Added, d.Deleted = remaining(d.Added, renamedAdded), remaining(d.Deleted, renamedDeleted)
+	sort.Sort(defChangesByUnitAndPath(d.Renamed))
+}
+
+// computeDefsDelta compares the defs at two commits, identifying defs
+// by their source unit and path. Local defs are ignored, and if
+// exported is true, so are unexported defs. The lists are sorted by
+// source unit and def path.
+func computeDefsDelta(baseDefs, headDefs []*graph.Def, exported bool) *defsDelta {
+	keep := func(def *graph.Def) bool { return !def.Local && (!exported || def.Exported) }
+	base := defsByUnitAndPath(baseDefs)
+	head := defsByUnitAndPath(headDefs)
+
+	var d defsDelta
+	for key, headDef := range head {
+		if !keep(headDef) {
+			continue
+		}
+		if baseDef, present := base[key]; !present || !keep(baseDef) {
+			d.Added = append(d.Added, headDef)
+		} else if defChanged(baseDef, headDef) {
+			d.Changed = append(d.Changed, &defChange{Base: baseDef, Head: headDef})
+		}
+	}
+	for key, baseDef := range base {
+		if !keep(baseDef) {
+			continue
+		}
+		if headDef, present := head[key]; !present || !keep(headDef) {
+			d.Deleted = append(d.Deleted, baseDef)
+		}
+	}
+
+	sort.Sort(defsByUnitAndPathOrder(d.Added))
+	sort.Sort(defChangesByUnitAndPath(d.Changed))
+	sort.Sort(defsByUnitAndPathOrder(d.Deleted))
+	return &d
+}
+
+// defLess orders defs by source unit and path.
+func defLess(a, b *graph.Def) bool {
+	if a.UnitType != b.UnitType {
+		return a.UnitType < b.UnitType
+	}
+	if a.Unit != b.Unit {
+		return a.Unit < b.Unit
+	}
+	return a.Path < b.Path
+}
+
+type defsByUnitAndPathOrder []*graph.Def
+
+func (v defsByUnitAndPathOrder) Len() int           { return len(v) }
+func (v defsByUnitAndPathOrder) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v defsByUnitAndPathOrder) Less(i, j int) bool { return defLess(v[i], v[j]) }
+
+type defChangesByUnitAndPath []*defChange
+
+func (v defChangesByUnitAndPath) Len() int           { return len(v) }
+func (v defChangesByUnitAndPath) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v defChangesByUnitAndPath) Less(i, j int) bool { return defLess(v[i].Head, v[j].Head) }
+
+func (c *DeltaDefsCmd) Execute(args []string) error {
+	format, err := c.outputFormat()
+	if err != nil {
+		return err
+	}
+	if c.Range != "" {
+		if c.Base != "" || c.Head != "" {
+			return errors.New("--range can't be used with --base or --head")
+		}
+		base, head, err := parseRevRange(c.Range)
+		if err != nil {
+			return err
+		}
+		c.Base, c.Head = CommitID(base), CommitID(head)
+	}
+	baseSide, headSide, err := c.deltaSides()
+	if err != nil {
+		return err
+	}
+	if c.ShowDiff && (format != "text" || c.PerCommit) {
+		return errors.New("--show-diff can only be used with --format=text and without --per-commit")
+	}
+	var groupKey func(*graph.Def) string
+	if c.GroupBy != "" {
+		if c.PerCommit {
+			return errors.New("--group-by can't be used with --per-commit")
+		}
+		if groupKey, err = defGroupKey(c.GroupBy); err != nil {
+			return err
+		}
+	}
+	if c.PerCommit {
+		return c.executePerCommit(format, baseSide, headSide)
+	}
+	baseDefs, err := baseSide.defs()
+	if err != nil {
+		return err
+	}
+	headDefs, err := headSide.defs()
+	if err != nil {
+		return err
+	}
+	base, head := baseSide.String(), headSide.String()
+
+	d := computeDefsDelta(baseDefs, headDefs, c.Exported)
+	d.Base, d.Head = base, head
+	if !c.NoRenames {
+		d.detectRenames()
+	}
+	if c.Sort != "" {
+		if err := sortDefsDelta(d, c.Sort, deltaXrefs(baseSide)); err != nil {
+			return err
+		}
+	}
+	d.relocate(baseSide, headSide)
+
+	if groupKey != nil {
+		groups := groupDefsDelta(d, groupKey)
+		switch format {
+		case "json":
+			PrintJSON(struct {
+				Base, Head string
+				Groups     []*defsDeltaGroup
+				Xrefs      []*defImpact `json:",omitempty"`
+			}{base, head, groups, d.Xrefs}, "  ")
+			return nil
+		case "markdown":
+			return c.writeReport(func(w io.Writer) error {
+				return writeGroupedDefsDeltaMarkdown(w, d, groups, baseSide.defURL(c.LinkURL), headSide.defURL(c.LinkURL))
+			})
+		case "html":
+			r := newHTMLReport(fmt.Sprintf("Def changes from %s to %s", base, head))
+			r.counts([]string{"Added", "Changed", "Renamed", "Deleted"}, len(d.Added), len(d.Changed), len(d.Renamed), len(d.Deleted))
+			for _, g := range groups {
+				r.defsDelta(g.Key, g.defsDelta, baseSide, headSide, c.LinkURL)
+			}
+			return c.writeReport(r.writeTo)
+		}
+		colorable.Printf("Defs from %s to %s: %s\n", base, head, d.summary())
+		for _, g := range groups {
+			colorable.Printf("\n%s: %s\n", g.Key, g.summary())
+			printDefsDelta(g.defsDelta)
+		}
+		if c.ShowDiff {
+			printDefDiffs(d, baseSide, headSide)
+		}
+		return nil
+	}
+
+	switch format {
+	case "json":
+		PrintJSON(d, "  ")
+		return nil
+	case "markdown":
+		return c.writeReport(func(w io.Writer) error {
+			return writeDefsDeltaMarkdown(w, d, baseSide.defURL(c.LinkURL), headSide.defURL(c.LinkURL))
+		})
+	case "html":
+		r := newHTMLReport(fmt.Sprintf("Def changes from %s to %s", base, head))
+		r.defsDelta("Changes", d, baseSide, headSide, c.LinkURL)
+		return c.writeReport(r.writeTo)
+	}
+
+	colorable.Printf("Defs from %s to %s: %s\n", base, head, d.summary())
+	printDefsDelta(d)
+	if c.ShowDiff {
+		printDefDiffs(d, baseSide, headSide)
+	}
+	return nil
+}
+
+// summary returns the number of each kind of change in d.
+func (d *defsDelta) summary() string {
+	return fmt.Sprintf("%d added, %d changed, %d renamed, %d deleted", len(d.Added), len(d.Changed), len(d.Renamed), len(d.Deleted))
+}
+
+// empty reports whether d has no changes.
+func (d *defsDelta) empty() bool {
+	return len(d.Added)+len(d.Changed)+len(d.Renamed)+len(d.Deleted) == 0
+}
+
+// printDefsDelta prints one line per change in d.
+func printDefsDelta(d *defsDelta) {
+	// note returns the xref counts (if any) to print after a def.
+	note := func(base *graph.Def) string {
+		if n := d.xrefsNote(base); n != "" {
+			return " [" + n + "]"
+		}
+		return ""
+	}
+	for _, def := range d.Added {
+		colorable.Printf("  + %s\n", formatDeltaDef(def))
+	}
+	for _, c := range d.Changed {
+		colorable.Printf("  ~ %s%s\n", formatDeltaDef(c.Head), note(c.Base))
+	}
+	for _, c := range d.Renamed {
+		colorable.Printf("  > %s -> %s%s\n", formatDeltaDefKey(c.Base), formatDeltaDef(c.Head), note(c.Base))
+	}
+	for _, def := range d.Deleted {
+		colorable.Printf("  - %s%s\n", formatDeltaDef(def), note(def))
+	}
+}
+
+// formatDeltaDefKey formats a def's path and source unit for the text
+// output of the delta commands.
+func formatDeltaDefKey(def *graph.Def) string {
+	return fmt.Sprintf("%s (%s %s)", def.Path, def.UnitType, def.Unit)
+}
+
+// formatDeltaDef formats a def for the text output of the delta
+// commands.
+func formatDeltaDef(def *graph.Def) string {
+	return fmt.Sprintf("%s %s (%s %s) %s", def.Kind, def.Path, def.UnitType, def.Unit, def.File)
+}
+
+type DeltaBreakingCmd struct {
+	DeltaCmdCommon
+}
+
+var deltaBreakingCmd DeltaBreakingCmd
+
+// breakingDelta is the output of the breaking command.
+type breakingDelta struct {
+	Base, Head string
+
+	Breaking []*breakingChange
+
+	// NonBreaking is the number of other changes to defs (additions,
+	// and changes to unexported or test defs).
+	NonBreaking int
+}
+
+// breakingChange is a change to a def that may break code that uses
+// it.
+type breakingChange struct {
+	// Def is the def at the base commit.
+	Def *graph.Def
+
+	Reason string
+}
+
+// findBreakingChanges classifies the changes between the defs at two
+// commits as breaking or non-breaking. The breaking changes are
+// sorted by source unit and def path.
+func findBreakingChanges(baseDefs, headDefs []*graph.Def) *breakingDelta {
+	d := computeDefsDelta(baseDefs, headDefs, false)
+	d.detectRenames()
+	isAPI := func(def *graph.Def) bool { return def.Exported && !def.Test }
+
+	var b breakingDelta
+	for _, c := range d.Renamed {
+		if isAPI(c.Base) {
+			b.Breaking = append(b.Breaking, &breakingChange{Def: c.Base, Reason: "renamed to " + formatDeltaDefKey(c.Head)})
+		} else {
+			b.NonBreaking++
+		}
+	}
+	for _, def := range d.Deleted {
+		if isAPI(def) {
+			b.Breaking = append(b.Breaking, &breakingChange{Def: def, Reason: "removed"})
+		} else {
+			b.NonBreaking++
+		}
+	}
+	for _, c := range d.Changed {
+		switch {
+		case !isAPI(c.Base):
+			b.NonBreaking++
+		case c.Base.Kind != c.Head.Kind:
+			b.Breaking = append(b.Breaking, &breakingChange{Def: c.Base, Reason: fmt.Sprintf("kind changed from %s to %s", c.Base.Kind, c.Head.Kind)})
+		default:
+			b.Breaking = append(b.Breaking, &breakingChange{Def: c.Base, Reason: "signature changed"})
+		}
+	}
+	b.NonBreaking += len(d.Added)
+
+	// Defs that were unexported aren't changed according to
+	// defChanged, which only compares their interface.
+	head := defsByUnitAndPath(headDefs)
+	for key, baseDef := range defsByUnitAndPath(baseDefs) {
+		if headDef, present := head[key]; present && isAPI(baseDef) && !headDef.Exported && !defChanged(baseDef, headDef) {
+			b.Breaking = append(b.Breaking, &breakingChange{Def: baseDef, Reason: "unexported"})
+		}
+	}
+
+	sort.Sort(breakingChangesByUnitAndPath(b.Breaking))
+	return &b
+}
+
+// printBreakingDelta prints b as text.
+func printBreakingDelta(b *breakingDelta) {
+	colorable.Printf("Defs from %s to %s: %d breaking changes, %d non-breaking changes\n", b.Base, b.Head, len(b.Breaking), b.NonBreaking)
+	for _, bc := range b.Breaking {
+		colorable.Printf("  %-20s %s\n", bc.Reason+":", formatDeltaDef(bc.Def))
+	}
+}
+
+type breakingChangesByUnitAndPath []*breakingChange
+
+func (v breakingChangesByUnitAndPath) Len() int           { return len(v) }
+func (v breakingChangesByUnitAndPath) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v breakingChangesByUnitAndPath) Less(i, j int) bool { return defLess(v[i].Def, v[j].Def) }
+
+func (c *DeltaBreakingCmd) Execute(args []string) error {
+	format, err := c.outputFormat()
+	if err != nil {
+		return err
+	}
+	baseSide, headSide, err := c.deltaSides()
+	if err != nil {
+		return err
+	}
+	baseDefs, err := baseSide.defs()
+	if err != nil {
+		return err
+	}
+	headDefs, err := headSide.defs()
+	if err != nil {
+		return err
+	}
+	b := findBreakingChanges(baseDefs, headDefs)
+	b.Base, b.Head = baseSide.String(), headSide.String()
+	for _, bc := range b.Breaking {
+		baseSide.relocate(bc.Def)
+	}
+
+	switch format {
+	case "json":
+		PrintJSON(b, "  ")
+	case "markdown":
+		if err := c.writeReport(func(w io.Writer) error { return writeBreakingDeltaMarkdown(w, b, baseSide.defURL(c.LinkURL)) }); err != nil {
+			return err
+		}
+	case "html":
+		r := newHTMLReport(fmt.Sprintf("Breaking changes from %s to %s", b.Base, b.Head))
+		r.breakingDelta(b, baseSide, c.LinkURL)
+		if err := c.writeReport(r.writeTo); err != nil {
+			return err
+		}
+	default:
+		printBreakingDelta(b)
+	}
+
+	if len(b.Breaking) > 0 {
+		return fmt.Errorf("found %d breaking changes", len(b.Breaking))
+	}
+	return nil
+}
+
+type DeltaDepsCmd struct {
+	DeltaCmdCommon
+}
+
+var deltaDepsCmd DeltaDepsCmd
+
+// depsDelta is the output of the deps command.
+type depsDelta struct {
+	Base, Head string
+
+	Added   []*depChange
+	Removed []*depChange
+	Changed []*depChange
+}
+
+// depChange is a change to the revisions of a dependency repo that
+// are depended on. BaseRevs or HeadRevs is empty if the dependency
+// was added or removed, respectively.
+type depChange struct {
+	Repo               string
+	BaseRevs, HeadRevs []string `json:",omitempty"`
+}
+
+// deps returns the resolved dependencies of the side's commit.
+func (s *deltaSide) deps() ([]*dep.Resolution, error) {
+	deps, found, err := getDepResolutions(commandContext{repo: s.repo, commitFS: s.bs.Commit(s.commitID)})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", s.repo.RootDir, err)
+	}
+	if !found {
+		return nil, fmt.Errorf("%s: no dependency data for commit %s (check it out and run `src make` first)", s.repo.RootDir, s.commitID)
+	}
+	return deps, nil
+}
+
+// depRevs returns the sorted revisions (or, if a revision isn't known,
+// versions) of each repo that deps resolve to, keyed by repo URI.
+func depRevs(deps []*dep.Resolution) map[string][]string {
+	revs := map[string][]string{}
+	seen := map[string]bool{}
+	for _, d := range deps {
+		if d.Target == nil || d.Target.ToRepoCloneURL == "" {
+			continue
+		}
+		repo, err := graph.TryMakeURI(d.Target.ToRepoCloneURL)
+		if err != nil {
+			repo = d.Target.ToRepoCloneURL
+		}
+		rev := d.Target.ToRevSpec
+		if rev == "" {
+			rev = d.Target.ToVersionString
+		}
+		if _, present := revs[repo]; !present {
+			revs[repo] = nil
+		}
+		if rev != "" && !seen[repo+"@"+rev] {
+			seen[repo+"@"+rev] = true
+			revs[repo] = append(revs[repo], rev)
+		}
+	}
+	for _, v := range revs {
+		sort.Strings(v)
+	}
+	return revs
+}
+
+// computeDepsDelta compares the dependencies at two commits. The
+// lists are sorted by repo.
+func computeDepsDelta(baseDeps, headDeps []*dep.Resolution) *depsDelta {
+	base, head := depRevs(baseDeps), depRevs(headDeps)
+	var d depsDelta
+	for repo, headRevs := range head {
+		if baseRevs, present := base[repo]; !present {
+			d.Added = append(d.Added, &depChange{Repo: repo, HeadRevs: headRevs})
+		} else if strings.Join(baseRevs, " ") != strings.Join(headRevs, " ") {
+			d.Changed = append(d.Changed, &depChange{Repo: repo, BaseRevs: baseRevs, HeadRevs: headRevs})
+		}
+	}
+	for repo, baseRevs := range base {
+		if _, present := head[repo]; !present {
+			d.Removed = append(d.Removed, &depChange{Repo: repo, BaseRevs: baseRevs})
+		}
+	}
+	sort.Sort(depChangesByRepo(d.Added))
+	sort.Sort(depChangesByRepo(d.Removed))
+	sort.Sort(depChangesByRepo(d.Changed))
+	return &d
+}
+
+type depChangesByRepo []*depChange
+
+func (v depChangesByRepo) Len() int           { return len(v) }
+func (v depChangesByRepo) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v depChangesByRepo) Less(i, j int) bool { return v[i].Repo < v[j].Repo }
+
+func (c *DeltaDepsCmd) Execute(args []string) error {
+	format, err := c.outputFormat()
+	if err != nil {
+		return err
+	}
+	baseSide, headSide, err := c.deltaSides()
+	if err != nil {
+		return err
+	}
+	baseDeps, err := baseSide.deps()
+	if err != nil {
+		return err
+	}
+	headDeps, err := headSide.deps()
+	if err != nil {
+		return err
+	}
+
+	d := computeDepsDelta(baseDeps, headDeps)
+	d.Base, d.Head = baseSide.String(), headSide.String()
+
+	switch format {
+	case "json":
+		PrintJSON(d, "  ")
+		return nil
+	case "markdown":
+		return c.writeReport(func(w io.Writer) error { return writeDepsDeltaMarkdown(w, d, c.LinkURL) })
+	case "html":
+		r := newHTMLReport(fmt.Sprintf("Dependency changes from %s to %s", d.Base, d.Head))
+		r.depsDelta(d)
+		return c.writeReport(r.writeTo)
+	}
+
+	printDepsDelta(d)
+	return nil
+}
+
+// printDepsDelta prints d as text.
+func printDepsDelta(d *depsDelta) {
+	colorable.Printf("Dependencies from %s to %s: %d added, %d changed, %d removed\n", d.Base, d.Head, len(d.Added), len(d.Changed), len(d.Removed))
+	for _, c := range d.Added {
+		colorable.Printf("  + %s %s\n", c.Repo, formatDepRevs(c.HeadRevs))
+	}
+	for _, c := range d.Changed {
+		colorable.Printf("  ~ %s %s -> %s\n", c.Repo, formatDepRevs(c.BaseRevs), formatDepRevs(c.HeadRevs))
+	}
+	for _, c := range d.Removed {
+		colorable.Printf("  - %s %s\n", c.Repo, formatDepRevs(c.BaseRevs))
+	}
+}
+
+// formatDepRevs formats the revisions of a dependency repo for the
+// output of the deps command.
+func formatDepRevs(revs []string) string {
+	if len(revs) == 0 {
+		return "(unknown revision)"
+	}
+	return strings.Join(revs, ", ")
+}
+
+type DeltaDocsCmd struct {
+	DeltaCmdCommon
+
+	Check bool `long:"check" description:"exit with an error if any exported defs added or changed at head lack docs"`
+}
+
+var deltaDocsCmd DeltaDocsCmd
+
+// docsDelta is the output of the docs command.
+type docsDelta struct {
+	Base, Head string
+
+	Changed []*docChange
+
+	// Undocumented is the exported (non-test) defs that were added or
+	// changed at head and have no docs.
+	Undocumented []*graph.Def
+}
+
+// docChange is a change to a def's docs.
+type docChange struct {
+	// Def is the def at the head commit.
+	Def *graph.Def
+
+	BaseDoc, HeadDoc string
+}
+
+// docsByUnitAndPath returns the data of each of docs by format, keyed
+// by the source unit and path of the def they document.
+func docsByUnitAndPath(docs []*graph.Doc) map[graph.DefKey]map[string]string {
+	m := map[graph.DefKey]map[string]string{}
+	for _, doc := range docs {
+		key := graph.DefKey{UnitType: doc.UnitType, Unit: doc.Unit, Path: doc.Path}
+		if m[key] == nil {
+			m[key] = map[string]string{}
+		}
+		m[key][doc.Format] = doc.Data
+	}
+	return m
+}
+
+// docText returns the plain text doc among a def's docs (by format),
+// or, if there is none, the doc in the first format.
+func docText(docs map[string]string) string {
+	if doc, present := docs["text/plain"]; present {
+		return doc
+	}
+	formats := make([]string, 0, len(docs))
+	for format := range docs {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	if len(formats) == 0 {
+		return ""
+	}
+	return docs[formats[0]]
+}
+
+// docsEqual returns whether a and b contain the same docs.
+func docsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for format, doc := range a {
+		if bdoc, present := b[format]; !present || doc != bdoc {
+			return false
+		}
+	}
+	return true
+}
+
+// computeDocsDelta compares the docs at two commits. Defs that only
+// exist at one of the commits are only considered at head, for missing
+// docs. Local defs are ignored. The lists are sorted by source unit and
+// def path.
+func computeDocsDelta(base, head *graph.Output) *docsDelta {
+	baseDefs := defsByUnitAndPath(base.Defs)
+	baseDocs, headDocs := docsByUnitAndPath(base.Docs), docsByUnitAndPath(head.Docs)
+
+	var d docsDelta
+	for key, def := range defsByUnitAndPath(head.Defs) {
+		if def.Local {
+			continue
+		}
+		baseDef, present := baseDefs[key]
+		if present && !docsEqual(baseDocs[key], headDocs[key]) {
+			d.Changed = append(d.Changed, &docChange{Def: def, BaseDoc: docText(baseDocs[key]), HeadDoc: docText(headDocs[key])})
+		}
+		if def.Exported && !def.Test && len(headDocs[key]) == 0 && (!present || defChanged(baseDef, def)) {
+			d.Undocumented = append(d.Undocumented, def)
+		}
+	}
+
+	sort.Sort(docChangesByUnitAndPath(d.Changed))
+	sort.Sort(defsByUnitAndPathOrder(d.Undocumented))
+	return &d
+}
+
+type docChangesByUnitAndPath []*docChange
+
+func (v docChangesByUnitAndPath) Len() int           { return len(v) }
+func (v docChangesByUnitAndPath) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v docChangesByUnitAndPath) Less(i, j int) bool { return defLess(v[i].Def, v[j].Def) }
+
+func (c *DeltaDocsCmd) Execute(args []string) error {
+	format, err := c.outputFormat()
+	if err != nil {
+		return err
+	}
+	baseSide, headSide, err := c.deltaSides()
+	if err != nil {
+		return err
+	}
+	baseGraph, err := baseSide.graph()
+	if err != nil {
+		return err
+	}
+	headGraph, err := headSide.graph()
+	if err != nil {
+		return err
+	}
+
+	d := computeDocsDelta(baseGraph, headGraph)
+	d.Base, d.Head = baseSide.String(), headSide.String()
+	d.relocate(headSide)
+
+	switch format {
+	case "json":
+		PrintJSON(d, "  ")
+	case "markdown":
+		if err := c.writeReport(func(w io.Writer) error { return writeDocsDeltaMarkdown(w, d, headSide.defURL(c.LinkURL)) }); err != nil {
+			return err
+		}
+	case "html":
+		r := newHTMLReport(fmt.Sprintf("Doc changes from %s to %s", d.Base, d.Head))
+		r.docsDelta(d, headSide, c.LinkURL)
+		if err := c.writeReport(r.writeTo); err != nil {
+			return err
+		}
+	default:
+		printDocsDelta(d)
+	}
+
+	if c.Check && len(d.Undocumented) > 0 {
+		return fmt.Errorf("found %d exported defs without docs", len(d.Undocumented))
+	}
+	return nil
+}
+
+// relocate converts the files of the defs in d (see
+// (*deltaSide).relocate).
+func (d *docsDelta) relocate(head *deltaSide) {
+	for _, dc := range d.Changed {
+		head.relocate(dc.Def)
+	}
+	head.relocate(d.Undocumented...)
+}
+
+// printDocsDelta prints d as text.
+func printDocsDelta(d *docsDelta) {
+	colorable.Printf("Docs from %s to %s: %d changed, %d exported defs added or changed without docs\n", d.Base, d.Head, len(d.Changed), len(d.Undocumented))
+	for _, dc := range d.Changed {
+		colorable.Printf("  ~ %s\n", formatDeltaDef(dc.Def))
+	}
+	for _, def := range d.Undocumented {
+		colorable.Printf("  ! %s\n", formatDeltaDef(def))
+	}
+}
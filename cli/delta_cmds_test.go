@@ -0,0 +1,324 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestComputeDefsDelta(t *testing.T) {
+	def := func(path, kind string, exported bool) *graph.Def {
+		return &graph.Def{
+			DefKey:   graph.DefKey{UnitType: "t", Unit: "u", Path: path},
+			Name:     path,
+			Kind:     kind,
+			Exported: exported,
+		}
+	}
+	local := def("l", "var", false)
+	local.Local = true
+	moved := def("M", "func", true)
+	moved.File = "new.go"
+
+	base := []*graph.Def{def("A", "func", true), def("b", "func", false), def("C", "func", true), def("M", "func", true), def("D", "var", true), local}
+	head := []*graph.Def{def("A", "func", true), def("b", "var", false), def("C", "type", true), moved, def("E", "func", true), def("f", "func", false)}
+
+	paths := func(defs []*graph.Def) []string {
+		var ps []string
+		for _, d := range defs {
+			ps = append(ps, d.Path)
+		}
+		return ps
+	}
+	changedPaths := func(changes []*defChange) []string {
+		var ps []string
+		for _, c := range changes {
+			ps = append(ps, c.Head.Path)
+		}
+		return ps
+	}
+
+	tests := []struct {
+		exported                bool
+		added, changed, deleted string
+	}{
+		{exported: false, added: "[E f]", changed: "[C b]", deleted: "[D]"},
+		{exported: true, added: "[E]", changed: "[C]", deleted: "[D]"},
+	}
+	for _, test := range tests {
+		d := computeDefsDelta(base, head, test.exported)
+		if got := fmt.Sprint(paths(d.Added)); got != test.added {
+			t.Errorf("exported=%v: got added %s, want %s", test.exported, got, test.added)
+		}
+		if got := fmt.Sprint(changedPaths(d.Changed)); got != test.changed {
+			t.Errorf("exported=%v: got changed %s, want %s", test.exported, got, test.changed)
+		}
+		if got := fmt.Sprint(paths(d.Deleted)); got != test.deleted {
+			t.Errorf("exported=%v: got deleted %s, want %s", test.exported, got, test.deleted)
+		}
+	}
+}
+
+func TestDetectRenames(t *testing.T) {
+	def := func(unit, name, data string, size uint32) *graph.Def {
+		return &graph.Def{
+			DefKey:   graph.DefKey{UnitType: "t", Unit: unit, Path: name},
+			Name:     name,
+			Kind:     "func",
+			Exported: true,
+			Data:     []byte(data),
+			DefStart: 100,
+			DefEnd:   100 + size,
+		}
+	}
+	base := []*graph.Def{
+		def("u", "Old", `{"Name":"Old","Sig":"(int)"}`, 20),
+		def("u", "Moved", `{"Name":"Moved"}`, 10),
+		def("u", "Gone", `{"Name":"Gone","Sig":"(string)"}`, 30),
+		def("u", "Dup1", `{"Dup":true}`, 5),
+		def("u", "Dup2", `{"Dup":true}`, 5),
+		def("u", "NoData", "", 40),
+	}
+	head := []*graph.Def{
+		def("u", "NewName", `{"Name":"NewName","Sig":"(int)"}`, 24), // 4 bytes longer name
+		def("v", "Moved", `{"Name":"Moved"}`, 10),
+		def("u", "Other", `{"Name":"Other","Sig":"(bool)"}`, 31),
+		def("u", "Dup3", `{"Dup":true}`, 5),
+		def("u", "OtherNoData", "", 45), // same size, less its name
+	}
+
+	d := computeDefsDelta(base, head, false)
+	d.detectRenames()
+	var renamed []string
+	for _, c := range d.Renamed {
+		renamed = append(renamed, c.Base.Unit+"/"+c.Base.Path+"->"+c.Head.Unit+"/"+c.Head.Path)
+	}
+	if got, want := strings.Join(renamed, " "), "u/Old->u/NewName u/Moved->v/Moved"; got != want {
+		t.Errorf("got renamed %q, want %q", got, want)
+	}
+	// Dup1 and Dup2 are indistinguishable, so neither is paired with
+	// Dup3, and defs without data are never paired.
+	if got, want := fmt.Sprint(len(d.Added), len(d.Deleted)), "3 4"; got != want {
+		t.Errorf("got %s added and deleted, want %s", got, want)
+	}
+
+	b := findBreakingChanges(base, head)
+	var reasons []string
+	for _, bc := range b.Breaking {
+		reasons = append(reasons, bc.Def.Path+": "+bc.Reason)
+	}
+	if got, want := strings.Join(reasons, "; "), "Dup1: removed; Dup2: removed; Gone: removed; Moved: renamed to Moved (t v); NoData: removed; Old: renamed to NewName (t u)"; got != want {
+		t.Errorf("got breaking changes %q, want %q", got, want)
+	}
+}
+
+func TestFindBreakingChanges(t *testing.T) {
+	def := func(path, kind string, exported bool, data string) *graph.Def {
+		return &graph.Def{
+			DefKey:   graph.DefKey{UnitType: "t", Unit: "u", Path: path},
+			Name:     path,
+			Kind:     kind,
+			Exported: exported,
+			Data:     []byte(data),
+		}
+	}
+	test := def("T", "func", true, "")
+	test.Test = true
+
+	base := []*graph.Def{
+		def("Removed", "func", true, ""),
+		def("removed", "func", false, ""),
+		def("Kind", "func", true, ""),
+		def("Sig", "func", true, `"func(int)"`),
+		def("Unexported", "func", true, ""),
+		def("Same", "func", true, `"func()"`),
+		def("changed", "func", false, `"func()"`),
+		test,
+	}
+	head := []*graph.Def{
+		def("Kind", "var", true, ""),
+		def("Sig", "func", true, `"func(string)"`),
+		def("Unexported", "func", false, ""),
+		def("Same", "func", true, `"func()"`),
+		def("changed", "func", false, `"func(int)"`),
+		def("Added", "func", true, ""),
+	}
+
+	b := findBreakingChanges(base, head)
+	var got []string
+	for _, c := range b.Breaking {
+		got = append(got, c.Def.Path+": "+c.Reason)
+	}
+	want := []string{
+		"Kind: kind changed from func to var",
+		"Removed: removed",
+		"Sig: signature changed",
+		"Unexported: unexported",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got breaking changes %q, want %q", got, want)
+	}
+	// removed (unexported), T (test), changed (unexported), and Added.
+	if want := 4; b.NonBreaking != want {
+		t.Errorf("got %d non-breaking changes, want %d", b.NonBreaking, want)
+	}
+}
+
+func TestOpenDeltaSide(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "srclib-delta-side")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=a", "-c", "user.email=a@example.com", "commit", "-q", "--allow-empty", "-m", "1"},
+		{"tag", "v1"},
+		{"-c", "user.name=a", "-c", "user.email=a@example.com", "commit", "-q", "--allow-empty", "-m", "2"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s\n%s", args, err, out)
+		}
+	}
+
+	head, err := openDeltaSide(Directory(dir), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := openDeltaSide(Directory(dir), "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(base.commitID) != 40 || base.commitID == head.commitID {
+		t.Errorf("got base commit %q and head commit %q, want distinct commit IDs", base.commitID, head.commitID)
+	}
+	if want := dir + "@" + base.commitID; base.String() != want {
+		t.Errorf("got %q, want %q", base.String(), want)
+	}
+	if _, err := base.defs(); err == nil || !strings.Contains(err.Error(), "no build data") {
+		t.Errorf("got error %v, want a no build data error", err)
+	}
+	if _, _, _, _, err := fetchDeltaData(base, head, 2); err == nil {
+		t.Error("got no error fetching delta data without build data")
+	}
+	if _, err := openDeltaSide(Directory(dir), "nonexistent"); err == nil {
+		t.Error("got no error resolving a nonexistent revision")
+	}
+}
+
+func TestComputeDepsDelta(t *testing.T) {
+	res := func(cloneURL, rev, version string) *dep.Resolution {
+		return &dep.Resolution{Target: &dep.ResolvedTarget{ToRepoCloneURL: cloneURL, ToRevSpec: rev, ToVersionString: version}}
+	}
+	base := []*dep.Resolution{
+		res("https://example.com/same", "a", ""),
+		res("https://example.com/same", "a", ""),
+		res("https://example.com/upgraded", "v1", ""),
+		res("https://example.com/version", "", "1.0"),
+		res("https://example.com/removed", "r", ""),
+		{Error: "unresolved"},
+	}
+	head := []*dep.Resolution{
+		res("https://example.com/same.git", "a", ""),
+		res("https://example.com/upgraded", "v2", ""),
+		res("https://example.com/version", "", "1.1"),
+		res("https://example.com/added", "", ""),
+	}
+
+	d := computeDepsDelta(base, head)
+	format := func(changes []*depChange) string {
+		var s []string
+		for _, c := range changes {
+			s = append(s, fmt.Sprintf("%s%v%v", c.Repo, c.BaseRevs, c.HeadRevs))
+		}
+		return strings.Join(s, " ")
+	}
+	if got, want := format(d.Added), "example.com/added[][]"; got != want {
+		t.Errorf("got added %q, want %q", got, want)
+	}
+	if got, want := format(d.Removed), "example.com/removed[r][]"; got != want {
+		t.Errorf("got removed %q, want %q", got, want)
+	}
+	if got, want := format(d.Changed), "example.com/upgraded[v1][v2] example.com/version[1.0][1.1]"; got != want {
+		t.Errorf("got changed %q, want %q", got, want)
+	}
+}
+
+func TestComputeDocsDelta(t *testing.T) {
+	def := func(path string, exported bool, data string) *graph.Def {
+		return &graph.Def{DefKey: graph.DefKey{UnitType: "t", Unit: "u", Path: path}, Exported: exported, Data: []byte(data)}
+	}
+	doc := func(path, format, data string) *graph.Doc {
+		return &graph.Doc{DefKey: graph.DefKey{UnitType: "t", Unit: "u", Path: path}, Format: format, Data: data}
+	}
+	base := &graph.Output{
+		Defs: []*graph.Def{def("Same", true, ""), def("Edited", true, ""), def("Dropped", true, ""), def("Old", true, ""), def("Sig", true, "a")},
+		Docs: []*graph.Doc{doc("Same", "text/plain", "same"), doc("Edited", "text/plain", "old"), doc("Edited", "text/html", "<p>old</p>"), doc("Dropped", "text/plain", "doc")},
+	}
+	head := &graph.Output{
+		Defs: []*graph.Def{def("Same", true, ""), def("Edited", true, ""), def("Dropped", true, ""), def("Old", true, ""), def("Sig", true, "b"), def("New", true, ""), def("newUnexported", false, "")},
+		Docs: []*graph.Doc{doc("Same", "text/plain", "same"), doc("Edited", "text/plain", "new"), doc("Edited", "text/html", "<p>new</p>")},
+	}
+
+	d := computeDocsDelta(base, head)
+	var changed []string
+	for _, dc := range d.Changed {
+		changed = append(changed, fmt.Sprintf("%s:%q->%q", dc.Def.Path, dc.BaseDoc, dc.HeadDoc))
+	}
+	if got, want := strings.Join(changed, " "), `Dropped:"doc"->"" Edited:"old"->"new"`; got != want {
+		t.Errorf("got changed %s, want %s", got, want)
+	}
+	// Old is undocumented but unchanged, so it isn't reported.
+	var undocumented []string
+	for _, def := range d.Undocumented {
+		undocumented = append(undocumented, def.Path)
+	}
+	if got, want := strings.Join(undocumented, " "), "New Sig"; got != want {
+		t.Errorf("got undocumented %q, want %q", got, want)
+	}
+}
+
+func TestComputeImpactScore(t *testing.T) {
+	def := func(path string) *graph.Def {
+		return &graph.Def{DefKey: graph.DefKey{UnitType: "t", Unit: "u", Path: path}}
+	}
+	d := &defsDelta{
+		Added:   []*graph.Def{def("New")},
+		Changed: []*defChange{{Base: def("A"), Head: def("A")}},
+		Deleted: []*graph.Def{def("B"), def("C")},
+	}
+	refs := map[string][]*graph.Ref{
+		"A": {{Repo: "r1"}},
+		"B": {{Repo: "r1"}, {Repo: "r2"}, {Repo: "r2"}},
+	}
+	xrefs := func(def *graph.Def) ([]*graph.Ref, error) { return refs[def.Path], nil }
+
+	s, err := computeImpactScore(d, xrefs, impactWeights{Def: 1, Xref: 0.5, Dependent: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ChangedDefs != 3 || s.Xrefs != 4 || s.Dependents != 2 {
+		t.Errorf("got %d changed defs, %d xrefs, %d dependents, want 3, 4, 2", s.ChangedDefs, s.Xrefs, s.Dependents)
+	}
+	if want := 3 + 2 + 20.0; s.Score != want {
+		t.Errorf("got score %v, want %v", s.Score, want)
+	}
+	var defs []string
+	for _, di := range s.Defs {
+		defs = append(defs, fmt.Sprintf("%s:%s:%d:%s", di.Def.Path, di.Change, di.Xrefs, strings.Join(di.Dependents, ",")))
+	}
+	if got, want := strings.Join(defs, " "), "B:deleted:3:r1,r2 A:changed:1:r1"; got != want {
+		t.Errorf("got defs %q, want %q", got, want)
+	}
+}
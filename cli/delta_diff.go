@@ -0,0 +1,241 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// defDiffContext is the number of unchanged lines shown around each
+// change in def diffs.
+const defDiffContext = 3
+
+// maxDefDiffCells bounds the size (in lines of the base def times
+// lines of the head def, after removing their common prefix and
+// suffix) of the table used to diff two defs. Larger defs are shown
+// as entirely replaced.
+const maxDefDiffCells = 4 << 20
+
+// defText returns the definition of def (its DefStart to DefEnd byte
+// range) at the side's commit and the 1-based line number in its file
+// that the definition starts on. It returns false if the definition
+// can't be read.
+func (s *deltaSide) defText(def *graph.Def) (text string, line int, ok bool) {
+	if s.files == nil {
+		s.files = map[string][]byte{}
+	}
+	file := s.repoFile(def)
+	key := s.commitID + ":" + file
+	data, present := s.files[key]
+	if !present {
+		data, _ = fileAtCommit(s.repo.VCSType, s.repo.RootDir, s.commitID, file)
+		s.files[key] = data
+	}
+	if def.DefEnd <= def.DefStart || int(def.DefEnd) > len(data) {
+		return "", 0, false
+	}
+	line = bytes.Count(data[:def.DefStart], []byte{'\n'}) + 1
+	return string(data[def.DefStart:def.DefEnd]), line, true
+}
+
+// printDefDiffs prints a unified diff of the definition of each
+// changed and renamed def in d, from the base to the head commit.
+func printDefDiffs(d *defsDelta, base, head *deltaSide) {
+	changes := append(append([]*defChange{}, d.Changed...), d.Renamed...)
+	for _, c := range changes {
+		colorable.Println()
+		colorable.Println(colorable.Bold("~ " + formatDeltaDefKey(c.Head)))
+		baseText, baseLine, baseOK := base.defText(c.Base)
+		headText, headLine, headOK := head.defText(c.Head)
+		switch {
+		case !baseOK || !headOK:
+			colorable.Println("  (definition not available)")
+		case baseText == headText:
+			colorable.Println("  (definition text unchanged)")
+		default:
+			diff := unifiedDiff("a/"+c.Base.File, "b/"+c.Head.File, baseText, headText, baseLine, headLine, defDiffContext)
+			colorable.Print(colorizeUnifiedDiff(diff))
+		}
+	}
+}
+
+// diffOp is a line of a line-based diff: an unchanged (' '), deleted
+// ('-'), or added ('+') line.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines returns the edits that turn the lines a into the lines b,
+// using a longest common subsequence of the lines (after removing the
+// common prefix and suffix). If that would take too much memory (see
+// maxDefDiffCells), all of a is deleted and all of b is added.
+func diffLines(a, b []string) []diffOp {
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []diffOp
+	for _, l := range a[:prefix] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(ma)*len(mb) > maxDefDiffCells {
+		for _, l := range ma {
+			ops = append(ops, diffOp{'-', l})
+		}
+		for _, l := range mb {
+			ops = append(ops, diffOp{'+', l})
+		}
+	} else {
+		// lcs[i][j] is the length of the LCS of ma[i:] and mb[j:].
+		lcs := make([][]int, len(ma)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(mb)+1)
+		}
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(ma) || j < len(mb) {
+			switch {
+			case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+				ops = append(ops, diffOp{' ', ma[i]})
+				i++
+				j++
+			case j == len(mb) || (i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]):
+				ops = append(ops, diffOp{'-', ma[i]})
+				i++
+			default:
+				ops = append(ops, diffOp{'+', mb[j]})
+				j++
+			}
+		}
+	}
+	for _, l := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	return ops
+}
+
+// unifiedDiff returns a unified diff (with context lines of context)
+// from a, whose first line is line aLine of the file aName, to b,
+// whose first line is line bLine of bName. It returns the empty
+// string if a and b are equal.
+func unifiedDiff(aName, bName, a, b string, aLine, bLine, context int) string {
+	ops := diffLines(strings.Split(a, "\n"), strings.Split(b, "\n"))
+
+	var buf bytes.Buffer
+	for start := 0; start < len(ops); {
+		// Find the next change, and the extent of the hunk around it
+		// (which includes later changes that are close enough that
+		// their context would overlap).
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		last := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				last = i
+			} else if i-last > 2*context {
+				break
+			}
+		}
+		hunkStart, hunkEnd := first-context, last+context+1
+		if hunkStart < start {
+			hunkStart = start
+		}
+		if hunkEnd > len(ops) {
+			hunkEnd = len(ops)
+		}
+
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", aName, bName)
+		}
+		// Count the lines of a and b before and in the hunk.
+		aStart, bStart := aLine, bLine
+		for _, op := range ops[:hunkStart] {
+			if op.kind != '+' {
+				aStart++
+			}
+			if op.kind != '-' {
+				bStart++
+			}
+		}
+		var aCount, bCount int
+		for _, op := range ops[hunkStart:hunkEnd] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, op := range ops[hunkStart:hunkEnd] {
+			fmt.Fprintf(&buf, "%c%s\n", op.kind, op.line)
+		}
+		start = hunkEnd
+	}
+	return buf.String()
+}
+
+// hunkRange formats the start line and line count of one side of a
+// unified diff hunk.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		// By convention, an empty range starts at the line before
+		// the hunk.
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// colorizeUnifiedDiff is like ColorizeDiff, but it also highlights the
+// file headers and hunk headers of a unified diff.
+func colorizeUnifiedDiff(diff string) string {
+	lines := strings.SplitAfter(diff, "\n")
+	for i, line := range lines {
+		text := strings.TrimSuffix(line, "\n")
+		switch {
+		case text == "":
+			continue
+		case i < 2 && (strings.HasPrefix(text, "--- ") || strings.HasPrefix(text, "+++ ")):
+			text = colorable.Bold(text)
+		case strings.HasPrefix(text, "@@"):
+			text = colorable.Cyan(text)
+		case text[0] == '-':
+			text = colorable.Red(text)
+		case text[0] == '+':
+			text = colorable.Green(text)
+		}
+		if strings.HasSuffix(line, "\n") {
+			text += "\n"
+		}
+		lines[i] = text
+	}
+	return strings.Join(lines, "")
+}
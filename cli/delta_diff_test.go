@@ -0,0 +1,28 @@
+package cli
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{a: "x", b: "x", want: ""},
+		{
+			a:    "func f() {\n\treturn 1\n}",
+			b:    "func f() {\n\treturn 2\n}",
+			want: "--- a/f.go\n+++ b/f.go\n@@ -11,3 +21,3 @@\n func f() {\n-\treturn 1\n+\treturn 2\n }\n",
+		},
+		{
+			// Changes far apart are in separate hunks.
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10",
+			b:    "0\n1\n2\n3\n4\n5\n6\n7\n8\n10",
+			want: "--- a/f.go\n+++ b/f.go\n@@ -11,3 +21,4 @@\n+0\n 1\n 2\n 3\n@@ -16,5 +27,4 @@\n 6\n 7\n 8\n-9\n 10\n",
+		},
+	}
+	for _, test := range tests {
+		if got := unifiedDiff("a/f.go", "b/f.go", test.a, test.b, 11, 21, 3); got != test.want {
+			t.Errorf("unifiedDiff(%q, %q):\ngot:\n%s\nwant:\n%s", test.a, test.b, got, test.want)
+		}
+	}
+}
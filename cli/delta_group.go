@@ -0,0 +1,212 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// defsDeltaGroup is the part of a defsDelta whose defs share a file,
+// source unit, or kind (as given by --group-by).
+type defsDeltaGroup struct {
+	Key string
+
+	*defsDelta
+}
+
+// defGroupKey returns the func that returns the key of the group that
+// a def belongs to when grouping by the given --group-by value.
+func defGroupKey(by string) (func(*graph.Def) string, error) {
+	switch by {
+	case "file":
+		return func(def *graph.Def) string { return def.File }, nil
+	case "unit":
+		return func(def *graph.Def) string { return def.UnitType + " " + def.Unit }, nil
+	case "kind":
+		return func(def *graph.Def) string { return def.Kind }, nil
+	default:
+		return nil, fmt.Errorf("unrecognized --group-by value: %q (valid values are file, unit, kind)", by)
+	}
+}
+
+// groupDefsDelta splits d into groups of defs with the same key,
+// sorted by key. Added, changed, and renamed defs are grouped by their
+// head version, and deleted defs by their base version. The order of
+// the defs in each group is preserved.
+func groupDefsDelta(d *defsDelta, key func(*graph.Def) string) []*defsDeltaGroup {
+	groups := map[string]*defsDeltaGroup{}
+	group := func(def *graph.Def) *defsDelta {
+		k := key(def)
+		g, present := groups[k]
+		if !present {
+			g = &defsDeltaGroup{Key: k, defsDelta: &defsDelta{Base: d.Base, Head: d.Head, xrefsByDef: d.xrefsByDef}}
+			groups[k] = g
+		}
+		return g.defsDelta
+	}
+	for _, def := range d.Added {
+		g := group(def)
+		g.Added = append(g.Added, def)
+	}
+	for _, c := range d.Changed {
+		g := group(c.Head)
+		g.Changed = append(g.Changed, c)
+	}
+	for _, c := range d.Renamed {
+		g := group(c.Head)
+		g.Renamed = append(g.Renamed, c)
+	}
+	for _, def := range d.Deleted {
+		g := group(def)
+		g.Deleted = append(g.Deleted, def)
+	}
+
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	sorted := make([]*defsDeltaGroup, len(keys))
+	for i, k := range keys {
+		sorted[i] = groups[k]
+	}
+	return sorted
+}
+
+// sortDefsDelta sorts each list of defs in d by the given --sort value:
+// "name" sorts by def name, "xrefs" by the number of refs from other
+// repos (most first), and "impact" by the impact score that the
+// impact-score command would give each def by default (highest
+// first). The xrefs func returns the refs from other repos to a def at
+// the base commit; added defs have none. When sorting by xrefs or
+// impact, the counts are also recorded in d (see defsDelta.Xrefs), so
+// that they're shown with the defs.
+func sortDefsDelta(d *defsDelta, by string, xrefs func(*graph.Def) ([]*graph.Ref, error)) error {
+	if by == "name" {
+		less := func(a, b *graph.Def) bool {
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			return defLess(a, b)
+		}
+		d.sort(func(a, b *defChange) bool { return less(a.def(), b.def()) })
+		return nil
+	}
+	if by != "xrefs" && by != "impact" {
+		return fmt.Errorf("unrecognized --sort value: %q (valid values are name, xrefs, impact)", by)
+	}
+
+	d.xrefsByDef = map[*graph.Def]*defImpact{}
+	var changed []*defImpact
+	for _, c := range d.Changed {
+		changed = append(changed, &defImpact{Def: c.Base, Change: "changed"})
+	}
+	for _, c := range d.Renamed {
+		changed = append(changed, &defImpact{Def: c.Base, Change: "renamed"})
+	}
+	for _, def := range d.Deleted {
+		changed = append(changed, &defImpact{Def: def, Change: "deleted"})
+	}
+	for _, di := range changed {
+		refs, err := xrefs(di.Def)
+		if err != nil {
+			return err
+		}
+		repos := map[string]struct{}{}
+		for _, ref := range refs {
+			if _, present := repos[ref.Repo]; !present {
+				repos[ref.Repo] = struct{}{}
+				di.Dependents = append(di.Dependents, ref.Repo)
+			}
+		}
+		sort.Strings(di.Dependents)
+		di.Xrefs = len(refs)
+		d.xrefsByDef[di.Def] = di
+	}
+
+	w := defaultImpactWeights
+	score := func(di *defImpact) float64 {
+		if by == "xrefs" {
+			return float64(di.Xrefs)
+		}
+		return w.Def + w.Xref*float64(di.Xrefs) + w.Dependent*float64(len(di.Dependents))
+	}
+	d.sort(func(a, b *defChange) bool {
+		if a.Base == nil || b.Base == nil {
+			return a.Base != nil // added defs can't break anything
+		}
+		return score(d.xrefsByDef[a.Base]) > score(d.xrefsByDef[b.Base])
+	})
+
+	d.Xrefs = nil
+	for _, di := range changed {
+		if di.Xrefs > 0 {
+			d.Xrefs = append(d.Xrefs, di)
+		}
+	}
+	sort.Stable(defImpactsByScore{d.Xrefs, score})
+	return nil
+}
+
+type defImpactsByScore struct {
+	defs  []*defImpact
+	score func(*defImpact) float64
+}
+
+func (v defImpactsByScore) Len() int           { return len(v.defs) }
+func (v defImpactsByScore) Swap(i, j int)      { v.defs[i], v.defs[j] = v.defs[j], v.defs[i] }
+func (v defImpactsByScore) Less(i, j int) bool { return v.score(v.defs[i]) > v.score(v.defs[j]) }
+
+// def returns the head version of the def, or the base version if it
+// was deleted.
+func (c *defChange) def() *graph.Def {
+	if c.Head != nil {
+		return c.Head
+	}
+	return c.Base
+}
+
+// sort stably sorts each list of defs in d by less. Added and deleted
+// defs are passed to less as defChanges with only Head or Base set,
+// respectively.
+func (d *defsDelta) sort(less func(a, b *defChange) bool) {
+	added := make([]*defChange, len(d.Added))
+	for i, def := range d.Added {
+		added[i] = &defChange{Head: def}
+	}
+	deleted := make([]*defChange, len(d.Deleted))
+	for i, def := range d.Deleted {
+		deleted[i] = &defChange{Base: def}
+	}
+	for _, v := range [][]*defChange{added, d.Changed, d.Renamed, deleted} {
+		sort.Stable(defChangeSorter{v, less})
+	}
+	for i, c := range added {
+		d.Added[i] = c.Head
+	}
+	for i, c := range deleted {
+		d.Deleted[i] = c.Base
+	}
+}
+
+type defChangeSorter struct {
+	changes []*defChange
+	less    func(a, b *defChange) bool
+}
+
+func (s defChangeSorter) Len() int           { return len(s.changes) }
+func (s defChangeSorter) Swap(i, j int)      { s.changes[i], s.changes[j] = s.changes[j], s.changes[i] }
+func (s defChangeSorter) Less(i, j int) bool { return s.less(s.changes[i], s.changes[j]) }
+
+// deltaXrefs returns the func that sortDefsDelta uses to count refs to
+// the base side's defs from other repos in the global store.
+func deltaXrefs(base *deltaSide) func(*graph.Def) ([]*graph.Ref, error) {
+	repoURI := base.repo.URI()
+	if repoURI == "" {
+		log.Printf("Warning: the base repo has no clone URL, so refs to it from other repos can't be counted.")
+		return func(*graph.Def) ([]*graph.Ref, error) { return nil, nil }
+	}
+	return globalXrefs(repoURI)
+}
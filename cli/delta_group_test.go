@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestGroupDefsDelta(t *testing.T) {
+	def := func(path, file string) *graph.Def {
+		return &graph.Def{DefKey: graph.DefKey{UnitType: "t", Unit: "u", Path: path}, File: file}
+	}
+	d := &defsDelta{
+		Added:   []*graph.Def{def("A", "b.go"), def("B", "a.go")},
+		Changed: []*defChange{{Base: def("C", "x.go"), Head: def("C", "a.go")}},
+		Deleted: []*graph.Def{def("D", "c.go")},
+	}
+	key, err := defGroupKey("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var groups []string
+	for _, g := range groupDefsDelta(d, key) {
+		groups = append(groups, fmt.Sprintf("%s:%d/%d/%d", g.Key, len(g.Added), len(g.Changed), len(g.Deleted)))
+	}
+	// Changed defs are grouped by their head version's file.
+	if got, want := strings.Join(groups, " "), "a.go:1/1/0 b.go:1/0/0 c.go:0/0/1"; got != want {
+		t.Errorf("got groups %q, want %q", got, want)
+	}
+
+	if _, err := defGroupKey("x"); err == nil {
+		t.Error("got no error for an invalid --group-by value")
+	}
+}
+
+func TestSortDefsDelta(t *testing.T) {
+	def := func(name string) *graph.Def {
+		return &graph.Def{DefKey: graph.DefKey{UnitType: "t", Unit: "u", Path: "p/" + name}, Name: name}
+	}
+	newDelta := func() *defsDelta {
+		return &defsDelta{
+			Added:   []*graph.Def{def("b"), def("a")},
+			Deleted: []*graph.Def{def("x"), def("y"), def("z")},
+		}
+	}
+	refs := map[string][]*graph.Ref{
+		"x": {{Repo: "r1"}, {Repo: "r1"}, {Repo: "r1"}},
+		"y": {{Repo: "r1"}, {Repo: "r2"}},
+	}
+	xrefs := func(def *graph.Def) ([]*graph.Ref, error) { return refs[def.Name], nil }
+	names := func(defs []*graph.Def) string {
+		var s []string
+		for _, def := range defs {
+			s = append(s, def.Name)
+		}
+		return strings.Join(s, " ")
+	}
+
+	tests := map[string]struct{ added, deleted string }{
+		"name":   {"a b", "x y z"},
+		"xrefs":  {"b a", "x y z"},
+		"impact": {"b a", "y x z"}, // y has more dependent repos
+	}
+	for by, want := range tests {
+		d := newDelta()
+		if err := sortDefsDelta(d, by, xrefs); err != nil {
+			t.Fatal(err)
+		}
+		if got := names(d.Added); got != want.added {
+			t.Errorf("%s: got added %q, want %q", by, got, want.added)
+		}
+		if got := names(d.Deleted); got != want.deleted {
+			t.Errorf("%s: got deleted %q, want %q", by, got, want.deleted)
+		}
+		if by == "name" {
+			continue
+		}
+		// The counts are recorded, so that they're shown.
+		var xrefDefs []string
+		for _, di := range d.Xrefs {
+			xrefDefs = append(xrefDefs, di.Def.Name)
+		}
+		if got, want := strings.Join(xrefDefs, " "), strings.TrimSuffix(want.deleted, " z"); got != want {
+			t.Errorf("%s: got xrefs of %q, want %q", by, got, want)
+		}
+		for _, def := range d.Deleted {
+			if def.Name == "y" {
+				if got, want := d.xrefsNote(def), "2 refs from 2 other repos"; got != want {
+					t.Errorf("%s: got xrefs note %q, want %q", by, got, want)
+				}
+			}
+		}
+	}
+
+	if err := sortDefsDelta(newDelta(), "x", xrefs); err == nil {
+		t.Error("got no error for an invalid --sort value")
+	}
+}
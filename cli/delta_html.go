@@ -0,0 +1,229 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// htmlReportCSS is the stylesheet embedded in HTML delta reports.
+const htmlReportCSS = `
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; max-width: 960px; margin: 2em auto; padding: 0 1em; color: #24292e; }
+h1 { font-size: 1.6em; border-bottom: 1px solid #eaecef; padding-bottom: .3em; }
+h2 { font-size: 1.3em; margin-top: 2em; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #dfe2e5; padding: .3em .8em; text-align: left; }
+th { background: #f6f8fa; }
+code, pre { font-family: Menlo, Consolas, monospace; font-size: .9em; }
+pre { background: #f6f8fa; padding: .8em; overflow: auto; }
+.def { border: 1px solid #e1e4e8; border-radius: 4px; margin: 1em 0; padding: 0 1em; }
+.def h3 { font-size: 1em; }
+.def h3 a.anchor { color: inherit; text-decoration: none; }
+.change { display: inline-block; min-width: 6em; font-weight: normal; }
+.added { color: #22863a; } .changed, .renamed { color: #b08800; } .deleted, .breaking, .undocumented { color: #cb2431; }
+`
+
+// maxSnippetLines is the max number of lines of a def's definition
+// that are embedded in HTML reports.
+const maxSnippetLines = 30
+
+// htmlReport is a standalone HTML report (with inline CSS) of one or
+// more deltas.
+type htmlReport struct {
+	buf     bytes.Buffer
+	anchors map[string]int // number of times each anchor was used
+}
+
+func newHTMLReport(title string) *htmlReport {
+	r := &htmlReport{anchors: map[string]int{}}
+	fmt.Fprintf(&r.buf, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<style>%s</style>\n</head>\n<body>\n<h1>%s</h1>\n", html.EscapeString(title), htmlReportCSS, html.EscapeString(title))
+	return r
+}
+
+// writeTo finishes the report and writes it to w.
+func (r *htmlReport) writeTo(w io.Writer) error {
+	r.buf.WriteString("</body>\n</html>\n")
+	_, err := r.buf.WriteTo(w)
+	return err
+}
+
+func (r *htmlReport) heading(text string) {
+	fmt.Fprintf(&r.buf, "<h2>%s</h2>\n", html.EscapeString(text))
+}
+
+// table writes a table whose cells hold text.
+func (r *htmlReport) table(header []string, rows [][]string) {
+	r.buf.WriteString("<table>\n<tr>")
+	for _, h := range header {
+		fmt.Fprintf(&r.buf, "<th>%s</th>", html.EscapeString(h))
+	}
+	r.buf.WriteString("</tr>\n")
+	for _, row := range rows {
+		r.buf.WriteString("<tr>")
+		for _, cell := range row {
+			fmt.Fprintf(&r.buf, "<td>%s</td>", html.EscapeString(cell))
+		}
+		r.buf.WriteString("</tr>\n")
+	}
+	r.buf.WriteString("</table>\n")
+}
+
+// counts writes a table with a single row of counts.
+func (r *htmlReport) counts(header []string, counts ...interface{}) {
+	row := make([]string, len(counts))
+	for i, c := range counts {
+		row[i] = fmt.Sprint(c)
+	}
+	r.table(header, [][]string{row})
+}
+
+var nonAnchorChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// anchor returns a unique anchor (element ID) for def.
+func (r *htmlReport) anchor(def *graph.Def) string {
+	a := "def-" + strings.Trim(nonAnchorChars.ReplaceAllString(def.UnitType+"-"+def.Unit+"-"+def.Path, "-"), "-")
+	r.anchors[a]++
+	if n := r.anchors[a]; n > 1 {
+		a = fmt.Sprintf("%s-%d", a, n)
+	}
+	return a
+}
+
+// def writes a section describing a change to def, with a link to the
+// section itself (so it can be linked to), the details (as text), a
+// link to the def on Sourcegraph (if url is nonempty), and the def's
+// definition (if snippet is nonempty).
+func (r *htmlReport) def(change string, def *graph.Def, details []string, url, snippet string) {
+	a := r.anchor(def)
+	fmt.Fprintf(&r.buf, "<div class=\"def\" id=\"%s\">\n<h3><a class=\"anchor\" href=\"#%s\"><span class=\"change %s\">%s</span> <code>%s</code></a></h3>\n<ul>\n", a, a, strings.ToLower(change), html.EscapeString(change), html.EscapeString(def.Kind+" "+def.Path))
+	fmt.Fprintf(&r.buf, "<li>Source unit: <code>%s</code></li>\n", html.EscapeString(def.UnitType+" "+def.Unit))
+	for _, d := range details {
+		fmt.Fprintf(&r.buf, "<li>%s</li>\n", html.EscapeString(d))
+	}
+	if url != "" {
+		fmt.Fprintf(&r.buf, "<li><a href=\"%s\">View on Sourcegraph</a></li>\n", html.EscapeString(url))
+	}
+	r.buf.WriteString("</ul>\n")
+	if snippet != "" {
+		fmt.Fprintf(&r.buf, "<pre>%s</pre>\n", html.EscapeString(snippet))
+	}
+	r.buf.WriteString("</div>\n")
+}
+
+// snippet returns the definition of def at the side's commit (at most
+// maxSnippetLines lines of it), or the empty string if it can't be
+// read.
+func (s *deltaSide) snippet(def *graph.Def) string {
+	text, _, ok := s.defText(def)
+	if !ok {
+		return ""
+	}
+	lines := strings.Split(text, "\n")
+	if len(lines) > maxSnippetLines {
+		lines = append(lines[:maxSnippetLines], "...")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// defsDelta adds a section titled title for d, with the definitions
+// of added and changed defs at the head commit and of deleted defs at
+// the base commit.
+func (r *htmlReport) defsDelta(title string, d *defsDelta, base, head *deltaSide, linkURL string) {
+	baseURL, headURL := base.defURL(linkURL), head.defURL(linkURL)
+	r.heading(title)
+	r.counts([]string{"Added", "Changed", "Renamed", "Deleted"}, len(d.Added), len(d.Changed), len(d.Renamed), len(d.Deleted))
+	for _, def := range d.Added {
+		r.def("Added", def, []string{"File: " + def.File}, headURL(def), head.snippet(def))
+	}
+	for _, c := range d.Changed {
+		details := []string{"File: " + c.Head.File}
+		if c.Base.File != c.Head.File {
+			details[0] += " (was " + c.Base.File + ")"
+		}
+		if c.Base.Exported != c.Head.Exported {
+			details = append(details, fmt.Sprintf("Exported: %t → %t", c.Base.Exported, c.Head.Exported))
+		}
+		r.def("Changed", c.Head, d.withXrefsNote(details, c.Base), headURL(c.Head), head.snippet(c.Head))
+	}
+	for _, c := range d.Renamed {
+		details := []string{"Renamed from " + formatDeltaDefKey(c.Base), "File: " + c.Head.File}
+		r.def("Renamed", c.Head, d.withXrefsNote(details, c.Base), headURL(c.Head), head.snippet(c.Head))
+	}
+	for _, def := range d.Deleted {
+		r.def("Deleted", def, d.withXrefsNote([]string{"File: " + def.File}, def), baseURL(def), base.snippet(def))
+	}
+}
+
+// breakingDelta adds a section for b, with the definitions of the
+// affected defs at the base commit.
+func (r *htmlReport) breakingDelta(b *breakingDelta, base *deltaSide, linkURL string) {
+	baseURL := base.defURL(linkURL)
+	r.heading(fmt.Sprintf("Breaking changes from %s to %s", b.Base, b.Head))
+	r.counts([]string{"Breaking", "Non-breaking"}, len(b.Breaking), b.NonBreaking)
+	for _, bc := range b.Breaking {
+		r.def("Breaking", bc.Def, []string{"Reason: " + bc.Reason, "File: " + bc.Def.File}, baseURL(bc.Def), base.snippet(bc.Def))
+	}
+}
+
+// depsDelta adds a section for d.
+func (r *htmlReport) depsDelta(d *depsDelta) {
+	r.heading(fmt.Sprintf("Dependency changes from %s to %s", d.Base, d.Head))
+	r.counts([]string{"Added", "Changed", "Removed"}, len(d.Added), len(d.Changed), len(d.Removed))
+	if len(d.Added)+len(d.Changed)+len(d.Removed) == 0 {
+		return
+	}
+	var rows [][]string
+	for _, c := range d.Added {
+		rows = append(rows, []string{"Added", c.Repo, "", formatDepRevs(c.HeadRevs)})
+	}
+	for _, c := range d.Changed {
+		rows = append(rows, []string{"Changed", c.Repo, formatDepRevs(c.BaseRevs), formatDepRevs(c.HeadRevs)})
+	}
+	for _, c := range d.Removed {
+		rows = append(rows, []string{"Removed", c.Repo, formatDepRevs(c.BaseRevs), ""})
+	}
+	r.table([]string{"", "Repository", "Base", "Head"}, rows)
+}
+
+// docsDelta adds a section for d, with the definitions of the defs at
+// the head commit.
+func (r *htmlReport) docsDelta(d *docsDelta, head *deltaSide, linkURL string) {
+	headURL := head.defURL(linkURL)
+	r.heading(fmt.Sprintf("Doc changes from %s to %s", d.Base, d.Head))
+	r.counts([]string{"Changed", "Undocumented"}, len(d.Changed), len(d.Undocumented))
+	orNone := func(doc string) string {
+		if doc = strings.TrimSpace(doc); doc == "" {
+			return "(none)"
+		}
+		return doc
+	}
+	for _, dc := range d.Changed {
+		r.def("Changed", dc.Def, []string{"Before: " + orNone(dc.BaseDoc), "After: " + orNone(dc.HeadDoc)}, headURL(dc.Def), head.snippet(dc.Def))
+	}
+	for _, def := range d.Undocumented {
+		r.def("Undocumented", def, []string{"File: " + def.File}, headURL(def), head.snippet(def))
+	}
+}
+
+// impactScore adds a section for s, with the definitions of the
+// referenced defs at the base commit.
+func (r *htmlReport) impactScore(s *impactScore, base *deltaSide, linkURL string) {
+	baseURL := base.defURL(linkURL)
+	r.heading(fmt.Sprintf("Impact of changes from %s to %s", s.Base, s.Head))
+	header := []string{"Score", "Changed defs", "Refs from other repos", "Dependent repos"}
+	row := []interface{}{fmt.Sprintf("%.1f", s.Score), s.ChangedDefs, s.Xrefs, s.Dependents}
+	if s.Threshold > 0 {
+		header = append(header, "Threshold")
+		row = append(row, fmt.Sprintf("%.1f", s.Threshold))
+	}
+	r.counts(header, row...)
+	for _, di := range s.Defs {
+		details := []string{fmt.Sprintf("%d refs from %s", di.Xrefs, strings.Join(di.Dependents, ", ")), "File: " + di.Def.File}
+		r.def(strings.Title(di.Change), di.Def, details, baseURL(di.Def), base.snippet(di.Def))
+	}
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestHTMLReport(t *testing.T) {
+	def := &graph.Def{DefKey: graph.DefKey{UnitType: "GoPackage", Unit: "a/b", Path: "T/M"}, Kind: "func", File: "b.go"}
+
+	r := newHTMLReport("Changes <x>")
+	r.def("Added", def, []string{"File: b.go"}, "https://example.com/?a=1&b=2", "func (T) M() <-chan int {}")
+	r.def("Deleted", def, nil, "", "")
+	var buf bytes.Buffer
+	if err := r.writeTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"<title>Changes &lt;x&gt;</title>",
+		`<div class="def" id="def-GoPackage-a-b-T-M">`,
+		`<a class="anchor" href="#def-GoPackage-a-b-T-M">`,
+		`<div class="def" id="def-GoPackage-a-b-T-M-2">`, // same def twice
+		`<a href="https://example.com/?a=1&amp;b=2">`,
+		"<pre>func (T) M() &lt;-chan int {}</pre>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report doesn't contain %q:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "<pre>"); n != 1 {
+		t.Errorf("got %d snippets, want 1 (none for the def without one)", n)
+	}
+	if !strings.HasSuffix(out, "</html>\n") {
+		t.Error("report isn't terminated")
+	}
+}
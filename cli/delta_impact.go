@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+type DeltaImpactScoreCmd struct {
+	DeltaCmdCommon
+
+	Exported bool `long:"exported" description:"only count changes to exported defs"`
+
+	DefWeight       float64 `long:"def-weight" description:"weight of each changed, renamed, or deleted def" default:"1" value-name:"W"`
+	XrefWeight      float64 `long:"xref-weight" description:"weight of each ref from another repo to a changed def" default:"0.1" value-name:"W"`
+	DependentWeight float64 `long:"dependent-weight" description:"weight of each other repo that refers to a changed def" default:"5" value-name:"W"`
+
+	Threshold float64 `long:"threshold" description:"exit with an error if the score exceeds this (0 to never fail)" value-name:"SCORE"`
+}
+
+var deltaImpactScoreCmd DeltaImpactScoreCmd
+
+// impactWeights are the weights of the components of an impact score.
+type impactWeights struct {
+	Def, Xref, Dependent float64
+}
+
+// defaultImpactWeights are the default values of the --*-weight
+// options.
+var defaultImpactWeights = impactWeights{Def: 1, Xref: 0.1, Dependent: 5}
+
+// impactScore is the output of the impact-score command.
+type impactScore struct {
+	Base, Head string
+
+	ChangedDefs int // number of changed, renamed, and deleted defs
+	Xrefs       int // number of refs from other repos to the changed defs
+	Dependents  int // number of other repos that refer to the changed defs
+
+	Score     float64
+	Threshold float64 `json:",omitempty"`
+
+	// Defs lists the changed defs with refs from other repos, with the
+	// most referenced first.
+	Defs []*defImpact `json:",omitempty"`
+}
+
+// defImpact describes the refs from other repos to a changed def.
+type defImpact struct {
+	// Def is the def at the base commit.
+	Def *graph.Def
+
+	Change     string // "changed", "renamed", or "deleted"
+	Xrefs      int
+	Dependents []string
+}
+
+// computeImpactScore scores the changes in d. The xrefs func returns
+// the refs from other repos to a def at the base commit.
+func computeImpactScore(d *defsDelta, xrefs func(*graph.Def) ([]*graph.Ref, error), w impactWeights) (*impactScore, error) {
+	s := &impactScore{Base: d.Base, Head: d.Head}
+	var changed []*defImpact
+	for _, c := range d.Changed {
+		changed = append(changed, &defImpact{Def: c.Base, Change: "changed"})
+	}
+	for _, c := range d.Renamed {
+		changed = append(changed, &defImpact{Def: c.Base, Change: "renamed"})
+	}
+	for _, def := range d.Deleted {
+		changed = append(changed, &defImpact{Def: def, Change: "deleted"})
+	}
+	s.ChangedDefs = len(changed)
+
+	dependents := map[string]struct{}{}
+	for _, di := range changed {
+		refs, err := xrefs(di.Def)
+		if err != nil {
+			return nil, err
+		}
+		if len(refs) == 0 {
+			continue
+		}
+		repos := map[string]struct{}{}
+		for _, ref := range refs {
+			repos[ref.Repo] = struct{}{}
+			dependents[ref.Repo] = struct{}{}
+		}
+		di.Xrefs = len(refs)
+		for repo := range repos {
+			di.Dependents = append(di.Dependents, repo)
+		}
+		sort.Strings(di.Dependents)
+		s.Xrefs += len(refs)
+		s.Defs = append(s.Defs, di)
+	}
+	s.Dependents = len(dependents)
+	sort.Stable(defImpactsByXrefs(s.Defs))
+
+	s.Score = w.Def*float64(s.ChangedDefs) + w.Xref*float64(s.Xrefs) + w.Dependent*float64(s.Dependents)
+	return s, nil
+}
+
+type defImpactsByXrefs []*defImpact
+
+func (v defImpactsByXrefs) Len() int           { return len(v) }
+func (v defImpactsByXrefs) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v defImpactsByXrefs) Less(i, j int) bool { return v[i].Xrefs > v[j].Xrefs }
+
+// globalXrefs returns a func that lists the refs in the global store
+// from repos other than repoURI to a def in repoURI.
+func globalXrefs(repoURI string) func(*graph.Def) ([]*graph.Ref, error) {
+	globalStore := store.NewFSMultiRepoStore(rwvfs.Walkable(rwvfs.ReadOnly(rwvfs.OS(srclib.StoreDir))), nil)
+	return func(def *graph.Def) ([]*graph.Ref, error) {
+		refs, err := globalStore.Refs(store.ByRefDef(graph.RefDefKey{DefRepo: repoURI, DefUnitType: def.UnitType, DefUnit: def.Unit, DefPath: def.Path}))
+		if err != nil {
+			return nil, err
+		}
+		var xrefs []*graph.Ref
+		for _, ref := range withoutDefRefs(refs) {
+			if !graph.URIEqual(ref.Repo, repoURI) {
+				xrefs = append(xrefs, ref)
+			}
+		}
+		return xrefs, nil
+	}
+}
+
+func (c *DeltaImpactScoreCmd) Execute(args []string) error {
+	format, err := c.outputFormat()
+	if err != nil {
+		return err
+	}
+	baseSide, headSide, err := c.deltaSides()
+	if err != nil {
+		return err
+	}
+	baseDefs, err := baseSide.defs()
+	if err != nil {
+		return err
+	}
+	headDefs, err := headSide.defs()
+	if err != nil {
+		return err
+	}
+
+	d := computeDefsDelta(baseDefs, headDefs, c.Exported)
+	d.Base, d.Head = baseSide.String(), headSide.String()
+	d.detectRenames()
+
+	s, err := computeImpactScore(d, deltaXrefs(baseSide), impactWeights{Def: c.DefWeight, Xref: c.XrefWeight, Dependent: c.DependentWeight})
+	if err != nil {
+		return err
+	}
+	s.Threshold = c.Threshold
+	for _, di := range s.Defs {
+		baseSide.relocate(di.Def)
+	}
+
+	switch format {
+	case "json":
+		PrintJSON(s, "  ")
+	case "markdown":
+		if err := c.writeReport(func(w io.Writer) error { return writeImpactScoreMarkdown(w, s, baseSide.defURL(c.LinkURL)) }); err != nil {
+			return err
+		}
+	case "html":
+		r := newHTMLReport(fmt.Sprintf("Impact of changes from %s to %s", s.Base, s.Head))
+		r.impactScore(s, baseSide, c.LinkURL)
+		if err := c.writeReport(r.writeTo); err != nil {
+			return err
+		}
+	default:
+		colorable.Printf("Impact of changes from %s to %s: score %.1f\n", s.Base, s.Head, s.Score)
+		colorable.Printf("  %d changed defs, %d refs from other repos, %d dependent repos\n", s.ChangedDefs, s.Xrefs, s.Dependents)
+		for _, di := range s.Defs {
+			colorable.Printf("  %-8s %5d refs in %d repos  %s\n", di.Change, di.Xrefs, len(di.Dependents), formatDeltaDef(di.Def))
+		}
+	}
+
+	if c.Threshold > 0 && s.Score > c.Threshold {
+		return fmt.Errorf("impact score %.1f exceeds threshold %.1f", s.Score, c.Threshold)
+	}
+	return nil
+}
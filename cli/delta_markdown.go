@@ -0,0 +1,249 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// defURL returns a func that returns the URL of a def at the side's
+// commit on the Sourcegraph instance at baseURL, or the empty string
+// if baseURL is empty or the side's repo has no URI.
+func (s *deltaSide) defURL(baseURL string) func(*graph.Def) string {
+	return func(def *graph.Def) string {
+		repo := def.Repo
+		if repo == "" {
+			repo = s.repo.URI()
+		}
+		if baseURL == "" || repo == "" {
+			return ""
+		}
+		return fmt.Sprintf("%s/%s@%s/-/def/%s/%s/-/%s", strings.TrimSuffix(baseURL, "/"), repo, s.commitID, def.UnitType, def.Unit, def.Path)
+	}
+}
+
+// mdCode formats s as inline code that is also rendered inside HTML
+// elements (such as the <summary> of a collapsible section).
+func mdCode(s string) string {
+	return "<code>" + html.EscapeString(s) + "</code>"
+}
+
+// writeMarkdownTable writes a markdown table with a single row.
+func writeMarkdownTable(w io.Writer, header []string, row []interface{}) {
+	fmt.Fprintf(w, "| %s |\n", strings.Join(header, " | "))
+	fmt.Fprintf(w, "|%s\n", strings.Repeat(" ---: |", len(header)))
+	cells := make([]string, len(row))
+	for i, v := range row {
+		cells[i] = fmt.Sprint(v)
+	}
+	fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | "))
+}
+
+// writeDefMarkdown writes a collapsible section describing a def,
+// titled with the kind of change and the def's kind and path.
+func writeDefMarkdown(w io.Writer, change string, def *graph.Def, details []string, url string) {
+	fmt.Fprintf(w, "<details>\n<summary>%s %s</summary>\n\n", change, mdCode(def.Kind+" "+def.Path))
+	fmt.Fprintf(w, "- Source unit: %s\n", mdCode(def.UnitType+" "+def.Unit))
+	for _, d := range details {
+		fmt.Fprintf(w, "- %s\n", d)
+	}
+	if url != "" {
+		fmt.Fprintf(w, "- [View on Sourcegraph](%s)\n", url)
+	}
+	fmt.Fprint(w, "\n</details>\n")
+}
+
+// writeDefsDeltaMarkdown writes d as a markdown report, with links to
+// added and changed defs at the head commit and to deleted defs at the
+// base commit.
+func writeDefsDeltaMarkdown(w io.Writer, d *defsDelta, baseURL, headURL func(*graph.Def) string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "### Def changes from %s to %s\n\n", mdCode(d.Base), mdCode(d.Head))
+	writeMarkdownTable(&buf, []string{"Added", "Changed", "Renamed", "Deleted"}, []interface{}{len(d.Added), len(d.Changed), len(d.Renamed), len(d.Deleted)})
+	if !d.empty() {
+		fmt.Fprintln(&buf)
+	}
+	writeDefChangesMarkdown(&buf, d, baseURL, headURL)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// writeGroupedDefsDeltaMarkdown is like writeDefsDeltaMarkdown, but it
+// writes the changes in each group (of d) under its own heading.
+func writeGroupedDefsDeltaMarkdown(w io.Writer, d *defsDelta, groups []*defsDeltaGroup, baseURL, headURL func(*graph.Def) string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "### Def changes from %s to %s\n\n", mdCode(d.Base), mdCode(d.Head))
+	writeMarkdownTable(&buf, []string{"Added", "Changed", "Renamed", "Deleted"}, []interface{}{len(d.Added), len(d.Changed), len(d.Renamed), len(d.Deleted)})
+	for _, g := range groups {
+		fmt.Fprintf(&buf, "\n#### %s (%s)\n\n", mdCode(g.Key), g.summary())
+		writeDefChangesMarkdown(&buf, g.defsDelta, baseURL, headURL)
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// writeDefChangesMarkdown writes a collapsible section for each change
+// in d.
+func writeDefChangesMarkdown(buf *bytes.Buffer, d *defsDelta, baseURL, headURL func(*graph.Def) string) {
+	for _, def := range d.Added {
+		writeDefMarkdown(buf, "Added", def, []string{"File: " + mdCode(def.File)}, headURL(def))
+	}
+	for _, c := range d.Changed {
+		details := []string{"File: " + mdCode(c.Head.File)}
+		if c.Base.File != c.Head.File {
+			details[0] += " (was " + mdCode(c.Base.File) + ")"
+		}
+		if c.Base.Exported != c.Head.Exported {
+			details = append(details, fmt.Sprintf("Exported: %t → %t", c.Base.Exported, c.Head.Exported))
+		}
+		writeDefMarkdown(buf, "Changed", c.Head, d.withXrefsNote(details, c.Base), headURL(c.Head))
+	}
+	for _, c := range d.Renamed {
+		details := []string{"Renamed from " + mdCode(c.Base.Path) + " in " + mdCode(c.Base.UnitType+" "+c.Base.Unit), "File: " + mdCode(c.Head.File)}
+		writeDefMarkdown(buf, "Renamed", c.Head, d.withXrefsNote(details, c.Base), headURL(c.Head))
+	}
+	for _, def := range d.Deleted {
+		writeDefMarkdown(buf, "Deleted", def, d.withXrefsNote([]string{"File: " + mdCode(def.File)}, def), baseURL(def))
+	}
+}
+
+// writeBreakingDeltaMarkdown writes b as a markdown report, with links
+// to the affected defs at the base commit (where code that uses them
+// can be found).
+func writeBreakingDeltaMarkdown(w io.Writer, b *breakingDelta, baseURL func(*graph.Def) string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "### Breaking changes from %s to %s\n\n", mdCode(b.Base), mdCode(b.Head))
+	writeMarkdownTable(&buf, []string{"Breaking", "Non-breaking"}, []interface{}{len(b.Breaking), b.NonBreaking})
+	if len(b.Breaking) > 0 {
+		fmt.Fprintln(&buf)
+	}
+	for _, bc := range b.Breaking {
+		writeDefMarkdown(&buf, "Breaking", bc.Def, []string{"Reason: " + bc.Reason, "File: " + mdCode(bc.Def.File)}, baseURL(bc.Def))
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// writeDepsDeltaMarkdown writes d as a markdown report, with links to
+// the dependency repos on the Sourcegraph instance at baseURL (if
+// nonempty).
+func writeDepsDeltaMarkdown(w io.Writer, d *depsDelta, baseURL string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "### Dependency changes from %s to %s\n\n", mdCode(d.Base), mdCode(d.Head))
+	writeMarkdownTable(&buf, []string{"Added", "Changed", "Removed"}, []interface{}{len(d.Added), len(d.Changed), len(d.Removed)})
+	if len(d.Added)+len(d.Changed)+len(d.Removed) == 0 {
+		_, err := buf.WriteTo(w)
+		return err
+	}
+
+	repo := func(repo string) string {
+		if baseURL == "" {
+			return mdCode(repo)
+		}
+		return fmt.Sprintf("[%s](%s/%s)", mdCode(repo), strings.TrimSuffix(baseURL, "/"), repo)
+	}
+	fmt.Fprint(&buf, "\n| | Repository | Base | Head |\n| --- | --- | --- | --- |\n")
+	for _, c := range d.Added {
+		fmt.Fprintf(&buf, "| Added | %s | | %s |\n", repo(c.Repo), formatDepRevs(c.HeadRevs))
+	}
+	for _, c := range d.Changed {
+		fmt.Fprintf(&buf, "| Changed | %s | %s | %s |\n", repo(c.Repo), formatDepRevs(c.BaseRevs), formatDepRevs(c.HeadRevs))
+	}
+	for _, c := range d.Removed {
+		fmt.Fprintf(&buf, "| Removed | %s | %s | |\n", repo(c.Repo), formatDepRevs(c.BaseRevs))
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// writeDocsDeltaMarkdown writes d as a markdown report, with links to
+// the defs at the head commit.
+func writeDocsDeltaMarkdown(w io.Writer, d *docsDelta, headURL func(*graph.Def) string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "### Doc changes from %s to %s\n\n", mdCode(d.Base), mdCode(d.Head))
+	writeMarkdownTable(&buf, []string{"Changed", "Undocumented"}, []interface{}{len(d.Changed), len(d.Undocumented)})
+	if len(d.Changed)+len(d.Undocumented) > 0 {
+		fmt.Fprintln(&buf)
+	}
+	quote := func(doc string) string {
+		if doc == "" {
+			return "(none)"
+		}
+		return "\n\n  > " + strings.Replace(html.EscapeString(strings.TrimSpace(doc)), "\n", "\n  > ", -1) + "\n"
+	}
+	for _, dc := range d.Changed {
+		details := []string{"Before: " + quote(dc.BaseDoc), "After: " + quote(dc.HeadDoc)}
+		writeDefMarkdown(&buf, "Changed", dc.Def, details, headURL(dc.Def))
+	}
+	for _, def := range d.Undocumented {
+		writeDefMarkdown(&buf, "Undocumented", def, []string{"File: " + mdCode(def.File)}, headURL(def))
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// writeImpactScoreMarkdown writes s as a markdown report, with links to
+// the referenced defs at the base commit.
+func writeImpactScoreMarkdown(w io.Writer, s *impactScore, baseURL func(*graph.Def) string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "### Impact of changes from %s to %s\n\n", mdCode(s.Base), mdCode(s.Head))
+	header := []string{"Score", "Changed defs", "Refs from other repos", "Dependent repos"}
+	row := []interface{}{fmt.Sprintf("%.1f", s.Score), s.ChangedDefs, s.Xrefs, s.Dependents}
+	if s.Threshold > 0 {
+		header = append(header, "Threshold")
+		row = append(row, fmt.Sprintf("%.1f", s.Threshold))
+	}
+	writeMarkdownTable(&buf, header, row)
+	if len(s.Defs) > 0 {
+		fmt.Fprintln(&buf)
+	}
+	for _, di := range s.Defs {
+		repos := make([]string, len(di.Dependents))
+		for i, repo := range di.Dependents {
+			repos[i] = mdCode(repo)
+		}
+		details := []string{fmt.Sprintf("%d refs from %s", di.Xrefs, strings.Join(repos, ", ")), "File: " + mdCode(di.Def.File)}
+		writeDefMarkdown(&buf, strings.Title(di.Change), di.Def, details, baseURL(di.Def))
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// writeDefsChangelogMarkdown writes the changes made by each commit
+// from base to head as a markdown changelog (for release notes). The
+// defURL func returns the func that links defs at a given commit.
+func writeDefsChangelogMarkdown(w io.Writer, base, head string, deltas []*commitDefsDelta, defURL func(commitID string) func(*graph.Def) string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "### API changes from %s to %s\n", mdCode(base), mdCode(head))
+	if len(deltas) == 0 {
+		fmt.Fprint(&buf, "\nNo changes.\n")
+	}
+	link := func(def *graph.Def, url string) string {
+		if url == "" {
+			return mdCode(def.Kind + " " + def.Path)
+		}
+		return fmt.Sprintf("[%s](%s)", mdCode(def.Kind+" "+def.Path), url)
+	}
+	for _, d := range deltas {
+		fmt.Fprintf(&buf, "\n#### %s (%s)\n\n", html.EscapeString(d.Subject), mdCode(shortCommitID(d.Commit)))
+		headURL := defURL(d.Commit)
+		for _, def := range d.Added {
+			fmt.Fprintf(&buf, "- Added %s\n", link(def, headURL(def)))
+		}
+		for _, c := range d.Changed {
+			fmt.Fprintf(&buf, "- Changed %s\n", link(c.Head, headURL(c.Head)))
+		}
+		for _, c := range d.Renamed {
+			fmt.Fprintf(&buf, "- Renamed %s to %s\n", mdCode(c.Base.Path), link(c.Head, headURL(c.Head)))
+		}
+		for _, def := range d.Deleted {
+			fmt.Fprintf(&buf, "- Deleted %s\n", mdCode(def.Kind+" "+def.Path))
+		}
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestWriteDefsDeltaMarkdown(t *testing.T) {
+	side := &deltaSide{repo: &Repo{CloneURL: "https://github.com/a/b"}, commitID: "c1"}
+	def := func(path, file string) *graph.Def {
+		return &graph.Def{DefKey: graph.DefKey{UnitType: "GoPackage", Unit: "u", Path: path}, Kind: "func", File: file, Exported: true}
+	}
+	d := &defsDelta{
+		Base:    "c0",
+		Head:    "c1",
+		Added:   []*graph.Def{def("A<T>", "a.go")},
+		Changed: []*defChange{{Base: def("B", "old.go"), Head: def("B", "b.go")}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeDefsDeltaMarkdown(&buf, d, side.defURL(""), side.defURL("https://example.com/")); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"### Def changes from <code>c0</code> to <code>c1</code>\n",
+		"| Added | Changed | Renamed | Deleted |\n| ---: | ---: | ---: | ---: |\n| 1 | 1 | 0 | 0 |\n",
+		"<summary>Added <code>func A&lt;T&gt;</code></summary>",
+		"- [View on Sourcegraph](https://example.com/github.com/a/b@c1/-/def/GoPackage/u/-/B)\n",
+		"- File: <code>b.go</code> (was <code>old.go</code>)\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "<details>"); n != 2 {
+		t.Errorf("got %d collapsible sections, want 2", n)
+	}
+
+	if url := side.defURL("")(def("A", "a.go")); url != "" {
+		t.Errorf("got URL %q with no base URL, want none", url)
+	}
+}
@@ -0,0 +1,235 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+type DeltaNotifyCmd struct {
+	DeltaCmdCommon
+
+	Config   string `long:"config" description:"JSON file listing the people to notify and the repos they're responsible for (see the command's help)" required:"yes" value-name:"FILE"`
+	Post     bool   `long:"post" description:"post each digest to the config file's Webhook instead of printing it"`
+	Exported bool   `long:"exported" description:"only notify about changes to exported defs"`
+}
+
+var deltaNotifyCmd DeltaNotifyCmd
+
+// notifyConfig is the config file of the notify command.
+type notifyConfig struct {
+	// Webhook is the URL that digests are posted to with --post, as
+	// JSON (see notifyPayload). A Slack incoming webhook URL works.
+	Webhook string
+
+	Subscribers []*notifySubscriber
+}
+
+// notifySubscriber is a person to notify of changes to defs that any
+// of their repos refer to.
+type notifySubscriber struct {
+	Name  string
+	Email string `json:",omitempty"`
+	Slack string `json:",omitempty"` // Slack handle (e.g., "@alice") or channel
+
+	// Repos is the URIs of the repos the subscriber is responsible
+	// for. They may contain path.Match patterns (e.g.,
+	// "github.com/acme/*").
+	Repos []string
+}
+
+// readNotifyConfig reads and checks the notify config file at file.
+func readNotifyConfig(file string) (*notifyConfig, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var conf notifyConfig
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	for _, s := range conf.Subscribers {
+		for _, pat := range s.Repos {
+			if _, err := path.Match(pat, ""); err != nil {
+				return nil, fmt.Errorf("%s: subscriber %q: bad repo pattern %q: %s", file, s.Name, pat, err)
+			}
+		}
+	}
+	return &conf, nil
+}
+
+// watches reports whether repo is one of the subscriber's repos.
+func (s *notifySubscriber) watches(repo string) bool {
+	for _, pat := range s.Repos {
+		if ok, _ := path.Match(pat, repo); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyDigest lists the changed defs that a subscriber's repos refer
+// to.
+type notifyDigest struct {
+	Subscriber *notifySubscriber
+	Base, Head string
+	Defs       []*defImpact // Dependents lists only the subscriber's repos
+}
+
+// notifyDigests returns a digest for each subscriber whose repos refer
+// to any of the defs in s, in config file order.
+func notifyDigests(s *impactScore, subscribers []*notifySubscriber) []*notifyDigest {
+	var digests []*notifyDigest
+	for _, sub := range subscribers {
+		dg := &notifyDigest{Subscriber: sub, Base: s.Base, Head: s.Head}
+		for _, di := range s.Defs {
+			var repos []string
+			for _, repo := range di.Dependents {
+				if sub.watches(repo) {
+					repos = append(repos, repo)
+				}
+			}
+			if len(repos) > 0 {
+				dg.Defs = append(dg.Defs, &defImpact{Def: di.Def, Change: di.Change, Xrefs: di.Xrefs, Dependents: repos})
+			}
+		}
+		if len(dg.Defs) > 0 {
+			digests = append(digests, dg)
+		}
+	}
+	return digests
+}
+
+// text returns the digest as a plain text message, with links to the
+// defs if defURL returns any.
+func (dg *notifyDigest) text(defURL func(*graph.Def) string) string {
+	var repos []string
+	seen := map[string]bool{}
+	for _, di := range dg.Defs {
+		for _, repo := range di.Dependents {
+			if !seen[repo] {
+				seen[repo] = true
+				repos = append(repos, repo)
+			}
+		}
+	}
+	sort.Strings(repos)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d defs used by %s changed between %s and %s:\n", len(dg.Defs), strings.Join(repos, ", "), dg.Base, dg.Head)
+	for _, di := range dg.Defs {
+		fmt.Fprintf(&buf, "  %s %s (used by %s)", di.Change, formatDeltaDef(di.Def), strings.Join(di.Dependents, ", "))
+		if url := defURL(di.Def); url != "" {
+			fmt.Fprintf(&buf, " %s", url)
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// notifyPayload is the JSON body of a digest posted to a webhook.
+type notifyPayload struct {
+	Channel string `json:"channel,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Text    string `json:"text"`
+}
+
+// postDigest posts a digest's text to webhook.
+func postDigest(webhook string, sub *notifySubscriber, text string) error {
+	body, err := json.Marshal(notifyPayload{Channel: sub.Slack, Email: sub.Email, Text: text})
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("posting digest for %s to %s: HTTP %s", sub.Name, webhook, resp.Status)
+	}
+	return nil
+}
+
+func (c *DeltaNotifyCmd) Execute(args []string) error {
+	format, err := c.outputFormat()
+	if err != nil {
+		return err
+	}
+	if format != "text" && format != "json" {
+		return fmt.Errorf("the notify command doesn't support --format=%s", format)
+	}
+	conf, err := readNotifyConfig(c.Config)
+	if err != nil {
+		return err
+	}
+	if c.Post && conf.Webhook == "" {
+		return errors.New("--post requires a Webhook in the config file")
+	}
+	baseSide, headSide, err := c.deltaSides()
+	if err != nil {
+		return err
+	}
+	baseDefs, err := baseSide.defs()
+	if err != nil {
+		return err
+	}
+	headDefs, err := headSide.defs()
+	if err != nil {
+		return err
+	}
+
+	d := computeDefsDelta(baseDefs, headDefs, c.Exported)
+	d.Base, d.Head = baseSide.String(), headSide.String()
+	d.detectRenames()
+
+	s, err := computeImpactScore(d, deltaXrefs(baseSide), defaultImpactWeights)
+	if err != nil {
+		return err
+	}
+	digests := notifyDigests(s, conf.Subscribers)
+
+	if c.Post {
+		defURL := baseSide.defURL(c.LinkURL)
+		for _, dg := range digests {
+			if err := postDigest(conf.Webhook, dg.Subscriber, dg.text(defURL)); err != nil {
+				return err
+			}
+			if GlobalOpt.Verbose {
+				log.Printf("Posted digest of %d changed defs for %s", len(dg.Defs), dg.Subscriber.Name)
+			}
+		}
+		return nil
+	}
+
+	switch format {
+	case "json":
+		PrintJSON(digests, "  ")
+	default:
+		defURL := baseSide.defURL(c.LinkURL)
+		for i, dg := range digests {
+			if i > 0 {
+				colorable.Println()
+			}
+			to := dg.Subscriber.Name
+			for _, h := range []string{dg.Subscriber.Email, dg.Subscriber.Slack} {
+				if h != "" {
+					to += " <" + h + ">"
+				}
+			}
+			colorable.Printf("To: %s\n%s", to, dg.text(defURL))
+		}
+	}
+	return nil
+}
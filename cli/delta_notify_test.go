@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestNotifyDigests(t *testing.T) {
+	def := func(path string) *graph.Def {
+		return &graph.Def{DefKey: graph.DefKey{UnitType: "t", Unit: "u", Path: path}}
+	}
+	s := &impactScore{
+		Base: "b", Head: "h",
+		Defs: []*defImpact{
+			{Def: def("A"), Change: "changed", Xrefs: 3, Dependents: []string{"github.com/acme/x", "github.com/other/y"}},
+			{Def: def("B"), Change: "deleted", Xrefs: 1, Dependents: []string{"github.com/other/y"}},
+		},
+	}
+	subs := []*notifySubscriber{
+		{Name: "acme", Repos: []string{"github.com/acme/*"}},
+		{Name: "nobody", Repos: []string{"example.com/z"}},
+		{Name: "other", Repos: []string{"github.com/other/y"}},
+	}
+	digests := notifyDigests(s, subs)
+	if len(digests) != 2 {
+		t.Fatalf("got %d digests, want 2 (none for a subscriber whose repos use no changed defs)", len(digests))
+	}
+	if dg := digests[0]; dg.Subscriber.Name != "acme" || len(dg.Defs) != 1 || dg.Defs[0].Def.Path != "A" || strings.Join(dg.Defs[0].Dependents, ",") != "github.com/acme/x" {
+		t.Errorf("got acme digest %+v, want only def A with only acme's repo", dg)
+	}
+	if dg := digests[1]; dg.Subscriber.Name != "other" || len(dg.Defs) != 2 {
+		t.Errorf("got other digest %+v, want defs A and B", dg)
+	}
+}
+
+func TestPostDigest(t *testing.T) {
+	var got notifyPayload
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer ts.Close()
+
+	if err := postDigest(ts.URL, &notifySubscriber{Name: "a", Slack: "@a"}, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if want := (notifyPayload{Channel: "@a", Text: "hello"}); got != want {
+		t.Errorf("got payload %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// parseRevRange parses a BASE..HEAD revision range. HEAD may be
+// omitted (meaning the current commit).
+func parseRevRange(r string) (base, head string, err error) {
+	i := strings.Index(r, "..")
+	if i == -1 || strings.Contains(r, "...") {
+		return "", "", fmt.Errorf("invalid revision range %q (expected BASE..HEAD)", r)
+	}
+	base, head = r[:i], r[i+2:]
+	if base == "" {
+		return "", "", fmt.Errorf("invalid revision range %q (no base revision)", r)
+	}
+	return base, head, nil
+}
+
+// commitDefsDelta is the set of defs changed by a single commit.
+type commitDefsDelta struct {
+	Commit  string
+	Subject string
+
+	*defsDelta
+}
+
+// executePerCommit lists the changes to defs made by each commit from
+// base to head.
+func (c *DeltaDefsCmd) executePerCommit(format string, base, head *deltaSide) error {
+	if base.repo.RootDir != head.repo.RootDir {
+		return errors.New("--per-commit requires the base and head revisions to be in the same repo")
+	}
+	commits, err := head.repo.firstParentCommits(base.commitID, head.commitID)
+	if err != nil {
+		return err
+	}
+
+	var xrefs func(*graph.Def) ([]*graph.Ref, error)
+	if c.Sort == "xrefs" || c.Sort == "impact" {
+		xrefs = deltaXrefs(base)
+	}
+
+	prev := base
+	prevDefs, err := base.defs()
+	if err != nil {
+		return err
+	}
+	var deltas []*commitDefsDelta
+	for _, commit := range commits {
+		s := *head
+		s.commitID = commit.ID
+		if exists, err := buildstore.BuildDataExistsForCommit(s.bs, s.commitID); err != nil {
+			return err
+		} else if !exists {
+			log.Printf("Warning: no build data for commit %s (%s); its changes are included in the next built commit's.", s.commitID, commit.Subject)
+			continue
+		}
+		defs, err := s.defs()
+		if err != nil {
+			return err
+		}
+
+		d := computeDefsDelta(prevDefs, defs, c.Exported)
+		d.Base, d.Head = prev.String(), s.String()
+		if !c.NoRenames {
+			d.detectRenames()
+		}
+		if c.Sort != "" {
+			if err := sortDefsDelta(d, c.Sort, xrefs); err != nil {
+				return err
+			}
+		}
+		if !d.empty() {
+			deltas = append(deltas, &commitDefsDelta{Commit: s.commitID, Subject: commit.Subject, defsDelta: d})
+		}
+		prev, prevDefs = &s, defs
+	}
+	// Relocate only after all of the deltas are computed, since each
+	// commit's defs are compared again with the next commit's. The
+	// head commit's source unit dirs are used for every commit.
+	base.paths, base.repoFiles = head.paths, head.repoFiles
+	for _, d := range deltas {
+		d.relocate(head, head)
+	}
+
+	switch format {
+	case "json":
+		PrintJSON(deltas, "  ")
+		return nil
+	case "markdown":
+		return c.writeReport(func(w io.Writer) error {
+			return writeDefsChangelogMarkdown(w, base.String(), head.String(), deltas, func(commitID string) func(*graph.Def) string {
+				s := *head
+				s.commitID = commitID
+				return s.defURL(c.LinkURL)
+			})
+		})
+	case "html":
+		r := newHTMLReport(fmt.Sprintf("Def changes from %s to %s", base, head))
+		prev := base
+		for _, d := range deltas {
+			s := *head
+			s.commitID = d.Commit
+			r.defsDelta(shortCommitID(d.Commit)+" "+d.Subject, d.defsDelta, prev, &s, c.LinkURL)
+			prev = &s
+		}
+		return c.writeReport(r.writeTo)
+	}
+
+	colorable.Printf("Defs from %s to %s: %d commits with changes\n", base, head, len(deltas))
+	for _, d := range deltas {
+		colorable.Printf("\n%s %s: %s\n", shortCommitID(d.Commit), d.Subject, d.summary())
+		printDefsDelta(d.defsDelta)
+	}
+	return nil
+}
+
+// shortCommitID abbreviates a commit ID for display.
+func shortCommitID(commitID string) string {
+	if len(commitID) > 12 {
+		return commitID[:12]
+	}
+	return commitID
+}
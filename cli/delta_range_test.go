@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestParseRevRange(t *testing.T) {
+	tests := map[string]struct {
+		base, head string
+		wantErr    bool
+	}{
+		"v1.0..v1.2": {base: "v1.0", head: "v1.2"},
+		"v1.0..":     {base: "v1.0"},
+		"..v1.2":     {wantErr: true},
+		"v1.0":       {wantErr: true},
+		"v1.0...v2":  {wantErr: true},
+	}
+	for r, test := range tests {
+		base, head, err := parseRevRange(r)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: got error %v, want error %v", r, err, test.wantErr)
+			continue
+		}
+		if base != test.base || head != test.head {
+			t.Errorf("%q: got %q..%q, want %q..%q", r, base, head, test.base, test.head)
+		}
+	}
+}
+
+func TestFirstParentCommits(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "srclib-rev-range")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	commit := []string{"-c", "user.name=a", "-c", "user.email=a@example.com", "commit", "-q", "--allow-empty", "-m"}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		append(commit, "base"),
+		{"tag", "v1"},
+		{"checkout", "-q", "-b", "topic"},
+		append(commit, "topic change"),
+		{"checkout", "-q", "-"},
+		append(commit, "first"),
+		{"-c", "user.name=a", "-c", "user.email=a@example.com", "merge", "-q", "--no-ff", "-m", "merge topic", "topic"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s\n%s", args, err, out)
+		}
+	}
+
+	commits, err := firstParentCommits("git", dir, "v1", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var subjects []string
+	for _, c := range commits {
+		if len(c.ID) != 40 {
+			t.Errorf("got commit ID %q, want a full commit ID", c.ID)
+		}
+		subjects = append(subjects, c.Subject)
+	}
+	// The topic branch's commit is included in the merge commit.
+	if got, want := strings.Join(subjects, ", "), "first, merge topic"; got != want {
+		t.Errorf("got commits %q, want %q", got, want)
+	}
+}
+
+func TestWriteDefsChangelogMarkdown(t *testing.T) {
+	def := func(path string) *graph.Def {
+		return &graph.Def{DefKey: graph.DefKey{UnitType: "t", Unit: "u", Path: path}, Kind: "func"}
+	}
+	deltas := []*commitDefsDelta{
+		{Commit: "0123456789abcdef", Subject: "Add <A>", defsDelta: &defsDelta{Added: []*graph.Def{def("A")}}},
+		{Commit: "fedcba9876543210", Subject: "Rename B", defsDelta: &defsDelta{Renamed: []*defChange{{Base: def("B"), Head: def("C")}}, Deleted: []*graph.Def{def("D")}}},
+	}
+	defURL := func(commitID string) func(*graph.Def) string {
+		return func(def *graph.Def) string { return "https://example.com/" + commitID + "/" + def.Path }
+	}
+
+	var buf bytes.Buffer
+	if err := writeDefsChangelogMarkdown(&buf, "v1", "v2", deltas, defURL); err != nil {
+		t.Fatal(err)
+	}
+	want := `### API changes from <code>v1</code> to <code>v2</code>
+
+#### Add &lt;A&gt; (<code>0123456789ab</code>)
+
+- Added [<code>func A</code>](https://example.com/0123456789abcdef/A)
+
+#### Rename B (<code>fedcba987654</code>)
+
+- Renamed <code>B</code> to [<code>func C</code>](https://example.com/fedcba9876543210/C)
+- Deleted <code>func D</code>
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+	"sourcegraph.com/sourcegraph/srclib/plan"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// deltaWaitInterval is how often --wait checks the build progress.
+var deltaWaitInterval = 2 * time.Second
+
+// buildProgress returns the number of source units in the build data
+// for commitID and how many of them have been analyzed (i.e., have
+// graph data). Both are 0 if there is no build data for the commit.
+func buildProgress(bs buildstore.RepoBuildStore, commitID string) (units, built int, err error) {
+	exists, err := buildstore.BuildDataExistsForCommit(bs, commitID)
+	if err != nil || !exists {
+		return 0, 0, err
+	}
+
+	fs := bs.Commit(commitID)
+	unitSuffix := buildstore.DataTypeSuffix(unit.SourceUnit{})
+	err = buildstore.WalkFiles(fs, ".", nil, func(unitFile string) error {
+		if !strings.HasSuffix(unitFile, unitSuffix) {
+			return nil
+		}
+		var u unit.SourceUnit
+		if err := readJSONFileFS(fs, unitFile, &u); err != nil {
+			return err
+		}
+		units++
+		if _, err := fs.Stat(plan.SourceUnitDataFilename("graph", &u)); err == nil {
+			built++
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+	return units, built, err
+}
+
+// checkBuild checks that the side's commit has been fully built. With
+// --wait, it waits for the build to finish; otherwise it only warns
+// about incomplete build data (a commit without any build data is
+// reported as an error when its data is read).
+func (c *DeltaCmdCommon) checkBuild(s *deltaSide) error {
+	progress := func() (int, int, error) { return buildProgress(s.bs, s.commitID) }
+	if c.Wait {
+		return waitForBuild(s.String(), progress, c.WaitTimeout, deltaWaitInterval, os.Stderr)
+	}
+	units, built, err := progress()
+	if err != nil {
+		return err
+	}
+	if built < units {
+		log.Printf("Warning: the build data for %s is incomplete (%d of %d source units analyzed), so the delta may be missing changes. Use --wait to wait for the build to finish.", s, built, units)
+	}
+	return nil
+}
+
+// waitForBuild calls progress every interval until it reports that the
+// build named name is complete, writing progress messages to w. It
+// returns an error if the build isn't complete within timeout.
+func waitForBuild(name string, progress func() (units, built int, err error), timeout, interval time.Duration, w io.Writer) error {
+	deadline := time.Now().Add(timeout)
+	lastMsg := ""
+	for {
+		units, built, err := progress()
+		if err != nil {
+			return err
+		}
+		if units > 0 && built == units {
+			if lastMsg != "" {
+				fmt.Fprintf(w, "Build of %s finished.\n", name)
+			}
+			return nil
+		}
+
+		var msg string
+		if units == 0 {
+			msg = fmt.Sprintf("Waiting for the build of %s to start...", name)
+		} else {
+			msg = fmt.Sprintf("Waiting for the build of %s (%d of %d source units analyzed)...", name, built, units)
+		}
+		if msg != lastMsg {
+			fmt.Fprintln(w, msg)
+			lastMsg = msg
+		}
+
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("timed out after %s waiting for the build of %s (run 'src make' for it, or increase --wait-timeout)", timeout, name)
+		}
+		time.Sleep(interval)
+	}
+}
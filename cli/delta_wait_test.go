@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitForBuild(t *testing.T) {
+	// The build starts, analyzes one of two units, and finishes.
+	steps := [][2]int{{0, 0}, {2, 1}, {2, 1}, {2, 2}}
+	var calls int
+	progress := func() (int, int, error) {
+		s := steps[calls]
+		calls++
+		return s[0], s[1], nil
+	}
+	var buf bytes.Buffer
+	if err := waitForBuild("c", progress, time.Minute, time.Millisecond, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if calls != len(steps) {
+		t.Errorf("got %d progress calls, want %d", calls, len(steps))
+	}
+	want := "Waiting for the build of c to start...\nWaiting for the build of c (1 of 2 source units analyzed)...\nBuild of c finished.\n"
+	if buf.String() != want {
+		t.Errorf("got output %q, want %q", buf.String(), want)
+	}
+
+	// A finished build produces no output.
+	buf.Reset()
+	done := func() (int, int, error) { return 1, 1, nil }
+	if err := waitForBuild("c", done, time.Minute, time.Millisecond, &buf); err != nil || buf.Len() != 0 {
+		t.Errorf("got err %v and output %q, want neither", err, buf.String())
+	}
+
+	stuck := func() (int, int, error) { return 2, 1, nil }
+	if err := waitForBuild("c", stuck, 5*time.Millisecond, time.Millisecond, &buf); err == nil {
+		t.Error("got no error for a build that doesn't finish")
+	}
+
+	failing := func() (int, int, error) { return 0, 0, errors.New("x") }
+	if err := waitForBuild("c", failing, time.Minute, time.Millisecond, &buf); err == nil {
+		t.Error("got no error when checking the build progress fails")
+	}
+}
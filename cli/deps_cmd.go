@@ -0,0 +1,210 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+func init() {
+	c, err := CLI.AddCommand("deps",
+		"inspect the current repo's dependencies",
+		"The deps command inspects the resolved dependencies of the repository at DIR.",
+		&depsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("unused",
+		"list dependencies that the code doesn't refer to",
+		"The unused command lists the resolved dependencies of the repository at DIR that none of its refs point to, which are candidates for removal from its dependency lists. A dependency that resolves to a specific source unit is only used if refs point to that source unit; otherwise, refs to any source unit in its repository count. The repository is built (if needed) first.\n\nA dependency can be needed even though no refs point to it (e.g., if it's only used at build time, or by code that the toolchain doesn't analyze), so check each one before removing it.",
+		&depsUnusedCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type DepsCmd struct{}
+
+var depsCmd DepsCmd
+
+func (c *DepsCmd) Execute(args []string) error { return nil }
+
+type DepsUnusedCmd struct {
+	All  bool `long:"all" description:"list all resolved dependencies with the number of refs to each, not just unused ones"`
+	JSON bool `long:"json" description:"print the dependencies (and their ref counts) as JSON"`
+
+	Args struct {
+		Dir Directory `name:"DIR" default:"." description:"root directory of the repository"`
+	} `positional-args:"yes"`
+}
+
+var depsUnusedCmd DepsUnusedCmd
+
+// depUsage is the number of refs from a repo's code to one of its
+// resolved dependencies.
+type depUsage struct {
+	Repo     string
+	UnitType string `json:",omitempty"`
+	Unit     string `json:",omitempty"`
+	Version  string `json:",omitempty"`
+
+	Refs int
+}
+
+func (d *depUsage) String() string {
+	s := d.Repo
+	if d.Unit != "" {
+		s += " " + d.Unit
+		if d.UnitType != "" {
+			s += " (" + d.UnitType + ")"
+		}
+	}
+	if d.Version != "" {
+		s += " @ " + d.Version
+	}
+	return s
+}
+
+func (c *DepsUnusedCmd) Execute(args []string) error {
+	context, err := prepareCommandContext(c.Args.Dir.String())
+	if err != nil {
+		return err
+	}
+	deps, foundDepresolve, err := getDepResolutions(context)
+	if err != nil {
+		return err
+	}
+	if !foundDepresolve {
+		return fmt.Errorf("No dependency information found. Try running `%s config` first.", srclib.CommandName)
+	}
+
+	s, err := OpenStoreReadOnly()
+	if err != nil {
+		return err
+	}
+	rs, ok := s.(store.RepoStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing refs", s)
+	}
+	var refs []*graph.Ref
+	if repos := resolvedDepRepos(deps, context.repo.URI()); len(repos) > 0 {
+		refs, err = rs.Refs(store.ByCommitIDs(context.repo.CommitID), store.ByRefDefRepos(repos...))
+		if err != nil {
+			return err
+		}
+	}
+
+	usages, unresolved := depUsages(deps, refs, context.repo.URI())
+	if c.JSON {
+		if !c.All {
+			usages = unusedDeps(usages)
+		}
+		PrintJSON(usages, "  ")
+		return nil
+	}
+
+	unused := unusedDeps(usages)
+	if c.All {
+		for _, d := range usages {
+			colorable.Printf("%6d  %s\n", d.Refs, d)
+		}
+	} else if len(unused) == 0 {
+		colorable.Printf("All %d resolved dependencies are referenced.\n", len(usages))
+	} else {
+		colorable.Printf("%d of %d resolved dependencies are not referenced:\n", len(unused), len(usages))
+		for _, d := range unused {
+			colorable.Printf("  %s\n", d)
+		}
+	}
+	if unresolved > 0 {
+		colorable.Printf("(%d dependencies couldn't be resolved and weren't checked.)\n", unresolved)
+	}
+	return nil
+}
+
+// depUsages counts the refs to each of the distinct resolved
+// dependencies in deps (excluding those that resolve to currentRepo),
+// sorted by repo and source unit. It also returns the number of deps
+// that weren't resolved.
+func depUsages(deps []*dep.Resolution, refs []*graph.Ref, currentRepo string) ([]*depUsage, int) {
+	type defUnit struct{ repo, unitType, unit string }
+	refCounts := map[defUnit]int{}
+	for _, ref := range refs {
+		if ref.DefRepo != "" && !ref.Def {
+			refCounts[defUnit{ref.DefRepo, ref.DefUnitType, ref.DefUnit}]++
+		}
+	}
+
+	var usages []*depUsage
+	unresolved := 0
+	seen := map[string]bool{}
+	for _, d := range deps {
+		if d.Target == nil {
+			unresolved++
+			continue
+		}
+		uri, err := graph.TryMakeURI(d.Target.ToRepoCloneURL)
+		if err != nil || uri == "" || graph.URIEqual(uri, currentRepo) {
+			continue
+		}
+		u := &depUsage{Repo: uri, UnitType: d.Target.ToUnitType, Unit: d.Target.ToUnit, Version: d.Target.ToVersionString}
+		if seen[u.String()] {
+			continue
+		}
+		seen[u.String()] = true
+		for du, n := range refCounts {
+			if graph.URIEqual(du.repo, uri) && (u.Unit == "" || du.unit == u.Unit) && (u.UnitType == "" || du.unitType == u.UnitType) {
+				u.Refs += n
+			}
+		}
+		usages = append(usages, u)
+	}
+	sort.Sort(depUsagesByName(usages))
+	return usages, unresolved
+}
+
+// resolvedDepRepos returns the distinct repos that deps resolve to,
+// excluding currentRepo.
+func resolvedDepRepos(deps []*dep.Resolution, currentRepo string) []string {
+	var repos []string
+	seen := map[string]bool{}
+	for _, d := range deps {
+		if d.Target == nil {
+			continue
+		}
+		uri, err := graph.TryMakeURI(d.Target.ToRepoCloneURL)
+		if err != nil || uri == "" || graph.URIEqual(uri, currentRepo) || seen[uri] {
+			continue
+		}
+		seen[uri] = true
+		repos = append(repos, uri)
+	}
+	return repos
+}
+
+// unusedDeps returns the deps in usages that have no refs.
+func unusedDeps(usages []*depUsage) []*depUsage {
+	var unused []*depUsage
+	for _, d := range usages {
+		if d.Refs == 0 {
+			unused = append(unused, d)
+		}
+	}
+	return unused
+}
+
+type depUsagesByName []*depUsage
+
+func (v depUsagesByName) Len() int           { return len(v) }
+func (v depUsagesByName) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v depUsagesByName) Less(i, j int) bool { return v[i].String() < v[j].String() }
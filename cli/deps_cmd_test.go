@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestDepUsages(t *testing.T) {
+	deps := []*dep.Resolution{
+		{Target: &dep.ResolvedTarget{ToRepoCloneURL: "https://github.com/a/used", ToUnit: "u", ToUnitType: "t"}},
+		{Target: &dep.ResolvedTarget{ToRepoCloneURL: "https://github.com/a/used", ToUnit: "other", ToUnitType: "t"}},
+		{Target: &dep.ResolvedTarget{ToRepoCloneURL: "https://github.com/a/repo-level"}},
+		{Target: &dep.ResolvedTarget{ToRepoCloneURL: "https://github.com/a/unused", ToVersionString: "1.0"}},
+		{Target: &dep.ResolvedTarget{ToRepoCloneURL: "https://github.com/a/unused", ToVersionString: "1.0"}},
+		{Target: &dep.ResolvedTarget{ToRepoCloneURL: "https://github.com/me/self"}},
+		{Error: "not found"},
+	}
+	refs := []*graph.Ref{
+		{DefRepo: "github.com/a/used", DefUnitType: "t", DefUnit: "u", DefPath: "p"},
+		{DefRepo: "github.com/a/used", DefUnitType: "t", DefUnit: "u", DefPath: "q"},
+		{DefRepo: "github.com/a/repo-level", DefUnitType: "t", DefUnit: "x", DefPath: "p"},
+		{DefRepo: "github.com/a/unused", DefPath: "p", Def: true},
+		{DefPath: "p"},
+	}
+
+	usages, unresolved := depUsages(deps, refs, "github.com/me/self")
+	if unresolved != 1 {
+		t.Errorf("got %d unresolved, want 1", unresolved)
+	}
+	want := []*depUsage{
+		{Repo: "github.com/a/repo-level", Refs: 1},
+		{Repo: "github.com/a/unused", Version: "1.0"},
+		{Repo: "github.com/a/used", UnitType: "t", Unit: "other"},
+		{Repo: "github.com/a/used", UnitType: "t", Unit: "u", Refs: 2},
+	}
+	if !reflect.DeepEqual(usages, want) {
+		t.Errorf("got usages %v, want %v", usages, want)
+	}
+	if unused := unusedDeps(usages); !reflect.DeepEqual(unused, []*depUsage{want[1], want[2]}) {
+		t.Errorf("got unused %v", unused)
+	}
+}
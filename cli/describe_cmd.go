@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func init() {
+	_, err := CLI.AddCommand("describe",
+		"describe the def at a position in a file (as JSON)",
+		"The describe command prints (as JSON) the key, definition, and documentation of the def referred to by the ref at a position in a file. The position is specified by --byte-offset or by --line and --col (1-based). The def is looked up in the current repository's store and then in the global store. If there is no ref at the position, an empty JSON object is printed. The current repository is built (if needed) first.",
+		&describeCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type DescribeCmd struct {
+	File       string `long:"file" required:"yes" value-name:"FILE"`
+	ByteOffset int    `long:"byte-offset" value-name:"BYTE" default:"-1"`
+	Line       int    `long:"line" value-name:"LINE"`
+	Col        int    `long:"col" value-name:"COL"`
+}
+
+var describeCmd DescribeCmd
+
+// describeOutput is the output of the describe command.
+type describeOutput struct {
+	// Key is the key of the def that the ref refers to. It is set
+	// even if the def itself isn't found.
+	Key *graph.DefKey `json:",omitempty"`
+
+	// Ref is the ref at the position.
+	Ref *graph.Ref `json:",omitempty"`
+
+	Def *graph.Def    `json:",omitempty"`
+	Doc *graph.DefDoc `json:",omitempty"`
+}
+
+func (c *DescribeCmd) Execute(args []string) error {
+	if (c.ByteOffset >= 0) == (c.Line > 0 || c.Col > 0) {
+		return fmt.Errorf("specify the position with either --byte-offset or --line and --col")
+	}
+	if c.ByteOffset < 0 && (c.Line <= 0 || c.Col <= 0) {
+		return fmt.Errorf("both --line and --col must be specified")
+	}
+
+	context, err := prepareCommandContext(c.File)
+	if err != nil {
+		return err
+	}
+	file := context.relativeFile
+	commitID := context.repo.CommitID
+
+	ofs := uint32(c.ByteOffset)
+	if c.ByteOffset < 0 {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if ofs, err = lineColToByteOffset(data, c.Line, c.Col); err != nil {
+			return err
+		}
+	}
+
+	s, err := OpenStoreReadOnly()
+	if err != nil {
+		return err
+	}
+	rs, ok := s.(store.RepoStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing defs and refs", s)
+	}
+
+	var out describeOutput
+	ref, err := refAtOffset(rs, commitID, file, ofs)
+	if err != nil {
+		return err
+	}
+	if ref == nil {
+		if GlobalOpt.Verbose {
+			log.Printf("No ref found at %s:@%d.", file, ofs)
+		}
+		PrintJSON(out, "")
+		return nil
+	}
+	out.Ref = ref
+
+	key := ref.DefKey()
+	repoURI := context.repo.URI()
+	if key.Repo == "" || graph.URIEqual(key.Repo, repoURI) {
+		key.Repo = repoURI
+		key.CommitID = commitID
+	}
+	out.Key = &key
+
+	u := unit.ID2{Type: key.UnitType, Name: key.Unit}
+	var defs []*graph.Def
+	if key.CommitID == commitID {
+		defs, err = rs.Defs(store.ByCommitIDs(commitID), store.ByUnits(u), store.ByDefPath(key.Path))
+	} else {
+		globalStore := store.NewFSMultiRepoStore(rwvfs.Walkable(rwvfs.ReadOnly(rwvfs.OS(srclib.StoreDir))), nil)
+		defs, err = globalStore.Defs(store.ByRepos(key.Repo), store.ByUnits(u), store.ByDefPath(key.Path))
+	}
+	if err != nil {
+		return err
+	}
+	if len(defs) > 0 {
+		out.Def = defs[0]
+		if out.Def.Repo == "" {
+			out.Def.Repo = key.Repo
+		}
+		out.Doc = preferredDoc(out.Def.Docs)
+	} else if GlobalOpt.Verbose {
+		log.Printf("No def found for %+v (has its build data been imported into the store?).", key)
+	}
+
+	PrintJSON(out, "")
+	return nil
+}
@@ -0,0 +1,362 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+func init() {
+	c, err := CLI.AddCommand("doc",
+		"show documentation for a def",
+		"The doc command prints the signature, documentation, and usage examples of a def, specified by its def path or name. The def is looked up in the current repository's store (at the current commit) and then in the global store (which contains the repository's dependencies).",
+		&docCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	SetDefaultCommitIDOpt(c)
+}
+
+type DocCmd struct {
+	CommitID     string `long:"commit" description:"commit ID of the current repository to look up defs in"`
+	Examples     int    `long:"examples" description:"max number of usage examples to show (0 for none)" default:"3"`
+	ExamplesFrom string `long:"examples-from" description:"where to take usage examples from: any (but prefer test files, which usually show intended usage), tests, or nontests" default:"any" value-name:"any|tests|nontests"`
+	Context      string `long:"context" description:"lines of context to show around usage examples, or auto to show the whole enclosing def if it is short (and 2 lines otherwise)" default:"auto" value-name:"auto|N"`
+
+	SignatureOpt
+
+	Args struct {
+		Def string `name:"DEF" description:"def path (e.g., pkg/Type/Method) or name"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var docCmd DocCmd
+
+func (c *DocCmd) Execute(args []string) error {
+	switch c.ExamplesFrom {
+	case "any", "tests", "nontests":
+	default:
+		return fmt.Errorf("unrecognized --examples-from value: %q (valid values are any, tests, nontests)", c.ExamplesFrom)
+	}
+	context, err := parseExampleContext(c.Context)
+	if err != nil {
+		return err
+	}
+	if err := c.SignatureOpt.validate(); err != nil {
+		return err
+	}
+
+	repo, err := OpenLocalRepo()
+	if err != nil {
+		return err
+	}
+
+	var localStore store.RepoStore
+	if repo != nil && repo.RootDir != "" {
+		// Ref (example) files are relative to the top-level dir of
+		// the repository.
+		if err := os.Chdir(repo.RootDir); err != nil {
+			return err
+		}
+		localStore = store.NewFSRepoStore(rwvfs.ReadOnly(rwvfs.OS(store.SrclibStoreDir)))
+	}
+	globalStore := store.NewFSMultiRepoStore(rwvfs.Walkable(rwvfs.ReadOnly(rwvfs.OS(srclib.StoreDir))), nil)
+
+	var defs []*graph.Def
+	if localStore != nil {
+		defs, err = findDefs(localStore, c.Args.Def, store.ByCommitIDs(c.CommitID))
+		if err != nil {
+			return err
+		}
+	}
+	if len(defs) == 0 {
+		if defs, err = findDefs(globalStore, c.Args.Def); err != nil {
+			return err
+		}
+	}
+
+	switch len(defs) {
+	case 0:
+		return fmt.Errorf("no def found matching %q (has the build data been imported into the store?)", c.Args.Def)
+	case 1:
+	default:
+		colorable.Printf("%q matches %d defs; specify one by its def path:\n", c.Args.Def, len(defs))
+		for _, def := range defs {
+			colorable.Printf("  %s\t%s\n", def.Path, defLocation(def))
+		}
+		return fmt.Errorf("ambiguous def %q", c.Args.Def)
+	}
+	def := defs[0]
+
+	colorable.Println(colorable.Bold(def.Name) + " (" + def.Kind + ")")
+	colorable.Println(defLocation(def))
+
+	if c.Signature != "none" {
+		if sig, err := defSignature(def); err != nil {
+			log.Printf("Warning: formatting signature of %s: %s", def.Path, err)
+		} else if sig = c.SignatureOpt.format(sig); sig != "" {
+			colorable.Println()
+			colorable.Println(sig)
+		}
+	}
+
+	colorable.Println()
+	if d := preferredDoc(def.Docs); d != nil {
+		colorable.Println(renderDoc(d))
+	} else {
+		colorable.Println("(no documentation)")
+	}
+
+	if c.Examples > 0 && localStore != nil {
+		examples, err := defExamples(localStore, def, c.CommitID, c.Examples, c.ExamplesFrom, context)
+		if err != nil {
+			return err
+		}
+		if len(examples) > 0 {
+			colorable.Println()
+			colorable.Println(colorable.Bold("EXAMPLES"))
+			for _, ex := range examples {
+				colorable.Println()
+				colorable.Println(ex)
+			}
+		}
+	}
+	return nil
+}
+
+// findDefs returns the defs in s whose def path is defSpec or, if
+// there are none, whose name is defSpec. Exact (case-sensitive) name
+// matches are preferred over case-insensitive ones.
+func findDefs(s store.RepoStore, defSpec string, filters ...store.DefFilter) ([]*graph.Def, error) {
+	defs, err := s.Defs(append(filters, store.ByDefPath(defSpec))...)
+	if err != nil || len(defs) > 0 {
+		return defs, err
+	}
+
+	defs, err = s.Defs(append(filters, store.ByDefQuery(defSpec))...)
+	if err != nil {
+		return nil, err
+	}
+	var exact, folded []*graph.Def
+	for _, def := range defs {
+		if def.Name == defSpec {
+			exact = append(exact, def)
+		} else if strings.EqualFold(def.Name, defSpec) {
+			folded = append(folded, def)
+		}
+	}
+	if len(exact) > 0 {
+		return exact, nil
+	}
+	return folded, nil
+}
+
+// defLocation returns a human-readable description of where def is
+// defined.
+func defLocation(def *graph.Def) string {
+	loc := def.File
+	if def.Repo != "" {
+		loc = def.Repo + ": " + loc
+	}
+	return fmt.Sprintf("%s (%s %s)", loc, def.UnitType, def.Unit)
+}
+
+// defSignature returns the def's declaration, formatted by its
+// toolchain.
+func defSignature(def *graph.Def) (string, error) {
+	b, err := json.Marshal(def)
+	if err != nil {
+		return "", err
+	}
+	c := &FmtCmd{
+		UnitType:   def.UnitType,
+		ObjectType: "def",
+		Format:     "decl",
+		Object:     string(b),
+	}
+	return c.Get()
+}
+
+// defExamples returns up to n snippets of code in the current
+// repository that refer to def. The refs are chosen and ordered by
+// from (see exampleRefs), and the snippets' surrounding lines by
+// context.
+func defExamples(s store.RepoStore, def *graph.Def, commitID string, n int, from string, context exampleContext) ([]string, error) {
+	refs, err := s.Refs(
+		store.ByCommitIDs(commitID),
+		store.ByRefDef(graph.RefDefKey{
+			DefRepo:     def.Repo,
+			DefUnitType: def.UnitType,
+			DefUnit:     def.Unit,
+			DefPath:     def.Path,
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	tests, err := testFiles(s, commitID)
+	if err != nil {
+		return nil, err
+	}
+	fileDefs := map[string][]*graph.Def{}
+	var examples []string
+	for _, ref := range exampleRefs(refs, tests, from) {
+		if len(examples) == n {
+			break
+		}
+		var enclosing []*graph.Def
+		if context.auto {
+			defs, present := fileDefs[ref.File]
+			if !present {
+				defs, err = s.Defs(store.ByCommitIDs(commitID), store.ByFiles(ref.File))
+				if err != nil {
+					return nil, err
+				}
+				fileDefs[ref.File] = defs
+			}
+			enclosing = defs
+		}
+		file := filepath.FromSlash(ref.File)
+		f, err := defaultFileCache.readFile(file)
+		if err != nil {
+			continue
+		}
+		start, end := context.segment(f, ref, enclosing)
+		if snippet := getFileSegment(file, start, end, true); snippet != "" {
+			examples = append(examples, snippet)
+		}
+	}
+	return examples, nil
+}
+
+// autoContextMaxDefLines is the maximum number of lines in a def that
+// encloses a usage example for the whole def to be shown (with
+// --context=auto). Examples in longer defs are shown with
+// autoContextLines lines of context.
+const (
+	autoContextMaxDefLines = 15
+	autoContextLines       = 2
+)
+
+// exampleContext is how much of the code around a usage example to
+// show.
+type exampleContext struct {
+	// auto is whether to show the whole def that encloses the
+	// example, if it is short.
+	auto bool
+
+	// lines is the number of lines to show before and after the
+	// example (if auto is set, when its enclosing def isn't shown).
+	lines int
+}
+
+// parseExampleContext parses a --context value: "auto" or a number of
+// lines.
+func parseExampleContext(s string) (exampleContext, error) {
+	if s == "auto" {
+		return exampleContext{auto: true, lines: autoContextLines}, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return exampleContext{}, fmt.Errorf("invalid --context value: %q (valid values are auto or a non-negative number of lines)", s)
+	}
+	return exampleContext{lines: n}, nil
+}
+
+// segment returns the byte range of f (the contents of ref's file) to
+// show for ref. defs are the defs in the file, which are only used if
+// c.auto is set.
+func (c exampleContext) segment(f []byte, ref *graph.Ref, defs []*graph.Def) (start, end uint32) {
+	if int(ref.End) > len(f) || ref.Start > ref.End {
+		return ref.Start, ref.End
+	}
+	if c.auto {
+		if def := enclosingDef(defs, ref); def != nil && int(def.DefEnd) <= len(f) && bytes.Count(f[def.DefStart:def.DefEnd], []byte{'\n'}) < autoContextMaxDefLines {
+			return def.DefStart, def.DefEnd
+		}
+	}
+	start, end = ref.Start, ref.End
+	// The range only needs to end up on the first and last lines to
+	// show, since the snippet is extended to whole lines.
+	for i := 0; i < c.lines && start > 0; {
+		start--
+		if f[start] == '\n' {
+			i++
+		}
+	}
+	for i := 0; i < c.lines && end < uint32(len(f)); end++ {
+		if f[end] == '\n' {
+			i++
+		}
+	}
+	return start, end
+}
+
+// enclosingDef returns the innermost of defs whose definition spans
+// ref, or nil if there is none.
+func enclosingDef(defs []*graph.Def, ref *graph.Ref) *graph.Def {
+	var inner *graph.Def
+	for _, def := range defs {
+		if def.File != ref.File || def.DefStart > ref.Start || def.DefEnd < ref.End || def.DefEnd <= def.DefStart {
+			continue
+		}
+		if inner == nil || def.DefEnd-def.DefStart < inner.DefEnd-inner.DefStart {
+			inner = def
+		}
+	}
+	return inner
+}
+
+// testFiles returns the files that contain test defs in s at
+// commitID.
+func testFiles(s store.RepoStore, commitID string) (map[string]bool, error) {
+	defs, err := s.Defs(store.ByCommitIDs(commitID), store.DefFilterFunc(func(def *graph.Def) bool { return def.Test }))
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool)
+	for _, def := range defs {
+		files[def.File] = true
+	}
+	return files, nil
+}
+
+// exampleRefs returns the refs (excluding def refs) to use as usage
+// examples. If from is "tests" or "nontests", only refs in (or not
+// in, respectively) test files are returned. If it is "any", refs in
+// test files, which usually demonstrate intended usage best, come
+// first. The order of refs is otherwise preserved.
+func exampleRefs(refs []*graph.Ref, testFiles map[string]bool, from string) []*graph.Ref {
+	var inTests, notInTests []*graph.Ref
+	for _, ref := range refs {
+		if ref.Def {
+			continue
+		}
+		if testFiles[ref.File] {
+			inTests = append(inTests, ref)
+		} else {
+			notInTests = append(notInTests, ref)
+		}
+	}
+	switch from {
+	case "tests":
+		return inTests
+	case "nontests":
+		return notInTests
+	default:
+		return append(inTests, notInTests...)
+	}
+}
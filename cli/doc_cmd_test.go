@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestExampleRefs(t *testing.T) {
+	refs := []*graph.Ref{
+		{File: "a.go", Start: 1},
+		{File: "a_test.go", Start: 2},
+		{File: "a.go", Start: 3, Def: true},
+		{File: "b.go", Start: 4},
+		{File: "a_test.go", Start: 5},
+	}
+	tests := map[string]bool{"a_test.go": true}
+
+	format := func(refs []*graph.Ref) string {
+		var s []string
+		for _, ref := range refs {
+			s = append(s, ref.File)
+		}
+		return strings.Join(s, " ")
+	}
+	for from, want := range map[string]string{
+		"any":      "a_test.go a_test.go a.go b.go",
+		"tests":    "a_test.go a_test.go",
+		"nontests": "a.go b.go",
+	} {
+		if got := format(exampleRefs(refs, tests, from)); got != want {
+			t.Errorf("%s: got %q, want %q", from, got, want)
+		}
+	}
+}
+
+func TestExampleContext_segment(t *testing.T) {
+	src := "package p\n\nfunc f() {\n\tg()\n}\n\nfunc h() {\n\tx := 1\n\tg()\n\t_ = x\n}\n"
+	f := []byte(src)
+	span := func(s string) (uint32, uint32) {
+		i := strings.Index(src, s)
+		return uint32(i), uint32(i + len(s))
+	}
+	fStart, fEnd := span("func f() {\n\tg()\n}")
+	hStart, hEnd := span("func h() {\n\tx := 1\n\tg()\n\t_ = x\n}")
+	defs := []*graph.Def{
+		{DefKey: graph.DefKey{Path: "f"}, File: "a.go", DefStart: fStart, DefEnd: fEnd},
+		{DefKey: graph.DefKey{Path: "h"}, File: "a.go", DefStart: hStart, DefEnd: hEnd},
+	}
+	gStart := uint32(strings.LastIndex(src, "g()"))
+	ref := &graph.Ref{File: "a.go", Start: gStart, End: gStart + 1}
+
+	lines := func(start, end uint32) string {
+		startLine, lines := fileSegmentLinesOf(f, start, end)
+		return fmt.Sprintf("%d:%s", startLine, strings.Join(lines, "|"))
+	}
+	tests := []struct {
+		context exampleContext
+		defs    []*graph.Def
+		want    string
+	}{
+		{exampleContext{}, nil, "9:\tg()"},
+		{exampleContext{lines: 1}, nil, "8:\tx := 1|\tg()|\t_ = x"},
+		{exampleContext{lines: 100}, nil, "1:" + strings.Replace(strings.TrimSuffix(src, "\n"), "\n", "|", -1) + "|"},
+		{exampleContext{auto: true, lines: 1}, defs, "7:func h() {|\tx := 1|\tg()|\t_ = x|}"},
+		{exampleContext{auto: true, lines: 1}, defs[:1], "8:\tx := 1|\tg()|\t_ = x"},
+	}
+	for _, test := range tests {
+		start, end := test.context.segment(f, ref, test.defs)
+		if got := lines(start, end); got != test.want {
+			t.Errorf("%+v: got %q, want %q", test.context, got, test.want)
+		}
+	}
+}
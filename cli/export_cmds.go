@@ -0,0 +1,288 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+func init() {
+	c, err := CLI.AddCommand("export",
+		"export build data",
+		"The export command exports the build data in the store to formats used by other tools.",
+		&exportCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	kytheC, err := c.AddCommand("kythe",
+		"export Kythe entries",
+		"The kythe command writes the defs, refs, and docs in the store to stdout as a Kythe entry stream (JSON, one entry per line). The stream can be used with Kythe's tools, e.g., `entrystream --read_format=json`.",
+		&exportKytheCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	SetDefaultRepoOpt(kytheC)
+	SetDefaultCommitIDOpt(kytheC)
+
+	_, err = c.AddCommand("rename-map",
+		"export the edits that rename a def",
+		"The rename-map command writes a JSON list of the edits (file, byte range, and replacement text) that rename a def and all refs to it to NEWNAME, for use by codemod tools. The def is specified either by the position (FILE:LINE:COL) of a ref to it or of its definition, or by its def path or name, and must be defined in the current repository. With --global, refs in other repositories in the global store are also included; their edits are relative to those repositories' roots.",
+		&exportRenameMapCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type ExportCmd struct{}
+
+var exportCmd ExportCmd
+
+func (c *ExportCmd) Execute(args []string) error { return nil }
+
+type ExportKytheCmd struct {
+	Repo     string `long:"repo" description:"repo URI, used as the Kythe corpus of defs and refs without a repo"`
+	CommitID string `long:"commit" description:"only export data for this commit"`
+
+	NoFileText bool `long:"no-file-text" description:"don't emit the text of each file (which is read from the local repository at the commit of the defs and refs in it; files in other repos are emitted without text)"`
+}
+
+var exportKytheCmd ExportKytheCmd
+
+func (c *ExportKytheCmd) Execute(args []string) error {
+	s, err := OpenStoreReadOnly()
+	if err != nil {
+		return err
+	}
+	us, ok := s.(store.UnitStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing defs and refs", s)
+	}
+
+	var defFilters []store.DefFilter
+	var refFilters []store.RefFilter
+	if c.CommitID != "" {
+		defFilters = append(defFilters, store.ByCommitIDs(c.CommitID))
+		refFilters = append(refFilters, store.ByCommitIDs(c.CommitID))
+	}
+	defs, err := us.Defs(defFilters...)
+	if err != nil {
+		return err
+	}
+	refs, err := us.Refs(refFilters...)
+	if err != nil {
+		return err
+	}
+
+	e := &kytheEmitter{w: os.Stdout, corpus: c.Repo, files: map[kytheVName]struct{}{}}
+	if !c.NoFileText {
+		e.fileText = localFileText(c.Repo)
+	}
+	for _, def := range defs {
+		e.def(def)
+	}
+	for _, ref := range refs {
+		e.ref(ref)
+	}
+	return e.err
+}
+
+// localFileText returns a func that reads the text of a file at a
+// commit of the local repository (whose corpus is corpus, or its URI),
+// or nil if there is no local repository.
+func localFileText(corpus string) func(corpus, commitID, file string) ([]byte, error) {
+	local, err := OpenLocalRepo()
+	if err != nil || local == nil || local.RootDir == "" {
+		return nil
+	}
+	return func(fileCorpus, commitID, file string) ([]byte, error) {
+		if fileCorpus != corpus && fileCorpus != local.URI() {
+			return nil, errors.New("not in the local repository")
+		}
+		if commitID == "" {
+			return nil, errors.New("unknown commit")
+		}
+		return fileAtCommit(local.VCSType, local.RootDir, commitID, file)
+	}
+}
+
+// kytheVName is a Kythe VName, which names a node in the Kythe
+// graph. See https://kythe.io/docs/kythe-storage.html.
+type kytheVName struct {
+	Signature string `json:"signature,omitempty"`
+	Corpus    string `json:"corpus,omitempty"`
+	Root      string `json:"root,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Language  string `json:"language,omitempty"`
+}
+
+// kytheEntry is a single Kythe entry: either a fact about a node
+// (when EdgeKind is empty) or an edge between two nodes.
+type kytheEntry struct {
+	Source    *kytheVName `json:"source"`
+	EdgeKind  string      `json:"edge_kind,omitempty"`
+	Target    *kytheVName `json:"target,omitempty"`
+	FactName  string      `json:"fact_name"`
+	FactValue []byte      `json:"fact_value,omitempty"`
+}
+
+// kytheNodeKinds maps common srclib def kinds to Kythe node
+// kinds. Defs with other kinds are emitted as "variable" nodes with
+// their srclib kind as the Kythe subkind.
+var kytheNodeKinds = map[string]string{
+	"func":      "function",
+	"method":    "function",
+	"function":  "function",
+	"type":      "record",
+	"class":     "record",
+	"interface": "interface",
+	"var":       "variable",
+	"field":     "variable",
+	"const":     "constant",
+	"package":   "package",
+	"module":    "package",
+}
+
+// kytheEmitter writes Kythe entries for srclib defs and refs. The
+// first error encountered is stored in err, and all subsequent
+// writes are skipped.
+type kytheEmitter struct {
+	w      io.Writer
+	corpus string // corpus of defs and refs whose repo is empty
+
+	// fileText, if set, returns the text of a file (in a corpus) at a
+	// commit, which is emitted with the file's node.
+	fileText func(corpus, commitID, file string) ([]byte, error)
+
+	files map[kytheVName]struct{} // files whose nodes have been emitted
+	err   error
+}
+
+func (e *kytheEmitter) emit(entry *kytheEntry) {
+	if e.err != nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		e.err = err
+		return
+	}
+	_, e.err = fmt.Fprintf(e.w, "%s\n", data)
+}
+
+func (e *kytheEmitter) fact(node *kytheVName, name string, value []byte) {
+	e.emit(&kytheEntry{Source: node, FactName: name, FactValue: value})
+}
+
+func (e *kytheEmitter) edge(source *kytheVName, kind string, target *kytheVName) {
+	e.emit(&kytheEntry{Source: source, EdgeKind: kind, Target: target, FactName: "/"})
+}
+
+func (e *kytheEmitter) repoOrCorpus(repo string) string {
+	if repo == "" {
+		return e.corpus
+	}
+	return repo
+}
+
+// defVName returns the VName of the def with the given key. The
+// source unit is included in the signature because def paths are
+// only unique within a source unit.
+func (e *kytheEmitter) defVName(repo, unitType, unit, path string) *kytheVName {
+	return &kytheVName{
+		Signature: fmt.Sprintf("%s:%s#%s", unitType, unit, path),
+		Corpus:    e.repoOrCorpus(repo),
+		Language:  unitType,
+	}
+}
+
+// file emits the node for a file (once per file in each corpus) and
+// returns its VName. The file's text is read at commitID.
+func (e *kytheEmitter) file(repo, commitID, file string) *kytheVName {
+	v := &kytheVName{Corpus: e.repoOrCorpus(repo), Path: file}
+	if _, seen := e.files[*v]; seen {
+		return v
+	}
+	e.files[*v] = struct{}{}
+	e.fact(v, "/kythe/node/kind", []byte("file"))
+	if e.fileText != nil {
+		if text, err := e.fileText(v.Corpus, commitID, file); err == nil {
+			e.fact(v, "/kythe/text", text)
+		} else if GlobalOpt.Verbose {
+			log.Printf("Warning: not emitting text for file %q in %s: %s", file, v.Corpus, err)
+		}
+	}
+	return v
+}
+
+// anchor emits an anchor node spanning [start, end) in file (at
+// commitID) and returns its VName.
+func (e *kytheEmitter) anchor(repo, commitID, unitType, file string, start, end uint32) *kytheVName {
+	fileV := e.file(repo, commitID, file)
+	v := &kytheVName{
+		Signature: fmt.Sprintf("@%d:%d", start, end),
+		Corpus:    fileV.Corpus,
+		Path:      file,
+		Language:  unitType,
+	}
+	e.fact(v, "/kythe/node/kind", []byte("anchor"))
+	e.fact(v, "/kythe/loc/start", []byte(fmt.Sprint(start)))
+	e.fact(v, "/kythe/loc/end", []byte(fmt.Sprint(end)))
+	e.edge(v, "/kythe/edge/childof", fileV)
+	return v
+}
+
+func (e *kytheEmitter) def(def *graph.Def) {
+	v := e.defVName(def.Repo, def.UnitType, def.Unit, def.Path)
+	kind, ok := kytheNodeKinds[def.Kind]
+	if !ok {
+		kind = "variable"
+	}
+	e.fact(v, "/kythe/node/kind", []byte(kind))
+	if !ok && def.Kind != "" {
+		e.fact(v, "/kythe/subkind", []byte(def.Kind))
+	}
+	if def.File != "" {
+		a := e.anchor(def.Repo, def.CommitID, def.UnitType, def.File, def.DefStart, def.DefEnd)
+		e.edge(a, "/kythe/edge/defines", v)
+	}
+	for i, doc := range def.Docs {
+		docV := &kytheVName{
+			Signature: fmt.Sprintf("%s#doc%d", v.Signature, i),
+			Corpus:    v.Corpus,
+			Language:  v.Language,
+		}
+		e.fact(docV, "/kythe/node/kind", []byte("doc"))
+		e.fact(docV, "/kythe/text", []byte(doc.Data))
+		e.fact(docV, "/kythe/text/encoding", []byte("UTF-8"))
+		e.edge(docV, "/kythe/edge/documents", v)
+	}
+}
+
+func (e *kytheEmitter) ref(ref *graph.Ref) {
+	if ref.Def {
+		// The def's "defines" anchor was already emitted.
+		return
+	}
+	defRepo, defUnitType, defUnit := ref.DefRepo, ref.DefUnitType, ref.DefUnit
+	if defRepo == "" {
+		defRepo = ref.Repo
+	}
+	if defUnitType == "" {
+		defUnitType = ref.UnitType
+	}
+	if defUnit == "" {
+		defUnit = ref.Unit
+	}
+	a := e.anchor(ref.Repo, ref.CommitID, ref.UnitType, ref.File, ref.Start, ref.End)
+	e.edge(a, "/kythe/edge/ref", e.defVName(defRepo, defUnitType, defUnit, ref.DefPath))
+}
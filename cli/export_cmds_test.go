@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// kytheEntries decodes the entry stream written by a kytheEmitter.
+func kytheEntries(t *testing.T, data []byte) []*kytheEntry {
+	var entries []*kytheEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var e *kytheEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// findKytheFact returns the value of the named fact of the node with
+// the given signature and path, and whether it was found.
+func findKytheFact(entries []*kytheEntry, sig, path, name string) (string, bool) {
+	for _, e := range entries {
+		if e.EdgeKind == "" && e.Source.Signature == sig && e.Source.Path == path && e.FactName == name {
+			return string(e.FactValue), true
+		}
+	}
+	return "", false
+}
+
+// hasKytheEdge reports whether there's an edge of the given kind from
+// the node with signature source to the node with signature target.
+func hasKytheEdge(entries []*kytheEntry, source, kind, target string) bool {
+	for _, e := range entries {
+		if e.EdgeKind == kind && e.Source.Signature == source && e.Target.Signature == target {
+			return true
+		}
+	}
+	return false
+}
+
+func TestKytheEmitter_def(t *testing.T) {
+	var buf bytes.Buffer
+	var read []string
+	e := &kytheEmitter{w: &buf, corpus: "r", files: map[kytheVName]struct{}{}}
+	e.fileText = func(corpus, commitID, file string) ([]byte, error) {
+		read = append(read, corpus+"@"+commitID+":"+file)
+		return []byte("package p"), nil
+	}
+	e.def(&graph.Def{
+		DefKey:   graph.DefKey{CommitID: "c", UnitType: "GoPackage", Unit: "p", Path: "F"},
+		Name:     "F",
+		Kind:     "func",
+		File:     "f.go",
+		DefStart: 5,
+		DefEnd:   6,
+		Docs:     []*graph.DefDoc{{Format: "text/plain", Data: "F does it."}},
+	})
+	e.def(&graph.Def{DefKey: graph.DefKey{CommitID: "c", UnitType: "GoPackage", Unit: "p", Path: "T"}, Kind: "struct", File: "f.go"})
+	if e.err != nil {
+		t.Fatal(e.err)
+	}
+	entries := kytheEntries(t, buf.Bytes())
+
+	const sig = "GoPackage:p#F"
+	if kind, _ := findKytheFact(entries, sig, "", "/kythe/node/kind"); kind != "function" {
+		t.Errorf("got def node kind %q, want function", kind)
+	}
+	if kind, _ := findKytheFact(entries, "GoPackage:p#T", "", "/kythe/subkind"); kind != "struct" {
+		t.Errorf("got unknown kind's subkind %q, want struct", kind)
+	}
+	if start, _ := findKytheFact(entries, "@5:6", "f.go", "/kythe/loc/start"); start != "5" {
+		t.Errorf("got anchor start %q, want 5", start)
+	}
+	if !hasKytheEdge(entries, "@5:6", "/kythe/edge/defines", sig) {
+		t.Error("no defines edge from the anchor to the def")
+	}
+	if text, _ := findKytheFact(entries, sig+"#doc0", "", "/kythe/text"); text != "F does it." {
+		t.Errorf("got doc text %q, want the def's doc", text)
+	}
+	if !hasKytheEdge(entries, sig+"#doc0", "/kythe/edge/documents", sig) {
+		t.Error("no documents edge from the doc to the def")
+	}
+
+	// The file node (and its text, read at the def's commit) is only
+	// emitted once.
+	if text, _ := findKytheFact(entries, "", "f.go", "/kythe/text"); text != "package p" {
+		t.Errorf("got file text %q, want %q", text, "package p")
+	}
+	if want := "r@c:f.go"; len(read) != 1 || read[0] != want {
+		t.Errorf("got file text reads %v, want [%s]", read, want)
+	}
+}
+
+func TestKytheEmitter_ref(t *testing.T) {
+	var buf bytes.Buffer
+	e := &kytheEmitter{w: &buf, corpus: "r", files: map[kytheVName]struct{}{}}
+	e.ref(&graph.Ref{DefUnitType: "GoPackage", DefUnit: "p", DefPath: "F", UnitType: "GoPackage", Unit: "q", File: "f.go", Start: 1, End: 2})
+	e.ref(&graph.Ref{DefRepo: "r", DefUnitType: "GoPackage", DefUnit: "p", DefPath: "F", Repo: "other", UnitType: "GoPackage", Unit: "q", File: "f.go", Start: 3, End: 4})
+	e.ref(&graph.Ref{DefPath: "F", UnitType: "GoPackage", Unit: "p", File: "f.go", Start: 5, End: 6, Def: true})
+	if e.err != nil {
+		t.Fatal(e.err)
+	}
+	entries := kytheEntries(t, buf.Bytes())
+
+	if !hasKytheEdge(entries, "@1:2", "/kythe/edge/ref", "GoPackage:p#F") || !hasKytheEdge(entries, "@3:4", "/kythe/edge/ref", "GoPackage:p#F") {
+		t.Error("missing ref edges from the anchors to the def")
+	}
+	if _, found := findKytheFact(entries, "@5:6", "f.go", "/kythe/node/kind"); found {
+		t.Error("got an anchor for a def's own ref, want none")
+	}
+
+	// The same path in two corpora is two files.
+	fileCorpora := map[string]int{}
+	for _, entry := range entries {
+		if entry.FactName == "/kythe/node/kind" && string(entry.FactValue) == "file" {
+			fileCorpora[entry.Source.Corpus]++
+		}
+	}
+	if fileCorpora["r"] != 1 || fileCorpora["other"] != 1 {
+		t.Errorf("got file nodes per corpus %v, want 1 in each of r and other", fileCorpora)
+	}
+}
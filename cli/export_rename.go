@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+type ExportRenameMapCmd struct {
+	Global bool `long:"global" description:"also include edits to refs in other repositories in the global store"`
+
+	Args struct {
+		Def     string `name:"DEF" description:"FILE:LINE:COL position, or def path or name"`
+		NewName string `name:"NEWNAME" description:"new name of the def"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var exportRenameMapCmd ExportRenameMapCmd
+
+// renameEdit is an edit that replaces the bytes in [Start, End) of a
+// file with Replacement.
+type renameEdit struct {
+	Repo        string
+	File        string
+	Start       uint32
+	End         uint32
+	Replacement string
+}
+
+func (c *ExportRenameMapCmd) Execute(args []string) error {
+	if c.Args.NewName == "" {
+		return fmt.Errorf("NEWNAME must not be empty")
+	}
+
+	context, rs, defKey, err := resolveDefArg(c.Args.Def)
+	if err != nil {
+		return err
+	}
+	repoURI := context.repo.URI()
+	commitID := context.repo.CommitID
+	if defKey.DefRepo != "" && !graph.URIEqual(defKey.DefRepo, repoURI) {
+		return fmt.Errorf("def %s is defined in %s, not in the current repository", defKey.DefPath, defKey.DefRepo)
+	}
+
+	defs, err := rs.Defs(store.ByCommitIDs(commitID), store.ByDefKey(graph.DefKey{UnitType: defKey.DefUnitType, Unit: defKey.DefUnit, Path: defKey.DefPath}))
+	if err != nil {
+		return err
+	}
+	if len(defs) == 0 {
+		return fmt.Errorf("def %s not found in the current repository", defKey.DefPath)
+	}
+	name := defs[0].Name
+
+	refs, err := rs.Refs(store.ByCommitIDs(commitID), store.ByRefDef(defKey))
+	if err != nil {
+		return err
+	}
+	readFile := func(file string) ([]byte, error) {
+		return ioutil.ReadFile(filepath.Join(context.repo.RootDir, file))
+	}
+	edits, skipped := renameEdits(refs, name, c.Args.NewName, readFile)
+	for _, e := range edits {
+		e.Repo = repoURI
+	}
+
+	if c.Global {
+		absDef := defKey
+		absDef.DefRepo = repoURI
+		globalStore := store.NewFSMultiRepoStore(rwvfs.Walkable(rwvfs.ReadOnly(rwvfs.OS(srclib.StoreDir))), nil)
+		globalRefs, err := globalStore.Refs(store.ByRefDef(absDef))
+		if err != nil {
+			return err
+		}
+		var otherRefs []*graph.Ref
+		for _, ref := range globalRefs {
+			if !graph.URIEqual(ref.Repo, repoURI) {
+				otherRefs = append(otherRefs, ref)
+			}
+		}
+		// Other repos' files aren't available locally, so their refs'
+		// byte ranges are used as-is.
+		otherEdits, _ := renameEdits(otherRefs, name, c.Args.NewName, nil)
+		edits = append(edits, otherEdits...)
+	}
+
+	for _, ref := range skipped {
+		log.Printf("warning: skipping ref at %s:@%d-%d, which does not contain the name %q", ref.File, ref.Start, ref.End, name)
+	}
+
+	sort.Sort(renameEditsByPosition(edits))
+	if edits == nil {
+		edits = []*renameEdit{}
+	}
+	PrintJSON(edits, "  ")
+	return nil
+}
+
+// renameEdits returns the edits that replace name with newName in
+// each of refs. If readFile is non-nil, it is used to read the refs'
+// files, and each edit is narrowed to the occurrence of name at the
+// end of the ref's byte range (so that, e.g., only the last component
+// of a qualified name is replaced); refs whose text does not end in
+// name are returned in skipped. Duplicate refs yield a single edit.
+func renameEdits(refs []*graph.Ref, name, newName string, readFile func(file string) ([]byte, error)) (edits []*renameEdit, skipped []*graph.Ref) {
+	type editKey struct {
+		repo, file string
+		start, end uint32
+	}
+	seen := map[editKey]bool{}
+	files := map[string][]byte{}
+	for _, ref := range refs {
+		start, end := ref.Start, ref.End
+		if readFile != nil {
+			data, ok := files[ref.File]
+			if !ok {
+				var err error
+				if data, err = readFile(ref.File); err != nil {
+					data = nil
+				}
+				files[ref.File] = data
+			}
+			if int(end) > len(data) || start > end || !bytes.HasSuffix(data[start:end], []byte(name)) {
+				skipped = append(skipped, ref)
+				continue
+			}
+			start = end - uint32(len(name))
+		}
+
+		k := editKey{ref.Repo, ref.File, start, end}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		edits = append(edits, &renameEdit{Repo: ref.Repo, File: ref.File, Start: start, End: end, Replacement: newName})
+	}
+	return edits, skipped
+}
+
+type renameEditsByPosition []*renameEdit
+
+func (v renameEditsByPosition) Len() int      { return len(v) }
+func (v renameEditsByPosition) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v renameEditsByPosition) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	if a.Repo != b.Repo {
+		return a.Repo < b.Repo
+	}
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	return a.Start < b.Start
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestRenameEdits(t *testing.T) {
+	files := map[string]string{
+		"a.go": "func Foo() {}\nvar x = pkg.Foo()\nvar y = Bar()\n",
+	}
+	readFile := func(file string) ([]byte, error) {
+		data, ok := files[file]
+		if !ok {
+			return nil, fmt.Errorf("no such file %q", file)
+		}
+		return []byte(data), nil
+	}
+	refs := []*graph.Ref{
+		{File: "a.go", Start: 5, End: 8, Def: true}, // Foo
+		{File: "a.go", Start: 5, End: 8},            // duplicate
+		{File: "a.go", Start: 22, End: 29},          // pkg.Foo
+		{File: "a.go", Start: 40, End: 43},          // Bar
+		{File: "b.go", Start: 0, End: 3},            // missing file
+	}
+
+	format := func(edits []*renameEdit) string {
+		var s []string
+		for _, e := range edits {
+			s = append(s, fmt.Sprintf("%s:%d-%d=%s", e.File, e.Start, e.End, e.Replacement))
+		}
+		return strings.Join(s, " ")
+	}
+
+	edits, skipped := renameEdits(refs, "Foo", "Baz", readFile)
+	if got, want := format(edits), "a.go:5-8=Baz a.go:26-29=Baz"; got != want {
+		t.Errorf("got edits %q, want %q", got, want)
+	}
+	if len(skipped) != 2 || skipped[0] != refs[3] || skipped[1] != refs[4] {
+		t.Errorf("got skipped %v, want refs 3 and 4", skipped)
+	}
+
+	// Without file contents, the refs' byte ranges are used as-is.
+	edits, skipped = renameEdits(refs, "Foo", "Baz", nil)
+	if got, want := format(edits), "a.go:5-8=Baz a.go:22-29=Baz a.go:40-43=Baz b.go:0-3=Baz"; got != want {
+		t.Errorf("got edits %q, want %q", got, want)
+	}
+	if len(skipped) != 0 {
+		t.Errorf("got skipped %v, want none", skipped)
+	}
+}
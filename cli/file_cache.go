@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileCache is an in-memory LRU cache of source file contents. It
+// lets snippets of the same files be displayed repeatedly (e.g., by
+// successive queries in a REPL session) without re-reading them.
+// Cached contents are invalidated when a file's size or modification
+// time changes.
+type fileCache struct {
+	files   map[string]*list.Element
+	lru     *list.List
+	size    int64 // total size of cached file contents
+	maxSize int64
+	sync.Mutex
+}
+
+type fileCacheElement struct {
+	path    string
+	modTime time.Time
+	data    []byte
+}
+
+var defaultFileCache = newFileCache(64 << 20)
+
+func newFileCache(maxSize int64) *fileCache {
+	return &fileCache{
+		files:   map[string]*list.Element{},
+		lru:     list.New(),
+		maxSize: maxSize,
+	}
+}
+
+// readFile returns the contents of the named file, from the cache if
+// they are cached and current.
+func (c *fileCache) readFile(path string) ([]byte, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	if el, ok := c.files[path]; ok {
+		e := el.Value.(fileCacheElement)
+		if e.modTime.Equal(fi.ModTime()) && int64(len(e.data)) == fi.Size() {
+			c.lru.MoveToFront(el)
+			c.Unlock()
+			return e.data, nil
+		}
+		c.remove(el)
+	}
+	c.Unlock()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > c.maxSize {
+		return data, nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.files[path]; ok {
+		// Another goroutine cached the file while we read it.
+		c.remove(el)
+	}
+	c.files[path] = c.lru.PushFront(fileCacheElement{path: path, modTime: fi.ModTime(), data: data})
+	c.size += int64(len(data))
+
+	// Evict least recently used
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+	return data, nil
+}
+
+// remove removes el from the cache. The caller must hold c's lock.
+func (c *fileCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(fileCacheElement)
+	delete(c.files, e.path)
+	c.size -= int64(len(e.data))
+}
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-file-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	read := func(c *fileCache, path, want string) {
+		data, err := c.readFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s: got %q, want %q", path, data, want)
+		}
+	}
+
+	c := newFileCache(10)
+	a := write("a", "aaaa")
+	b := write("b", "bbbb")
+	read(c, a, "aaaa")
+	read(c, b, "bbbb")
+	if c.lru.Len() != 2 || c.size != 8 {
+		t.Errorf("got %d cached files (%d bytes), want 2 (8 bytes)", c.lru.Len(), c.size)
+	}
+
+	// Changed files are re-read.
+	write("a", "aaaaa")
+	if err := os.Chtimes(a, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	read(c, a, "aaaaa")
+
+	// Adding another file evicts the least recently used one (b).
+	cc := write("c", "cccc")
+	read(c, cc, "cccc")
+	if _, cached := c.files[b]; cached {
+		t.Error("b was not evicted")
+	}
+	if c.lru.Len() != 2 || c.size != 9 {
+		t.Errorf("got %d cached files (%d bytes), want 2 (9 bytes)", c.lru.Len(), c.size)
+	}
+
+	// Files larger than the cache aren't cached.
+	big := write("big", "0123456789x")
+	read(c, big, "0123456789x")
+	if _, cached := c.files[big]; cached {
+		t.Error("big file was cached")
+	}
+}
@@ -0,0 +1,202 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/srclib"
+)
+
+func init() {
+	_, err := CLI.AddCommand("import",
+		"build and import many repos into the global store",
+		"The import command configures, makes, and imports (into the global store, SRCLIBSTORE) each repo listed in the --repos file. The repos are processed concurrently (up to --jobs at a time); a failure in one repo doesn't stop the others. The first repo is processed alone before the others start, so that toolchains are warmed up (e.g., their Docker images are pulled) only once. A summary of each repo's result is printed at the end, and the command fails if any repo failed.",
+		&importCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type ImportCmd struct {
+	ToolchainExecOpt `group:"execution"`
+
+	Repos    string `long:"repos" description:"file listing the directories of the repos to import (one per line; blank lines and lines starting with '#' are ignored)" required:"yes" value-name:"FILE"`
+	Jobs     int    `short:"j" long:"jobs" description:"max number of repos to process concurrently" default:"4"`
+	NoWarmUp bool   `long:"no-warm-up" description:"don't process the first repo alone before the others"`
+}
+
+var importCmd ImportCmd
+
+// importStages are the stages of processing each repo, in order. Each
+// is run as a srclib subcommand (with the given args) in the repo's
+// directory.
+var importStages = []struct {
+	name string
+	args func(c *ImportCmd) []string
+}{
+	{"config", func(c *ImportCmd) []string { return []string{"config", "-m", c.ExeMethods} }},
+	{"make", func(c *ImportCmd) []string { return []string{"make", "-m", c.ExeMethods} }},
+	{"import", func(c *ImportCmd) []string {
+		return []string{"store", "--type", "MultiRepoStore", "--root", srclib.StoreDir, "import", "--quiet"}
+	}},
+}
+
+// repoImport is the result of processing a repo.
+type repoImport struct {
+	Dir string
+
+	// FailedStage is the name of the stage that failed (if any).
+	FailedStage string
+	Err         error
+	Output      []byte // the failed stage's output
+
+	Duration time.Duration
+}
+
+func (c *ImportCmd) Execute(args []string) error {
+	f, err := os.Open(c.Repos)
+	if err != nil {
+		return err
+	}
+	dirs, err := readRepoList(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %s", c.Repos, err)
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("%s lists no repos", c.Repos)
+	}
+
+	runStage := func(dir string, stage int) ([]byte, error) {
+		cmd := exec.Command(srclib.CommandName, importStages[stage].args(c)...)
+		cmd.Dir = dir
+		if isOffline() {
+			cmd.Env = append(os.Environ(), offlineEnv+"=1")
+		}
+		return cmd.CombinedOutput()
+	}
+
+	results := runImportPipeline(dirs, c.Jobs, !c.NoWarmUp, runStage, func(r *repoImport) {
+		if r.Err != nil {
+			log.Printf("%s: %s failed: %s", r.Dir, r.FailedStage, r.Err)
+			if GlobalOpt.Verbose {
+				log.Printf("%s: %s output:\n%s", r.Dir, r.FailedStage, r.Output)
+			}
+		} else if GlobalOpt.Verbose {
+			log.Printf("%s: imported in %s", r.Dir, r.Duration)
+		}
+	})
+
+	colorable.Println()
+	return printImportSummary(colorable.Stdout, results)
+}
+
+// readRepoList reads a list of repo directories, one per line,
+// ignoring blank lines and comments (lines starting with '#').
+func readRepoList(r io.Reader) ([]string, error) {
+	var dirs []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		dirs = append(dirs, filepath.Clean(line))
+	}
+	return dirs, s.Err()
+}
+
+// runImportPipeline processes each repo dir by calling runStage for
+// each of its stages, in order, stopping at the first failed stage.
+// Up to jobs repos are processed concurrently. If warmUp is true, the
+// first repo is processed before any others. done (if non-nil) is
+// called as each repo is finished. The results are in the same order
+// as dirs.
+func runImportPipeline(dirs []string, jobs int, warmUp bool, runStage func(dir string, stage int) ([]byte, error), done func(*repoImport)) []*repoImport {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	var doneMu sync.Mutex
+	process := func(dir string) *repoImport {
+		r := &repoImport{Dir: dir}
+		start := time.Now()
+		for i, stage := range importStages {
+			if out, err := runStage(dir, i); err != nil {
+				r.FailedStage, r.Err, r.Output = stage.name, err, out
+				break
+			}
+		}
+		r.Duration = time.Since(start)
+		if done != nil {
+			doneMu.Lock()
+			done(r)
+			doneMu.Unlock()
+		}
+		return r
+	}
+
+	results := make([]*repoImport, len(dirs))
+	rest := dirs
+	if warmUp {
+		results[0] = process(dirs[0])
+		rest = dirs[1:]
+	}
+
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, dir := range rest {
+		i, dir := i+len(dirs)-len(rest), dir
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			results[i] = process(dir)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// printImportSummary prints a table of the results of the import
+// command. It returns an error if any repo failed.
+func printImportSummary(w io.Writer, results []*repoImport) error {
+	width := len("REPO")
+	for _, r := range results {
+		if len(r.Dir) > width {
+			width = len(r.Dir)
+		}
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%-*s  %-8s  %s\n", width, "REPO", "TIME", "RESULT")
+	failed := 0
+	for _, r := range results {
+		result := "ok"
+		if r.Err != nil {
+			failed++
+			result = fmt.Sprintf("FAILED (%s: %s)", r.FailedStage, r.Err)
+		}
+		fmt.Fprintf(&buf, "%-*s  %-8s  %s\n", width, r.Dir, r.Duration/time.Second*time.Second, result)
+	}
+	fmt.Fprintf(&buf, "\n%d of %d repos imported successfully.\n", len(results)-failed, len(results))
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return errors.New("some repos failed to import")
+	}
+	return nil
+}
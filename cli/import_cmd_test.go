@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadRepoList(t *testing.T) {
+	dirs, err := readRepoList(strings.NewReader("a\n\n  # comment\n./b/\n  /c/d  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b", "/c/d"}; !reflect.DeepEqual(dirs, want) {
+		t.Errorf("got %v, want %v", dirs, want)
+	}
+}
+
+func TestRunImportPipeline(t *testing.T) {
+	const jobs = 2
+	var (
+		mu                sync.Mutex
+		running, maxRun   int
+		ran               = map[string][]int{}
+		warmUpDone        bool
+		startedBeforeWarm bool
+	)
+	runStage := func(dir string, stage int) ([]byte, error) {
+		mu.Lock()
+		if dir != "a" && !warmUpDone {
+			startedBeforeWarm = true
+		}
+		ran[dir] = append(ran[dir], stage)
+		running++
+		if running > maxRun {
+			maxRun = running
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		running--
+		if dir == "a" && stage == len(importStages)-1 {
+			warmUpDone = true
+		}
+		if dir == "c" && stage == 1 {
+			return []byte("make output"), errors.New("make failed")
+		}
+		return nil, nil
+	}
+
+	dirs := []string{"a", "b", "c", "d", "e"}
+	results := runImportPipeline(dirs, jobs, true, runStage, nil)
+
+	if startedBeforeWarm {
+		t.Error("repos were processed before the warm-up repo finished")
+	}
+	if maxRun > jobs {
+		t.Errorf("%d stages ran concurrently, want at most %d", maxRun, jobs)
+	}
+	for i, r := range results {
+		if r.Dir != dirs[i] {
+			t.Errorf("result %d is for %s, want %s", i, r.Dir, dirs[i])
+		}
+		wantStages := len(importStages)
+		if r.Dir == "c" {
+			if r.FailedStage != "make" || string(r.Output) != "make output" {
+				t.Errorf("c: got failed stage %q with output %q", r.FailedStage, r.Output)
+			}
+			wantStages = 2 // the import stage isn't run after make fails
+		} else if r.Err != nil {
+			t.Errorf("%s: unexpected error: %s", r.Dir, r.Err)
+		}
+		if len(ran[r.Dir]) != wantStages {
+			t.Errorf("%s: ran stages %v, want %d stages", r.Dir, ran[r.Dir], wantStages)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := printImportSummary(&buf, results); err == nil {
+		t.Error("got no error from summary with a failed repo")
+	}
+	if !strings.Contains(buf.String(), "FAILED (make: make failed)") || !strings.Contains(buf.String(), "4 of 5 repos") {
+		t.Errorf("unexpected summary:\n%s", buf.String())
+	}
+}
@@ -29,6 +29,7 @@ func (c *InfoCmd) Execute(args []string) error {
 	log.Println()
 
 	log.Printf("SRCLIBPATH=%q", srclib.Path)
+	log.Printf("SRCLIBSTORE=%q", srclib.StoreDir)
 
 	log.Println()
 	log.Printf("Build data types (%d)", len(buildstore.DataTypes))
@@ -5,6 +5,8 @@ import (
 	"log"
 	"os"
 
+	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/dep"
 	"sourcegraph.com/sourcegraph/srclib/graph"
 	"sourcegraph.com/sourcegraph/srclib/grapher"
 )
@@ -19,6 +21,20 @@ func init() {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	_, err = c.AddCommand("normalize-dep-data", "", "", &normalizeDepDataCmd)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("anonymize-graph-data",
+		"anonymize graph data for sharing",
+		"The anonymize-graph-data command reads graph data (the output of a grapher, such as a *.graph.json file) from stdin and prints it with all identifying information (repo, unit, and def names, paths, docs, etc.) deterministically replaced by hashes, preserving its structure. Use it to share build data that reproduces a problem without revealing proprietary code.",
+		&anonymizeGraphDataCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 type NormalizeGraphDataCmd struct {
@@ -40,6 +56,11 @@ func (c *NormalizeGraphDataCmd) Execute(args []string) error {
 	if err != nil {
 		return err
 	}
+	nestedRepos, err := readNestedRepos(localRepo)
+	if err != nil {
+		return err
+	}
+	grapher.AttributeNestedRepos(o, nestedRepos)
 	if err := grapher.NormalizeData(localRepo.URI(), c.UnitType, c.Dir, o); err != nil {
 		return err
 	}
@@ -55,3 +76,69 @@ func (c *NormalizeGraphDataCmd) Execute(args []string) error {
 
 	return nil
 }
+
+type NormalizeDepDataCmd struct{}
+
+var normalizeDepDataCmd NormalizeDepDataCmd
+
+// Execute resolves deps on source units of nested repositories to
+// those repositories and applies the repository's dependency overrides
+// (from its Srcfile) to the dep resolutions read from stdin.
+func (c *NormalizeDepDataCmd) Execute(args []string) error {
+	var deps []*dep.Resolution
+	if err := json.NewDecoder(os.Stdin).Decode(&deps); err != nil {
+		return err
+	}
+
+	localRepo, err := OpenRepo(".")
+	if err != nil {
+		return err
+	}
+	cfg, err := config.ReadRepository(localRepo.RootDir, localRepo.URI())
+	if err != nil {
+		return err
+	}
+	nestedRepos, err := readNestedRepos(localRepo)
+	if err != nil {
+		return err
+	}
+	dep.ApplyNestedRepos(deps, localRepo.URI(), nestedRepos)
+	dep.ApplyOverrides(deps, cfg.DepOverrides)
+
+	data, err := json.MarshalIndent(deps, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stdout.Write(data); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type AnonymizeGraphDataCmd struct {
+	Salt string `long:"salt" description:"secret string to salt hashes with (without one, short identifiers can be recovered by guessing)"`
+}
+
+var anonymizeGraphDataCmd AnonymizeGraphDataCmd
+
+func (c *AnonymizeGraphDataCmd) Execute(args []string) error {
+	var o *graph.Output
+	if err := json.NewDecoder(os.Stdin).Decode(&o); err != nil {
+		return err
+	}
+
+	grapher.Anonymizer{Salt: c.Salt}.Anonymize(o)
+
+	data, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stdout.Write(data); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -272,6 +272,10 @@ func lintGraphOutput(baseDir, repoURI, unitType, unitName, path string, checkFil
 	for _, ann := range o.Anns {
 		label := fmt.Sprintf("Ann %+v", ann)
 		checkOrigin(label, ann.Repo, ann.CommitID, ann.UnitType, ann.Unit)
+		if ann.File == "" && ann.DefPath != "" {
+			// Anns attached to defs needn't have a file.
+			continue
+		}
 		if err := checkFile(label, ann.File); err != nil {
 			return issues, err
 		}
@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// mailmap maps author names and emails to canonical identities, as
+// specified by a git .mailmap file (see git-check-mailmap(1)).
+type mailmap struct {
+	// byEmail holds entries that match any name with an email;
+	// byNameEmail holds entries that match a specific name and email
+	// (and take precedence). Keys are lowercased.
+	byEmail     map[string]mailmapIdentity
+	byNameEmail map[[2]string]mailmapIdentity
+}
+
+type mailmapIdentity struct {
+	name, email string // empty if not mapped
+}
+
+// readMailmap reads the .mailmap file at path. If it doesn't exist,
+// readMailmap returns a nil *mailmap (which maps every identity to
+// itself) and no error.
+func readMailmap(path string) (*mailmap, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMailmap(f)
+}
+
+// parseMailmap parses a .mailmap file. Each line has one of the
+// following forms:
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+func parseMailmap(r io.Reader) (*mailmap, error) {
+	m := &mailmap{
+		byEmail:     map[string]mailmapIdentity{},
+		byNameEmail: map[[2]string]mailmapIdentity{},
+	}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+
+		// Split the line into (name, email) pairs.
+		var names, emails []string
+		for {
+			start := strings.Index(line, "<")
+			end := strings.Index(line, ">")
+			if start == -1 || end < start {
+				break
+			}
+			names = append(names, strings.TrimSpace(line[:start]))
+			emails = append(emails, strings.TrimSpace(line[start+1:end]))
+			line = line[end+1:]
+		}
+
+		var proper mailmapIdentity
+		switch len(emails) {
+		case 1:
+			proper.name = names[0]
+			m.byEmail[strings.ToLower(emails[0])] = proper
+		case 2:
+			proper = mailmapIdentity{name: names[0], email: emails[0]}
+			if names[1] == "" {
+				m.byEmail[strings.ToLower(emails[1])] = proper
+			} else {
+				m.byNameEmail[[2]string{strings.ToLower(names[1]), strings.ToLower(emails[1])}] = proper
+			}
+		}
+	}
+	return m, s.Err()
+}
+
+// resolve returns the canonical name and email for the identity with
+// the given name and email.
+func (m *mailmap) resolve(name, email string) (string, string) {
+	if m == nil {
+		return name, email
+	}
+	id, ok := m.byNameEmail[[2]string{strings.ToLower(name), strings.ToLower(email)}]
+	if !ok {
+		id, ok = m.byEmail[strings.ToLower(email)]
+	}
+	if !ok {
+		return name, email
+	}
+	if id.name != "" {
+		name = id.name
+	}
+	if id.email != "" {
+		email = id.email
+	}
+	return name, email
+}
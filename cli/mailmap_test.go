@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMailmap(t *testing.T) {
+	mm, err := parseMailmap(strings.NewReader(`# comment
+Alice Smith <alice@example.com>
+<bob@example.com> <bob@old.example.com>
+Carol <carol@example.com> <carol@laptop>
+Dave <dave@example.com> dave <root@localhost> # trailing comment
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct{ name, email, wantName, wantEmail string }{
+		{"alice", "ALICE@example.com", "Alice Smith", "ALICE@example.com"},
+		{"Bob", "bob@old.example.com", "Bob", "bob@example.com"},
+		{"carol", "carol@laptop", "Carol", "carol@example.com"},
+		{"dave", "root@localhost", "Dave", "dave@example.com"},
+		{"eve", "root@localhost", "eve", "root@localhost"},
+	}
+	for _, test := range tests {
+		name, email := mm.resolve(test.name, test.email)
+		if name != test.wantName || email != test.wantEmail {
+			t.Errorf("%s <%s>: got %s <%s>, want %s <%s>", test.name, test.email, name, email, test.wantName, test.wantEmail)
+		}
+	}
+
+	var nilMailmap *mailmap
+	if name, email := nilMailmap.resolve("x", "y"); name != "x" || email != "y" {
+		t.Errorf("nil mailmap: got %s <%s>, want x <y>", name, email)
+	}
+}
+
+func TestBlameAuthors(t *testing.T) {
+	blameLine := func(commitID, name, email string, secs int) string {
+		return commitID + " 1 1\nauthor " + name + "\nauthor-mail <" + email + ">\nauthor-time " + strconv.Itoa(secs) + "\nsummary s\nfilename f\n\tcode\n"
+	}
+	c1 := strings.Repeat("1", 40)
+	c2 := strings.Repeat("2", 40)
+	blame := blameLine(c1, "Bob", "bob@old.example.com", 100) +
+		blameLine(c2, "Bob", "bob@example.com", 200) +
+		blameLine(c2, "Bob", "bob@example.com", 200) +
+		blameLine(c1, "alice", "alice@example.com", 100) +
+		blameLine(notCommittedID, "Not Committed Yet", "not.committed.yet", 300)
+
+	mm, err := parseMailmap(strings.NewReader("<bob@example.com> <bob@old.example.com>\nAlice <alice@example.com>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []authorLines{
+		{name: "Bob", email: "bob@example.com", lines: 3, commits: 2, lastTime: time.Unix(200, 0)},
+		{name: "Alice", email: "alice@example.com", lines: 1, commits: 1, lastTime: time.Unix(100, 0)},
+		{name: "Not Committed Yet", email: "not.committed.yet", lines: 1, commits: 0, lastTime: time.Unix(300, 0)},
+	}
+	if got := blameAuthors([]byte(blame), mm, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("got authors %+v, want %+v", got, want)
+	}
+
+	// In a shallow clone whose history begins at c1, c1's lines may
+	// be older, so they're unattributed.
+	want = []authorLines{
+		{lines: 2, unattributed: true},
+		{name: "Bob", email: "bob@example.com", lines: 2, commits: 1, lastTime: time.Unix(200, 0)},
+		{name: "Not Committed Yet", email: "not.committed.yet", lines: 1, commits: 0, lastTime: time.Unix(300, 0)},
+	}
+	if got := blameAuthors([]byte(blame), mm, map[string]bool{c1: true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("shallow: got authors %+v, want %+v", got, want)
+	}
+}
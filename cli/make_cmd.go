@@ -42,6 +42,8 @@ type MakeCmd struct {
 
 	Dir Directory `short:"C" long:"directory" description:"change to DIR before doing anything" value-name:"DIR"`
 
+	Report string `long:"report" description:"write a JSON build report (rules built and cached, durations, toolchain versions, warnings, output sizes) to FILE" value-name:"FILE"`
+
 	Args struct {
 		Goals []string `name:"GOALS..." description:"Makefile targets to build (default: all)"`
 	} `positional-args:"yes"`
@@ -50,12 +52,43 @@ type MakeCmd struct {
 var makeCmd MakeCmd
 
 func (c *MakeCmd) Execute(args []string) error {
+	var reportFile string
+	if c.Report != "" && !c.DryRun {
+		// Resolve it before -C changes the cwd.
+		var err error
+		if reportFile, err = filepath.Abs(c.Report); err != nil {
+			return err
+		}
+	}
 	if c.Dir != "" {
 		if err := os.Chdir(c.Dir.String()); err != nil {
 			return err
 		}
 	}
+	if reportFile == "" {
+		return c.make(nil)
+	}
+
+	r := newMakeReporter()
+	log.SetOutput(io.MultiWriter(colorable.Stderr, r))
+	err := c.make(r)
+	log.SetOutput(colorable.Stderr)
+	rep := r.finish(err)
+	if repo, err := OpenRepo("."); err == nil {
+		rep.Repo, rep.CommitID = repo.URI(), repo.CommitID
+	}
+	if err2 := writeMakeReport(reportFile, rep); err2 != nil {
+		if err == nil {
+			return err2
+		}
+		log.Printf("Warning: failed to write make report: %s", err2)
+	}
+	return err
+}
 
+// make creates the Makefile and makes its goals, recording the
+// progress in r (if r is non-nil).
+func (c *MakeCmd) make(r *makeReporter) error {
 	mf, err := CreateMakefile(c.ToolchainExecOpt, c.Verbose)
 	if err != nil {
 		return err
@@ -78,6 +111,10 @@ func (c *MakeCmd) Execute(args []string) error {
 				log.New(nopWriteCloser{}, "", 0)
 		}
 	}
+	if r != nil {
+		r.setMakefile(mf, goals)
+		mk.RuleOutput = r.ruleOutput(mk.RuleOutput)
+	}
 
 	if c.DryRun {
 		return mk.DryRun(os.Stdout)
@@ -0,0 +1,319 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sourcegraph.com/sourcegraph/makex"
+	"sourcegraph.com/sourcegraph/srclib/plan"
+	"sourcegraph.com/sourcegraph/srclib/toolchain"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// makeReport is the build report that 'src make --report' writes, for
+// tracking the health of analysis over time.
+type makeReport struct {
+	Repo     string `json:",omitempty"` // repo URI
+	CommitID string `json:",omitempty"`
+	Goals    []string
+
+	Start   time.Time
+	Seconds float64 // duration of the whole make
+
+	Success bool
+	Error   string `json:",omitempty"`
+
+	// Counts of the rules by status (see ruleReport.Status).
+	Built, Cached, UpToDate, Failed int
+
+	// OutputBytes is the total size of the rules' targets.
+	OutputBytes int64
+
+	Rules      []*ruleReport
+	Toolchains []*toolchainReport `json:",omitempty"`
+
+	// Warnings are the warnings logged by srclib and the
+	// lines of rule output that mention a warning.
+	Warnings []string `json:",omitempty"`
+}
+
+// ruleReport describes what happened to a Makefile rule's target.
+type ruleReport struct {
+	Target   string
+	UnitType string `json:",omitempty"`
+	Unit     string `json:",omitempty"`
+
+	// Status is "built" (its recipe ran), "cached" (its target was
+	// copied from a previous commit's build data), "up-to-date" (its
+	// target already existed), "failed", or "missing" (it wasn't run
+	// and its target doesn't exist).
+	Status string
+
+	Seconds     float64 `json:",omitempty"`
+	OutputBytes int64   `json:",omitempty"`
+
+	start, end time.Time
+	ran        bool
+}
+
+// toolchainReport describes a tool that ran, according to the
+// environment it recorded (see toolchain.Env).
+type toolchainReport struct {
+	Toolchain string
+	Subcmd    string
+	Mode      string
+	Version   string `json:",omitempty"` // toolchain VCS revision or Docker image ID
+}
+
+// maxReportWarnings is the most warnings a make report includes.
+const maxReportWarnings = 500
+
+// makeReporter records the progress of a make for a makeReport.
+type makeReporter struct {
+	mu     sync.Mutex
+	report makeReport
+	rules  map[string]*ruleReport // keyed on target
+	mf     *makex.Makefile
+}
+
+func newMakeReporter() *makeReporter {
+	return &makeReporter{
+		report: makeReport{Start: time.Now()},
+		rules:  map[string]*ruleReport{},
+	}
+}
+
+// setMakefile records the rules of mf (other than phony rules with no
+// recipes, such as "all") in the report.
+func (r *makeReporter) setMakefile(mf *makex.Makefile, goals []string) {
+	r.mf = mf
+	r.report.Goals = goals
+	for _, rule := range mf.Rules {
+		if len(rule.Recipes()) == 0 {
+			continue
+		}
+		rr := &ruleReport{Target: rule.Target()}
+		if u, ok := rule.(interface {
+			SourceUnit() *unit.SourceUnit
+		}); ok {
+			rr.UnitType, rr.Unit = u.SourceUnit().Type, u.SourceUnit().Name
+		}
+		r.rules[rr.Target] = rr
+		r.report.Rules = append(r.report.Rules, rr)
+	}
+}
+
+// ruleOutput wraps a makex.Maker RuleOutput func (or the default
+// output, if ruleOutput is nil) to time each rule and collect the
+// warnings in its stderr.
+func (r *makeReporter) ruleOutput(ruleOutput func(makex.Rule) (io.WriteCloser, io.WriteCloser, *log.Logger)) func(makex.Rule) (io.WriteCloser, io.WriteCloser, *log.Logger) {
+	return func(rule makex.Rule) (io.WriteCloser, io.WriteCloser, *log.Logger) {
+		var stdout, stderr io.WriteCloser
+		var logger *log.Logger
+		if ruleOutput != nil {
+			stdout, stderr, logger = ruleOutput(rule)
+		} else {
+			stdout, stderr = nopCloser{os.Stdout}, nopCloser{os.Stderr}
+			logger = log.New(os.Stderr, rule.Target()+": ", 0)
+		}
+
+		r.mu.Lock()
+		rr := r.rules[rule.Target()]
+		if rr != nil {
+			rr.ran = true
+			rr.start = time.Now()
+		}
+		r.mu.Unlock()
+		// makex closes the rule's stderr once its recipes have run.
+		return stdout, &warningWriter{w: stderr, r: r, rule: rr}, logger
+	}
+}
+
+// addWarning records a warning, unless the report already has the
+// maximum number.
+func (r *makeReporter) addWarning(s string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.report.Warnings) < maxReportWarnings {
+		r.report.Warnings = append(r.report.Warnings, s)
+	}
+}
+
+// Write implements io.Writer so that the reporter can collect the
+// warnings logged by srclib (see log.SetOutput).
+func (r *makeReporter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSpace(string(p)), "\n") {
+		if isWarning(line) {
+			r.addWarning(strings.TrimSpace(line))
+		}
+	}
+	return len(p), nil
+}
+
+func isWarning(line string) bool {
+	return strings.Contains(strings.ToLower(line), "warning")
+}
+
+// finish completes the report after the make has run (with error
+// err) and returns it.
+func (r *makeReporter) finish(err error) *makeReport {
+	r.mu.Lock()
+	rep := &r.report
+	rep.Seconds = time.Since(rep.Start).Seconds()
+	rep.Success = err == nil
+	if err != nil {
+		rep.Error = err.Error()
+	}
+
+	var envTargets []string
+	var rules []makex.Rule
+	if r.mf != nil {
+		rules = r.mf.Rules
+	}
+	for _, rule := range rules {
+		if rule, ok := rule.(interface {
+			EnvTarget() string
+		}); ok {
+			envTargets = append(envTargets, rule.EnvTarget())
+		}
+		rr := r.rules[rule.Target()]
+		if rr == nil {
+			continue
+		}
+		fi, statErr := os.Stat(rr.Target)
+		if statErr == nil {
+			rr.OutputBytes = fi.Size()
+			rep.OutputBytes += fi.Size()
+		}
+		switch {
+		case rr.ran && statErr != nil:
+			rr.Status = "failed"
+			rep.Failed++
+		case rr.ran && plan.IsCachedRule(rule):
+			rr.Status = "cached"
+			rep.Cached++
+		case rr.ran:
+			rr.Status = "built"
+			rep.Built++
+		case statErr == nil:
+			rr.Status = "up-to-date"
+			rep.UpToDate++
+		default:
+			rr.Status = "missing"
+		}
+		if rr.ran && !rr.end.IsZero() {
+			rr.Seconds = rr.end.Sub(rr.start).Seconds()
+		}
+	}
+	r.mu.Unlock()
+
+	// Reading the envs may log a warning, which is written to r.
+	tcs := readToolchainReports(envTargets)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep.Toolchains = tcs
+	return rep
+}
+
+// readToolchainReports returns the distinct tools recorded in the
+// toolchain.Env files envFiles. Files that don't exist (because their
+// rule didn't run) are skipped.
+func readToolchainReports(envFiles []string) []*toolchainReport {
+	seen := map[toolchainReport]bool{}
+	var tcs []*toolchainReport
+	for _, file := range envFiles {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var env toolchain.Env
+		if err := json.Unmarshal(data, &env); err != nil {
+			log.Printf("Warning: reading toolchain env %s: %s", file, err)
+			continue
+		}
+		tc := toolchainReport{Toolchain: env.Toolchain, Subcmd: env.Subcmd, Mode: env.Mode, Version: env.ToolchainVersion}
+		if tc.Version == "" {
+			tc.Version = env.ImageID
+		}
+		if !seen[tc] {
+			seen[tc] = true
+			tcs = append(tcs, &tc)
+		}
+	}
+	sort.Sort(toolchainReports(tcs))
+	return tcs
+}
+
+type toolchainReports []*toolchainReport
+
+func (v toolchainReports) Len() int      { return len(v) }
+func (v toolchainReports) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v toolchainReports) Less(i, j int) bool {
+	if v[i].Toolchain != v[j].Toolchain {
+		return v[i].Toolchain < v[j].Toolchain
+	}
+	return v[i].Subcmd < v[j].Subcmd
+}
+
+// writeMakeReport writes rep as JSON to file.
+func writeMakeReport(file string, rep *makeReport) error {
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(data, '\n'), 0644)
+}
+
+// warningWriter passes a rule's stderr through to w, recording the
+// lines that mention a warning. Closing it marks the end of the
+// rule.
+type warningWriter struct {
+	w    io.WriteCloser
+	r    *makeReporter
+	rule *ruleReport
+	buf  bytes.Buffer // incomplete last line
+}
+
+func (w *warningWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i == -1 {
+			break
+		}
+		w.line(string(w.buf.Next(i + 1)))
+	}
+	return w.w.Write(p)
+}
+
+func (w *warningWriter) line(s string) {
+	if s = strings.TrimSpace(s); isWarning(s) {
+		if w.rule != nil {
+			s = w.rule.Target + ": " + s
+		}
+		w.r.addWarning(s)
+	}
+}
+
+func (w *warningWriter) Close() error {
+	w.line(w.buf.String())
+	w.buf.Reset()
+	if w.rule != nil {
+		w.r.mu.Lock()
+		w.rule.end = time.Now()
+		w.r.mu.Unlock()
+	}
+	return w.w.Close()
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/makex"
+)
+
+// envRule is a rule whose tool records its environment.
+type envRule struct {
+	makex.BasicRule
+	envFile string
+}
+
+func (r *envRule) EnvTarget() string { return r.envFile }
+
+func TestMakeReporter(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "srclib-make-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	file := func(name, data string) string {
+		p := filepath.Join(tmpDir, name)
+		if data != "" {
+			if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return p
+	}
+	rule := func(target string) makex.BasicRule {
+		return makex.BasicRule{TargetFile: target, RecipeCmds: []string{"true"}}
+	}
+
+	built := &envRule{rule(file("built", "12345")), file("built.env", `{"Toolchain":"t","Subcmd":"graph","Mode":"program","ToolchainVersion":"abc"}`)}
+	failed, upToDate, missing := rule(file("failed", "")), rule(file("up-to-date", "12")), rule(file("missing", ""))
+	mf := &makex.Makefile{Rules: []makex.Rule{
+		&makex.BasicRule{TargetFile: "all"},
+		built, &failed, &upToDate, &missing,
+	}}
+
+	r := newMakeReporter()
+	r.setMakefile(mf, []string{"all"})
+	ruleOutput := r.ruleOutput(func(makex.Rule) (io.WriteCloser, io.WriteCloser, *log.Logger) {
+		return nopWriteCloser{}, nopWriteCloser{}, log.New(nopWriteCloser{}, "", 0)
+	})
+	for _, rule := range []makex.Rule{built, &failed} {
+		_, stderr, _ := ruleOutput(rule)
+		fmt.Fprint(stderr, "ok\nwarning: x")
+		fmt.Fprint(stderr, "yz\n")
+		stderr.Close()
+	}
+	fmt.Fprintln(r, "Warning: from log")
+
+	rep := r.finish(errors.New("oops"))
+	if rep.Success || rep.Error != "oops" {
+		t.Errorf("got Success %v and Error %q, want failure", rep.Success, rep.Error)
+	}
+	if rep.Built != 1 || rep.Failed != 1 || rep.UpToDate != 1 || rep.Cached != 0 || rep.OutputBytes != 7 {
+		t.Errorf("got report %+v, want 1 rule built, failed, and up-to-date, and 7 output bytes", rep)
+	}
+	var statuses []string
+	for _, rr := range rep.Rules {
+		statuses = append(statuses, rr.Status)
+	}
+	if want := []string{"built", "failed", "up-to-date", "missing"}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("got rule statuses %v, want %v", statuses, want)
+	}
+	wantWarnings := []string{built.Target() + ": warning: xyz", failed.Target() + ": warning: xyz", "Warning: from log"}
+	if !reflect.DeepEqual(rep.Warnings, wantWarnings) {
+		t.Errorf("got warnings %q, want %q", rep.Warnings, wantWarnings)
+	}
+	if want := []*toolchainReport{{Toolchain: "t", Subcmd: "graph", Mode: "program", Version: "abc"}}; !reflect.DeepEqual(rep.Toolchains, want) {
+		t.Errorf("got toolchains %+v, want %+v", rep.Toolchains, want)
+	}
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+// findNestedRepos returns the git and hg repositories (including git
+// submodules, whose .git is a file) nested in the tree rooted at
+// rootDir, whose own URI is repoURI. Repositories nested in nested
+// repositories belong to those and aren't returned.
+func findNestedRepos(rootDir, repoURI string) ([]*config.NestedRepo, error) {
+	var repos []*config.NestedRepo
+	err := filepath.Walk(rootDir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) || os.IsPermission(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		switch fi.Name() {
+		case ".git", ".hg", buildstore.BuildDataDirName, store.SrclibStoreDir:
+			return filepath.SkipDir
+		}
+		if p == rootDir || !isRepoRoot(p) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(rootDir, p)
+		if err != nil {
+			return err
+		}
+		r := &config.NestedRepo{Dir: filepath.ToSlash(rel)}
+		if repo, err := OpenRepo(p); err == nil {
+			r.URI, r.CloneURL, r.CommitID = repo.URI(), repo.CloneURL, repo.CommitID
+		} else if GlobalOpt.Verbose {
+			log.Printf("Warning: reading nested repository in %s: %s", r.Dir, err)
+		}
+		if r.URI == "" && repoURI != "" {
+			// Without a clone URL, name it after where it is in
+			// the containing repository.
+			r.URI = path.Join(repoURI, r.Dir)
+		}
+		repos = append(repos, r)
+		return filepath.SkipDir
+	})
+	return repos, err
+}
+
+// isRepoRoot returns whether dir is the root of a git or hg
+// repository.
+func isRepoRoot(dir string) bool {
+	for _, vcsDir := range []string{".git", ".hg"} {
+		// Don't check that it's a dir, because git submodules
+		// have a .git file.
+		if _, err := os.Stat(filepath.Join(dir, vcsDir)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// addNestedRepos adds the nested repositories found in the tree
+// rooted at rootDir to cfg, unless cfg (from the Srcfile) already
+// lists a repository in the same dir.
+func addNestedRepos(cfg *config.Repository, rootDir string) error {
+	found, err := findNestedRepos(rootDir, cfg.URI)
+	if err != nil {
+		return err
+	}
+	for _, r := range found {
+		if config.NestedRepoAt(cfg.NestedRepos, r.Dir) == nil {
+			cfg.NestedRepos = append(cfg.NestedRepos, r)
+		}
+	}
+	return nil
+}
+
+// readNestedRepos reads the nested repositories that `src config`
+// found in repo's tree at its current commit.
+func readNestedRepos(repo *Repo) ([]*config.NestedRepo, error) {
+	bs, err := buildstore.LocalRepo(repo.RootDir)
+	if err != nil {
+		return nil, err
+	}
+	return config.ReadNestedRepos(bs.Commit(repo.CommitID))
+}
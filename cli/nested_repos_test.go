@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindNestedRepos(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "srclib-nested-repos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	git := func(dir string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s\n%s", args, err, out)
+		}
+	}
+	commit := []string{"-c", "user.name=a", "-c", "user.email=a@example.com", "commit", "-q", "--allow-empty", "-m", "c"}
+
+	// root is the containing repo; root/lib/sub has a clone URL, and
+	// root/vendor/x (which contains another repo, root/vendor/x/y)
+	// doesn't.
+	root := filepath.Join(dir, "root")
+	for _, d := range []string{root, filepath.Join(root, "lib", "sub"), filepath.Join(root, "vendor", "x", "y")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(root, "init", "-q")
+	git(filepath.Join(root, "lib", "sub"), "init", "-q")
+	git(filepath.Join(root, "lib", "sub"), "remote", "add", "origin", "https://example.com/sub.git")
+	git(filepath.Join(root, "lib", "sub"), commit...)
+	git(filepath.Join(root, "vendor", "x"), "init", "-q")
+	git(filepath.Join(root, "vendor", "x", "y"), "init", "-q")
+
+	repos, err := findNestedRepos(root, "example.com/root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 2 {
+		t.Fatalf("got %d nested repos, want 2", len(repos))
+	}
+	if r := repos[0]; r.Dir != "lib/sub" || r.URI != "example.com/sub" || r.CloneURL != "https://example.com/sub.git" || len(r.CommitID) != 40 {
+		t.Errorf("got nested repo %+v, want lib/sub at its commit", r)
+	}
+	if r := repos[1]; r.Dir != "vendor/x" || r.URI != "example.com/root/vendor/x" {
+		t.Errorf("got nested repo %+v, want vendor/x named after its dir", r)
+	}
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// offlineEnv is the environment variable that enables offline mode
+// (like the --offline flag) if it is set to a non-empty value other
+// than "0".
+const offlineEnv = "SRC_OFFLINE"
+
+func init() {
+	// Make all HTTP requests that use the default transport (as
+	// http.Get, http.DefaultClient, etc., do) fail fast in offline
+	// mode.
+	http.DefaultTransport = &offlineTransport{http.DefaultTransport}
+}
+
+// isOffline returns whether offline mode is enabled, by the --offline
+// flag or the SRC_OFFLINE environment variable.
+func isOffline() bool {
+	if GlobalOpt.Offline {
+		return true
+	}
+	v := os.Getenv(offlineEnv)
+	return v != "" && v != "0"
+}
+
+// checkOnline returns an error if offline mode is enabled. Commands
+// call it before doing something that requires network access
+// (described by what).
+func checkOnline(what string) error {
+	if isOffline() {
+		return fmt.Errorf("%s requires network access, but offline mode is enabled (by --offline or %s)", what, offlineEnv)
+	}
+	return nil
+}
+
+// offlineTransport is an http.RoundTripper that fails in offline mode
+// and otherwise uses its underlying transport.
+type offlineTransport struct {
+	http.RoundTripper
+}
+
+func (t *offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := checkOnline(fmt.Sprintf("%s %s", req.Method, req.URL)); err != nil {
+		return nil, err
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
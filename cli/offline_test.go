@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOffline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	defer func(orig bool) { GlobalOpt.Offline = orig }(GlobalOpt.Offline)
+	defer os.Setenv(offlineEnv, os.Getenv(offlineEnv))
+
+	tests := []struct {
+		flag        bool
+		env         string
+		wantOffline bool
+	}{
+		{false, "", false},
+		{false, "0", false},
+		{true, "", true},
+		{false, "1", true},
+	}
+	for _, test := range tests {
+		GlobalOpt.Offline = test.flag
+		os.Setenv(offlineEnv, test.env)
+
+		if got := isOffline(); got != test.wantOffline {
+			t.Errorf("flag %v, env %q: got offline %v, want %v", test.flag, test.env, got, test.wantOffline)
+		}
+		if err := checkOnline("x"); (err != nil) != test.wantOffline {
+			t.Errorf("flag %v, env %q: got checkOnline error %v", test.flag, test.env, err)
+		}
+		resp, err := http.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if test.wantOffline && (err == nil || !strings.Contains(err.Error(), "offline mode")) {
+			t.Errorf("flag %v, env %q: got HTTP error %v, want an offline mode error", test.flag, test.env, err)
+		} else if !test.wantOffline && err != nil {
+			t.Errorf("flag %v, env %q: got HTTP error %v", test.flag, test.env, err)
+		}
+	}
+}
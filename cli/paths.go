@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// PathsOpt is the --paths option of commands that print the files of
+// defs and refs.
+type PathsOpt struct {
+	Paths string `long:"paths" description:"how to print def and ref file paths: repo-relative (as stored in the build data), unit-relative (relative to the source unit's directory), or absolute" default:"repo-relative" value-name:"BASE"`
+}
+
+// checkPathBase returns an error if base isn't a valid --paths value.
+func checkPathBase(base string) error {
+	switch base {
+	case "repo-relative", "unit-relative", "absolute":
+		return nil
+	}
+	return fmt.Errorf("unrecognized --paths value: %q (valid values are repo-relative, unit-relative, absolute)", base)
+}
+
+// pathDisplay converts the repo-relative file paths of a repo's defs
+// and refs to the form given by --paths. A nil *pathDisplay leaves
+// them repo-relative.
+type pathDisplay struct {
+	base     string // --paths value
+	root     string // the repo's root dir
+	unitDirs map[unitDirKey]string
+}
+
+type unitDirKey struct{ unitType, unit string }
+
+// newPathDisplay returns a pathDisplay for --paths=base of the repo
+// rooted at root. The units func, which lists the repo's source units,
+// is only called for unit-relative paths.
+func newPathDisplay(base, root string, units func() ([]*unit.SourceUnit, error)) (*pathDisplay, error) {
+	if err := checkPathBase(base); err != nil {
+		return nil, err
+	}
+	if base == "repo-relative" {
+		return nil, nil
+	}
+	p := &pathDisplay{base: base, root: root}
+	if base == "unit-relative" {
+		us, err := units()
+		if err != nil {
+			return nil, err
+		}
+		p.unitDirs = make(map[unitDirKey]string, len(us))
+		for _, u := range us {
+			p.unitDirs[unitDirKey{u.Type, u.Name}] = u.Dir
+		}
+	}
+	return p, nil
+}
+
+// commitUnits returns a func that lists the source units in the build
+// data in commitFS.
+func commitUnits(commitFS rwvfs.WalkableFileSystem) func() ([]*unit.SourceUnit, error) {
+	return func() ([]*unit.SourceUnit, error) {
+		var units []*unit.SourceUnit
+		for _, unitFile := range getSourceUnits(commitFS, nil) {
+			var u unit.SourceUnit
+			if err := readJSONFileFS(commitFS, unitFile, &u); err != nil {
+				return nil, fmt.Errorf("%s: %s", unitFile, err)
+			}
+			units = append(units, &u)
+		}
+		return units, nil
+	}
+}
+
+// path returns the display form of file (a repo-relative path) in the
+// given source unit. Files in units without a directory are left
+// repo-relative when unit-relative paths are requested.
+func (p *pathDisplay) path(unitType, unitName, file string) string {
+	if p == nil || file == "" {
+		return file
+	}
+	switch p.base {
+	case "absolute":
+		if p.root != "" {
+			return filepath.ToSlash(filepath.Join(p.root, filepath.FromSlash(file)))
+		}
+	case "unit-relative":
+		dir := path.Clean(filepath.ToSlash(p.unitDirs[unitDirKey{unitType, unitName}]))
+		if dir == "." {
+			return file
+		}
+		if rel, err := filepath.Rel(dir, path.Clean(file)); err == nil {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return file
+}
+
+// def returns the display form of def's file.
+func (p *pathDisplay) def(def *graph.Def) string {
+	return p.path(def.UnitType, def.Unit, def.File)
+}
+
+// ref returns the display form of ref's file.
+func (p *pathDisplay) ref(ref *graph.Ref) string {
+	return p.path(ref.UnitType, ref.Unit, ref.File)
+}
+
+// queryPaths caches the pathDisplay for --paths of the query command's
+// active context (which changes in --watch mode).
+var queryPaths struct {
+	repo    *Repo
+	display *pathDisplay
+}
+
+// queryDisplayPath returns the display form (given by the query
+// command's --paths) of file in the given source unit of repo. Only
+// the files of the active context's repo are converted.
+func queryDisplayPath(repo, unitType, unitName, file string) string {
+	r := activeContext.repo
+	if r == nil || queryCmd.Paths == "" || queryCmd.Paths == "repo-relative" || (repo != "" && repo != r.URI()) {
+		return file
+	}
+	if queryPaths.repo != r {
+		units := func() ([]*unit.SourceUnit, error) {
+			if activeContext.commitFS == nil {
+				return nil, nil
+			}
+			return commitUnits(activeContext.commitFS)()
+		}
+		p, err := newPathDisplay(queryCmd.Paths, r.RootDir, units)
+		if err != nil {
+			log.Printf("Warning: %s (printing repo-relative paths)", err)
+		}
+		queryPaths.repo, queryPaths.display = r, p
+	}
+	return queryPaths.display.path(unitType, unitName, file)
+}
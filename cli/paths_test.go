@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestPathDisplay(t *testing.T) {
+	units := func() ([]*unit.SourceUnit, error) {
+		return []*unit.SourceUnit{
+			{Type: "t", Name: "a", Dir: "sub/a"},
+			{Type: "t", Name: "root", Dir: "."},
+		}, nil
+	}
+	tests := []struct {
+		base, unit, file, want string
+	}{
+		{"repo-relative", "a", "sub/a/x.go", "sub/a/x.go"},
+		{"absolute", "a", "sub/a/x.go", "/r/sub/a/x.go"},
+		{"unit-relative", "a", "sub/a/x.go", "x.go"},
+		{"unit-relative", "a", "sub/b/y.go", "../b/y.go"},
+		{"unit-relative", "root", "sub/a/x.go", "sub/a/x.go"},
+		{"unit-relative", "unknown", "z.go", "z.go"},
+	}
+	for _, test := range tests {
+		p, err := newPathDisplay(test.base, "/r", units)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.path("t", test.unit, test.file); got != test.want {
+			t.Errorf("%s: got %q for %s in unit %s, want %q", test.base, got, test.file, test.unit, test.want)
+		}
+	}
+
+	if _, err := newPathDisplay("x", "/r", units); err == nil {
+		t.Error("got no error for an invalid --paths value")
+	}
+}
+
+func TestDeltaSideRelocate(t *testing.T) {
+	s := &deltaSide{paths: &pathDisplay{base: "absolute", root: "/r"}, repoFiles: map[*graph.Def]string{}}
+	def := &graph.Def{DefKey: graph.DefKey{UnitType: "t", Unit: "u", Path: "p"}, File: "a.go"}
+	s.relocate(def)
+	s.relocate(def) // already relocated
+	if def.File != "/r/a.go" {
+		t.Errorf("got relocated file %q, want %q", def.File, "/r/a.go")
+	}
+	if got := s.repoFile(def); got != "a.go" {
+		t.Errorf("got repo file %q, want %q", got, "a.go")
+	}
+}
@@ -2,12 +2,13 @@ package cli
 
 import (
 	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"reflect"
@@ -19,7 +20,11 @@ import (
 
 	"sort"
 
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
 	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
 
 	"github.com/alexsaveliev/go-colorable-wrapper"
 	"github.com/peterh/liner"
@@ -38,26 +43,161 @@ func init() {
 }
 
 type QueryCmd struct {
+	Global bool      `long:"global" description:"search all repos in the global store (SRCLIBSTORE) instead of the current repo; does not need to be run inside a repo"`
+	Repos  []RepoURI `long:"repo" description:"search only this repo (a URI or alias; see 'src aliases') in the global store (may be repeated; implies --global)" value-name:"REPO"`
+
+	Rev string `long:"rev" description:"query this commit of the current repo from its local store (.srclib-store) instead of building the working tree's commit; REV is a label given with 'src store import --label' (e.g., a branch name) or an imported commit ID prefix" value-name:"REV"`
+
+	SelectDeps bool `long:"select-deps" description:"interactively choose which of the current repo's dependencies (in the global store) to search in addition to the current repo"`
+	OnlyDeps   bool `long:"only-deps" description:"search all of the current repo's dependencies (in the global store) instead of the current repo"`
+	NoDeps     bool `long:"no-deps" description:"search only the current repo, not its dependencies (the default)"`
+
+	ShowDupes bool `long:"show-dupes" description:"show every copy of defs that exist in multiple repos or commits, instead of only the one nearest to the current repo"`
+
+	CompletionTimeout time.Duration `long:"completion-timeout" description:"max time to spend looking up name completions (0 for no limit)" default:"500ms"`
+	CompletionLimit   int           `long:"completion-limit" description:"max number of defs to consider for name completions (0 for no limit)" default:"1000"`
+
+	Locations bool `long:"locations" description:"print each def and ref as a single FILE:LINE:COL: line (like a compiler error), so editors and IDE terminals can jump to it"`
+
+	Tree bool `long:"tree" description:"show results grouped by repo, source unit, and file (with counts) instead of as a flat list"`
+
+	SignatureOpt
+	PathsOpt
+
+	Render string `long:"render" description:"how to render defs, docs, and snippets: terminal, markdown (for wikis and pull request comments), or html (a fragment for web pages and chat bots)" default:"terminal" value-name:"FORMAT"`
+
+	NDJSON bool `long:"ndjson" description:"stream results (given ARGS) as newline-delimited JSON, writing each def and ref as soon as it is found, followed by any snippets and a final \"done\" record"`
+
+	Watch         bool          `long:"watch" description:"re-run the query (given as ARGS) whenever the current repo's build data changes (e.g., after 'src make') or its working tree switches to another commit (whose data is prepared in the background)"`
+	WatchInterval time.Duration `long:"watch-interval" description:"how often to check for build data changes in --watch mode" default:"1s"`
+
+	Save string `long:"save" description:"save the query (given as ARGS) under NAME for later use with --run, instead of running it" value-name:"NAME"`
+	Run  string `long:"run" description:"run the query saved under NAME (any ARGS are appended to it)" value-name:"NAME"`
+
 	Args struct {
-		Rest []string `name:"ARGS"`
+		Rest []DefName `name:"ARGS"`
 	} `positional-args:"yes"`
 }
 
 var queryCmd QueryCmd
 
+// query returns the query given as ARGS.
+func (c *QueryCmd) query() string {
+	args := make([]string, len(c.Args.Rest))
+	for i, arg := range c.Args.Rest {
+		args[i] = string(arg)
+	}
+	return strings.Join(args, " ")
+}
+
+// repos returns the repos given with --repo.
+func (c *QueryCmd) repos() []string {
+	repos := make([]string, len(c.Repos))
+	for i, repo := range c.Repos {
+		repos[i] = string(repo)
+	}
+	return repos
+}
+
 var historyFile = filepath.Join(os.TempDir(), ".srclibq_history")
 
 var activeContext commandContext
 
 func (c *QueryCmd) Execute(args []string) error {
-	if err := setActiveContext("."); err != nil {
+	if c.Save != "" {
+		if c.Run != "" {
+			return errors.New("--save and --run can't be used together")
+		}
+		if len(c.Args.Rest) == 0 {
+			return errors.New("--save requires a query (given as ARGS)")
+		}
+		if err := saveQuery(c.Save, c.query()); err != nil {
+			return err
+		}
+		colorable.Printf("Saved query as %q; run it with 'src query --run %s'.\n", c.Save, c.Save)
+		return nil
+	}
+	if c.Run != "" {
+		query, err := savedQuery(c.Run)
+		if err != nil {
+			return err
+		}
+		c.Args.Rest = append([]DefName{DefName(query)}, c.Args.Rest...)
+	}
+
+	if nDepFlags := countTrue(c.SelectDeps, c.OnlyDeps, c.NoDeps); nDepFlags > 1 {
+		return errors.New("only one of --select-deps, --only-deps, and --no-deps may be given")
+	} else if nDepFlags == 1 && (c.Global || len(c.Repos) != 0) {
+		return errors.New("--select-deps, --only-deps, and --no-deps can't be used with --global or --repo")
+	}
+
+	if c.Rev != "" && (c.Global || len(c.Repos) != 0 || c.Watch) {
+		return errors.New("--rev can't be used with --global, --repo, or --watch")
+	}
+	if err := c.SignatureOpt.validate(); err != nil {
+		return err
+	}
+	if err := checkPathBase(c.Paths); err != nil {
+		return err
+	}
+	if _, ok := queryRenderers[c.Render]; !ok {
+		return fmt.Errorf("unrecognized --render value: %q (valid values are terminal, markdown, html)", c.Render)
+	} else if c.Render != "terminal" && (c.Locations || c.Tree || c.NDJSON) {
+		return errors.New("--render can't be used with --locations, --tree, or --ndjson")
+	}
+	if c.NDJSON {
+		if len(c.Args.Rest) == 0 {
+			return errors.New("--ndjson requires a query (given as ARGS)")
+		}
+		if c.Tree || c.Locations || c.Watch {
+			return errors.New("--ndjson can't be used with --tree, --locations, or --watch")
+		}
+		queryStream = newNDJSONStream(os.Stdout)
+	}
+
+	if c.Global || len(c.Repos) != 0 {
+		repos, err := resolveRepos(c.repos())
+		if err != nil {
+			return err
+		}
+		for i, repo := range repos {
+			c.Repos[i] = RepoURI(repo)
+		}
+
+		// Query the global store, which doesn't require a
+		// current repo or building anything.
+		storeCmd.Type = "MultiRepoStore"
+		storeCmd.Root = srclib.StoreDir
+		if GlobalOpt.Verbose {
+			log.Printf("# Querying global store at %s", storeCmd.Root)
+		}
+	} else if c.Rev != "" {
+		if err := setRevContext(c.Rev); err != nil {
+			return err
+		}
+	} else if err := setActiveContext("."); err != nil {
 		// TODO: log error somewhere
 		log.Println("Errors were found building this project. Some things may be broken. Continuing...")
 	}
+	if err := c.setDepScope(os.Stdin); err != nil {
+		return err
+	}
+	if c.Watch {
+		if len(c.Args.Rest) == 0 {
+			return errors.New("--watch requires a query (given as ARGS)")
+		}
+		if activeContext.commitFS == nil {
+			return errors.New("--watch requires a current repo with build data (it can't be used with --global or --repo)")
+		}
+		return watchQuery(c.query(), c.WatchInterval)
+	}
+	if queryStream != nil {
+		return streamQuery(c.query(), queryStream)
+	}
 	if len(c.Args.Rest) != 0 {
 		// If args are provided, evaluate the args and do not
 		// enter the interactive interface.
-		output, err := eval(strings.Join(c.Args.Rest, " "))
+		output, err := eval(c.query())
 		// Always print output, even if err is non-nil.
 		if output != "" {
 			colorable.Print(cleanOutput(output))
@@ -73,6 +213,11 @@ func (c *QueryCmd) Execute(args []string) error {
 		return err
 	}
 	defer persist(term, historyFile)
+	defer func() {
+		if err := stopTranscript(); err != nil {
+			colorable.Println("Error: writing transcript:", err)
+		}
+	}()
 	term.SetWordCompleter(wordCompleter)
 	term.SetTabCompletionStyle(liner.TabPrints)
 
@@ -86,7 +231,27 @@ func (c *QueryCmd) Execute(args []string) error {
 			return err
 		}
 		term.AppendHistory(line)
-		output, err := eval(line)
+		if query, op, target := splitRedirect(line); op != 0 {
+			err := evalRedirect(query, op, target)
+			if err != nil {
+				colorable.Println("Error:", err)
+			}
+			if activeTranscript != nil {
+				if err := activeTranscript.recordRedirect(query, op, target, err); err != nil {
+					colorable.Println("Error: writing transcript:", err)
+				}
+			}
+			releaseStoreLocks()
+			continue
+		}
+		objs, f, output, err := evalObjects(line)
+		if activeTranscript != nil && !isTranscriptCommand(line) {
+			if err := activeTranscript.record(line, objs, f, output, err); err != nil {
+				colorable.Println("Error: writing transcript:", err)
+			}
+		}
+		output, err = renderEvaluated(line, objs, f, output, err)
+		releaseStoreLocks()
 		if err != nil {
 			colorable.Println("Error:", err)
 			if output != "" {
@@ -99,7 +264,12 @@ func (c *QueryCmd) Execute(args []string) error {
 }
 
 func setActiveContext(repoPath string) error {
-	colorable.Printf("Analyzing project...")
+	// Keep progress output out of streamed results.
+	status := colorable.Print
+	if queryStream != nil {
+		status = func(a ...interface{}) (int, error) { return fmt.Fprint(os.Stderr, a...) }
+	}
+	status("Analyzing project...")
 	// Build project concurrently so we can update the UI.
 	type maybeContext struct {
 		context commandContext
@@ -114,29 +284,83 @@ OuterLoop:
 	for {
 		select {
 		case <-time.Tick(time.Second):
-			colorable.Print(".")
+			status(".")
 		case m := <-done:
 			if m.err != nil {
-				colorable.Println()
+				status("\n")
 				return m.err
 			}
 			activeContext = m.context
 			break OuterLoop
 		}
 	}
-	colorable.Println()
+	status("\n")
 	// Invariant: activeContext is the result of prepareCommandContext
 	// after the loop above.
 	return nil
 }
 
+// setRevContext sets activeContext to rev (a label or commit ID
+// prefix; see resolveStoreRev) of the repo in the current directory.
+// Unlike setActiveContext, it doesn't build anything: the commit's
+// data must already be in the repo's local store.
+func setRevContext(rev string) error {
+	repo, err := OpenRepo(".")
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(repo.RootDir); err != nil {
+		return err
+	}
+	storeCmd.Type = "RepoStore"
+	storeCmd.Root = filepath.Join(repo.RootDir, store.SrclibStoreDir)
+	s, err := OpenStoreReadOnly()
+	if err != nil {
+		return err
+	}
+	rs, ok := s.(store.RepoStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing versions", s)
+	}
+	commitID, err := resolveStoreRev(storeCmd.Root, rs, rev)
+	if err != nil {
+		return err
+	}
+	if GlobalOpt.Verbose {
+		log.Printf("# Querying %s at commit %s (%s)", repo.URI(), commitID, rev)
+	}
+
+	revRepo := *repo
+	revRepo.CommitID = commitID
+	activeContext = commandContext{repo: &revRepo}
+	if bs, err := buildstore.LocalRepo(repo.RootDir); err == nil {
+		// The commit's build data (used for name completions and
+		// deps) is only available if it hasn't been cleaned up.
+		activeContext.buildStore = bs
+		if exists, _ := buildstore.BuildDataExistsForCommit(bs, commitID); exists {
+			activeContext.commitFS = bs.Commit(commitID)
+		}
+	}
+	return nil
+}
+
+// activeCommitID returns the commit ID of the active repo, or the
+// empty string if there is none (e.g., when querying the global
+// store).
+func activeCommitID() string {
+	if activeContext.repo == nil {
+		return ""
+	}
+	return activeContext.repo.CommitID
+}
+
 // matchWithKeyword matches lines that include a colon, ':'.
 //
 // Groups:
-//   1. line prefix
-//   2. keyword name
-//   3. whitespace between keyword name and value
-//   4. value
+//  1. line prefix
+//  2. keyword name
+//  3. whitespace between keyword name and value
+//  4. value
 //
 // If matchWithKeyword does not match, then the input matches all
 // valid values for the implicit keyword, ":name", that are prefixed
@@ -155,7 +379,7 @@ OuterLoop:
 // If group four is not empty, then the input matches all valid values
 // for the keywored named by group one that have group four as their
 // prefix.
-var matchWithKeyword = regexp.MustCompile(`(.*:)(\w*)([[:blank:]]*)(.*)`)
+var matchWithKeyword = regexp.MustCompile(`(.*:)([\w-]*)([[:blank:]]*)(.*)`)
 
 // wordCompleter returns a set of completions for word ending at line[pos].
 func wordCompleter(line string, pos int) (head string, completions []string, tail string) {
@@ -231,35 +455,138 @@ func valueCompleter(keyword, token string) []string {
 		return nameCompleter(token)
 	case keyHelp:
 		return keywordCompleter(keyword)
+	case keySearchDocs:
+		var cs []string
+		for _, v := range []string{"on", "off"} {
+			if strings.HasPrefix(v, token) {
+				cs = append(cs, v)
+			}
+		}
+		return cs
+	case keyTranscript:
+		var cs []string
+		for _, v := range []string{"start", "stop"} {
+			if strings.HasPrefix(v, token) {
+				cs = append(cs, v)
+			}
+		}
+		return cs
 	}
 	return nil
 }
 
 // nameCompleter returns a set of values that complete token for name.
-// Because nameCompleter blocks, only completes tokens larger than
-// three characters.
-// TODO: investigave blocking -- can the user break out of it?
+// Because nameCompleter blocks (for up to --completion-timeout), only
+// completes tokens larger than three characters.
 func nameCompleter(token string) []string {
 	if len(token) < 4 {
 		return nil
 	}
-	// PERF: do we need to limit this call?
-	c := &StoreDefsCmd{
-		Query:    string(token),
-		CommitID: activeContext.repo.CommitID,
-	}
-	defs, err := c.Get()
-	if err != nil {
-		// TODO: log this error.
-		return nil
-	}
+	defs := completionDefs(token)
 	completions := make([]string, 0, len(defs))
+	seen := make(map[string]struct{}, len(defs))
 	for _, d := range defs {
+		if _, dup := seen[d.Name]; dup {
+			continue
+		}
+		seen[d.Name] = struct{}{}
 		completions = append(completions, d.Name)
 	}
+	activeNameFreqs().Rank(completions)
 	return completions
 }
 
+// completionDefs returns the defs whose names (or, with
+// ":search-docs on", doc titles) match token, for name completion. If --repo was given, the repos are searched one at a
+// time, in order, so that the first ones are most likely to be
+// completed. The search stops when queryCmd.CompletionLimit defs were
+// found or when queryCmd.CompletionTimeout has elapsed (in which case
+// the defs found so far are returned, and the pending lookup's results
+// are discarded).
+func completionDefs(token string) []*graph.Def {
+	phases := [][]string{nil}
+	if repos := queryCmd.repos(); len(repos) > 0 {
+		phases = make([][]string, len(repos))
+		for i, repo := range repos {
+			phases[i] = []string{repo}
+		}
+	}
+
+	var timeout <-chan time.Time
+	if queryCmd.CompletionTimeout > 0 {
+		timeout = time.After(queryCmd.CompletionTimeout)
+	}
+
+	var defs []*graph.Def
+	for _, repos := range phases {
+		limit := 0
+		if queryCmd.CompletionLimit > 0 {
+			if limit = queryCmd.CompletionLimit - len(defs); limit <= 0 {
+				break
+			}
+		}
+		c := &StoreDefsCmd{
+			Query:    token,
+			CommitID: activeCommitID(),
+			Repos:    repos,
+			Limit:    limit,
+		}
+		done := make(chan []*graph.Def, 1)
+		searchDocs := querySearchDocs
+		go func() {
+			phaseDefs, err := c.Get()
+			if err == nil && searchDocs {
+				dc := *c
+				dc.Query, dc.DocTitle = "", token
+				var docDefs []*graph.Def
+				docDefs, err = dc.Get()
+				phaseDefs = append(phaseDefs, docDefs...)
+				if limit > 0 && len(phaseDefs) > limit {
+					phaseDefs = phaseDefs[:limit]
+				}
+			}
+			if err != nil && GlobalOpt.Verbose {
+				log.Printf("Warning: looking up completions for %q: %s", token, err)
+			}
+			done <- phaseDefs
+		}()
+		select {
+		case phaseDefs := <-done:
+			defs = append(defs, phaseDefs...)
+		case <-timeout:
+			if GlobalOpt.Verbose {
+				log.Printf("Completion lookup for %q timed out after %s.", token, queryCmd.CompletionTimeout)
+			}
+			return defs
+		}
+	}
+	return defs
+}
+
+// nameFreqs caches the def name frequency table of the build data
+// for nameFreqsCommitID.
+var (
+	nameFreqs         store.DefNameFreqs
+	nameFreqsCommitID string
+)
+
+// activeNameFreqs returns the def name frequency table recorded when
+// the active commit's build data was imported, or nil if there is
+// none.
+func activeNameFreqs() store.DefNameFreqs {
+	if activeContext.commitFS == nil {
+		return nil
+	}
+	if commitID := activeCommitID(); commitID != nameFreqsCommitID {
+		freqs, err := store.ReadDefNameFreqs(activeContext.commitFS)
+		if err != nil && GlobalOpt.Verbose {
+			log.Printf("Warning: reading def name frequencies: %s", err)
+		}
+		nameFreqs, nameFreqsCommitID = freqs, commitID
+	}
+	return nameFreqs
+}
+
 // cleanOutput returns o with only one trailing newline.
 func cleanOutput(o string) string {
 	return strings.TrimSuffix(o, "\n") + "\n"
@@ -388,7 +715,20 @@ var (
 	keyKind   tokKeyword = "kind"
 	keyFile   tokKeyword = "file"
 	keyLimit  tokKeyword = "limit"
+	keySearch tokKeyword = "search"
 	keyHelp   tokKeyword = "help"
+	keyShow   tokKeyword = "show"
+
+	keySearchDocs tokKeyword = "search-docs"
+	keyTranscript tokKeyword = "transcript"
+
+	// The following keywords are display commands. When they are
+	// used without a name, they change how the last result set is
+	// displayed instead of running a new query.
+	keyDefs    tokKeyword = "defs"
+	keyRefs    tokKeyword = "refs"
+	keyDoc     tokKeyword = "doc"
+	keyAuthors tokKeyword = "authors"
 )
 
 // keywordInfo holds a keyword's meta information.
@@ -423,8 +763,8 @@ var keywordInfoMap = map[tokKeyword]keywordInfo{
 ':name' is special because it is implicit if no keyword is used, and its input cannot be a list. For example, the input 'some word' is equivalent to ':name some word'.`,
 	},
 	keyIn: keywordInfo{
-		argName:     "repo",
-		description: "Change the repository to 'repo'.",
+		argName:     "repos",
+		description: "Search only 'repos' in the global store, instead of the current repo (or the repos given with --repo). Repos may be given by URI or by alias (e.g., 'mux' for github.com/gorilla/mux; see 'src aliases').",
 	},
 	keySelect: keywordInfo{
 		validVals:   []tokValue{"defs", "refs", "docs"},
@@ -446,6 +786,10 @@ var keywordInfoMap = map[tokKeyword]keywordInfo{
 		argName:     "formats",
 		description: "Show defs in the formats specificed by 'formats'.",
 	},
+	keySearch: keywordInfo{
+		argName:     "words",
+		description: "Search for defs whose names, paths, or docs match 'words', best match first. Matching tolerates typos, so use it when ':name' finds nothing or you only remember what a def does.",
+	},
 	keyLimit: keywordInfo{
 		typeConstraint: "int",
 		argName:        "number",
@@ -455,6 +799,32 @@ var keywordInfoMap = map[tokKeyword]keywordInfo{
 		argName:     "topics",
 		description: "Show the help for 'topics'. If 'topics' is empty, show general help.",
 	},
+	keyShow: keywordInfo{
+		argName:     "section on|off",
+		description: "Turn 'section' of the def output on or off for all later queries in this repository (the setting is saved). Sections are \"decl\", \"src\", \"docs\" and \"authors\". Turning \"decl\" or \"src\" on or off has no effect when ':format' is given; turning \"docs\" or \"authors\" off hides them unless they are requested explicitly. Without arguments, list the current settings.",
+	},
+	keySearchDocs: keywordInfo{
+		argName:     "on|off",
+		description: "Turn matching ':name' queries and name completions against the first sentence of each def's docs on or off for this session, so defs can be found by what they do. Without arguments, show the current setting.",
+	},
+	keyTranscript: keywordInfo{
+		argName:     "start FILE|stop",
+		description: "Start or stop recording the queries of this session and their results to the Markdown file 'FILE' (with code in fenced code blocks), so the session can be shared as a readable document. Without arguments, show whether a transcript is being recorded.",
+	},
+	keyDefs: keywordInfo{
+		description: "Display only the defs of the last result set, hiding refs, docs and authors.",
+	},
+	keyRefs: keywordInfo{
+		typeConstraint: "int",
+		argName:        "number",
+		description:    "Display the last result set with up to 'number' refs for each def. If 'number' is empty, display all refs.",
+	},
+	keyDoc: keywordInfo{
+		description: "Display the last result set with the docs for each def.",
+	},
+	keyAuthors: keywordInfo{
+		description: "Display the last result set with the authors of each def, according to the VCS history (git only). Authors are listed with the number of lines and commits of theirs in the def and when they last touched it. Identities are merged according to the repo's .mailmap file.",
+	},
 }
 
 // allKeywords is a sorted list of all available keywords.
@@ -688,7 +1058,7 @@ func lexKeyword(l *lexer) stateFn {
 	// if GlobalOpt.Verbose {
 	// 	log.Printf("lexKeyword: on %s", string(l.peek()))
 	// }
-	l.acceptRun(alpha)
+	l.acceptRun(alpha + "-")
 	l.emitKeyword()
 	return lexStart
 }
@@ -879,6 +1249,10 @@ type format struct {
 	showDefDecl bool
 	showDefBody bool
 	limit       int
+	showAuthors bool
+	// refsLimit is the maximum number of refs to display for
+	// each def. If it is 0, all refs are displayed.
+	refsLimit int
 	// The following are unimplemented:
 	showDefMethods bool
 	showDefFull    bool
@@ -890,7 +1264,7 @@ func briefHelpText() string {
 	buf := &bytes.Buffer{}
 	buf.WriteString("Available commands: -- \":help all\" for detailed help, \":help usage\" for usage\n")
 	for i, k := range allKeywords {
-		fmt.Fprintf(buf, "  :%s <%s>", k, keywordInfoMap[k].argName)
+		fmt.Fprintf(buf, "  %s", keywordUsage(k))
 		if i != len(allKeywords)-1 {
 			buf.WriteString("\n")
 		}
@@ -898,6 +1272,15 @@ func briefHelpText() string {
 	return buf.String()
 }
 
+// keywordUsage returns the usage for k, such as ":limit <number>".
+// Keywords without an argument name are shown alone.
+func keywordUsage(k tokKeyword) string {
+	if argName := keywordInfoMap[k].argName; argName != "" {
+		return fmt.Sprintf(":%s <%s>", k, argName)
+	}
+	return ":" + string(k)
+}
+
 // helpText returns the concatenated help text for each topic, where
 // topic is "all", "usage" or a keyword name (such as "format").
 // TODO: explain "all" and "usage".
@@ -932,6 +1315,16 @@ src> Hello :kind func
 ;; All functions that begin with "Hello". ":kind" is language-defined.
 src> Hello :format decl
 ;; All definition declarations -- ignore defintion bodies.
+src> :refs 3
+;; Re-display the last results with up to 3 references each.
+src> :doc :authors
+;; Re-display the last results with their docs and authors.
+src> :defs
+;; Re-display the last results without refs, docs or authors.
+src> Hello :select defs, refs > hello.json
+;; Write the results (and their references) as JSON to hello.json.
+src> Hello | jq '.[].Path'
+;; Pipe the results as JSON to a shell command.
 `)
 			continue
 		}
@@ -943,7 +1336,7 @@ src> Hello :format decl
 		if buf.Len() == 0 {
 			fmt.Fprint(buf, "Keywords:\n")
 		}
-		fmt.Fprintf(buf, "-- :%s <%s>\n%s%s\n", k, info.argName,
+		fmt.Fprintf(buf, "-- %s\n%s%s\n", keywordUsage(k),
 			indent, strings.Replace(info.description, "\n", "\n"+indent, -1))
 		if len(info.validVals) == 0 &&
 			len(info.defaultVals) == 0 &&
@@ -959,7 +1352,7 @@ src> Hello :format decl
 			fmt.Fprintf(buf, "%sDefault values for '%s': %s\n",
 				indent, info.argName, formatValues(info.defaultVals))
 		}
-		if info.typeConstraint == "" {
+		if info.typeConstraint != "" {
 			fmt.Fprintf(buf, "%s'%s' must be of type %s\n",
 				indent, info.argName, info.typeConstraint)
 		}
@@ -970,62 +1363,573 @@ src> Hello :format decl
 // eval evaluates input and returns the results as output. If output
 // is non-empty, it should be displayed even if err is non-nil.
 func eval(input string) (output string, err error) {
+	objs, f, output, err := evalObjects(input)
+	return renderEvaluated(input, objs, f, output, err)
+}
+
+// renderEvaluated renders the results of evalObjects(input) with the
+// active renderer.
+func renderEvaluated(input string, objs interface{}, f format, output string, err error) (string, error) {
+	if objs == nil || err != nil {
+		return output, err
+	}
+	if isEmptyResult(objs) {
+		if i, err := parse(input); err == nil && len(i.get(keyName)) != 0 {
+			return noResultsText(i.get(keyName)), nil
+		}
+	}
+	if queryCmd.Tree {
+		return formatTree(objs), nil
+	}
+	return formatObject(objs, f), nil
+}
+
+// isEmptyResult returns whether objs (as returned by evalObjects)
+// holds no defs.
+func isEmptyResult(objs interface{}) bool {
+	switch o := objs.(type) {
+	case []*graph.Def:
+		return len(o) == 0
+	case []defRefs:
+		return len(o) == 0
+	}
+	return false
+}
+
+// evalObjects evaluates input and returns the resulting objects
+// (either []*graph.Def or []defRefs) and the format to display them
+// in. If input doesn't query any objects (e.g., if it is a ":help"
+// command), objs is nil and output holds the text to display instead.
+func evalObjects(input string) (objs interface{}, f format, output string, err error) {
 	i, err := parse(input)
 	if err != nil {
-		return briefHelpText(), err
+		return nil, f, briefHelpText(), err
 	}
 	// There is some sense of order: if i is empty or ":help" is
 	// set, do not evaluate the rest of 'i'.
 	switch {
 	case i.isEmpty():
-		return briefHelpText(), nil
+		return nil, f, briefHelpText(), nil
 	case i.get(keyHelp) != nil:
-		return helpText(i.get(keyHelp))
-	}
+		output, err := helpText(i.get(keyHelp))
+		return nil, f, output, err
+	case i.get(keyShow) != nil:
+		output, err := showCommand(i.get(keyShow))
+		return nil, f, output, err
+	case i.get(keySearchDocs) != nil:
+		output, err := searchDocsCommand(i.get(keySearchDocs))
+		return nil, f, output, err
+	case i.get(keyTranscript) != nil:
+		output, err := transcriptCommand(i.get(keyTranscript))
+		return nil, f, output, err
+	}
+	formatGiven := len(i.get(keyFormat)) != 0
 	i.setDefaults()
 
-	f := inputToFormat(i)
-	var out []string
-	// TODO: only deal with one name!
-	for _, input := range i.get(keyName) {
-		c := &StoreDefsCmd{
-			Query:    string(input),
-			CommitID: activeContext.repo.CommitID,
-			Limit:    f.limit,
-		}
-		// TODO: make the following filters work with more
-		// than one value.
-		if len(i.get(keyKind)) != 0 {
-			c.Filter = byDefKind{string(i.get(keyKind)[0])}
+	var defs []*graph.Def
+	if len(i.get(keyName)) == 0 && len(i.get(keySearch)) == 0 {
+		if !hasDisplayCommands(i) {
+			return nil, f, "", nil
 		}
-		if len(i.get(keyFile)) != 0 {
-			c.File = string(i.get(keyFile)[0])
+		// Only display commands were given, so re-display the
+		// last result set.
+		if !lastResults.valid {
+			return nil, f, "", errors.New("no results to display; run a query first")
 		}
-		defs, err := c.Get()
-		if err != nil {
-			return "", err
+		defs, f = lastResults.defs, lastResults.f
+	} else {
+		f = inputToFormat(i)
+		activeShowSettings().apply(&f, formatGiven)
+		var inRepos []string
+		if in := i.get(keyIn); len(in) != 0 {
+			names := make([]string, len(in))
+			for j, v := range in {
+				names[j] = strings.TrimSpace(string(v))
+			}
+			if inRepos, err = resolveRepos(names); err != nil {
+				return nil, f, "", err
+			}
 		}
-		if f.showRefs {
-			outDefRefs := make([]defRefs, 0, len(defs))
-			for _, d := range defs {
-				c := &StoreRefsCmd{
-					DefRepo:     d.Repo,
-					DefUnitType: d.UnitType,
-					DefUnit:     d.Unit,
-					DefPath:     d.Path,
+		// lookup finds the defs selected by c (which specifies a
+		// name, doc title, or search query) and the rest of the input.
+		lookup := func(c *StoreDefsCmd) ([]*graph.Def, error) {
+			c.CommitID = activeCommitID()
+			c.Repos = queryCmd.repos()
+			c.Limit = f.limit
+			// TODO: make the following filters work with more
+			// than one value.
+			if len(i.get(keyKind)) != 0 {
+				c.Filter = byDefKind{string(i.get(keyKind)[0])}
+			}
+			if len(i.get(keyFile)) != 0 {
+				c.File = string(i.get(keyFile)[0])
+			}
+			if len(inRepos) != 0 {
+				return globalStoreDefs(*c, inRepos)
+			}
+			var nameDefs []*graph.Def
+			if !queryScope.excludeCurrent {
+				var err error
+				if nameDefs, err = c.Get(); err != nil {
+					return nil, err
 				}
-				refs, err := c.Get()
+			}
+			depNameDefs, err := depDefs(*c)
+			if err != nil {
+				return nil, err
+			}
+			nameDefs = append(nameDefs, depNameDefs...)
+			if f.limit > 0 && len(nameDefs) > f.limit {
+				nameDefs = nameDefs[:f.limit]
+			}
+			return nameDefs, nil
+		}
+		// TODO: only deal with one name!
+		for _, input := range i.get(keyName) {
+			nameDefs, err := lookup(&StoreDefsCmd{Query: string(input)})
+			if err != nil {
+				return nil, f, "", err
+			}
+			defs = append(defs, nameDefs...)
+			if querySearchDocs {
+				docDefs, err := lookup(&StoreDefsCmd{DocTitle: string(input)})
 				if err != nil {
-					return "", err
+					return nil, f, "", err
 				}
-				outDefRefs = append(outDefRefs, defRefs{d, refs})
+				defs = append(defs, docDefs...)
+			}
+		}
+		if words := i.get(keySearch); len(words) != 0 {
+			q := make([]string, len(words))
+			for j, w := range words {
+				q[j] = string(w)
+			}
+			searchDefs, err := lookup(&StoreDefsCmd{Search: strings.Join(q, " ")})
+			if err != nil {
+				return nil, f, "", err
+			}
+			defs = append(defs, searchDefs...)
+		}
+		if !queryCmd.ShowDupes {
+			defs = dedupDefs(defs)
+		}
+	}
+	f, err = applyDisplayCommands(i, f)
+	if err != nil {
+		return nil, f, "", err
+	}
+	lastResults.defs, lastResults.f, lastResults.valid = defs, f, true
+	if queryStream != nil {
+		for _, d := range defs {
+			if err := queryStream.def(d); err != nil {
+				return nil, f, "", err
+			}
+		}
+	}
+
+	if f.showRefs {
+		outDefRefs := make([]defRefs, 0, len(defs))
+		for _, d := range defs {
+			c := &StoreRefsCmd{
+				DefRepo:     d.Repo,
+				DefUnitType: d.UnitType,
+				DefUnit:     d.Unit,
+				DefPath:     d.Path,
+			}
+			refs, err := c.Get()
+			if err != nil {
+				return nil, f, "", err
+			}
+			if f.refsLimit > 0 {
+				refs = limitRefs(refs, f.refsLimit)
+			}
+			if queryStream != nil {
+				for _, ref := range withoutDefRefs(refs) {
+					if err := queryStream.ref(ref); err != nil {
+						return nil, f, "", err
+					}
+				}
+			}
+			outDefRefs = append(outDefRefs, defRefs{d, refs})
+		}
+		return outDefRefs, f, "", nil
+	}
+	return defs, f, "", nil
+}
+
+// dedupDefs removes defs that are copies of the same def (i.e., that
+// have the same source unit and path but are in different repos or
+// commits). Of each set of copies, it keeps the def from the current
+// repo, or else the def at the commit that the current repo pins the
+// dep to, or else the def at the newest commit (the one most recently
+// imported into the global store). The order of defs is otherwise
+// preserved.
+func dedupDefs(defs []*graph.Def) []*graph.Def {
+	c := activeDedupContext()
+
+	// proximity ranks a def by how near it is to the current repo
+	// (lower is nearer).
+	proximity := func(d *graph.Def) int {
+		switch {
+		case d.Repo == "" || (c.currentRepo != "" && graph.URIEqual(d.Repo, c.currentRepo)):
+			return 0
+		case d.CommitID != "" && c.pinned[d.Repo] == d.CommitID:
+			return 1
+		default:
+			return 2
+		}
+	}
+
+	type copyKey struct{ unitType, unit, path string }
+	best := make(map[copyKey]int, len(defs)) // index in deduped of the best copy seen so far
+	deduped := make([]*graph.Def, 0, len(defs))
+	for _, d := range defs {
+		k := copyKey{d.UnitType, d.Unit, d.Path}
+		if i, seen := best[k]; seen {
+			if p, q := proximity(d), proximity(deduped[i]); p < q || (p == q && c.imported(d).After(c.imported(deduped[i]))) {
+				deduped[i] = d
 			}
-			out = append(out, formatObject(outDefRefs, f))
 			continue
 		}
-		out = append(out, formatObject(defs, f))
+		best[k] = len(deduped)
+		deduped = append(deduped, d)
+	}
+	return deduped
+}
+
+// dedupContext is what dedupDefs knows about the query command's
+// active context. It is computed once per active context (which
+// changes in --watch mode), not on every evaluation.
+type dedupContext struct {
+	repo        *Repo             // the active context's repo
+	currentRepo string            // URI of the current repo, if any
+	pinned      map[string]string // see pinnedDepCommits
+
+	global      interface{}             // the global store
+	importTimes map[[2]string]time.Time // cached import times, keyed by repo and commit ID
+}
+
+var queryDedup *dedupContext
+
+// activeDedupContext returns the dedupContext of the active context.
+// With --global or --repo, there is no current repo (and no local
+// checkout is consulted).
+func activeDedupContext() *dedupContext {
+	if queryDedup != nil && queryDedup.repo == activeContext.repo {
+		return queryDedup
+	}
+	c := &dedupContext{
+		repo:        activeContext.repo,
+		global:      store.NewFSMultiRepoStore(rwvfs.Walkable(rwvfs.ReadOnly(rwvfs.OS(srclib.StoreDir))), nil),
+		importTimes: map[[2]string]time.Time{},
+	}
+	if activeContext.repo != nil {
+		c.currentRepo = activeContext.repo.URI()
+	} else if !queryCmd.Global && len(queryCmd.Repos) == 0 {
+		if repo, _ := OpenLocalRepo(); repo != nil {
+			c.currentRepo = repo.URI()
+		}
+	}
+	c.pinned = pinnedDepCommits(c.global)
+	queryDedup = c
+	return c
+}
+
+// imported returns the time that d's commit was imported into the
+// global store, or the zero time if it isn't known.
+func (c *dedupContext) imported(d *graph.Def) time.Time {
+	if d.Repo == "" || d.CommitID == "" {
+		return time.Time{}
+	}
+	k := [2]string{d.Repo, d.CommitID}
+	t, cached := c.importTimes[k]
+	if !cached {
+		var err error
+		if t, _, err = store.ImportTime(c.global, d.Repo, d.CommitID); err != nil && GlobalOpt.Verbose {
+			log.Printf("Warning: reading import time of %s commit %s: %s", d.Repo, d.CommitID, err)
+		}
+		c.importTimes[k] = t
+	}
+	return t
+}
+
+// pinnedDepCommits returns the commit IDs that the active repo's
+// resolved deps specify, keyed by dep repo URI. A dep's revision is
+// resolved to a commit of the dep's repo in the global store (see
+// resolveDepRev); deps whose revision isn't known or can't be resolved
+// are omitted.
+func pinnedDepCommits(global interface{}) map[string]string {
+	pinned := map[string]string{}
+	if activeContext.commitFS == nil {
+		return pinned
+	}
+	deps, _, err := getDepResolutions(activeContext)
+	if err != nil {
+		if GlobalOpt.Verbose {
+			log.Printf("Warning: reading dep resolutions: %s", err)
+		}
+		return pinned
+	}
+	for _, d := range deps {
+		if d.Target == nil || d.Target.ToRevSpec == "" {
+			continue
+		}
+		uri, err := graph.TryMakeURI(d.Target.ToRepoCloneURL)
+		if err != nil {
+			continue
+		}
+		if commitID := resolveDepRev(global, uri, d.Target.ToRevSpec); commitID != "" {
+			pinned[uri] = commitID
+		} else if GlobalOpt.Verbose {
+			log.Printf("Warning: dep %s is pinned to %q, which doesn't match a commit of it in the global store", uri, d.Target.ToRevSpec)
+		}
+	}
+	return pinned
+}
+
+// resolveDepRev returns the commit ID that rev (a dep's revision)
+// refers to: rev itself, if it is a full commit ID, or else the only
+// commit of repo in the global store whose ID starts with rev. Branch
+// and tag names can't be resolved without a clone of the dep's repo,
+// so it returns "" for them.
+func resolveDepRev(global interface{}, repo, rev string) string {
+	if len(rev) == 40 {
+		return rev
+	}
+	mrs, ok := global.(store.MultiRepoStore)
+	if !ok {
+		return ""
+	}
+	versions, err := mrs.Versions(store.ByRepos(repo), store.VersionFilterFunc(func(v *store.Version) bool {
+		return strings.HasPrefix(v.CommitID, rev)
+	}))
+	if err != nil || len(versions) != 1 {
+		return ""
+	}
+	return versions[0].CommitID
+}
+
+// lastResults holds the defs and display format of the last
+// evaluated input. Display commands (such as ":refs 3") operate on
+// it.
+var lastResults struct {
+	defs  []*graph.Def
+	f     format
+	valid bool // whether any query has been evaluated yet
+}
+
+// displayCommands are the keywords that change how results are
+// displayed.
+var displayCommands = []tokKeyword{keyDefs, keyRefs, keyDoc, keyAuthors}
+
+// hasDisplayCommands returns true if i contains any display commands.
+func hasDisplayCommands(i *inputValues) bool {
+	for _, k := range displayCommands {
+		if i.get(k) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// applyDisplayCommands returns f modified by the display commands
+// (":defs", ":refs", ":doc" and ":authors") in i.
+func applyDisplayCommands(i *inputValues, f format) (format, error) {
+	if i.get(keyDefs) != nil {
+		f.showDefs = true
+		f.showRefs = false
+		f.showDocs = false
+		f.showAuthors = false
+	}
+	if vs := i.get(keyRefs); vs != nil {
+		f.showRefs = true
+		f.refsLimit = 0
+		switch len(vs) {
+		case 0:
+		case 1:
+			n, err := strconv.Atoi(string(vs[0]))
+			if err != nil || n < 0 {
+				return f, fmt.Errorf("invalid value for :%s: %s is not a non-negative integer", keyRefs, vs[0])
+			}
+			f.refsLimit = n
+		default:
+			return f, fmt.Errorf(":%s takes at most one value", keyRefs)
+		}
+	}
+	if i.get(keyDoc) != nil {
+		f.showDocs = true
+	}
+	if i.get(keyAuthors) != nil {
+		f.showAuthors = true
+	}
+	return f, nil
+}
+
+// limitRefs returns the first n refs in refs that are not def refs
+// (which are not displayed anyway).
+func limitRefs(refs []*graph.Ref, n int) []*graph.Ref {
+	var limited []*graph.Ref
+	for _, r := range refs {
+		if len(limited) == n {
+			break
+		}
+		if !r.Def {
+			limited = append(limited, r)
+		}
+	}
+	return limited
+}
+
+// defAuthors returns the authors of the lines that def spans and the
+// number of lines each one wrote (most lines first), according to
+// "git blame".
+func defAuthors(def *graph.Def) ([]authorLines, error) {
+	if activeContext.repo == nil || activeContext.repo.VCSType != "git" {
+		return nil, errors.New("authors are only available for git repositories")
+	}
+	src, err := ioutil.ReadFile(def.File)
+	if err != nil {
+		return nil, err
+	}
+	if def.DefEnd > uint32(len(src)) || def.DefStart > def.DefEnd {
+		return nil, fmt.Errorf("def %s has out-of-bounds byte range [%d, %d)", def.Path, def.DefStart, def.DefEnd)
+	}
+	startLine := bytes.Count(src[:def.DefStart], []byte{'\n'}) + 1
+	endLine := bytes.Count(src[:def.DefEnd], []byte{'\n'}) + 1
+	cmd := exec.Command("git", "blame", "--line-porcelain", "-L", fmt.Sprintf("%d,%d", startLine, endLine), "--", def.File)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("exec %v failed: %s", cmd.Args, err)
+	}
+	mm, err := readMailmap(filepath.Join(activeContext.repo.RootDir, ".mailmap"))
+	if err != nil {
+		return nil, err
+	}
+	var shallow map[string]bool
+	if activeContext.repo.Shallow {
+		// Blame assigns the lines from before a shallow clone's
+		// history to its boundary commits. Rather than fetch all of
+		// the history, report those lines as unattributed.
+		if shallow, err = shallowCommits(activeContext.repo.RootDir); err != nil {
+			return nil, err
+		}
+	}
+	return blameAuthors(out, mm, shallow), nil
+}
+
+// blameAuthors aggregates the output of 'git blame --line-porcelain'
+// by author. Authors are identified by their email (or by their name,
+// if they have no email), after mapping them to their canonical
+// identities with mm. Lines last changed by the boundary commits of a
+// shallow clone (in shallow) are aggregated as unattributed, because
+// they may be older.
+func blameAuthors(blame []byte, mm *mailmap, shallow map[string]bool) []authorLines {
+	byID := map[string]*authorLines{}
+	commits := map[string]map[string]struct{}{}
+	var (
+		commitID, name, email string
+		authorTime            time.Time
+	)
+	for _, line := range strings.Split(string(blame), "\n") {
+		switch {
+		case strings.HasPrefix(line, "\t") && shallow[commitID]:
+			a, present := byID[unattributedID]
+			if !present {
+				a = &authorLines{unattributed: true}
+				byID[unattributedID] = a
+			}
+			a.lines++
+		case strings.HasPrefix(line, "\t"):
+			// The line's contents, which end its header.
+			name, email := mm.resolve(name, email)
+			id := strings.ToLower(email)
+			if id == "" {
+				id = name
+			}
+			a, present := byID[id]
+			if !present {
+				a = &authorLines{name: name, email: email}
+				byID[id] = a
+				commits[id] = map[string]struct{}{}
+			}
+			a.lines++
+			if commitID != notCommittedID {
+				commits[id][commitID] = struct{}{}
+			}
+			if authorTime.After(a.lastTime) {
+				a.lastTime = authorTime
+			}
+		case strings.HasPrefix(line, "author "):
+			name = strings.TrimPrefix(line, "author ")
+		case strings.HasPrefix(line, "author-mail "):
+			email = strings.Trim(strings.TrimPrefix(line, "author-mail "), "<>")
+		case strings.HasPrefix(line, "author-time "):
+			if secs, err := strconv.ParseInt(strings.TrimPrefix(line, "author-time "), 10, 64); err == nil {
+				authorTime = time.Unix(secs, 0)
+			}
+		default:
+			// The first line of each header is the commit ID
+			// followed by line numbers.
+			if fields := strings.Fields(line); len(fields) >= 3 && len(fields[0]) == 40 {
+				commitID = fields[0]
+			}
+		}
 	}
-	return strings.Join(out, "\n"), nil
+
+	authors := make([]authorLines, 0, len(byID))
+	for id, a := range byID {
+		a.commits = len(commits[id])
+		authors = append(authors, *a)
+	}
+	sort.Sort(byLines(authors))
+	return authors
+}
+
+// formatAuthor formats a for display, such as "Alice <alice@example.com>
+// (12 lines, 3 commits, last 2015-06-01)".
+func formatAuthor(a authorLines) string {
+	if a.unattributed {
+		return fmt.Sprintf("%s from before the shallow clone's history (run 'git fetch --unshallow' to attribute them)", pluralize(a.lines, "line"))
+	}
+	id := a.name
+	if a.email != "" {
+		id += " <" + a.email + ">"
+	}
+	detail := pluralize(a.lines, "line")
+	if a.commits > 0 {
+		detail += ", " + pluralize(a.commits, "commit") + ", last " + a.lastTime.Format("2006-01-02")
+	}
+	return fmt.Sprintf("%s (%s)", id, detail)
+}
+
+// notCommittedID is the commit ID that 'git blame' reports for lines
+// that haven't been committed yet.
+const notCommittedID = "0000000000000000000000000000000000000000"
+
+// unattributedID is the blameAuthors key of the lines whose author
+// isn't known because of a shallow clone.
+const unattributedID = "\x00unattributed"
+
+type authorLines struct {
+	name, email string
+	lines       int
+	commits     int       // number of commits that last touched the lines
+	lastTime    time.Time // latest author time of those commits
+
+	unattributed bool // lines from before a shallow clone's history
+}
+
+// byLines sorts authors by number of lines (descending), then by
+// name.
+type byLines []authorLines
+
+func (v byLines) Len() int      { return len(v) }
+func (v byLines) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v byLines) Less(i, j int) bool {
+	if v[i].lines == v[j].lines {
+		return v[i].name < v[j].name
+	}
+	return v[i].lines > v[j].lines
 }
 
 // TODO: move to store package.
@@ -1043,34 +1947,19 @@ type defRefs struct {
 }
 
 func getFileSegment(file string, start, end uint32, header bool) string {
-	f, err := ioutil.ReadFile(file)
+	return getFileSegmentAs(file, file, start, end, header)
+}
+
+// getFileSegmentAs is like getFileSegment, but prints the file's path
+// in the header as display.
+func getFileSegmentAs(file, display string, start, end uint32, header bool) string {
+	if header {
+		return renderFileSegment(terminalRenderer{}, file, display, start, end)
+	}
+	f, err := defaultFileCache.readFile(file)
 	if err != nil {
 		return ""
 	}
-	if header {
-		startLine := bytes.Count(f[:start], []byte{'\n'}) + 1
-		// Roll 'start' back and 'end' forward to the nearest
-		// newline.
-		for ; start-1 > 0 && f[start-1] != '\n'; start-- {
-		}
-		for ; end < uint32(len(f)) && f[end] != '\n'; end++ {
-		}
-		var out []string
-		onLine := startLine
-		for _, line := range bytes.Split(f[start:end], []byte{'\n'}) {
-			var marker string
-			if startLine == onLine {
-				marker = ":"
-			} else {
-				marker = "-"
-			}
-			out = append(out, fmt.Sprintf("%s:%d%s%s",
-				file, onLine, marker, string(line),
-			))
-			onLine++
-		}
-		return strings.Join(out, "\n")
-	}
 	return string(f[start:end])
 }
 
@@ -1080,40 +1969,37 @@ func formatObject(objs interface{}, f format) string {
 		if o == nil {
 			return "def is nil"
 		}
+		if queryCmd.Locations {
+			return filePositionAs(o.File, queryDisplayPath(o.Repo, o.UnitType, o.Unit, o.File), o.DefStart, o.DefEnd)
+		}
+		r := activeRenderer()
 		var output []string
 		if f.showDefs {
-			output = append(output, "---------- def ----------")
-			if f.showDefDecl {
-				b, err := json.Marshal(o)
-				if err != nil {
-					return fmt.Sprintf("error unmarshalling: %s", err)
-				}
-				c := &FmtCmd{
-					UnitType:   o.UnitType,
-					ObjectType: "def",
-					Format:     "decl",
-					Object:     string(b),
-				}
-				out, err := c.Get()
+			output = append(output, r.heading("def"))
+			if f.showDefDecl && queryCmd.Signature != "none" {
+				out, err := defSignature(o)
 				if err != nil {
 					return fmt.Sprintf("error formatting def: %s", err)
 				}
-				output = append(output, out)
+				output = append(output, r.code(queryCmd.SignatureOpt.format(out)))
 			}
 			if f.showDefBody {
-				output = append(output, getFileSegment(o.File, o.DefStart, o.DefEnd, true))
+				output = append(output, renderFileSegment(r, o.File, queryDisplayPath(o.Repo, o.UnitType, o.Unit, o.File), o.DefStart, o.DefEnd))
 			}
 		}
 		if f.showDocs {
-			var data string
-			for _, doc := range o.Docs {
-				if doc.Format == "text/plain" {
-					data = doc.Data
-					break
-				}
+			if d := preferredDoc(o.Docs); d != nil {
+				output = append(output, r.heading("doc"), r.doc(d))
 			}
-			if data != "" {
-				output = append(output, "---------- doc ----------", data)
+		}
+		if f.showAuthors {
+			output = append(output, r.heading("authors"))
+			authors, err := defAuthors(o)
+			if err != nil {
+				output = append(output, r.text(fmt.Sprintf("error getting authors: %s", err)))
+			}
+			for _, a := range authors {
+				output = append(output, r.text(formatAuthor(a)))
 			}
 		}
 		return strings.Join(output, "\n")
@@ -1128,7 +2014,11 @@ func formatObject(objs interface{}, f format) string {
 			return "ref is nil"
 		}
 		if f.showRefs {
-			return getFileSegment(o.File, o.Start, o.End, true)
+			display := queryDisplayPath(o.Repo, o.UnitType, o.Unit, o.File)
+			if queryCmd.Locations {
+				return filePositionAs(o.File, display, o.Start, o.End)
+			}
+			return renderFileSegment(activeRenderer(), o.File, display, o.Start, o.End)
 		}
 		return ""
 	case []*graph.Ref:
@@ -1146,10 +2036,16 @@ func formatObject(objs interface{}, f format) string {
 		}
 		return strings.Join(out, "\n")
 	case defRefs:
+		if queryCmd.Locations {
+			if refs := formatObject(o.refs, f); refs != "" {
+				return formatObject(o.def, f) + "\n" + refs
+			}
+			return formatObject(o.def, f)
+		}
 		var out []string
 		out = append(out,
 			formatObject(o.def, f),
-			"---------- refs ----------",
+			activeRenderer().heading("refs"),
 			formatObject(o.refs, f),
 		)
 		return strings.Join(out, "\n")
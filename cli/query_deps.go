@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+// queryScope holds the dependency repos (whose defs are read from the
+// global store) that are searched in addition to (or, if
+// excludeCurrent is set, instead of) the current repo. It is set by
+// the --select-deps and --only-deps flags.
+var queryScope struct {
+	depRepos       []string
+	excludeCurrent bool
+}
+
+// setDepScope sets queryScope according to the dependency flags of c.
+// It must be called after the active context is set.
+func (c *QueryCmd) setDepScope(in io.Reader) error {
+	if !c.SelectDeps && !c.OnlyDeps {
+		return nil
+	}
+	if activeContext.commitFS == nil {
+		return errors.New("--select-deps and --only-deps require a current repo with build data")
+	}
+	repos, err := depRepoURIs()
+	if err != nil {
+		return err
+	}
+	if len(repos) == 0 {
+		return errors.New("the current repo has no resolved dependencies")
+	}
+	if c.SelectDeps {
+		if repos, err = pickDeps(repos, in); err != nil {
+			return err
+		}
+	}
+	queryScope.depRepos = repos
+	queryScope.excludeCurrent = c.OnlyDeps
+	if GlobalOpt.Verbose {
+		log.Printf("# Querying dependency repos %v in global store at %s", repos, srclib.StoreDir)
+	}
+	return nil
+}
+
+// depRepoURIs returns the sorted, distinct URIs of the repos that the
+// active repo's deps resolve to (excluding the active repo itself).
+func depRepoURIs() ([]string, error) {
+	deps, _, err := getDepResolutions(activeContext)
+	if err != nil {
+		return nil, err
+	}
+	currentRepo := activeContext.repo.URI()
+	seen := map[string]bool{}
+	var repos []string
+	for _, d := range deps {
+		if d.Target == nil || d.Target.ToRepoCloneURL == "" {
+			continue
+		}
+		uri, err := graph.TryMakeURI(d.Target.ToRepoCloneURL)
+		if err != nil || uri == "" || graph.URIEqual(uri, currentRepo) || seen[uri] {
+			continue
+		}
+		seen[uri] = true
+		repos = append(repos, uri)
+	}
+	sort.Strings(repos)
+	return repos, nil
+}
+
+// pickDeps lists repos and reads the user's selection of them from
+// in.
+func pickDeps(repos []string, in io.Reader) ([]string, error) {
+	colorable.Println("Dependencies of the current repo:")
+	for i, repo := range repos {
+		colorable.Printf("  %2d) %s\n", i+1, repo)
+	}
+	r := bufio.NewReader(in)
+	for {
+		colorable.Print("Include which in the query? (e.g., 1,3-5; 'all' or 'none') [all]: ")
+		line, err := r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, err
+		}
+		selected, err := parseSelection(line, len(repos))
+		if err != nil {
+			colorable.Println("Error:", err)
+			continue
+		}
+		picked := make([]string, len(selected))
+		for i, n := range selected {
+			picked[i] = repos[n]
+		}
+		return picked, nil
+	}
+}
+
+// parseSelection parses a comma- or space-separated list of 1-based
+// item numbers and ranges (such as "1,3-5") of items numbered 1 to n,
+// and returns the 0-based indexes of the selected items, in order.
+// An empty selection or "all" selects every item, and "none" selects
+// no items.
+func parseSelection(s string, n int) ([]int, error) {
+	s = strings.TrimSpace(s)
+	all := make([]int, n)
+	for i := range all {
+		all[i] = i
+	}
+	switch s {
+	case "", "all":
+		return all, nil
+	case "none":
+		return []int{}, nil
+	}
+
+	selected := make([]bool, n)
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		lo, hi := f, f
+		if i := strings.Index(f, "-"); i != -1 {
+			lo, hi = f[:i], f[i+1:]
+		}
+		start, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selection %q", f)
+		}
+		end, err := strconv.Atoi(hi)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selection %q", f)
+		}
+		if start < 1 || end > n || start > end {
+			return nil, fmt.Errorf("selection %q is out of range (1-%d)", f, n)
+		}
+		for i := start; i <= end; i++ {
+			selected[i-1] = true
+		}
+	}
+	var indexes []int
+	for _, i := range all {
+		if selected[i] {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes, nil
+}
+
+// depDefs returns the defs in queryScope's dependency repos (in the
+// global store) that are selected by c's filters.
+func depDefs(c StoreDefsCmd) ([]*graph.Def, error) {
+	if len(queryScope.depRepos) == 0 {
+		return nil, nil
+	}
+	return globalStoreDefs(c, queryScope.depRepos)
+}
+
+// globalStoreDefs returns the defs in repos (in the global store) that
+// are selected by c's filters.
+func globalStoreDefs(c StoreDefsCmd, repos []string) ([]*graph.Def, error) {
+	c.CommitID = ""
+	c.Repos = repos
+	s := store.NewFSMultiRepoStore(rwvfs.Walkable(rwvfs.ReadOnly(rwvfs.OS(srclib.StoreDir))), nil)
+	if c.Search != "" {
+		// get ranks and limits search results.
+		return c.get(s, c.filters())
+	}
+	return s.Defs(c.filters()...)
+}
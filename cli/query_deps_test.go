@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestParseSelection(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []int
+		wantErr bool
+	}{
+		{s: "", want: []int{0, 1, 2, 3, 4}},
+		{s: " all\n", want: []int{0, 1, 2, 3, 4}},
+		{s: "none", want: []int{}},
+		{s: "2", want: []int{1}},
+		{s: "5,1", want: []int{0, 4}},
+		{s: "1, 3-4", want: []int{0, 2, 3}},
+		{s: "2-3 3-5", want: []int{1, 2, 3, 4}},
+		{s: "0", wantErr: true},
+		{s: "6", wantErr: true},
+		{s: "4-2", wantErr: true},
+		{s: "x", wantErr: true},
+		{s: "1-", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := parseSelection(test.s, 5)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: got error %v, want error %v", test.s, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.s, got, test.want)
+		}
+	}
+}
+
+func TestDedupDefs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-query-dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(storeDir string) { srclib.StoreDir = storeDir }(srclib.StoreDir)
+	srclib.StoreDir = dir
+	defer func(orig commandContext) { activeContext = orig }(activeContext)
+	defer func(orig *dedupContext) { queryDedup = orig }(queryDedup)
+	defer func(orig QueryCmd) { queryCmd = orig }(queryCmd)
+	queryCmd = QueryCmd{Global: true}
+
+	// The dep's older commit was imported before its newer one.
+	const older, newer = "1111111111111111111111111111111111111111", "2222222222222222222222222222222222222222"
+	s, err := (&StoreCmd{Type: "MultiRepoStore", Root: dir, Backend: "fs"}).open(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mrs := s.(store.MultiRepoStoreImporter)
+	u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}
+	for i, commitID := range []string{older, newer} {
+		if err := mrs.Import("dep", commitID, u, graph.Output{Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, Name: "p", File: "f"}}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetImportTime(mrs, "dep", commitID, time.Unix(int64(1000+i), 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	def := func(commitID string) *graph.Def {
+		return &graph.Def{DefKey: graph.DefKey{Repo: "dep", CommitID: commitID, UnitType: "t", Unit: "u", Path: "p"}}
+	}
+
+	// Without a pinned commit, the newest copy is kept, whatever the
+	// order.
+	activeContext = commandContext{}
+	for _, defs := range [][]*graph.Def{{def(older), def(newer)}, {def(newer), def(older)}} {
+		if got := dedupDefs(defs); len(got) != 1 || got[0].CommitID != newer {
+			t.Errorf("got %v, want only the def at the newer commit", got)
+		}
+	}
+
+	// Revisions given as a commit ID prefix are resolved against the
+	// store, and the pinned commit is preferred over newer ones.
+	if got := resolveDepRev(queryDedup.global, "dep", older[:7]); got != older {
+		t.Errorf("got resolved rev %q, want %q", got, older)
+	}
+	if got := resolveDepRev(queryDedup.global, "dep", "master"); got != "" {
+		t.Errorf("got resolved branch %q, want none", got)
+	}
+	queryDedup.pinned = map[string]string{"dep": older}
+	if got := dedupDefs([]*graph.Def{def(newer), def(older)}); len(got) != 1 || got[0].CommitID != older {
+		t.Errorf("got %v, want only the def at the pinned commit", got)
+	}
+}
@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"encoding/json"
+	"io"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// ndjsonRecord is a line of the newline-delimited JSON output of
+// 'src query --ndjson'. Type is "def", "ref", "snippet", "error", or
+// (as the last line) "done".
+type ndjsonRecord struct {
+	Type string
+
+	// Def is the def (for "def" records) or the def that the
+	// snippet is of (for "snippet" records).
+	Def *graph.Def `json:",omitempty"`
+
+	// Ref is the ref (for "ref" records). Its Def* fields identify
+	// the def it refers to.
+	Ref *graph.Ref `json:",omitempty"`
+
+	// Snippet is the def's declaration or body (as SnippetType,
+	// "decl" or "body", indicates), as requested by the query's
+	// :format.
+	Snippet     string `json:",omitempty"`
+	SnippetType string `json:",omitempty"`
+
+	Error string `json:",omitempty"`
+
+	// Defs and Refs are the total number of defs and refs emitted
+	// (for "done" records).
+	Defs, Refs int `json:",omitempty"`
+}
+
+// ndjsonStream writes query results as newline-delimited JSON as soon
+// as they are found, so that wrappers can render them progressively.
+type ndjsonStream struct {
+	enc        *json.Encoder
+	defs, refs int
+}
+
+func newNDJSONStream(w io.Writer) *ndjsonStream {
+	return &ndjsonStream{enc: json.NewEncoder(w)}
+}
+
+// queryStream is the stream that query results are written to, or nil
+// if results are formatted as text after the query finishes.
+var queryStream *ndjsonStream
+
+func (s *ndjsonStream) emit(r *ndjsonRecord) error {
+	switch r.Type {
+	case "def":
+		s.defs++
+	case "ref":
+		s.refs++
+	}
+	return s.enc.Encode(r)
+}
+
+func (s *ndjsonStream) def(def *graph.Def) error {
+	if file := queryDisplayPath(def.Repo, def.UnitType, def.Unit, def.File); file != def.File {
+		def2 := *def
+		def2.File = file
+		def = &def2
+	}
+	return s.emit(&ndjsonRecord{Type: "def", Def: def})
+}
+
+func (s *ndjsonStream) ref(ref *graph.Ref) error {
+	if file := queryDisplayPath(ref.Repo, ref.UnitType, ref.Unit, ref.File); file != ref.File {
+		ref2 := *ref
+		ref2.File = file
+		ref = &ref2
+	}
+	return s.emit(&ndjsonRecord{Type: "ref", Ref: ref})
+}
+
+func (s *ndjsonStream) snippet(def *graph.Def, typ, snippet string) error {
+	return s.emit(&ndjsonRecord{Type: "snippet", Def: def, Snippet: snippet, SnippetType: typ})
+}
+
+func (s *ndjsonStream) emitError(err error) error {
+	return s.emit(&ndjsonRecord{Type: "error", Error: err.Error()})
+}
+
+// done writes the final record, which holds the number of defs and
+// refs that were written.
+func (s *ndjsonStream) done() error {
+	return s.emit(&ndjsonRecord{Type: "done", Defs: s.defs, Refs: s.refs})
+}
+
+// streamQuery evaluates input and writes its results to s. Defs and
+// refs are written as soon as they are found; the snippets that the
+// query's :format requests (which require reading source files) are
+// written after all of them.
+func streamQuery(input string, s *ndjsonStream) error {
+	objs, f, _, err := evalObjects(input)
+	if err != nil {
+		s.emitError(err)
+		return err
+	}
+	if f.showDefDecl || f.showDefBody {
+		var defs []*graph.Def
+		switch o := objs.(type) {
+		case []*graph.Def:
+			defs = o
+		case []defRefs:
+			for _, dr := range o {
+				defs = append(defs, dr.def)
+			}
+		}
+		for _, def := range defs {
+			if f.showDefDecl {
+				decl, err := defSignature(def)
+				if err != nil {
+					return err
+				}
+				if err := s.snippet(def, "decl", decl); err != nil {
+					return err
+				}
+			}
+			if f.showDefBody {
+				if err := s.snippet(def, "body", getFileSegment(def.File, def.DefStart, def.DefEnd, false)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return s.done()
+}
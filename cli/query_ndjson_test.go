@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestNDJSONStream(t *testing.T) {
+	var buf bytes.Buffer
+	s := newNDJSONStream(&buf)
+	def := &graph.Def{DefKey: graph.DefKey{Path: "p"}, Name: "n"}
+	for _, err := range []error{
+		s.def(def),
+		s.ref(&graph.Ref{DefPath: "p", File: "f"}),
+		s.ref(&graph.Ref{DefPath: "p", File: "g"}),
+		s.snippet(def, "decl", "func n()"),
+		s.done(),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 5 {
+		t.Fatalf("got %d lines, want 5:\n%s", len(lines), buf.Bytes())
+	}
+	for i, prefix := range []string{`{"Type":"def","Def":{`, `{"Type":"ref","Ref":{`, `{"Type":"ref","Ref":{`, `{"Type":"snippet","Def":{`} {
+		if !bytes.HasPrefix(lines[i], []byte(prefix)) {
+			t.Errorf("line %d: got %s, want prefix %s", i, lines[i], prefix)
+		}
+	}
+	if !bytes.Contains(lines[3], []byte(`"Snippet":"func n()","SnippetType":"decl"`)) {
+		t.Errorf("got snippet line %s", lines[3])
+	}
+	if got, want := string(lines[4]), `{"Type":"done","Defs":1,"Refs":2}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
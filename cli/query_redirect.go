@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// splitRedirect splits a REPL input line of the form "QUERY > FILE"
+// or "QUERY | COMMAND" at the first '>' or '|' that is not quoted. If
+// there is none, op is 0 and query is line.
+func splitRedirect(line string) (query string, op byte, target string) {
+	var quote rune
+	escaped := false
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if c == '\\' {
+				escaped = true
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>' || c == '|':
+			return strings.TrimSpace(line[:i]), byte(c), strings.TrimSpace(line[i+1:])
+		}
+	}
+	return line, 0, ""
+}
+
+// evalRedirect evaluates query and writes its results as JSON to
+// the file target (if op is '>') or to the stdin of the shell command
+// target (if op is '|').
+func evalRedirect(query string, op byte, target string) error {
+	if target == "" {
+		if op == '>' {
+			return errors.New("no file to redirect output to (usage: QUERY > FILE)")
+		}
+		return errors.New("no command to pipe output to (usage: QUERY | COMMAND)")
+	}
+	data, n, err := evalJSON(query)
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case '>':
+		if err := ioutil.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+		colorable.Printf("Wrote %d results to %s\n", n, target)
+	case '|':
+		cmd := exec.Command("sh", "-c", target)
+		cmd.Stdin = bytes.NewReader(data)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("command %q failed: %s", target, err)
+		}
+	}
+	return nil
+}
+
+// evalJSON evaluates query and returns its results (and the number of
+// them) as JSON. Defs are output as graph.Def objects. If refs are
+// displayed, each result instead is an object with Def and Refs
+// fields.
+func evalJSON(query string) ([]byte, int, error) {
+	objs, _, _, err := evalObjects(query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var n int
+	switch o := objs.(type) {
+	case []*graph.Def:
+		if o == nil {
+			objs = []*graph.Def{}
+		}
+		n = len(o)
+	case []defRefs:
+		type defWithRefs struct {
+			Def  *graph.Def
+			Refs []*graph.Ref
+		}
+		out := make([]defWithRefs, len(o))
+		for i, dr := range o {
+			out[i] = defWithRefs{Def: dr.def, Refs: withoutDefRefs(dr.refs)}
+		}
+		objs = out
+		n = len(o)
+	default:
+		return nil, 0, fmt.Errorf("%q has no results to output", query)
+	}
+
+	data, err := json.MarshalIndent(objs, "", "  ")
+	if err != nil {
+		return nil, 0, err
+	}
+	return append(data, '\n'), n, nil
+}
@@ -0,0 +1,25 @@
+package cli
+
+import "testing"
+
+func TestSplitRedirect(t *testing.T) {
+	tests := []struct {
+		line   string
+		query  string
+		op     byte
+		target string
+	}{
+		{"Hello", "Hello", 0, ""},
+		{"Hello :select defs, refs > out.json", "Hello :select defs, refs", '>', "out.json"},
+		{"Hello | jq '.[] | .Path'", "Hello", '|', "jq '.[] | .Path'"},
+		{`"a > b" > out.json`, `"a > b"`, '>', "out.json"},
+		{`'it\'s | x' | wc -l`, `'it\'s | x'`, '|', "wc -l"},
+		{"Hello >", "Hello", '>', ""},
+	}
+	for _, test := range tests {
+		query, op, target := splitRedirect(test.line)
+		if query != test.query || op != test.op || target != test.target {
+			t.Errorf("%q: got (%q, %q, %q), want (%q, %q, %q)", test.line, query, op, target, test.query, test.op, test.target)
+		}
+	}
+}
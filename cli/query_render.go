@@ -0,0 +1,176 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/doc"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// A queryRenderer renders the parts of the output of 'src query', so
+// that it can be shown in a terminal or embedded in a wiki page, pull
+// request comment, chat message, etc.
+type queryRenderer interface {
+	// heading renders the heading of a section (e.g., "def" or
+	// "refs").
+	heading(name string) string
+
+	// code renders a block of code (e.g., a def's signature).
+	code(s string) string
+
+	// text renders a line of plain text.
+	text(s string) string
+
+	// doc renders a def's documentation.
+	doc(d *graph.DefDoc) string
+
+	// snippet renders lines of file (as displayed), the first of
+	// which is line number startLine.
+	snippet(file string, startLine int, lines []string) string
+}
+
+// queryRenderers are the valid values of the query command's --render
+// option.
+var queryRenderers = map[string]queryRenderer{
+	"terminal": terminalRenderer{},
+	"markdown": markdownRenderer{},
+	"html":     htmlRenderer{},
+}
+
+// activeRenderer returns the renderer chosen by the query command's
+// --render option.
+func activeRenderer() queryRenderer {
+	if r, ok := queryRenderers[queryCmd.Render]; ok {
+		return r
+	}
+	return terminalRenderer{}
+}
+
+// fileSegmentLines returns the full lines of file that the byte range
+// [start, end) is on, and the line number of the first. It returns
+// false if the file can't be read.
+func fileSegmentLines(file string, start, end uint32) (startLine int, lines []string, ok bool) {
+	f, err := defaultFileCache.readFile(file)
+	if err != nil {
+		return 0, nil, false
+	}
+	startLine, lines = fileSegmentLinesOf(f, start, end)
+	return startLine, lines, true
+}
+
+// fileSegmentLinesOf is like fileSegmentLines, but takes the file's
+// contents.
+func fileSegmentLinesOf(f []byte, start, end uint32) (startLine int, lines []string) {
+	startLine = bytes.Count(f[:start], []byte{'\n'}) + 1
+	// Roll 'start' back and 'end' forward to the nearest
+	// newline.
+	for ; start > 0 && f[start-1] != '\n'; start-- {
+	}
+	for ; end < uint32(len(f)) && f[end] != '\n'; end++ {
+	}
+	for _, line := range bytes.Split(f[start:end], []byte{'\n'}) {
+		lines = append(lines, string(line))
+	}
+	return startLine, lines
+}
+
+// renderFileSegment renders the lines of file (printed as display)
+// that the byte range [start, end) is on, or returns the empty string
+// if the file can't be read.
+func renderFileSegment(r queryRenderer, file, display string, start, end uint32) string {
+	startLine, lines, ok := fileSegmentLines(file, start, end)
+	if !ok {
+		return ""
+	}
+	return r.snippet(display, startLine, lines)
+}
+
+// terminalRenderer renders plain (or, for docs, ANSI-styled) text,
+// with snippets formatted like grep output.
+type terminalRenderer struct{}
+
+func (terminalRenderer) heading(name string) string {
+	return "---------- " + name + " ----------"
+}
+
+func (terminalRenderer) code(s string) string { return s }
+func (terminalRenderer) text(s string) string { return s }
+
+func (terminalRenderer) doc(d *graph.DefDoc) string { return renderDoc(d) }
+
+func (terminalRenderer) snippet(file string, startLine int, lines []string) string {
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		marker := "-"
+		if i == 0 {
+			marker = ":"
+		}
+		out[i] = fmt.Sprintf("%s:%d%s%s", file, startLine+i, marker, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// markdownRenderer renders Markdown, with code in fenced code blocks.
+type markdownRenderer struct{}
+
+func (markdownRenderer) heading(name string) string { return "#### " + name + "\n" }
+
+func (markdownRenderer) code(s string) string { return mdFence(s) }
+
+func (markdownRenderer) text(s string) string { return s + "  " }
+
+func (markdownRenderer) doc(d *graph.DefDoc) string {
+	if doc.FormatForMIMEType(d.Format) == doc.Markdown {
+		return d.Data + "\n"
+	}
+	out, err := doc.ToTerminal(doc.FormatForMIMEType(d.Format), []byte(d.Data), doc.TerminalOptions{})
+	if err != nil && GlobalOpt.Verbose {
+		log.Printf("Warning: rendering %s doc: %s", d.Format, err)
+	}
+	return out + "\n"
+}
+
+func (markdownRenderer) snippet(file string, startLine int, lines []string) string {
+	return fmt.Sprintf("%s\n%s", mdCode(fmt.Sprintf("%s:%d", file, startLine)), mdFence(strings.Join(lines, "\n")))
+}
+
+// mdFence returns s in a fenced code block, using a fence that doesn't
+// occur in s.
+func mdFence(s string) string {
+	fence := "```"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	return fence + "\n" + s + "\n" + fence + "\n"
+}
+
+// htmlRenderer renders an HTML fragment.
+type htmlRenderer struct{}
+
+func (htmlRenderer) heading(name string) string {
+	return "<h4>" + html.EscapeString(name) + "</h4>"
+}
+
+func (htmlRenderer) code(s string) string {
+	return "<pre><code>" + html.EscapeString(s) + "</code></pre>"
+}
+
+func (htmlRenderer) text(s string) string {
+	return "<p>" + html.EscapeString(s) + "</p>"
+}
+
+func (htmlRenderer) doc(d *graph.DefDoc) string {
+	out, err := doc.ToHTML(doc.FormatForMIMEType(d.Format), []byte(d.Data))
+	if err != nil && GlobalOpt.Verbose {
+		log.Printf("Warning: rendering %s doc: %s", d.Format, err)
+	}
+	return `<div class="doc">` + string(out) + "</div>"
+}
+
+func (htmlRenderer) snippet(file string, startLine int, lines []string) string {
+	return fmt.Sprintf(`<div class="snippet"><div class="location">%s:%d</div><pre><code>%s</code></pre></div>`, html.EscapeString(file), startLine, html.EscapeString(strings.Join(lines, "\n")))
+}
@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"testing"
+)
+
+func TestQueryRenderers_snippet(t *testing.T) {
+	lines := []string{"func f() {", "\treturn `x` < 1", "}"}
+	tests := map[string]string{
+		"terminal": "a.go:3:func f() {\na.go:4-\treturn `x` < 1\na.go:5-}",
+		"markdown": "<code>a.go:3</code>\n```\nfunc f() {\n\treturn `x` < 1\n}\n```\n",
+		"html":     `<div class="snippet"><div class="location">a.go:3</div><pre><code>func f() {` + "\n\treturn `x` &lt; 1\n}</code></pre></div>",
+	}
+	for name, want := range tests {
+		if got := queryRenderers[name].snippet("a.go", 3, lines); got != want {
+			t.Errorf("%s: got snippet %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestMDFence(t *testing.T) {
+	if got, want := mdFence("a ``` b"), "````\na ``` b\n````\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
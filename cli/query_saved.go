@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib"
+)
+
+// savedQueriesFile is the file that holds queries saved with `src
+// query --save`. It is a JSON object mapping query names to queries.
+var savedQueriesFile = filepath.Join(filepath.SplitList(srclib.Path)[0], ".srclibqueries")
+
+// readSavedQueries reads the saved queries. If there are none, it
+// returns an empty map.
+func readSavedQueries() (map[string]string, error) {
+	queries := map[string]string{}
+	data, err := ioutil.ReadFile(savedQueriesFile)
+	if os.IsNotExist(err) {
+		return queries, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &queries); err != nil {
+		return nil, fmt.Errorf("reading saved queries from %s: %s", savedQueriesFile, err)
+	}
+	return queries, nil
+}
+
+// saveQuery saves query under name, replacing any query previously
+// saved under that name.
+func saveQuery(name, query string) error {
+	if name == "" || strings.ContainsAny(name, " \t\n") {
+		return fmt.Errorf("invalid saved query name %q (it must be nonempty and contain no whitespace)", name)
+	}
+	queries, err := readSavedQueries()
+	if err != nil {
+		return err
+	}
+	queries[name] = query
+	data, err := json.MarshalIndent(queries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(savedQueriesFile), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(savedQueriesFile, data, 0600)
+}
+
+// savedQuery returns the query saved under name.
+func savedQuery(name string) (string, error) {
+	queries, err := readSavedQueries()
+	if err != nil {
+		return "", err
+	}
+	query, ok := queries[name]
+	if !ok {
+		names := make([]string, 0, len(queries))
+		for name := range queries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return "", fmt.Errorf("no query saved as %q (there are no saved queries; save one with --save)", name)
+		}
+		return "", fmt.Errorf("no query saved as %q (saved queries: %s)", name, strings.Join(names, ", "))
+	}
+	return query, nil
+}
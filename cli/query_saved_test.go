@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSavedQueries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-saved-queries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(orig string) { savedQueriesFile = orig }(savedQueriesFile)
+	savedQueriesFile = filepath.Join(dir, "sub", ".srclibqueries")
+
+	if _, err := savedQuery("a"); err == nil {
+		t.Error("got no error running a query that wasn't saved")
+	}
+	if err := saveQuery("a", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveQuery("b", ":kind func bar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveQuery("a", "baz"); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveQuery("c d", "qux"); err == nil {
+		t.Error("got no error saving a query with whitespace in its name")
+	}
+
+	for name, want := range map[string]string{"a": "baz", "b": ":kind func bar"} {
+		got, err := savedQuery(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
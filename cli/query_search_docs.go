@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+)
+
+// querySearchDocs is whether name queries and completions in the
+// query REPL also match def doc titles (the first sentence of each
+// def's docs). It is set with the ":search-docs" command and lasts
+// for the session.
+var querySearchDocs bool
+
+// searchDocsCommand evaluates the ":search-docs" command with args.
+// With no args, it reports the setting; otherwise, args must be "on"
+// or "off".
+func searchDocsCommand(args []tokValue) (string, error) {
+	var fields []string
+	for _, arg := range args {
+		fields = append(fields, strings.Fields(string(arg))...)
+	}
+	if len(fields) > 1 || (len(fields) == 1 && fields[0] != "on" && fields[0] != "off") {
+		return "", fmt.Errorf("usage: :search-docs on|off")
+	}
+	if len(fields) == 1 {
+		querySearchDocs = fields[0] == "on"
+	}
+	if querySearchDocs {
+		return "search-docs: on (names also match the first sentence of defs' docs)", nil
+	}
+	return "search-docs: off", nil
+}
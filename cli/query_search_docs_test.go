@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchDocsCommand(t *testing.T) {
+	defer func(orig bool) { querySearchDocs = orig }(querySearchDocs)
+	querySearchDocs = false
+
+	if _, _, _, err := evalObjects(":search-docs on"); err != nil {
+		t.Fatal(err)
+	}
+	if !querySearchDocs {
+		t.Error("after :search-docs on, got querySearchDocs == false")
+	}
+	if _, _, output, err := evalObjects(":search-docs"); err != nil {
+		t.Fatal(err)
+	} else if output == "" {
+		t.Error("got no output for :search-docs")
+	}
+	if _, _, _, err := evalObjects(":search-docs off"); err != nil {
+		t.Fatal(err)
+	}
+	if querySearchDocs {
+		t.Error("after :search-docs off, got querySearchDocs == true")
+	}
+	if _, _, _, err := evalObjects(":search-docs maybe"); err == nil {
+		t.Error("got no error for :search-docs maybe")
+	}
+
+	if _, completions, _ := wordCompleter(":search-docs o", len(":search-docs o")); !reflect.DeepEqual(completions, []string{"on", "off"}) {
+		t.Errorf("got completions %v, want [on off]", completions)
+	}
+}
@@ -0,0 +1,162 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib"
+)
+
+// showSettingsFile is the file that holds the section display
+// settings set with the ":show" command. It is a JSON object mapping
+// repo URIs to showSettings.
+var showSettingsFile = filepath.Join(filepath.SplitList(srclib.Path)[0], ".srclibshow")
+
+// showSections describes the sections of def output that ":show" can
+// turn on or off.
+var showSections = map[string]string{
+	"decl":    "the def's declaration",
+	"src":     "the def's source code",
+	"docs":    "the def's docs",
+	"authors": "the def's authors (git only)",
+}
+
+// showSettings maps sections (see showSections) to whether they are
+// shown. Sections that aren't in the map are shown as usual.
+type showSettings map[string]bool
+
+// apply turns f's sections on or off according to s. The decl and src
+// settings only apply if formatGiven is false (i.e., the query didn't
+// use ":format"). Turning docs or authors off only hides them when they
+// weren't requested (with ":select docs", ":doc", or ":authors").
+func (s showSettings) apply(f *format, formatGiven bool) {
+	if on, present := s["decl"]; present && !formatGiven {
+		f.showDefDecl = on
+	}
+	if on, present := s["src"]; present && !formatGiven {
+		f.showDefBody = on
+	}
+	if s["docs"] {
+		f.showDocs = true
+	}
+	if s["authors"] {
+		f.showAuthors = true
+	}
+}
+
+// showSettingsKey returns the key of the active repo's settings in
+// the settings file.
+func showSettingsKey() string {
+	if activeContext.repo == nil {
+		return "(global)"
+	}
+	if uri := activeContext.repo.URI(); uri != "" {
+		return uri
+	}
+	return activeContext.repo.RootDir
+}
+
+// readAllShowSettings reads the settings of all repos. If there are
+// none, it returns an empty map.
+func readAllShowSettings() (map[string]showSettings, error) {
+	all := map[string]showSettings{}
+	data, err := ioutil.ReadFile(showSettingsFile)
+	if os.IsNotExist(err) {
+		return all, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("reading display settings from %s: %s", showSettingsFile, err)
+	}
+	return all, nil
+}
+
+// activeShowSettings returns the active repo's settings.
+func activeShowSettings() showSettings {
+	all, err := readAllShowSettings()
+	if err != nil {
+		if GlobalOpt.Verbose {
+			log.Printf("Warning: %s", err)
+		}
+		return nil
+	}
+	return all[showSettingsKey()]
+}
+
+// setShowSetting turns section on or off for the active repo, and
+// saves the setting.
+func setShowSetting(section string, on bool) error {
+	all, err := readAllShowSettings()
+	if err != nil {
+		return err
+	}
+	key := showSettingsKey()
+	if all[key] == nil {
+		all[key] = showSettings{}
+	}
+	all[key][section] = on
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(showSettingsFile), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(showSettingsFile, data, 0600)
+}
+
+// showCommand evaluates the ":show" command with args. With no args,
+// it lists the sections and their settings; otherwise, args must be a
+// section and "on" or "off".
+func showCommand(args []tokValue) (string, error) {
+	var fields []string
+	for _, arg := range args {
+		fields = append(fields, strings.Fields(string(arg))...)
+	}
+	if len(fields) == 0 {
+		return formatShowSettings(activeShowSettings()), nil
+	}
+
+	if len(fields) != 2 || (fields[1] != "on" && fields[1] != "off") {
+		return "", fmt.Errorf("usage: :show <section> on|off")
+	}
+	section := strings.ToLower(fields[0])
+	if _, valid := showSections[section]; !valid {
+		return "", fmt.Errorf("unknown section %q (valid sections: %s)", section, strings.Join(sortedShowSections(), ", "))
+	}
+	if err := setShowSetting(section, fields[1] == "on"); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s: %s (saved for %s)", section, fields[1], showSettingsKey()), nil
+}
+
+// formatShowSettings lists the sections and their settings in s.
+func formatShowSettings(s showSettings) string {
+	var lines []string
+	for _, section := range sortedShowSections() {
+		setting := "default"
+		if on, present := s[section]; present && on {
+			setting = "on"
+		} else if present {
+			setting = "off"
+		}
+		lines = append(lines, fmt.Sprintf("%-8s %-8s %s", section, setting, showSections[section]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func sortedShowSections() []string {
+	sections := make([]string, 0, len(showSections))
+	for section := range showSections {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	return sections
+}
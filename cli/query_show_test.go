@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShowCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-query-show")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(orig string) { showSettingsFile = orig }(showSettingsFile)
+	showSettingsFile = filepath.Join(dir, ".srclibshow")
+	defer func(orig commandContext) { activeContext = orig }(activeContext)
+	activeContext = commandContext{repo: &Repo{CloneURL: "https://example.com/r"}}
+
+	for _, args := range [][]tokValue{{"src off"}, {"docs", "on"}} {
+		if _, err := showCommand(args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]tokValue{{"stats off"}, {"docs"}, {"docs maybe"}} {
+		if _, err := showCommand(args); err == nil {
+			t.Errorf("%v: got no error", args)
+		}
+	}
+
+	f := format{showDefs: true, showDefDecl: true, showDefBody: true}
+	activeShowSettings().apply(&f, false)
+	if want := (format{showDefs: true, showDefDecl: true, showDocs: true}); f != want {
+		t.Errorf("got format %+v, want %+v", f, want)
+	}
+	f = format{showDefs: true, showDefBody: true}
+	activeShowSettings().apply(&f, true)
+	if want := (format{showDefs: true, showDefBody: true, showDocs: true}); f != want {
+		t.Errorf("with :format, got format %+v, want %+v", f, want)
+	}
+
+	// Settings are per repo.
+	activeContext = commandContext{repo: &Repo{CloneURL: "https://example.com/other"}}
+	if s := activeShowSettings(); len(s) != 0 {
+		t.Errorf("got settings %v for other repo, want none", s)
+	}
+}
@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+// maxSuggestions is the maximum number of "did you mean" suggestions
+// shown for a query with no results.
+const maxSuggestions = 5
+
+// suggestNames returns up to n def names in corpus that are close
+// (by edit distance) to query, nearest first. Because name queries
+// match def name prefixes, a name is also close if its prefix of
+// query's length is. Names at the same distance are ordered by how
+// many defs have them (most first), then alphabetically. Queries
+// shorter than 3 characters are too ambiguous to get suggestions.
+func suggestNames(query string, corpus store.DefNameFreqs, n int) []string {
+	if len(query) < 3 {
+		return nil
+	}
+	query = strings.ToLower(query)
+	maxDist := len(query) / 3
+	if maxDist < 1 {
+		maxDist = 1
+	}
+
+	var suggestions []nameSuggestion
+	for name, freq := range corpus {
+		dist := editDistance(query, name)
+		if len(name) > len(query) {
+			if d := editDistance(query, name[:len(query)]); d < dist {
+				dist = d
+			}
+		}
+		if dist > 0 && dist <= maxDist {
+			suggestions = append(suggestions, nameSuggestion{name, dist, freq})
+		}
+	}
+	sort.Sort(nameSuggestions(suggestions))
+
+	if len(suggestions) > n {
+		suggestions = suggestions[:n]
+	}
+	if len(suggestions) == 0 {
+		return nil
+	}
+	names := make([]string, len(suggestions))
+	for i, s := range suggestions {
+		names[i] = s.name
+	}
+	return names
+}
+
+type nameSuggestion struct {
+	name       string
+	dist, freq int
+}
+
+type nameSuggestions []nameSuggestion
+
+func (v nameSuggestions) Len() int      { return len(v) }
+func (v nameSuggestions) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v nameSuggestions) Less(i, j int) bool {
+	if v[i].dist != v[j].dist {
+		return v[i].dist < v[j].dist
+	}
+	if v[i].freq != v[j].freq {
+		return v[i].freq > v[j].freq
+	}
+	return v[i].name < v[j].name
+}
+
+// editDistance returns the Levenshtein distance between a and b
+// (compared bytewise).
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// noResultsText returns the text to display for a query for names
+// that returned no defs, including "did you mean" suggestions from
+// the active commit's def names (if any are close) and the commits
+// that removed defs with those names (if they were removed recently).
+func noResultsText(names []tokValue) string {
+	if removed := removedDefsText(names); removed != "" {
+		return "No results. " + removed
+	}
+	corpus := activeNameFreqs()
+	var suggestions []string
+	seen := map[string]bool{}
+	for _, name := range names {
+		for _, s := range suggestNames(string(name), corpus, maxSuggestions) {
+			if !seen[s] {
+				seen[s] = true
+				suggestions = append(suggestions, s)
+			}
+		}
+	}
+	if len(suggestions) == 0 {
+		return "No results."
+	}
+	return "No results. Did you mean " + strings.Join(suggestions, ", ") + "?"
+}
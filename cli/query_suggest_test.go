@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"handler", "hnadler", 2},
+		{"handler", "handler", 0},
+	}
+	for _, test := range tests {
+		if got := editDistance(test.a, test.b); got != test.want {
+			t.Errorf("editDistance(%q, %q): got %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestSuggestNames(t *testing.T) {
+	corpus := store.DefNameFreqs{
+		"handler":     3,
+		"handlerfunc": 5,
+		"handle":      1,
+		"newserver":   2,
+		"server":      4,
+		"x":           1,
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"Hnadler", []string{"handlerfunc", "handler"}},
+		{"servr", []string{"server"}},
+		{"NewServe", nil},
+		{"zzz", nil},
+		{"y", nil},
+	}
+	for _, test := range tests {
+		if got := suggestNames(test.query, corpus, maxSuggestions); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.query, got, test.want)
+		}
+	}
+}
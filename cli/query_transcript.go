@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// A queryTranscript records the queries of a query REPL session and
+// their results in a Markdown file, so that the session can be shared
+// as a readable document.
+type queryTranscript struct {
+	file string
+	f    *os.File
+	w    *bufio.Writer
+}
+
+// activeTranscript is the transcript started with ":transcript start",
+// or nil if none is being recorded. It lasts until ":transcript stop"
+// or the end of the session.
+var activeTranscript *queryTranscript
+
+// transcriptCommand evaluates the ":transcript" command with args.
+// With no args, it reports whether a transcript is being recorded;
+// otherwise, args must be "start FILE" or "stop".
+func transcriptCommand(args []tokValue) (string, error) {
+	var fields []string
+	for _, arg := range args {
+		fields = append(fields, strings.Fields(string(arg))...)
+	}
+	usage := fmt.Errorf("usage: :transcript start FILE|stop")
+	switch {
+	case len(fields) == 0:
+		if activeTranscript == nil {
+			return "transcript: off", nil
+		}
+		return fmt.Sprintf("transcript: recording to %s", activeTranscript.file), nil
+
+	case fields[0] == "start" && len(fields) == 2:
+		if activeTranscript != nil {
+			return "", fmt.Errorf("already recording a transcript to %s (stop it with :transcript stop)", activeTranscript.file)
+		}
+		t, err := startTranscript(fields[1])
+		if err != nil {
+			return "", err
+		}
+		activeTranscript = t
+		return fmt.Sprintf("transcript: recording queries and results to %s", t.file), nil
+
+	case fields[0] == "stop" && len(fields) == 1:
+		if activeTranscript == nil {
+			return "", fmt.Errorf("no transcript is being recorded")
+		}
+		file := activeTranscript.file
+		err := stopTranscript()
+		return fmt.Sprintf("transcript: wrote %s", file), err
+	}
+	return "", usage
+}
+
+// startTranscript creates file and writes the transcript's title to
+// it.
+func startTranscript(file string) (*queryTranscript, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	t := &queryTranscript{file: file, f: f, w: bufio.NewWriter(f)}
+	fmt.Fprintln(t.w, "# src query transcript")
+	fmt.Fprintln(t.w)
+	if repo := activeContext.repo; repo != nil {
+		fmt.Fprintf(t.w, "Repository `%s` at commit `%s`, ", repo.URI(), repo.CommitID)
+	}
+	fmt.Fprintf(t.w, "recorded %s.\n\n", time.Now().Format(time.RFC1123))
+	return t, t.w.Flush()
+}
+
+// stopTranscript finishes and closes the active transcript (if any).
+func stopTranscript() error {
+	t := activeTranscript
+	if t == nil {
+		return nil
+	}
+	activeTranscript = nil
+	err := t.w.Flush()
+	if err2 := t.f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// record adds input (a line entered in the REPL) and its result to
+// the transcript. Results that evalObjects returns as objects are
+// rendered as Markdown; other output (such as help text) is recorded
+// as a code block, because it is formatted for a terminal.
+func (t *queryTranscript) record(input string, objs interface{}, f format, output string, err error) error {
+	fmt.Fprintf(t.w, "## %s\n\n", mdCode(input))
+	if objs != nil && err == nil {
+		defer func(orig string) { queryCmd.Render = orig }(queryCmd.Render)
+		queryCmd.Render = "markdown"
+		output, err = renderEvaluated(input, objs, f, output, err)
+		if !isEmptyResult(objs) {
+			fmt.Fprintln(t.w, strings.TrimSuffix(output, "\n"))
+			fmt.Fprintln(t.w)
+			return t.w.Flush()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(t.w, "**Error:** %s\n\n", err)
+	}
+	if output = strings.TrimSpace(output); output != "" {
+		fmt.Fprintln(t.w, mdFence(output))
+	}
+	return t.w.Flush()
+}
+
+// recordRedirect adds input (a line that redirected a query's results)
+// to the transcript, noting where the results went instead.
+func (t *queryTranscript) recordRedirect(query string, op byte, target string, err error) error {
+	fmt.Fprintf(t.w, "## %s\n\n", mdCode(query))
+	if err != nil {
+		fmt.Fprintf(t.w, "**Error:** %s\n\n", err)
+	} else if op == '>' {
+		fmt.Fprintf(t.w, "Results written to %s.\n\n", mdCode(target))
+	} else {
+		fmt.Fprintf(t.w, "Results piped to %s.\n\n", mdCode(target))
+	}
+	return t.w.Flush()
+}
+
+// isTranscriptCommand returns whether input is a ":transcript"
+// command, which isn't itself recorded.
+func isTranscriptCommand(input string) bool {
+	i, err := parse(input)
+	return err == nil && i.get(keyTranscript) != nil
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTranscriptCommand(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "srclib-transcript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer stopTranscript()
+	file := filepath.Join(tmpDir, "session.md")
+
+	if _, _, _, err := evalObjects(":transcript start " + file); err != nil {
+		t.Fatal(err)
+	}
+	if activeTranscript == nil {
+		t.Fatal("after :transcript start, got no active transcript")
+	}
+	if _, _, _, err := evalObjects(":transcript start " + file); err == nil {
+		t.Error("got no error starting a second transcript")
+	}
+	if !isTranscriptCommand(":transcript stop") || isTranscriptCommand("foo") {
+		t.Error("isTranscriptCommand: got wrong result")
+	}
+
+	if err := activeTranscript.record(":help", nil, format{}, "some help\n", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := activeTranscript.record("foo", nil, format{}, "", errors.New("oops")); err != nil {
+		t.Fatal(err)
+	}
+	if err := activeTranscript.recordRedirect("bar", '>', "out.json", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := evalObjects(":transcript stop"); err != nil {
+		t.Fatal(err)
+	}
+	if activeTranscript != nil {
+		t.Error("after :transcript stop, got an active transcript")
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# src query transcript\n",
+		"## <code>:help</code>\n\n```\nsome help\n```\n",
+		"## <code>foo</code>\n\n**Error:** oops\n",
+		"## <code>bar</code>\n\nResults written to <code>out.json</code>.\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("got transcript %q, want it to contain %q", data, want)
+		}
+	}
+
+	if _, _, _, err := evalObjects(":transcript pause"); err == nil {
+		t.Error("got no error for :transcript pause")
+	}
+}
@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// formatTree formats query results (either []*graph.Def or []defRefs)
+// as a tree grouped by repo, source unit, and file, with the number
+// of defs in each group. If refs are shown, each def is followed by
+// its number of refs.
+func formatTree(objs interface{}) string {
+	var defs []*graph.Def
+	refCounts := map[*graph.Def]int{}
+	switch o := objs.(type) {
+	case []*graph.Def:
+		defs = o
+	case []defRefs:
+		for _, dr := range o {
+			defs = append(defs, dr.def)
+			refCounts[dr.def] = len(withoutDefRefs(dr.refs))
+		}
+	}
+
+	root := &defTree{}
+	for _, def := range defs {
+		repo := def.Repo
+		if repo == "" && activeContext.repo != nil {
+			repo = activeContext.repo.URI()
+		}
+		if repo == "" {
+			repo = "(unknown repo)"
+		}
+		root.add([]string{repo, def.UnitType + " " + def.Unit, queryDisplayPath(def.Repo, def.UnitType, def.Unit, def.File)}, def)
+	}
+
+	var buf bytes.Buffer
+	root.write(&buf, 0, refCounts)
+	return buf.String()
+}
+
+// defTree is a node in the tree of defs displayed by formatTree.
+type defTree struct {
+	n        int // number of defs in the subtree
+	children map[string]*defTree
+	defs     []*graph.Def // only set on leaves
+}
+
+func (t *defTree) add(path []string, def *graph.Def) {
+	t.n++
+	if len(path) == 0 {
+		t.defs = append(t.defs, def)
+		return
+	}
+	if t.children == nil {
+		t.children = map[string]*defTree{}
+	}
+	child, present := t.children[path[0]]
+	if !present {
+		child = &defTree{}
+		t.children[path[0]] = child
+	}
+	child.add(path[1:], def)
+}
+
+func (t *defTree) write(buf *bytes.Buffer, depth int, refCounts map[*graph.Def]int) {
+	indent := strings.Repeat("  ", depth)
+
+	names := make([]string, 0, len(t.children))
+	for name := range t.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child := t.children[name]
+		fmt.Fprintf(buf, "%s%s (%s)\n", indent, name, pluralize(child.n, "def"))
+		child.write(buf, depth+1, refCounts)
+	}
+
+	sort.Sort(defsByStart(t.defs))
+	for _, def := range t.defs {
+		fmt.Fprintf(buf, "%s%s (%s)", indent, def.Name, def.Kind)
+		if n, present := refCounts[def]; present {
+			fmt.Fprintf(buf, " - %s", pluralize(n, "ref"))
+		}
+		buf.WriteByte('\n')
+	}
+}
+
+func pluralize(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+type defsByStart []*graph.Def
+
+func (v defsByStart) Len() int           { return len(v) }
+func (v defsByStart) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v defsByStart) Less(i, j int) bool { return v[i].DefStart < v[j].DefStart }
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestFormatTree(t *testing.T) {
+	def := func(repo, unit, file, name string, start uint32) *graph.Def {
+		return &graph.Def{
+			DefKey:   graph.DefKey{Repo: repo, UnitType: "GoPackage", Unit: unit, Path: name},
+			Name:     name,
+			Kind:     "func",
+			File:     file,
+			DefStart: start,
+		}
+	}
+	a := def("r2", "u", "f.go", "A", 10)
+	b := def("r1", "u2", "g.go", "B", 0)
+	c := def("r1", "u1", "f.go", "C", 20)
+	d := def("r1", "u1", "f.go", "D", 5)
+
+	want := `r1 (3 defs)
+  GoPackage u1 (2 defs)
+    f.go (2 defs)
+      D (func) - 0 refs
+      C (func) - 2 refs
+  GoPackage u2 (1 def)
+    g.go (1 def)
+      B (func) - 0 refs
+r2 (1 def)
+  GoPackage u (1 def)
+    f.go (1 def)
+      A (func) - 1 ref
+`
+	got := formatTree([]defRefs{
+		{a, []*graph.Ref{{}}},
+		{b, nil},
+		{c, []*graph.Ref{{}, {}, {Def: true}}},
+		{d, nil},
+	})
+	if got != want {
+		t.Errorf("got tree:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,204 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+	"github.com/kr/fs"
+	"github.com/mattn/go-isatty"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+)
+
+// watchQuery evaluates the query input and prints its output. It then
+// re-imports the active repo's build data and re-evaluates the query
+// whenever the build data changes, until the process is interrupted.
+//
+// When the repo's working tree switches to another commit (e.g., after
+// 'git checkout'), the new commit's data is built (if needed) and
+// imported in the background, and the query is re-evaluated against
+// that commit once its data is ready.
+func watchQuery(input string, interval time.Duration) error {
+	var status string
+	render := func() {
+		if isatty.IsTerminal(os.Stdout.Fd()) {
+			colorable.Print("\033[H\033[2J") // clear the screen
+		}
+		colorable.Printf("# %s (%s; watching for build data changes)\n", input, time.Now().Format("15:04:05"))
+		if status != "" {
+			colorable.Printf("# %s\n", status)
+		}
+		colorable.Println()
+		output, err := eval(input)
+		releaseStoreLocks()
+		if output != "" {
+			colorable.Print(cleanOutput(output))
+		}
+		if err != nil {
+			colorable.Println("Error:", err)
+		}
+	}
+
+	last, err := buildDataVersion(activeContext.commitFS)
+	if err != nil {
+		return err
+	}
+	render()
+
+	sb := newStandby(func(commitID string) error {
+		repo := *activeContext.repo
+		repo.CommitID = commitID
+		return warmCommit(activeContext.buildStore, &repo)
+	})
+	prev := last
+	tick := time.Tick(interval)
+	for {
+		select {
+		case <-tick:
+		case r := <-sb.results:
+			if !sb.finish(r) {
+				continue
+			}
+			if r.err != nil {
+				status = fmt.Sprintf("preparing data for commit %s failed: %s", r.commitID, r.err)
+				render()
+				continue
+			}
+			repo := *activeContext.repo
+			repo.CommitID = r.commitID
+			activeContext.repo = &repo
+			activeContext.commitFS = activeContext.buildStore.Commit(r.commitID)
+			if last, err = buildDataVersion(activeContext.commitFS); err != nil {
+				return err
+			}
+			prev, status = last, ""
+			render()
+			continue
+		}
+
+		head, err := resolveWorkingTreeRevision(activeContext.repo.VCSType, activeContext.repo.RootDir)
+		if err != nil {
+			log.Printf("Warning: checking the current commit: %s", err)
+		} else if sb.check(head, activeContext.repo.CommitID) {
+			status = fmt.Sprintf("switched to commit %s; preparing its data in the background (showing results for %s)", head, activeContext.repo.CommitID)
+			render()
+		} else if head == activeContext.repo.CommitID && status != "" {
+			// Switched back before the other commit's data was
+			// ready.
+			status = ""
+			render()
+		}
+
+		v, err := buildDataVersion(activeContext.commitFS)
+		if err != nil {
+			return err
+		}
+		// Wait until the build data stops changing (e.g., until
+		// 'src make' finishes) before re-running the query.
+		if v == last || v != prev {
+			prev = v
+			continue
+		}
+		last = v
+		if err := importBuildData(activeContext.repo); err != nil {
+			log.Printf("Warning: importing changed build data: %s", err)
+		}
+		render()
+	}
+}
+
+// standby prepares the data of commits that the working tree switches
+// to in the background, one commit at a time.
+type standby struct {
+	warm    func(commitID string) error
+	warming string // the commit being prepared, if any
+	results chan standbyResult
+}
+
+type standbyResult struct {
+	commitID string
+	err      error
+}
+
+func newStandby(warm func(commitID string) error) *standby {
+	return &standby{warm: warm, results: make(chan standbyResult)}
+}
+
+// check starts preparing head's data if it differs from the current
+// commit and isn't already being prepared, and reports whether it
+// did. If head is the current commit, any preparation in progress
+// is abandoned.
+func (s *standby) check(head, current string) bool {
+	if head == current {
+		s.warming = ""
+		return false
+	}
+	if head == s.warming {
+		return false
+	}
+	s.warming = head
+	go func() {
+		s.results <- standbyResult{commitID: head, err: s.warm(head)}
+	}()
+	return true
+}
+
+// finish reports whether r is the result for the commit that is
+// currently being prepared (and not an abandoned one).
+func (s *standby) finish(r standbyResult) bool {
+	if r.commitID != s.warming {
+		return false
+	}
+	s.warming = ""
+	return true
+}
+
+// warmCommit imports repo's build data for its commit into the store
+// (so that its indexes are ready), building it first if needed.
+func warmCommit(bs buildstore.RepoBuildStore, repo *Repo) error {
+	exists, err := buildstore.BuildDataExistsForCommit(bs, repo.CommitID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ensureBuild(bs, repo)
+	}
+	return importBuildData(repo)
+}
+
+// dataVersion identifies a version of a dir tree's contents (by
+// its files' count, total size, and latest modification time).
+type dataVersion struct {
+	files   int
+	size    int64
+	modTime time.Time
+}
+
+// buildDataVersion returns the version of the build data in commitFS.
+// If there is no build data, it returns the zero dataVersion.
+func buildDataVersion(commitFS rwvfs.WalkableFileSystem) (dataVersion, error) {
+	var v dataVersion
+	w := fs.WalkFS(".", commitFS)
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return dataVersion{}, err
+		}
+		fi := w.Stat()
+		if fi.Mode().IsDir() {
+			continue
+		}
+		v.files++
+		v.size += fi.Size()
+		if fi.ModTime().After(v.modTime) {
+			v.modTime = fi.ModTime()
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStandby(t *testing.T) {
+	release := make(chan struct{})
+	sb := newStandby(func(commitID string) error {
+		<-release
+		if commitID == "bad" {
+			return errors.New("build failed")
+		}
+		return nil
+	})
+
+	if sb.check("a", "a") {
+		t.Error("started preparing the current commit")
+	}
+	if !sb.check("b", "a") {
+		t.Fatal("didn't start preparing a new commit")
+	}
+	if sb.check("b", "a") {
+		t.Error("started preparing a commit that is already being prepared")
+	}
+
+	// Switching back abandons the preparation.
+	sb.check("a", "a")
+	release <- struct{}{}
+	if r := <-sb.results; sb.finish(r) {
+		t.Errorf("got abandoned result %+v", r)
+	}
+
+	if !sb.check("bad", "a") {
+		t.Fatal("didn't start preparing a new commit")
+	}
+	release <- struct{}{}
+	if r := <-sb.results; !sb.finish(r) || r.commitID != "bad" || r.err == nil {
+		t.Errorf("got result %+v, want a failure for commit bad", r)
+	}
+}
@@ -0,0 +1,245 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+func init() {
+	_, err := CLI.AddCommand("refs",
+		"list refs to a def",
+		"The refs command lists all refs to a def. The def is specified either by the position (FILE:LINE:COL, with 1-based line and column numbers) of a ref to it or of its definition, or by its def path or name. Refs are grouped by the repository they're in. The current repository is built (if needed) before refs are listed.",
+		&refsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type RefsCmd struct {
+	JSON      bool `long:"json" description:"print refs (or counts, with --count-only) as JSON"`
+	CountOnly bool `long:"count-only" description:"only print the number of refs in each repository"`
+	Global    bool `long:"global" description:"also list refs from other repositories in the global store"`
+
+	Args struct {
+		Def string `name:"DEF" description:"FILE:LINE:COL position, or def path or name"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var refsCmd RefsCmd
+
+// positionSpec matches FILE:LINE:COL position arguments.
+var positionSpec = regexp.MustCompile(`^(.+):(\d+):(\d+)$`)
+
+func (c *RefsCmd) Execute(args []string) error {
+	context, rs, def, err := resolveDefArg(c.Args.Def)
+	if err != nil {
+		return err
+	}
+	commitID := context.repo.CommitID
+
+	repoURI := context.repo.URI()
+	byRepo := map[string][]*graph.Ref{}
+	localRefs, err := rs.Refs(store.ByCommitIDs(commitID), store.ByRefDef(def))
+	if err != nil {
+		return err
+	}
+	byRepo[repoURI] = withoutDefRefs(localRefs)
+
+	if c.Global {
+		absDef := def
+		if absDef.DefRepo == "" {
+			absDef.DefRepo = repoURI
+		}
+		globalStore := store.NewFSMultiRepoStore(rwvfs.Walkable(rwvfs.ReadOnly(rwvfs.OS(srclib.StoreDir))), nil)
+		globalRefs, err := globalStore.Refs(store.ByRefDef(absDef))
+		if err != nil {
+			return err
+		}
+		for _, ref := range withoutDefRefs(globalRefs) {
+			// The current repo's refs were already listed above.
+			if !graph.URIEqual(ref.Repo, repoURI) {
+				byRepo[ref.Repo] = append(byRepo[ref.Repo], ref)
+			}
+		}
+	}
+
+	repos := make([]string, 0, len(byRepo))
+	for repo := range byRepo {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+
+	if c.CountOnly {
+		counts := make(map[string]int, len(byRepo))
+		for repo, refs := range byRepo {
+			counts[repo] = len(refs)
+		}
+		if c.JSON {
+			PrintJSON(counts, "  ")
+			return nil
+		}
+		for _, repo := range repos {
+			colorable.Printf("%d\t%s\n", counts[repo], repo)
+		}
+		return nil
+	}
+
+	if c.JSON {
+		var refs []*graph.Ref
+		for _, repo := range repos {
+			for _, ref := range byRepo[repo] {
+				if ref.Repo == "" {
+					ref.Repo = repo
+				}
+				refs = append(refs, ref)
+			}
+		}
+		PrintJSON(refs, "  ")
+		return nil
+	}
+
+	for i, repo := range repos {
+		if i > 0 {
+			colorable.Println()
+		}
+		refs := byRepo[repo]
+		colorable.Println(colorable.Bold(fmt.Sprintf("%s (%d refs)", repo, len(refs))))
+		for _, ref := range refs {
+			if repo == repoURI {
+				colorable.Println(refPosition(ref))
+			} else {
+				// Other repos' files aren't available locally.
+				colorable.Printf("%s:@%d-%d\n", ref.File, ref.Start, ref.End)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveDefArg resolves a DEF argument, which is either the
+// FILE:LINE:COL position of a ref to a def (or of its definition) or a
+// def path or name, to the def it specifies. It also returns the
+// context of the repository that contains the position (or the current
+// directory) and that repository's store.
+func resolveDefArg(spec string) (commandContext, store.RepoStore, graph.RefDefKey, error) {
+	file := "."
+	m := positionSpec.FindStringSubmatch(spec)
+	if m != nil {
+		file = m[1]
+	}
+	context, err := prepareCommandContext(file)
+	if err != nil {
+		return commandContext{}, nil, graph.RefDefKey{}, err
+	}
+	s, err := OpenStoreReadOnly()
+	if err != nil {
+		return commandContext{}, nil, graph.RefDefKey{}, err
+	}
+	rs, ok := s.(store.RepoStore)
+	if !ok {
+		return commandContext{}, nil, graph.RefDefKey{}, fmt.Errorf("store (type %T) does not implement listing defs and refs", s)
+	}
+	commitID := context.repo.CommitID
+
+	if m != nil {
+		line, _ := strconv.Atoi(m[2])
+		col, _ := strconv.Atoi(m[3])
+		def, err := refDefAtPosition(rs, commitID, context.relativeFile, line, col)
+		return context, rs, def, err
+	}
+
+	defs, err := findDefs(rs, spec, store.ByCommitIDs(commitID))
+	if err != nil {
+		return commandContext{}, nil, graph.RefDefKey{}, err
+	}
+	switch len(defs) {
+	case 0:
+		return commandContext{}, nil, graph.RefDefKey{}, fmt.Errorf("no def found matching %q", spec)
+	case 1:
+	default:
+		colorable.Printf("%q matches %d defs; specify one by its def path or position:\n", spec, len(defs))
+		for _, def := range defs {
+			colorable.Printf("  %s\t%s\n", def.Path, defLocation(def))
+		}
+		return commandContext{}, nil, graph.RefDefKey{}, fmt.Errorf("ambiguous def %q", spec)
+	}
+	def := graph.RefDefKey{DefRepo: defs[0].Repo, DefUnitType: defs[0].UnitType, DefUnit: defs[0].Unit, DefPath: defs[0].Path}
+	return context, rs, def, nil
+}
+
+// refDefAtPosition returns the def that the ref at the given 1-based
+// line and column in file refers to.
+func refDefAtPosition(s store.RepoStore, commitID, file string, line, col int) (graph.RefDefKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return graph.RefDefKey{}, err
+	}
+	ofs, err := lineColToByteOffset(data, line, col)
+	if err != nil {
+		return graph.RefDefKey{}, err
+	}
+	ref, err := refAtOffset(s, commitID, file, ofs)
+	if err != nil {
+		return graph.RefDefKey{}, err
+	}
+	if ref == nil {
+		return graph.RefDefKey{}, fmt.Errorf("no ref found at %s:%d:%d", file, line, col)
+	}
+	return ref.RefDefKey(), nil
+}
+
+// refAtOffset returns the ref in file that contains the byte offset
+// ofs, or nil if there is none. The ref's DefUnitType and DefUnit are
+// filled in if they are implied (i.e., if the def is in the ref's
+// source unit).
+func refAtOffset(s store.RepoStore, commitID, file string, ofs uint32) (*graph.Ref, error) {
+	refs, err := s.Refs(
+		store.ByCommitIDs(commitID),
+		store.ByFiles(filepath.ToSlash(file)),
+		store.RefFilterFunc(func(ref *graph.Ref) bool {
+			return ref.Start <= ofs && ofs < ref.End
+		}),
+	)
+	if err != nil || len(refs) == 0 {
+		return nil, err
+	}
+	ref := refs[0]
+	if ref.DefUnitType == "" {
+		ref.DefUnitType = ref.UnitType
+	}
+	if ref.DefUnit == "" {
+		ref.DefUnit = ref.Unit
+	}
+	return ref, nil
+}
+
+// withoutDefRefs returns the refs in refs that are not def refs
+// (i.e., that are not the definitions themselves).
+func withoutDefRefs(refs []*graph.Ref) []*graph.Ref {
+	var nonDefRefs []*graph.Ref
+	for _, ref := range refs {
+		if !ref.Def {
+			nonDefRefs = append(nonDefRefs, ref)
+		}
+	}
+	return nonDefRefs
+}
+
+// refPosition returns the FILE:LINE:COL position of ref (in the
+// current repository) followed by the line's text.
+func refPosition(ref *graph.Ref) string {
+	return filePosition(ref.File, ref.Start, ref.End)
+}
@@ -0,0 +1,296 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+func init() {
+	_, err := CLI.AddCommand("aliases",
+		"list and configure short repo names",
+		"The aliases command lists the short names (aliases) that can be used instead of repo URIs in 'src query --repo' and the query REPL's ':in' keyword. Each repo in the global store (SRCLIBSTORE), and each dependency of the current repo, is automatically aliased by the last element of its URI (e.g., mux for github.com/gorilla/mux), unless other repos share that name. Configured aliases take precedence over automatic ones.\n\nThe list of repos in the global store is cached, and it is rebuilt when it is more than an hour old, when an alias isn't found, or when --refresh is given.",
+		&aliasesCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type AliasesCmd struct {
+	Set     string `long:"set" description:"configure ALIAS as an alias for the repo URI REPO" value-name:"ALIAS=REPO"`
+	Unset   string `long:"unset" description:"remove the configured alias ALIAS" value-name:"ALIAS"`
+	Refresh bool   `long:"refresh" description:"rebuild the cached list of repos in the global store"`
+}
+
+var aliasesCmd AliasesCmd
+
+func (c *AliasesCmd) Execute(args []string) error {
+	if c.Set != "" || c.Unset != "" {
+		configured, err := readRepoAliases()
+		if err != nil {
+			return err
+		}
+		if c.Set != "" {
+			i := strings.Index(c.Set, "=")
+			if i == -1 {
+				return fmt.Errorf("invalid --set value %q (it must be ALIAS=REPO)", c.Set)
+			}
+			alias, repo := strings.ToLower(strings.TrimSpace(c.Set[:i])), strings.TrimSpace(c.Set[i+1:])
+			if alias == "" || strings.ContainsAny(alias, "/ \t") || !strings.Contains(repo, "/") {
+				return fmt.Errorf("invalid --set value %q (ALIAS must be nonempty and contain no slashes or whitespace, and REPO must be a repo URI)", c.Set)
+			}
+			configured[alias] = repo
+		}
+		if c.Unset != "" {
+			alias := strings.ToLower(c.Unset)
+			if _, present := configured[alias]; !present {
+				return fmt.Errorf("no alias %q is configured", c.Unset)
+			}
+			delete(configured, alias)
+		}
+		if err := writeRepoAliases(configured); err != nil {
+			return err
+		}
+	}
+
+	aliases, err := listRepoAliases(c.Refresh)
+	if err != nil {
+		return err
+	}
+	for _, a := range aliases {
+		switch {
+		case a.Configured:
+			colorable.Printf("%-20s %s (configured)\n", a.Alias, a.Repos[0])
+		case len(a.Repos) == 1:
+			colorable.Printf("%-20s %s\n", a.Alias, a.Repos[0])
+		default:
+			colorable.Printf("%-20s ambiguous: %s\n", a.Alias, strings.Join(a.Repos, ", "))
+		}
+	}
+	return nil
+}
+
+// repoAliasesFile is the file that holds the repo aliases configured
+// with `src aliases --set`. It is a JSON object mapping aliases to
+// repo URIs.
+var repoAliasesFile = filepath.Join(filepath.SplitList(srclib.Path)[0], ".srclibaliases")
+
+// repoRegistryFile caches the list of repos in the global store,
+// which is slow to list when the store is large.
+var repoRegistryFile = filepath.Join(filepath.SplitList(srclib.Path)[0], ".srclibrepos")
+
+// repoRegistryMaxAge is how long the cached list of repos in the
+// global store is used before it's rebuilt.
+const repoRegistryMaxAge = time.Hour
+
+// repoRegistry is the format of repoRegistryFile.
+type repoRegistry struct {
+	Repos   []string
+	Updated time.Time
+}
+
+// readRepoAliases reads the configured aliases. If there are none, it
+// returns an empty map.
+func readRepoAliases() (map[string]string, error) {
+	aliases := map[string]string{}
+	data, err := ioutil.ReadFile(repoAliasesFile)
+	if os.IsNotExist(err) {
+		return aliases, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return nil, fmt.Errorf("reading repo aliases from %s: %s", repoAliasesFile, err)
+	}
+	return aliases, nil
+}
+
+// writeRepoAliases saves the configured aliases.
+func writeRepoAliases(aliases map[string]string) error {
+	data, err := json.MarshalIndent(aliases, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(repoAliasesFile), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(repoAliasesFile, data, 0600)
+}
+
+// globalStoreRepos returns the repos in the global store, from
+// repoRegistryFile if it's fresh and refresh is false. It also reports
+// whether the list was rebuilt (and so is up to date).
+func globalStoreRepos(refresh bool) (repos []string, rebuilt bool, err error) {
+	if !refresh {
+		var reg repoRegistry
+		if err := readJSONFile(repoRegistryFile, &reg); err == nil && time.Since(reg.Updated) < repoRegistryMaxAge {
+			return reg.Repos, false, nil
+		}
+	}
+
+	if _, err := os.Stat(srclib.StoreDir); err == nil {
+		s := store.NewFSMultiRepoStore(rwvfs.Walkable(rwvfs.ReadOnly(rwvfs.OS(srclib.StoreDir))), nil)
+		if repos, err = s.Repos(); err != nil {
+			return nil, false, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	data, err := json.Marshal(repoRegistry{Repos: repos, Updated: time.Now()})
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(repoRegistryFile), 0700)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(repoRegistryFile, data, 0600)
+	}
+	if err != nil && GlobalOpt.Verbose {
+		log.Printf("Warning: caching the list of repos in the global store: %s", err)
+	}
+	return repos, true, nil
+}
+
+// knownRepos returns the repos that can be referred to by automatic
+// aliases: those in the global store and the current repo's
+// dependencies (if there is a current repo with build data).
+func knownRepos(refresh bool) (repos []string, rebuilt bool, err error) {
+	repos, rebuilt, err = globalStoreRepos(refresh)
+	if err != nil {
+		return nil, false, err
+	}
+	if activeContext.commitFS != nil {
+		deps, err := depRepoURIs()
+		if err != nil {
+			if GlobalOpt.Verbose {
+				log.Printf("Warning: listing the current repo's dependencies for repo aliases: %s", err)
+			}
+		} else {
+			repos = append(repos, deps...)
+		}
+	}
+	return repos, rebuilt, nil
+}
+
+// A repoAlias is a short name for a repo.
+type repoAlias struct {
+	Alias string
+
+	// Repos lists the repos the alias refers to. Automatic aliases
+	// that refer to more than one repo are ambiguous and can't be
+	// used.
+	Repos []string
+
+	Configured bool
+}
+
+// repoAliases returns the aliases for repos (each repo is aliased by
+// the last element of its URI, case-insensitively) and the configured
+// aliases, which take precedence, sorted by alias.
+func repoAliases(configured map[string]string, repos []string) []*repoAlias {
+	byAlias := map[string]*repoAlias{}
+	for alias, repo := range configured {
+		byAlias[strings.ToLower(alias)] = &repoAlias{Alias: strings.ToLower(alias), Repos: []string{repo}, Configured: true}
+	}
+	for _, repo := range repos {
+		alias := strings.ToLower(path.Base(repo))
+		a := byAlias[alias]
+		if a == nil {
+			a = &repoAlias{Alias: alias}
+			byAlias[alias] = a
+		} else if a.Configured {
+			continue
+		}
+		var dup bool
+		for _, r := range a.Repos {
+			dup = dup || r == repo
+		}
+		if !dup {
+			a.Repos = append(a.Repos, repo)
+		}
+	}
+
+	aliases := make([]*repoAlias, 0, len(byAlias))
+	for _, a := range byAlias {
+		sort.Strings(a.Repos)
+		aliases = append(aliases, a)
+	}
+	sort.Sort(repoAliasesByName(aliases))
+	return aliases
+}
+
+type repoAliasesByName []*repoAlias
+
+func (v repoAliasesByName) Len() int           { return len(v) }
+func (v repoAliasesByName) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v repoAliasesByName) Less(i, j int) bool { return v[i].Alias < v[j].Alias }
+
+// listRepoAliases returns the configured and automatic repo aliases.
+func listRepoAliases(refresh bool) ([]*repoAlias, error) {
+	configured, err := readRepoAliases()
+	if err != nil {
+		return nil, err
+	}
+	repos, _, err := knownRepos(refresh)
+	if err != nil {
+		return nil, err
+	}
+	return repoAliases(configured, repos), nil
+}
+
+// resolveRepo returns the URI of the repo named by name, which is
+// either a repo URI (which is returned as-is) or an alias.
+func resolveRepo(name string) (string, error) {
+	if strings.Contains(name, "/") {
+		return name, nil
+	}
+	configured, err := readRepoAliases()
+	if err != nil {
+		return "", err
+	}
+	for refresh := false; ; refresh = true {
+		repos, rebuilt, err := knownRepos(refresh)
+		if err != nil {
+			return "", err
+		}
+		for _, a := range repoAliases(configured, repos) {
+			if a.Alias != strings.ToLower(name) {
+				continue
+			}
+			if len(a.Repos) > 1 {
+				return "", fmt.Errorf("repo alias %q is ambiguous (it may refer to %s); use a full repo URI or configure the alias with 'src aliases --set'", name, strings.Join(a.Repos, ", "))
+			}
+			return a.Repos[0], nil
+		}
+		// The repo may have been added to the global store since
+		// the list of its repos was cached.
+		if rebuilt {
+			return "", fmt.Errorf("unknown repo %q (it is neither a repo URI nor an alias; list aliases with 'src aliases')", name)
+		}
+	}
+}
+
+// resolveRepos is like resolveRepo, but for multiple repo names.
+func resolveRepos(names []string) ([]string, error) {
+	repos := make([]string, len(names))
+	for i, name := range names {
+		repo, err := resolveRepo(name)
+		if err != nil {
+			return nil, err
+		}
+		repos[i] = repo
+	}
+	return repos, nil
+}
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/srclib"
+)
+
+func TestRepoAliases(t *testing.T) {
+	configured := map[string]string{"Ctx": "golang.org/x/net/context"}
+	repos := []string{
+		"github.com/gorilla/mux",
+		"github.com/gorilla/context",
+		"github.com/a/util",
+		"github.com/b/util",
+		"github.com/gorilla/mux",
+	}
+	var got []repoAlias
+	for _, a := range repoAliases(configured, repos) {
+		got = append(got, *a)
+	}
+	want := []repoAlias{
+		{Alias: "context", Repos: []string{"github.com/gorilla/context"}},
+		{Alias: "ctx", Repos: []string{"golang.org/x/net/context"}, Configured: true},
+		{Alias: "mux", Repos: []string{"github.com/gorilla/mux"}},
+		{Alias: "util", Repos: []string{"github.com/a/util", "github.com/b/util"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got aliases %+v, want %+v", got, want)
+	}
+}
+
+func TestResolveRepo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-repo-aliases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(aliasesFile, registryFile, storeDir string) {
+		repoAliasesFile, repoRegistryFile, srclib.StoreDir = aliasesFile, registryFile, storeDir
+	}(repoAliasesFile, repoRegistryFile, srclib.StoreDir)
+	repoAliasesFile = filepath.Join(dir, ".srclibaliases")
+	repoRegistryFile = filepath.Join(dir, ".srclibrepos")
+	srclib.StoreDir = filepath.Join(dir, "store") // doesn't exist
+	defer func(orig commandContext) { activeContext = orig }(activeContext)
+	activeContext = commandContext{}
+
+	reg, err := json.Marshal(repoRegistry{
+		Repos:   []string{"github.com/gorilla/mux", "github.com/a/util", "github.com/b/util"},
+		Updated: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(repoRegistryFile, reg, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRepoAliases(map[string]string{"u": "github.com/a/util"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"github.com/x/y": "github.com/x/y",
+		"MUX":            "github.com/gorilla/mux",
+		"u":              "github.com/a/util",
+	}
+	for name, want := range tests {
+		if got, err := resolveRepo(name); err != nil {
+			t.Errorf("%s: %s", name, err)
+		} else if got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+	if _, err := resolveRepo("util"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("util: got error %v, want ambiguous", err)
+	}
+
+	// An unknown alias rebuilds the cached registry from the (here,
+	// nonexistent) global store.
+	if _, err := resolveRepo("nope"); err == nil || !strings.Contains(err.Error(), "unknown repo") {
+		t.Errorf("nope: got error %v, want unknown repo", err)
+	}
+	if _, err := resolveRepo("mux"); err == nil {
+		t.Error("mux: got no error after the registry was rebuilt without it")
+	}
+}
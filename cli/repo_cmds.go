@@ -30,5 +30,9 @@ func (c *repoCmd) Execute(args []string) error {
 	colorable.Println("VCS:", repo.VCSType)
 	colorable.Println("Root dir:", repo.RootDir)
 	colorable.Println("Commit ID:", repo.CommitID)
+	if repo.VCSType == "git" {
+		colorable.Println("Detached HEAD:", repo.DetachedHEAD)
+		colorable.Println("Shallow clone:", repo.Shallow)
+	}
 	return nil
 }
@@ -23,6 +23,9 @@ type Repo struct {
 	VCSType  string // VCS type (git or hg)
 	CommitID string // CommitID of current working directory
 	CloneURL string // CloneURL of repo.
+
+	Shallow      bool // whether the repo is a shallow clone (git only; see shallowCommits)
+	DetachedHEAD bool // whether HEAD is detached (git only)
 }
 
 // URI returns the Repo's URI. It returns the empty string if the
@@ -61,6 +64,20 @@ func OpenRepo(dir string) (*Repo, error) {
 		rc.CloneURL = getVCSCloneURL(rc.VCSType, rc.RootDir)
 		return nil
 	})
+	if rc.VCSType == "git" {
+		par.Do(func() error {
+			shallow, err := shallowCommits(rc.RootDir)
+			if err != nil && GlobalOpt.Verbose {
+				log.Printf("Warning: checking whether %s is a shallow clone: %s", rc.RootDir, err)
+			}
+			rc.Shallow = len(shallow) > 0
+			return nil
+		})
+		par.Do(func() error {
+			rc.DetachedHEAD = isDetachedHEAD(rc.RootDir)
+			return nil
+		})
+	}
 	return rc, par.Wait()
 }
 
@@ -68,7 +85,7 @@ func resolveWorkingTreeRevision(vcsType string, dir string) (string, error) {
 	var cmd *exec.Cmd
 	switch vcsType {
 	case "git":
-		cmd = exec.Command("git", "rev-parse", "HEAD")
+		cmd = exec.Command("git", "rev-parse", "-q", "--verify", "HEAD")
 	case "hg":
 		cmd = exec.Command("hg", "--config", "trusted.users=root", "identify", "--debug", "-i")
 	default:
@@ -77,6 +94,10 @@ func resolveWorkingTreeRevision(vcsType string, dir string) (string, error) {
 	cmd.Dir = dir
 
 	out, err := cmd.CombinedOutput()
+	if code, _ := exitStatus(err); vcsType == "git" && code == 1 && len(bytes.TrimSpace(out)) == 0 {
+		// HEAD doesn't refer to a commit (but is otherwise fine).
+		return "", fmt.Errorf("the git repository at %s has no commits yet (srclib analyzes the commit checked out in the working tree, so commit something first)", dir)
+	}
 	if err != nil {
 		return "", fmt.Errorf("exec %v failed: %s. Output was:\n\n%s", cmd.Args, err, out)
 	}
@@ -84,6 +105,109 @@ func resolveWorkingTreeRevision(vcsType string, dir string) (string, error) {
 	return strings.TrimSuffix(string(bytes.TrimSpace(out)), "+"), nil
 }
 
+// resolveRevision returns the commit ID that rev (a commit ID,
+// branch, tag, etc.) refers to in the repository at dir.
+func resolveRevision(vcsType, dir, rev string) (string, error) {
+	var cmd *exec.Cmd
+	switch vcsType {
+	case "git":
+		cmd = exec.Command("git", "rev-parse", "--verify", rev+"^{commit}")
+	case "hg":
+		cmd = exec.Command("hg", "--config", "trusted.users=root", "log", "--template", "{node}", "-r", rev)
+	default:
+		return "", fmt.Errorf("unknown vcs type: %q", vcsType)
+	}
+	cmd.Dir = dir
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("resolving revision %q failed: %s. Output was:\n\n%s", rev, err, out)
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
+
+// fileAtCommit returns the contents of file (relative to the
+// repository's root dir) at commitID in the repository at dir.
+func fileAtCommit(vcsType, dir, commitID, file string) ([]byte, error) {
+	var cmd *exec.Cmd
+	switch vcsType {
+	case "git":
+		cmd = exec.Command("git", "show", commitID+":"+filepath.ToSlash(file))
+	case "hg":
+		cmd = exec.Command("hg", "--config", "trusted.users=root", "cat", "-r", commitID, file)
+	default:
+		return nil, fmt.Errorf("unknown vcs type: %q", vcsType)
+	}
+	cmd.Dir = dir
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s at commit %s failed: %s", file, commitID, err)
+	}
+	return out, nil
+}
+
+// commitMessage returns the full commit message of commitID in the
+// repository at dir.
+func commitMessage(vcsType, dir, commitID string) (string, error) {
+	var cmd *exec.Cmd
+	switch vcsType {
+	case "git":
+		cmd = exec.Command("git", "log", "-1", "--format=%B", commitID)
+	case "hg":
+		cmd = exec.Command("hg", "--config", "trusted.users=root", "log", "--template", "{desc}", "-r", commitID)
+	default:
+		return "", fmt.Errorf("unknown vcs type: %q", vcsType)
+	}
+	cmd.Dir = dir
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("reading message of commit %s failed: %s", commitID, err)
+	}
+	return string(out), nil
+}
+
+// revRangeCommit is a commit in a revision range.
+type revRangeCommit struct {
+	ID      string
+	Subject string // first line of the commit message
+}
+
+// firstParentCommits lists the commits that are reachable from head
+// but not from base by following only first parents, oldest first.
+// Each commit's first parent is thus the commit before it (or base).
+func firstParentCommits(vcsType, dir, base, head string) ([]revRangeCommit, error) {
+	var cmd *exec.Cmd
+	switch vcsType {
+	case "git":
+		cmd = exec.Command("git", "log", "--reverse", "--first-parent", "--format=%H %s", base+".."+head)
+	case "hg":
+		cmd = exec.Command("hg", "--config", "trusted.users=root", "log", "--template", "{node} {desc|firstline}\n", "-r", fmt.Sprintf("sort(_firstancestors(%s) - ancestors(%s))", head, base))
+	default:
+		return nil, fmt.Errorf("unknown vcs type: %q", vcsType)
+	}
+	cmd.Dir = dir
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing commits in %s..%s failed: %s", base, head, err)
+	}
+	var commits []revRangeCommit
+	for _, line := range strings.Split(string(bytes.TrimSpace(out)), "\n") {
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		c := revRangeCommit{ID: parts[0]}
+		if len(parts) == 2 {
+			c.Subject = parts[1]
+		}
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
+
 func getRootDir(dir string) (rootDir string, vcsType string, err error) {
 	dir, err = filepath.Abs(dir)
 	if err != nil {
@@ -34,32 +34,79 @@ func OpenLocalRepo() (*Repo, error) {
 	return localRepo, localRepoErr
 }
 
-func SetDefaultRepoOpt(c *flags.Command) {
-	OpenLocalRepo()
-	if localRepo != nil {
-		if localRepo.CloneURL != "" {
-			SetOptionDefaultValue(c.Group, "repo", localRepo.URI())
+// lazyDefault is a func that sets default option values of a command
+// from the local repo.
+type lazyDefault struct {
+	cmd *flags.Command
+	set func()
+}
+
+// lazyDefaults holds the lazy defaults of all commands. Opening the
+// local repo runs VCS commands, which is slow in large worktrees, so
+// it's deferred until applyLazyDefaults determines which commands may
+// run instead of being done when each command is registered.
+var lazyDefaults []lazyDefault
+
+// applyLazyDefaults sets the default option values of the commands
+// named in args (the command-line arguments).
+func applyLazyDefaults(args []string) {
+	named := make(map[string]bool, len(args))
+	for _, arg := range args {
+		named[arg] = true
+	}
+	for _, d := range lazyDefaults {
+		if commandNamed(d.cmd, named) {
+			d.set()
 		}
 	}
 }
 
-func SetDefaultCommitIDOpt(c *flags.Command) {
-	OpenLocalRepo()
-	if localRepo != nil {
-		if localRepo.CommitID != "" {
-			SetOptionDefaultValue(c.Group, "commit", localRepo.CommitID)
+// commandNamed returns whether c's name or one of its aliases is in
+// names.
+func commandNamed(c *flags.Command, names map[string]bool) bool {
+	if names[c.Name] {
+		return true
+	}
+	for _, alias := range c.Aliases {
+		if names[alias] {
+			return true
 		}
 	}
+	return false
+}
+
+func SetDefaultRepoOpt(c *flags.Command) {
+	lazyDefaults = append(lazyDefaults, lazyDefault{c, func() {
+		OpenLocalRepo()
+		if localRepo != nil {
+			if localRepo.CloneURL != "" {
+				SetOptionDefaultValue(c.Group, "repo", localRepo.URI())
+			}
+		}
+	}})
+}
+
+func SetDefaultCommitIDOpt(c *flags.Command) {
+	lazyDefaults = append(lazyDefaults, lazyDefault{c, func() {
+		OpenLocalRepo()
+		if localRepo != nil {
+			if localRepo.CommitID != "" {
+				SetOptionDefaultValue(c.Group, "commit", localRepo.CommitID)
+			}
+		}
+	}})
 }
 
 func setDefaultRepoSubdirOpt(c *flags.Command) {
-	OpenLocalRepo()
-	if localRepo != nil {
-		absDir, err := os.Getwd()
-		if err != nil {
-			log.Fatal(err)
+	lazyDefaults = append(lazyDefaults, lazyDefault{c, func() {
+		OpenLocalRepo()
+		if localRepo != nil {
+			absDir, err := os.Getwd()
+			if err != nil {
+				log.Fatal(err)
+			}
+			subdir, _ := filepath.Rel(localRepo.RootDir, absDir)
+			SetOptionDefaultValue(c.Group, "subdir", subdir)
 		}
-		subdir, _ := filepath.Rel(localRepo.RootDir, absDir)
-		SetOptionDefaultValue(c.Group, "subdir", subdir)
-	}
+	}})
 }
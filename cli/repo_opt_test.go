@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"testing"
+
+	"sourcegraph.com/sourcegraph/go-flags"
+)
+
+func TestApplyLazyDefaults(t *testing.T) {
+	defer func(orig []lazyDefault) { lazyDefaults = orig }(lazyDefaults)
+	lazyDefaults = nil
+
+	p := flags.NewParser(nil, flags.Default)
+	var opts struct{}
+	a, err := p.AddCommand("a", "", "", &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Aliases = []string{"aa"}
+	b, err := p.AddCommand("b", "", "", &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	called := map[string]int{}
+	lazyDefaults = []lazyDefault{
+		{a, func() { called["a"]++ }},
+		{b, func() { called["b"]++ }},
+	}
+
+	applyLazyDefaults([]string{"-v", "a", "--foo"})
+	applyLazyDefaults([]string{"aa"})
+	applyLazyDefaults([]string{"c"})
+	if called["a"] != 2 || called["b"] != 0 {
+		t.Errorf("got lazy defaults called %v, want a twice and b never", called)
+	}
+}
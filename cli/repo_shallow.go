@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// shallowCommits returns the set of commits at the boundary of the
+// history of the shallow git clone at dir (the commits whose parents
+// weren't fetched). It is empty if the repo isn't a shallow clone.
+func shallowCommits(dir string) (map[string]bool, error) {
+	cmd := exec.Command("git", "rev-parse", "--git-path", "shallow")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("exec %v failed: %s", cmd.Args, err)
+	}
+	file := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	commits := map[string]bool{}
+	for _, id := range strings.Fields(string(data)) {
+		commits[id] = true
+	}
+	return commits, nil
+}
+
+// isDetachedHEAD returns whether HEAD of the git repo at dir is
+// detached (i.e., refers directly to a commit instead of a branch), as
+// it usually is in CI checkouts.
+func isDetachedHEAD(dir string) bool {
+	cmd := exec.Command("git", "symbolic-ref", "-q", "HEAD")
+	cmd.Dir = dir
+	code, _ := exitStatus(cmd.Run())
+	return code == 1
+}
+
+// truncatedHistory returns whether any of commitIDs is at the boundary
+// of the fetched history of r, so that history before it is missing.
+func (r *Repo) truncatedHistory(commitIDs ...string) bool {
+	if !r.Shallow {
+		return false
+	}
+	shallow, err := shallowCommits(r.RootDir)
+	if err != nil {
+		return false
+	}
+	for _, id := range commitIDs {
+		if shallow[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// deepenDepths are the numbers of commits that deepenUntil
+// successively deepens a shallow clone's history by, before fetching
+// all of it.
+var deepenDepths = []int{100, 1000}
+
+// deepenUntil fetches more of the history of r, a shallow clone, until
+// done reports that the history needed for what (e.g., "resolving
+// revision X") is available or there is no more history to fetch. It
+// returns an error if fetching fails or isn't allowed (in offline
+// mode); otherwise the caller's own check (in done) has the last word.
+func (r *Repo) deepenUntil(what string, done func() bool) error {
+	fetch := func(arg string) error {
+		if err := checkOnline("fetching history of a shallow clone"); err != nil {
+			return err
+		}
+		log.Printf("Fetching more history of the shallow clone at %s (git fetch %s) for %s", r.RootDir, arg, what)
+		cmd := exec.Command("git", "fetch", "-q", arg)
+		cmd.Dir = r.RootDir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("exec %v failed: %s. Output was:\n\n%s", cmd.Args, err, out)
+		}
+		return nil
+	}
+	for _, depth := range deepenDepths {
+		if err := fetch("--deepen=" + strconv.Itoa(depth)); err != nil {
+			return r.missingHistory(what, err)
+		}
+		if shallow, err := shallowCommits(r.RootDir); err == nil {
+			r.Shallow = len(shallow) > 0
+		}
+		if done() || !r.Shallow {
+			return nil
+		}
+	}
+	if err := fetch("--unshallow"); err != nil {
+		return r.missingHistory(what, err)
+	}
+	r.Shallow = false
+	done()
+	return nil
+}
+
+// missingHistory returns an error explaining that what needs history
+// that r, a shallow clone, doesn't have and that fetching it failed
+// (with err).
+func (r *Repo) missingHistory(what string, err error) error {
+	return fmt.Errorf("%s needs git history that the shallow clone at %s doesn't have, and fetching it failed: %s\n\nFetch it with 'git fetch --unshallow' (or --deepen=N), or clone with a greater --depth.", what, r.RootDir, err)
+}
+
+// resolveRevision is like the resolveRevision func, but if r is a
+// shallow clone and rev can't be resolved, it fetches more history and
+// tries again.
+func (r *Repo) resolveRevision(rev string) (string, error) {
+	commitID, err := resolveRevision(r.VCSType, r.RootDir, rev)
+	if err != nil && r.Shallow {
+		if err := r.deepenUntil(fmt.Sprintf("resolving revision %q", rev), func() bool {
+			commitID, err = resolveRevision(r.VCSType, r.RootDir, rev)
+			return err == nil
+		}); err != nil {
+			return "", err
+		}
+	}
+	return commitID, err
+}
+
+// firstParentCommits is like the firstParentCommits func, but if r is
+// a shallow clone whose history ends between base and head, it fetches
+// more history.
+func (r *Repo) firstParentCommits(base, head string) ([]revRangeCommit, error) {
+	commits, err := firstParentCommits(r.VCSType, r.RootDir, base, head)
+	truncated := func() bool {
+		ids := make([]string, len(commits))
+		for i, c := range commits {
+			ids[i] = c.ID
+		}
+		return r.truncatedHistory(ids...)
+	}
+	if err != nil || !truncated() {
+		return commits, err
+	}
+	if err := r.deepenUntil(fmt.Sprintf("listing the commits in %s..%s", base, head), func() bool {
+		commits, err = firstParentCommits(r.VCSType, r.RootDir, base, head)
+		return err == nil && !truncated()
+	}); err != nil {
+		return nil, err
+	}
+	return commits, err
+}
+
+// firstParentAncestors is like the firstParentAncestors func, but if r
+// is a shallow clone whose history ends before n ancestors, it fetches
+// more history. If that fails, it returns the ancestors that are
+// available along with the error.
+func (r *Repo) firstParentAncestors(commitID string, n int) ([]string, error) {
+	ancestors, err := firstParentAncestors(r.VCSType, r.RootDir, commitID, n)
+	truncated := func() bool {
+		return len(ancestors) < n && r.truncatedHistory(append([]string{commitID}, ancestors...)...)
+	}
+	if err != nil || !truncated() {
+		return ancestors, err
+	}
+	if err := r.deepenUntil(fmt.Sprintf("listing %d ancestors of %s", n, commitID), func() bool {
+		ancestors, err = firstParentAncestors(r.VCSType, r.RootDir, commitID, n)
+		return err == nil && !truncated()
+	}); err != nil {
+		return ancestors, err
+	}
+	return ancestors, err
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRepo_shallow(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	tmpDir, err := ioutil.TempDir("", "srclib-shallow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	git := func(dir string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s\n%s", args, err, out)
+		}
+	}
+	upstream := filepath.Join(tmpDir, "upstream")
+	if err := os.Mkdir(upstream, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git(upstream, "init", "-q")
+	for _, msg := range []string{"c1", "c2", "c3", "c4"} {
+		git(upstream, "-c", "user.name=a", "-c", "user.email=a@example.com", "commit", "-q", "--allow-empty", "-m", msg)
+	}
+	clone := func(name string) *Repo {
+		git(tmpDir, "clone", "-q", "--depth", "1", "file://"+upstream, name)
+		git(filepath.Join(tmpDir, name), "checkout", "-q", "--detach")
+		repo, err := OpenRepo(filepath.Join(tmpDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	}
+
+	repo := clone("online")
+	if !repo.Shallow || !repo.DetachedHEAD {
+		t.Errorf("got Shallow %v and DetachedHEAD %v, want both true", repo.Shallow, repo.DetachedHEAD)
+	}
+	// Resolving a revision before the shallow clone's history fetches
+	// more of it.
+	if _, err := repo.resolveRevision("HEAD~2"); err != nil {
+		t.Fatal(err)
+	}
+	if commits, err := repo.firstParentCommits("HEAD~2", "HEAD"); err != nil || len(commits) != 2 {
+		t.Errorf("got commits %v (error %v), want 2", commits, err)
+	}
+	if repo.Shallow {
+		t.Error("after fetching all history, got Shallow true")
+	}
+
+	defer func(orig bool) { GlobalOpt.Offline = orig }(GlobalOpt.Offline)
+	GlobalOpt.Offline = true
+	repo = clone("offline")
+	if _, err := repo.resolveRevision("HEAD~2"); err == nil || !strings.Contains(err.Error(), "shallow clone") {
+		t.Errorf("in offline mode, got error %v, want one explaining the shallow clone", err)
+	}
+	if ancestors, err := repo.firstParentAncestors(repo.CommitID, 5); err == nil || len(ancestors) != 0 {
+		t.Errorf("in offline mode, got ancestors %v (error %v), want none and an error", ancestors, err)
+	}
+}
+
+func TestOpenRepo_noCommits(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "srclib-no-commits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cmd := exec.Command("git", "init", "-q")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git init: %s\n%s", err, out)
+	}
+	if _, err := OpenRepo(dir); err == nil || !strings.Contains(err.Error(), "no commits yet") {
+		t.Errorf("got error %v, want one saying the repo has no commits", err)
+	}
+}
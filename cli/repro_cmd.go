@@ -0,0 +1,196 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/grapher"
+	"sourcegraph.com/sourcegraph/srclib/plan"
+	"sourcegraph.com/sourcegraph/srclib/toolchain"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func init() {
+	c, err := CLI.AddCommand("repro",
+		"re-run a source unit's graph step",
+		"The repro command re-runs the graph step for a single source unit in the same environment (toolchain, execution method, and env vars) that was recorded when it was built, and prints the graph output. Only the env vars that commonly affect tools (such as PATH, GOPATH, and JAVA_HOME) and those named in $SRCLIB_RECORD_ENV are recorded; the others keep their current values. Differences between the recorded environment and the current one are reported, to help debug discrepancies between builds.",
+		&reproCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	SetDefaultCommitIDOpt(c)
+}
+
+type ReproCmd struct {
+	CommitID string `long:"commit" description:"commit ID whose build data to use"`
+
+	Args struct {
+		Unit string `name:"UNIT" description:"name or ID of the source unit to re-graph"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var reproCmd ReproCmd
+
+func (c *ReproCmd) Execute(args []string) error {
+	repo, err := OpenLocalRepo()
+	if err != nil {
+		return err
+	}
+	if repo == nil || repo.RootDir == "" {
+		return fmt.Errorf("repro must be run inside a repository")
+	}
+	// Tools run at the top-level dir of the repository (as they do
+	// during `src make`).
+	if err := os.Chdir(repo.RootDir); err != nil {
+		return err
+	}
+	bdfs, err := GetBuildDataFS(c.CommitID)
+	if err != nil {
+		return err
+	}
+	if bdfs == nil {
+		return fmt.Errorf("no build data found for commit %q", c.CommitID)
+	}
+	commitFS := rwvfs.Walkable(bdfs)
+
+	u, err := findSourceUnit(commitFS, c.Args.Unit)
+	if err != nil {
+		return err
+	}
+
+	envFile := plan.SourceUnitDataFilename(&toolchain.Env{}, u)
+	var env *toolchain.Env
+	if err := readJSONFileFS(commitFS, envFile, &env); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no recorded environment for source unit %s (expected it at %s); rebuild with `src make` to record it", u.ID(), envFile)
+		}
+		return err
+	}
+
+	mode, err := toolchain.ModeByName(env.Mode)
+	if err != nil {
+		return err
+	}
+	if current, err := toolchain.CaptureEnv(env.Toolchain, env.Subcmd, mode); err != nil {
+		log.Printf("Warning: couldn't determine the current environment of %s %s: %s", env.Toolchain, env.Subcmd, err)
+	} else if diffs := env.Diff(current); len(diffs) > 0 {
+		log.Printf("The current environment differs from the recorded environment (recorded != current):")
+		for _, d := range diffs {
+			log.Printf(" - %s", d)
+		}
+	}
+
+	cmd, err := reproCommand(env, mode)
+	if err != nil {
+		return err
+	}
+	unitFile, err := commitFS.Open(plan.SourceUnitDataFilename(unit.SourceUnit{}, u))
+	if err != nil {
+		return err
+	}
+	defer unitFile.Close()
+	cmd.Stdin = unitFile
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if GlobalOpt.Verbose {
+		log.Printf("Running tool: %v", cmd.Args)
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("command %v failed: %s", cmd.Args, err)
+	}
+
+	var o *graph.Output
+	if err := json.Unmarshal(out.Bytes(), &o); err != nil {
+		return err
+	}
+	if err := grapher.NormalizeData(repo.URI(), u.Type, ".", o); err != nil {
+		return err
+	}
+	PrintJSON(o, "")
+	return nil
+}
+
+// findSourceUnit returns the source unit in the build data whose name
+// or ID is unitSpec.
+func findSourceUnit(commitFS rwvfs.WalkableFileSystem, unitSpec string) (*unit.SourceUnit, error) {
+	var matches []*unit.SourceUnit
+	for _, unitFile := range getSourceUnits(commitFS, nil) {
+		var u *unit.SourceUnit
+		if err := readJSONFileFS(commitFS, unitFile, &u); err != nil {
+			return nil, err
+		}
+		if SourceUnitMatchesArgs([]string{unitSpec}, u) {
+			matches = append(matches, u)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no source unit found matching %q", unitSpec)
+	case 1:
+		return matches[0], nil
+	}
+	ids := make([]string, len(matches))
+	for i, u := range matches {
+		ids[i] = string(u.ID())
+	}
+	return nil, fmt.Errorf("source unit %q is ambiguous; specify one of: %s", unitSpec, strings.Join(ids, ", "))
+}
+
+// reproCommand returns the command that re-runs the tool recorded in
+// env (opened with mode).
+func reproCommand(env *toolchain.Env, mode toolchain.Mode) (*exec.Cmd, error) {
+	tool, err := toolchain.OpenTool(env.Toolchain, env.Subcmd, mode)
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := tool.Command()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = reproEnv(os.Environ(), env.Vars)
+	return cmd, nil
+}
+
+// reproEnv returns the current environment variables (which include
+// those that weren't recorded, such as credentials), with the values
+// of the recorded ones replaced by their recorded values.
+func reproEnv(current, recorded []string) []string {
+	values := map[string]string{}
+	var names []string
+	for _, kv := range recorded {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if _, dup := values[parts[0]]; !dup {
+			names = append(names, parts[0])
+		}
+		values[parts[0]] = parts[1]
+	}
+
+	env := make([]string, 0, len(current)+len(names))
+	seen := map[string]bool{}
+	for _, kv := range current {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if v, ok := values[name]; ok {
+			kv = name + "=" + v
+			seen[name] = true
+		}
+		env = append(env, kv)
+	}
+	for _, name := range names {
+		if !seen[name] {
+			env = append(env, name+"="+values[name])
+		}
+	}
+	return env
+}
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/toolchain"
+)
+
+func TestReproEnv(t *testing.T) {
+	current := []string{"PATH=/usr/bin", "GH_PAT=secret", "HOME=/home/u"}
+	recorded := []string{"PATH=/bin", "GOPATH=/go"}
+	want := []string{"PATH=/bin", "GH_PAT=secret", "HOME=/home/u", "GOPATH=/go"}
+	if got := reproEnv(current, recorded); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestReproCommand(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "srclib-repro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer func(orig string) {
+		srclib.Path = orig
+	}(srclib.Path)
+	srclib.Path = tmpdir
+
+	var extension string
+	if runtime.GOOS == "windows" {
+		extension = ".exe"
+	}
+	program := filepath.Join(tmpdir, "a", "a", ".bin", "a"+extension)
+	for f, mode := range map[string]os.FileMode{program: 0700, filepath.Join(tmpdir, "a", "a", "Srclibtoolchain"): 0700} {
+		if err := os.MkdirAll(filepath.Dir(f), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(f, nil, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	env := &toolchain.Env{Toolchain: "a/a", Subcmd: "graph", Mode: "program", Vars: []string{"SRCLIB_REPRO_TEST=recorded"}}
+	cmd, err := reproCommand(env, toolchain.AsProgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{program, "graph"}; !reflect.DeepEqual(cmd.Args, want) {
+		t.Errorf("got args %v, want %v", cmd.Args, want)
+	}
+	var found bool
+	for _, kv := range cmd.Env {
+		if kv == "SRCLIB_REPRO_TEST=recorded" {
+			found = true
+		}
+	}
+	if !found || len(cmd.Env) < len(os.Environ()) {
+		t.Errorf("got env %v, want the current env plus the recorded var", cmd.Env)
+	}
+}
@@ -26,6 +26,9 @@ type SelfUpdateCmd struct {
 }
 
 func (c *SelfUpdateCmd) Execute(_ []string) error {
+	if err := checkOnline("selfupdate"); err != nil {
+		return err
+	}
 	url := "https://srclib-release.s3.amazonaws.com/"
 	var u = &selfupdate.Updater{
 		CurrentVersion: Version,
@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SignatureOpt holds the options that control how def signatures are
+// displayed.
+type SignatureOpt struct {
+	Signature string `long:"signature" description:"how to show def signatures: full (wrapped at --width), short (the first line, truncated to --width), or none" default:"full" value-name:"full|short|none"`
+	Width     int    `long:"width" description:"max width of signature lines (0 for no limit)" default:"80" value-name:"COLS"`
+}
+
+// signatureIndent is the indentation of wrapped signature lines.
+const signatureIndent = "    "
+
+func (o *SignatureOpt) validate() error {
+	switch o.Signature {
+	case "full", "short", "none":
+		return nil
+	default:
+		return fmt.Errorf("unrecognized --signature value: %q (valid values are full, short, none)", o.Signature)
+	}
+}
+
+// format formats sig (as returned by defSignature) according to the
+// options. It returns the empty string if signatures aren't shown.
+func (o *SignatureOpt) format(sig string) string {
+	switch o.Signature {
+	case "none":
+		return ""
+	case "short":
+		return shortSignature(sig, o.Width)
+	}
+	if o.Width <= 0 {
+		return sig
+	}
+	lines := strings.Split(sig, "\n")
+	for i, line := range lines {
+		lines[i] = wrapSignature(line, o.Width, signatureIndent)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// shortSignature returns the first line of sig, truncated to width
+// columns (if width > 0).
+func shortSignature(sig string, width int) string {
+	short := sig
+	if i := strings.Index(short, "\n"); i != -1 {
+		short = strings.TrimRight(short[:i], " {") + " ..."
+	}
+	if r := []rune(short); width > 3 && len(r) > width {
+		short = string(r[:width-3]) + "..."
+	}
+	return short
+}
+
+// wrapSignature wraps a line of a signature that is longer than width
+// columns after the commas and opening brackets of its (outermost)
+// parameter and type parameter lists, indenting each continuation line with indent
+// (in addition to the line's own indentation).
+func wrapSignature(line string, width int, indent string) string {
+	r := []rune(line)
+	if len(r) <= width {
+		return line
+	}
+	indent = line[:len(line)-len(strings.TrimLeft(line, " \t"))] + indent
+
+	// breaks are the positions at which the line may be broken.
+	var breaks []int
+	depth := 0
+	for i, c := range r {
+		switch c {
+		case '(', '[', '{', '<':
+			if c == '<' && i+1 < len(r) && r[i+1] == '-' {
+				continue // a Go channel direction, not a bracket
+			}
+			depth++
+			if depth == 1 && i+1 < len(r) && !strings.ContainsRune(")]}>", r[i+1]) {
+				breaks = append(breaks, i+1)
+			}
+		case ')', ']', '}', '>':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 1 && i+1 < len(r) {
+				breaks = append(breaks, i+1)
+			}
+		}
+	}
+
+	var lines []string
+	start, prefix := 0, ""
+	for len(prefix)+len(r)-start > width {
+		// Break at the last break that fits, or else at the first
+		// one (if a parameter is itself too long).
+		at := -1
+		for _, b := range breaks {
+			if b <= start {
+				continue
+			}
+			if at == -1 || len(prefix)+b-start <= width {
+				at = b
+			}
+			if len(prefix)+b-start > width {
+				break
+			}
+		}
+		if at == -1 {
+			break
+		}
+		lines = append(lines, prefix+strings.TrimRight(string(r[start:at]), " "))
+		start, prefix = at, indent
+		for start < len(r) && r[start] == ' ' {
+			start++
+		}
+	}
+	lines = append(lines, prefix+string(r[start:]))
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,48 @@
+package cli
+
+import "testing"
+
+func TestWrapSignature(t *testing.T) {
+	tests := []struct {
+		line  string
+		width int
+		want  string
+	}{
+		{"func F(a int) error", 80, "func F(a int) error"},
+		{
+			"func Copy(dst io.Writer, src io.Reader, buf []byte) (written int64, err error)", 40,
+			"func Copy(dst io.Writer, src io.Reader,\n    buf []byte) (written int64,\n    err error)",
+		},
+		{
+			"  Map<String, List<Integer>> build(String name)", 20,
+			"  Map<String,\n      List<Integer>> build(\n      String name)",
+		},
+		{"func F(ch <-chan int, done chan<- bool)", 25, "func F(ch <-chan int,\n    done chan<- bool)"},
+		{"func F(f func(a, b int), c int)", 20, "func F(\n    f func(a, b int),\n    c int)"},
+		{"func VeryLongFunctionNameWithoutParams()", 10, "func VeryLongFunctionNameWithoutParams()"},
+	}
+	for _, test := range tests {
+		if got := wrapSignature(test.line, test.width, "    "); got != test.want {
+			t.Errorf("%q (width %d): got\n%s\nwant\n%s", test.line, test.width, got, test.want)
+		}
+	}
+}
+
+func TestSignatureOptFormat(t *testing.T) {
+	sig := "type T struct {\n\tA int\n}"
+	if got, want := (&SignatureOpt{Signature: "short", Width: 80}).format(sig), "type T struct ..."; got != want {
+		t.Errorf("got short signature %q, want %q", got, want)
+	}
+	if got, want := (&SignatureOpt{Signature: "short", Width: 10}).format("func F(a, b int)"), "func F(..."; got != want {
+		t.Errorf("got short signature %q, want %q", got, want)
+	}
+	if got := (&SignatureOpt{Signature: "none"}).format(sig); got != "" {
+		t.Errorf("got signature %q with --signature=none", got)
+	}
+	if got := (&SignatureOpt{Signature: "full", Width: 0}).format(sig); got != sig {
+		t.Errorf("got full signature %q, want it unchanged", got)
+	}
+	if err := (&SignatureOpt{Signature: "x"}).validate(); err == nil {
+		t.Error("got no error for an invalid --signature value")
+	}
+}
@@ -0,0 +1,238 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+func init() {
+	c, err := CLI.AddCommand("stats",
+		"show statistics about build data",
+		"The stats command computes statistics about the build data in the store.",
+		&statsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	churnRefsC, err := c.AddCommand("churn-refs",
+		"show how refs to a repo's defs fared across a change",
+		"The churn-refs command reports how many refs (in dependent code) to the repo's defs at the --from commit survived, broke, or were updated by the change to the --to commit. A ref survived if its def exists unchanged at --to, was updated if its def exists but its kind, name, or signature data changed, and broke if its def no longer exists. The build data of both commits must have been imported into the store.",
+		&statsChurnRefsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	SetDefaultRepoOpt(churnRefsC)
+}
+
+type StatsCmd struct{}
+
+var statsCmd StatsCmd
+
+func (c *StatsCmd) Execute(args []string) error { return nil }
+
+type StatsChurnRefsCmd struct {
+	Repo string `long:"repo" description:"repo whose defs are referenced"`
+	From string `long:"from" description:"commit ID before the change" required:"yes"`
+	To   string `long:"to" description:"commit ID after the change" required:"yes"`
+
+	Internal bool `long:"internal" description:"also count refs from the repo itself (at the --from commit)"`
+	Top      int  `short:"n" long:"top" description:"number of most-referenced broken and updated defs to list" default:"10"`
+	JSON     bool `long:"json" description:"print the stats as JSON"`
+}
+
+var statsChurnRefsCmd StatsChurnRefsCmd
+
+// refChurn holds the stats computed by the churn-refs command.
+type refChurn struct {
+	Repo     string
+	From, To string
+
+	Survived int
+	Updated  int
+	Broke    int
+
+	// Unknown is the number of refs whose def doesn't exist at the
+	// --from commit (e.g., because the referencing code was built
+	// against a different version), which therefore can't be
+	// classified.
+	Unknown int
+
+	// BrokenDefs and UpdatedDefs list the defs whose refs broke or
+	// were updated, most-referenced first.
+	BrokenDefs  []*defRefCount `json:",omitempty"`
+	UpdatedDefs []*defRefCount `json:",omitempty"`
+}
+
+type defRefCount struct {
+	graph.DefKey
+	Refs int
+}
+
+func (c *StatsChurnRefsCmd) Execute(args []string) error {
+	s, err := OpenStoreReadOnly()
+	if err != nil {
+		return err
+	}
+	us, ok := s.(store.UnitStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing defs and refs", s)
+	}
+
+	// Refs from other repos are only present in multi-repo
+	// stores. Single-repo stores only contain the repo's own refs,
+	// and their defs and refs have no repo.
+	repo := c.Repo
+	internal := c.Internal
+	if _, multi := s.(store.MultiRepoStore); !multi {
+		repo = ""
+		internal = true
+	} else if repo == "" {
+		return fmt.Errorf("no repo specified (use --repo)")
+	}
+
+	defsAt := func(commitID string) (map[graph.DefKey]*graph.Def, error) {
+		filters := []store.DefFilter{store.ByCommitIDs(commitID)}
+		if repo != "" {
+			filters = append(filters, store.ByRepos(repo))
+		}
+		defs, err := us.Defs(filters...)
+		if err != nil {
+			return nil, err
+		}
+		if len(defs) == 0 {
+			return nil, fmt.Errorf("no defs found for commit %s (is its build data imported into the store?)", commitID)
+		}
+		return defsByUnitAndPath(defs), nil
+	}
+	fromDefs, err := defsAt(c.From)
+	if err != nil {
+		return err
+	}
+	toDefs, err := defsAt(c.To)
+	if err != nil {
+		return err
+	}
+
+	refs, err := us.Refs(store.AbsRefFilterFunc(func(ref *graph.Ref) bool {
+		if ref.DefRepo != repo {
+			return false
+		}
+		if ref.Repo == repo {
+			return internal && ref.CommitID == c.From
+		}
+		return true
+	}))
+	if err != nil {
+		return err
+	}
+
+	churn := refChurn{Repo: c.Repo, From: c.From, To: c.To}
+	broken := map[graph.DefKey]int{}
+	updated := map[graph.DefKey]int{}
+	for _, ref := range refs {
+		key := graph.DefKey{UnitType: ref.DefUnitType, Unit: ref.DefUnit, Path: ref.DefPath}
+		if key.UnitType == "" {
+			key.UnitType = ref.UnitType
+		}
+		if key.Unit == "" {
+			key.Unit = ref.Unit
+		}
+
+		fromDef, present := fromDefs[key]
+		if !present {
+			churn.Unknown++
+			continue
+		}
+		toDef, present := toDefs[key]
+		switch {
+		case !present:
+			churn.Broke++
+			broken[key]++
+		case defChanged(fromDef, toDef):
+			churn.Updated++
+			updated[key]++
+		default:
+			churn.Survived++
+		}
+	}
+	churn.BrokenDefs = topDefRefCounts(broken, c.Top)
+	churn.UpdatedDefs = topDefRefCounts(updated, c.Top)
+
+	if c.JSON {
+		PrintJSON(churn, "  ")
+		return nil
+	}
+
+	total := churn.Survived + churn.Updated + churn.Broke
+	colorable.Printf("Refs to defs in %s, from %s to %s:\n", c.Repo, c.From, c.To)
+	colorable.Printf("  survived: %6d (%.1f%%)\n", churn.Survived, percent(churn.Survived, total))
+	colorable.Printf("  updated:  %6d (%.1f%%)\n", churn.Updated, percent(churn.Updated, total))
+	colorable.Printf("  broke:    %6d (%.1f%%)\n", churn.Broke, percent(churn.Broke, total))
+	if churn.Unknown > 0 {
+		colorable.Printf("  (%d refs to defs not found at %s were not counted)\n", churn.Unknown, c.From)
+	}
+	printDefs := func(title string, defs []*defRefCount) {
+		if len(defs) == 0 {
+			return
+		}
+		colorable.Println()
+		colorable.Println(title)
+		for _, d := range defs {
+			colorable.Printf("  %6d  %s (%s %s)\n", d.Refs, d.Path, d.UnitType, d.Unit)
+		}
+	}
+	printDefs("Most-referenced broken defs:", churn.BrokenDefs)
+	printDefs("Most-referenced updated defs:", churn.UpdatedDefs)
+	return nil
+}
+
+// defsByUnitAndPath indexes defs by their source unit and path
+// (i.e., by their def key without the repo and commit ID), which
+// identify the same def across commits.
+func defsByUnitAndPath(defs []*graph.Def) map[graph.DefKey]*graph.Def {
+	m := make(map[graph.DefKey]*graph.Def, len(defs))
+	for _, def := range defs {
+		m[graph.DefKey{UnitType: def.UnitType, Unit: def.Unit, Path: def.Path}] = def
+	}
+	return m
+}
+
+// defChanged returns whether the def's interface (as opposed to its
+// location) differs between a and b.
+func defChanged(a, b *graph.Def) bool {
+	return a.Name != b.Name || a.Kind != b.Kind || !bytes.Equal(a.Data, b.Data)
+}
+
+// topDefRefCounts returns the n defs with the most refs in counts
+// (all of them if n <= 0).
+func topDefRefCounts(counts map[graph.DefKey]int, n int) []*defRefCount {
+	defs := make([]*defRefCount, 0, len(counts))
+	for key, refs := range counts {
+		defs = append(defs, &defRefCount{DefKey: key, Refs: refs})
+	}
+	sort.Sort(defRefCountsByRefs(defs))
+	if n > 0 && len(defs) > n {
+		defs = defs[:n]
+	}
+	return defs
+}
+
+type defRefCountsByRefs []*defRefCount
+
+func (v defRefCountsByRefs) Len() int      { return len(v) }
+func (v defRefCountsByRefs) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v defRefCountsByRefs) Less(i, j int) bool {
+	if v[i].Refs != v[j].Refs {
+		return v[i].Refs > v[j].Refs
+	}
+	return v[i].Path < v[j].Path
+}
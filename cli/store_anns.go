@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/srclib/ann"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+type StoreAnnsCmd struct {
+	Repo     string `long:"repo"`
+	CommitID string `long:"commit"`
+	UnitType string `long:"unit-type"`
+	Unit     string `long:"unit"`
+
+	File  string `long:"file" description:"only show anns in this file"`
+	Start uint32 `long:"start" description:"with --file, only show anns that overlap the range starting at this byte offset"`
+	End   uint32 `long:"end" description:"with --file, only show anns that overlap the range ending at this byte offset"`
+
+	DefPath string `long:"def-path" description:"only show anns attached to the def with this path"`
+
+	Types []string `long:"type" description:"only show anns of this type (e.g., lint, coverage, or deprecated); can be repeated" value-name:"TYPE"`
+
+	Import string `long:"import" description:"replace the anns of the source unit given by --unit-type and --unit (at --commit, in --repo) with those in this JSON file (a list of anns; - for stdin), leaving its defs and refs as they are" value-name:"FILE"`
+}
+
+var storeAnnsCmd StoreAnnsCmd
+
+func (c *StoreAnnsCmd) filters() ([]store.AnnFilter, error) {
+	var fs []store.AnnFilter
+	if (c.UnitType != "") != (c.Unit != "") {
+		return nil, errors.New("must specify either both or neither of --unit-type and --unit (to filter by source unit)")
+	}
+	if c.UnitType != "" {
+		fs = append(fs, store.ByUnits(unit.ID2{Type: c.UnitType, Name: c.Unit}))
+	}
+	if c.CommitID != "" {
+		fs = append(fs, store.ByCommitIDs(c.CommitID))
+	}
+	if c.Repo != "" {
+		fs = append(fs, store.ByRepos(c.Repo))
+	}
+	if c.File != "" {
+		file := path.Clean(c.File)
+		if c.Start != 0 || c.End != 0 {
+			end := c.End
+			if end == 0 {
+				end = ^uint32(0)
+			}
+			fs = append(fs, store.ByFileRange(file, c.Start, end))
+		} else {
+			fs = append(fs, store.ByFiles(file))
+		}
+	} else if c.Start != 0 || c.End != 0 {
+		return nil, errors.New("--start and --end require --file")
+	}
+	if c.DefPath != "" {
+		fs = append(fs, store.ByDefPath(c.DefPath))
+	}
+	if len(c.Types) > 0 {
+		fs = append(fs, store.ByAnnTypes(c.Types...))
+	}
+	return fs, nil
+}
+
+func (c *StoreAnnsCmd) Execute(args []string) error {
+	if c.Import != "" {
+		return c.importAnns()
+	}
+
+	fs, err := c.filters()
+	if err != nil {
+		return err
+	}
+	s, err := OpenStoreReadOnly()
+	if err != nil {
+		return err
+	}
+	as, ok := s.(store.AnnStore)
+	if !ok {
+		return fmt.Errorf("store of type %T doesn't hold anns", s)
+	}
+	anns, err := as.Anns(fs...)
+	if err != nil {
+		return err
+	}
+	if anns == nil {
+		anns = []*ann.Ann{}
+	}
+	PrintJSON(anns, "  ")
+	return nil
+}
+
+// importAnns replaces a source unit's anns with those in the file
+// given by --import.
+func (c *StoreAnnsCmd) importAnns() error {
+	if c.UnitType == "" || c.Unit == "" || c.CommitID == "" {
+		return errors.New("--import requires --unit-type, --unit, and --commit")
+	}
+	if storeCmd.Type == "MultiRepoStore" && c.Repo == "" {
+		return errors.New("--import requires --repo in a MultiRepoStore")
+	}
+	if storeCmd.ReadOnly {
+		return errors.New("can't import anns into a store opened with --read-only")
+	}
+
+	var r io.Reader = os.Stdin
+	if c.Import != "-" {
+		f, err := os.Open(c.Import)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var anns []*ann.Ann
+	if err := json.NewDecoder(r).Decode(&anns); err != nil {
+		return fmt.Errorf("reading anns from %s: %s", c.Import, err)
+	}
+
+	s, err := storeCmd.store()
+	if err != nil {
+		return err
+	}
+	if storeCmd.isLocalFS() {
+		// Don't rewrite the unit's ann file while it's being read.
+		unlock, err := lockStore(storeCmd.root(), true, true)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	u := unit.ID2{Type: c.UnitType, Name: c.Unit}
+	if err := store.ImportAnns(s, c.Repo, c.CommitID, u, anns); err != nil {
+		return err
+	}
+	colorable.Printf("Imported %d anns for source unit %s %s\n", len(anns), u.Type, u.Name)
+	return nil
+}
@@ -0,0 +1,195 @@
+package cli
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+type StoreExportCmd struct {
+	Output   string `short:"o" long:"output" description:"archive file to write (gzipped if it ends in .gz or .tgz)" required:"yes" value-name:"FILE"`
+	Repo     string `long:"repo" description:"repo of the commit to export (required for MultiRepoStore stores)"`
+	CommitID string `long:"commit" description:"commit ID of the commit to export"`
+}
+
+var storeExportCmd StoreExportCmd
+
+func (c *StoreExportCmd) Execute(args []string) error {
+	if c.CommitID == "" {
+		return errors.New("no commit to export (use --commit)")
+	}
+	gzipped, err := archiveGzipped(c.Output)
+	if err != nil {
+		return err
+	}
+	if storeCmd.isLocalFS() {
+		// Don't let an import publish the commit mid-export.
+		unlock, err := lockStore(storeCmd.root(), false, false)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	repoFS, err := storeCmd.repoFS(c.Repo)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(c.Output)
+	if err != nil {
+		return err
+	}
+	var w io.WriteCloser = f
+	if gzipped {
+		w = gzip.NewWriter(f)
+	}
+	n, err := store.ExportCommit(w, repoFS, store.ArchiveManifest{Repo: c.Repo, CommitID: c.CommitID})
+	if gzipped {
+		if err2 := w.Close(); err == nil {
+			err = err2
+		}
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(c.Output)
+		return err
+	}
+	colorable.Printf("Exported %d files of commit %s to %s\n", n, c.CommitID, c.Output)
+	return nil
+}
+
+// archiveGzipped returns whether the store archive file is gzipped,
+// according to its name.
+func archiveGzipped(file string) (bool, error) {
+	switch {
+	case strings.HasSuffix(file, ".gz"), strings.HasSuffix(file, ".tgz"):
+		return true, nil
+	case strings.HasSuffix(file, ".zst"):
+		return false, fmt.Errorf("zstd-compressed archives are not supported (use %s.gz for a gzipped archive)", strings.TrimSuffix(file, ".zst"))
+	}
+	return false, nil
+}
+
+// repoFS returns the filesystem of repo's repo store (whose dirs are
+// commits) in the store.
+func (c *StoreCmd) repoFS(repo string) (rwvfs.FileSystem, error) {
+	fs, err := c.backendFS()
+	if err != nil {
+		return nil, err
+	}
+	switch c.Type {
+	case "RepoStore":
+		return fs, nil
+	case "MultiRepoStore":
+		if repo == "" {
+			return nil, errors.New("no repo given (use --repo)")
+		}
+		return rwvfs.Sub(fs, path.Join(store.DefaultRepoPaths.RepoToPath(repo)...)), nil
+	}
+	return nil, fmt.Errorf("unrecognized store --type value: %q (valid values are RepoStore, MultiRepoStore)", c.Type)
+}
+
+// importArchive imports the commit in the archive c.Archive, replacing
+// the commit's data in the store (if any).
+func (c *StoreImportCmd) importArchive() error {
+	if c.Sample || c.Unit != "" || c.UnitType != "" {
+		return errors.New("--archive imports a whole commit, so it can't be used with --sample, --unit, or --unit-type")
+	}
+	if storeCmd.ReadOnly {
+		return errors.New("can't import into a store opened with --read-only")
+	}
+	gzipped, err := archiveGzipped(c.Archive)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(c.Archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if gzipped {
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	}
+	ar, err := store.NewArchiveReader(r)
+	if err != nil {
+		return fmt.Errorf("%s: %s", c.Archive, err)
+	}
+	c.Repo, c.CommitID = ar.Manifest.Repo, ar.Manifest.CommitID
+	if c.DryRun {
+		colorable.Printf("Would import commit %s of %s from %s\n", c.CommitID, c.Repo, c.Archive)
+		return nil
+	}
+
+	// Like imports from build data, extract the commit to a
+	// temporary store and then publish it, if the store allows.
+	staged, err := storeCmd.stageCommit(c.Repo, c.CommitID)
+	if err != nil {
+		return err
+	}
+	var n int
+	if staged != nil {
+		dir := filepath.Join(staged.tmpDir, c.CommitID)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			staged.remove()
+			return err
+		}
+		if n, err = ar.Extract(rwvfs.OS(dir)); err != nil {
+			staged.remove()
+			return err
+		}
+		if err := staged.publish(); err != nil {
+			return err
+		}
+	} else {
+		repoFS, err := storeCmd.repoFS(c.Repo)
+		if err != nil {
+			return err
+		}
+		commitFS := rwvfs.Walkable(rwvfs.Sub(repoFS, c.CommitID))
+		if _, err := commitFS.Stat("."); err == nil {
+			if err := buildstore.RemoveAll(".", commitFS); err != nil {
+				return err
+			}
+		}
+		if err := rwvfs.MkdirAll(repoFS, c.CommitID); err != nil {
+			return err
+		}
+		if n, err = ar.Extract(commitFS); err != nil {
+			return err
+		}
+		s, err := storeCmd.store()
+		if err != nil {
+			return err
+		}
+		if err := store.SetImportTime(s, c.Repo, c.CommitID, time.Now()); err != nil {
+			return err
+		}
+		if err := storeCmd.indexXRefs(c.Repo, c.CommitID); err != nil {
+			return err
+		}
+	}
+	if !c.Quiet {
+		colorable.Printf("Imported %d files of commit %s from %s\n", n, c.CommitID, c.Archive)
+	}
+	return nil
+}
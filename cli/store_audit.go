@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditEntry is a line of the store daemon's audit log.
+type auditEntry struct {
+	Time   time.Time
+	User   string `json:",omitempty"`
+	Method string
+
+	// Query holds the query's non-default options.
+	Query map[string]interface{} `json:",omitempty"`
+
+	Results int
+	Error   string `json:",omitempty"`
+}
+
+// auditLog is an append-only log of the queries answered by the store
+// daemon, with one JSON-encoded auditEntry per line. When the log
+// reaches maxSize bytes, it is rotated: FILE is renamed to FILE.1
+// (and FILE.1 to FILE.2, etc.), keeping at most keep old logs.
+type auditLog struct {
+	path    string
+	maxSize int64
+	keep    int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func openAuditLog(path string, maxSize int64, keep int) (*auditLog, error) {
+	l := &auditLog{path: path, maxSize: maxSize, keep: keep}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *auditLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, fi.Size()
+	return nil
+}
+
+// rotate renames the current log to path.1 (shifting older logs up
+// and removing the oldest) and starts a new one. The log at path is
+// reopened even if a rename fails, so that later entries are still
+// logged (to the log that wasn't rotated).
+func (l *auditLog) rotate() error {
+	closeErr := l.f.Close()
+	l.f = nil
+	err := l.shift()
+	if err == nil {
+		err = closeErr
+	}
+	if openErr := l.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// shift renames the logs for rotate.
+func (l *auditLog) shift() error {
+	if l.keep <= 0 {
+		return os.Remove(l.path)
+	}
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.keep))
+	for i := l.keep - 1; i >= 1; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(l.path, l.path+".1")
+}
+
+// Log appends e to the log.
+func (l *auditLog) Log(e *auditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		// The log couldn't be reopened when it was last rotated.
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			if l.f == nil {
+				return err
+			}
+			log.Printf("Warning: rotating audit log %s: %s", l.path, err)
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+func (l *auditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// auditQuery returns the options of a store query (one of the store
+// subcommands' option structs) that don't have their zero value.
+func auditQuery(args interface{}) map[string]interface{} {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return nil
+	}
+	var opts map[string]interface{}
+	if err := json.Unmarshal(data, &opts); err != nil {
+		return nil
+	}
+	for k, v := range opts {
+		switch v {
+		case nil, false, "", float64(0):
+			delete(opts, k)
+		}
+	}
+	return opts
+}
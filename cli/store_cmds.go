@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
 	"sync"
@@ -26,11 +28,14 @@ import (
 
 	"sourcegraph.com/sourcegraph/go-flags"
 	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/ann"
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
 	"sourcegraph.com/sourcegraph/srclib/config"
 	"sourcegraph.com/sourcegraph/srclib/graph"
 	"sourcegraph.com/sourcegraph/srclib/grapher"
 	"sourcegraph.com/sourcegraph/srclib/plan"
 	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/toolchain"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
 
@@ -43,17 +48,6 @@ func init() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	lrepo, _ := OpenLocalRepo()
-	if lrepo != nil && lrepo.RootDir != "" {
-		absDir, err := os.Getwd()
-		if err != nil {
-			log.Fatal(err)
-		}
-		relDir, err := filepath.Rel(absDir, lrepo.RootDir)
-		if err == nil {
-			SetOptionDefaultValue(storeC.Group, "root", filepath.Join(relDir, store.SrclibStoreDir))
-		}
-	}
 
 	InitStoreCmds(storeC)
 }
@@ -70,6 +64,17 @@ func InitStoreCmds(c *flags.Command) {
 	SetDefaultRepoOpt(importC)
 	SetDefaultCommitIDOpt(importC)
 
+	exportC, err := c.AddCommand("export",
+		"export a commit to an archive",
+		"The export command writes the data and indexes of a commit in the store to a single archive file (a tar file, gzipped if FILE ends in .gz or .tgz), so that they can be attached to a release and restored in another store with 'src store import --archive FILE' without re-graphing the commit.",
+		&storeExportCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	SetDefaultRepoOpt(exportC)
+	SetDefaultCommitIDOpt(exportC)
+
 	_, err = c.AddCommand("indexes",
 		"list indexes",
 		"The indexes command lists all of a store's indexes that match the specified criteria.",
@@ -88,6 +93,24 @@ func InitStoreCmds(c *flags.Command) {
 		log.Fatal(err)
 	}
 
+	_, err = c.AddCommand("prewarm",
+		"build and verify all indexes",
+		"The prewarm command builds all indexes that match the specified index criteria (stale or not), then reads each one back to verify that it exists and isn't corrupt. It exits with a non-zero status if any index failed to build or verify. Run it in CI before publishing a store as an artifact, so that consumers never build indexes lazily.",
+		&storePrewarmCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("verify",
+		"check indexes for corruption",
+		"The verify command checks all indexes that match the specified index criteria for corruption. It verifies each index file's checksum, and it checks each source unit index against the unit's def and ref data files: every byte offset in the index must begin a def or ref, and every def path, file, etc., in the data files must be in the index. It exits with a non-zero status if any index has a problem, unless --repair is given and all such indexes are successfully rebuilt.",
+		&storeVerifyCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	_, err = c.AddCommand("repos",
 		"list repos",
 		"The repos command lists all repos that match a filter.",
@@ -106,6 +129,15 @@ func InitStoreCmds(c *flags.Command) {
 		log.Fatal(err)
 	}
 
+	_, err = c.AddCommand("labels",
+		"list commit labels",
+		"The labels command lists the labels (e.g., branch names) given to imported commits with 'import --label', and the commit each currently refers to. With a LABEL argument, it lists every commit that has had that label, most recent first.",
+		&storeLabelsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	_, err = c.AddCommand("units",
 		"list units",
 		"The units command lists all units that match a filter.",
@@ -133,16 +165,111 @@ func InitStoreCmds(c *flags.Command) {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	_, err = c.AddCommand("xrefs",
+		"list refs to a def from other repos",
+		"The xrefs command lists, as JSON, the refs to the specified def from all other repos in a MultiRepoStore, grouped by the source unit they're in. It reads them from the store's xref index, which imports keep up to date, so it doesn't need to scan every repo. Use --reindex to rebuild the index from the data of all commits in the store (e.g., for stores written by older versions of src).",
+		&storeXRefsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("anns",
+		"list or import anns (lint findings, coverage, etc.)",
+		"The anns command lists, as JSON, the annotations (such as lint findings, coverage, and deprecation marks) that match a filter. Anns are attached to ranges of files or to defs, and are imported with the rest of a source unit's graph data. Use --import to replace a source unit's anns with those produced by another tool (e.g., a linter run after the unit was built) without re-importing its defs and refs.",
+		&storeAnnsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("stats",
+		"show counts, index sizes, and read times for each commit",
+		"The stats command prints, for each commit in the store (or those given by --repo and --commit), the number of source units, defs, refs, and docs; the number and total size of its indexes and when they were last built; and how long it took to read all of its defs and refs, which helps to plan capacity and to find out why a store is slow. With --top-defs, it also lists the defs with the most refs from other source units or repos.",
+		&storeStatsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("compact",
+		"recompress the store's data files",
+		"The compact command rewrites the def and ref data files of every commit in the store with the given compressor (gzip by default; none decompresses them). Indexes remain valid, so nothing needs to be re-imported. Stop 'src store serve' while compacting.",
+		&storeCompactCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("dedupe",
+		"share identical files across commits",
+		"The dedupe command moves files (data files, indexes, etc.) that are identical in multiple commits of a repo, such as those of source units that didn't change, to content-addressed blobs that the commits share, and then removes blobs that no commit uses anymore. The store reads the shared files transparently, and re-importing a commit replaces its shared files with its own. Stop 'src store serve' while deduping.",
+		&storeDedupeCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("gc",
+		"remove blobs that no commit uses",
+		"The gc command removes the blobs (written by the dedupe command) that no commit in the store points to anymore, such as after commits are deleted.\n\nWith --keep-last or --keep-rev, it first removes the data and indexes of the commits that are no longer needed: all but each repo's N most recently imported commits, the commits given by --keep-rev, and (in a RepoStore) the current commits of labels. In a MultiRepoStore, --keep-rev is either a full commit ID or REPO@PREFIX (a commit ID prefix of one repo's commits). With --build-data, it also removes the local repository's build data (in "+buildstore.BuildDataDirName+") for commits not kept by the same criteria. Use --dry-run to list what would be removed, and its size, first. Stop 'src store serve' while collecting garbage.",
+		&storeGCCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("serve",
+		"serve store queries from a long-lived daemon",
+		"The serve command keeps the store and its indexes open and answers units, defs, and refs queries over a unix socket (in --socket-dir, by default $XDG_RUNTIME_DIR/srclib or ~/.srclib/run, which only the user can access). While it is running, the units, defs, and refs commands and src query send their queries to it instead of opening the store themselves, as long as they specify the same store type, backend, and root. It caches the indexes of the commits that it serves, and reads a commit's indexes again after the commit is re-imported.\n\nTo share the daemon with a team, run it with --shared-group and a --socket-dir that the team's members use too. The daemon makes the dir and socket accessible to the group, and only answers clients whose user (from the socket's peer credentials) is the daemon's own or is in the group. With --audit-log, it appends a record of each query (with the name of the user that the client runs as, from the peer credentials) to a log file, which it rotates by size.",
+		&storeServeCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// compressorNamed returns the store data file compressor with the
+// given name, or nil for "none" (or "").
+func compressorNamed(name string) (store.Compressor, error) {
+	if name == "" || name == "none" {
+		return nil, nil
+	}
+	c, present := store.Compressors[name]
+	if !present {
+		return nil, fmt.Errorf("unrecognized compressor: %q (valid values are none, %s)", name, strings.Join(store.CompressorNames(), ", "))
+	}
+	return c, nil
 }
 
 // OpenStore is called by all of the store subcommands to open the
 // store.
 var OpenStore func() (interface{}, error) = storeCmd.store
 
+// OpenStoreReadOnly is like OpenStore, but the store it opens never
+// writes to its underlying storage. It is called by commands that
+// only query the store.
+var OpenStoreReadOnly func() (interface{}, error) = storeCmd.readOnlyStore
+
 type StoreCmd struct {
 	Type   string `short:"t" long:"type" description:"the (multi-)repo store type to use (RepoStore, MultiRepoStore, etc.)" default:"RepoStore"`
 	Root   string `short:"r" long:"root" description:"the root of the store (repo clone dir for RepoStore, global path for MultiRepoStore, etc.)" default:".srclib-store"`
 	Config string `long:"config" description:"(rarely used) JSON-encoded config for extra config, specific to each store type"`
+
+	Backend string `long:"backend" description:"the storage backend that holds the store's data (fs: one file per store file; bolt: a single embedded Bolt database)" default:"fs"`
+
+	URL string `long:"store-url" description:"keep the store in object storage at this URL (s3://BUCKET/PREFIX or gs://BUCKET/PREFIX; credentials are read from the environment) instead of in --root with --backend" value-name:"URL"`
+
+	Compress string `long:"compress" description:"compress the def, ref, and ann data files written to the store (gzip or none); compressed files are smaller but are decompressed in full when read, and are read whatever their compression" default:"none"`
+
+	ReadOnly bool `long:"read-only" description:"open the store in read-only mode (writes fail)"`
+
+	NoMmap bool `long:"no-mmap" description:"read index and data files into memory instead of mapping them (use if the store is on a filesystem where mmap is unreliable, such as some network filesystems)"`
+
+	SocketDir string `long:"socket-dir" description:"the dir that holds the store daemon's socket (default: $XDG_RUNTIME_DIR/srclib or ~/.srclib/run); a daemon shared with 'src store serve --shared-group' and its clients must use the same dir" env:"SRCLIB_STORE_SOCKET_DIR" value-name:"DIR"`
+
+	IndexCache string `long:"index-cache" description:"share source unit indexes through this local dir, keyed by a hash of each unit's data, so that stores importing identical data (e.g., other checkouts of the repo or CI clones) copy the indexes instead of building them" env:"SRCLIB_INDEX_CACHE" value-name:"DIR"`
 }
 
 var storeCmd StoreCmd
@@ -152,13 +279,68 @@ func (c *StoreCmd) Execute(args []string) error { return nil }
 // store returns the store specified by StoreCmd's Type and Root
 // options.
 func (c *StoreCmd) store() (interface{}, error) {
-	fs := rwvfs.OS(c.Root)
+	return c.open(c.ReadOnly)
+}
 
-	type createParents interface {
-		CreateParentDirs(bool)
+// readOnlyStore is like store, but the store is always read-only.
+//
+// It also takes a shared lock on an fs-backed store, so that `src
+// store import` doesn't publish a commit mid-query. The lock is held
+// until releaseStoreLocks is called (which long-running processes do
+// after each query) or the process exits.
+func (c *StoreCmd) readOnlyStore() (interface{}, error) {
+	if c.isLocalFS() {
+		unlock, err := lockStore(c.root(), false, false)
+		if err != nil {
+			return nil, err
+		}
+		heldStoreLocksMu.Lock()
+		heldStoreLocks = append(heldStoreLocks, unlock)
+		heldStoreLocksMu.Unlock()
+	}
+	return c.open(true)
+}
+
+// root returns the root of the store. The default RepoStore root
+// (.srclib-store) is resolved relative to the local repo's root
+// directory (if any), not the current directory.
+func (c *StoreCmd) root() string {
+	if c.Type == "RepoStore" && c.Root == store.SrclibStoreDir {
+		if lrepo, _ := OpenLocalRepo(); lrepo != nil && lrepo.RootDir != "" {
+			return filepath.Join(lrepo.RootDir, store.SrclibStoreDir)
+		}
+	}
+	return c.Root
+}
+
+// isLocalFS returns whether the store is kept in local files (one per
+// store file), which is what store locking and staged imports need.
+func (c *StoreCmd) isLocalFS() bool {
+	return c.Backend == "fs" && c.URL == ""
+}
+
+// backendFS opens the filesystem that holds the store's data.
+func (c *StoreCmd) backendFS() (rwvfs.FileSystem, error) {
+	if c.URL != "" {
+		if err := checkOnline("opening a store in object storage"); err != nil {
+			return nil, err
+		}
+		return buildstore.OpenURL(c.URL)
+	}
+	return store.OpenBackend(c.Backend, c.root())
+}
+
+func (c *StoreCmd) open(readOnly bool) (interface{}, error) {
+	fs, err := c.backendFS()
+	if err != nil {
+		return nil, err
 	}
-	if fs, ok := fs.(createParents); ok {
-		fs.CreateParentDirs(true)
+	store.UseMmap = !c.NoMmap
+	store.SharedIndexDir = c.IndexCache
+	if readOnly {
+		fs = rwvfs.ReadOnly(fs)
+	} else if store.DataCompressor, err = compressorNamed(c.Compress); err != nil {
+		return nil, err
 	}
 
 	switch c.Type {
@@ -176,16 +358,30 @@ type StoreImportCmd struct {
 
 	Quiet bool `short:"q" long:"quiet" description:"silence all output"`
 
+	Labels []string `long:"label" description:"label the imported commit (e.g., with its branch name) so it can be queried with 'src query --rev LABEL'; the label then refers to this commit (may be repeated)" value-name:"LABEL"`
+
 	Sample           bool `long:"sample" description:"(sample data) import sample data, not .srclib-cache data"`
 	SampleDefs       int  `long:"sample-defs" description:"(sample data) number of sample defs to import" default:"100"`
 	SampleRefs       int  `long:"sample-refs" description:"(sample data) number of sample refs to import" default:"100"`
 	SampleImportOnly bool `long:"sample-import-only" description:"(sample data) only import, don't demonstrate listing data"`
+
+	Archive string `long:"archive" description:"import the commit (data and indexes) from this archive written by 'src store export', instead of from build data; the repo and commit are those in the archive" value-name:"FILE"`
 }
 
 var storeImportCmd StoreImportCmd
 
 func (c *StoreImportCmd) Execute(args []string) error {
 	start := time.Now()
+	if len(c.Labels) > 0 && storeCmd.URL != "" {
+		// Labels are kept in a local file in the store root.
+		return errors.New("--label is not supported for stores in object storage (--store-url)")
+	}
+	if c.Archive != "" {
+		if err := c.importArchive(); err != nil {
+			return err
+		}
+		return c.finish(start)
+	}
 
 	s, err := OpenStore()
 	if err != nil {
@@ -204,8 +400,50 @@ func (c *StoreImportCmd) Execute(args []string) error {
 		log.Printf("# Importing build data for %s (commit %s)", c.Repo, c.CommitID)
 	}
 
-	if err := Import(bdfs, s, c.ImportOpt); err != nil {
+	// Import a whole commit into a temporary store and then move it
+	// into place, so that concurrent queries (e.g., while `src make`
+	// runs) never see it partly imported. Importing only some of a
+	// commit's source units updates the commit in place.
+	var staged *stagedCommit
+	if !c.DryRun && c.Unit == "" && c.UnitType == "" {
+		if staged, err = storeCmd.stageCommit(c.Repo, c.CommitID); err != nil {
+			return err
+		}
+	}
+	if staged != nil {
+		if err := Import(bdfs, staged.store, c.ImportOpt); err != nil {
+			staged.remove()
+			return err
+		}
+		if err := staged.publish(); err != nil {
+			return err
+		}
+	} else if err := Import(bdfs, s, c.ImportOpt); err != nil {
 		return err
+	} else if !c.DryRun {
+		if err := store.SetImportTime(s, c.Repo, c.CommitID, time.Now()); err != nil {
+			log.Printf("Warning: failed to record import time of commit %s: %s", c.CommitID, err)
+		}
+	}
+	if bdfs != nil && !c.DryRun && c.Unit == "" && c.UnitType == "" {
+		// Record the defs removed since the previous build, so
+		// queries for them can say when they were removed.
+		if err := recordLocalDefTombstones(c.CommitID); err != nil {
+			log.Printf("Warning: failed to record def tombstones: %s", err)
+		}
+	}
+	return c.finish(start)
+}
+
+// finish labels the imported commit (if --label was given).
+func (c *StoreImportCmd) finish(start time.Time) error {
+	if len(c.Labels) > 0 && !c.DryRun {
+		if storeCmd.Type != "RepoStore" {
+			return fmt.Errorf("--label is only supported by RepoStore stores, not %s", storeCmd.Type)
+		}
+		if err := labelStoreCommit(storeCmd.root(), c.CommitID, c.Labels); err != nil {
+			return err
+		}
 	}
 	if !c.Quiet {
 		log.Printf("# Import completed in %s.", time.Since(start))
@@ -216,6 +454,7 @@ func (c *StoreImportCmd) Execute(args []string) error {
 type ImportOpt struct {
 	DryRun  bool `short:"n" long:"dry-run" description:"print what would be done but don't do anything"`
 	NoIndex bool `long:"no-index" description:"don't build indexes (indexes inside a single source unit are always built)"`
+	Jobs    int  `short:"j" long:"jobs" description:"max number of source units to import, and of indexes to build, concurrently (0 for the number of CPUs)"`
 
 	Repo     string `long:"repo" description:"only import for this repo"`
 	Unit     string `long:"unit" description:"only import source units with this name"`
@@ -225,6 +464,24 @@ type ImportOpt struct {
 	Verbose bool
 }
 
+// readGraphOutputFS reads the graph output in the named JSON file. It
+// decodes the file as a stream, so that the (often much larger) JSON
+// isn't held in memory along with the decoded output.
+func readGraphOutputFS(fs vfs.FileSystem, file string) (data graph.Output, err error) {
+	f, err := fs.Open(file)
+	if err != nil {
+		return data, err
+	}
+	defer f.Close()
+	err = graph.DecodeOutputStream(bufio.NewReader(f), graph.OutputHandler{
+		Def: func(def *graph.Def) error { data.Defs = append(data.Defs, def); return nil },
+		Ref: func(ref *graph.Ref) error { data.Refs = append(data.Refs, ref); return nil },
+		Doc: func(doc *graph.Doc) error { data.Docs = append(data.Docs, doc); return nil },
+		Ann: func(a *ann.Ann) error { data.Anns = append(data.Anns, a); return nil },
+	})
+	return data, err
+}
+
 // Import imports build data into a RepoStore or MultiRepoStore.
 func Import(buildDataFS vfs.FileSystem, stor interface{}, opt ImportOpt) error {
 	// Traverse the build data directory for this repo and commit to
@@ -242,9 +499,16 @@ func Import(buildDataFS vfs.FileSystem, stor interface{}, opt ImportOpt) error {
 	var (
 		mu               sync.Mutex
 		hasIndexableData bool
+		nameFreqs        = store.DefNameFreqs{}
 	)
 
-	par := parallel.NewRun(10)
+	jobs := opt.Jobs
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+	store.IndexJobs = jobs
+
+	par := parallel.NewRun(jobs)
 	for _, rule_ := range mf.Rules {
 		rule := rule_
 
@@ -267,8 +531,8 @@ func Import(buildDataFS vfs.FileSystem, stor interface{}, opt ImportOpt) error {
 		par.Do(func() error {
 			switch rule := rule.(type) {
 			case *grapher.GraphUnitRule:
-				var data graph.Output
-				if err := readJSONFileFS(buildDataFS, rule.Target(), &data); err != nil {
+				data, err := readGraphOutputFS(buildDataFS, rule.Target())
+				if err != nil {
 					if os.IsNotExist(err) {
 						log.Printf("Warning: no build data for unit %s %s.", rule.Unit.Type, rule.Unit.Name)
 						return nil
@@ -293,13 +557,21 @@ func Import(buildDataFS vfs.FileSystem, stor interface{}, opt ImportOpt) error {
 					}
 				}
 
+				// Record the tool that produced the defs and refs so
+				// that they can be traced back to it.
+				var env *toolchain.Env
+				if err := readJSONFileFS(buildDataFS, rule.EnvTarget(), &env); err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("error reading grapher environment file %s for unit %s %s: %s", rule.EnvTarget(), rule.Unit.Type, rule.Unit.Name, err)
+				}
+				u := withGraphTool(rule.Unit, env, rule.Tool)
+
 				switch imp := stor.(type) {
 				case store.RepoImporter:
-					if err := imp.Import(opt.CommitID, rule.Unit, data); err != nil {
+					if err := imp.Import(opt.CommitID, u, data); err != nil {
 						return fmt.Errorf("error running store.RepoImporter.Import: %s", err)
 					}
 				case store.MultiRepoImporter:
-					if err := imp.Import(opt.Repo, opt.CommitID, rule.Unit, data); err != nil {
+					if err := imp.Import(opt.Repo, opt.CommitID, u, data); err != nil {
 						return fmt.Errorf("error running store.MultiRepoImporter.Import: %s", err)
 					}
 				default:
@@ -308,6 +580,7 @@ func Import(buildDataFS vfs.FileSystem, stor interface{}, opt ImportOpt) error {
 
 				mu.Lock()
 				hasIndexableData = true
+				nameFreqs.Add(data.Defs)
 				mu.Unlock()
 			}
 			return nil
@@ -317,6 +590,15 @@ func Import(buildDataFS vfs.FileSystem, stor interface{}, opt ImportOpt) error {
 		return err
 	}
 
+	// Record the def name frequencies in the build data (if it's
+	// writable) for ranking completions. They're only accurate if
+	// all of the source units were imported.
+	if bdfs, ok := buildDataFS.(rwvfs.FileSystem); ok && hasIndexableData && opt.Unit == "" && opt.UnitType == "" {
+		if err := store.WriteDefNameFreqs(bdfs, nameFreqs); err != nil {
+			log.Printf("Warning: failed to write def name frequencies: %s", err)
+		}
+	}
+
 	if hasIndexableData && !opt.NoIndex {
 		if GlobalOpt.Verbose {
 			log.Printf("# Building indexes")
@@ -699,6 +981,14 @@ func doStoreIndexesCmd(crit store.IndexCriteria, opt storeIndexOptions, f func(i
 					colorable.Printf("(BUILD ERROR: %s) ", x.BuildError)
 					hasError = true
 				}
+				if x.VerifyError != "" {
+					colorable.Printf("(VERIFY ERROR: %s) ", x.VerifyError)
+					if x.Repaired {
+						colorable.Print("REPAIRED ")
+					} else {
+						hasError = true
+					}
+				}
 				if x.BuildDuration != 0 {
 					colorable.Printf("- build took %s ", x.BuildDuration)
 				}
@@ -719,10 +1009,9 @@ func doStoreIndexesCmd(crit store.IndexCriteria, opt storeIndexOptions, f func(i
 	}
 
 	_, err = f(s, crit, indexChan)
-	defer func() {
-		close(indexChan)
-		<-done
-	}()
+	// Wait for all indexes to be printed, so that hasError is final.
+	close(indexChan)
+	<-done
 	if err != nil {
 		return err
 	}
@@ -761,6 +1050,156 @@ func (c *StoreIndexCmd) Execute(args []string) error {
 	return doStoreIndexesCmd(c.IndexCriteria(), c.storeIndexOptions, store.BuildIndexes)
 }
 
+type StorePrewarmCmd struct {
+	storeIndexCriteria
+	storeIndexOptions
+}
+
+var storePrewarmCmd StorePrewarmCmd
+
+func (c *StorePrewarmCmd) Execute(args []string) error {
+	crit := c.IndexCriteria()
+	if err := doStoreIndexesCmd(crit, c.storeIndexOptions, store.BuildIndexes); err != nil {
+		return err
+	}
+	if c.Output == "text" {
+		colorable.Println("\nVerifying indexes...")
+	}
+	return doStoreIndexesCmd(crit, c.storeIndexOptions, store.VerifyIndexes)
+}
+
+type StoreVerifyCmd struct {
+	storeIndexCriteria
+	storeIndexOptions
+
+	Repair bool `long:"repair" description:"rebuild indexes that have problems"`
+}
+
+var storeVerifyCmd StoreVerifyCmd
+
+func (c *StoreVerifyCmd) Execute(args []string) error {
+	return doStoreIndexesCmd(c.IndexCriteria(), c.storeIndexOptions, func(s interface{}, crit store.IndexCriteria, ch chan<- store.IndexStatus) ([]store.IndexStatus, error) {
+		return store.CheckIndexes(s, crit, c.Repair, ch)
+	})
+}
+
+type StoreCompactCmd struct {
+	Compressor string `long:"compressor" description:"compressor to rewrite data files with (gzip, or none to decompress them)" default:"gzip"`
+}
+
+var storeCompactCmd StoreCompactCmd
+
+func (c *StoreCompactCmd) Execute(args []string) error {
+	comp, err := compressorNamed(c.Compressor)
+	if err != nil {
+		return err
+	}
+	if storeCmd.ReadOnly {
+		return errors.New("can't compact a store opened with --read-only")
+	}
+	fs, err := storeCmd.backendFS()
+	if err != nil {
+		return err
+	}
+	if storeCmd.isLocalFS() {
+		// Don't rewrite data files while they're being read or
+		// imported.
+		unlock, err := lockStore(storeCmd.root(), true, true)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	stats, err := store.CompactDataFiles(rwvfs.Walkable(fs), comp)
+	if err != nil {
+		return err
+	}
+	colorable.Printf("Compacted %d data files: %s -> %s", stats.Files, bytesString(uint64(stats.Before)), bytesString(uint64(stats.After)))
+	if stats.Before > 0 {
+		colorable.Printf(" (%.0f%%)", float64(stats.After)/float64(stats.Before)*100)
+	}
+	colorable.Println()
+	return nil
+}
+
+type StoreDedupeCmd struct {
+	NoGC bool `long:"no-gc" description:"don't remove unused blobs after deduping"`
+}
+
+var storeDedupeCmd StoreDedupeCmd
+
+func (c *StoreDedupeCmd) Execute(args []string) error {
+	if storeCmd.ReadOnly {
+		return errors.New("can't dedupe a store opened with --read-only")
+	}
+	s, err := storeCmd.store()
+	if err != nil {
+		return err
+	}
+	if storeCmd.isLocalFS() {
+		// Don't replace files with blob pointers (or remove unused
+		// blobs, below) while they're being read or imported.
+		unlock, err := lockStore(storeCmd.root(), true, true)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	stats, err := store.DedupeCommits(s)
+	if err != nil {
+		return err
+	}
+	colorable.Printf("Deduped %d files into %d new blobs: %s -> %s\n", stats.Files, stats.Blobs, bytesString(uint64(stats.Before)), bytesString(uint64(stats.After)))
+	if c.NoGC {
+		return nil
+	}
+	return storeGCCmd.Execute(nil)
+}
+
+type StoreGCCmd struct {
+	KeepLast  int      `long:"keep-last" description:"also remove the data and indexes of all commits except each repo's N most recently imported and those given by --keep-rev (or, in a RepoStore, labeled)" value-name:"N"`
+	KeepRevs  []string `long:"keep-rev" description:"keep this commit when removing commits (in a RepoStore, a commit ID prefix or a label; in a MultiRepoStore, a full commit ID or REPO@PREFIX) (may be repeated)" value-name:"REV"`
+	BuildData bool     `long:"build-data" description:"when removing commits, also remove the build data of the local repository's commits that aren't kept (by the same criteria)"`
+	DryRun    bool     `short:"n" long:"dry-run" description:"only list the commits that would be removed, and their sizes"`
+}
+
+var storeGCCmd StoreGCCmd
+
+func (c *StoreGCCmd) Execute(args []string) error {
+	if storeCmd.ReadOnly {
+		return errors.New("can't collect garbage in a store opened with --read-only")
+	}
+	s, err := storeCmd.store()
+	if err != nil {
+		return err
+	}
+	if c.KeepLast > 0 || len(c.KeepRevs) > 0 {
+		if err := c.pruneCommits(s); err != nil {
+			return err
+		}
+	} else if c.BuildData || c.DryRun {
+		return errors.New("--build-data and --dry-run require --keep-last or --keep-rev")
+	}
+	if c.DryRun {
+		return nil
+	}
+	if storeCmd.isLocalFS() {
+		// Don't remove blobs that an import or dedupe that's in
+		// progress is about to point to.
+		unlock, err := lockStore(storeCmd.root(), true, true)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	stats, err := store.CollectBlobGarbage(s)
+	if err != nil {
+		return err
+	}
+	colorable.Printf("Removed %d unused blobs (%s)\n", stats.Blobs, bytesString(uint64(stats.Bytes)))
+	return nil
+}
+
 type StoreReposCmd struct {
 	IDContains string `short:"i" long:"id-contains" description:"filter to repos whose ID contains this substring"`
 }
@@ -776,7 +1215,7 @@ func (c *StoreReposCmd) filters() []store.RepoFilter {
 var storeReposCmd StoreReposCmd
 
 func (c *StoreReposCmd) Execute(args []string) error {
-	s, err := OpenStore()
+	s, err := OpenStoreReadOnly()
 	if err != nil {
 		return err
 	}
@@ -822,7 +1261,7 @@ func (c *StoreVersionsCmd) filters() []store.VersionFilter {
 var storeVersionsCmd StoreVersionsCmd
 
 func (c *StoreVersionsCmd) Execute(args []string) error {
-	s, err := OpenStore()
+	s, err := OpenStoreReadOnly()
 	if err != nil {
 		return err
 	}
@@ -882,22 +1321,34 @@ func (c *StoreUnitsCmd) filters() []store.UnitFilter {
 var storeUnitsCmd StoreUnitsCmd
 
 func (c *StoreUnitsCmd) Execute(args []string) error {
-	s, err := OpenStore()
+	units, err := c.Get()
 	if err != nil {
 		return err
 	}
+	PrintJSON(units, "  ")
+	return nil
+}
 
-	ts, ok := s.(store.TreeStore)
-	if !ok {
-		return fmt.Errorf("store (type %T) does not implement listing source units", s)
+func (c *StoreUnitsCmd) Get() ([]*unit.SourceUnit, error) {
+	fs := c.filters()
+	var units []*unit.SourceUnit
+	if ok, err := callStoreDaemon("Units", c, &units); ok {
+		return units, err
 	}
 
-	units, err := ts.Units(c.filters()...)
+	s, err := OpenStoreReadOnly()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	PrintJSON(units, "  ")
-	return nil
+	return c.get(s, fs)
+}
+
+func (c *StoreUnitsCmd) get(s interface{}, fs []store.UnitFilter) ([]*unit.SourceUnit, error) {
+	ts, ok := s.(store.TreeStore)
+	if !ok {
+		return nil, fmt.Errorf("store (type %T) does not implement listing source units", s)
+	}
+	return ts.Units(fs...)
 }
 
 type StoreDefsCmd struct {
@@ -912,12 +1363,29 @@ type StoreDefsCmd struct {
 
 	Query string `long:"query"`
 
+	FilePrefix string   `long:"file-prefix" description:"only show defs in files whose paths begin with this prefix"`
+	Kinds      []string `long:"kind" description:"only show defs of this kind (may be repeated to show defs of any of the kinds)"`
+	NameRegexp string   `long:"name-regexp" description:"only show defs whose names match this regexp (if it is anchored with ^ and begins with a literal, local defs are omitted)"`
+
+	Search string `long:"search" description:"only show non-local defs whose names, paths, or docs match this free-text query (typos are tolerated), best match first"`
+
+	DocTitle string `long:"doc-title" description:"only show non-local defs whose doc titles (the first sentence of their docs) contain words starting with each word in this query"`
+
+	Toolchain string `long:"toolchain" description:"only show defs produced by this toolchain (e.g., sourcegraph.com/sourcegraph/srclib-go)"`
+
+	ChildrenOf string `long:"children-of" description:"only show the children of the def with this path in its source unit's tree of defs (e.g., the methods and fields of a type)" value-name:"PATH"`
+	ParentOf   string `long:"parent-of" description:"only show the parent of the def with this path in its source unit's tree of defs (e.g., the type of a method)" value-name:"PATH"`
+
 	Limit  int `short:"n" long:"limit" description:"max results to return (0 for all)"`
 	Offset int `long:"offset" description:"results offset (0 to start with first results)"`
 
 	// If Filter is non-nil, it is applied along with the above
 	// filters.
 	Filter store.DefFilter
+
+	// If Repos is non-empty, only defs in Repo or any of Repos are
+	// selected.
+	Repos []string
 }
 
 func (c *StoreDefsCmd) filters() []store.DefFilter {
@@ -931,8 +1399,8 @@ func (c *StoreDefsCmd) filters() []store.DefFilter {
 	if c.CommitID != "" {
 		fs = append(fs, store.ByCommitIDs(c.CommitID))
 	}
-	if c.Repo != "" {
-		fs = append(fs, store.ByRepos(c.Repo))
+	if repos := c.repos(); len(repos) != 0 {
+		fs = append(fs, store.ByRepos(repos...))
 	}
 	if c.RepoCommitIDs != "" {
 		fs = append(fs, makeRepoCommitIDsFilter(c.RepoCommitIDs))
@@ -946,15 +1414,43 @@ func (c *StoreDefsCmd) filters() []store.DefFilter {
 	if c.Query != "" {
 		fs = append(fs, store.ByDefQuery(c.Query))
 	}
+	if c.FilePrefix != "" {
+		fs = append(fs, store.ByFilePrefix(c.FilePrefix))
+	}
+	if len(c.Kinds) != 0 {
+		fs = append(fs, store.ByKindAny(c.Kinds...))
+	}
+	if c.NameRegexp != "" {
+		// Get checks that it compiles.
+		fs = append(fs, store.ByNameRegexp(regexp.MustCompile(c.NameRegexp)))
+	}
+	if c.DocTitle != "" {
+		fs = append(fs, store.ByDocTitle(c.DocTitle))
+	}
+	if c.ChildrenOf != "" {
+		fs = append(fs, store.ByDefParent(c.ChildrenOf))
+	}
+	if c.ParentOf != "" {
+		fs = append(fs, store.ByDefChild(c.ParentOf))
+	}
 	if c.Filter != nil {
 		fs = append(fs, c.Filter)
 	}
-	if c.Limit != 0 || c.Offset != 0 {
+	// Search results are limited after they're ranked (in get).
+	if (c.Limit != 0 || c.Offset != 0) && c.Search == "" {
 		fs = append(fs, store.Limit(c.Limit, c.Offset))
 	}
 	return fs
 }
 
+// repos returns the union of Repo and Repos.
+func (c *StoreDefsCmd) repos() []string {
+	if c.Repo == "" {
+		return c.Repos
+	}
+	return append([]string{c.Repo}, c.Repos...)
+}
+
 var storeDefsCmd StoreDefsCmd
 
 func (c *StoreDefsCmd) Execute(args []string) error {
@@ -967,20 +1463,90 @@ func (c *StoreDefsCmd) Execute(args []string) error {
 }
 
 func (c *StoreDefsCmd) Get() ([]*graph.Def, error) {
-	s, err := OpenStore()
+	if c.NameRegexp != "" {
+		if _, err := regexp.Compile(c.NameRegexp); err != nil {
+			return nil, fmt.Errorf("--name-regexp: %s", err)
+		}
+	}
+	fs := c.filters()
+	// Filter can't be sent to the daemon, and verbose output must be
+	// logged by this process.
+	if c.Filter == nil && !GlobalOpt.Verbose {
+		var defs []*graph.Def
+		if ok, err := callStoreDaemon("Defs", c, &defs); ok {
+			return defs, err
+		}
+	}
+
+	s, err := OpenStoreReadOnly()
 	if err != nil {
 		return nil, err
 	}
+	return c.get(s, fs)
+}
 
+func (c *StoreDefsCmd) get(s interface{}, fs []store.DefFilter) ([]*graph.Def, error) {
 	us, ok := s.(store.UnitStore)
 	if !ok {
 		return nil, fmt.Errorf("store (type %T) does not implement listing defs", s)
 	}
 
-	defs, err := us.Defs(c.filters()...)
-	if err != nil {
+	var prov unitProvenance
+	var err error
+	if c.Toolchain != "" || GlobalOpt.Verbose {
+		if prov, err = readUnitProvenance(s); err != nil {
+			return nil, err
+		}
+	}
+	if c.Toolchain != "" {
+		ids := prov.units(c.Toolchain)
+		if len(ids) == 0 {
+			return nil, nil
+		}
+		fs = append([]store.DefFilter{store.ByUnits(ids...)}, fs...)
+	}
+
+	var defs []*graph.Def
+	if c.Search != "" {
+		results, err := store.NewDefSearcher(us).SearchDefs(c.Search, 0, fs...)
+		if err != nil {
+			return nil, err
+		}
+		defs = make([]*graph.Def, len(results))
+		for i, r := range results {
+			defs[i] = r.Def
+		}
+	} else if defs, err = us.Defs(fs...); err != nil {
 		return nil, err
 	}
+	if c.Toolchain != "" {
+		// The same unit may have been graphed by different tools at
+		// other repos or commits, so check each def's exact unit.
+		filtered := defs[:0]
+		for _, def := range defs {
+			if prov.producedBy(defUnitKey(def), c.Toolchain) {
+				filtered = append(filtered, def)
+			}
+		}
+		defs = filtered
+	}
+	if c.Search != "" {
+		if c.Offset >= len(defs) {
+			defs = nil
+		} else {
+			defs = defs[c.Offset:]
+		}
+		if c.Limit > 0 && len(defs) > c.Limit {
+			defs = defs[:c.Limit]
+		}
+	}
+	if GlobalOpt.Verbose {
+		keys := map[unit.Key]struct{}{}
+		for _, def := range defs {
+			keys[defUnitKey(def)] = struct{}{}
+		}
+		prov.log(keys)
+	}
 	return defs, nil
 }
 
@@ -991,6 +1557,8 @@ type StoreRefsCmd struct {
 	File     string `long:"file"`
 	CommitID string `long:"commit"`
 
+	FilePrefix string `long:"file-prefix" description:"only show refs in files whose paths begin with this prefix"`
+
 	RepoCommitIDs string `long:"repo-commits" description:"comma-separated list of repo@commitID specifiers"`
 
 	Start uint32 `long:"start"`
@@ -1000,12 +1568,15 @@ type StoreRefsCmd struct {
 	DefUnitType string `long:"def-unit-type" `
 	DefUnit     string `long:"def-unit"`
 	DefPath     string `long:"def-path"`
+	DefFile     string `long:"def-file" description:"only show refs to defs defined in this file (only refs in the same repo and commit as the defs are found)"`
 
 	Broken   bool `long:"broken" description:"only show refs that point to nonexistent defs"`
 	Coverage bool `long:"coverage" description:"print a coverage summary (resolved refs, broken refs, total refs)"`
 
 	Format string `long:"format" description:"output format ('json' or 'none')" default:"json"`
 
+	Toolchain string `long:"toolchain" description:"only show refs produced by this toolchain (e.g., sourcegraph.com/sourcegraph/srclib-go)"`
+
 	Limit  int `short:"n" long:"limit" description:"max results to return (0 for all)"`
 	Offset int `long:"offset" description:"results offset (0 to start with first results)"`
 }
@@ -1030,6 +1601,9 @@ func (c *StoreRefsCmd) filters() []store.RefFilter {
 	if c.File != "" {
 		fs = append(fs, store.ByFiles(path.Clean(c.File)))
 	}
+	if c.FilePrefix != "" {
+		fs = append(fs, store.ByFilePrefix(c.FilePrefix))
+	}
 	if c.Start != 0 {
 		fs = append(fs, store.RefFilterFunc(func(ref *graph.Ref) bool {
 			return ref.Start >= c.Start
@@ -1048,11 +1622,10 @@ func (c *StoreRefsCmd) filters() []store.RefFilter {
 			DefPath:     c.DefPath,
 		}))
 	} else {
-		// Slower filters since they don't use an index.
+		// Slower filters (except for ByRefDefRepos) since they don't
+		// use an index.
 		if c.DefRepo != "" {
-			fs = append(fs, store.AbsRefFilterFunc(store.RefFilterFunc(func(ref *graph.Ref) bool {
-				return ref.DefRepo == c.DefRepo
-			})))
+			fs = append(fs, store.ByRefDefRepos(c.DefRepo))
 		}
 		if c.DefUnitType != "" {
 			fs = append(fs, store.AbsRefFilterFunc(store.RefFilterFunc(func(ref *graph.Ref) bool {
@@ -1065,6 +1638,9 @@ func (c *StoreRefsCmd) filters() []store.RefFilter {
 			})))
 		}
 	}
+	if c.DefFile != "" {
+		fs = append(fs, store.ByRefDefFiles(path.Clean(c.DefFile)))
+	}
 	if c.Limit != 0 || c.Offset != 0 {
 		fs = append(fs, store.Limit(c.Limit, c.Offset))
 	}
@@ -1086,20 +1662,60 @@ func (c *StoreRefsCmd) Execute(args []string) error {
 }
 
 func (c *StoreRefsCmd) Get() ([]*graph.Ref, error) {
-	s, err := OpenStore()
+	fs := c.filters()
+	// The coverage summary and verbose output must be logged by this
+	// process.
+	if !c.Coverage && !GlobalOpt.Verbose {
+		var refs []*graph.Ref
+		if ok, err := callStoreDaemon("Refs", c, &refs); ok {
+			return refs, err
+		}
+	}
+
+	s, err := OpenStoreReadOnly()
 	if err != nil {
 		return nil, err
 	}
+	return c.get(s, fs)
+}
 
+func (c *StoreRefsCmd) get(s interface{}, fs []store.RefFilter) ([]*graph.Ref, error) {
 	us, ok := s.(store.UnitStore)
 	if !ok {
 		return nil, fmt.Errorf("store (type %T) does not implement listing refs", s)
 	}
 
-	refs, err := us.Refs(c.filters()...)
+	var prov unitProvenance
+	var err error
+	if c.Toolchain != "" || GlobalOpt.Verbose {
+		if prov, err = readUnitProvenance(s); err != nil {
+			return nil, err
+		}
+	}
+	if c.Toolchain != "" {
+		ids := prov.units(c.Toolchain)
+		if len(ids) == 0 {
+			return nil, nil
+		}
+		fs = append([]store.RefFilter{
+			store.ByUnits(ids...),
+			store.AbsRefFilterFunc(func(ref *graph.Ref) bool {
+				return prov.producedBy(refUnitKey(ref), c.Toolchain)
+			}),
+		}, fs...)
+	}
+
+	refs, err := us.Refs(fs...)
 	if err != nil {
 		return nil, err
 	}
+	if GlobalOpt.Verbose {
+		keys := map[unit.Key]struct{}{}
+		for _, ref := range refs {
+			keys[refUnitKey(ref)] = struct{}{}
+		}
+		prov.log(keys)
+	}
 
 	allRefs := refs
 	var brokenRefs []*graph.Ref
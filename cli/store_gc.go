@@ -0,0 +1,204 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+// pruneCommits removes the store's commits that c doesn't keep (see
+// StoreGCCmd) and prints what it removed.
+func (c *StoreGCCmd) pruneCommits(s interface{}) error {
+	keep, err := c.keepFunc(s)
+	if err != nil {
+		return err
+	}
+	if storeCmd.isLocalFS() && !c.DryRun {
+		// Don't remove commits while other src processes query them.
+		unlock, err := lockStore(storeCmd.root(), true, true)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	pruned, err := store.PruneCommits(s, store.PruneOptions{KeepLast: c.KeepLast, Keep: keep, DryRun: c.DryRun})
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, pc := range pruned {
+		name := pc.CommitID
+		if pc.Repo != "" {
+			name = pc.Repo + "@" + pc.CommitID
+		}
+		c.printRemoved("commit "+name, pc.Imported, pc.Bytes)
+		total += pc.Bytes
+	}
+	c.printTotal(len(pruned), "commits", total)
+
+	if c.BuildData {
+		return c.pruneBuildData(s, keep)
+	}
+	return nil
+}
+
+// keepFunc returns a func that reports whether a commit that isn't
+// one of its repo's --keep-last most recent is kept: one given by
+// --keep-rev or, in a RepoStore, the current commit of a label.
+func (c *StoreGCCmd) keepFunc(s interface{}) (func(repo, commitID string) bool, error) {
+	keepIDs := map[string]bool{}
+	var keepRepoRevs []repoRev
+	if storeCmd.Type == "RepoStore" {
+		labels, err := readStoreLabels(storeCmd.root())
+		if err != nil {
+			return nil, err
+		}
+		for label := range labels {
+			keepIDs[labels.current(label)] = true
+		}
+		for _, rev := range c.KeepRevs {
+			commitID, err := resolveStoreRev(storeCmd.root(), s.(store.RepoStore), rev)
+			if err != nil {
+				return nil, err
+			}
+			keepIDs[commitID] = true
+		}
+	} else {
+		// A commit ID prefix could also match commits of other
+		// repos, so prefixes must be scoped to a repo.
+		for _, rev := range c.KeepRevs {
+			if i := strings.LastIndex(rev, "@"); i > 0 && i < len(rev)-1 {
+				keepRepoRevs = append(keepRepoRevs, repoRev{repo: rev[:i], prefix: rev[i+1:]})
+			} else if len(rev) == 40 {
+				keepIDs[rev] = true
+			} else {
+				return nil, fmt.Errorf("--keep-rev %q: in a MultiRepoStore, give a full commit ID, or REPO@PREFIX to keep a commit of REPO by a commit ID prefix", rev)
+			}
+		}
+	}
+	return func(repo, commitID string) bool {
+		if keepIDs[commitID] {
+			return true
+		}
+		for _, rr := range keepRepoRevs {
+			if repo == rr.repo && strings.HasPrefix(commitID, rr.prefix) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// A repoRev is a commit ID prefix given as --keep-rev REPO@PREFIX.
+type repoRev struct{ repo, prefix string }
+
+// pruneBuildData removes the build data of the local repo's commits
+// that aren't among its --keep-last most recently built or kept by
+// keep. Commits that are in the store s are ordered by the time that
+// they were imported into it (which follows their build), and others
+// by the time that their build data was last written.
+func (c *StoreGCCmd) pruneBuildData(s interface{}, keep func(repo, commitID string) bool) error {
+	lrepo, err := OpenLocalRepo()
+	if err != nil {
+		return err
+	}
+	if lrepo == nil || lrepo.RootDir == "" {
+		return errors.New("--build-data requires a local repository")
+	}
+	var repo string
+	if storeCmd.Type == "MultiRepoStore" {
+		repo = lrepo.URI()
+	}
+	dir := filepath.Join(lrepo.RootDir, buildstore.BuildDataDirName)
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var commits []*store.PrunedCommit
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		pc := &store.PrunedCommit{Repo: repo, CommitID: e.Name()}
+		err := filepath.Walk(filepath.Join(dir, e.Name()), func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.Mode().IsRegular() {
+				pc.Bytes += fi.Size()
+			}
+			if fi.ModTime().After(pc.Imported) {
+				pc.Imported = fi.ModTime()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if imported, ok, err := store.ImportTime(s, repo, pc.CommitID); err != nil {
+			return err
+		} else if ok {
+			pc.Imported = imported
+		}
+		commits = append(commits, pc)
+	}
+	sort.Sort(prunedByTime(commits))
+
+	var n int
+	var total int64
+	for i, pc := range commits {
+		if i < c.KeepLast || keep(repo, pc.CommitID) {
+			continue
+		}
+		if !c.DryRun {
+			if err := os.RemoveAll(filepath.Join(dir, pc.CommitID)); err != nil {
+				return err
+			}
+		}
+		c.printRemoved("build data for commit "+pc.CommitID, pc.Imported, pc.Bytes)
+		n++
+		total += pc.Bytes
+	}
+	c.printTotal(n, "build data dirs", total)
+	return nil
+}
+
+func (c *StoreGCCmd) printRemoved(what string, imported time.Time, size int64) {
+	verb := "Removed"
+	if c.DryRun {
+		verb = "Would remove"
+	}
+	colorable.Printf("%s %s (%s, %s)\n", verb, what, imported.Format(time.RFC3339), bytesString(uint64(size)))
+}
+
+func (c *StoreGCCmd) printTotal(n int, what string, size int64) {
+	verb := "Removed"
+	if c.DryRun {
+		verb = "Would remove"
+	}
+	colorable.Printf("%s %d %s (%s)\n", verb, n, what, bytesString(uint64(size)))
+}
+
+type prunedByTime []*store.PrunedCommit
+
+func (v prunedByTime) Len() int      { return len(v) }
+func (v prunedByTime) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v prunedByTime) Less(i, j int) bool {
+	if !v[i].Imported.Equal(v[j].Imported) {
+		return v[i].Imported.After(v[j].Imported)
+	}
+	return v[i].CommitID < v[j].CommitID
+}
@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+// storeLabelsFile is the name of the file, in the root of a
+// RepoStore, that holds the labels (e.g., branch names) given to
+// imported commits with `src store import --label`.
+const storeLabelsFile = "labels.json"
+
+// storeLabels maps each label to the commit IDs it has been given to,
+// oldest first. The last commit ID is the label's current commit
+// (e.g., the latest commit imported for a branch).
+type storeLabels map[string][]string
+
+// readStoreLabels reads the labels in the RepoStore at root. If there
+// are none, it returns an empty map.
+func readStoreLabels(root string) (storeLabels, error) {
+	labels := storeLabels{}
+	file := filepath.Join(root, storeLabelsFile)
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return labels, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return nil, fmt.Errorf("reading store labels from %s: %s", file, err)
+	}
+	return labels, nil
+}
+
+// writeStoreLabels writes labels to the RepoStore at root.
+func writeStoreLabels(root string, labels storeLabels) error {
+	data, err := json.MarshalIndent(labels, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(root, storeLabelsFile), data, 0644)
+}
+
+// add makes commitID the current commit of label. If commitID was
+// given label before, it's moved to the end of the label's history.
+func (l storeLabels) add(label, commitID string) {
+	var history []string
+	for _, id := range l[label] {
+		if id != commitID {
+			history = append(history, id)
+		}
+	}
+	l[label] = append(history, commitID)
+}
+
+// current returns the current commit of label, or the empty string if
+// there is none.
+func (l storeLabels) current(label string) string {
+	history := l[label]
+	if len(history) == 0 {
+		return ""
+	}
+	return history[len(history)-1]
+}
+
+// labelStoreCommit gives commitID each of labels in the RepoStore at
+// root.
+func labelStoreCommit(root, commitID string, labels []string) error {
+	for _, label := range labels {
+		if label == "" || strings.ContainsAny(label, " \t\n") {
+			return fmt.Errorf("invalid label %q (it must be nonempty and contain no whitespace)", label)
+		}
+	}
+	l, err := readStoreLabels(root)
+	if err != nil {
+		return err
+	}
+	for _, label := range labels {
+		l.add(label, commitID)
+	}
+	return writeStoreLabels(root, l)
+}
+
+// resolveStoreRev returns the commit ID in the RepoStore rs (at root)
+// that rev refers to. Rev is either a label, which resolves to its
+// current commit, or an unambiguous prefix of an imported commit ID.
+func resolveStoreRev(root string, rs store.RepoStore, rev string) (string, error) {
+	labels, err := readStoreLabels(root)
+	if err != nil {
+		return "", err
+	}
+	if commitID := labels.current(rev); commitID != "" {
+		return commitID, nil
+	}
+
+	versions, err := rs.Versions(store.VersionFilterFunc(func(version *store.Version) bool {
+		return strings.HasPrefix(version.CommitID, rev)
+	}))
+	if err != nil {
+		return "", err
+	}
+	switch len(versions) {
+	case 0:
+		return "", fmt.Errorf("no label or imported commit matches %q (see 'src store labels' and 'src store versions')", rev)
+	case 1:
+		return versions[0].CommitID, nil
+	default:
+		return "", fmt.Errorf("%q matches %d imported commits; use a longer commit ID prefix", rev, len(versions))
+	}
+}
+
+type StoreLabelsCmd struct {
+	Args struct {
+		Label string `name:"LABEL" description:"show the history of this label (most recent first)"`
+	} `positional-args:"yes"`
+}
+
+var storeLabelsCmd StoreLabelsCmd
+
+func (c *StoreLabelsCmd) Execute(args []string) error {
+	if storeCmd.Type != "RepoStore" {
+		return fmt.Errorf("labels are only supported by RepoStore stores, not %s", storeCmd.Type)
+	}
+	labels, err := readStoreLabels(storeCmd.root())
+	if err != nil {
+		return err
+	}
+
+	if c.Args.Label != "" {
+		history, present := labels[c.Args.Label]
+		if !present {
+			return fmt.Errorf("no such label %q", c.Args.Label)
+		}
+		for i := len(history) - 1; i >= 0; i-- {
+			colorable.Println(history[i])
+		}
+		return nil
+	}
+
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+	for _, label := range names {
+		colorable.Printf("%s\t%s\n", label, labels.current(label))
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+func TestResolveStoreRev(t *testing.T) {
+	root, err := ioutil.TempDir("", "srclib-store-labels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, commitID := range []string{"aaa111", "aaa222", "bbb333"} {
+		if err := os.Mkdir(filepath.Join(root, commitID), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := labelStoreCommit(root, "aaa111", []string{"master", "release-1.9"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := labelStoreCommit(root, "bbb333", []string{"master"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := labelStoreCommit(root, "aaa111", []string{"master"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := labelStoreCommit(root, "aaa111", []string{"bad label"}); err == nil {
+		t.Error("got no error for label with whitespace")
+	}
+
+	labels, err := readStoreLabels(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := storeLabels{"master": {"bbb333", "aaa111"}, "release-1.9": {"aaa111"}}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("got labels %v, want %v", labels, want)
+	}
+
+	// The labels file must not be mistaken for a version.
+	rs := store.NewFSRepoStore(rwvfs.OS(root))
+	tests := map[string]string{
+		"master":      "aaa111",
+		"release-1.9": "aaa111",
+		"bbb":         "bbb333",
+		"aaa2":        "aaa222",
+		"aaa":         "", // ambiguous
+		"labels":      "", // no such label or commit
+	}
+	for rev, wantCommitID := range tests {
+		commitID, err := resolveStoreRev(root, rs, rev)
+		if wantCommitID == "" {
+			if err == nil {
+				t.Errorf("%s: got commit %q, want error", rev, commitID)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", rev, err)
+		} else if commitID != wantCommitID {
+			t.Errorf("%s: got commit %q, want %q", rev, commitID, wantCommitID)
+		}
+	}
+}
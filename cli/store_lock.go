@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// storeLockFile is the name of the file (in the root of an fs-backed
+// store) that processes lock to coordinate access to the store: a
+// process that queries the store holds a shared lock on it, and `src
+// store import` holds an exclusive lock while it publishes a commit
+// (see stagedCommit.publish). The locks are advisory, so they only
+// keep src processes out of each other's way.
+const storeLockFile = ".lock"
+
+// A storeLock is this process's lock on a store's lock file. Locks of
+// the same store taken by the process share its file, because a
+// process's locks on separate opens of a file conflict with each
+// other.
+type storeLock struct {
+	mu                sync.Mutex
+	f                 *os.File
+	shared, exclusive int // number of holders
+}
+
+var (
+	storeLocksMu sync.Mutex
+	storeLocks   = map[string]*storeLock{} // keyed by lock file path
+
+	// heldStoreLocks unlock the locks taken by readOnlyStore.
+	heldStoreLocksMu sync.Mutex
+	heldStoreLocks   []func() error
+)
+
+// releaseStoreLocks releases the locks taken by opening stores with
+// OpenStoreReadOnly. Processes that run indefinitely (such as the
+// interactive query shell) call it when they finish each query, so
+// that they don't keep imports from publishing.
+func releaseStoreLocks() {
+	heldStoreLocksMu.Lock()
+	defer heldStoreLocksMu.Unlock()
+	for _, unlock := range heldStoreLocks {
+		if err := unlock(); err != nil {
+			log.Printf("Warning: unlocking store: %s", err)
+		}
+	}
+	heldStoreLocks = nil
+}
+
+// lockStore takes a shared or exclusive lock on the store at root,
+// blocking until it is available, and returns a func that releases
+// the lock. If create is false and the store's lock file doesn't
+// exist (so no process has published to the store with locking), it
+// returns without locking.
+func lockStore(root string, exclusive, create bool) (unlock func() error, err error) {
+	path := filepath.Join(root, storeLockFile)
+
+	storeLocksMu.Lock()
+	l, present := storeLocks[path]
+	if !present {
+		var f *os.File
+		if create {
+			f, err = os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0644)
+		} else {
+			f, err = os.Open(path)
+		}
+		if err != nil {
+			storeLocksMu.Unlock()
+			if !create && os.IsNotExist(err) {
+				return func() error { return nil }, nil
+			}
+			return nil, err
+		}
+		l = &storeLock{f: f}
+		storeLocks[path] = l
+	}
+	storeLocksMu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case exclusive && l.exclusive == 0:
+		// This also upgrades a shared lock held by the process.
+		err = flockExclusive(l.f)
+	case !exclusive && l.shared == 0 && l.exclusive == 0:
+		err = flockShared(l.f)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if exclusive {
+		l.exclusive++
+	} else {
+		l.shared++
+	}
+
+	var once sync.Once
+	return func() error {
+		var err error
+		once.Do(func() { err = l.release(exclusive) })
+		return err
+	}, nil
+}
+
+func (l *storeLock) release(exclusive bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if exclusive {
+		l.exclusive--
+	} else {
+		l.shared--
+	}
+	switch {
+	case l.exclusive > 0:
+		return nil
+	case l.shared > 0:
+		if exclusive {
+			return flockShared(l.f) // downgrade
+		}
+		return nil
+	}
+	return flockUnlock(l.f)
+}
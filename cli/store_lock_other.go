@@ -0,0 +1,20 @@
+// +build !windows
+
+package cli
+
+import (
+	"os"
+	"syscall"
+)
+
+func flockShared(f *os.File) error    { return flock(f, syscall.LOCK_SH) }
+func flockExclusive(f *os.File) error { return flock(f, syscall.LOCK_EX) }
+func flockUnlock(f *os.File) error    { return flock(f, syscall.LOCK_UN) }
+
+func flock(f *os.File, how int) error {
+	for {
+		if err := syscall.Flock(int(f.Fd()), how); err != syscall.EINTR {
+			return err
+		}
+	}
+}
@@ -0,0 +1,13 @@
+// +build windows
+
+package cli
+
+import "os"
+
+// Store locks aren't implemented on Windows, so concurrent imports and
+// queries aren't coordinated there (although imports still publish
+// each commit with a rename).
+
+func flockShared(f *os.File) error    { return nil }
+func flockExclusive(f *os.File) error { return nil }
+func flockUnlock(f *os.File) error    { return nil }
@@ -0,0 +1,35 @@
+// +build darwin
+
+package cli
+
+import (
+	"errors"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+const peerCredsSupported = true
+
+// peerCreds returns the user and (primary) group of the process on
+// the other end of conn (a unix socket connection).
+func peerCreds(conn *net.UnixConn) (peerCred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return peerCred{}, err
+	}
+	var cred *unix.Xucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	}); err != nil {
+		return peerCred{}, err
+	}
+	if credErr != nil {
+		return peerCred{}, credErr
+	}
+	if cred.Ngroups == 0 {
+		return peerCred{}, errors.New("peer credentials have no group")
+	}
+	return peerCred{uid: int(cred.Uid), gid: int(cred.Groups[0])}, nil
+}
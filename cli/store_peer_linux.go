@@ -0,0 +1,30 @@
+// +build linux
+
+package cli
+
+import (
+	"net"
+	"syscall"
+)
+
+const peerCredsSupported = true
+
+// peerCreds returns the user and group of the process on the other
+// end of conn (a unix socket connection).
+func peerCreds(conn *net.UnixConn) (peerCred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return peerCred{}, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return peerCred{}, err
+	}
+	if credErr != nil {
+		return peerCred{}, credErr
+	}
+	return peerCred{uid: int(cred.Uid), gid: int(cred.Gid)}, nil
+}
@@ -0,0 +1,17 @@
+// +build !linux,!darwin
+
+package cli
+
+import (
+	"errors"
+	"net"
+)
+
+// Peer credentials aren't implemented on this OS, so the store
+// daemon's audit log doesn't record the users that queried it, and
+// the daemon can't be shared with a group.
+const peerCredsSupported = false
+
+func peerCreds(conn *net.UnixConn) (peerCred, error) {
+	return peerCred{}, errors.New("peer credentials are not supported on this OS")
+}
@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/toolchain"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// graphOp is the name of the source unit operation that produces defs
+// and refs.
+const graphOp = "graph"
+
+// withGraphTool returns a copy of u whose Ops record the tool that
+// produced its graph data (and, therefore, its defs and refs): the
+// tool in env (the environment the grapher ran in) or, if env is nil,
+// the tool that the build plan chose.
+func withGraphTool(u *unit.SourceUnit, env *toolchain.Env, planned *srclib.ToolRef) *unit.SourceUnit {
+	var tool *srclib.ToolRef
+	switch {
+	case env != nil:
+		tool = &srclib.ToolRef{Toolchain: env.Toolchain, Subcmd: env.Subcmd, Version: env.ToolchainVersion}
+		if tool.Version == "" {
+			tool.Version = env.ImageID
+		}
+	case planned != nil:
+		t := *planned
+		tool = &t
+	default:
+		return u
+	}
+
+	u2 := *u
+	u2.Ops = make(map[string]*srclib.ToolRef, len(u.Ops)+1)
+	for op, t := range u.Ops {
+		u2.Ops[op] = t
+	}
+	u2.Ops[graphOp] = tool
+	return &u2
+}
+
+// unitProvenance maps source units to the tools that produced their
+// defs and refs.
+type unitProvenance map[unit.Key]*srclib.ToolRef
+
+// readUnitProvenance returns the tools recorded (when they were
+// imported) as having produced the defs and refs of the source units
+// in s. Units whose tool wasn't recorded are omitted.
+func readUnitProvenance(s interface{}) (unitProvenance, error) {
+	ts, ok := s.(store.TreeStore)
+	if !ok {
+		return nil, fmt.Errorf("store (type %T) does not implement listing source units", s)
+	}
+	units, err := ts.Units()
+	if err != nil {
+		return nil, err
+	}
+	p := make(unitProvenance, len(units))
+	for _, u := range units {
+		if tool := u.Ops[graphOp]; tool != nil {
+			p[u.Key()] = tool
+		}
+	}
+	return p, nil
+}
+
+func defUnitKey(def *graph.Def) unit.Key {
+	return unit.Key{Repo: def.Repo, CommitID: def.CommitID, UnitType: def.UnitType, Unit: def.Unit}
+}
+
+func refUnitKey(ref *graph.Ref) unit.Key {
+	return unit.Key{Repo: ref.Repo, CommitID: ref.CommitID, UnitType: ref.UnitType, Unit: ref.Unit}
+}
+
+// producedBy reports whether the defs and refs of the source unit
+// identified by key were produced by a tool in toolchainPath.
+func (p unitProvenance) producedBy(key unit.Key, toolchainPath string) bool {
+	tool := p[key]
+	return tool != nil && tool.Toolchain == toolchainPath
+}
+
+// units returns the source units whose defs and refs were produced by
+// a tool in toolchainPath.
+func (p unitProvenance) units(toolchainPath string) []unit.ID2 {
+	seen := map[unit.ID2]bool{}
+	var ids []unit.ID2
+	for key, tool := range p {
+		id := unit.ID2{Type: key.UnitType, Name: key.Unit}
+		if tool.Toolchain == toolchainPath && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// log logs the tools that produced the defs and refs of
+// each of the given source units.
+func (p unitProvenance) log(keys map[unit.Key]struct{}) {
+	lines := make([]string, 0, len(keys))
+	for key := range keys {
+		name := key.UnitType + " " + key.Unit
+		if key.Repo != "" {
+			name = key.Repo + " " + name
+		}
+		if tool := p[key]; tool != nil {
+			lines = append(lines, fmt.Sprintf("# %s: from %s", name, tool))
+		} else {
+			lines = append(lines, fmt.Sprintf("# %s: from unknown tool (imported before tools were recorded)", name))
+		}
+	}
+	sort.Strings(lines)
+	for _, line := range lines {
+		log.Println(line)
+	}
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/toolchain"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestWithGraphTool(t *testing.T) {
+	planned := &srclib.ToolRef{Toolchain: "tc", Subcmd: "graph"}
+	u := &unit.SourceUnit{Name: "u", Type: "t", Ops: map[string]*srclib.ToolRef{"depresolve": nil}}
+
+	tests := map[string]struct {
+		env  *toolchain.Env
+		want *srclib.ToolRef
+	}{
+		"no env": {
+			want: &srclib.ToolRef{Toolchain: "tc", Subcmd: "graph"},
+		},
+		"version": {
+			env:  &toolchain.Env{Toolchain: "tc", Subcmd: "graph", ToolchainVersion: "abc123", ImageID: "img"},
+			want: &srclib.ToolRef{Toolchain: "tc", Subcmd: "graph", Version: "abc123"},
+		},
+		"image": {
+			env:  &toolchain.Env{Toolchain: "tc", Subcmd: "graph", ImageID: "img"},
+			want: &srclib.ToolRef{Toolchain: "tc", Subcmd: "graph", Version: "img"},
+		},
+	}
+	for label, test := range tests {
+		u2 := withGraphTool(u, test.env, planned)
+		if got := u2.Ops[graphOp]; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got graph tool %+v, want %+v", label, got, test.want)
+		}
+		if _, present := u2.Ops["depresolve"]; !present {
+			t.Errorf("%s: other ops were not kept", label)
+		}
+		if _, present := u.Ops[graphOp]; present {
+			t.Errorf("%s: original unit was modified", label)
+		}
+	}
+}
+
+func TestUnitProvenance(t *testing.T) {
+	p := unitProvenance{
+		{Repo: "r", CommitID: "c1", UnitType: "t", Unit: "a"}: {Toolchain: "x"},
+		{Repo: "r", CommitID: "c2", UnitType: "t", Unit: "a"}: {Toolchain: "y"},
+		{Repo: "r", CommitID: "c1", UnitType: "t", Unit: "b"}: {Toolchain: "y"},
+	}
+	if got, want := p.units("x"), []unit.ID2{{Type: "t", Name: "a"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got units %v, want %v", got, want)
+	}
+	if got := len(p.units("y")); got != 2 {
+		t.Errorf("got %d units, want 2", got)
+	}
+	if !p.producedBy(unit.Key{Repo: "r", CommitID: "c1", UnitType: "t", Unit: "a"}, "x") {
+		t.Error("want unit a at c1 to be produced by x")
+	}
+	if p.producedBy(unit.Key{Repo: "r", CommitID: "c2", UnitType: "t", Unit: "a"}, "x") {
+		t.Error("want unit a at c2 to not be produced by x")
+	}
+}
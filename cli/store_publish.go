@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+// A stagedCommit is a commit being imported into a temporary store
+// alongside the commit's dir in an fs-backed store, so that readers of
+// the store never see the commit half-imported or half-indexed. Its
+// data is moved into the store by publish.
+type stagedCommit struct {
+	root     string // root of the store (which is locked by publish)
+	repoDir  string // dir of the repo store that the commit is in
+	tmpDir   string // dir of the temporary store
+	commitID string
+
+	// store is the temporary store to import the commit into.
+	store store.RepoStoreImporter
+
+	// indexXRefs, if set, is called by publish (while the store is
+	// locked) after the commit is moved into place, to update the
+	// store's xref index with the commit's refs.
+	indexXRefs func() error
+}
+
+// stageCommit returns a stagedCommit for importing repo's commitID
+// into the store, or nil if the store isn't an fs-backed store (whose
+// commits can be replaced by renaming dirs).
+func (c *StoreCmd) stageCommit(repo, commitID string) (*stagedCommit, error) {
+	if !c.isLocalFS() || c.ReadOnly {
+		return nil, nil
+	}
+	root := c.root()
+	repoDir := root
+	switch c.Type {
+	case "RepoStore":
+	case "MultiRepoStore":
+		if repo == "" {
+			return nil, nil
+		}
+		repoDir = filepath.Join(append([]string{root}, store.DefaultRepoPaths.RepoToPath(repo)...)...)
+	default:
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		return nil, err
+	}
+	// The dir is hidden, so that it isn't listed as a commit dir.
+	tmpDir, err := ioutil.TempDir(repoDir, ".import-"+commitID+"-")
+	if err != nil {
+		return nil, err
+	}
+	fs, err := store.OpenBackend(c.Backend, tmpDir)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, err
+	}
+	staged := &stagedCommit{
+		root:     root,
+		repoDir:  repoDir,
+		tmpDir:   tmpDir,
+		commitID: commitID,
+		store:    store.NewFSRepoStore(fs),
+	}
+	if c.Type == "MultiRepoStore" {
+		// The staged commit is imported into a repo store, which
+		// doesn't know about the multi-repo store's xref index.
+		staged.indexXRefs = func() error { return c.indexXRefs(repo, commitID) }
+	}
+	return staged, nil
+}
+
+// publish replaces the commit's dir in the store with the staged
+// commit's, while it holds an exclusive lock on the store (so that no
+// other src process is querying it). If nothing was imported into
+// the staged commit, the store is left as it is.
+func (s *stagedCommit) publish() error {
+	staged := filepath.Join(s.tmpDir, s.commitID)
+	if _, err := os.Stat(staged); os.IsNotExist(err) {
+		return s.remove()
+	}
+	// The import time is published with the commit (see
+	// store.SetImportTime).
+	if err := store.SetImportTime(s.store, "", s.commitID, time.Now()); err != nil {
+		s.remove()
+		return err
+	}
+
+	unlock, err := lockStore(s.root, true, true)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(s.repoDir, s.commitID)
+	old := filepath.Join(s.tmpDir, ".old")
+	if err := os.Rename(dir, old); err != nil && !os.IsNotExist(err) {
+		unlock()
+		return err
+	}
+	if err := os.Rename(staged, dir); err != nil {
+		os.Rename(old, dir)
+		unlock()
+		return fmt.Errorf("publishing commit %s: %s", s.commitID, err)
+	}
+	if s.indexXRefs != nil {
+		if err := s.indexXRefs(); err != nil {
+			// The commit is already published, so keep it (and remove
+			// its previous data), but the xref index is now stale.
+			unlock()
+			s.remove()
+			return fmt.Errorf("indexing xrefs from commit %s (published, but the xref index is incomplete; rebuild it with `src store xrefs --reindex`): %s", s.commitID, err)
+		}
+	}
+	if err := unlock(); err != nil {
+		return err
+	}
+	return s.remove()
+}
+
+// remove removes the staged commit (and, after publish, the commit's
+// previous data).
+func (s *stagedCommit) remove() error { return os.RemoveAll(s.tmpDir) }
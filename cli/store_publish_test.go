@@ -0,0 +1,188 @@
+package cli
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestStagedCommit_publish(t *testing.T) {
+	root, err := ioutil.TempDir("", "srclib-store-publish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	c := &StoreCmd{Type: "RepoStore", Root: root, Backend: "fs"}
+
+	importDefs := func(paths ...string) {
+		staged, err := c.stageCommit("", "c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data graph.Output
+		for _, path := range paths {
+			data.Defs = append(data.Defs, &graph.Def{DefKey: graph.DefKey{Path: path}, Name: path, File: "f"})
+		}
+		if err := staged.store.Import("c", &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}, data); err != nil {
+			t.Fatal(err)
+		}
+		if err := staged.store.(store.RepoIndexer).Index("c"); err != nil {
+			t.Fatal(err)
+		}
+
+		// Readers don't see the commit until it's published.
+		rs := store.NewFSRepoStore(rwvfs.OS(root))
+		before, err := rs.Defs(store.ByCommitIDs("c"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := staged.publish(); err != nil {
+			t.Fatal(err)
+		}
+		after, err := rs.Defs(store.ByCommitIDs("c"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(before) == len(paths) || len(after) != len(paths) {
+			t.Errorf("got %d defs before and %d after publishing, want %d after", len(before), len(after), len(paths))
+		}
+		if _, ok, err := store.ImportTime(rs, "", "c"); err != nil || !ok {
+			t.Errorf("got no import time (error %v), want the publish time", err)
+		}
+	}
+	importDefs("a")
+	importDefs("a", "b")
+
+	// The staging dirs are removed, and only the commit is listed.
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) != 1 || names[0] != "c" {
+		t.Errorf("got store dirs %v, want only the commit dir", names)
+	}
+	if _, err := os.Stat(filepath.Join(root, storeLockFile)); err != nil {
+		t.Errorf("lock file: %s", err)
+	}
+}
+
+func TestStagedCommit_publishXRefs(t *testing.T) {
+	root, err := ioutil.TempDir("", "srclib-store-publish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	c := &StoreCmd{Type: "MultiRepoStore", Root: root, Backend: "fs"}
+
+	staged, err := c.stageCommit("a", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := graph.Output{Refs: []*graph.Ref{
+		{DefRepo: "lib", DefUnitType: "t", DefUnit: "u", DefPath: "p", Repo: "a", CommitID: "c", UnitType: "t", Unit: "u", File: "f", Start: 1, End: 2},
+	}}
+	if err := staged.store.Import("c", &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := staged.publish(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := c.open(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xrefs, err := s.(store.XRefStore).XRefs(graph.RefDefKey{DefRepo: "lib", DefUnitType: "t", DefUnit: "u", DefPath: "p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(xrefs) != 1 || xrefs[0].Repo != "a" || len(xrefs[0].Refs) != 1 {
+		t.Errorf("got xrefs %+v, want 1 xref from repo a", xrefs)
+	}
+}
+
+func TestStagedCommit_publishXRefsError(t *testing.T) {
+	root, err := ioutil.TempDir("", "srclib-store-publish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	c := &StoreCmd{Type: "MultiRepoStore", Root: root, Backend: "fs"}
+
+	staged, err := c.stageCommit("a", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := staged.store.Import("c", &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}, graph.Output{}); err != nil {
+		t.Fatal(err)
+	}
+	staged.indexXRefs = func() error { return errors.New("fail") }
+	if err := staged.publish(); err == nil || !strings.Contains(err.Error(), "--reindex") {
+		t.Errorf("got error %v, want it to say how to rebuild the xref index", err)
+	}
+
+	// The commit is published, and the staging dir is removed.
+	if _, err := os.Stat(filepath.Join(staged.repoDir, "c")); err != nil {
+		t.Errorf("published commit: %s", err)
+	}
+	if _, err := os.Stat(staged.tmpDir); !os.IsNotExist(err) {
+		t.Errorf("got staging dir stat error %v, want it removed", err)
+	}
+}
+
+func TestLockStore_upgrade(t *testing.T) {
+	root, err := ioutil.TempDir("", "srclib-store-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// Without a lock file, readers don't lock (or create one).
+	unlock, err := lockStore(root, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if _, err := os.Stat(filepath.Join(root, storeLockFile)); !os.IsNotExist(err) {
+		t.Fatalf("got lock file (error %v), want none", err)
+	}
+
+	// A process's exclusive lock doesn't wait for its own shared
+	// locks.
+	unlockEx, err := lockStore(root, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockEx()
+	unlockSh, err := lockStore(root, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unlockEx, err = lockStore(root, true, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := unlockEx(); err != nil {
+		t.Fatal(err)
+	}
+	if err := unlockSh(); err != nil {
+		t.Fatal(err)
+	}
+	l := storeLocks[filepath.Join(root, storeLockFile)]
+	if l.shared != 0 || l.exclusive != 0 {
+		t.Errorf("got %d shared and %d exclusive holders after unlocking, want none", l.shared, l.exclusive)
+	}
+}
@@ -0,0 +1,442 @@
+package cli
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+	"sourcegraph.com/sourcegraph/srclib/util"
+)
+
+type StoreServeCmd struct {
+	AuditLog        string `long:"audit-log" description:"append a JSON line for each query (with the time, the user that the client runs as, the query options, and the number of results) to this file" value-name:"FILE"`
+	AuditLogMaxSize int64  `long:"audit-log-max-size" description:"rotate the audit log when it would exceed this many bytes (0 to never rotate)" default:"104857600" value-name:"BYTES"`
+	AuditLogKeep    int    `long:"audit-log-keep" description:"number of rotated audit logs (FILE.1, FILE.2, ...) to keep" default:"10" value-name:"N"`
+
+	SharedGroup string `long:"shared-group" description:"also answer the queries of members of this group (a name or gid), through a socket in --socket-dir that the group can access; each client's user and group are checked using the socket's peer credentials" value-name:"GROUP"`
+}
+
+var storeServeCmd StoreServeCmd
+
+func (c *StoreServeCmd) Execute(args []string) error {
+	sock, err := storeCmd.socketPath()
+	if err != nil {
+		return err
+	}
+	access := storeAccess{gid: -1}
+	if c.SharedGroup != "" {
+		if storeCmd.SocketDir == "" {
+			return errors.New("--shared-group requires --socket-dir (a dir that the group's members can reach)")
+		}
+		if !peerCredsSupported {
+			return errors.New("--shared-group is not supported on this OS, which doesn't report the users of socket clients")
+		}
+		if access.gid, err = lookupGroupID(c.SharedGroup); err != nil {
+			return err
+		}
+	}
+	if err := makeStoreSocketDir(filepath.Dir(sock), access.gid); err != nil {
+		return err
+	}
+	if err := checkStoreSocket(sock); err == nil {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			conn.Close()
+			return fmt.Errorf("a store daemon is already serving this store on %s", sock)
+		}
+		// Nothing is listening, so the socket is left over from
+		// a daemon that didn't shut down cleanly.
+		if err := os.Remove(sock); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("not replacing %s: %s", sock, err)
+	}
+
+	s, err := OpenStoreReadOnly()
+	if err != nil {
+		return err
+	}
+	// The daemon runs indefinitely, so it mustn't hold a lock on the
+	// store (which would keep imports from ever publishing) except
+	// while it answers a query.
+	releaseStoreLocks()
+	var lockRoot string
+	if storeCmd.isLocalFS() {
+		lockRoot = storeCmd.root()
+	}
+	var audit *auditLog
+	if c.AuditLog != "" {
+		audit, err = openAuditLog(c.AuditLog, c.AuditLogMaxSize, c.AuditLogKeep)
+		if err != nil {
+			return err
+		}
+		defer audit.Close()
+	}
+
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		return err
+	}
+	if access.gid >= 0 {
+		// Connecting to a socket requires write access to it.
+		if err := os.Chown(sock, -1, access.gid); err != nil {
+			l.Close()
+			return err
+		}
+		if err := os.Chmod(sock, 0660); err != nil {
+			l.Close()
+			return err
+		}
+	}
+	var stopping bool
+	var mu sync.Mutex
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		<-c
+		mu.Lock()
+		stopping = true
+		mu.Unlock()
+		l.Close() // also removes the socket file
+	}()
+
+	log.Printf("Serving %s store at %s on %s", storeCmd.Type, storeCmd.root(), sock)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			mu.Lock()
+			defer mu.Unlock()
+			if stopping {
+				return nil
+			}
+			return err
+		}
+		// Each connection gets its own service, which knows the user
+		// that the client runs as (for the audit log).
+		cred, credErr := peerCreds(conn.(*net.UnixConn))
+		svc, err := storeService{store: s, audit: audit, lockRoot: lockRoot}.serviceFor(access, cred, credErr)
+		if err != nil {
+			log.Printf("Rejecting store daemon client: %s", err)
+			conn.Close()
+			continue
+		}
+		srv := rpc.NewServer()
+		if err := srv.RegisterName("Store", svc); err != nil {
+			return err
+		}
+		go srv.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// socketPath returns the path of the unix socket that `src store
+// serve` listens on for the store specified by c. It is outside of
+// the store root so that it is never mistaken for store data.
+func (c *StoreCmd) socketPath() (string, error) {
+	root, err := filepath.Abs(c.root())
+	if err != nil {
+		return "", err
+	}
+	dir := c.SocketDir
+	if dir == "" {
+		dir, err = storeSocketDir()
+	} else {
+		dir, err = filepath.Abs(dir)
+	}
+	if err != nil {
+		return "", err
+	}
+	h := sha1.Sum([]byte(c.Type + "\x00" + c.Backend + "\x00" + c.URL + "\x00" + root))
+	return filepath.Join(dir, fmt.Sprintf("store-%x.sock", h[:8])), nil
+}
+
+// storeSocketDir returns the dir that holds the current user's store
+// daemon sockets: srclib in $XDG_RUNTIME_DIR or, if that isn't set,
+// .srclib/run in the user's home dir. Unlike a shared temp dir, other
+// users can't create (or remove) sockets in it.
+func storeSocketDir() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "srclib"), nil
+	}
+	home := util.CurrentUserHomeDir()
+	if home == "" {
+		return "", errors.New("no XDG_RUNTIME_DIR and current user has no home directory")
+	}
+	return filepath.Join(home, ".srclib", "run"), nil
+}
+
+// makeStoreSocketDir creates the store daemon socket dir (if it
+// doesn't exist) and makes sure that only the current user can access
+// it, or, if gid >= 0, that the group can also read it (but not
+// create or remove sockets in it).
+func makeStoreSocketDir(dir string, gid int) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("store daemon socket dir %s is not a directory", dir)
+	}
+	if err := checkFileOwner(fi); err != nil {
+		return fmt.Errorf("store daemon socket dir %s: %s", dir, err)
+	}
+	perm := os.FileMode(0700)
+	if gid >= 0 {
+		if err := os.Chown(dir, -1, gid); err != nil {
+			return err
+		}
+		perm = 0750
+	}
+	if fi.Mode().Perm() != perm {
+		return os.Chmod(dir, perm)
+	}
+	return nil
+}
+
+// checkStoreSocket returns an error if sock isn't a socket owned by
+// the current user or, for a daemon shared with a group, by the owner
+// of its dir (which only that user may write to, so no one else could
+// have put the socket there). Clients don't connect to (and daemons
+// don't remove) sockets that fail the check.
+func checkStoreSocket(sock string) error {
+	fi, err := os.Lstat(sock)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return errors.New("not a socket")
+	}
+	ownerErr := checkFileOwner(fi)
+	if ownerErr == nil {
+		return nil
+	}
+	dir, err := os.Lstat(filepath.Dir(sock))
+	if err != nil {
+		return err
+	}
+	if !dir.IsDir() || dir.Mode().Perm()&0022 != 0 || !sameFileOwner(fi, dir) {
+		return ownerErr
+	}
+	return nil
+}
+
+// peerCred is the user and (primary) group that a store daemon client
+// runs as.
+type peerCred struct{ uid, gid int }
+
+// storeAccess decides which clients may query a store daemon.
+type storeAccess struct {
+	// gid is the group whose members may query the daemon (as well as
+	// the user that runs it), or -1 if the daemon isn't shared.
+	gid int
+}
+
+// check returns an error if a client that runs as cred may not query
+// the daemon.
+func (a storeAccess) check(cred peerCred) error {
+	if a.gid < 0 || cred.uid == os.Getuid() || cred.gid == a.gid {
+		return nil
+	}
+	if u, err := user.LookupId(strconv.Itoa(cred.uid)); err == nil {
+		if gids, err := u.GroupIds(); err == nil {
+			for _, gid := range gids {
+				if gid == strconv.Itoa(a.gid) {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("uid %d is not in group %d", cred.uid, a.gid)
+}
+
+// lookupGroupID returns the gid of the named group (or of the group
+// whose gid is name).
+func lookupGroupID(name string) (int, error) {
+	if gid, err := strconv.Atoi(name); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// storeService answers store queries for the store daemon. Its
+// methods take the same options as the corresponding store
+// subcommands.
+type storeService struct {
+	store interface{}
+	audit *auditLog // if nil, queries aren't logged
+
+	// lockRoot is the root of the store to take a shared lock on
+	// while answering each query, or "" for stores that aren't
+	// locked.
+	lockRoot string
+
+	// user is the name of the user that the client runs as, from
+	// the connection's peer credentials (not from the client, which
+	// could claim to be anyone).
+	user string
+}
+
+// serviceFor returns a copy of s that answers the queries of a client
+// that runs as cred (or whose credentials couldn't be determined, if
+// credErr != nil), or an error if access doesn't let the client query
+// the daemon.
+func (s storeService) serviceFor(access storeAccess, cred peerCred, credErr error) (*storeService, error) {
+	if credErr != nil {
+		if access.gid >= 0 {
+			return nil, fmt.Errorf("getting the client's user: %s", credErr)
+		}
+		if s.audit != nil {
+			log.Printf("Warning: getting the user of a store daemon client: %s", credErr)
+		}
+		return &s, nil
+	}
+	if err := access.check(cred); err != nil {
+		return nil, err
+	}
+	if s.audit != nil {
+		s.user = username(cred.uid)
+	}
+	return &s, nil
+}
+
+// username returns the name (or, if it has none, the uid) of the user
+// with the given uid.
+func username(uid int) string {
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return "uid " + strconv.Itoa(uid)
+}
+
+func (s *storeService) Units(args *StoreUnitsCmd, reply *[]*unit.SourceUnit) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	units, err := args.get(s.store, args.filters())
+	unlock()
+	*reply = units
+	if logErr := s.log("Units", args, len(units), err); logErr != nil {
+		return logErr
+	}
+	return err
+}
+
+func (s *storeService) Defs(args *StoreDefsCmd, reply *[]*graph.Def) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defs, err := args.get(s.store, args.filters())
+	unlock()
+	*reply = defs
+	if logErr := s.log("Defs", args, len(defs), err); logErr != nil {
+		return logErr
+	}
+	return err
+}
+
+func (s *storeService) Refs(args *StoreRefsCmd, reply *[]*graph.Ref) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	refs, err := args.get(s.store, args.filters())
+	unlock()
+	*reply = refs
+	if logErr := s.log("Refs", args, len(refs), err); logErr != nil {
+		return logErr
+	}
+	return err
+}
+
+// lock takes a shared lock on the store for a query.
+func (s *storeService) lock() (unlock func() error, err error) {
+	if s.lockRoot == "" {
+		return func() error { return nil }, nil
+	}
+	return lockStore(s.lockRoot, false, false)
+}
+
+// log writes a query to the audit log (if any). If it can't, the
+// query fails, so that no query goes unlogged.
+func (s *storeService) log(method string, args interface{}, results int, queryErr error) error {
+	if s.audit == nil {
+		return nil
+	}
+	e := &auditEntry{Time: time.Now().UTC(), User: s.user, Method: method, Query: auditQuery(args), Results: results}
+	if queryErr != nil {
+		e.Error = queryErr.Error()
+	}
+	if err := s.audit.Log(e); err != nil {
+		log.Printf("Writing audit log: %s", err)
+		return fmt.Errorf("store daemon couldn't write its audit log: %s", err)
+	}
+	return nil
+}
+
+var (
+	storeDaemonClientsMu sync.Mutex
+	storeDaemonClients   = map[string]*rpc.Client{}
+)
+
+// callStoreDaemon calls the named storeService method on the store
+// daemon serving the store that OpenStoreReadOnly would open. It
+// reports whether a daemon handled the call; if not, the caller
+// should query the store itself.
+func callStoreDaemon(method string, args, reply interface{}) (bool, error) {
+	sock, err := storeCmd.socketPath()
+	if err != nil {
+		return false, nil
+	}
+
+	storeDaemonClientsMu.Lock()
+	client, present := storeDaemonClients[sock]
+	if !present && checkStoreSocket(sock) == nil {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			client = jsonrpc.NewClient(conn)
+			storeDaemonClients[sock] = client
+		}
+	}
+	storeDaemonClientsMu.Unlock()
+	if client == nil {
+		return false, nil
+	}
+
+	err = client.Call("Store."+method, args, reply)
+	if _, ok := err.(rpc.ServerError); err != nil && !ok {
+		// The daemon went away; forget it and fall back to reading
+		// the store directly.
+		storeDaemonClientsMu.Lock()
+		if storeDaemonClients[sock] == client {
+			delete(storeDaemonClients, sock)
+		}
+		storeDaemonClientsMu.Unlock()
+		client.Close()
+		if GlobalOpt.Verbose {
+			log.Printf("Warning: store daemon on %s failed (%s); reading the store directly", sock, err)
+		}
+		return false, nil
+	}
+	return true, err
+}
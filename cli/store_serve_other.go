@@ -0,0 +1,34 @@
+// +build !windows
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkFileOwner returns an error if the file described by fi isn't
+// owned by the current user.
+func checkFileOwner(fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.New("unknown owner")
+	}
+	if int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("owned by uid %d, not the current user (uid %d)", st.Uid, os.Getuid())
+	}
+	return nil
+}
+
+// sameFileOwner reports whether the files described by a and b are
+// owned by the same user.
+func sameFileOwner(a, b os.FileInfo) bool {
+	sa, ok := a.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	sb, ok := b.Sys().(*syscall.Stat_t)
+	return ok && sa.Uid == sb.Uid
+}
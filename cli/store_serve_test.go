@@ -0,0 +1,316 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestStoreDaemon(t *testing.T) {
+	// The daemon serves a store in another directory, and the store at
+	// the client's (empty) root is never opened, so results can only
+	// come from the daemon.
+	root, err := ioutil.TempDir("", "srclib-store-serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	origStoreCmd := storeCmd
+	defer func() { storeCmd = origStoreCmd }()
+	storeCmd = StoreCmd{Type: "RepoStore", Root: root, Backend: "fs"}
+
+	served, err := ioutil.TempDir("", "srclib-store-served")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(served)
+	rs := store.NewFSRepoStore(rwvfs.OS(served))
+	u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f.go"}}
+	data := graph.Output{
+		Defs: []*graph.Def{
+			{DefKey: graph.DefKey{Path: "p"}, Name: "p", File: "f.go"},
+			{DefKey: graph.DefKey{Path: "q"}, Name: "q", File: "f.go"},
+		},
+		Refs: []*graph.Ref{{DefPath: "p", File: "f.go", Start: 1, End: 2}},
+	}
+	if err := rs.Import("c", u, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.(store.RepoIndexer).Index("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	runtimeDir, err := ioutil.TempDir("", "srclib-runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(runtimeDir)
+	defer func(orig string) { os.Setenv("XDG_RUNTIME_DIR", orig) }(os.Getenv("XDG_RUNTIME_DIR"))
+	os.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	sock, err := storeCmd.socketPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := makeStoreSocketDir(filepath.Dir(sock), -1); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(filepath.Dir(sock)); err != nil || fi.Mode().Perm() != 0700 {
+		t.Errorf("got socket dir %v (error %v), want mode 0700", fi, err)
+	}
+
+	// Clients don't connect to files that aren't sockets.
+	if err := ioutil.WriteFile(sock, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkStoreSocket(sock); err == nil {
+		t.Errorf("regular file %s: got no error, want it to not be trusted", sock)
+	}
+	os.Remove(sock)
+
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	audit, err := openAuditLog(filepath.Join(served, "audit.log"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			cred, credErr := peerCreds(conn.(*net.UnixConn))
+			svc, err := storeService{store: rs, audit: audit}.serviceFor(storeAccess{gid: -1}, cred, credErr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			srv := rpc.NewServer()
+			if err := srv.RegisterName("Store", svc); err != nil {
+				t.Error(err)
+				return
+			}
+			go srv.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+	defer func() {
+		for sock, client := range storeDaemonClients {
+			client.Close()
+			delete(storeDaemonClients, sock)
+		}
+	}()
+
+	units, err := (&StoreUnitsCmd{}).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 1 || units[0].Name != "u" {
+		t.Errorf("got units %v, want unit u", units)
+	}
+
+	defs, err := (&StoreDefsCmd{Path: "q"}).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 1 || defs[0].Path != "q" {
+		t.Errorf("got defs %v, want def q", defs)
+	}
+
+	refs, err := (&StoreRefsCmd{DefUnitType: "t", DefUnit: "u", DefPath: "p"}).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].Start != 1 {
+		t.Errorf("got refs %v, want 1 ref to p", refs)
+	}
+
+	// The daemon logged each query, with the user that the client
+	// runs as.
+	cur, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logData, err := ioutil.ReadFile(filepath.Join(served, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var methods []string
+	for _, line := range strings.Split(strings.TrimSpace(string(logData)), "\n") {
+		var e auditEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.User != cur.Username || e.Results != 1 {
+			t.Errorf("got audit entry %+v, want user %q and 1 result", e, cur.Username)
+		}
+		methods = append(methods, e.Method)
+	}
+	if want := []string{"Units", "Defs", "Refs"}; !reflect.DeepEqual(methods, want) {
+		t.Errorf("got audit log methods %v, want %v", methods, want)
+	}
+
+	// Queries that can't be sent to the daemon read the store
+	// directly, which doesn't exist here.
+	defs, err = (&StoreDefsCmd{Filter: byDefKind{"func"}}).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 0 {
+		t.Errorf("got defs %v from the empty local store, want none", defs)
+	}
+}
+
+func TestStoreService_shared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-store-serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rs := store.NewFSRepoStore(rwvfs.OS(filepath.Join(dir, "store")))
+	u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f.go"}}
+	if err := rs.Import("c", u, graph.Output{Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, Name: "p", File: "f.go"}}}); err != nil {
+		t.Fatal(err)
+	}
+	audit, err := openAuditLog(filepath.Join(dir, "audit.log"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+
+	// The socket dir is readable by the group, but only its owner can
+	// add sockets to it.
+	sockDir := filepath.Join(dir, "run")
+	if err := makeStoreSocketDir(sockDir, os.Getgid()); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(sockDir); err != nil || fi.Mode().Perm() != 0750 {
+		t.Errorf("got shared socket dir %v (error %v), want mode 0750", fi, err)
+	}
+
+	const gid, otherUID = 4242, 54321
+	access := storeAccess{gid: gid}
+	base := storeService{store: rs, audit: audit}
+
+	// Clients outside of the group (or whose user isn't known) are
+	// rejected.
+	if _, err := base.serviceFor(access, peerCred{uid: otherUID, gid: gid + 1}, nil); err == nil {
+		t.Error("client outside of the group: got no error")
+	}
+	if _, err := base.serviceFor(access, peerCred{}, errors.New("no creds")); err == nil {
+		t.Error("client without creds: got no error")
+	}
+
+	// Another user in the group may query, and is logged as that user.
+	svc, err := base.serviceFor(access, peerCred{uid: otherUID, gid: gid}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var defs []*graph.Def
+	if err := svc.Defs(&StoreDefsCmd{}, &defs); err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 1 {
+		t.Errorf("got defs %v, want 1", defs)
+	}
+	logData, err := ioutil.ReadFile(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e auditEntry
+	if err := json.Unmarshal(logData, &e); err != nil {
+		t.Fatal(err)
+	}
+	if want := username(otherUID); e.User != want || e.User == username(os.Getuid()) {
+		t.Errorf("got audit entry user %q, want %q", e.User, want)
+	}
+}
+
+func TestAuditLog_rotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-audit-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	// Each entry is ~60 bytes, so each log holds 2 entries.
+	l, err := openAuditLog(path, 150, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i := 0; i < 7; i++ {
+		if err := l.Log(&auditEntry{Method: "Defs", Results: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for file, want := range map[string]int{path: 1, path + ".1": 2, path + ".2": 2, path + ".3": -1} {
+		data, err := ioutil.ReadFile(file)
+		if want == -1 {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s: got error %v, want it to not exist", file, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(data), "\n"); n != want {
+			t.Errorf("%s: got %d entries, want %d", file, n, want)
+		}
+	}
+	if data, _ := ioutil.ReadFile(path); !strings.Contains(string(data), `"Results":6`) {
+		t.Errorf("got current log %q, want the last entry", data)
+	}
+}
+
+func TestAuditLog_rotateError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-audit-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	// The log can't be renamed over a non-empty dir, so it can't be
+	// rotated, but entries are still logged to it.
+	if err := os.MkdirAll(filepath.Join(path+".1", "x"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	l, err := openAuditLog(path, 100, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i := 0; i < 3; i++ {
+		if err := l.Log(&auditEntry{Method: "Defs", Results: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 3 {
+		t.Errorf("got %d entries, want 3", n)
+	}
+}
@@ -0,0 +1,208 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+type StoreStatsCmd struct {
+	Repo     string `long:"repo" description:"only show stats for this repo"`
+	CommitID string `long:"commit" description:"only show stats for this commit ID"`
+
+	TopDefs int  `long:"top-defs" description:"also list the N defs with the most xrefs (refs from other source units or repos in the store)" value-name:"N"`
+	JSON    bool `long:"json" description:"print the stats as JSON"`
+}
+
+var storeStatsCmd StoreStatsCmd
+
+// commitStats holds the stats computed by the store stats command for
+// one commit of a repo.
+type commitStats struct {
+	Repo     string `json:",omitempty"`
+	CommitID string
+
+	Units int
+	Defs  int
+	Refs  int
+	Docs  int
+
+	// Indexes and IndexBytes are the number and total size of the
+	// commit's indexes (both tree and source unit indexes).
+	Indexes    int
+	IndexBytes int64
+
+	// Indexed is when the commit's most recently built index was
+	// built (usually when the commit was imported), if known.
+	Indexed *time.Time `json:",omitempty"`
+
+	// DefsTime and RefsTime are how long it took to read all of the
+	// commit's defs and refs from the store.
+	DefsTime, RefsTime time.Duration
+}
+
+func (c *StoreStatsCmd) Execute(args []string) error {
+	s, err := OpenStoreReadOnly()
+	if err != nil {
+		return err
+	}
+	rs, ok := s.(store.RepoStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing versions", s)
+	}
+
+	var vfs []store.VersionFilter
+	if c.Repo != "" {
+		vfs = append(vfs, store.ByRepos(c.Repo))
+	}
+	if c.CommitID != "" {
+		vfs = append(vfs, store.ByCommitIDs(c.CommitID))
+	}
+	versions, err := rs.Versions(vfs...)
+	if err != nil {
+		return err
+	}
+
+	var stats []*commitStats
+	xrefs := map[graph.DefKey]int{}
+	for _, v := range versions {
+		st, err := versionStats(s, rs, v, xrefs)
+		if err != nil {
+			return fmt.Errorf("%s@%s: %s", v.Repo, v.CommitID, err)
+		}
+		stats = append(stats, st)
+	}
+	var topDefs []*defRefCount
+	if c.TopDefs > 0 {
+		topDefs = topDefRefCounts(xrefs, c.TopDefs)
+	}
+
+	if c.JSON {
+		PrintJSON(struct {
+			Commits []*commitStats
+			TopDefs []*defRefCount `json:",omitempty"`
+		}{stats, topDefs}, "  ")
+		return nil
+	}
+
+	var total commitStats
+	for _, st := range stats {
+		if st.Repo != "" {
+			colorable.Println(colorable.Bold(st.Repo + "@" + st.CommitID))
+		} else {
+			colorable.Println(colorable.Bold(st.CommitID))
+		}
+		printCommitStats(st)
+		total.Units += st.Units
+		total.Defs += st.Defs
+		total.Refs += st.Refs
+		total.Docs += st.Docs
+		total.Indexes += st.Indexes
+		total.IndexBytes += st.IndexBytes
+		total.DefsTime += st.DefsTime
+		total.RefsTime += st.RefsTime
+	}
+	if len(stats) > 1 {
+		colorable.Println(colorable.Bold(fmt.Sprintf("Total (%d commits)", len(stats))))
+		printCommitStats(&total)
+	}
+	if len(topDefs) > 0 {
+		colorable.Println(colorable.Bold("Defs with the most xrefs"))
+		for _, d := range topDefs {
+			if d.Repo != "" {
+				colorable.Printf("  %6d  %s %s (%s %s)\n", d.Refs, d.Repo, d.Path, d.UnitType, d.Unit)
+			} else {
+				colorable.Printf("  %6d  %s (%s %s)\n", d.Refs, d.Path, d.UnitType, d.Unit)
+			}
+		}
+	}
+	return nil
+}
+
+func printCommitStats(st *commitStats) {
+	colorable.Printf("  units:   %9d\n", st.Units)
+	colorable.Printf("  defs:    %9d   (read in %s)\n", st.Defs, st.DefsTime)
+	colorable.Printf("  refs:    %9d   (read in %s)\n", st.Refs, st.RefsTime)
+	colorable.Printf("  docs:    %9d\n", st.Docs)
+	colorable.Printf("  indexes: %9d   (%s)\n", st.Indexes, bytesString(uint64(st.IndexBytes)))
+	if st.Indexed != nil {
+		colorable.Printf("  indexed: %s\n", st.Indexed.Format(time.RFC3339))
+	}
+}
+
+// versionStats computes the stats of a version in the store s (whose
+// RepoStore is rs). It also adds the xrefs from the version's refs to
+// xrefs.
+func versionStats(s interface{}, rs store.RepoStore, v *store.Version, xrefs map[graph.DefKey]int) (*commitStats, error) {
+	st := &commitStats{Repo: v.Repo, CommitID: v.CommitID}
+	var (
+		ufs []store.UnitFilter
+		dfs []store.DefFilter
+		rfs []store.RefFilter
+	)
+	if v.CommitID != "" {
+		f := store.ByCommitIDs(v.CommitID)
+		ufs, dfs, rfs = append(ufs, f), append(dfs, f), append(rfs, f)
+	}
+	if v.Repo != "" {
+		f := store.ByRepos(v.Repo)
+		ufs, dfs, rfs = append(ufs, f), append(dfs, f), append(rfs, f)
+	}
+
+	units, err := rs.Units(ufs...)
+	if err != nil {
+		return nil, err
+	}
+	st.Units = len(units)
+
+	start := time.Now()
+	defs, err := rs.Defs(dfs...)
+	if err != nil {
+		return nil, err
+	}
+	st.DefsTime = time.Since(start)
+	st.Defs = len(defs)
+	for _, def := range defs {
+		st.Docs += len(def.Docs)
+	}
+
+	start = time.Now()
+	refs, err := rs.Refs(rfs...)
+	if err != nil {
+		return nil, err
+	}
+	st.RefsTime = time.Since(start)
+	st.Refs = len(refs)
+	for _, ref := range refs {
+		if ref.Def {
+			continue
+		}
+		key := graph.DefKey{Repo: ref.DefRepo, UnitType: ref.DefUnitType, Unit: ref.DefUnit, Path: ref.DefPath}
+		if key.Repo == "" {
+			key.Repo = ref.Repo
+		}
+		if key.Repo != ref.Repo || key.UnitType != ref.UnitType || key.Unit != ref.Unit {
+			xrefs[key]++
+		}
+	}
+
+	xs, err := store.Indexes(s, store.IndexCriteria{Repo: v.Repo, CommitID: v.CommitID}, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range xs {
+		if x.Stale {
+			continue
+		}
+		st.Indexes++
+		st.IndexBytes += x.Size
+		if x.ModTime != nil && (st.Indexed == nil || x.ModTime.After(*st.Indexed)) {
+			st.Indexed = x.ModTime
+		}
+	}
+	return st, nil
+}
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/alexsaveliev/go-colorable-wrapper"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+type StoreXRefsCmd struct {
+	DefRepo     string `long:"def-repo" description:"repo of the def to list xrefs to" value-name:"REPO"`
+	DefUnitType string `long:"def-unit-type" description:"source unit type of the def" value-name:"TYPE"`
+	DefUnit     string `long:"def-unit" description:"source unit of the def" value-name:"UNIT"`
+	DefPath     string `long:"def-path" description:"path of the def" value-name:"PATH"`
+
+	Reindex bool `long:"reindex" description:"rebuild the xref index from the data of every commit in the store (e.g., for data imported before the store kept an xref index)"`
+}
+
+var storeXRefsCmd StoreXRefsCmd
+
+func (c *StoreXRefsCmd) Execute(args []string) error {
+	if storeCmd.Type != "MultiRepoStore" {
+		return fmt.Errorf("xrefs are only supported by MultiRepoStore stores, not %s", storeCmd.Type)
+	}
+	if c.Reindex {
+		return c.reindex()
+	}
+	if c.DefRepo == "" || c.DefPath == "" {
+		return errors.New("--def-repo and --def-path are required (or use --reindex)")
+	}
+
+	s, err := OpenStoreReadOnly()
+	if err != nil {
+		return err
+	}
+	xrefs, err := s.(store.XRefStore).XRefs(graph.RefDefKey{
+		DefRepo:     c.DefRepo,
+		DefUnitType: c.DefUnitType,
+		DefUnit:     c.DefUnit,
+		DefPath:     c.DefPath,
+	})
+	if err != nil {
+		return err
+	}
+	if xrefs == nil {
+		xrefs = []*store.XRef{}
+	}
+	PrintJSON(xrefs, "  ")
+	return nil
+}
+
+// reindex rebuilds the xrefs from every version of every repo in the
+// store.
+func (c *StoreXRefsCmd) reindex() error {
+	if storeCmd.ReadOnly {
+		return errors.New("can't reindex xrefs in a store opened with --read-only")
+	}
+	s, err := storeCmd.store()
+	if err != nil {
+		return err
+	}
+	versions, err := s.(store.MultiRepoStore).Versions()
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if err := s.(store.XRefStore).IndexXRefs(v.Repo, v.CommitID); err != nil {
+			return fmt.Errorf("indexing xrefs from %s@%s: %s", v.Repo, v.CommitID, err)
+		}
+	}
+	colorable.Printf("Indexed xrefs from %d commits\n", len(versions))
+	return nil
+}
+
+// indexXRefs updates the xref index of a MultiRepoStore with the refs
+// of a commit of repo whose data was written to the repo's store
+// directly (not via the MultiRepoStore's Import).
+func (c *StoreCmd) indexXRefs(repo, commitID string) error {
+	if c.Type != "MultiRepoStore" {
+		return nil
+	}
+	s, err := c.open(false)
+	if err != nil {
+		return err
+	}
+	if xs, ok := s.(store.XRefStore); ok {
+		return xs.IndexXRefs(repo, commitID)
+	}
+	return nil
+}
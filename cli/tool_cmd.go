@@ -3,11 +3,14 @@ package cli
 import (
 	"bytes"
 	"encoding/json"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"strings"
 
+	"github.com/mattn/go-isatty"
 	"sourcegraph.com/sourcegraph/go-flags"
 
 	"sourcegraph.com/sourcegraph/srclib/toolchain"
@@ -28,6 +31,8 @@ func init() {
 type ToolCmd struct {
 	ToolchainExecOpt
 
+	EnvOutput string `long:"env-output" description:"write the environment that the tool runs in (as JSON) to this file, for use by the repro command" value-name:"FILE"`
+
 	Args struct {
 		Toolchain ToolchainPath `name:"TOOLCHAIN" description:"toolchain path of the toolchain to run"`
 		Tool      ToolName      `name:"TOOL" description:"tool subcommand name to run (in TOOLCHAIN)"`
@@ -52,6 +57,14 @@ func (c *ToolCmd) Execute(args []string) error {
 		cmder = tc
 	}
 
+	if c.EnvOutput != "" {
+		// The env is only used to reproduce the tool run, so the
+		// tool still runs without it.
+		if err := writeToolEnv(c.EnvOutput, string(c.Args.Toolchain), string(c.Args.Tool), c.ToolchainMode()); err != nil {
+			log.Printf("Warning: writing the tool's environment to %s: %s", c.EnvOutput, err)
+		}
+	}
+
 	// HACK: Buffer stdout to work around
 	// https://github.com/docker/docker/issues/3631. Otherwise, lots
 	// of builds fail. Also, if a lot of data is printed, the return
@@ -63,14 +76,31 @@ func (c *ToolCmd) Execute(args []string) error {
 			log.Fatal(err)
 		}
 		cmd.Args = append(cmd.Args, c.Args.ToolArgs...)
-		cmd.Stderr = os.Stderr
+		// Keep the end of stderr and the input (unless it's
+		// interactive) for a diagnostics bundle in case the tool
+		// crashes.
+		stderr := &tailBuffer{max: maxDiagnosticsStderr}
+		cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
 		var out bytes.Buffer
 		cmd.Stdout = &out
-		cmd.Stdin = os.Stdin
+		var input bytes.Buffer
+		if isatty.IsTerminal(os.Stdin.Fd()) {
+			cmd.Stdin = os.Stdin
+		} else {
+			cmd.Stdin = io.TeeReader(os.Stdin, &input)
+		}
 		if GlobalOpt.Verbose {
 			log.Printf("Running tool: %v", cmd.Args)
 		}
 		if err := cmd.Run(); err != nil {
+			if _, crashed := err.(*exec.ExitError); crashed {
+				dir, err2 := writeToolDiagnostics(cmd, err, string(c.Args.Toolchain), string(c.Args.Tool), c.ToolchainMode(), stderr, input.Bytes())
+				if err2 != nil {
+					log.Printf("Warning: writing diagnostics for crashed tool: %s", err2)
+				} else {
+					log.Print(toolCrashMessage(string(c.Args.Toolchain), string(c.Args.Tool), dir))
+				}
+			}
 			log.Fatal(err)
 		}
 
@@ -92,6 +122,20 @@ func (c *ToolCmd) Execute(args []string) error {
 	}
 }
 
+// writeToolEnv writes the JSON-encoded toolchain.Env of the tool to
+// file.
+func writeToolEnv(file, toolchainPath, subcmd string, mode toolchain.Mode) error {
+	env, err := toolchain.CaptureEnv(toolchainPath, subcmd, mode)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
 type ToolName string
 
 func (t ToolName) Complete(match string) []flags.Completion {
@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"sourcegraph.com/sourcegraph/srclib/toolchain"
+)
+
+// diagnosticsDirName is the directory (relative to the dir that tools
+// run in, which during `src make` is the top-level dir of the
+// repository) that diagnostics bundles are written to when a tool
+// crashes.
+const diagnosticsDirName = ".srclib-diagnostics"
+
+// maxDiagnosticsStderr is the maximum number of bytes of a crashed
+// tool's stderr that are kept in its diagnostics bundle. Only the end
+// of its stderr is kept, since that's where the crash is usually
+// reported.
+const maxDiagnosticsStderr = 1 << 20
+
+// toolDiagnostics describes a tool crash. It is written to the
+// info.json file of a diagnostics bundle.
+type toolDiagnostics struct {
+	// Args are the args of the tool's command.
+	Args []string
+
+	// Error is the error returned by the tool's command (e.g., "exit
+	// status 2").
+	Error string
+
+	// Time is when the tool crashed.
+	Time time.Time
+
+	// SrclibVersion, GOOS, and GOARCH describe the srclib program
+	// that ran the tool.
+	SrclibVersion string
+	GOOS, GOARCH  string
+
+	// Env is the environment (including the toolchain version) that
+	// the tool ran in, or nil if it couldn't be determined (in which
+	// case EnvError says why).
+	Env      *toolchain.Env `json:",omitempty"`
+	EnvError string         `json:",omitempty"`
+
+	// StderrTruncated is true if only the end of the tool's stderr
+	// was kept.
+	StderrTruncated bool `json:",omitempty"`
+}
+
+// writeToolDiagnostics writes a diagnostics bundle for a tool whose
+// command failed with runErr to a new dir under diagnosticsDirName,
+// and returns the dir's path. The bundle contains the info.json file
+// (see toolDiagnostics), the tool's stderr (stderr.txt), and the input
+// that the tool read from stdin (input.json), such as the source unit
+// it was run on.
+func writeToolDiagnostics(cmd *exec.Cmd, runErr error, toolchainPath, subcmd string, mode toolchain.Mode, stderr *tailBuffer, input []byte) (string, error) {
+	if err := os.MkdirAll(diagnosticsDirName, 0755); err != nil {
+		return "", err
+	}
+	prefix := strings.Join([]string{time.Now().Format("20060102T150405"), path.Base(toolchainPath), subcmd}, "-")
+	dir, err := ioutil.TempDir(diagnosticsDirName, prefix+"-")
+	if err != nil {
+		return "", err
+	}
+
+	info := toolDiagnostics{
+		Args:            cmd.Args,
+		Error:           runErr.Error(),
+		Time:            time.Now(),
+		SrclibVersion:   Version,
+		GOOS:            runtime.GOOS,
+		GOARCH:          runtime.GOARCH,
+		StderrTruncated: stderr.truncated,
+	}
+	if env, err := toolchain.CaptureEnv(toolchainPath, subcmd, mode); err == nil {
+		info.Env = env
+	} else {
+		info.EnvError = err.Error()
+	}
+	infoData, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	files := map[string][]byte{
+		"info.json":  infoData,
+		"stderr.txt": stderr.Bytes(),
+	}
+	if len(input) > 0 {
+		files["input.json"] = input
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return "", err
+		}
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return dir, nil
+}
+
+// tailBuffer is a writer that keeps the last max bytes written to it.
+type tailBuffer struct {
+	max       int
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) > b.max {
+		p = p[len(p)-b.max:]
+		b.truncated = true
+	}
+	if over := b.buf.Len() + len(p) - b.max; over > 0 {
+		b.buf.Next(over)
+		b.truncated = true
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+func (b *tailBuffer) Bytes() []byte { return b.buf.Bytes() }
+
+// toolCrashMessage returns the message that tells the user where the
+// diagnostics bundle for a crashed tool is.
+func toolCrashMessage(toolchainPath, subcmd, dir string) string {
+	return fmt.Sprintf("Tool %s %s crashed. Diagnostics (its stderr, input, environment, and versions) were saved to %s; include them when reporting the problem to the toolchain's maintainers (after checking that they contain nothing confidential).", toolchainPath, subcmd, dir)
+}
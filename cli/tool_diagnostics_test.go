@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/toolchain"
+)
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 5}
+	for _, s := range []string{"ab", "cd"} {
+		b.Write([]byte(s))
+	}
+	if got := string(b.Bytes()); got != "abcd" || b.truncated {
+		t.Errorf("got %q (truncated=%v), want %q", got, b.truncated, "abcd")
+	}
+	b.Write([]byte("ef"))
+	if got := string(b.Bytes()); got != "bcdef" || !b.truncated {
+		t.Errorf("got %q (truncated=%v), want %q truncated", got, b.truncated, "bcdef")
+	}
+	b.Write([]byte("0123456789"))
+	if got := string(b.Bytes()); got != "56789" {
+		t.Errorf("got %q, want %q", got, "56789")
+	}
+}
+
+func TestWriteToolDiagnostics(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "srclib-diagnostics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatal(err)
+	}
+
+	stderr := &tailBuffer{max: maxDiagnosticsStderr}
+	stderr.Write([]byte("panic: oops\n"))
+	cmd := exec.Command("srclib-go", "graph")
+	dir, err := writeToolDiagnostics(cmd, errors.New("exit status 2"), "example.com/srclib-go", "graph", toolchain.AsProgram, stderr, []byte(`{"Name":"u"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filepath.IsAbs(dir) || !strings.Contains(dir, diagnosticsDirName) || !strings.Contains(filepath.Base(dir), "srclib-go-graph-") {
+		t.Errorf("got diagnostics dir %q, want an absolute path under %s named for the tool", dir, diagnosticsDirName)
+	}
+
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if got := read("stderr.txt"); got != "panic: oops\n" {
+		t.Errorf("got stderr %q", got)
+	}
+	if got := read("input.json"); got != `{"Name":"u"}` {
+		t.Errorf("got input %q", got)
+	}
+	var info toolDiagnostics
+	if err := json.Unmarshal([]byte(read("info.json")), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Error != "exit status 2" || info.SrclibVersion != Version || len(info.Args) != 2 {
+		t.Errorf("got info %+v", info)
+	}
+	if info.Env == nil && info.EnvError == "" {
+		t.Error("got neither Env nor EnvError")
+	}
+}
@@ -273,6 +273,20 @@ func (c *ToolchainGetCmd) Execute(args []string) error {
 		if GlobalOpt.Verbose {
 			colorable.Println(tc)
 		}
+		if isOffline() {
+			// Only toolchains that are already present can be
+			// gotten without the network.
+			if c.Update {
+				return checkOnline("updating toolchains")
+			}
+			dir, err := toolchain.Dir(string(tc))
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(dir); os.IsNotExist(err) {
+				return checkOnline(fmt.Sprintf("downloading toolchain %s", tc))
+			}
+		}
 		_, err := toolchain.CloneOrUpdate(string(tc), c.Update)
 		if err != nil {
 			return err
@@ -400,6 +414,9 @@ type ToolchainInstallCmd struct {
 var toolchainInstallCmd ToolchainInstallCmd
 
 func (c *ToolchainInstallCmd) Execute(args []string) error {
+	if err := checkOnline("installing toolchains"); err != nil {
+		return err
+	}
 	if len(c.Args.Languages) == 0 {
 		return errors.New(colorable.Red(fmt.Sprintf("No languages specified. Standard languages include: %s", stdToolchains.listKeys())))
 	}
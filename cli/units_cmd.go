@@ -8,6 +8,7 @@ import (
 
 	"github.com/alexsaveliev/go-colorable-wrapper"
 
+	"sourcegraph.com/sourcegraph/srclib/buildstore"
 	"sourcegraph.com/sourcegraph/srclib/config"
 	"sourcegraph.com/sourcegraph/srclib/scan"
 	"sourcegraph.com/sourcegraph/srclib/toolchain"
@@ -63,6 +64,19 @@ func scanUnitsIntoConfig(cfg *config.Repository, configOpt config.Options, execO
 		}
 	}
 
+	// Skip scanned source units in dirs that the repo ignores (e.g.,
+	// vendored or generated code).
+	ignorer, err := buildstore.ReadIgnoreFiles(".")
+	if err != nil {
+		return err
+	}
+
+	// Source units in nested repositories (such as git submodules)
+	// belong to those repositories, not this one.
+	if err := addNestedRepos(cfg, "."); err != nil {
+		return err
+	}
+
 	// collect manually specified source units by ID
 	manualUnits := make(map[unit.ID]*unit.SourceUnit, len(cfg.SourceUnits))
 	for _, u := range cfg.SourceUnits {
@@ -92,6 +106,22 @@ func scanUnitsIntoConfig(cfg *config.Repository, configOpt config.Options, execO
 			continue
 		}
 
+		if r := config.NestedRepoAt(cfg.NestedRepos, filepath.ToSlash(unitDir)); r != nil {
+			if GlobalOpt.Verbose {
+				log.Printf("Skipping source unit %q in nested repository %s (in %q).", u.ID(), r.URI, r.Dir)
+			}
+			r.Units = append(r.Units, unit.ID2{Type: u.Type, Name: u.Name})
+			continue
+		}
+
+		// heed .gitignore and .srclibignore
+		if unitDir != "" && ignorer.IgnoredTree(unitDir, true) {
+			if GlobalOpt.Verbose {
+				log.Printf("Skipping source unit %q in ignored dir %q.", u.ID(), unitDir)
+			}
+			continue
+		}
+
 		skip := false
 		for _, skipUnit := range cfg.SkipUnits {
 			if u.Name == skipUnit.Name && u.Type == skipUnit.Type {
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,13 +11,18 @@ import (
 	"net/http/httputil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/alexsaveliev/go-colorable-wrapper"
+	"github.com/mattn/go-isatty"
 
 	"golang.org/x/tools/godoc/vfs"
 
 	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/doc"
+	"sourcegraph.com/sourcegraph/srclib/graph"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
 
@@ -211,3 +217,130 @@ func parseRepoAndCommitID(repoAndCommitID string) (uri, commitID string) {
 	}
 	return repoAndCommitID, ""
 }
+
+// docFormatPreference lists def doc formats (MIME types) from most to
+// least preferred for display in the terminal. The richer formats are
+// preferred because they retain structure (lists, code blocks, and
+// links) that the renderer can display.
+var docFormatPreference = []string{"text/html", "text/x-markdown", "text/markdown", "text/plain", "text/x-rst"}
+
+// preferredDoc returns the doc in the format best suited for display
+// in the terminal, or nil if there are no docs.
+func preferredDoc(docs []*graph.DefDoc) *graph.DefDoc {
+	for _, format := range docFormatPreference {
+		for _, d := range docs {
+			if d.Format == format && strings.TrimSpace(d.Data) != "" {
+				return d
+			}
+		}
+	}
+	if len(docs) > 0 {
+		return docs[0]
+	}
+	return nil
+}
+
+// renderDoc renders d as wrapped text for display on stdout, styled
+// if stdout is a terminal.
+func renderDoc(d *graph.DefDoc) string {
+	out, err := doc.ToTerminal(doc.FormatForMIMEType(d.Format), []byte(d.Data), terminalDocOptions())
+	if err != nil && GlobalOpt.Verbose {
+		log.Printf("Warning: rendering %s doc: %s", d.Format, err)
+	}
+	return out
+}
+
+// terminalDocOptions returns the options for rendering docs to stdout:
+// they are wrapped to $COLUMNS (default 80) and styled only if stdout
+// is a terminal.
+func terminalDocOptions() doc.TerminalOptions {
+	width := 80
+	if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && cols > 0 {
+		width = cols
+	}
+	return doc.TerminalOptions{Width: width, Color: isatty.IsTerminal(os.Stdout.Fd())}
+}
+
+// lineColToByteOffset returns the byte offset in data of the 1-based
+// line and (byte) column.
+func lineColToByteOffset(data []byte, line, col int) (uint32, error) {
+	if line < 1 || col < 1 {
+		return 0, fmt.Errorf("invalid position %d:%d (line and column are 1-based)", line, col)
+	}
+	ofs := 0
+	for l := 1; l < line; l++ {
+		i := bytes.IndexByte(data[ofs:], '\n')
+		if i == -1 {
+			return 0, fmt.Errorf("line %d is past the end of the file", line)
+		}
+		ofs += i + 1
+	}
+	lineEnd := len(data)
+	if i := bytes.IndexByte(data[ofs:], '\n'); i != -1 {
+		lineEnd = ofs + i
+	}
+	if ofs+col-1 > lineEnd {
+		return 0, fmt.Errorf("column %d is past the end of line %d", col, line)
+	}
+	return uint32(ofs + col - 1), nil
+}
+
+// byteOffsetToLineCol returns the 1-based line and (byte) column of
+// the byte offset in data.
+func byteOffsetToLineCol(data []byte, ofs uint32) (line, col int) {
+	if int(ofs) > len(data) {
+		ofs = uint32(len(data))
+	}
+	before := data[:ofs]
+	line = bytes.Count(before, []byte{'\n'}) + 1
+	col = len(before) - (bytes.LastIndexByte(before, '\n') + 1) + 1
+	return line, col
+}
+
+// lineAt returns the line in data that contains the byte offset (not
+// including its trailing newline).
+func lineAt(data []byte, ofs uint32) []byte {
+	if int(ofs) > len(data) {
+		ofs = uint32(len(data))
+	}
+	start := bytes.LastIndexByte(data[:ofs], '\n') + 1
+	end := len(data)
+	if i := bytes.IndexByte(data[ofs:], '\n'); i != -1 {
+		end = int(ofs) + i
+	}
+	return data[start:end]
+}
+
+// filePosition returns the FILE:LINE:COL position of the byte range
+// [start, end) in file (a slash-separated path in the current
+// repository) followed by the text of the line that the range starts
+// on. If the file can't be read, the position is given by byte
+// offsets.
+func filePosition(file string, start, end uint32) string {
+	return filePositionAs(file, file, start, end)
+}
+
+// filePositionAs is like filePosition, but prints the file's path as
+// display.
+func filePositionAs(file, display string, start, end uint32) string {
+	data, err := defaultFileCache.readFile(filepath.FromSlash(file))
+	if err != nil {
+		if GlobalOpt.Verbose && !os.IsNotExist(err) {
+			log.Printf("Warning: %s", err)
+		}
+		return fmt.Sprintf("%s:@%d-%d", display, start, end)
+	}
+	line, col := byteOffsetToLineCol(data, start)
+	return fmt.Sprintf("%s:%d:%d: %s", display, line, col, bytes.TrimSpace(lineAt(data, start)))
+}
+
+// countTrue returns the number of bs that are true.
+func countTrue(bs ...bool) int {
+	n := 0
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLineColByteOffset(t *testing.T) {
+	data := []byte("ab\ncde\n\nf")
+	tests := []struct {
+		line, col int
+		ofs       uint32
+	}{
+		{1, 1, 0},
+		{1, 3, 2},
+		{2, 1, 3},
+		{2, 3, 5},
+		{3, 1, 7},
+		{4, 1, 8},
+		{4, 2, 9},
+	}
+	for _, test := range tests {
+		ofs, err := lineColToByteOffset(data, test.line, test.col)
+		if err != nil {
+			t.Errorf("%d:%d: %s", test.line, test.col, err)
+			continue
+		}
+		if ofs != test.ofs {
+			t.Errorf("%d:%d: got offset %d, want %d", test.line, test.col, ofs, test.ofs)
+		}
+		if line, col := byteOffsetToLineCol(data, ofs); line != test.line || col != test.col {
+			t.Errorf("offset %d: got %d:%d, want %d:%d", ofs, line, col, test.line, test.col)
+		}
+	}
+
+	for _, pos := range [][2]int{{0, 1}, {1, 4}, {5, 1}} {
+		if _, err := lineColToByteOffset(data, pos[0], pos[1]); err == nil {
+			t.Errorf("%d:%d: got no error for invalid position", pos[0], pos[1])
+		}
+	}
+
+	if got, want := string(lineAt(data, 4)), "cde"; got != want {
+		t.Errorf("got line %q, want %q", got, want)
+	}
+}
+
+func TestFilePosition(t *testing.T) {
+	f, err := ioutil.TempFile("", "srclib-file-position")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("package p\n\n\tfunc F() {}\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	file := filepath.ToSlash(f.Name())
+	if got, want := filePosition(file, 17, 18), file+":3:7: func F() {}"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := filePosition("does/not/exist.go", 3, 5), "does/not/exist.go:@3-5"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -62,6 +62,20 @@ type Tree struct {
 	// name and type pair in SkipUnits is skipped.
 	SkipUnits []struct{ Name, Type string } `json:",omitempty"`
 
+	// DepOverrides redirects dependencies to alternate repositories
+	// or revisions (e.g., to a patched fork that the repository is
+	// actually built against). Overrides are applied to the output
+	// of dependency resolution, so refs to the dependency's defs
+	// resolve to the fork.
+	DepOverrides []*DepOverride `json:",omitempty"`
+
+	// NestedRepos lists the repositories (such as git submodules)
+	// nested in the tree, whose source units are graphed as separate
+	// repositories. Nested git and hg repositories are detected when
+	// the tree is configured; others (such as copies of
+	// repositories without VCS metadata) may be listed here.
+	NestedRepos []*NestedRepo `json:",omitempty"`
+
 	// TODO(sqs): Add some type of field that lets the Srcfile and the scanners
 	// have input into which tools get used during the execution phase. Right
 	// now, we're going to try just using the system defaults (srclib-*) and
@@ -72,6 +86,24 @@ type Tree struct {
 	Config map[string]interface{} `json:",omitempty"`
 }
 
+// DepOverride redirects a dependency to an alternate repository
+// and/or revision.
+type DepOverride struct {
+	// Repo is the clone URL (or URI) of the dependency to override,
+	// as resolved by the toolchain.
+	Repo string
+
+	// ToRepo is the clone URL of the repository to use instead. If
+	// empty, the dependency's repository is not changed.
+	ToRepo string `json:",omitempty"`
+
+	// ToRevSpec is the VCS revision of the repository to use. If
+	// empty, the dependency's revision is not changed (unless ToRepo
+	// is set, in which case it is cleared, since it refers to a
+	// revision of the original repository).
+	ToRevSpec string `json:",omitempty"`
+}
+
 // ReadRepository parses and validates the configuration for a repository. If no
 // Srcfile exists, it returns the default configuration for the repository. If
 // an overridden configuration is specified for the repository (hard-coded in
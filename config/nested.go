@@ -0,0 +1,98 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"golang.org/x/tools/godoc/vfs"
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A NestedRepo is a repository whose working tree is inside another
+// repository's tree, such as a git submodule or a repository that was
+// cloned into a subdirectory. Its source units belong to it, not to
+// the containing repository: they are skipped when the containing
+// repository is scanned, and refs from the containing repository to
+// their defs (and deps on them) are resolved to the nested repository
+// like any other dependency.
+type NestedRepo struct {
+	// Dir is the nested repository's root dir, relative to the root
+	// of the containing repository (slash-separated).
+	Dir string
+
+	// URI is the nested repository's URI, and CloneURL is its clone
+	// URL (if known).
+	URI      string
+	CloneURL string `json:",omitempty"`
+
+	// CommitID is the commit that is checked out in Dir.
+	CommitID string `json:",omitempty"`
+
+	// Units are the source units that were scanned in Dir (and
+	// skipped).
+	Units []unit.ID2 `json:",omitempty"`
+}
+
+// NestedReposFilename is the name of the file (in a commit's build
+// data dir) that lists the repositories nested in the commit's tree.
+const NestedReposFilename = "nested-repos.json"
+
+// ReadNestedRepos reads the nested repositories listed in fs (a
+// commit's build data dir). If there is no list, it returns nil.
+func ReadNestedRepos(fs vfs.FileSystem) ([]*NestedRepo, error) {
+	f, err := fs.Open(NestedReposFilename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var repos []*NestedRepo
+	if err := json.NewDecoder(f).Decode(&repos); err != nil {
+		return nil, err
+	}
+	return repos, nil
+}
+
+// WriteNestedRepos writes the list of nested repositories to fs (a
+// commit's build data dir).
+func WriteNestedRepos(fs rwvfs.FileSystem, repos []*NestedRepo) (err error) {
+	f, err := fs.Create(NestedReposFilename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := f.Close(); err2 != nil && err == nil {
+			err = err2
+		}
+	}()
+	return json.NewEncoder(f).Encode(repos)
+}
+
+// NestedRepoAt returns the repository in repos whose tree contains
+// path (a slash-separated path relative to the root of the containing
+// repository), or nil if path isn't in a nested repository.
+func NestedRepoAt(repos []*NestedRepo, path string) *NestedRepo {
+	path = strings.TrimPrefix(path, "./")
+	for _, r := range repos {
+		if path == r.Dir || strings.HasPrefix(path, r.Dir+"/") {
+			return r
+		}
+	}
+	return nil
+}
+
+// NestedRepoOfUnit returns the repository in repos that the source
+// unit belongs to, or nil if it isn't in a nested repository.
+func NestedRepoOfUnit(repos []*NestedRepo, u unit.ID2) *NestedRepo {
+	for _, r := range repos {
+		for _, ru := range r.Units {
+			if ru == u {
+				return r
+			}
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"errors"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -10,6 +11,14 @@ var (
 	// ErrInvalidFilePath indicates that a file path outside of the tree or
 	// repository root directory was specified in the config.
 	ErrInvalidFilePath = errors.New("invalid file path specified in config (above config root dir or source unit dir)")
+
+	// ErrInvalidDepOverride indicates that a dependency override
+	// doesn't specify the dependency to override or its replacement.
+	ErrInvalidDepOverride = errors.New("invalid dependency override specified in config (Repo and at least one of ToRepo or ToRevSpec are required)")
+
+	// ErrInvalidNestedRepo indicates that a nested repository
+	// doesn't specify its dir (inside the tree) or its URI.
+	ErrInvalidNestedRepo = errors.New("invalid nested repository specified in config (Dir, which must be inside the tree, and URI are required)")
 )
 
 func (c *Tree) validate() error {
@@ -24,5 +33,17 @@ func (c *Tree) validate() error {
 			}
 		}
 	}
+	for _, o := range c.DepOverrides {
+		if o.Repo == "" || (o.ToRepo == "" && o.ToRevSpec == "") {
+			return ErrInvalidDepOverride
+		}
+	}
+	for _, r := range c.NestedRepos {
+		dir := path.Clean(r.Dir)
+		if r.URI == "" || dir == "." || path.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, "../") {
+			return ErrInvalidNestedRepo
+		}
+		r.Dir = dir
+	}
 	return nil
 }
@@ -28,3 +28,34 @@ func TestTree_validate(t *testing.T) {
 		}
 	}
 }
+
+func TestTree_validate_depOverrides(t *testing.T) {
+	tests := map[string]*Tree{
+		"no repo":        &Tree{DepOverrides: []*DepOverride{{ToRepo: "github.com/me/fork"}}},
+		"no replacement": &Tree{DepOverrides: []*DepOverride{{Repo: "github.com/foo/bar"}}},
+	}
+	for label, tree := range tests {
+		if err := tree.validate(); err != ErrInvalidDepOverride {
+			t.Errorf("%s: got err %v, want ErrInvalidDepOverride", label, err)
+		}
+	}
+
+	valid := &Tree{DepOverrides: []*DepOverride{{Repo: "github.com/foo/bar", ToRevSpec: "v1.2"}}}
+	if err := valid.validate(); err != nil {
+		t.Errorf("valid override: got err %v", err)
+	}
+}
+
+func TestTree_validate_nestedRepos(t *testing.T) {
+	tests := map[string]*Tree{
+		"no URI":       &Tree{NestedRepos: []*NestedRepo{{Dir: "lib/sub"}}},
+		"no dir":       &Tree{NestedRepos: []*NestedRepo{{URI: "example.com/sub"}}},
+		"dir is root":  &Tree{NestedRepos: []*NestedRepo{{Dir: "lib/..", URI: "example.com/sub"}}},
+		"dir is above": &Tree{NestedRepos: []*NestedRepo{{Dir: "../sub", URI: "example.com/sub"}}},
+	}
+	for label, tree := range tests {
+		if err := tree.validate(); err != ErrInvalidNestedRepo {
+			t.Errorf("%s: got err %v, want ErrInvalidNestedRepo", label, err)
+		}
+	}
+}
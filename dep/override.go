@@ -0,0 +1,78 @@
+package dep
+
+import (
+	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// ApplyOverrides modifies the targets of resolutions according to
+// overrides (from the repository's Srcfile). A target is overridden
+// if its repository is the same as the override's Repo (compared by
+// URI, so clone URLs and URIs may be used interchangeably). The first
+// matching override is applied.
+func ApplyOverrides(resolutions []*Resolution, overrides []*config.DepOverride) {
+	if len(overrides) == 0 {
+		return
+	}
+	for _, r := range resolutions {
+		if r.Target == nil {
+			continue
+		}
+		for _, o := range overrides {
+			if !sameRepo(r.Target.ToRepoCloneURL, o.Repo) {
+				continue
+			}
+			if o.ToRepo != "" {
+				r.Target.ToRepoCloneURL = o.ToRepo
+				r.Target.ToRevSpec = ""
+			}
+			if o.ToRevSpec != "" {
+				r.Target.ToRevSpec = o.ToRevSpec
+			}
+			break
+		}
+	}
+}
+
+// sameRepo returns whether a and b (clone URLs or URIs) refer to the
+// same repository.
+func sameRepo(a, b string) bool {
+	if a == b {
+		return true
+	}
+	ua, err := graph.TryMakeURI(a)
+	if err != nil {
+		return false
+	}
+	ub, err := graph.TryMakeURI(b)
+	if err != nil {
+		return false
+	}
+	return graph.URIEqual(ua, ub)
+}
+
+// ApplyNestedRepos modifies the targets of resolutions that are
+// source units of repositories nested in the current repository's
+// tree (such as git submodules) to refer to the nested repositories
+// (at their checked-out commits). repoURI is the current repository's
+// URI; targets in it are those whose repository is empty or repoURI.
+func ApplyNestedRepos(resolutions []*Resolution, repoURI string, repos []*config.NestedRepo) {
+	if len(repos) == 0 {
+		return
+	}
+	for _, r := range resolutions {
+		if r.Target == nil || (r.Target.ToRepoCloneURL != "" && !sameRepo(r.Target.ToRepoCloneURL, repoURI)) {
+			continue
+		}
+		nested := config.NestedRepoOfUnit(repos, unit.ID2{Type: r.Target.ToUnitType, Name: r.Target.ToUnit})
+		if nested == nil {
+			continue
+		}
+		r.Target.ToRepoCloneURL = nested.CloneURL
+		if r.Target.ToRepoCloneURL == "" {
+			r.Target.ToRepoCloneURL = nested.URI
+		}
+		r.Target.ToRevSpec = nested.CommitID
+	}
+}
@@ -0,0 +1,61 @@
+package dep
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	resolutions := []*Resolution{
+		{Target: &ResolvedTarget{ToRepoCloneURL: "https://github.com/foo/bar.git", ToUnit: "bar", ToRevSpec: "v1"}},
+		{Target: &ResolvedTarget{ToRepoCloneURL: "git://github.com/foo/baz", ToUnit: "baz", ToRevSpec: "v2"}},
+		{Target: &ResolvedTarget{ToRepoCloneURL: "https://github.com/foo/qux", ToUnit: "qux", ToRevSpec: "v3"}},
+		{Error: "unresolved"},
+	}
+	ApplyOverrides(resolutions, []*config.DepOverride{
+		{Repo: "github.com/foo/bar", ToRepo: "https://github.com/me/bar", ToRevSpec: "patched"},
+		{Repo: "git@github.com:foo/baz.git", ToRepo: "https://github.com/me/baz"},
+		{Repo: "https://github.com/foo/qux", ToRevSpec: "v3.1"},
+	})
+
+	want := []*ResolvedTarget{
+		{ToRepoCloneURL: "https://github.com/me/bar", ToUnit: "bar", ToRevSpec: "patched"},
+		{ToRepoCloneURL: "https://github.com/me/baz", ToUnit: "baz"},
+		{ToRepoCloneURL: "https://github.com/foo/qux", ToUnit: "qux", ToRevSpec: "v3.1"},
+		nil,
+	}
+	for i, r := range resolutions {
+		if !reflect.DeepEqual(r.Target, want[i]) {
+			t.Errorf("resolution %d: got target %+v, want %+v", i, r.Target, want[i])
+		}
+	}
+}
+
+func TestApplyNestedRepos(t *testing.T) {
+	resolutions := []*Resolution{
+		{Target: &ResolvedTarget{ToUnit: "sub/u", ToUnitType: "t"}},
+		{Target: &ResolvedTarget{ToRepoCloneURL: "https://example.com/me/repo.git", ToUnit: "sub/u", ToUnitType: "t", ToRevSpec: "v1"}},
+		{Target: &ResolvedTarget{ToRepoCloneURL: "https://example.com/other", ToUnit: "sub/u", ToUnitType: "t"}},
+		{Target: &ResolvedTarget{ToUnit: "u", ToUnitType: "t"}},
+		{Error: "unresolved"},
+	}
+	ApplyNestedRepos(resolutions, "example.com/me/repo", []*config.NestedRepo{
+		{Dir: "sub", URI: "example.com/sub", CloneURL: "https://example.com/sub.git", CommitID: "c", Units: []unit.ID2{{Type: "t", Name: "sub/u"}}},
+	})
+
+	want := []*ResolvedTarget{
+		{ToRepoCloneURL: "https://example.com/sub.git", ToUnit: "sub/u", ToUnitType: "t", ToRevSpec: "c"},
+		{ToRepoCloneURL: "https://example.com/sub.git", ToUnit: "sub/u", ToUnitType: "t", ToRevSpec: "c"},
+		{ToRepoCloneURL: "https://example.com/other", ToUnit: "sub/u", ToUnitType: "t"},
+		{ToUnit: "u", ToUnitType: "t"},
+		nil,
+	}
+	for i, r := range resolutions {
+		if !reflect.DeepEqual(r.Target, want[i]) {
+			t.Errorf("resolution %d: got target %+v, want %+v", i, r.Target, want[i])
+		}
+	}
+}
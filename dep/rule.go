@@ -59,8 +59,9 @@ func (r *ResolveDepsRule) Prereqs() []string {
 }
 
 func (r *ResolveDepsRule) Recipes() []string {
+	safeCommand := util.SafeCommandName(srclib.CommandName)
 	return []string{
-		fmt.Sprintf("%s tool %s %q %q < $^ 1> $@", util.SafeCommandName(srclib.CommandName), r.opt.ToolchainExecOpt, r.Tool.Toolchain, r.Tool.Subcmd),
+		fmt.Sprintf("%s tool %s %q %q < $^ | %s internal normalize-dep-data 1> $@", safeCommand, r.opt.ToolchainExecOpt, r.Tool.Toolchain, r.Tool.Subcmd, safeCommand),
 	}
 }
 
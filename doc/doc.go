@@ -14,6 +14,7 @@ type Formatter string
 const (
 	Text             Formatter = "text"
 	Markdown         Formatter = "markdown"
+	HTML             Formatter = "html"
 	ReStructuredText Formatter = "rst"
 )
 
@@ -29,6 +30,8 @@ var ExtensionFormat = map[string]Formatter{
 	"":          Text,
 	".ascii":    Text,
 	".rst":      ReStructuredText,
+	".html":     HTML,
+	".htm":      HTML,
 }
 
 // Format returns the doc formatter to use for the given filename. It determines
@@ -59,6 +62,9 @@ func ToHTML(formatter Formatter, src []byte) ([]byte, error) {
 	case ReStructuredText:
 		out, err = ReStructuredTextToHTML(src)
 
+	case HTML:
+		out = src
+
 	case Text:
 		// wrap in <pre> below
 	}
@@ -0,0 +1,435 @@
+package doc
+
+import (
+	"bytes"
+	"html"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// MIMETypeFormat maps the MIME types used in def docs (see
+// graph.DefDoc) to the formatter for docs in that format.
+var MIMETypeFormat = map[string]Formatter{
+	"text/plain":      Text,
+	"text/html":       HTML,
+	"text/markdown":   Markdown,
+	"text/x-markdown": Markdown,
+	"text/x-rst":      ReStructuredText,
+}
+
+// FormatForMIMEType returns the formatter for docs with the given
+// MIME type, defaulting to plain text.
+func FormatForMIMEType(mimeType string) Formatter {
+	if fmt, present := MIMETypeFormat[mimeType]; present {
+		return fmt
+	}
+	return Text
+}
+
+// TerminalOptions control how docs are rendered for display in a
+// terminal.
+type TerminalOptions struct {
+	// Width is the column at which to wrap text. If 0, text is not
+	// wrapped.
+	Width int
+
+	// Color is whether to style text (headings, emphasis, code, and
+	// links) using ANSI escape sequences.
+	Color bool
+}
+
+const (
+	ansiReset     = "\x1b[0m"
+	ansiBold      = "\x1b[1m"
+	ansiUnderline = "\x1b[4m"
+	ansiCode      = "\x1b[36m"
+	ansiLink      = "\x1b[34m"
+)
+
+// ToTerminal renders a source document in format as wrapped, styled
+// text for display in a terminal. Paragraphs, headings, lists, code
+// blocks, block quotes, and links are preserved. If the document
+// can't be converted to HTML first (e.g., because rst2html isn't
+// installed), it is rendered as plain text and a non-nil error is
+// returned.
+func ToTerminal(formatter Formatter, src []byte, opt TerminalOptions) (string, error) {
+	var htmlSrc []byte
+	var err error
+	if formatter == Text {
+		htmlSrc = textToHTML(src)
+	} else {
+		htmlSrc, err = ToHTML(formatter, src)
+	}
+
+	nodes, parseErr := nethtml.ParseFragment(bytes.NewReader(htmlSrc), &nethtml.Node{
+		Type:     nethtml.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if parseErr != nil {
+		return string(src), parseErr
+	}
+
+	r := &termRenderer{opt: opt}
+	for _, n := range nodes {
+		r.node(n)
+	}
+	r.flush()
+	return strings.TrimRight(r.buf.String(), "\n"), err
+}
+
+// textToHTML converts plain text to HTML, treating blank-line
+// separated blocks of text as paragraphs and indented blocks as
+// preformatted text (as in Go doc comments).
+func textToHTML(src []byte) []byte {
+	var buf bytes.Buffer
+	var para []string
+	inPre := false
+	endBlock := func() {
+		if inPre {
+			buf.WriteString("</pre>")
+			inPre = false
+		}
+		if len(para) > 0 {
+			buf.WriteString("<p>" + html.EscapeString(strings.Join(para, "\n")) + "</p>")
+			para = nil
+		}
+	}
+	for _, line := range strings.Split(string(src), "\n") {
+		switch {
+		case strings.TrimSpace(line) == "":
+			if inPre {
+				buf.WriteString("\n")
+			} else {
+				endBlock()
+			}
+		case line[0] == ' ' || line[0] == '\t':
+			if !inPre {
+				endBlock()
+				buf.WriteString("<pre>")
+				inPre = true
+			}
+			buf.WriteString(html.EscapeString(line) + "\n")
+		default:
+			if inPre {
+				endBlock()
+			}
+			para = append(para, line)
+		}
+	}
+	endBlock()
+	return buf.Bytes()
+}
+
+// A termSpan is a run of inline text with a single style.
+type termSpan struct {
+	text  string
+	style string // ANSI escape sequences to apply (or "")
+}
+
+// termRenderer renders an HTML node tree as terminal text.
+type termRenderer struct {
+	opt TerminalOptions
+	buf bytes.Buffer
+
+	spans  []termSpan // inline text of the current block, not yet written
+	styles []string   // stack of active inline styles
+	indent string     // prefix of each line in the current block
+	marker string     // list item marker to write before the next line (replacing the indent's end)
+
+	// blank is whether a blank line should be written before the
+	// next block.
+	blank bool
+
+	lists []int // stack of list item counters (-1 for unordered lists)
+}
+
+func (r *termRenderer) style() string {
+	return strings.Join(r.styles, "")
+}
+
+func (r *termRenderer) withStyle(style string, fn func()) {
+	r.styles = append(r.styles, style)
+	fn()
+	r.styles = r.styles[:len(r.styles)-1]
+}
+
+func (r *termRenderer) withIndent(indent string, fn func()) {
+	orig := r.indent
+	r.indent += indent
+	fn()
+	r.indent = orig
+}
+
+func (r *termRenderer) children(n *nethtml.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		r.node(c)
+	}
+}
+
+// block renders n as a block, separated from surrounding blocks by
+// blank lines.
+func (r *termRenderer) block(n *nethtml.Node) {
+	r.flush()
+	if r.marker == "" {
+		// Not the first block in a list item.
+		r.blank = true
+	}
+	r.children(n)
+	r.flush()
+	r.blank = true
+}
+
+func (r *termRenderer) node(n *nethtml.Node) {
+	switch n.Type {
+	case nethtml.TextNode:
+		r.spans = append(r.spans, termSpan{text: n.Data, style: r.style()})
+		return
+	case nethtml.ElementNode:
+	default:
+		r.children(n)
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Head:
+		// Not displayed.
+
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		style := ansiBold
+		if n.DataAtom == atom.H1 || n.DataAtom == atom.H2 {
+			style += ansiUnderline
+		}
+		r.withStyle(style, func() { r.block(n) })
+
+	case atom.P, atom.Div, atom.Dl, atom.Table:
+		r.block(n)
+
+	case atom.Blockquote:
+		r.withIndent("| ", func() { r.block(n) })
+
+	case atom.Dd:
+		r.flush()
+		r.withIndent("    ", func() { r.children(n); r.flush() })
+
+	case atom.Dt, atom.Tr:
+		r.flush()
+		r.children(n)
+		r.flush()
+
+	case atom.Td, atom.Th:
+		r.spans = append(r.spans, termSpan{text: " "})
+		r.children(n)
+		r.spans = append(r.spans, termSpan{text: " "})
+
+	case atom.Ul, atom.Ol:
+		start := -1
+		if n.DataAtom == atom.Ol {
+			start = 1
+		}
+		r.lists = append(r.lists, start)
+		r.flush()
+		// Separate top-level lists from surrounding blocks, but not
+		// nested lists from their parent item's text.
+		r.blank = len(r.lists) == 1
+		r.children(n)
+		r.flush()
+		r.lists = r.lists[:len(r.lists)-1]
+		if len(r.lists) == 0 {
+			r.blank = true
+		}
+
+	case atom.Li:
+		r.flush()
+		marker := "* "
+		if len(r.lists) > 0 {
+			if i := r.lists[len(r.lists)-1]; i >= 0 {
+				marker = strconv.Itoa(i) + ". "
+				r.lists[len(r.lists)-1]++
+			}
+		}
+		r.withIndent(strings.Repeat(" ", len(marker)), func() {
+			r.marker = marker
+			r.children(n)
+			r.flush()
+		})
+		r.marker = ""
+		// Items consisting of paragraphs set blank; don't separate
+		// items from each other.
+		r.blank = false
+
+	case atom.Pre:
+		r.flush()
+		r.pre(n)
+		r.blank = true
+
+	case atom.Br:
+		r.flush()
+
+	case atom.Hr:
+		r.flush()
+		r.blank = true
+		w := r.opt.Width - len(r.indent)
+		if w <= 0 || w > 40 {
+			w = 40
+		}
+		r.writeLine(strings.Repeat("-", w))
+		r.blank = true
+
+	case atom.B, atom.Strong:
+		r.withStyle(ansiBold, func() { r.children(n) })
+
+	case atom.I, atom.Em:
+		r.withStyle(ansiUnderline, func() { r.children(n) })
+
+	case atom.Code, atom.Tt, atom.Kbd, atom.Samp:
+		r.withStyle(ansiCode, func() { r.children(n) })
+
+	case atom.A:
+		r.withStyle(ansiLink+ansiUnderline, func() { r.children(n) })
+		if href := attr(n, "href"); href != "" && href != textContent(n) && !strings.HasPrefix(href, "#") {
+			r.spans = append(r.spans, termSpan{text: " <" + href + ">", style: r.style()})
+		}
+
+	case atom.Img:
+		if alt := attr(n, "alt"); alt != "" {
+			r.spans = append(r.spans, termSpan{text: "[" + alt + "]", style: r.style()})
+		}
+
+	default:
+		r.children(n)
+	}
+}
+
+// pre writes the text of n (a <pre> element) without wrapping it.
+func (r *termRenderer) pre(n *nethtml.Node) {
+	text := strings.Trim(textContent(n), "\n")
+	if text == "" {
+		return
+	}
+	r.withIndent("    ", func() {
+		for _, line := range strings.Split(text, "\n") {
+			line = strings.TrimRightFunc(line, unicode.IsSpace)
+			if line != "" && r.opt.Color {
+				line = ansiCode + line + ansiReset
+			}
+			r.writeLine(line)
+		}
+	})
+}
+
+// writeLine writes a single line, preceded by the current indent (or
+// list item marker) and a blank line if needed.
+func (r *termRenderer) writeLine(line string) {
+	if r.blank && r.buf.Len() > 0 {
+		r.buf.WriteString("\n")
+	}
+	r.blank = false
+	prefix := r.indent
+	if r.marker != "" {
+		prefix = prefix[:len(prefix)-len(r.marker)] + r.marker
+		r.marker = ""
+	}
+	r.buf.WriteString(strings.TrimRight(prefix+line, " "))
+	r.buf.WriteString("\n")
+}
+
+// A termWord is a sequence of non-space spans.
+type termWord []termSpan
+
+func (w termWord) len() int {
+	n := 0
+	for _, s := range w {
+		n += utf8.RuneCountInString(s.text)
+	}
+	return n
+}
+
+func (w termWord) render(color bool) string {
+	var buf bytes.Buffer
+	for _, s := range w {
+		if color && s.style != "" {
+			buf.WriteString(s.style + s.text + ansiReset)
+		} else {
+			buf.WriteString(s.text)
+		}
+	}
+	return buf.String()
+}
+
+// flush writes the pending inline text, wrapped to the configured
+// width.
+func (r *termRenderer) flush() {
+	spans := r.spans
+	r.spans = nil
+
+	var words []termWord
+	var cur termWord
+	endWord := func() {
+		if len(cur) > 0 {
+			words = append(words, cur)
+			cur = nil
+		}
+	}
+	for _, s := range spans {
+		for len(s.text) > 0 {
+			i := strings.IndexFunc(s.text, unicode.IsSpace)
+			if i == -1 {
+				cur = append(cur, s)
+				break
+			}
+			if i > 0 {
+				cur = append(cur, termSpan{text: s.text[:i], style: s.style})
+			}
+			endWord()
+			_, size := utf8.DecodeRuneInString(s.text[i:])
+			s.text = s.text[i+size:]
+		}
+	}
+	endWord()
+	if len(words) == 0 {
+		return
+	}
+
+	width := r.opt.Width - len(r.indent)
+	var line []string
+	lineLen := 0
+	for _, w := range words {
+		n := w.len()
+		if len(line) > 0 && r.opt.Width > 0 && lineLen+1+n > width {
+			r.writeLine(strings.Join(line, " "))
+			line, lineLen = nil, 0
+		}
+		if len(line) > 0 {
+			lineLen++
+		}
+		line = append(line, w.render(r.opt.Color))
+		lineLen += n
+	}
+	r.writeLine(strings.Join(line, " "))
+}
+
+func attr(n *nethtml.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func textContent(n *nethtml.Node) string {
+	if n.Type == nethtml.TextNode {
+		return n.Data
+	}
+	var buf bytes.Buffer
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		buf.WriteString(textContent(c))
+	}
+	return buf.String()
+}
@@ -0,0 +1,56 @@
+package doc
+
+import "testing"
+
+func TestToTerminal(t *testing.T) {
+	tests := map[string]struct {
+		format Formatter
+		src    string
+		opt    TerminalOptions
+		want   string
+	}{
+		"text wrapped": {
+			format: Text,
+			src:    "Foo returns the bar\nof a baz.\n\nIt panics.",
+			opt:    TerminalOptions{Width: 12},
+			want:   "Foo returns\nthe bar of a\nbaz.\n\nIt panics.",
+		},
+		"text with code block": {
+			format: Text,
+			src:    "Example:\n\n\tx := Foo()\n\tif x {\n\t}\nDone.",
+			want:   "Example:\n\n    \tx := Foo()\n    \tif x {\n    \t}\n\nDone.",
+		},
+		"html paragraphs and styles": {
+			format: HTML,
+			src:    "<p>Call <code>Foo</code> <b>first</b>.</p><p>See <a href=\"http://example.com\">docs</a>.</p>",
+			opt:    TerminalOptions{Color: true},
+			want:   "Call \x1b[36mFoo\x1b[0m \x1b[1mfirst\x1b[0m.\n\nSee \x1b[34m\x1b[4mdocs\x1b[0m <http://example.com>.",
+		},
+		"html without color": {
+			format: HTML,
+			src:    "<p>Call <code>Foo</code> <b>first</b>.</p>",
+			want:   "Call Foo first.",
+		},
+		"markdown lists": {
+			format: Markdown,
+			src:    "# Title\n\nItems:\n\n* one\n* two is\n  long\n\nSteps:\n\n1. a\n\n2. b\n    * c\n",
+			opt:    TerminalOptions{Width: 10},
+			want:   "Title\n\nItems:\n\n* one\n* two is\n  long\n\nSteps:\n\n1. a\n2. b\n   * c",
+		},
+		"markdown code block": {
+			format: Markdown,
+			src:    "Use:\n\n```\nfoo()\n  bar()\n```\n",
+			want:   "Use:\n\n    foo()\n      bar()",
+		},
+	}
+	for label, test := range tests {
+		got, err := ToTerminal(test.format, []byte(test.src), test.opt)
+		if err != nil {
+			t.Errorf("%s: ToTerminal: %s", label, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", label, got, test.want)
+		}
+	}
+}
@@ -20,6 +20,12 @@ var (
 	// where DIR is the first entry in Path (SRCLIBPATH).
 	CacheDir = os.Getenv("SRCLIBCACHE")
 
+	// StoreDir is the root of the global multi-repo store, which holds
+	// imported build data for many repositories. It is initialized from
+	// the SRCLIBSTORE environment variable; if empty, it defaults to
+	// DIR/.store, where DIR is the first entry in Path (SRCLIBPATH).
+	StoreDir = os.Getenv("SRCLIBSTORE")
+
 	// CommandName holds the commands that will be used to call self when generating
 	// Makefiles and updating toolchains.
 	CommandName = "srclib"
@@ -41,4 +47,9 @@ func init() {
 		dirs := filepath.SplitList(Path)
 		CacheDir = filepath.Join(dirs[0], ".cache")
 	}
+
+	if StoreDir == "" {
+		dirs := filepath.SplitList(Path)
+		StoreDir = filepath.Join(dirs[0], ".store")
+	}
 }
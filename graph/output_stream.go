@@ -0,0 +1,129 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/ann"
+)
+
+// An OutputHandler receives the items of a JSON-encoded Output as
+// DecodeOutputStream decodes them. Items whose func is nil are
+// skipped.
+type OutputHandler struct {
+	Def func(*Def) error
+	Ref func(*Ref) error
+	Doc func(*Doc) error
+	Ann func(*ann.Ann) error
+}
+
+// DecodeOutputStream decodes a JSON-encoded Output from r and calls
+// h's funcs with each of its items, in the order that they appear.
+// Unlike decoding the whole Output at once, it holds neither the JSON
+// nor the decoded items in memory, so it can read outputs that are
+// larger than memory.
+func DecodeOutputStream(r io.Reader, h OutputHandler) error {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok == nil {
+		return nil // null
+	} else if tok != json.Delim('{') {
+		return fmt.Errorf("graph output: expected object, got %v", tok)
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+
+		// Field names are matched case-insensitively, like
+		// encoding/json does.
+		var decodeItem func() error
+		switch {
+		case strings.EqualFold(key, "Defs") && h.Def != nil:
+			decodeItem = func() error {
+				var def *Def
+				if err := dec.Decode(&def); err != nil {
+					return err
+				}
+				return h.Def(def)
+			}
+		case strings.EqualFold(key, "Refs") && h.Ref != nil:
+			decodeItem = func() error {
+				var ref *Ref
+				if err := dec.Decode(&ref); err != nil {
+					return err
+				}
+				return h.Ref(ref)
+			}
+		case strings.EqualFold(key, "Docs") && h.Doc != nil:
+			decodeItem = func() error {
+				var doc *Doc
+				if err := dec.Decode(&doc); err != nil {
+					return err
+				}
+				return h.Doc(doc)
+			}
+		case strings.EqualFold(key, "Anns") && h.Ann != nil:
+			decodeItem = func() error {
+				var a *ann.Ann
+				if err := dec.Decode(&a); err != nil {
+					return err
+				}
+				return h.Ann(a)
+			}
+		default:
+			if err := skipJSONValue(dec); err != nil {
+				return err
+			}
+			continue
+		}
+
+		tok, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		if tok == nil {
+			continue // null
+		}
+		if tok != json.Delim('[') {
+			return fmt.Errorf("graph output: expected array for %s, got %v", key, tok)
+		}
+		for dec.More() {
+			if err := decodeItem(); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil { // ']'
+			return err
+		}
+	}
+	_, err := dec.Token() // '}'
+	return err
+}
+
+// skipJSONValue reads the next value from dec one token at a time (so
+// that skipping a large value doesn't buffer it).
+func skipJSONValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('['), json.Delim('{'):
+			depth++
+		case json.Delim(']'), json.Delim('}'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package graph
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/ann"
+)
+
+func TestDecodeOutputStream(t *testing.T) {
+	want := Output{
+		Defs: []*Def{{DefKey: DefKey{Path: "p"}, Name: "p"}, {DefKey: DefKey{Path: "q"}, Name: "q"}},
+		Refs: []*Ref{{DefPath: "p", File: "f", Start: 1, End: 2}},
+		Docs: []*Doc{{DefKey: DefKey{Path: "p"}, Format: "text/plain", Data: "d"}},
+		Anns: []*ann.Ann{{File: "f", Type: "t"}},
+	}
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Output
+	h := OutputHandler{
+		Def: func(def *Def) error { got.Defs = append(got.Defs, def); return nil },
+		Ref: func(ref *Ref) error { got.Refs = append(got.Refs, ref); return nil },
+		Doc: func(doc *Doc) error { got.Docs = append(got.Docs, doc); return nil },
+		Ann: func(a *ann.Ann) error { got.Anns = append(got.Anns, a); return nil },
+	}
+	if err := DecodeOutputStream(strings.NewReader(string(data)), h); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Items without a handler, unknown fields, and nulls are skipped,
+	// and field names are matched case-insensitively.
+	var defs []string
+	input := `{"Extra": {"a": [1, {"b": null}]}, "refs": [{"DefPath": "p"}], "defs": [{"Path": "x"}], "Docs": null}`
+	if err := DecodeOutputStream(strings.NewReader(input), OutputHandler{
+		Def: func(def *Def) error { defs = append(defs, def.Path); return nil },
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"x"}; !reflect.DeepEqual(defs, want) {
+		t.Errorf("got defs %v, want %v", defs, want)
+	}
+
+	for _, input := range []string{`[]`, `{"Defs": {}}`, `{"Defs": [`} {
+		if err := DecodeOutputStream(strings.NewReader(input), h); err == nil {
+			t.Errorf("%s: got no error", input)
+		}
+	}
+}
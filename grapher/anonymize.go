@@ -0,0 +1,153 @@
+package grapher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"strings"
+
+	"sourcegraph.com/sqs/pbtypes"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// Anonymizer replaces the identifying information in graph data (repo
+// and unit names, commit IDs, def paths and names, file paths, doc
+// text, and toolchain-specific data) with hashes, so that build data
+// from proprietary repositories can be shared (e.g., to reproduce a
+// bug).
+//
+// Anonymization is deterministic and preserves the data's structure:
+// equal strings are replaced by equal hashes, path components are
+// hashed individually (so a def's Name is still the last component of
+// its Path, and files in the same dir are still in the same dir), and
+// file extensions, def and unit types and kinds, byte offsets, and
+// flags are preserved.
+type Anonymizer struct {
+	// Salt is prepended to all strings before they are hashed. If
+	// it is empty, the original strings can be found by guessing
+	// them, so it should be set to a secret value when anonymizing
+	// data that might contain guessable identifiers.
+	Salt string
+}
+
+// Anonymize anonymizes o in place.
+func (a Anonymizer) Anonymize(o *graph.Output) {
+	for _, def := range o.Defs {
+		a.defKey(&def.DefKey)
+		def.Name = a.ident(def.Name)
+		def.File = a.file(def.File)
+		def.Data = a.data(def.Data)
+		for _, doc := range def.Docs {
+			doc.Data = a.ident(doc.Data)
+		}
+	}
+	for _, ref := range o.Refs {
+		ref.DefRepo = a.path(ref.DefRepo)
+		ref.DefUnit = a.path(ref.DefUnit)
+		ref.DefPath = a.path(ref.DefPath)
+		ref.Repo = a.path(ref.Repo)
+		ref.CommitID = a.commitID(ref.CommitID)
+		ref.Unit = a.path(ref.Unit)
+		ref.File = a.file(ref.File)
+	}
+	for _, doc := range o.Docs {
+		a.defKey(&doc.DefKey)
+		doc.Data = a.ident(doc.Data)
+		doc.File = a.file(doc.File)
+	}
+	for _, ann := range o.Anns {
+		ann.Repo = a.path(ann.Repo)
+		ann.CommitID = a.commitID(ann.CommitID)
+		ann.Unit = a.path(ann.Unit)
+		ann.File = a.file(ann.File)
+		ann.Data = a.data(ann.Data)
+	}
+
+	// The original order would reveal the alphabetical order of the
+	// original paths.
+	sortedOutput(o)
+}
+
+func (a Anonymizer) defKey(k *graph.DefKey) {
+	k.Repo = a.path(k.Repo)
+	k.CommitID = a.commitID(k.CommitID)
+	k.Unit = a.path(k.Unit)
+	k.Path = a.path(k.Path)
+}
+
+func (a Anonymizer) hash(s string) string {
+	h := sha256.Sum256([]byte(a.Salt + s))
+	return hex.EncodeToString(h[:])
+}
+
+// ident anonymizes a single identifier (or other string).
+func (a Anonymizer) ident(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "x" + a.hash(s)[:12]
+}
+
+func (a Anonymizer) commitID(id string) string {
+	if id == "" {
+		return ""
+	}
+	return a.hash(id)[:40]
+}
+
+// path anonymizes each component of a slash-separated path (such as a
+// repo URI, source unit name, or def path).
+func (a Anonymizer) path(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		if part != "." && part != ".." {
+			parts[i] = a.ident(part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// file anonymizes a file path, preserving the file's extension.
+func (a Anonymizer) file(p string) string {
+	ext := path.Ext(p)
+	if ext == path.Base(p) {
+		ext = "" // a dotfile
+	}
+	return a.path(strings.TrimSuffix(p, ext)) + ext
+}
+
+// data anonymizes toolchain-specific JSON data by anonymizing all
+// string values in it. Object keys are preserved. If data isn't
+// valid JSON, it is removed.
+func (a Anonymizer) data(data pbtypes.RawMessage) pbtypes.RawMessage {
+	if len(data) == 0 {
+		return data
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil
+	}
+	b, err := json.Marshal(a.jsonValue(v))
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (a Anonymizer) jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return a.ident(v)
+	case []interface{}:
+		for i, e := range v {
+			v[i] = a.jsonValue(e)
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = a.jsonValue(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,65 @@
+package grapher
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestAnonymizer(t *testing.T) {
+	o := &graph.Output{
+		Defs: []*graph.Def{{
+			DefKey: graph.DefKey{Repo: "example.com/secret", Unit: "example.com/secret/pkg", UnitType: "GoPackage", Path: "Type/Method"},
+			Name:   "Method",
+			Kind:   "func",
+			File:   "pkg/type.go",
+			Data:   []byte(`{"Signature":"func(x int)","Exported":true}`),
+			Docs:   []*graph.DefDoc{{Format: "text/plain", Data: "Method does secret things."}},
+		}},
+		Refs: []*graph.Ref{{
+			DefRepo:     "example.com/secret",
+			DefUnitType: "GoPackage",
+			DefUnit:     "example.com/secret/pkg",
+			DefPath:     "Type/Method",
+			File:        "pkg/main.go",
+			Start:       10,
+			End:         16,
+		}},
+	}
+	a := Anonymizer{Salt: "s"}
+	a.Anonymize(o)
+
+	def, ref := o.Defs[0], o.Refs[0]
+	b, _ := json.Marshal(o)
+	for _, secret := range []string{"secret", "Method", "pkg", "type", "main", "Signature does"} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("anonymized data contains %q: %s", secret, b)
+		}
+	}
+
+	if def.Path != ref.DefPath || def.Unit != ref.DefUnit || def.Repo != ref.DefRepo {
+		t.Errorf("def key %+v doesn't match ref def key %+v", def.DefKey, ref.DefKey())
+	}
+	if !strings.HasSuffix(def.Path, "/"+def.Name) {
+		t.Errorf("def name %q isn't the last component of its path %q", def.Name, def.Path)
+	}
+	if !strings.HasSuffix(def.File, ".go") || strings.Split(def.File, "/")[0] != strings.Split(ref.File, "/")[0] {
+		t.Errorf("file paths %q and %q lost their structure", def.File, ref.File)
+	}
+	if def.Kind != "func" || def.UnitType != "GoPackage" || ref.Start != 10 || ref.End != 16 {
+		t.Error("kinds, types, and offsets should be preserved")
+	}
+	if !strings.Contains(string(def.Data), `"Exported":true`) {
+		t.Errorf("def data %s lost its structure", def.Data)
+	}
+
+	// Anonymization is deterministic.
+	if got, want := a.path("example.com/secret/pkg"), def.Unit; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if (Anonymizer{Salt: "t"}).path("example.com/secret/pkg") == def.Unit {
+		t.Error("different salts produced the same hash")
+	}
+}
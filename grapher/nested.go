@@ -0,0 +1,59 @@
+package grapher
+
+import (
+	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// AttributeNestedRepos modifies o (the graph output of a source unit
+// in the current repository) so that the data of repos, the
+// repositories nested in the current repository's tree, is attributed
+// to them. Refs to defs in their source units are changed to refer to
+// the nested repository (so they resolve like refs to any other
+// dependency), and defs, refs, docs, and anns in their files are
+// removed (they are graphed as part of the nested repositories).
+func AttributeNestedRepos(o *graph.Output, repos []*config.NestedRepo) {
+	if len(repos) == 0 {
+		return
+	}
+	inNested := func(file string) bool { return config.NestedRepoAt(repos, file) != nil }
+
+	defs := o.Defs[:0]
+	for _, def := range o.Defs {
+		if !inNested(def.File) {
+			defs = append(defs, def)
+		}
+	}
+	o.Defs = defs
+
+	refs := o.Refs[:0]
+	for _, ref := range o.Refs {
+		if inNested(ref.File) {
+			continue
+		}
+		if ref.DefRepo == "" {
+			if r := config.NestedRepoOfUnit(repos, unit.ID2{Type: ref.DefUnitType, Name: ref.DefUnit}); r != nil {
+				ref.DefRepo = r.URI
+			}
+		}
+		refs = append(refs, ref)
+	}
+	o.Refs = refs
+
+	docs := o.Docs[:0]
+	for _, doc := range o.Docs {
+		if !inNested(doc.File) {
+			docs = append(docs, doc)
+		}
+	}
+	o.Docs = docs
+
+	anns := o.Anns[:0]
+	for _, a := range o.Anns {
+		if !inNested(a.File) {
+			anns = append(anns, a)
+		}
+	}
+	o.Anns = anns
+}
@@ -0,0 +1,51 @@
+package grapher
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/ann"
+	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestAttributeNestedRepos(t *testing.T) {
+	repos := []*config.NestedRepo{
+		{Dir: "lib/sub", URI: "example.com/sub", Units: []unit.ID2{{Type: "t", Name: "sub/u"}}},
+	}
+	o := &graph.Output{
+		Defs: []*graph.Def{
+			{DefKey: graph.DefKey{Path: "a"}, File: "a.x"},
+			{DefKey: graph.DefKey{Path: "b"}, File: "lib/sub/b.x"},
+			{DefKey: graph.DefKey{Path: "c"}, File: "lib/subdir/c.x"},
+		},
+		Refs: []*graph.Ref{
+			{DefUnitType: "t", DefUnit: "u", DefPath: "a", File: "a.x"},
+			{DefUnitType: "t", DefUnit: "sub/u", DefPath: "b", File: "a.x"},
+			{DefRepo: "example.com/other", DefUnitType: "t", DefUnit: "sub/u", DefPath: "b", File: "a.x"},
+			{DefUnitType: "t", DefUnit: "sub/u", DefPath: "b", File: "lib/sub/b.x"},
+		},
+		Docs: []*graph.Doc{{DefKey: graph.DefKey{Path: "b"}, File: "lib/sub/b.x"}},
+		Anns: []*ann.Ann{{File: "a.x"}, {File: "lib/sub/b.x"}},
+	}
+	AttributeNestedRepos(o, repos)
+
+	var defPaths []string
+	for _, def := range o.Defs {
+		defPaths = append(defPaths, def.Path)
+	}
+	if want := []string{"a", "c"}; !reflect.DeepEqual(defPaths, want) {
+		t.Errorf("got defs %v, want %v", defPaths, want)
+	}
+	var defRepos []string
+	for _, ref := range o.Refs {
+		defRepos = append(defRepos, ref.DefRepo)
+	}
+	if want := []string{"", "example.com/sub", "example.com/other"}; !reflect.DeepEqual(defRepos, want) {
+		t.Errorf("got ref def repos %q, want %q", defRepos, want)
+	}
+	if len(o.Docs) != 0 || len(o.Anns) != 1 {
+		t.Errorf("got %d docs and %d anns, want only the ann outside of the nested repo", len(o.Docs), len(o.Anns))
+	}
+}
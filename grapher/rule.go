@@ -22,6 +22,7 @@ const graphOp = "graph"
 func init() {
 	plan.RegisterRuleMaker(graphOp, makeGraphRules)
 	buildstore.RegisterDataType("graph", &graph.Output{})
+	buildstore.RegisterDataType("graph-env", &toolchain.Env{})
 }
 
 func makeGraphRules(c *config.Tree, dataDir string, existing []makex.Rule, opt plan.Options) ([]makex.Rule, error) {
@@ -69,10 +70,16 @@ func (r *GraphUnitRule) Prereqs() []string {
 	return ps
 }
 
+// EnvTarget is the build data file that the environment the grapher
+// ran in is recorded to.
+func (r *GraphUnitRule) EnvTarget() string {
+	return filepath.ToSlash(filepath.Join(r.dataDir, plan.SourceUnitDataFilename(&toolchain.Env{}, r.Unit)))
+}
+
 func (r *GraphUnitRule) Recipes() []string {
 	safeCommand := util.SafeCommandName(srclib.CommandName)
 	return []string{
-		fmt.Sprintf("%s tool %s %q %q < $< | %s internal normalize-graph-data --unit-type %q --dir . 1> $@", safeCommand, r.opt.ToolchainExecOpt, r.Tool.Toolchain, r.Tool.Subcmd, safeCommand, r.Unit.Type),
+		fmt.Sprintf("%s tool %s --env-output %q %q %q < $< | %s internal normalize-graph-data --unit-type %q --dir . 1> $@", safeCommand, r.opt.ToolchainExecOpt, r.EnvTarget(), r.Tool.Toolchain, r.Tool.Subcmd, safeCommand, r.Unit.Type),
 	}
 }
 
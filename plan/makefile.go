@@ -80,6 +80,14 @@ func (r *cachedRule) SourceUnit() *unit.SourceUnit {
 	return r.unit
 }
 
+// IsCachedRule reports whether r is a rule (created by CreateMakefile)
+// that copies its target from a previous commit's build data instead
+// of building it.
+func IsCachedRule(r makex.Rule) bool {
+	_, ok := r.(*cachedRule)
+	return ok
+}
+
 // listLatestCommitIDs lists the latest commit ids.
 func listLatestCommitIDs(vcsType string) ([]string, error) {
 	if vcsType != "git" {
@@ -40,10 +40,10 @@ func TestCreateMakefile(t *testing.T) {
 all: testdata/n/t.depresolve.json testdata/n/t.graph.json
 
 testdata/n/t.depresolve.json: testdata/n/t.unit.json
-	srclib tool  "tc" "t" < $^ 1> $@
+	srclib tool  "tc" "t" < $^ | srclib internal normalize-dep-data 1> $@
 
 testdata/n/t.graph.json: testdata/n/t.unit.json
-	srclib tool  "tc" "t" < $< | srclib internal normalize-graph-data --unit-type "t" --dir . 1> $@
+	srclib tool  --env-output "testdata/n/t.graph-env.json" "tc" "t" < $< | srclib internal normalize-graph-data --unit-type "t" --dir . 1> $@
 
 .DELETE_ON_ERROR:
 `
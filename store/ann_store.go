@@ -0,0 +1,200 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+
+	"code.google.com/p/rog-go/parallel"
+
+	"sourcegraph.com/sourcegraph/srclib/ann"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// An AnnStore is a store that holds anns: annotations, such as lint
+// findings, coverage, and deprecation marks, that toolchains and
+// other tools attach to ranges of files or to defs (see ann.Ann).
+// Clients overlay them on the code they show.
+//
+// A source unit's anns are imported with its defs and refs (in
+// graph.Output's Anns), or replaced later with ImportAnns. The
+// file-backed and in-memory stores in this package (at each level)
+// are AnnStores.
+type AnnStore interface {
+	// Anns returns all anns that match the filters.
+	Anns(...AnnFilter) ([]*ann.Ann, error)
+}
+
+var (
+	_ AnnStore = (*fsUnitStore)(nil)
+	_ AnnStore = (*memoryUnitStore)(nil)
+	_ AnnStore = unitStores{}
+	_ AnnStore = treeStores{}
+	_ AnnStore = repoStores{}
+)
+
+func (s unitStores) Anns(f ...AnnFilter) ([]*ann.Ann, error) {
+	uss, err := openUnitStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		allAnns   []*ann.Ann
+		allAnnsMu sync.Mutex
+	)
+	par := parallel.NewRun(storeFetchPar)
+	for u_, us_ := range uss {
+		u := u_
+		as, ok := us_.(AnnStore)
+		if !ok {
+			continue
+		}
+
+		par.Do(func() error {
+			anns, err := as.Anns(filtersForUnit(u, f).([]AnnFilter)...)
+			if err != nil && !isStoreNotExist(err) {
+				return err
+			}
+			for _, a := range anns {
+				a.UnitType = u.Type
+				a.Unit = u.Name
+			}
+			allAnnsMu.Lock()
+			allAnns = append(allAnns, anns...)
+			allAnnsMu.Unlock()
+			return nil
+		})
+	}
+	err = par.Wait()
+	return allAnns, err
+}
+
+func (s treeStores) Anns(f ...AnnFilter) ([]*ann.Ann, error) {
+	tss, err := openTreeStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var allAnns []*ann.Ann
+	for commitID, ts := range tss {
+		as, ok := ts.(AnnStore)
+		if !ok {
+			continue
+		}
+
+		anns, err := as.Anns(f...)
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, a := range anns {
+			a.CommitID = commitID
+		}
+		allAnns = append(allAnns, anns...)
+	}
+	return allAnns, nil
+}
+
+func (s repoStores) Anns(f ...AnnFilter) ([]*ann.Ann, error) {
+	rss, err := openRepoStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		allAnns   []*ann.Ann
+		allAnnsMu sync.Mutex
+	)
+	par := parallel.NewRun(storeFetchPar)
+	for repo_, rs_ := range rss {
+		repo := repo_
+		as, ok := rs_.(AnnStore)
+		if !ok {
+			continue
+		}
+
+		par.Do(func() error {
+			anns, err := as.Anns(filtersForRepo(repo, f).([]AnnFilter)...)
+			if err != nil && !isStoreNotExist(err) {
+				return err
+			}
+			for _, a := range anns {
+				a.Repo = repo
+			}
+			allAnnsMu.Lock()
+			allAnns = append(allAnns, anns...)
+			allAnnsMu.Unlock()
+			return nil
+		})
+	}
+	err = par.Wait()
+	return allAnns, err
+}
+
+func (s *memoryUnitStore) Anns(f ...AnnFilter) ([]*ann.Ann, error) {
+	if s.data == nil {
+		return nil, errUnitNoInit
+	}
+
+	var anns []*ann.Ann
+	for _, a := range s.data.Anns {
+		if annFilters(f).SelectAnn(a) {
+			anns = append(anns, a)
+		}
+	}
+	return anns, nil
+}
+
+// An annImporter is a unit store whose anns can be replaced without
+// re-importing its other data.
+type annImporter interface {
+	importAnns([]*ann.Ann) error
+}
+
+func (s *fsUnitStore) importAnns(anns []*ann.Ann) error { return s.writeAnns(anns) }
+
+func (s *memoryUnitStore) importAnns(anns []*ann.Ann) error {
+	if s.data == nil {
+		return errUnitNoInit
+	}
+	s.data.Anns = anns
+	return nil
+}
+
+// ImportAnns replaces the anns of a source unit that's already in the
+// store s with anns (e.g., the findings of a linter or coverage tool
+// that ran after the unit was built), leaving its defs and refs as
+// they are. The repo is only used if s is a MultiRepoStore, and the
+// commit ID if s is a MultiRepoStore or RepoStore.
+func ImportAnns(s interface{}, repo, commitID string, u unit.ID2, anns []*ann.Ann) error {
+	if mrs, ok := s.(repoStoreOpener); ok {
+		if s = mrs.openRepoStore(repo); s == nil {
+			return fmt.Errorf("no repo %q in store", repo)
+		}
+	}
+	if rs, ok := s.(treeStoreOpener); ok {
+		if s = rs.openTreeStore(commitID); s == nil {
+			return fmt.Errorf("no commit %q in store", commitID)
+		}
+	}
+	ts, ok := s.(TreeStore)
+	if !ok {
+		return fmt.Errorf("can't import anns into store of type %T", s)
+	}
+	if units, err := ts.Units(ByUnits(u)); err != nil && !isStoreNotExist(err) {
+		return err
+	} else if len(units) == 0 {
+		return fmt.Errorf("no source unit %s %s at commit %q in store", u.Type, u.Name, commitID)
+	}
+
+	var us UnitStore
+	if o, ok := ts.(unitStoreOpener); ok {
+		us = o.openUnitStore(u)
+	}
+	ai, ok := us.(annImporter)
+	if !ok {
+		return fmt.Errorf("can't import anns into unit store of type %T", us)
+	}
+	cleanForImport(&graph.Output{Anns: anns}, "", "", "")
+	return ai.importAnns(anns)
+}
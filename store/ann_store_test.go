@@ -0,0 +1,123 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/ann"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestAnns(t *testing.T) {
+	defer func(orig bool) { useIndexedStore = orig }(useIndexedStore)
+
+	anns := func() []*ann.Ann {
+		return []*ann.Ann{
+			{File: "f.go", Start: 0, End: 10, Type: "lint"},
+			{File: "f.go", Start: 20, End: 30, Type: "coverage"},
+			{File: "g.go", Start: 5, End: 5, Type: "lint"},
+			{DefPath: "p/T", Type: "deprecated"},
+		}
+	}
+	// desc describes anns so they can be compared regardless of order.
+	desc := func(anns []*ann.Ann) []string {
+		s := make([]string, len(anns))
+		for i, a := range anns {
+			s[i] = fmt.Sprintf("%s@%s:%s/%s:%s:%s:%d:%d:%s", a.Repo, a.CommitID, a.UnitType, a.Unit, a.Type, a.File, a.Start, a.End, a.DefPath)
+		}
+		sort.Strings(s)
+		return s
+	}
+	u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f.go", "g.go"}}
+	uid := unit.ID2{Type: "t", Name: "u"}
+
+	stores := map[string]func() interface{}{
+		"fs": func() interface{} {
+			useIndexedStore = false
+			return NewFSRepoStore(rwvfs.Map(map[string]string{}))
+		},
+		"indexed": func() interface{} {
+			useIndexedStore = true
+			return NewFSRepoStore(rwvfs.Map(map[string]string{}))
+		},
+		"memory":    func() interface{} { return newMemoryRepoStore() },
+		"multiRepo": func() interface{} { return NewFSMultiRepoStore(rwvfs.Walkable(rwvfs.Map(map[string]string{})), nil) },
+	}
+	for label, newStore := range stores {
+		s := newStore()
+		data := graph.Output{Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p/T"}, File: "f.go"}}, Anns: anns()}
+		var err error
+		switch s := s.(type) {
+		case RepoImporter:
+			err = s.Import("c", u, data)
+		case MultiRepoImporter:
+			err = s.Import("r", "c", u, data)
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", label, err)
+		}
+		repo := ""
+		if label == "multiRepo" {
+			repo = "r"
+		}
+		prefix := repo + "@c:t/u:"
+
+		tests := map[string]struct {
+			filters []AnnFilter
+			want    []string
+		}{
+			"all": {
+				want: []string{prefix + "coverage:f.go:20:30:", prefix + "deprecated::0:0:p/T", prefix + "lint:f.go:0:10:", prefix + "lint:g.go:5:5:"},
+			},
+			"types": {
+				filters: []AnnFilter{ByAnnTypes("lint"), ByUnits(uid), ByCommitIDs("c")},
+				want:    []string{prefix + "lint:f.go:0:10:", prefix + "lint:g.go:5:5:"},
+			},
+			"files": {
+				filters: []AnnFilter{ByFiles("g.go")},
+				want:    []string{prefix + "lint:g.go:5:5:"},
+			},
+			"file range": {
+				filters: []AnnFilter{ByFileRange("f.go", 5, 20)},
+				want:    []string{prefix + "lint:f.go:0:10:"},
+			},
+			"def": {
+				filters: []AnnFilter{ByDefKey(graph.DefKey{Repo: repo, CommitID: "c", UnitType: "t", Unit: "u", Path: "p/T"})},
+				want:    []string{prefix + "deprecated::0:0:p/T"},
+			},
+		}
+		for name, test := range tests {
+			got, err := s.(AnnStore).Anns(test.filters...)
+			if err != nil {
+				t.Fatalf("%s: %s: %s", label, name, err)
+			}
+			if got := desc(got); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%s: %s: got anns %v, want %v", label, name, got, test.want)
+			}
+		}
+
+		// Replace the unit's anns without re-importing it.
+		if err := ImportAnns(s, repo, "c", uid, []*ann.Ann{{File: "g.go", Type: "coverage", Unit: "x"}}); err != nil {
+			t.Fatalf("%s: %s", label, err)
+		}
+		got, err := s.(AnnStore).Anns()
+		if err != nil {
+			t.Fatalf("%s: %s", label, err)
+		}
+		if want := []string{prefix + "coverage:g.go:0:0:"}; !reflect.DeepEqual(desc(got), want) {
+			t.Errorf("%s: after ImportAnns, got anns %v, want %v", label, desc(got), want)
+		}
+		if defs, err := s.(interface {
+			Defs(...DefFilter) ([]*graph.Def, error)
+		}).Defs(); err != nil || len(defs) != 1 {
+			t.Errorf("%s: after ImportAnns, got defs %v (error %v), want the imported def", label, defs, err)
+		}
+		if err := ImportAnns(s, repo, "c", unit.ID2{Type: "t", Name: "nounit"}, nil); err == nil {
+			t.Errorf("%s: ImportAnns of a nonexistent unit: got no error", label)
+		}
+	}
+}
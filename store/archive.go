@@ -0,0 +1,183 @@
+package store
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/kr/fs"
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// An ArchiveManifest describes the commit whose data is in a store
+// archive (see ExportCommit). It is the archive's first file.
+type ArchiveManifest struct {
+	// Version is the version of the archive format.
+	Version int
+
+	// Repo is the repository of the commit, if known, and CommitID
+	// is its commit ID.
+	Repo     string `json:",omitempty"`
+	CommitID string
+
+	// Created is when the archive was written.
+	Created time.Time
+}
+
+const (
+	// ArchiveManifestFilename is the name of the manifest file in a
+	// store archive.
+	ArchiveManifestFilename = "srclib-archive.json"
+
+	// archiveVersion is the version of the archive format that
+	// ExportCommit writes.
+	archiveVersion = 1
+
+	// archiveDataDir is the dir in a store archive that holds the
+	// files of the commit's dir.
+	archiveDataDir = "data"
+)
+
+// ExportCommit writes a tar archive to w that holds the data and
+// indexes of commit m.CommitID in the FS-backed repo store whose files
+// are in repoFS, so that they can be restored in another store with
+// an ArchiveReader. Files that were deduplicated into blobs (see
+// DedupeCommits) are written in full. It returns the number of files
+// written.
+func ExportCommit(w io.Writer, repoFS rwvfs.FileSystem, m ArchiveManifest) (int, error) {
+	commitFS := rwvfs.Walkable(rwvfs.Sub(repoFS, m.CommitID))
+	if _, err := commitFS.Stat("."); err != nil {
+		return 0, fmt.Errorf("commit %s is not in the store: %s", m.CommitID, err)
+	}
+	contents := newBlobFS(commitFS, rwvfs.Sub(repoFS, blobsDir))
+
+	m.Version = archiveVersion
+	if m.Created.IsZero() {
+		m.Created = time.Now()
+	}
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	tw := tar.NewWriter(w)
+	if err := writeTarFile(tw, ArchiveManifestFilename, m.Created, manifest); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	walker := fs.WalkFS(".", commitFS)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return n, err
+		}
+		if !walker.Stat().Mode().IsRegular() {
+			continue
+		}
+		name := path.Clean(walker.Path())
+		data, err := readFile(contents, name)
+		if err != nil {
+			return n, err
+		}
+		if err := writeTarFile(tw, path.Join(archiveDataDir, name), walker.Stat().ModTime(), data); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, tw.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, modTime time.Time, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func readFile(fs rwvfs.FileSystem, name string) ([]byte, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// An ArchiveReader reads a store archive written by ExportCommit.
+type ArchiveReader struct {
+	Manifest ArchiveManifest
+
+	tr *tar.Reader
+}
+
+// NewArchiveReader reads the manifest of the store archive read from
+// r.
+func NewArchiveReader(r io.Reader) (*ArchiveReader, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err == io.EOF || (err == nil && hdr.Name != ArchiveManifestFilename) {
+		return nil, errors.New("not a srclib store archive (no manifest)")
+	} else if err != nil {
+		return nil, err
+	}
+	ar := &ArchiveReader{tr: tr}
+	if err := json.NewDecoder(tr).Decode(&ar.Manifest); err != nil {
+		return nil, fmt.Errorf("reading store archive manifest: %s", err)
+	}
+	if ar.Manifest.Version != archiveVersion {
+		return nil, fmt.Errorf("unsupported store archive version %d (this version of srclib reads version %d)", ar.Manifest.Version, archiveVersion)
+	}
+	if ar.Manifest.CommitID == "" || strings.ContainsAny(ar.Manifest.CommitID, "/.") {
+		return nil, fmt.Errorf("invalid commit ID %q in store archive manifest", ar.Manifest.CommitID)
+	}
+	return ar, nil
+}
+
+// Extract writes the files of the archived commit's dir to commitFS
+// (the commit's dir in a repo store) and returns how many it wrote.
+func (ar *ArchiveReader) Extract(commitFS rwvfs.FileSystem) (int, error) {
+	n := 0
+	for {
+		hdr, err := ar.tr.Next()
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if !strings.HasPrefix(name, archiveDataDir+"/") {
+			continue
+		}
+		name = strings.TrimPrefix(name, archiveDataDir+"/")
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return n, fmt.Errorf("invalid file path %q in store archive", hdr.Name)
+		}
+		if dir := path.Dir(name); dir != "." {
+			if err := rwvfs.MkdirAll(commitFS, dir); err != nil {
+				return n, err
+			}
+		}
+		f, err := commitFS.Create(name)
+		if err != nil {
+			return n, err
+		}
+		if _, err := io.Copy(f, ar.tr); err != nil {
+			f.Close()
+			return n, err
+		}
+		if err := f.Close(); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestExportCommit(t *testing.T) {
+	defer func(orig bool) { useIndexedStore = orig }(useIndexedStore)
+	useIndexedStore = true
+
+	var data graph.Output
+	for i := 0; i < 100; i++ {
+		path := fmt.Sprintf("p%d", i)
+		data.Defs = append(data.Defs, &graph.Def{DefKey: graph.DefKey{Path: path}, Name: path, File: "a.go"})
+	}
+	u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"a.go"}}
+	src := rwvfs.Map(map[string]string{})
+	rs := NewFSRepoStore(src)
+	for _, commitID := range []string{"c1", "c2"} {
+		if err := rs.(RepoStoreImporter).Import(commitID, u, data); err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.(RepoIndexer).Index(commitID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Export a commit whose files point to blobs.
+	if _, err := DedupeCommits(rs); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	n, err := ExportCommit(&buf, src, ArchiveManifest{Repo: "r", CommitID: "c2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Fatal("got no files exported")
+	}
+	if _, err := ExportCommit(&bytes.Buffer{}, src, ArchiveManifest{CommitID: "c3"}); err == nil {
+		t.Error("got no error exporting a nonexistent commit")
+	}
+
+	ar, err := NewArchiveReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.Manifest.Repo != "r" || ar.Manifest.CommitID != "c2" || ar.Manifest.Version != archiveVersion {
+		t.Errorf("got manifest %+v, want repo r and commit c2", ar.Manifest)
+	}
+	m := map[string]string{}
+	dst := rwvfs.Map(m)
+	if err := rwvfs.MkdirAll(dst, "c2"); err != nil {
+		t.Fatal(err)
+	}
+	if n2, err := ar.Extract(rwvfs.Sub(dst, "c2")); err != nil {
+		t.Fatal(err)
+	} else if n2 != n {
+		t.Errorf("got %d files extracted, want %d", n2, n)
+	}
+	for name, data := range m {
+		if strings.HasPrefix(data, string(blobPointerMagic)) {
+			t.Errorf("got blob pointer in extracted file %s, want its contents", name)
+		}
+	}
+
+	defPaths := func(rs RepoStore) []string {
+		defs, err := rs.Defs(ByCommitIDs("c2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths := make([]string, len(defs))
+		for i, def := range defs {
+			paths[i] = def.Path
+		}
+		sort.Strings(paths)
+		return paths
+	}
+	if got, want := defPaths(NewFSRepoStore(dst)), defPaths(rs); len(want) != 100 || !reflect.DeepEqual(got, want) {
+		t.Errorf("got defs %v in extracted commit, want %v", got, want)
+	}
+}
+
+func TestNewArchiveReader_invalid(t *testing.T) {
+	archive := func(name, manifest string) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		if err := writeTarFile(tw, name, time.Time{}, []byte(manifest)); err != nil {
+			t.Fatal(err)
+		}
+		tw.Close()
+		return buf.Bytes()
+	}
+	tests := map[string][]byte{
+		"empty":       archive("x", "")[:0],
+		"no manifest": archive("data/a.json", "{}"),
+		"bad version": archive(ArchiveManifestFilename, `{"Version":99,"CommitID":"c"}`),
+		"bad commit":  archive(ArchiveManifestFilename, `{"Version":1,"CommitID":"../c"}`),
+	}
+	for label, data := range tests {
+		if _, err := NewArchiveReader(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: got no error", label)
+		}
+	}
+}
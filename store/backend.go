@@ -0,0 +1,132 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// A Backend provides the storage that the FS-based stores persist
+// their data to. The stores only ever see an rwvfs.FileSystem, so a
+// backend is free to keep the files somewhere other than on disk.
+type Backend interface {
+	// Open opens (creating it if necessary) the storage located at
+	// root.
+	Open(root string) (rwvfs.FileSystem, error)
+}
+
+// Backends holds the available store backends, keyed by the name
+// used to select them (e.g., with `src store --backend`).
+var Backends = map[string]Backend{
+	"fs":   FSBackend{},
+	"bolt": BoltBackend{},
+}
+
+// BackendNames returns the sorted names of the available backends.
+func BackendNames() []string {
+	names := make([]string, 0, len(Backends))
+	for name := range Backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OpenBackend opens the storage located at root using the named
+// backend.
+func OpenBackend(name, root string) (rwvfs.FileSystem, error) {
+	b, ok := Backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unrecognized store backend %q (valid values are %s)", name, strings.Join(BackendNames(), ", "))
+	}
+	return b.Open(root)
+}
+
+// FSBackend stores each file in the store as a file on the local
+// filesystem under root. It is the default backend.
+type FSBackend struct{}
+
+func (FSBackend) Open(root string) (rwvfs.FileSystem, error) {
+	fs := rwvfs.OS(root)
+	setCreateParentDirs(fs)
+	return replacingOSFS{FileSystem: fs, root: root}, nil
+}
+
+// replacingOSFS is rwvfs.OS, except that Create writes to a temporary
+// file in the same directory and renames it over the named file when
+// it is closed. Readers (including those that have the old file
+// mmapped) never see a partly written file, and a write that fails
+// leaves the old file intact, so commands like `src store compact`
+// can safely rewrite files in place.
+//
+// The other backends' writes are already atomic: bolt commits each
+// file in a single transaction when it is closed, and each file in
+// object storage is written with a single put.
+type replacingOSFS struct {
+	rwvfs.FileSystem
+	root string
+}
+
+func (fs replacingOSFS) Create(name string) (io.WriteCloser, error) {
+	p := filepath.Join(fs.root, path.Clean("/"+name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+		return nil, &os.PathError{Op: "create", Path: name, Err: syscall.EISDIR}
+	}
+	// The temporary file's name starts with a dot and doesn't end in
+	// the named file's extension, so that it is never mistaken for a
+	// store file if it is left behind.
+	for i := 0; ; i++ {
+		tmp := filepath.Join(filepath.Dir(p), "."+filepath.Base(p)+".tmp"+strconv.FormatInt(rand.Int63(), 36))
+		f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && i < 100 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &replacingFile{f: f, dst: p}, nil
+	}
+}
+
+// replacingFile is a temporary file that is renamed to dst when it is
+// closed (see replacingOSFS). If a write to it failed, it is removed
+// instead, because callers close files after failed writes.
+type replacingFile struct {
+	f        *os.File
+	dst      string
+	writeErr error
+}
+
+func (f *replacingFile) Write(p []byte) (int, error) {
+	n, err := f.f.Write(p)
+	if err != nil && f.writeErr == nil {
+		f.writeErr = err
+	}
+	return n, err
+}
+
+func (f *replacingFile) Close() error {
+	err := f.f.Close()
+	if err == nil {
+		err = f.writeErr
+	}
+	if err == nil {
+		err = os.Rename(f.f.Name(), f.dst)
+	}
+	if err != nil {
+		os.Remove(f.f.Name())
+	}
+	return err
+}
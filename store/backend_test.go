@@ -0,0 +1,67 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+func TestFSBackend_replace(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "srclib-fs-backend-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	fs, err := FSBackend{}.Open(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, fs, "d/f", "old")
+	w, err := fs.Create("d/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("new contents")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Until the new file is closed, readers see the old one.
+	if got := readTestFile(t, fs, "d/f"); got != "old" {
+		t.Errorf("before close, got %q, want %q", got, "old")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, fs, "d/f"); got != "new contents" {
+		t.Errorf("after close, got %q, want %q", got, "new contents")
+	}
+
+	// The temporary file is gone.
+	fis, err := fs.ReadDir("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 || fis[0].Name() != "f" {
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		t.Errorf("got dir entries %v, want [f]", names)
+	}
+}
+
+func readTestFile(t *testing.T, fs rwvfs.FileSystem, name string) string {
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
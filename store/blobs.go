@@ -0,0 +1,411 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/kr/fs"
+	"golang.org/x/tools/godoc/vfs"
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// blobsDir is the dir (alongside the commit dirs of an FS-backed repo
+// store) that holds the content-addressed blobs that the commits'
+// files are deduplicated into. See DedupeCommits.
+const blobsDir = ".blobs"
+
+// minDedupeSize is the size (in bytes) below which files aren't
+// deduplicated, since a blob pointer wouldn't save much space.
+const minDedupeSize = 512
+
+// A blob pointer is a file in a commit dir whose contents were moved
+// to a blob. It consists of blobPointerMagic, the hex SHA-256 of the
+// blob, and a newline.
+var blobPointerMagic = []byte("srclib-blob sha256:")
+
+var blobPointerLen = int64(len(blobPointerMagic) + 2*sha256.Size + 1)
+
+func blobPointer(sum string) []byte {
+	return []byte(string(blobPointerMagic) + sum + "\n")
+}
+
+// blobPath returns the path of a blob (relative to blobsDir), which
+// is sharded by the first byte of its SHA-256 so that dirs stay small.
+func blobPath(sum string) string { return path.Join(sum[:2], sum[2:]) }
+
+// readBlobPointer returns the blob SHA-256 that the named file points
+// to, or false if it is not a blob pointer. fi is the file's
+// FileInfo.
+func readBlobPointer(fs vfs.FileSystem, name string, fi os.FileInfo) (sum string, ok bool, err error) {
+	if !fi.Mode().IsRegular() || fi.Size() != blobPointerLen {
+		return "", false, nil
+	}
+	f, err := fs.Open(name)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", false, err
+	}
+	if !bytes.HasPrefix(data, blobPointerMagic) || data[len(data)-1] != '\n' {
+		return "", false, nil
+	}
+	sum = string(data[len(blobPointerMagic) : len(data)-1])
+	if _, err := hex.DecodeString(sum); err != nil {
+		return "", false, nil
+	}
+	return sum, true, nil
+}
+
+// blobFS is a commit dir's filesystem, in which reads of blob pointer
+// files read the blobs that they point to instead. Writes replace a
+// pointer with the new contents (leaving the blob alone).
+type blobFS struct {
+	rwvfs.FileSystem
+	blobs rwvfs.FileSystem
+}
+
+// blobFetcherFS is a blobFS whose underlying filesystems support
+// OpenFetcher.
+type blobFetcherFS struct{ blobFS }
+
+var _ rwvfs.FetcherOpener = blobFetcherFS{}
+
+// newBlobFS returns fs, wrapped so that blob pointers in it resolve to
+// blobs in blobs.
+func newBlobFS(fs, blobs rwvfs.FileSystem) rwvfs.FileSystem {
+	bfs := blobFS{FileSystem: fs, blobs: blobs}
+	if _, ok := fs.(rwvfs.FetcherOpener); ok {
+		return blobFetcherFS{bfs}
+	}
+	return bfs
+}
+
+// resolve returns the filesystem and path that the named file's
+// contents are in.
+func (b blobFS) resolve(name string) (rwvfs.FileSystem, string, error) {
+	fi, err := b.FileSystem.Stat(name)
+	if err != nil {
+		return nil, "", err
+	}
+	sum, ok, err := readBlobPointer(b.FileSystem, name, fi)
+	if err != nil {
+		return nil, "", err
+	}
+	if !ok {
+		return b.FileSystem, name, nil
+	}
+	return b.blobs, blobPath(sum), nil
+}
+
+func (b blobFS) Open(name string) (vfs.ReadSeekCloser, error) {
+	fs, name, err := b.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Open(name)
+}
+
+// Stat returns the FileInfo of the blob that name points to, if it is
+// a blob pointer (so that sizes are those of the contents).
+func (b blobFS) Stat(name string) (os.FileInfo, error) {
+	fs, target, err := b.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fs.Stat(target)
+	if err != nil {
+		return nil, err
+	}
+	if target != name {
+		fi = namedFileInfo{FileInfo: fi, name: path.Base(name)}
+	}
+	return fi, nil
+}
+
+func (b blobFetcherFS) OpenFetcher(name string) (vfs.ReadSeekCloser, error) {
+	fs, name, err := b.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.(rwvfs.FetcherOpener).OpenFetcher(name)
+}
+
+// namedFileInfo is a FileInfo with a different name.
+type namedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi namedFileInfo) Name() string { return fi.name }
+
+// DedupeStats describes the files deduplicated by DedupeCommits.
+type DedupeStats struct {
+	// Files is the number of files replaced by blob pointers, and
+	// Blobs is the number of blobs written for them.
+	Files, Blobs int
+
+	// Before and After are the total sizes (in bytes) of the
+	// deduplicated files before, and of the pointers and new blobs
+	// after.
+	Before, After int64
+}
+
+// DedupeCommits deduplicates the files (data files, indexes, etc.)
+// that are identical in multiple commits of each repo in s (an
+// FS-backed RepoStore or MultiRepoStore), such as the data of source
+// units that didn't change between the commits. Each such file is
+// moved to a content-addressed blob in the repo store's blobs dir and
+// replaced by a small pointer to the blob, which the store follows
+// when it reads the file. Re-importing a commit replaces its pointers
+// with ordinary files.
+//
+// Blobs that are no longer pointed to (e.g., after commits are
+// removed) are left in place; use CollectBlobGarbage to remove them.
+func DedupeCommits(s interface{}) (*DedupeStats, error) {
+	rss, err := fsRepoStoresIn(s)
+	if err != nil {
+		return nil, err
+	}
+	var stats DedupeStats
+	for _, rs := range rss {
+		if err := rs.dedupe(&stats); err != nil {
+			return nil, fmt.Errorf("%s: %s", rs.fs, err)
+		}
+	}
+	return &stats, nil
+}
+
+// fsRepoStoresIn returns the FS-backed repo stores in s.
+func fsRepoStoresIn(s interface{}) ([]*fsRepoStore, error) {
+	switch s := s.(type) {
+	case *fsRepoStore:
+		return []*fsRepoStore{s}, nil
+	case *fsMultiRepoStore:
+		repos, err := s.Repos()
+		if err != nil {
+			return nil, err
+		}
+		rss := make([]*fsRepoStore, len(repos))
+		for i, repo := range repos {
+			rss[i] = s.openRepoStore(repo).(*fsRepoStore)
+		}
+		return rss, nil
+	}
+	return nil, fmt.Errorf("store (type %T) does not store commits in blobs", s)
+}
+
+// commitFiles returns the regular files (keyed by path) in all of the
+// repo store's commit dirs.
+func (s *fsRepoStore) commitFiles() (map[string]os.FileInfo, error) {
+	dirs, err := s.versionDirs()
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]os.FileInfo{}
+	for _, dir := range dirs {
+		w := fs.WalkFS(dir, rwvfs.Walkable(s.fs))
+		for w.Step() {
+			if err := w.Err(); err != nil {
+				return nil, err
+			}
+			if fi := w.Stat(); fi.Mode().IsRegular() {
+				files[w.Path()] = fi
+			}
+		}
+	}
+	return files, nil
+}
+
+// blobSizes returns the sizes of the blobs in the repo store (keyed by
+// SHA-256).
+func (s *fsRepoStore) blobSizes() (map[string]int64, error) {
+	blobs := map[string]int64{}
+	if _, err := s.fs.Stat(blobsDir); os.IsNotExist(err) {
+		return blobs, nil
+	}
+	w := fs.WalkFS(blobsDir, rwvfs.Walkable(s.fs))
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			return nil, err
+		}
+		if fi := w.Stat(); fi.Mode().IsRegular() {
+			shard := path.Base(path.Dir(w.Path()))
+			blobs[shard+fi.Name()] = fi.Size()
+		}
+	}
+	return blobs, nil
+}
+
+func (s *fsRepoStore) dedupe(stats *DedupeStats) error {
+	files, err := s.commitFiles()
+	if err != nil {
+		return err
+	}
+	blobs, err := s.blobSizes()
+	if err != nil {
+		return err
+	}
+
+	// Only hash files that might be identical to another file or to
+	// an existing blob (i.e., that have the same size).
+	sizes := map[int64]int{}
+	for _, fi := range files {
+		sizes[fi.Size()]++
+	}
+	for _, size := range blobs {
+		sizes[size]++
+	}
+	bySum := map[string][]string{}
+	for name, fi := range files {
+		if fi.Size() < minDedupeSize || sizes[fi.Size()] < 2 {
+			continue
+		}
+		sum, err := fileSHA256(s.fs, name)
+		if err != nil {
+			return err
+		}
+		bySum[sum] = append(bySum[sum], name)
+	}
+
+	sums := make([]string, 0, len(bySum))
+	for sum := range bySum {
+		sums = append(sums, sum)
+	}
+	sort.Strings(sums)
+	for _, sum := range sums {
+		names := bySum[sum]
+		size, blobExists := blobs[sum]
+		if len(names) < 2 && !blobExists {
+			continue
+		}
+		sort.Strings(names)
+		if !blobExists || size != files[names[0]].Size() {
+			// Write the blob (or rewrite it, if it was only partly
+			// written before).
+			if err := s.writeBlob(sum, names[0]); err != nil {
+				return err
+			}
+			stats.Blobs++
+			stats.After += files[names[0]].Size()
+		}
+		// Each file is replaced by its pointer atomically (see
+		// replacingOSFS), so readers see either its contents or the
+		// pointer to the (already written) blob.
+		for _, name := range names {
+			if err := writeFile(s.fs, name, blobPointer(sum)); err != nil {
+				return err
+			}
+			stats.Files++
+			stats.Before += files[name].Size()
+			stats.After += blobPointerLen
+		}
+	}
+	return nil
+}
+
+// writeBlob copies the named file to the blob with the given SHA-256.
+func (s *fsRepoStore) writeBlob(sum, name string) error {
+	p := path.Join(blobsDir, blobPath(sum))
+	if err := rwvfs.MkdirAll(s.fs, path.Dir(p)); err != nil {
+		return err
+	}
+	f, err := s.fs.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := s.fs.Create(p)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func writeFile(fs rwvfs.FileSystem, name string, data []byte) error {
+	w, err := fs.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func fileSHA256(fs rwvfs.FileSystem, name string) (string, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// BlobGCStats describes the blobs removed by CollectBlobGarbage.
+type BlobGCStats struct {
+	Blobs int   // the number of blobs removed
+	Bytes int64 // their total size
+}
+
+// CollectBlobGarbage removes the blobs in s (an FS-backed RepoStore or
+// MultiRepoStore) that no commit's files point to.
+func CollectBlobGarbage(s interface{}) (*BlobGCStats, error) {
+	rss, err := fsRepoStoresIn(s)
+	if err != nil {
+		return nil, err
+	}
+	var stats BlobGCStats
+	for _, rs := range rss {
+		if err := rs.collectBlobGarbage(&stats); err != nil {
+			return nil, fmt.Errorf("%s: %s", rs.fs, err)
+		}
+	}
+	return &stats, nil
+}
+
+func (s *fsRepoStore) collectBlobGarbage(stats *BlobGCStats) error {
+	blobs, err := s.blobSizes()
+	if err != nil || len(blobs) == 0 {
+		return err
+	}
+	files, err := s.commitFiles()
+	if err != nil {
+		return err
+	}
+	for name, fi := range files {
+		sum, ok, err := readBlobPointer(s.fs, name, fi)
+		if err != nil {
+			return err
+		}
+		if ok {
+			delete(blobs, sum)
+		}
+	}
+	for sum, size := range blobs {
+		if err := s.fs.Remove(path.Join(blobsDir, blobPath(sum))); err != nil {
+			return err
+		}
+		stats.Blobs++
+		stats.Bytes += size
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestDedupeCommits(t *testing.T) {
+	defer func(orig bool) { useIndexedStore = orig }(useIndexedStore)
+
+	// unitData returns data for a source unit that is big enough to
+	// be deduplicated.
+	unitData := func(name string) graph.Output {
+		var data graph.Output
+		for i := 0; i < 100; i++ {
+			path := fmt.Sprintf("%s/p%d", name, i)
+			data.Defs = append(data.Defs, &graph.Def{DefKey: graph.DefKey{Path: path}, Name: path, File: name + ".go"})
+			data.Refs = append(data.Refs, &graph.Ref{DefPath: path, File: name + ".go", Start: uint32(i), End: uint32(i + 1)})
+		}
+		return data
+	}
+	importUnit := func(rs RepoStoreImporter, commitID, name string) {
+		u := &unit.SourceUnit{Type: "t", Name: name, Files: []string{name + ".go"}}
+		if err := rs.Import(commitID, u, unitData(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defPaths := func(rs RepoStore, commitID string) []string {
+		defs, err := rs.Defs(ByCommitIDs(commitID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths := make([]string, len(defs))
+		for i, def := range defs {
+			paths[i] = def.Path
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	for _, indexed := range []bool{false, true} {
+		useIndexedStore = indexed
+		m := map[string]string{}
+		fs := rwvfs.Map(m)
+		rs := NewFSRepoStore(fs)
+
+		// Unit "a" is unchanged in both commits; unit "b" only
+		// exists in c1.
+		importUnit(rs, "c1", "a")
+		importUnit(rs, "c1", "b")
+		importUnit(rs, "c2", "a")
+		if indexed {
+			for _, commitID := range []string{"c1", "c2"} {
+				if err := rs.(RepoIndexer).Index(commitID); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		want1, want2 := defPaths(rs, "c1"), defPaths(rs, "c2")
+
+		stats, err := DedupeCommits(rs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Files < 4 || stats.Blobs != stats.Files/2 || stats.After >= stats.Before {
+			t.Errorf("indexed=%v: got dedupe stats %+v, want unit a's files in both commits deduped", indexed, stats)
+		}
+		if _, err := fs.Stat(blobsDir); err != nil {
+			t.Errorf("indexed=%v: blobs dir: %s", indexed, err)
+		}
+		if got := defPaths(rs, "c1"); !reflect.DeepEqual(got, want1) {
+			t.Errorf("indexed=%v: after dedupe, got c1 defs %v, want %v", indexed, got, want1)
+		}
+		if got := defPaths(rs, "c2"); !reflect.DeepEqual(got, want2) {
+			t.Errorf("indexed=%v: after dedupe, got c2 defs %v, want %v", indexed, got, want2)
+		}
+		if refs, err := rs.Refs(ByCommitIDs("c2"), ByFiles("a.go")); err != nil || len(refs) != 100 {
+			t.Errorf("indexed=%v: after dedupe, got %d c2 refs in a.go (error %v), want 100", indexed, len(refs), err)
+		}
+
+		// Deduping again finds nothing new, and a new commit's
+		// unchanged files are deduped into the existing blobs.
+		importUnit(rs, "c3", "a")
+		stats2, err := DedupeCommits(rs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats2.Blobs != 0 || stats2.Files != stats.Files/2 {
+			t.Errorf("indexed=%v: got 2nd dedupe stats %+v, want %d files and no new blobs", indexed, stats2, stats.Files/2)
+		}
+
+		// Blobs are only removed once no commit points to them.
+		if gc, err := CollectBlobGarbage(rs); err != nil || gc.Blobs != 0 {
+			t.Errorf("indexed=%v: got GC stats %+v (error %v), want no blobs removed", indexed, gc, err)
+		}
+		for name := range m {
+			if strings.HasPrefix(name, "c2/") || strings.HasPrefix(name, "c3/") {
+				delete(m, name)
+			}
+		}
+		importUnit(rs, "c1", "a") // replaces c1's pointers
+		if indexed {
+			if err := rs.(RepoIndexer).Index("c1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := defPaths(rs, "c1"); !reflect.DeepEqual(got, want1) {
+			t.Errorf("indexed=%v: after re-import, got c1 defs %v, want %v", indexed, got, want1)
+		}
+		gc, err := CollectBlobGarbage(rs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Only the blobs that c1's remaining pointers point to are
+		// kept.
+		kept := map[string]struct{}{}
+		for name, data := range m {
+			if strings.HasPrefix(data, string(blobPointerMagic)) {
+				sum := strings.TrimSpace(strings.TrimPrefix(data, string(blobPointerMagic)))
+				if _, present := m[blobsDir+"/"+blobPath(sum)]; !present {
+					t.Errorf("indexed=%v: %s points to missing blob %s", indexed, name, sum)
+				}
+				kept[sum] = struct{}{}
+			}
+		}
+		if gc.Blobs == 0 || gc.Blobs+len(kept) != stats.Blobs {
+			t.Errorf("indexed=%v: got GC stats %+v with %d blobs kept, want the other %d blobs removed", indexed, gc, len(kept), stats.Blobs-len(kept))
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/tools/godoc/vfs"
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// BoltDBFilename is the name of the Bolt database file that
+// BoltBackend creates in the store root.
+const BoltDBFilename = "store.db"
+
+// BoltBackend keeps the entire store in a single Bolt database file
+// (BoltDBFilename) in the store root, instead of in thousands of
+// small files. Each store file is a key in the database.
+type BoltBackend struct{}
+
+var (
+	boltDBsMu sync.Mutex
+	boltDBs   = map[string]*bolt.DB{}
+)
+
+// boltLockTimeout is how long to wait for another process to release
+// its lock on a Bolt database before giving up.
+const boltLockTimeout = 5 * time.Second
+
+func (BoltBackend) Open(root string) (rwvfs.FileSystem, error) {
+	dbFile, err := filepath.Abs(filepath.Join(root, BoltDBFilename))
+	if err != nil {
+		return nil, err
+	}
+
+	// Bolt takes an exclusive lock on the database file, so a second
+	// bolt.Open of the same file in this process would block forever.
+	// Share a single *bolt.DB per file instead.
+	boltDBsMu.Lock()
+	defer boltDBsMu.Unlock()
+	if db, present := boltDBs[dbFile]; present {
+		return &boltFS{db: db}, nil
+	}
+
+	if err := os.MkdirAll(root, 0777); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(dbFile, 0666, &bolt.Options{Timeout: boltLockTimeout})
+	if err != nil {
+		if err == bolt.ErrTimeout {
+			err = fmt.Errorf("store database is locked by another process")
+		}
+		return nil, fmt.Errorf("opening Bolt store %s: %s", dbFile, err)
+	}
+	fs, err := NewBoltFS(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	boltDBs[dbFile] = db
+	return fs, nil
+}
+
+var (
+	boltFilesBucket = []byte("files")
+	boltDirsBucket  = []byte("dirs")
+)
+
+// NewBoltFS returns a filesystem whose files and directories are
+// stored in db.
+func NewBoltFS(db *bolt.DB) (rwvfs.FileSystem, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltFilesBucket, boltDirsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &boltFS{db: db}, nil
+}
+
+// boltFS is an rwvfs.FileSystem backed by a Bolt database. File
+// contents are stored in the "files" bucket and directories in the
+// "dirs" bucket, both keyed by their slash-separated path relative to
+// the root (which is the empty key and always exists).
+type boltFS struct {
+	db *bolt.DB
+}
+
+func boltKey(p string) string {
+	p = path.Clean("/" + filepath.ToSlash(p))
+	return p[1:]
+}
+
+// boltChildPrefix returns the prefix shared by the keys of all of the
+// entries beneath the directory key.
+func boltChildPrefix(key string) []byte {
+	if key == "" {
+		return nil
+	}
+	return []byte(key + "/")
+}
+
+// boltGet is like (*bolt.Bucket).Get, but it also reports whether the
+// key exists (Get's return value does not distinguish missing keys
+// from empty values).
+func boltGet(b *bolt.Bucket, key string) ([]byte, bool) {
+	k, v := b.Cursor().Seek([]byte(key))
+	if k == nil || string(k) != key {
+		return nil, false
+	}
+	return v, true
+}
+
+func boltStat(tx *bolt.Tx, key string) os.FileInfo {
+	if key == "" {
+		return boltFileInfo{name: ".", dir: true}
+	}
+	if v, ok := boltGet(tx.Bucket(boltFilesBucket), key); ok {
+		return boltFileInfo{name: path.Base(key), size: int64(len(v))}
+	}
+	if _, ok := boltGet(tx.Bucket(boltDirsBucket), key); ok {
+		return boltFileInfo{name: path.Base(key), dir: true}
+	}
+	return nil
+}
+
+func (fs *boltFS) Open(name string) (vfs.ReadSeekCloser, error) {
+	key := boltKey(name)
+	var data []byte
+	err := fs.db.View(func(tx *bolt.Tx) error {
+		fi := boltStat(tx, key)
+		if fi == nil {
+			return os.ErrNotExist
+		}
+		if fi.IsDir() {
+			return syscall.EISDIR
+		}
+		v, _ := boltGet(tx.Bucket(boltFilesBucket), key)
+		// Values are only valid for the life of the transaction.
+		data = append([]byte(nil), v...)
+		return nil
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return boltFile{bytes.NewReader(data)}, nil
+}
+
+func (fs *boltFS) stat(op, name string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	fs.db.View(func(tx *bolt.Tx) error {
+		fi = boltStat(tx, boltKey(name))
+		return nil
+	})
+	if fi == nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return fi, nil
+}
+
+func (fs *boltFS) Lstat(name string) (os.FileInfo, error) { return fs.stat("lstat", name) }
+
+func (fs *boltFS) Stat(name string) (os.FileInfo, error) { return fs.stat("stat", name) }
+
+func (fs *boltFS) ReadDir(name string) ([]os.FileInfo, error) {
+	key := boltKey(name)
+	var fis []os.FileInfo
+	err := fs.db.View(func(tx *bolt.Tx) error {
+		fi := boltStat(tx, key)
+		if fi == nil {
+			return os.ErrNotExist
+		}
+		if !fi.IsDir() {
+			return syscall.ENOTDIR
+		}
+		prefix := boltChildPrefix(key)
+		for _, bucket := range [][]byte{boltDirsBucket, boltFilesBucket} {
+			c := tx.Bucket(bucket).Cursor()
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); {
+				rest := k[len(prefix):]
+				if i := bytes.IndexByte(rest, '/'); i != -1 {
+					// Skip over the rest of this child's subtree ('0'
+					// is the byte after '/').
+					k, v = c.Seek(append(append([]byte(nil), k[:len(prefix)+i]...), '0'))
+					continue
+				}
+				fis = append(fis, boltFileInfo{
+					name: string(rest),
+					size: int64(len(v)),
+					dir:  bytes.Equal(bucket, boltDirsBucket),
+				})
+				k, v = c.Next()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	sort.Sort(fileInfosByName(fis))
+	return fis, nil
+}
+
+func (fs *boltFS) Create(name string) (io.WriteCloser, error) {
+	key := boltKey(name)
+	if key == "" {
+		return nil, &os.PathError{Op: "create", Path: name, Err: syscall.EISDIR}
+	}
+	return &boltWriter{fs: fs, name: name, key: key}, nil
+}
+
+func (fs *boltFS) Mkdir(name string) error {
+	key := boltKey(name)
+	err := fs.db.Update(func(tx *bolt.Tx) error {
+		if boltStat(tx, key) != nil {
+			return os.ErrExist
+		}
+		if parent := boltStat(tx, boltKey(path.Dir(key))); parent == nil {
+			return os.ErrNotExist
+		} else if !parent.IsDir() {
+			return syscall.ENOTDIR
+		}
+		return tx.Bucket(boltDirsBucket).Put([]byte(key), []byte{})
+	})
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return nil
+}
+
+// boltMkdirAll creates the directory key and any missing parents. It
+// is only used by boltWriter: boltFS deliberately does not implement
+// rwvfs.MkdirAllOverrider, because rwvfs.Sub passes MkdirAll paths
+// through to overriders untranslated.
+func boltMkdirAll(tx *bolt.Tx, key string) error {
+	if fi := boltStat(tx, key); fi != nil {
+		if !fi.IsDir() {
+			return syscall.ENOTDIR
+		}
+		return nil
+	}
+	if err := boltMkdirAll(tx, boltKey(path.Dir(key))); err != nil {
+		return err
+	}
+	return tx.Bucket(boltDirsBucket).Put([]byte(key), []byte{})
+}
+
+func (fs *boltFS) Remove(name string) error {
+	key := boltKey(name)
+	err := fs.db.Update(func(tx *bolt.Tx) error {
+		if key == "" {
+			return syscall.EBUSY
+		}
+		fi := boltStat(tx, key)
+		if fi == nil {
+			return os.ErrNotExist
+		}
+		if !fi.IsDir() {
+			return tx.Bucket(boltFilesBucket).Delete([]byte(key))
+		}
+		prefix := boltChildPrefix(key)
+		for _, bucket := range [][]byte{boltDirsBucket, boltFilesBucket} {
+			if k, _ := tx.Bucket(bucket).Cursor().Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) {
+				return syscall.ENOTEMPTY
+			}
+		}
+		return tx.Bucket(boltDirsBucket).Delete([]byte(key))
+	})
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (fs *boltFS) String() string { return fmt.Sprintf("bolt(%s)", fs.db.Path()) }
+
+// boltWriter buffers a file's contents and writes them to the
+// database when it is closed. Any missing parent directories are
+// created, like rwvfs.OS with CreateParentDirs.
+type boltWriter struct {
+	fs     *boltFS
+	name   string
+	key    string
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (w *boltWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+func (w *boltWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.fs.db.Update(func(tx *bolt.Tx) error {
+		if fi := boltStat(tx, w.key); fi != nil && fi.IsDir() {
+			return syscall.EISDIR
+		}
+		if err := boltMkdirAll(tx, boltKey(path.Dir(w.key))); err != nil {
+			return err
+		}
+		return tx.Bucket(boltFilesBucket).Put([]byte(w.key), w.buf.Bytes())
+	})
+	if err != nil {
+		return &os.PathError{Op: "write", Path: w.name, Err: err}
+	}
+	return nil
+}
+
+type boltFile struct {
+	*bytes.Reader
+}
+
+func (boltFile) Close() error { return nil }
+
+type boltFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (fi boltFileInfo) Name() string       { return fi.name }
+func (fi boltFileInfo) Size() int64        { return fi.size }
+func (fi boltFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi boltFileInfo) IsDir() bool        { return fi.dir }
+func (fi boltFileInfo) Sys() interface{}   { return nil }
+func (fi boltFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+type fileInfosByName []os.FileInfo
+
+func (v fileInfosByName) Len() int           { return len(v) }
+func (v fileInfosByName) Less(i, j int) bool { return v[i].Name() < v[j].Name() }
+func (v fileInfosByName) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+func newTestBoltFS(t *testing.T) (rwvfs.FileSystem, func()) {
+	tmpDir, err := ioutil.TempDir("", "srclib-bolt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := BoltBackend{}.Open(tmpDir)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatal(err)
+	}
+	return fs, func() { os.RemoveAll(tmpDir) }
+}
+
+func writeTestFile(t *testing.T, fs rwvfs.FileSystem, name, data string) {
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBoltFS(t *testing.T) {
+	fs, done := newTestBoltFS(t)
+	defer done()
+
+	writeTestFile(t, fs, "a/b/c.json", "abc")
+	writeTestFile(t, fs, "a/d.json", "")
+	writeTestFile(t, fs, "a-e.json", "e")
+	if err := fs.Mkdir("a/f"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("a/f"); !os.IsExist(err) {
+		t.Errorf("got Mkdir error %v, want IsExist", err)
+	}
+	if err := fs.Mkdir("x/y"); !os.IsNotExist(err) {
+		t.Errorf("got Mkdir error %v, want IsNotExist", err)
+	}
+
+	f, err := fs.Open("/a/b/c.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "abc" {
+		t.Errorf("got contents %q, want %q", data, "abc")
+	}
+
+	if fi, err := fs.Stat("a/d.json"); err != nil || fi.IsDir() || fi.Size() != 0 {
+		t.Errorf("got Stat %v (err %v), want empty file", fi, err)
+	}
+	if fi, err := fs.Stat("a/b"); err != nil || !fi.IsDir() {
+		t.Errorf("got Stat %v (err %v), want (implicitly created) dir", fi, err)
+	}
+	if _, err := fs.Stat("a/nope"); !os.IsNotExist(err) {
+		t.Errorf("got Stat error %v, want IsNotExist", err)
+	}
+
+	readDirNames := func(name string) []string {
+		fis, err := fs.ReadDir(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		return names
+	}
+	if names, want := readDirNames("."), []string{"a", "a-e.json"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got root ReadDir %v, want %v", names, want)
+	}
+	if names, want := readDirNames("a"), []string{"b", "d.json", "f"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got ReadDir %v, want %v", names, want)
+	}
+
+	if err := fs.Remove("a/b"); err == nil {
+		t.Error("got Remove of non-empty dir to succeed, want error")
+	}
+	if err := fs.Remove("a/b/c.json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if names, want := readDirNames("a"), []string{"d.json", "f"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got ReadDir after Remove %v, want %v", names, want)
+	}
+}
+
+func TestBoltMultiRepoStore(t *testing.T) {
+	useIndexedStore = false
+	testMultiRepoStore(t, func() MultiRepoStoreImporter {
+		fs, _ := newTestBoltFS(t)
+		return NewFSMultiRepoStore(rwvfs.Walkable(rwvfs.Sub(fs, "/testdata")), nil)
+	})
+}
+
+func TestBoltMultiRepoStore_indexed(t *testing.T) {
+	useIndexedStore = true
+	defer func() { useIndexedStore = false }()
+	testMultiRepoStore(t, func() MultiRepoStoreImporter {
+		fs, _ := newTestBoltFS(t)
+		return NewFSMultiRepoStore(rwvfs.Walkable(rwvfs.Sub(fs, "/testdata")), nil)
+	})
+}
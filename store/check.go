@@ -0,0 +1,350 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// CheckIndexes is like VerifyIndexes, but it checks indexes more
+// thoroughly. Each index file's CRC-32 checksum is verified, and each
+// source unit index is checked against the unit's def and ref data
+// files: every byte offset in the index must begin a def or ref in
+// the data files, and every key (such as a def path or file) that the
+// data files contain must be in the index and map to the right
+// offsets. The problems with an index are reported in VerifyError.
+//
+// If repair is true, indexes with problems are rebuilt and checked
+// again. An index that then passes the check has Repaired set;
+// otherwise, BuildError describes why it couldn't be repaired.
+func CheckIndexes(store interface{}, c IndexCriteria, repair bool, indexChan chan<- IndexStatus) ([]IndexStatus, error) {
+	var xs []IndexStatus
+	indexChan2 := make(chan IndexStatus)
+	done := make(chan struct{})
+	go func() {
+		var data unitDataCache
+		for sx := range indexChan2 {
+			if err := checkIndex(sx, &data); err != nil {
+				sx.VerifyError = err.Error()
+				if repair {
+					if err := sx.store.BuildIndex(sx.Name, sx.index); err != nil {
+						sx.BuildError = err.Error()
+					} else if err := checkIndex(sx, &data); err != nil {
+						sx.BuildError = fmt.Sprintf("index is still invalid after being rebuilt: %s", err)
+					} else {
+						sx.Stale = false
+						sx.Repaired = true
+					}
+				}
+			}
+			xs = append(xs, sx)
+			if indexChan != nil {
+				indexChan <- sx
+			}
+		}
+		done <- struct{}{}
+	}()
+	err := listIndexes(store, c, indexChan2, nil)
+	close(indexChan2)
+	<-done
+	return xs, err
+}
+
+// checkIndex reads sx's index from its backing file and checks it.
+func checkIndex(sx IndexStatus, data *unitDataCache) (err error) {
+	px, ok := sx.index.(persistedIndex)
+	if !ok {
+		return nil
+	}
+
+	// Malformed index data can make index readers panic (e.g., by
+	// indexing past the end of a slice).
+	defer func() {
+		if r := recover(); r != nil {
+			err = &errIndexCorrupt{name: sx.Name, err: fmt.Errorf("panic: %v", r)}
+		}
+	}()
+
+	if err := sx.store.readIndex(sx.Name, rawIndex{}); err != nil {
+		return err
+	}
+	if err := sx.store.readIndex(sx.Name, px); err != nil {
+		return err
+	}
+	if !sx.index.Ready() {
+		return errors.New("index is not ready after being read")
+	}
+
+	if cx, ok := sx.index.(checkedIndex); ok {
+		if us, ok := sx.store.(*indexedUnitStore); ok {
+			d, err := data.get(us)
+			if err != nil {
+				return fmt.Errorf("reading source unit data files: %s", err)
+			}
+			return cx.check(d)
+		}
+	}
+	return nil
+}
+
+// rawIndex is a persistedIndex that reads its backing file without
+// interpreting the contents. Reading the file to the end verifies the
+// CRC-32 checksum that ends it (or the checksum and length that end
+// the gzip stream, for gzipped index files), which detects truncated
+// and corrupted index files.
+type rawIndex struct{}
+
+func (rawIndex) Write(io.Writer) error { panic("rawIndex can't be written") }
+
+func (rawIndex) Read(r io.Reader) error {
+	_, err := io.Copy(ioutil.Discard, r)
+	return err
+}
+
+// A checkedIndex is a source unit index whose contents can be checked
+// against the unit's data files.
+type checkedIndex interface {
+	check(d *unitData) error
+}
+
+var (
+	_ checkedIndex = (*defPathIndex)(nil)
+	_ checkedIndex = (*defFilesIndex)(nil)
+	_ checkedIndex = (*defParentsIndex)(nil)
+	_ checkedIndex = (*defRefsIndex)(nil)
+	_ checkedIndex = (*refFileIndex)(nil)
+	_ checkedIndex = (*defQueryIndex)(nil)
+	_ checkedIndex = (*defSearchIndex)(nil)
+)
+
+// unitData holds the contents of a source unit's def and ref data
+// files.
+type unitData struct {
+	defs   []*graph.Def
+	defOfs byteOffsets
+
+	refs    []*graph.Ref
+	refFBRs fileByteRanges
+	refOfs  byteOffsets
+}
+
+// checkDefOfs returns an error if any of ofs is not the offset of a
+// def in the def data file.
+func (d *unitData) checkDefOfs(ofs byteOffsets) error {
+	return checkOfs(ofs, d.defOfs, "def")
+}
+
+func checkOfs(ofs, valid byteOffsets, what string) error {
+	set := make(map[int64]struct{}, len(valid))
+	for _, o := range valid {
+		set[o] = struct{}{}
+	}
+	for _, o := range ofs {
+		if _, ok := set[o]; !ok {
+			return fmt.Errorf("byte offset %d does not begin a %s in the %s data file", o, what, what)
+		}
+	}
+	return nil
+}
+
+// unitDataCache holds the data of the most recently read source unit,
+// so that its data files are read only once to check all of its
+// indexes (which are listed consecutively).
+type unitDataCache struct {
+	us   *indexedUnitStore
+	data *unitData
+	err  error
+}
+
+func (c *unitDataCache) get(us *indexedUnitStore) (*unitData, error) {
+	if c.us != us {
+		c.us = us
+		c.data = &unitData{}
+		c.data.defs, c.data.defOfs, c.err = us.fsUnitStore.readDefs()
+		if c.err == nil {
+			c.data.refs, c.data.refFBRs, c.data.refOfs, c.err = us.fsUnitStore.readRefs()
+		}
+	}
+	return c.data, c.err
+}
+
+// countMismatch returns an error describing an index that has a
+// different number of entries than the data files.
+func countMismatch(got, want int, keys string) error {
+	return fmt.Errorf("index has %d %s, but the data files have %d", got, keys, want)
+}
+
+func (x *defPathIndex) check(d *unitData) error {
+	pathOfs := make(map[string]byteOffsets, len(d.defs))
+	for i, def := range d.defs {
+		pathOfs[def.Path] = append(pathOfs[def.Path], d.defOfs[i])
+	}
+	// The def at offset 0 can't be distinguished from an unused slot
+	// in the phtable, so it isn't counted.
+	nonzero := 0
+	for path, ofs := range pathOfs {
+		o, found := x.getByPath(path)
+		if !found {
+			return fmt.Errorf("def path %q is missing from the index", path)
+		}
+		if err := checkOfs(byteOffsets{o}, ofs, "def"); err != nil {
+			return fmt.Errorf("def path %q: %s", path, err)
+		}
+		if o != 0 {
+			nonzero++
+		}
+	}
+	if n := x.phtable.ValueCount(); n != nonzero {
+		return countMismatch(n, nonzero, "defs at nonzero offsets")
+	}
+	return nil
+}
+
+func (x *defFilesIndex) check(d *unitData) error {
+	x.RLock()
+	defer x.RUnlock()
+	want := x.filesToDefOfs(d.defs, d.defOfs)
+	if n := x.phtable.ValueCount(); n != len(want) {
+		return countMismatch(n, len(want), "files and dirs")
+	}
+	for file, wantOfs := range want {
+		ofs, found, err := x.getByPath(file)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("file %q is missing from the index", file)
+		}
+		if err := d.checkDefOfs(ofs); err != nil {
+			return fmt.Errorf("file %q: %s", file, err)
+		}
+		if !reflect.DeepEqual(ofs, wantOfs) {
+			return fmt.Errorf("file %q: index has def offsets %v, want %v", file, ofs, wantOfs)
+		}
+	}
+	return nil
+}
+
+func (x *defParentsIndex) check(d *unitData) error {
+	x.RLock()
+	defer x.RUnlock()
+	want := defParentsOfs(d.defs, d.defOfs)
+	if n := x.phtable.ValueCount(); n != len(want) {
+		return countMismatch(n, len(want), "children and parent entries")
+	}
+	for key, wantOfs := range want {
+		ofs, err := x.get(key)
+		if err != nil {
+			return err
+		}
+		if ofs == nil {
+			return fmt.Errorf("entry %q is missing from the index", key)
+		}
+		if err := d.checkDefOfs(ofs); err != nil {
+			return fmt.Errorf("entry %q: %s", key, err)
+		}
+		if !reflect.DeepEqual(ofs, wantOfs) {
+			return fmt.Errorf("entry %q: index has def offsets %v, want %v", key, ofs, wantOfs)
+		}
+	}
+	return nil
+}
+
+func (x *defRefsIndex) check(d *unitData) error {
+	x.RLock()
+	defer x.RUnlock()
+	want := defRefOfs(d.refs, d.refOfs)
+	if n := x.phtable.ValueCount(); n != len(want) {
+		return countMismatch(n, len(want), "defs")
+	}
+	for def, wantOfs := range want {
+		ofs, found, err := x.getByDef(def)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("def %+v is missing from the index", def)
+		}
+		if err := checkOfs(ofs, d.refOfs, "ref"); err != nil {
+			return fmt.Errorf("def %+v: %s", def, err)
+		}
+		if !reflect.DeepEqual(ofs, wantOfs) {
+			return fmt.Errorf("def %+v: index has ref offsets %v, want %v", def, ofs, wantOfs)
+		}
+	}
+	return nil
+}
+
+func (x *refFileIndex) check(d *unitData) error {
+	if n := x.phtable.ValueCount(); n != len(d.refFBRs) {
+		return countMismatch(n, len(d.refFBRs), "files")
+	}
+	for file, wantBR := range d.refFBRs {
+		br, found, err := x.getByFile(file)
+		if err != nil {
+			return err
+		}
+		if !found || len(br) == 0 {
+			return fmt.Errorf("file %q is missing from the index", file)
+		}
+		if err := checkOfs(byteOffsets{br.start()}, d.refOfs, "ref"); err != nil {
+			return fmt.Errorf("file %q: %s", file, err)
+		}
+		if !reflect.DeepEqual(br, wantBR) {
+			return fmt.Errorf("file %q: index has ref byte ranges %v, want %v", file, br, wantBR)
+		}
+	}
+	return nil
+}
+
+func (x *defQueryIndex) check(d *unitData) error {
+	x.RLock()
+	var ofs byteOffsets
+	for _, v := range x.mt.Values {
+		ofs = append(ofs, v...)
+	}
+	x.RUnlock()
+	if err := d.checkDefOfs(ofs); err != nil {
+		return err
+	}
+	return checkSameAsRebuilt(x, &defQueryIndex{f: x.f}, d)
+}
+
+func (x *defSearchIndex) check(d *unitData) error {
+	x.RLock()
+	ofs := x.t.Ofs
+	x.RUnlock()
+	if err := d.checkDefOfs(ofs); err != nil {
+		return err
+	}
+	return checkSameAsRebuilt(x, &defSearchIndex{docTitles: x.docTitles}, d)
+}
+
+// checkSameAsRebuilt builds fresh (an empty index of the same kind as
+// x) from d and returns an error if its serialized form differs from
+// x's. It may only be used for indexes whose serialized form is
+// determined by the data they are built from.
+func checkSameAsRebuilt(x persistedIndex, fresh interface {
+	persistedIndex
+	defIndexBuilder
+}, d *unitData) error {
+	if err := fresh.Build(d.defs, d.defOfs); err != nil {
+		return err
+	}
+	var got, want bytes.Buffer
+	if err := x.Write(&got); err != nil {
+		return err
+	}
+	if err := fresh.Write(&want); err != nil {
+		return err
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		return errors.New("index contents differ from the contents of an index rebuilt from the def data file")
+	}
+	return nil
+}
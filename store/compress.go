@@ -0,0 +1,255 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+
+	"github.com/kr/fs"
+	"golang.org/x/tools/godoc/vfs"
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// DataCompressor compresses the def and ref data files written by
+// file-backed stores (nil for none). Data files are decompressed when
+// they're read, whichever compressor (if any) wrote them. Like Codec,
+// it should only be set at init time or when you can guarantee that
+// no stores are writing data files.
+var DataCompressor Compressor
+
+// A Compressor compresses and decompresses store data files.
+type Compressor interface {
+	// Magic returns the bytes that compressed data begins with, which
+	// identify the compressor of a data file when it's read.
+	Magic() []byte
+
+	// NewWriter returns a writer that compresses data written to it
+	// and writes it to w. The data is only complete once the writer
+	// is closed.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+
+	// NewReader returns a reader that decompresses the data read
+	// from r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// Compressors maps names (for command-line flags) to compressors.
+var Compressors = map[string]Compressor{
+	"gzip": GzipCompressor{},
+}
+
+// CompressorNames returns the sorted names of Compressors.
+func CompressorNames() []string {
+	names := make([]string, 0, len(Compressors))
+	for name := range Compressors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GzipCompressor compresses data files with gzip.
+type GzipCompressor struct{}
+
+// Magic implements Compressor. It includes the deflate method byte
+// after the gzip ID bytes, which makes it less likely that an
+// uncompressed data file is mistaken for a gzip one.
+func (GzipCompressor) Magic() []byte { return []byte{0x1f, 0x8b, 0x08} }
+
+func (GzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, gzip.BestSpeed)
+}
+
+func (GzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }
+
+// zstdMagic begins zstd-compressed data. There is no zstd compressor
+// yet, but such data files are reported clearly instead of being
+// misread as uncompressed.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// maxMagicLen is the length of the longest compressor magic.
+const maxMagicLen = 4
+
+// sniffCompressor returns the compressor whose magic the data
+// beginning with b starts with, or nil if it is uncompressed.
+func sniffCompressor(b []byte) (Compressor, error) {
+	for _, c := range Compressors {
+		if bytes.HasPrefix(b, c.Magic()) {
+			return c, nil
+		}
+	}
+	if bytes.HasPrefix(b, zstdMagic) {
+		return nil, errors.New("data file is zstd-compressed, which is not supported")
+	}
+	return nil, nil
+}
+
+// decompressedFile is a data file that was decompressed into memory
+// when it was opened. Unlike the underlying file, it is safe for
+// concurrent use via ReadAt.
+type decompressedFile struct{ *bytes.Reader }
+
+func (decompressedFile) Close() error { return nil }
+
+// maybeDecompress returns f (or a mappedFile, if f is mapped into
+// memory), or (if f is compressed) an in-memory decompressedFile with
+// f's decompressed contents, in which case f is closed. Byte offsets
+// in data files refer to their decompressed contents, so compressed
+// files must be decompressed in full to be read at an offset.
+func maybeDecompress(f vfs.ReadSeekCloser) (vfs.ReadSeekCloser, error) {
+	magic := make([]byte, maxMagicLen)
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		f.Close()
+		return nil, err
+	}
+	c, err := sniffCompressor(magic[:n])
+	if err == nil {
+		_, err = f.Seek(0, 0)
+	}
+	if err != nil || c == nil {
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return maybeMap(f), nil
+	}
+
+	defer f.Close()
+	r, err := c.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return decompressedFile{bytes.NewReader(data)}, nil
+}
+
+// openDataFile opens the named data file in fs, decompressing it if
+// needed.
+func openDataFile(fs rwvfs.FileSystem, name string) (vfs.ReadSeekCloser, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return maybeDecompress(f)
+}
+
+// createDataFile creates the named data file in fs. Data written to
+// it is compressed with DataCompressor (if set).
+func createDataFile(fs rwvfs.FileSystem, name string) (io.WriteCloser, error) {
+	f, err := fs.Create(name)
+	if err != nil || DataCompressor == nil {
+		return f, err
+	}
+	cw, err := DataCompressor.NewWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &compressedFile{WriteCloser: cw, f: f}, nil
+}
+
+// compressedFile is a data file being written through a compressor.
+type compressedFile struct {
+	io.WriteCloser // the compressor
+	f              io.WriteCloser
+}
+
+func (f *compressedFile) Close() error {
+	err := f.WriteCloser.Close()
+	if err2 := f.f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// CompactStats describes the data files rewritten by CompactDataFiles.
+type CompactStats struct {
+	Files int
+
+	// Before and After are the total sizes (in bytes) of the data
+	// files before and after they were rewritten.
+	Before, After int64
+}
+
+// CompactDataFiles rewrites all def, ref, and ann data files in fs
+// with the compressor c (or uncompressed, if c is nil). Indexes refer
+// to byte offsets in the data files' decompressed contents, so they
+// remain valid. Data files that DedupeCommits moved to blobs are left as is.
+func CompactDataFiles(wfs rwvfs.WalkableFileSystem, c Compressor) (*CompactStats, error) {
+	var stats CompactStats
+	w := fs.WalkFS(".", wfs)
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			return nil, err
+		}
+		fi := w.Stat()
+		if name := path.Base(w.Path()); !fi.Mode().IsRegular() || (name != unitDefsFilename && name != unitRefsFilename && name != unitAnnsFilename) {
+			continue
+		}
+		if _, isPointer, err := readBlobPointer(wfs, w.Path(), fi); err != nil {
+			return nil, err
+		} else if isPointer {
+			continue // the blob is shared, and its SHA-256 names its contents
+		}
+		after, err := compactDataFile(wfs, w.Path(), c)
+		if err != nil {
+			return nil, fmt.Errorf("compacting %s: %s", w.Path(), err)
+		}
+		stats.Files++
+		stats.Before += fi.Size()
+		stats.After += after
+	}
+	return &stats, nil
+}
+
+// compactDataFile rewrites the named data file with the compressor c
+// and returns its new size.
+func compactDataFile(fs rwvfs.FileSystem, name string, c Compressor) (int64, error) {
+	f, err := openDataFile(fs, name)
+	if err != nil {
+		return 0, err
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	var buf bytes.Buffer
+	if c == nil {
+		buf.Write(data)
+	} else {
+		cw, err := c.NewWriter(&buf)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := cw.Write(data); err != nil {
+			return 0, err
+		}
+		if err := cw.Close(); err != nil {
+			return 0, err
+		}
+	}
+
+	// The file is only replaced if the new contents are written in
+	// full (see replacingOSFS for the local filesystem backend).
+	out, err := fs.Create(name)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := out.Write(buf.Bytes()); err != nil {
+		out.Close()
+		return 0, err
+	}
+	return int64(buf.Len()), out.Close()
+}
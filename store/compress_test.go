@@ -0,0 +1,93 @@
+package store
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestDataCompressor(t *testing.T) {
+	defer func(orig Compressor) { DataCompressor = orig }(DataCompressor)
+
+	data := graph.Output{
+		Defs: []*graph.Def{
+			{DefKey: graph.DefKey{Path: "p"}, Name: "p", File: "f"},
+			{DefKey: graph.DefKey{Path: "q"}, Name: "q", File: "f"},
+		},
+		Refs: []*graph.Ref{
+			{DefPath: "p", File: "f", Start: 1, End: 2},
+			{DefPath: "q", File: "g", Start: 3, End: 4},
+		},
+	}
+
+	for _, indexed := range []bool{false, true} {
+		DataCompressor = GzipCompressor{}
+		m := map[string]string{}
+		fs := rwvfs.Map(m)
+		var us UnitStoreImporter
+		if indexed {
+			us = newIndexedUnitStore(fs, "test")
+		} else {
+			us = &fsUnitStore{fs: fs}
+		}
+		if err := us.Import(data); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix([]byte(m[unitDefsFilename]), GzipCompressor{}.Magic()) {
+			t.Fatalf("indexed=%v: def data file is not gzip-compressed", indexed)
+		}
+
+		// Data files are read whatever their compression, before and
+		// after they're compacted.
+		DataCompressor = nil
+		check := func(label string) {
+			defs, err := us.Defs(ByDefPath("q"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(defs) != 1 || defs[0].Name != "q" {
+				t.Errorf("indexed=%v, %s: got defs %v, want def q", indexed, label, defs)
+			}
+			refs, err := us.Refs(ByFiles("g"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(refs) != 1 || refs[0].Start != 3 {
+				t.Errorf("indexed=%v, %s: got refs %v, want ref in g", indexed, label, refs)
+			}
+		}
+		check("gzip")
+
+		gzipped := m[unitDefsFilename]
+		stats, err := CompactDataFiles(rwvfs.Walkable(fs), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Files != 2 {
+			t.Errorf("indexed=%v: got %d files compacted, want 2", indexed, stats.Files)
+		}
+		if strings.HasPrefix(m[unitDefsFilename], gzipped[:3]) {
+			t.Errorf("indexed=%v: def data file is still compressed", indexed)
+		}
+		check("uncompressed")
+
+		if _, err := CompactDataFiles(rwvfs.Walkable(fs), GzipCompressor{}); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m[unitDefsFilename], gzipped) {
+			t.Errorf("indexed=%v: recompressed def data file differs", indexed)
+		}
+		check("recompressed")
+	}
+}
+
+func TestDataFile_zstd(t *testing.T) {
+	fs := rwvfs.Map(map[string]string{unitDefsFilename: string(zstdMagic) + "x"})
+	if _, err := (&fsUnitStore{fs: fs}).Defs(); err == nil || !strings.Contains(err.Error(), "zstd") {
+		t.Errorf("got error %v, want zstd error", err)
+	}
+}
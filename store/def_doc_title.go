@@ -0,0 +1,93 @@
+package store
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// htmlTag matches HTML tags, which are stripped from HTML docs to
+// find their titles.
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// DefDocTitle returns the first sentence of def's documentation (its
+// "title"), as plain text, or "" if def has no docs. The plain-text
+// doc is used if there is one; otherwise the first doc is used, with
+// any HTML tags stripped.
+func DefDocTitle(def *graph.Def) string {
+	if len(def.Docs) == 0 {
+		return ""
+	}
+	doc := def.Docs[0]
+	for _, d := range def.Docs {
+		if d.Format == "text/plain" {
+			doc = d
+			break
+		}
+	}
+
+	text := doc.Data
+	if doc.Format == "text/html" {
+		text = html.UnescapeString(htmlTag.ReplaceAllString(text, " "))
+	}
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	if i := strings.Index(text, "\n\n"); i != -1 {
+		text = text[:i]
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	if i := strings.Index(text, ". "); i != -1 {
+		text = text[:i+1]
+	}
+	return text
+}
+
+// ByDocTitleFilter is implemented by filters that restrict their
+// selection to defs whose doc titles (see DefDocTitle) match a query.
+type ByDocTitleFilter interface {
+	ByDocTitle() string
+}
+
+// ByDocTitle returns a filter that selects non-local defs whose doc
+// titles (see DefDocTitle) match q: each word in q must be a prefix of
+// a word in the title, in any order and case-insensitively. If q has
+// no words (e.g., it's only punctuation), no defs match. It panics if
+// q is empty.
+func ByDocTitle(q string) interface {
+	DefFilter
+	ByDocTitleFilter
+} {
+	if q == "" {
+		panic("ByDocTitle: empty")
+	}
+	return byDocTitleFilter{q: q, words: searchWords(q)}
+}
+
+type byDocTitleFilter struct {
+	q     string
+	words []string
+}
+
+func (f byDocTitleFilter) String() string     { return fmt.Sprintf("ByDocTitle(%q)", f.q) }
+func (f byDocTitleFilter) ByDocTitle() string { return f.q }
+func (f byDocTitleFilter) SelectDef(def *graph.Def) bool {
+	if def.Local || len(f.words) == 0 {
+		return false
+	}
+	title := searchWords(DefDocTitle(def))
+	for _, qw := range f.words {
+		var found bool
+		for _, tw := range title {
+			if strings.HasPrefix(tw, qw) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestDefDocTitle(t *testing.T) {
+	tests := []struct {
+		docs []*graph.DefDoc
+		want string
+	}{
+		{nil, ""},
+		{[]*graph.DefDoc{{Format: "text/plain", Data: "Open opens the\nstore. It returns an error."}}, "Open opens the store."},
+		{[]*graph.DefDoc{{Format: "text/plain", Data: "No period\n\nSecond paragraph."}}, "No period"},
+		{[]*graph.DefDoc{{Format: "text/html", Data: "<p>Parses &amp; <code>checks</code> input.</p>"}}, "Parses & checks input."},
+		{
+			[]*graph.DefDoc{
+				{Format: "text/html", Data: "<p>HTML doc.</p>"},
+				{Format: "text/plain", Data: "Plain doc."},
+			},
+			"Plain doc.",
+		},
+	}
+	for _, test := range tests {
+		if got := DefDocTitle(&graph.Def{Docs: test.docs}); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.docs, got, test.want)
+		}
+	}
+}
+
+func TestByDocTitle(t *testing.T) {
+	doc := func(s string) []*graph.DefDoc { return []*graph.DefDoc{{Format: "text/plain", Data: s}} }
+	defs := []*graph.Def{
+		{DefKey: graph.DefKey{Path: "Open"}, Name: "Open", Docs: doc("Open opens the repository store at dir. Unlike Create, it fails if the store is missing.")},
+		{DefKey: graph.DefKey{Path: "Create"}, Name: "Create", Docs: doc("Create creates a new store at dir.")},
+		{DefKey: graph.DefKey{Path: "Parse"}, Name: "Parse", Docs: doc("Parse parses a store path.")},
+		{DefKey: graph.DefKey{Path: "Undocumented"}, Name: "Undocumented"},
+		{DefKey: graph.DefKey{Path: "Open/x"}, Name: "x", Local: true, Docs: doc("x is the opened store.")},
+	}
+
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"store", []string{"Create", "Open", "Parse"}},
+		{"open store", []string{"Open"}},
+		{"STORE at", []string{"Create", "Open"}}, // "at" is too short for trigrams
+		{"fails", nil},                           // not in the first sentence
+		{"Undocumented", nil},
+	}
+
+	for _, indexed := range []bool{false, true} {
+		fs := rwvfs.Map(map[string]string{})
+		var us UnitStoreImporter
+		if indexed {
+			us = newIndexedUnitStore(fs, "test")
+		} else {
+			us = &fsUnitStore{fs: fs}
+		}
+		if err := us.Import(graph.Output{Defs: defs}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, test := range tests {
+			got, err := us.Defs(ByDocTitle(test.q))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, def := range got {
+				names = append(names, def.Name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, test.want) {
+				t.Errorf("indexed=%v: %q: got %v, want %v", indexed, test.q, names, test.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/alecthomas/binary"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store/phtable"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// defFileRefsIndex makes it fast to find the refs (in any source unit
+// in a tree) to defs that are defined in a file, such as to determine
+// what might break if the file is changed. Only refs to defs in the
+// same tree are indexed.
+type defFileRefsIndex struct {
+	phtable *phtable.CHD
+	ready   bool
+	sync.RWMutex
+}
+
+var _ interface {
+	Index
+	persistedIndex
+	refTreeIndex
+	unitDataIndexBuilder
+} = (*defFileRefsIndex)(nil)
+
+var c_defFileRefsIndex_getByFile = &counter{count: new(int64)}
+
+func (x *defFileRefsIndex) String() string { return fmt.Sprintf("defFileRefsIndex(ready=%v)", x.ready) }
+
+// unitRefOffsets holds the byte offsets of refs in a source unit's
+// ref data file.
+type unitRefOffsets struct {
+	Unit unit.ID2
+	Ofs  byteOffsets
+}
+
+// getByFile returns the refs to defs defined in file.
+func (x *defFileRefsIndex) getByFile(file string) ([]unitRefOffsets, bool, error) {
+	vlog.Printf("defFileRefsIndex.getByFile(%s)", file)
+	c_defFileRefsIndex_getByFile.increment()
+
+	if x.phtable == nil {
+		panic("phtable not built/read")
+	}
+	v := x.phtable.Get([]byte(file))
+	if v == nil {
+		return nil, false, nil
+	}
+
+	var uofs []unitRefOffsets
+	if err := binary.Unmarshal(v, &uofs); err != nil {
+		return nil, true, err
+	}
+	return uofs, true, nil
+}
+
+// Covers implements Index.
+func (x *defFileRefsIndex) Covers(filters interface{}) int {
+	cov := 0
+	for _, f := range storeFilters(filters) {
+		if _, ok := f.(ByRefDefFilesFilter); ok {
+			cov++
+		}
+	}
+	return cov
+}
+
+// Refs implements refTreeIndex.
+func (x *defFileRefsIndex) Refs(fs ...RefFilter) (map[unit.ID2]byteOffsets, error) {
+	x.RLock()
+	defer x.RUnlock()
+	for _, f := range fs {
+		if ff, ok := f.(ByRefDefFilesFilter); ok {
+			uofs := map[unit.ID2]byteOffsets{}
+			seen := map[string]struct{}{}
+			for _, file := range ff.ByRefDefFiles() {
+				if _, dup := seen[file]; dup {
+					continue
+				}
+				seen[file] = struct{}{}
+				v, _, err := x.getByFile(file)
+				if err != nil {
+					return nil, err
+				}
+				for _, uo := range v {
+					uofs[uo.Unit] = append(uofs[uo.Unit], uo.Ofs...)
+				}
+			}
+			vlog.Printf("defFileRefsIndex(%v): Found refs in %d units using index.", ff.ByRefDefFiles(), len(uofs))
+			return uofs, nil
+		}
+	}
+	return nil, nil
+}
+
+// Build implements unitDataIndexBuilder.
+func (x *defFileRefsIndex) Build(units []*unit.SourceUnit, readDefs func(unit.ID2) ([]*graph.Def, error), readRefs func(unit.ID2) ([]*graph.Ref, byteOffsets, error)) error {
+	x.Lock()
+	defer x.Unlock()
+	vlog.Printf("defFileRefsIndex: building def file->refs index (%d units)...", len(units))
+
+	// Sort units so that the index is deterministic.
+	unitIDs := make([]unit.ID2, len(units))
+	for i, u := range units {
+		unitIDs[i] = u.ID2()
+	}
+	sort.Sort(unitID2s(unitIDs))
+
+	defFiles := map[graph.RefDefKey]string{}
+	for _, u := range unitIDs {
+		defs, err := readDefs(u)
+		if err != nil {
+			return err
+		}
+		for _, def := range defs {
+			if def.File != "" {
+				defFiles[graph.RefDefKey{DefUnitType: u.Type, DefUnit: u.Name, DefPath: def.Path}] = path.Clean(def.File)
+			}
+		}
+	}
+
+	fileToRefs := map[string][]unitRefOffsets{}
+	for _, u := range unitIDs {
+		refs, refOfs, err := readRefs(u)
+		if err != nil {
+			return err
+		}
+		for i, ref := range refs {
+			if ref.DefRepo != "" {
+				continue // refs to defs in other repos aren't indexed
+			}
+			def := ref.RefDefKey()
+			if def.DefUnitType == "" {
+				def.DefUnitType = u.Type
+			}
+			if def.DefUnit == "" {
+				def.DefUnit = u.Name
+			}
+			file, present := defFiles[def]
+			if !present {
+				continue
+			}
+			uofs := fileToRefs[file]
+			if len(uofs) == 0 || uofs[len(uofs)-1].Unit != u {
+				uofs = append(uofs, unitRefOffsets{Unit: u})
+			}
+			uofs[len(uofs)-1].Ofs = append(uofs[len(uofs)-1].Ofs, refOfs[i])
+			fileToRefs[file] = uofs
+		}
+	}
+
+	vlog.Printf("defFileRefsIndex: adding %d index phtable keys...", len(fileToRefs))
+	b := phtable.Builder(len(fileToRefs))
+	for file, uofs := range fileToRefs {
+		v, err := binary.Marshal(uofs)
+		if err != nil {
+			return err
+		}
+		b.Add([]byte(file), v)
+	}
+	h, err := b.Build()
+	if err != nil {
+		return err
+	}
+	x.phtable = h
+	x.ready = true
+	vlog.Printf("defFileRefsIndex: done building index.")
+	return nil
+}
+
+// Write implements persistedIndex.
+func (x *defFileRefsIndex) Write(w io.Writer) error {
+	x.RLock()
+	defer x.RUnlock()
+	if x.phtable == nil {
+		panic("no phtable to write")
+	}
+	return x.phtable.Write(w)
+}
+
+// Read implements persistedIndex.
+func (x *defFileRefsIndex) Read(r io.Reader) error {
+	phtable, err := phtable.Read(r)
+	x.Lock()
+	defer x.Unlock()
+	x.phtable = phtable
+	x.ready = (err == nil)
+	return err
+}
+
+// Map implements mappedIndex.
+func (x *defFileRefsIndex) Map(m *phtable.Mapping) error {
+	phtable, err := phtable.ReadMapping(m, false)
+	x.Lock()
+	defer x.Unlock()
+	x.phtable = phtable
+	x.ready = (err == nil)
+	return err
+}
+
+// Ready implements persistedIndex.
+func (x *defFileRefsIndex) Ready() bool {
+	x.RLock()
+	defer x.RUnlock()
+	return x.ready
+}
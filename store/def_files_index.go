@@ -111,12 +111,7 @@ func (x *defFilesIndex) Build(defs []*graph.Def, ofs byteOffsets) error {
 	x.Lock()
 	defer x.Unlock()
 	vlog.Printf("defFilesIndex: building index...")
-	f2ofs := make(filesToDefOfs, len(defs)/50)
-	for i, def := range defs {
-		if len(f2ofs[def.File]) < x.perFile && DefFilters(x.filters).SelectDef(def) {
-			f2ofs.add(def.File, ofs[i], x.perFile)
-		}
-	}
+	f2ofs := x.filesToDefOfs(defs, ofs)
 	b := phtable.Builder(len(f2ofs))
 	for file, defOfs := range f2ofs {
 		ob, err := binary.Marshal(defOfs)
@@ -135,6 +130,18 @@ func (x *defFilesIndex) Build(defs []*graph.Def, ofs byteOffsets) error {
 	return nil
 }
 
+// filesToDefOfs returns the def offsets (of defs) that the index
+// holds for each file and dir.
+func (x *defFilesIndex) filesToDefOfs(defs []*graph.Def, ofs byteOffsets) filesToDefOfs {
+	f2ofs := make(filesToDefOfs, len(defs)/50)
+	for i, def := range defs {
+		if len(f2ofs[def.File]) < x.perFile && DefFilters(x.filters).SelectDef(def) {
+			f2ofs.add(def.File, ofs[i], x.perFile)
+		}
+	}
+	return f2ofs
+}
+
 // filesToDefOfs is a helper type used by defFilesIndex.Build that
 // adds parent dirs of each file to the mapping as well.
 //
@@ -177,6 +184,16 @@ func (x *defFilesIndex) Read(r io.Reader) error {
 	return err
 }
 
+// Map implements mappedIndex.
+func (x *defFilesIndex) Map(m *phtable.Mapping) error {
+	phtable, err := phtable.ReadMapping(m, false)
+	x.Lock()
+	defer x.Unlock()
+	x.phtable = phtable
+	x.ready = (err == nil)
+	return err
+}
+
 // Ready implements persistedIndex.
 func (x *defFilesIndex) Ready() bool {
 	x.RLock()
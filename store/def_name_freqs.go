@@ -0,0 +1,90 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// DefNameFreqsFilename is the name of the file (in a commit's build
+// data dir) that holds the def name frequency table for the commit.
+const DefNameFreqsFilename = "def-name-freqs.json"
+
+// DefNameFreqs is a corpus-frequency table of def names. It maps
+// each lowercased def name to the number of defs with that name.
+//
+// It is used to rank name completions and suggestions: names shared
+// by many defs (such as "String" or "init") are less specific than
+// rare names and are ranked after them.
+type DefNameFreqs map[string]int
+
+// Add counts the names of defs.
+func (f DefNameFreqs) Add(defs []*graph.Def) {
+	for _, def := range defs {
+		if def.Name != "" {
+			f[strings.ToLower(def.Name)]++
+		}
+	}
+}
+
+// Freq returns the number of defs named name (case-insensitively).
+func (f DefNameFreqs) Freq(name string) int {
+	return f[strings.ToLower(name)]
+}
+
+// Rank sorts names in place so that rarer names come first. Names
+// with the same frequency keep their original relative order. A nil
+// DefNameFreqs leaves names unchanged.
+func (f DefNameFreqs) Rank(names []string) {
+	if f == nil {
+		return
+	}
+	sort.Stable(namesByFreq{names, f})
+}
+
+type namesByFreq struct {
+	names []string
+	f     DefNameFreqs
+}
+
+func (v namesByFreq) Len() int           { return len(v.names) }
+func (v namesByFreq) Swap(i, j int)      { v.names[i], v.names[j] = v.names[j], v.names[i] }
+func (v namesByFreq) Less(i, j int) bool { return v.f.Freq(v.names[i]) < v.f.Freq(v.names[j]) }
+
+// ReadDefNameFreqs reads the def name frequency table from fs. If
+// none exists, it returns nil and no error.
+func ReadDefNameFreqs(fs vfs.FileSystem) (DefNameFreqs, error) {
+	f, err := fs.Open(DefNameFreqsFilename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var freqs DefNameFreqs
+	if err := json.NewDecoder(f).Decode(&freqs); err != nil {
+		return nil, err
+	}
+	return freqs, nil
+}
+
+// WriteDefNameFreqs writes the def name frequency table to fs,
+// replacing any existing table.
+func WriteDefNameFreqs(fs rwvfs.FileSystem, freqs DefNameFreqs) (err error) {
+	f, err := fs.Create(DefNameFreqsFilename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := f.Close(); err2 != nil && err == nil {
+			err = err2
+		}
+	}()
+	return json.NewEncoder(f).Encode(freqs)
+}
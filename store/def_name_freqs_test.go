@@ -0,0 +1,39 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestDefNameFreqs(t *testing.T) {
+	freqs := DefNameFreqs{}
+	freqs.Add([]*graph.Def{{Name: "String"}, {Name: "string"}, {Name: "Stringer"}, {Name: "StringSlice"}, {Name: "StringSlice"}, {Name: ""}})
+
+	if got, want := freqs.Freq("STRING"), 2; got != want {
+		t.Errorf("got freq %d, want %d", got, want)
+	}
+
+	names := []string{"String", "StringSlice", "Stringer", "Unknown"}
+	freqs.Rank(names)
+	if want := []string{"Unknown", "Stringer", "String", "StringSlice"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got ranked names %v, want %v", names, want)
+	}
+
+	fs := rwvfs.Map(map[string]string{})
+	if freqs2, err := ReadDefNameFreqs(fs); err != nil || freqs2 != nil {
+		t.Errorf("got (%v, %v) reading nonexistent freqs, want (nil, nil)", freqs2, err)
+	}
+	if err := WriteDefNameFreqs(fs, freqs); err != nil {
+		t.Fatal(err)
+	}
+	freqs2, err := ReadDefNameFreqs(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(freqs2, freqs) {
+		t.Errorf("got freqs %v after round trip, want %v", freqs2, freqs)
+	}
+}
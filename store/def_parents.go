@@ -0,0 +1,152 @@
+package store
+
+import (
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// ListChildren returns the children of the def with the given key in
+// its source unit's tree of defs (e.g., the methods and fields of a
+// type, or the top-level defs of a package), in arbitrary order. If
+// def.Path is empty, it returns the unit's top-level defs. The key's
+// Repo, CommitID, UnitType, and Unit, if set, restrict the query to
+// that repo, commit, and source unit.
+//
+// A def's parent is the def whose path is the longest proper prefix of
+// its path (at a "/" boundary), or, if there's no such def, the
+// innermost def in the same file whose byte range contains it.
+func ListChildren(s UnitStore, def graph.DefKey) ([]*graph.Def, error) {
+	return s.Defs(append(defKeyScope(def), ByDefParent(def.Path))...)
+}
+
+// GetParent returns the parent of the def with the given key in its
+// source unit's tree of defs (see ListChildren), or nil if it's a
+// top-level def. If the key doesn't identify a single source unit, the
+// parent in any of the matching units may be returned.
+func GetParent(s UnitStore, def graph.DefKey) (*graph.Def, error) {
+	defs, err := s.Defs(append(defKeyScope(def), ByDefChild(def.Path))...)
+	if err != nil || len(defs) == 0 {
+		return nil, err
+	}
+	return defs[0], nil
+}
+
+// defKeyScope returns filters that restrict a query to the repo,
+// commit, and source unit in def (those that are set).
+func defKeyScope(def graph.DefKey) []DefFilter {
+	var fs []DefFilter
+	if def.Repo != "" {
+		fs = append(fs, ByRepos(def.Repo))
+	}
+	if def.CommitID != "" {
+		fs = append(fs, ByCommitIDs(def.CommitID))
+	}
+	if def.UnitType != "" || def.Unit != "" {
+		fs = append(fs, ByUnits(unit.ID2{Type: def.UnitType, Name: def.Unit}))
+	}
+	return fs
+}
+
+// defParents returns a map from the path of each of a source unit's
+// defs to the path of its parent (as described in ListChildren).
+// Top-level defs aren't in the map.
+func defParents(defs []*graph.Def) map[string]string {
+	byPath := make(map[string]*graph.Def, len(defs))
+	for _, def := range defs {
+		byPath[def.Path] = def
+	}
+
+	parents := make(map[string]string, len(defs))
+	isOrphan := map[*graph.Def]bool{}
+	for _, def := range defs {
+		if parent := pathParent(def.Path, byPath); parent != "" {
+			parents[def.Path] = parent
+		} else {
+			isOrphan[def] = true
+		}
+	}
+
+	// Find the innermost enclosing def of each def without a path
+	// parent. Defs that enclose each other are sorted outermost
+	// first, so the stack holds the enclosing defs of each def.
+	bySpan := make([]*graph.Def, 0, len(defs))
+	for _, def := range defs {
+		if def.DefEnd > def.DefStart {
+			bySpan = append(bySpan, def)
+		}
+	}
+	sort.Sort(defsBySpan(bySpan))
+	var stack []*graph.Def
+	for _, def := range bySpan {
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if top.File == def.File && top.DefEnd >= def.DefEnd && (top.DefStart < def.DefStart || top.DefEnd > def.DefEnd) {
+				break
+			}
+			stack = stack[:len(stack)-1]
+		}
+		// A def can't be the child of one of its own descendants.
+		if isOrphan[def] && len(stack) > 0 {
+			if parent := stack[len(stack)-1]; !isDefAncestor(def.Path, parent.Path, parents) {
+				parents[def.Path] = parent.Path
+			}
+		}
+		stack = append(stack, def)
+	}
+	return parents
+}
+
+// pathParent returns the path of the def in byPath whose path is the
+// longest proper prefix of defPath at a "/" boundary, or "" if there
+// is none.
+func pathParent(defPath string, byPath map[string]*graph.Def) string {
+	for p := defPath; ; {
+		i := strings.LastIndex(p, "/")
+		if i <= 0 {
+			return ""
+		}
+		p = p[:i]
+		if _, present := byPath[p]; present {
+			return p
+		}
+	}
+}
+
+// isDefAncestor returns whether the def at path a is an ancestor of
+// (or is) the def at path d, given the parents found so far.
+func isDefAncestor(a, d string, parents map[string]string) bool {
+	for seen := 0; seen <= len(parents); seen++ {
+		if d == a {
+			return true
+		}
+		parent, present := parents[d]
+		if !present {
+			return false
+		}
+		d = parent
+	}
+	return true // cycle; be safe
+}
+
+// defsBySpan sorts defs by file and then by start offset, with defs
+// that enclose others (at the same start offset) first.
+type defsBySpan []*graph.Def
+
+func (v defsBySpan) Len() int      { return len(v) }
+func (v defsBySpan) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v defsBySpan) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	if a.DefStart != b.DefStart {
+		return a.DefStart < b.DefStart
+	}
+	if a.DefEnd != b.DefEnd {
+		return a.DefEnd > b.DefEnd
+	}
+	return a.Path < b.Path
+}
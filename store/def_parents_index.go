@@ -0,0 +1,170 @@
+package store
+
+import (
+	"io"
+	"sync"
+
+	"github.com/alecthomas/binary"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store/phtable"
+)
+
+// defParentsIndex makes it fast to find the children and parent of a
+// def in a source unit's tree of defs (see defParents).
+type defParentsIndex struct {
+	phtable *phtable.CHD
+	ready   bool
+	sync.RWMutex
+}
+
+var _ interface {
+	Index
+	persistedIndex
+	defIndexBuilder
+	defIndex
+} = (*defParentsIndex)(nil)
+
+var c_defParentsIndex_get = &counter{count: new(int64)}
+
+func (x *defParentsIndex) String() string { return "defParentsIndex" }
+
+// The index's keys are a def path prefixed with one of these, and its
+// values are the byte offsets of the def's children or parent.
+const (
+	defChildrenKeyPrefix = "c\x00"
+	defParentKeyPrefix   = "p\x00"
+)
+
+func (x *defParentsIndex) get(key string) (byteOffsets, error) {
+	c_defParentsIndex_get.increment()
+	if x.phtable == nil {
+		panic("phtable not built/read")
+	}
+	v := x.phtable.Get([]byte(key))
+	if v == nil {
+		return nil, nil
+	}
+	var ofs byteOffsets
+	if err := binary.Unmarshal(v, &ofs); err != nil {
+		return nil, err
+	}
+	return ofs, nil
+}
+
+// Covers implements defIndex.
+func (x *defParentsIndex) Covers(filters interface{}) int {
+	cov := 0
+	for _, f := range storeFilters(filters) {
+		if _, ok := f.(*byDefTreeFilter); ok {
+			cov++
+		}
+	}
+	return cov
+}
+
+// Defs implements defIndex.
+func (x *defParentsIndex) Defs(fs ...DefFilter) (byteOffsets, error) {
+	x.RLock()
+	defer x.RUnlock()
+	for _, f := range fs {
+		if tf, ok := f.(*byDefTreeFilter); ok {
+			prefix := defParentKeyPrefix
+			if tf.children {
+				prefix = defChildrenKeyPrefix
+			}
+			ofs, err := x.get(prefix + tf.path)
+			if err != nil {
+				return nil, err
+			}
+			vlog.Printf("defParentsIndex(%v): Found %d def offsets using index.", fs, len(ofs))
+			return ofs, nil
+		}
+	}
+	return nil, nil
+}
+
+// Build implements defIndexBuilder.
+func (x *defParentsIndex) Build(defs []*graph.Def, ofs byteOffsets) error {
+	x.Lock()
+	defer x.Unlock()
+	vlog.Printf("defParentsIndex: building index...")
+	m := defParentsOfs(defs, ofs)
+	b := phtable.Builder(len(m))
+	for key, ofs := range m {
+		ob, err := binary.Marshal(ofs)
+		if err != nil {
+			return err
+		}
+		b.Add([]byte(key), ob)
+	}
+	h, err := b.Build()
+	if err != nil {
+		return err
+	}
+	// The index's results aren't filtered again (see
+	// withoutDefTreeFilters), so lookups of defs that aren't in the
+	// index must find nothing instead of another key's value.
+	h.StoreKeys = true
+	x.phtable = h
+	x.ready = true
+	vlog.Printf("defParentsIndex: done building index.")
+	return nil
+}
+
+// defParentsOfs returns the entries of a defParentsIndex of defs
+// (whose byte offsets are ofs).
+func defParentsOfs(defs []*graph.Def, ofs byteOffsets) map[string]byteOffsets {
+	pathOfs := make(map[string]int64, len(defs))
+	for i, def := range defs {
+		pathOfs[def.Path] = ofs[i]
+	}
+	m := map[string]byteOffsets{}
+	parents := defParents(defs)
+	for i, def := range defs {
+		parent, present := parents[def.Path]
+		if present {
+			m[defParentKeyPrefix+def.Path] = byteOffsets{pathOfs[parent]}
+		}
+		k := defChildrenKeyPrefix + parent // top-level defs are the children of ""
+		m[k] = append(m[k], ofs[i])
+	}
+	return m
+}
+
+// Write implements persistedIndex.
+func (x *defParentsIndex) Write(w io.Writer) error {
+	x.RLock()
+	defer x.RUnlock()
+	if x.phtable == nil {
+		panic("no phtable to write")
+	}
+	return x.phtable.Write(w)
+}
+
+// Read implements persistedIndex.
+func (x *defParentsIndex) Read(r io.Reader) error {
+	phtable, err := phtable.Read(r)
+	x.Lock()
+	defer x.Unlock()
+	x.phtable = phtable
+	x.ready = (err == nil)
+	return err
+}
+
+// Map implements mappedIndex.
+func (x *defParentsIndex) Map(m *phtable.Mapping) error {
+	phtable, err := phtable.ReadMapping(m, false)
+	x.Lock()
+	defer x.Unlock()
+	x.phtable = phtable
+	x.ready = (err == nil)
+	return err
+}
+
+// Ready implements persistedIndex.
+func (x *defParentsIndex) Ready() bool {
+	x.RLock()
+	defer x.RUnlock()
+	return x.ready
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// defParentsTestDefs are the defs of a Go-like package "p" with type T
+// (whose method M has a local var v), and func F, whose local def's
+// path isn't nested under F's.
+var defParentsTestDefs = []*graph.Def{
+	{DefKey: graph.DefKey{Path: "p"}, Name: "p", File: "f.go", DefStart: 0, DefEnd: 100},
+	{DefKey: graph.DefKey{Path: "p/T"}, Name: "T", File: "f.go", DefStart: 10, DefEnd: 20},
+	{DefKey: graph.DefKey{Path: "p/T/M"}, Name: "M", File: "f.go", DefStart: 30, DefEnd: 50},
+	{DefKey: graph.DefKey{Path: "p/T/M/v"}, Name: "v", File: "f.go", DefStart: 40, DefEnd: 41},
+	{DefKey: graph.DefKey{Path: "F"}, Name: "F", File: "g.go", DefStart: 0, DefEnd: 50},
+	{DefKey: graph.DefKey{Path: "F$local"}, Name: "local", File: "g.go", DefStart: 10, DefEnd: 11},
+	{DefKey: graph.DefKey{Path: "G"}, Name: "G", File: "g.go", DefStart: 60, DefEnd: 70},
+}
+
+func TestDefParents(t *testing.T) {
+	want := map[string]string{
+		"p/T":     "p",
+		"p/T/M":   "p/T",
+		"p/T/M/v": "p/T/M",
+		"F$local": "F",
+	}
+	if got := defParents(defParentsTestDefs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got parents %v, want %v", got, want)
+	}
+
+	// A def isn't the parent of a def that it's nested under (by
+	// path), even if it's inside the other def's byte range.
+	defs := []*graph.Def{
+		{DefKey: graph.DefKey{Path: "a"}, File: "f", DefStart: 10, DefEnd: 20},
+		{DefKey: graph.DefKey{Path: "a/b"}, File: "f", DefStart: 0, DefEnd: 30},
+	}
+	if got, want := defParents(defs), map[string]string{"a/b": "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got parents %v, want %v", got, want)
+	}
+}
+
+func TestListChildren(t *testing.T) {
+	defer func(orig bool) { useIndexedStore = orig }(useIndexedStore)
+
+	key := func(path string) graph.DefKey {
+		return graph.DefKey{CommitID: "c", UnitType: "t", Unit: "u", Path: path}
+	}
+	paths := func(defs []*graph.Def) []string {
+		paths := make([]string, len(defs))
+		for i, def := range defs {
+			paths[i] = def.Path
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	stores := map[string]func() RepoStoreImporter{
+		"fs": func() RepoStoreImporter {
+			useIndexedStore = false
+			return NewFSRepoStore(rwvfs.Map(map[string]string{}))
+		},
+		"indexed": func() RepoStoreImporter {
+			useIndexedStore = true
+			return NewFSRepoStore(rwvfs.Map(map[string]string{}))
+		},
+		"memory": func() RepoStoreImporter { return newMemoryRepoStore() },
+	}
+	for label, newStore := range stores {
+		rs := newStore()
+		u := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f.go", "g.go"}}
+		if err := rs.Import("c", u, graph.Output{Defs: defParentsTestDefs}); err != nil {
+			t.Fatalf("%s: %s", label, err)
+		}
+		if ri, ok := rs.(RepoIndexer); ok && label == "indexed" {
+			if err := ri.Index("c"); err != nil {
+				t.Fatalf("%s: %s", label, err)
+			}
+		}
+
+		children := map[string][]string{
+			"":      {"F", "G", "p"},
+			"p":     {"p/T"},
+			"p/T":   {"p/T/M"},
+			"F":     {"F$local"},
+			"G":     {},
+			"nodef": {},
+		}
+		for parent, want := range children {
+			defs, err := ListChildren(rs, key(parent))
+			if err != nil {
+				t.Fatalf("%s: %s", label, err)
+			}
+			if got := paths(defs); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: got children of %q %v, want %v", label, parent, got, want)
+			}
+		}
+
+		parents := map[string]string{
+			"p/T/M/v": "p/T/M",
+			"F$local": "F",
+			"p":       "",
+			"nodef":   "",
+		}
+		for child, want := range parents {
+			def, err := GetParent(rs, key(child))
+			if err != nil {
+				t.Fatalf("%s: %s", label, err)
+			}
+			var got string
+			if def != nil {
+				got = def.Path
+			}
+			if got != want {
+				t.Errorf("%s: got parent of %q %q, want %q", label, child, got, want)
+			}
+		}
+	}
+}
@@ -122,20 +122,23 @@ func (x *defRefUnitsIndex) Build(unitRefIndexes map[unit.ID2]*defRefsIndex) erro
 		}
 	}
 	vlog.Printf("defRefUnitsIndex: adding %d index phtable keys...", len(defToUnits))
-	b := phtable.Builder(len(defToUnits))
-	for def, units := range defToUnits {
-		ub, err := binary.Marshal(units)
-		if err != nil {
-			return err
-		}
-		kb, err := proto.Marshal(&def)
-		if err != nil {
-			return err
+	h, err := buildPhtable(len(defToUnits), false, func(add func(k, v []byte) error) error {
+		for def, units := range defToUnits {
+			ub, err := binary.Marshal(units)
+			if err != nil {
+				return err
+			}
+			kb, err := proto.Marshal(&def)
+			if err != nil {
+				return err
+			}
+			if err := add(kb, ub); err != nil {
+				return err
+			}
 		}
-		b.Add(kb, ub)
-	}
-	vlog.Printf("defRefUnitsIndex: building phtable index...")
-	h, err := b.Build()
+		vlog.Printf("defRefUnitsIndex: building phtable index...")
+		return nil
+	})
 	if err != nil {
 		return err
 	}
@@ -165,6 +168,16 @@ func (x *defRefUnitsIndex) Read(r io.Reader) error {
 	return err
 }
 
+// Map implements mappedIndex.
+func (x *defRefUnitsIndex) Map(m *phtable.Mapping) error {
+	phtable, err := phtable.ReadMapping(m, false)
+	x.Lock()
+	defer x.Unlock()
+	x.phtable = phtable
+	x.ready = (err == nil)
+	return err
+}
+
 // Ready implements persistedIndex.
 func (x *defRefUnitsIndex) Ready() bool {
 	x.RLock()
@@ -87,38 +87,48 @@ func (x *defRefsIndex) Build(refs []*graph.Ref, fbr fileByteRanges, ofs byteOffs
 	x.Lock()
 	defer x.Unlock()
 	vlog.Printf("defRefsIndex: building inverted def->ref index (%d refs)...", len(refs))
-	defToRefOfs := map[graph.RefDefKey]byteOffsets{}
-	for i, ref := range refs {
-		defToRefOfs[ref.RefDefKey()] = append(defToRefOfs[ref.RefDefKey()], ofs[i])
-	}
+	defToRefOfs := defRefOfs(refs, ofs)
 
 	vlog.Printf("defRefsIndex: adding %d index phtable keys...", len(defToRefOfs))
-	b := phtable.Builder(len(fbr))
-	for def, refOfs := range defToRefOfs {
-		v, err := binary.Marshal(refOfs)
-		if err != nil {
-			return err
-		}
+	// Keys are stored so defRefUnitsIndex can enumerate defs pointed to
+	// by this unit's refs.
+	h, err := buildPhtable(len(defToRefOfs), true, func(add func(k, v []byte) error) error {
+		for def, refOfs := range defToRefOfs {
+			v, err := binary.Marshal(refOfs)
+			if err != nil {
+				return err
+			}
 
-		k, err := proto.Marshal(&def)
-		if err != nil {
-			return err
-		}
+			k, err := proto.Marshal(&def)
+			if err != nil {
+				return err
+			}
 
-		b.Add([]byte(k), v)
-	}
-	vlog.Printf("defRefsIndex: building index phtable...")
-	h, err := b.Build()
+			if err := add([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		vlog.Printf("defRefsIndex: building index phtable...")
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	h.StoreKeys = true // so defRefUnitsIndex can enumerate defs pointed to by this unit's refs
 	x.phtable = h
 	x.ready = true
 	vlog.Printf("defRefsIndex: done building index.")
 	return nil
 }
 
+// defRefOfs returns the offsets of the refs to each def.
+func defRefOfs(refs []*graph.Ref, ofs byteOffsets) map[graph.RefDefKey]byteOffsets {
+	defToRefOfs := map[graph.RefDefKey]byteOffsets{}
+	for i, ref := range refs {
+		defToRefOfs[ref.RefDefKey()] = append(defToRefOfs[ref.RefDefKey()], ofs[i])
+	}
+	return defToRefOfs
+}
+
 // Write implements persistedIndex.
 func (x *defRefsIndex) Write(w io.Writer) error {
 	x.RLock()
@@ -139,6 +149,16 @@ func (x *defRefsIndex) Read(r io.Reader) error {
 	return err
 }
 
+// Map implements mappedIndex.
+func (x *defRefsIndex) Map(m *phtable.Mapping) error {
+	phtable, err := phtable.ReadMapping(m, false)
+	x.Lock()
+	defer x.Unlock()
+	x.phtable = phtable
+	x.ready = (err == nil)
+	return err
+}
+
 // Ready implements persistedIndex.
 func (x *defRefsIndex) Ready() bool {
 	x.RLock()
@@ -0,0 +1,187 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/binary"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store/phtable"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// defRepoRefsIndex makes it fast to find the refs (in any source unit
+// in a tree) to defs in another repo, such as to find all of the refs
+// from a repo into one of its dependencies. Refs to defs in the same
+// repo (whose DefRepo is empty) aren't indexed. Repo URIs are
+// case-insensitive (see graph.URIEqual), so keys are lowercased.
+type defRepoRefsIndex struct {
+	phtable *phtable.CHD
+	ready   bool
+	sync.RWMutex
+}
+
+var _ interface {
+	Index
+	persistedIndex
+	refTreeIndex
+	unitDataIndexBuilder
+} = (*defRepoRefsIndex)(nil)
+
+var c_defRepoRefsIndex_getByRepo = &counter{count: new(int64)}
+
+func (x *defRepoRefsIndex) String() string { return fmt.Sprintf("defRepoRefsIndex(ready=%v)", x.ready) }
+
+// getByRepo returns the refs to defs in repo.
+func (x *defRepoRefsIndex) getByRepo(repo string) ([]unitRefOffsets, bool, error) {
+	vlog.Printf("defRepoRefsIndex.getByRepo(%s)", repo)
+	c_defRepoRefsIndex_getByRepo.increment()
+
+	if x.phtable == nil {
+		panic("phtable not built/read")
+	}
+	v := x.phtable.Get([]byte(strings.ToLower(repo)))
+	if v == nil {
+		return nil, false, nil
+	}
+
+	var uofs []unitRefOffsets
+	if err := binary.Unmarshal(v, &uofs); err != nil {
+		return nil, true, err
+	}
+	return uofs, true, nil
+}
+
+// Covers implements Index. The index doesn't cover a ByRefDefRepos
+// filter that also selects refs to defs in the current repo, since
+// those refs aren't indexed.
+func (x *defRepoRefsIndex) Covers(filters interface{}) int {
+	cov := 0
+	for _, f := range storeFilters(filters) {
+		if f, ok := f.(ByRefDefReposFilter); ok && !f.selectsImpliedRepo() {
+			cov++
+		}
+	}
+	return cov
+}
+
+// Refs implements refTreeIndex.
+func (x *defRepoRefsIndex) Refs(fs ...RefFilter) (map[unit.ID2]byteOffsets, error) {
+	x.RLock()
+	defer x.RUnlock()
+	for _, f := range fs {
+		if ff, ok := f.(ByRefDefReposFilter); ok && !ff.selectsImpliedRepo() {
+			uofs := map[unit.ID2]byteOffsets{}
+			seen := map[string]struct{}{}
+			for _, repo := range ff.ByRefDefRepos() {
+				if _, dup := seen[strings.ToLower(repo)]; dup {
+					continue
+				}
+				seen[strings.ToLower(repo)] = struct{}{}
+				v, _, err := x.getByRepo(repo)
+				if err != nil {
+					return nil, err
+				}
+				for _, uo := range v {
+					uofs[uo.Unit] = append(uofs[uo.Unit], uo.Ofs...)
+				}
+			}
+			vlog.Printf("defRepoRefsIndex(%v): Found refs in %d units using index.", ff.ByRefDefRepos(), len(uofs))
+			return uofs, nil
+		}
+	}
+	return nil, nil
+}
+
+// Build implements unitDataIndexBuilder. Only the refs are read.
+func (x *defRepoRefsIndex) Build(units []*unit.SourceUnit, readDefs func(unit.ID2) ([]*graph.Def, error), readRefs func(unit.ID2) ([]*graph.Ref, byteOffsets, error)) error {
+	x.Lock()
+	defer x.Unlock()
+	vlog.Printf("defRepoRefsIndex: building def repo->refs index (%d units)...", len(units))
+
+	// Sort units so that the index is deterministic.
+	unitIDs := make([]unit.ID2, len(units))
+	for i, u := range units {
+		unitIDs[i] = u.ID2()
+	}
+	sort.Sort(unitID2s(unitIDs))
+
+	repoToRefs := map[string][]unitRefOffsets{}
+	for _, u := range unitIDs {
+		refs, refOfs, err := readRefs(u)
+		if err != nil {
+			return err
+		}
+		for i, ref := range refs {
+			if ref.DefRepo == "" {
+				continue
+			}
+			key := strings.ToLower(ref.DefRepo)
+			uofs := repoToRefs[key]
+			if len(uofs) == 0 || uofs[len(uofs)-1].Unit != u {
+				uofs = append(uofs, unitRefOffsets{Unit: u})
+			}
+			uofs[len(uofs)-1].Ofs = append(uofs[len(uofs)-1].Ofs, refOfs[i])
+			repoToRefs[key] = uofs
+		}
+	}
+
+	vlog.Printf("defRepoRefsIndex: adding %d index phtable keys...", len(repoToRefs))
+	b := phtable.Builder(len(repoToRefs))
+	for repo, uofs := range repoToRefs {
+		v, err := binary.Marshal(uofs)
+		if err != nil {
+			return err
+		}
+		b.Add([]byte(repo), v)
+	}
+	h, err := b.Build()
+	if err != nil {
+		return err
+	}
+	x.phtable = h
+	x.ready = true
+	vlog.Printf("defRepoRefsIndex: done building index.")
+	return nil
+}
+
+// Write implements persistedIndex.
+func (x *defRepoRefsIndex) Write(w io.Writer) error {
+	x.RLock()
+	defer x.RUnlock()
+	if x.phtable == nil {
+		panic("no phtable to write")
+	}
+	return x.phtable.Write(w)
+}
+
+// Read implements persistedIndex.
+func (x *defRepoRefsIndex) Read(r io.Reader) error {
+	phtable, err := phtable.Read(r)
+	x.Lock()
+	defer x.Unlock()
+	x.phtable = phtable
+	x.ready = (err == nil)
+	return err
+}
+
+// Map implements mappedIndex.
+func (x *defRepoRefsIndex) Map(m *phtable.Mapping) error {
+	phtable, err := phtable.ReadMapping(m, false)
+	x.Lock()
+	defer x.Unlock()
+	x.phtable = phtable
+	x.ready = (err == nil)
+	return err
+}
+
+// Ready implements persistedIndex.
+func (x *defRepoRefsIndex) Ready() bool {
+	x.RLock()
+	defer x.RUnlock()
+	return x.ready
+}
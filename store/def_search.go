@@ -0,0 +1,184 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// defSearchMinMatch is the fraction of a search query's trigrams that
+// a def's text must contain for the def to match. It is less than 1
+// so that queries with typos still match.
+const defSearchMinMatch = 0.5
+
+// BySearchFilter is implemented by filters that restrict their
+// selection to defs whose names, paths, or docs match a free-text
+// search query.
+type BySearchFilter interface {
+	BySearch() string
+}
+
+// BySearch returns a filter that selects non-local defs whose names,
+// paths, or docs fuzzily match the free-text query q (see
+// DefSearcher). It panics if q is empty.
+func BySearch(q string) interface {
+	DefFilter
+	BySearchFilter
+} {
+	if q == "" {
+		panic("BySearch: empty")
+	}
+	return bySearchFilter{q: q, trigrams: searchTrigrams(q)}
+}
+
+type bySearchFilter struct {
+	q        string
+	trigrams []string
+}
+
+func (f bySearchFilter) String() string   { return fmt.Sprintf("BySearch(%q)", f.q) }
+func (f bySearchFilter) BySearch() string { return f.q }
+func (f bySearchFilter) SelectDef(def *graph.Def) bool {
+	return !def.Local && defSearchScore(def, f.q, f.trigrams) > 0
+}
+
+// defSearchText returns the text of def that search queries are
+// matched against.
+func defSearchText(def *graph.Def) string {
+	parts := []string{def.Name, def.Path}
+	for _, doc := range def.Docs {
+		parts = append(parts, doc.Data)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// searchWords splits s into lowercase words of letters, digits, and
+// underscores.
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
+
+// searchTrigrams returns the sorted, distinct trigrams of the words in
+// s. Words shorter than 3 bytes have no trigrams.
+func searchTrigrams(s string) []string {
+	seen := map[string]struct{}{}
+	var tris []string
+	for _, w := range searchWords(s) {
+		for i := 0; i+3 <= len(w); i++ {
+			if tri := w[i : i+3]; !containsKey(seen, tri) {
+				seen[tri] = struct{}{}
+				tris = append(tris, tri)
+			}
+		}
+	}
+	sort.Strings(tris)
+	return tris
+}
+
+func containsKey(m map[string]struct{}, k string) bool {
+	_, present := m[k]
+	return present
+}
+
+// searchTrigramsNeeded returns how many of a query's n trigrams a def
+// must contain to match it.
+func searchTrigramsNeeded(n int) int {
+	need := int(float64(n)*defSearchMinMatch + 0.999)
+	if need < 1 {
+		need = 1
+	}
+	return need
+}
+
+// defSearchScore returns how well def matches the search query q
+// (whose trigrams are qtris), or 0 if it doesn't match. Queries
+// without trigrams (i.e., shorter than 3 characters) only match
+// names that contain them.
+func defSearchScore(def *graph.Def, q string, qtris []string) float64 {
+	name, lq := strings.ToLower(def.Name), strings.ToLower(strings.TrimSpace(q))
+	var score float64
+	switch {
+	case name == lq:
+		score = 3
+	case strings.HasPrefix(name, lq):
+		score = 2
+	case strings.Contains(name, lq):
+		score = 1
+	}
+	if len(qtris) == 0 {
+		return score
+	}
+
+	dtris := searchTrigrams(defSearchText(def))
+	var shared int
+	for _, tri := range qtris {
+		if i := sort.SearchStrings(dtris, tri); i < len(dtris) && dtris[i] == tri {
+			shared++
+		}
+	}
+	if shared < searchTrigramsNeeded(len(qtris)) {
+		return 0
+	}
+	return score + float64(shared)/float64(len(qtris))
+}
+
+// A DefSearcher searches for defs by a free-text query, which is
+// fuzzily matched (by trigrams) against def names, paths, and docs.
+// Stores with a def search index (built at import time) answer
+// queries from it; others scan all defs.
+type DefSearcher interface {
+	// SearchDefs returns up to limit (0 for all) defs that match q
+	// and the filters fs, best match first.
+	SearchDefs(q string, limit int, fs ...DefFilter) ([]*DefSearchResult, error)
+}
+
+// A DefSearchResult is a def that matched a search query.
+type DefSearchResult struct {
+	Def *graph.Def
+
+	// Score is how well the def matched. Exact and prefix name
+	// matches score highest.
+	Score float64
+}
+
+// NewDefSearcher returns a DefSearcher that searches the defs in s.
+func NewDefSearcher(s UnitStore) DefSearcher { return defSearcher{s} }
+
+type defSearcher struct{ s UnitStore }
+
+func (s defSearcher) SearchDefs(q string, limit int, fs ...DefFilter) ([]*DefSearchResult, error) {
+	f := BySearch(q)
+	defs, err := s.s.Defs(append([]DefFilter{f}, fs...)...)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*DefSearchResult, len(defs))
+	qtris := searchTrigrams(q)
+	for i, def := range defs {
+		results[i] = &DefSearchResult{Def: def, Score: defSearchScore(def, q, qtris)}
+	}
+	sort.Sort(defSearchResultsByScore(results))
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+type defSearchResultsByScore []*DefSearchResult
+
+func (v defSearchResultsByScore) Len() int      { return len(v) }
+func (v defSearchResultsByScore) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v defSearchResultsByScore) Less(i, j int) bool {
+	if v[i].Score != v[j].Score {
+		return v[i].Score > v[j].Score
+	}
+	if v[i].Def.Name != v[j].Def.Name {
+		return v[i].Def.Name < v[j].Def.Name
+	}
+	return v[i].Def.Path < v[j].Def.Path
+}
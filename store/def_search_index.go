@@ -0,0 +1,200 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/alecthomas/binary"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// defSearchIndex is a trigram index over the names, paths, and docs
+// of a source unit's non-local defs, used to answer BySearch queries.
+//
+// If docTitles is true, it instead indexes the defs' doc titles (see
+// DefDocTitle), omitting defs without docs, and answers ByDocTitle
+// queries.
+type defSearchIndex struct {
+	docTitles bool
+
+	t     *defSearchTable
+	ready bool
+	sync.RWMutex
+}
+
+// defSearchTable is the persisted form of a defSearchIndex. The
+// defs containing Trigrams[i] are Ofs[j] for each j in Postings[i].
+type defSearchTable struct {
+	Trigrams []string
+	Postings [][]uint32
+	Ofs      byteOffsets
+}
+
+var _ interface {
+	Index
+	persistedIndex
+	defIndexBuilder
+	defIndex
+} = (*defSearchIndex)(nil)
+
+var c_defSearchIndex_getBySearch = &counter{count: new(int64)}
+
+func (x *defSearchIndex) String() string {
+	return fmt.Sprintf("defSearchIndex(docTitles=%v, ready=%v)", x.docTitles, x.ready)
+}
+
+// getBySearch returns the byte offsets of the defs that contain
+// enough of the trigrams of the query q to match it. Doc title
+// queries match by word prefixes, so matching defs contain all of
+// their trigrams.
+func (x *defSearchIndex) getBySearch(q string) byteOffsets {
+	vlog.Printf("defSearchIndex.getBySearch(%q)", q)
+	c_defSearchIndex_getBySearch.increment()
+
+	if x.t == nil {
+		panic("defSearchTable not built/read")
+	}
+
+	qtris := searchTrigrams(q)
+	counts := map[uint32]int{}
+	for _, tri := range qtris {
+		if i := sort.SearchStrings(x.t.Trigrams, tri); i < len(x.t.Trigrams) && x.t.Trigrams[i] == tri {
+			for _, d := range x.t.Postings[i] {
+				counts[d]++
+			}
+		}
+	}
+	need := searchTrigramsNeeded(len(qtris))
+	if x.docTitles {
+		need = len(qtris)
+	}
+	var ofs byteOffsets
+	for d, n := range counts {
+		if n >= need {
+			ofs = append(ofs, x.t.Ofs[d])
+		}
+	}
+	sort.Sort(int64Slice(ofs))
+	vlog.Printf("defSearchIndex.getBySearch(%q): found %d defs.", q, len(ofs))
+	return ofs
+}
+
+// query returns the query of the filter f that x answers, if any.
+func (x *defSearchIndex) query(f interface{}) (q string, ok bool) {
+	if x.docTitles {
+		if f, ok := f.(ByDocTitleFilter); ok {
+			return f.ByDocTitle(), true
+		}
+	} else if f, ok := f.(BySearchFilter); ok {
+		return f.BySearch(), true
+	}
+	return "", false
+}
+
+// Covers implements defIndex. Queries without trigrams (i.e., shorter
+// than 3 characters) can't use the index.
+func (x *defSearchIndex) Covers(filters interface{}) int {
+	cov := 0
+	for _, f := range storeFilters(filters) {
+		if q, ok := x.query(f); ok && len(searchTrigrams(q)) > 0 {
+			cov++
+		}
+	}
+	return cov
+}
+
+// Defs implements defIndex.
+func (x *defSearchIndex) Defs(f ...DefFilter) (byteOffsets, error) {
+	x.RLock()
+	defer x.RUnlock()
+	for _, ff := range f {
+		if q, ok := x.query(ff); ok {
+			return x.getBySearch(q), nil
+		}
+	}
+	return nil, nil
+}
+
+// Build implements defIndexBuilder.
+func (x *defSearchIndex) Build(defs []*graph.Def, ofs byteOffsets) error {
+	x.Lock()
+	defer x.Unlock()
+	vlog.Printf("defSearchIndex: building index... (%d defs)", len(defs))
+
+	postings := map[string][]uint32{}
+	t := &defSearchTable{}
+	for i, def := range defs {
+		if def.Local {
+			continue
+		}
+		text := defSearchText(def)
+		if x.docTitles {
+			if text = DefDocTitle(def); text == "" {
+				continue
+			}
+		}
+		d := uint32(len(t.Ofs))
+		t.Ofs = append(t.Ofs, ofs[i])
+		for _, tri := range searchTrigrams(text) {
+			postings[tri] = append(postings[tri], d)
+		}
+	}
+	t.Trigrams = make([]string, 0, len(postings))
+	for tri := range postings {
+		t.Trigrams = append(t.Trigrams, tri)
+	}
+	sort.Strings(t.Trigrams)
+	t.Postings = make([][]uint32, len(t.Trigrams))
+	for i, tri := range t.Trigrams {
+		t.Postings[i] = postings[tri]
+	}
+
+	x.t = t
+	x.ready = true
+	vlog.Printf("defSearchIndex: done building index (%d defs, %d trigrams).", len(t.Ofs), len(t.Trigrams))
+	return nil
+}
+
+// Write implements persistedIndex.
+func (x *defSearchIndex) Write(w io.Writer) error {
+	x.RLock()
+	defer x.RUnlock()
+	if x.t == nil {
+		panic("no defSearchTable to write")
+	}
+	b, err := binary.Marshal(x.t)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Read implements persistedIndex.
+func (x *defSearchIndex) Read(r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	x.Lock()
+	defer x.Unlock()
+	var t defSearchTable
+	err = binary.Unmarshal(b, &t)
+	if err == nil && len(t.Postings) != len(t.Trigrams) {
+		err = fmt.Errorf("defSearchIndex: %d trigrams but %d postings lists", len(t.Trigrams), len(t.Postings))
+	}
+	x.t = &t
+	x.ready = (err == nil)
+	return err
+}
+
+// Ready implements persistedIndex.
+func (x *defSearchIndex) Ready() bool {
+	x.RLock()
+	defer x.RUnlock()
+	return x.ready
+}
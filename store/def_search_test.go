@@ -0,0 +1,57 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestDefSearcher(t *testing.T) {
+	defs := []*graph.Def{
+		{DefKey: graph.DefKey{Path: "NewRepoStore"}, Name: "NewRepoStore"},
+		{DefKey: graph.DefKey{Path: "RepoStore"}, Name: "RepoStore"},
+		{DefKey: graph.DefKey{Path: "Open"}, Name: "Open", Docs: []*graph.DefDoc{{Format: "text/plain", Data: "Open opens the repository store at dir."}}},
+		{DefKey: graph.DefKey{Path: "Unrelated"}, Name: "Unrelated"},
+		{DefKey: graph.DefKey{Path: "RepoStore/x"}, Name: "repoStoreLocal", Local: true},
+	}
+
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"RepoStore", []string{"RepoStore", "NewRepoStore", "Open"}}, // exact, prefix, doc
+		{"NewRepoStroe", []string{"NewRepoStore"}},                   // typo
+		{"repository", []string{"Open", "NewRepoStore", "RepoStore"}},
+		{"op", []string{"Open"}}, // too short for trigrams
+		{"zzzz", nil},
+	}
+
+	for _, indexed := range []bool{false, true} {
+		fs := rwvfs.Map(map[string]string{})
+		var us UnitStoreImporter
+		if indexed {
+			us = newIndexedUnitStore(fs, "test")
+		} else {
+			us = &fsUnitStore{fs: fs}
+		}
+		if err := us.Import(graph.Output{Defs: defs}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, test := range tests {
+			results, err := NewDefSearcher(us).SearchDefs(test.q, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, r := range results {
+				names = append(names, r.Def.Name)
+			}
+			if !reflect.DeepEqual(names, test.want) {
+				t.Errorf("indexed=%v: %q: got %v, want %v", indexed, test.q, names, test.want)
+			}
+		}
+	}
+}
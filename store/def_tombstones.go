@@ -0,0 +1,158 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// DefTombstonesFilename is the name of the file (in a commit's build
+// data dir) that holds the tombstones of the defs that were removed
+// in or before the commit.
+const DefTombstonesFilename = "def-tombstones.json"
+
+// MaxDefTombstones is the max number of tombstones kept for a commit.
+// When there are more, the ones for the defs removed longest ago are
+// dropped.
+var MaxDefTombstones = 5000
+
+// A DefTombstone records a def that no longer exists: where it was
+// last defined, and the commit that removed it.
+type DefTombstone struct {
+	graph.DefKey
+
+	Name string `json:",omitempty"`
+	Kind string `json:",omitempty"`
+
+	// File, DefStart, and DefEnd are the def's location at
+	// LastCommitID.
+	File     string `json:",omitempty"`
+	DefStart uint32 `json:",omitempty"`
+	DefEnd   uint32 `json:",omitempty"`
+
+	// LastCommitID is the last commit (that was built) in which the
+	// def existed.
+	LastCommitID string
+
+	// RemovedIn is the first commit (that was built) in which the def
+	// no longer existed.
+	RemovedIn string
+}
+
+// DefTombstones is a list of tombstones, most recently removed first.
+type DefTombstones []*DefTombstone
+
+// NextDefTombstones returns the tombstones for a commit (commitID),
+// given the tombstones and defs of the previous commit that was built
+// (parentCommitID) and the commit's own defs. A tombstone is added for
+// each of the parent's defs that's gone, and the parent's tombstones
+// are kept unless their def has reappeared.
+func NextDefTombstones(parent DefTombstones, parentCommitID string, parentDefs []*graph.Def, commitID string, defs []*graph.Def) DefTombstones {
+	exists := make(map[graph.DefKey]bool, len(defs))
+	for _, def := range defs {
+		exists[tombstoneKey(def.DefKey)] = true
+	}
+
+	var removed DefTombstones
+	for _, def := range parentDefs {
+		if exists[tombstoneKey(def.DefKey)] {
+			continue
+		}
+		removed = append(removed, &DefTombstone{
+			DefKey:       tombstoneKey(def.DefKey),
+			Name:         def.Name,
+			Kind:         def.Kind,
+			File:         def.File,
+			DefStart:     def.DefStart,
+			DefEnd:       def.DefEnd,
+			LastCommitID: parentCommitID,
+			RemovedIn:    commitID,
+		})
+	}
+	sort.Sort(tombstonesByKey(removed))
+
+	t := removed
+	seen := make(map[graph.DefKey]bool, len(removed))
+	for _, x := range removed {
+		seen[x.DefKey] = true
+	}
+	for _, x := range parent {
+		if !exists[x.DefKey] && !seen[x.DefKey] {
+			seen[x.DefKey] = true
+			t = append(t, x)
+		}
+	}
+	if len(t) > MaxDefTombstones {
+		t = t[:MaxDefTombstones]
+	}
+	return t
+}
+
+// tombstoneKey returns the part of key that identifies a def across
+// commits of a repo.
+func tombstoneKey(key graph.DefKey) graph.DefKey {
+	return graph.DefKey{UnitType: key.UnitType, Unit: key.Unit, Path: key.Path}
+}
+
+type tombstonesByKey DefTombstones
+
+func (v tombstonesByKey) Len() int      { return len(v) }
+func (v tombstonesByKey) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v tombstonesByKey) Less(i, j int) bool {
+	a, b := v[i].DefKey, v[j].DefKey
+	if a.UnitType != b.UnitType {
+		return a.UnitType < b.UnitType
+	}
+	if a.Unit != b.Unit {
+		return a.Unit < b.Unit
+	}
+	return a.Path < b.Path
+}
+
+// ByName returns the tombstones of defs named name.
+func (t DefTombstones) ByName(name string) DefTombstones {
+	var matches DefTombstones
+	for _, x := range t {
+		if x.Name == name {
+			matches = append(matches, x)
+		}
+	}
+	return matches
+}
+
+// ReadDefTombstones reads the def tombstones from fs. If there are
+// none, it returns nil and no error.
+func ReadDefTombstones(fs vfs.FileSystem) (DefTombstones, error) {
+	f, err := fs.Open(DefTombstonesFilename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var t DefTombstones
+	if err := json.NewDecoder(f).Decode(&t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// WriteDefTombstones writes the def tombstones to fs, replacing any
+// existing ones.
+func WriteDefTombstones(fs rwvfs.FileSystem, t DefTombstones) (err error) {
+	f, err := fs.Create(DefTombstonesFilename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := f.Close(); err2 != nil && err == nil {
+			err = err2
+		}
+	}()
+	return json.NewEncoder(f).Encode(t)
+}
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestDefTombstones(t *testing.T) {
+	def := func(path string) *graph.Def {
+		return &graph.Def{DefKey: graph.DefKey{Repo: "r", CommitID: "x", UnitType: "t", Unit: "u", Path: path}, Name: path, File: path + ".go"}
+	}
+	paths := func(ts DefTombstones) []string {
+		var ps []string
+		for _, x := range ts {
+			ps = append(ps, x.Path+"@"+x.RemovedIn)
+		}
+		return ps
+	}
+
+	// c1 -> c2 removes A and B.
+	t2 := NextDefTombstones(nil, "c1", []*graph.Def{def("A"), def("B"), def("C")}, "c2", []*graph.Def{def("C")})
+	if want := []string{"A@c2", "B@c2"}; !reflect.DeepEqual(paths(t2), want) {
+		t.Errorf("got tombstones %v, want %v", paths(t2), want)
+	}
+	if x := t2[0]; x.LastCommitID != "c1" || x.File != "A.go" || x.Repo != "" || x.CommitID != "" {
+		t.Errorf("got tombstone %+v, want last location A.go at c1 (without repo or commit in its key)", x)
+	}
+
+	// c2 -> c3 removes C and restores B.
+	t3 := NextDefTombstones(t2, "c2", []*graph.Def{def("C")}, "c3", []*graph.Def{def("B")})
+	if want := []string{"C@c3", "A@c2"}; !reflect.DeepEqual(paths(t3), want) {
+		t.Errorf("got tombstones %v, want %v (most recent first, without restored defs)", paths(t3), want)
+	}
+
+	if got := t3.ByName("A"); len(got) != 1 || got[0].RemovedIn != "c2" {
+		t.Errorf("got %v for ByName(A), want its tombstone", paths(got))
+	}
+
+	fs := rwvfs.Map(map[string]string{})
+	if t0, err := ReadDefTombstones(fs); err != nil || t0 != nil {
+		t.Errorf("got (%v, %v) reading nonexistent tombstones, want (nil, nil)", t0, err)
+	}
+	if err := WriteDefTombstones(fs, t3); err != nil {
+		t.Fatal(err)
+	}
+	t4, err := ReadDefTombstones(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(t4, t3) {
+		t.Errorf("got tombstones %v after round trip, want %v", paths(t4), paths(t3))
+	}
+}
@@ -5,11 +5,14 @@ import (
 	"log"
 	"path"
 	"reflect"
+	"regexp"
+	"regexp/syntax"
 	"strings"
 	"sync"
 
 	"sort"
 
+	"sourcegraph.com/sourcegraph/srclib/ann"
 	"sourcegraph.com/sourcegraph/srclib/graph"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
@@ -81,6 +84,31 @@ type RefFilterFunc func(*graph.Ref) bool
 func (f RefFilterFunc) SelectRef(ref *graph.Ref) bool { return f(ref) }
 func (f RefFilterFunc) String() string                { return "RefFilterFunc" }
 
+// An AnnFilter filters a set of anns to only those for which
+// SelectAnn returns true.
+type AnnFilter interface {
+	SelectAnn(*ann.Ann) bool
+}
+
+type annFilters []AnnFilter
+
+func (fs annFilters) SelectAnn(a *ann.Ann) bool {
+	for _, f := range fs {
+		if !f.SelectAnn(a) {
+			return false
+		}
+	}
+	return true
+}
+
+// An AnnFilterFunc is an AnnFilter that selects only those anns for
+// which the func returns true.
+type AnnFilterFunc func(*ann.Ann) bool
+
+// SelectAnn calls f(a).
+func (f AnnFilterFunc) SelectAnn(a *ann.Ann) bool { return f(a) }
+func (f AnnFilterFunc) String() string            { return "AnnFilterFunc" }
+
 // A UnitFilter filters a set of units to only those for which Select
 // returns true.
 type UnitFilter interface {
@@ -170,6 +198,7 @@ type ByUnitsFilter interface {
 func ByUnits(units ...unit.ID2) interface {
 	DefFilter
 	RefFilter
+	AnnFilter
 	UnitFilter
 	ByUnitsFilter
 } {
@@ -203,6 +232,9 @@ func (f byUnitsFilter) SelectDef(def *graph.Def) bool {
 func (f byUnitsFilter) SelectRef(ref *graph.Ref) bool {
 	return (ref.Unit == "" && ref.UnitType == "") || f.contains(unit.ID2{Type: ref.UnitType, Name: ref.Unit})
 }
+func (f byUnitsFilter) SelectAnn(a *ann.Ann) bool {
+	return (a.Unit == "" && a.UnitType == "") || f.contains(unit.ID2{Type: a.UnitType, Name: a.Unit})
+}
 func (f byUnitsFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	return (unit.Type == "" && unit.Name == "") || f.contains(unit.ID2())
 }
@@ -220,6 +252,7 @@ type ByCommitIDsFilter interface {
 func ByCommitIDs(commitIDs ...string) interface {
 	DefFilter
 	RefFilter
+	AnnFilter
 	UnitFilter
 	VersionFilter
 	ByCommitIDsFilter
@@ -250,6 +283,9 @@ func (f byCommitIDsFilter) SelectDef(def *graph.Def) bool {
 func (f byCommitIDsFilter) SelectRef(ref *graph.Ref) bool {
 	return ref.CommitID == "" || f.contains(ref.CommitID)
 }
+func (f byCommitIDsFilter) SelectAnn(a *ann.Ann) bool {
+	return a.CommitID == "" || f.contains(a.CommitID)
+}
 func (f byCommitIDsFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	return unit.CommitID == "" || f.contains(unit.CommitID)
 }
@@ -270,6 +306,7 @@ type ByReposFilter interface {
 func ByRepos(repos ...string) interface {
 	DefFilter
 	RefFilter
+	AnnFilter
 	UnitFilter
 	VersionFilter
 	RepoFilter
@@ -301,6 +338,9 @@ func (f byReposFilter) SelectDef(def *graph.Def) bool {
 func (f byReposFilter) SelectRef(ref *graph.Ref) bool {
 	return ref.Repo == "" || f.contains(ref.Repo)
 }
+func (f byReposFilter) SelectAnn(a *ann.Ann) bool {
+	return a.Repo == "" || f.contains(a.Repo)
+}
 func (f byReposFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	return unit.Repo == "" || f.contains(unit.Repo)
 }
@@ -325,6 +365,7 @@ type ByRepoCommitIDsFilter interface {
 func ByRepoCommitIDs(versions ...Version) interface {
 	DefFilter
 	RefFilter
+	AnnFilter
 	UnitFilter
 	VersionFilter
 	RepoFilter
@@ -369,6 +410,9 @@ func (f byRepoCommitIDsFilter) SelectDef(def *graph.Def) bool {
 func (f byRepoCommitIDsFilter) SelectRef(ref *graph.Ref) bool {
 	return (ref.Repo == "" && ref.CommitID == "") || f.contains(ref.Repo, ref.CommitID)
 }
+func (f byRepoCommitIDsFilter) SelectAnn(a *ann.Ann) bool {
+	return (a.Repo == "" && a.CommitID == "") || f.contains(a.Repo, a.CommitID)
+}
 func (f byRepoCommitIDsFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	return (unit.Repo == "" && unit.CommitID == "") || f.contains(unit.Repo, unit.CommitID)
 }
@@ -390,6 +434,7 @@ func (f byRepoCommitIDsFilter) SelectRepo(repo string) bool {
 func ByUnitKey(key unit.Key) interface {
 	DefFilter
 	RefFilter
+	AnnFilter
 	UnitFilter
 	ByReposFilter
 	ByCommitIDsFilter
@@ -424,6 +469,10 @@ func (f byUnitKeyFilter) SelectRef(ref *graph.Ref) bool {
 	return (ref.Repo == "" || ref.Repo == f.key.Repo) && (ref.CommitID == "" || ref.CommitID == f.key.CommitID) &&
 		(ref.UnitType == "" || ref.UnitType == f.key.UnitType) && (ref.Unit == "" || ref.Unit == f.key.Unit)
 }
+func (f byUnitKeyFilter) SelectAnn(a *ann.Ann) bool {
+	return (a.Repo == "" || a.Repo == f.key.Repo) && (a.CommitID == "" || a.CommitID == f.key.CommitID) &&
+		(a.UnitType == "" || a.UnitType == f.key.UnitType) && (a.Unit == "" || a.Unit == f.key.Unit)
+}
 func (f byUnitKeyFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	return (unit.Repo == "" || unit.Repo == f.key.Repo) && (unit.CommitID == "" || unit.CommitID == f.key.CommitID) &&
 		(unit.Type == "" || unit.Type == f.key.UnitType) && (unit.Name == "" || unit.Name == f.key.Unit)
@@ -439,6 +488,7 @@ func (f byUnitKeyFilter) SelectUnit(unit *unit.SourceUnit) bool {
 // above them).
 func ByDefKey(key graph.DefKey) interface {
 	DefFilter
+	AnnFilter
 	ByReposFilter
 	ByCommitIDsFilter
 	ByUnitsFilter
@@ -463,6 +513,102 @@ func (f byDefKeyFilter) SelectDef(def *graph.Def) bool {
 		(def.UnitType == "" || def.UnitType == f.key.UnitType) && (def.Unit == "" || def.Unit == f.key.Unit) &&
 		def.Path == f.key.Path
 }
+func (f byDefKeyFilter) SelectAnn(a *ann.Ann) bool {
+	return (a.Repo == "" || a.Repo == f.key.Repo) && (a.CommitID == "" || a.CommitID == f.key.CommitID) &&
+		(a.UnitType == "" || a.UnitType == f.key.UnitType) && (a.Unit == "" || a.Unit == f.key.Unit) &&
+		a.DefPath == f.key.Path
+}
+
+// ByDefParent returns a filter that selects the children of the def
+// with the given path in its source unit's tree of defs (see
+// ListChildren). If parentPath is empty, it selects the unit's
+// top-level defs. To select the children of a def in a specific
+// source unit, use it with ByUnits.
+func ByDefParent(parentPath string) DefFilter {
+	return &byDefTreeFilter{path: parentPath, children: true}
+}
+
+// ByDefChild returns a filter that selects the parent of the def with
+// the given path in its source unit's tree of defs (see GetParent). It
+// panics if childPath is empty.
+func ByDefChild(childPath string) DefFilter {
+	if childPath == "" {
+		panic("childPath: empty")
+	}
+	return &byDefTreeFilter{path: childPath}
+}
+
+// byDefTreeFilter is the filter returned by ByDefParent (if children
+// is true) and ByDefChild.
+//
+// Whether a def is selected depends on the other defs in its source
+// unit, so unit stores either use the def_parents index or resolve
+// the filter (with withDefParents) before selecting defs with it.
+type byDefTreeFilter struct {
+	path     string
+	children bool
+
+	// parents maps the path of each def in the source unit to the
+	// path of its parent (see defParents). Until it's set, the filter
+	// selects nothing.
+	parents map[string]string
+}
+
+func (f *byDefTreeFilter) String() string {
+	if f.children {
+		return fmt.Sprintf("ByDefParent(%q)", f.path)
+	}
+	return fmt.Sprintf("ByDefChild(%q)", f.path)
+}
+func (f *byDefTreeFilter) SelectDef(def *graph.Def) bool {
+	if f.parents == nil {
+		return false
+	}
+	if f.children {
+		return f.parents[def.Path] == f.path
+	}
+	return def.Path != "" && f.parents[f.path] == def.Path
+}
+
+// hasDefTreeFilter returns whether fs contains a ByDefParent or
+// ByDefChild filter.
+func hasDefTreeFilter(fs []DefFilter) bool {
+	for _, f := range fs {
+		if _, ok := f.(*byDefTreeFilter); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutDefTreeFilters returns fs without its ByDefParent and
+// ByDefChild filters (e.g., because an index already applied them).
+func withoutDefTreeFilters(fs []DefFilter) []DefFilter {
+	fCopy := make([]DefFilter, 0, len(fs))
+	for _, f := range fs {
+		if _, ok := f.(*byDefTreeFilter); !ok {
+			fCopy = append(fCopy, f)
+		}
+	}
+	return fCopy
+}
+
+// withDefParents returns a copy of fs whose ByDefParent and
+// ByDefChild filters select defs using the given parents (computed by
+// defParents from all of a source unit's defs).
+func withDefParents(fs []DefFilter, parents map[string]string) []DefFilter {
+	fCopy := make([]DefFilter, len(fs))
+	for i, f := range fs {
+		if tf, ok := f.(*byDefTreeFilter); ok {
+			tf2 := *tf
+			tf2.parents = parents
+			fCopy[i] = &tf2
+		} else {
+			fCopy[i] = f
+		}
+	}
+	return fCopy
+}
 
 // ByRefDefFilter is implemented by filters that restrict their
 // selection to refs with a specific target definition.
@@ -548,6 +694,180 @@ func (f *byRefDefFilter) withEmptyImpliedValues() graph.RefDefKey {
 var _ impliedRepoSetter = (*byRefDefFilter)(nil)
 var _ impliedUnitSetter = (*byRefDefFilter)(nil)
 
+// ByRefDefFilesFilter is implemented by filters that restrict their
+// selection to refs whose target definitions are defined in any of a
+// set of files.
+type ByRefDefFilesFilter interface {
+	ByRefDefFiles() []string
+}
+
+// ByRefDefFiles returns a filter that selects refs to defs that are
+// defined in any of the listed files (but not in files beneath them,
+// if they are dirs). Only refs in the same tree (repo and commit) as
+// the def are selected. It panics if any file path is empty or has
+// not been cleaned.
+func ByRefDefFiles(files ...string) interface {
+	RefFilter
+	ByRefDefFilesFilter
+} {
+	for _, f := range files {
+		if f == "" {
+			panic("file: empty")
+		}
+		if f != path.Clean(f) {
+			panic("file: not cleaned (file != path.Clean(file))")
+		}
+	}
+	return &byRefDefFilesFilter{files: files}
+}
+
+type byRefDefFilesFilter struct {
+	files []string
+
+	// defs is the set of defs (with empty DefRepo) that are defined in
+	// files. A ref's def doesn't say which file the def is defined
+	// in, so the tree store must look up the defs before the filter
+	// can select refs (see resolveRefDefFiles).
+	defs map[graph.RefDefKey]struct{}
+
+	impliedUnit unit.ID2 // the implied DefUnit{,Type} value when ref.DefUnit{,Type} == ""
+}
+
+func (f *byRefDefFilesFilter) String() string {
+	return fmt.Sprintf("ByRefDefFiles(%v, impliedUnit=%+v)", f.files, f.impliedUnit)
+}
+func (f *byRefDefFilesFilter) ByRefDefFiles() []string { return f.files }
+func (f *byRefDefFilesFilter) withImpliedUnit(u unit.ID2) RefFilter {
+	newF := *f
+	newF.impliedUnit = u
+	return &newF
+}
+func (f *byRefDefFilesFilter) SelectRef(ref *graph.Ref) bool {
+	if f.defs == nil {
+		panic("ByRefDefFiles filter was not resolved by the tree store")
+	}
+	if ref.DefRepo != "" {
+		return false
+	}
+	def := graph.RefDefKey{DefUnitType: ref.DefUnitType, DefUnit: ref.DefUnit, DefPath: ref.DefPath}
+	if def.DefUnitType == "" {
+		def.DefUnitType = f.impliedUnit.Type
+	}
+	if def.DefUnit == "" {
+		def.DefUnit = f.impliedUnit.Name
+	}
+	_, present := f.defs[def]
+	return present
+}
+
+var _ impliedUnitSetter = (*byRefDefFilesFilter)(nil)
+
+// resolveRefDefFiles returns a copy of fs in which each unresolved
+// ByRefDefFiles filter is replaced by one that knows which defs (in
+// the tree store s) are defined in its files.
+func resolveRefDefFiles(s UnitStore, fs []RefFilter) ([]RefFilter, error) {
+	var fsCopy []RefFilter
+	for i, f := range fs {
+		f, ok := f.(*byRefDefFilesFilter)
+		if !ok || f.defs != nil {
+			continue
+		}
+		files := make(map[string]struct{}, len(f.files))
+		for _, file := range f.files {
+			files[file] = struct{}{}
+		}
+		defs, err := s.Defs(DefFilterFunc(func(def *graph.Def) bool {
+			_, present := files[def.File]
+			return present
+		}))
+		if err != nil {
+			return nil, err
+		}
+		resolved := *f
+		resolved.defs = make(map[graph.RefDefKey]struct{}, len(defs))
+		for _, def := range defs {
+			resolved.defs[graph.RefDefKey{DefUnitType: def.UnitType, DefUnit: def.Unit, DefPath: def.Path}] = struct{}{}
+		}
+		if fsCopy == nil {
+			fsCopy = append([]RefFilter{}, fs...)
+		}
+		fsCopy[i] = &resolved
+	}
+	if fsCopy == nil {
+		return fs, nil
+	}
+	return fsCopy, nil
+}
+
+// ByRefDefReposFilter is implemented by filters that restrict their
+// selection to refs whose target definitions are in any of a set of
+// repos.
+type ByRefDefReposFilter interface {
+	ByRefDefRepos() []string
+
+	selectsImpliedRepo() bool // see docstring on impl method
+}
+
+// ByRefDefRepos returns a filter that selects refs to defs in any of
+// the listed repos (such as all of the refs from a repo into one of
+// its dependencies). Repos are compared case-insensitively (see
+// graph.URIEqual). It panics if any repo is empty.
+func ByRefDefRepos(repos ...string) interface {
+	RefFilter
+	ByRefDefReposFilter
+} {
+	for _, repo := range repos {
+		if repo == "" {
+			panic("repo: empty")
+		}
+	}
+	return &byRefDefReposFilter{repos: repos}
+}
+
+type byRefDefReposFilter struct {
+	repos []string
+
+	impliedRepo string // the implied DefRepo value when ref.DefRepo == ""
+}
+
+func (f *byRefDefReposFilter) String() string {
+	return fmt.Sprintf("ByRefDefRepos(%v, impliedRepo=%q)", f.repos, f.impliedRepo)
+}
+func (f *byRefDefReposFilter) ByRefDefRepos() []string    { return f.repos }
+func (f *byRefDefReposFilter) setImpliedRepo(repo string) { f.impliedRepo = repo }
+func (f *byRefDefReposFilter) SelectRef(ref *graph.Ref) bool {
+	defRepo := ref.DefRepo
+	if defRepo == "" {
+		defRepo = f.impliedRepo
+	}
+	if defRepo == "" {
+		return false
+	}
+	for _, repo := range f.repos {
+		if graph.URIEqual(repo, defRepo) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectsImpliedRepo returns whether the filter selects refs to defs
+// in the repo that it is being applied to. Those refs are stored with
+// an empty DefRepo, so an index keyed on DefRepo can't find them.
+func (f *byRefDefReposFilter) selectsImpliedRepo() bool {
+	if f.impliedRepo == "" {
+		return false
+	}
+	for _, repo := range f.repos {
+		if graph.URIEqual(repo, f.impliedRepo) {
+			return true
+		}
+	}
+	return false
+}
+
+var _ impliedRepoSetter = (*byRefDefReposFilter)(nil)
+
 // An AbsRefFilterFunc creates a RefFilter that selects only those
 // refs for which the func returns true. Unlike RefFilterFunc, the
 // ref's Def{Repo,UnitType,Unit,Path}, Repo, and CommitID fields are
@@ -652,6 +972,7 @@ type ByDefPathFilter interface {
 // empty.
 func ByDefPath(defPath string) interface {
 	DefFilter
+	AnnFilter
 	ByDefPathFilter
 } {
 	if defPath == "" {
@@ -667,6 +988,9 @@ func (f byDefPathFilter) ByDefPath() string { return string(f) }
 func (f byDefPathFilter) SelectDef(def *graph.Def) bool {
 	return def.Path == string(f)
 }
+func (f byDefPathFilter) SelectAnn(a *ann.Ann) bool {
+	return a.DefPath == string(f)
+}
 
 // ByDefQueryFilter is implemented by filters that restrict their
 // selection to defs whose names match the query.
@@ -708,6 +1032,7 @@ type ByFilesFilter interface {
 func ByFiles(files ...string) interface {
 	DefFilter
 	RefFilter
+	AnnFilter
 	UnitFilter
 	ByFilesFilter
 } {
@@ -742,6 +1067,14 @@ func (f byFilesFilter) SelectRef(ref *graph.Ref) bool {
 	}
 	return false
 }
+func (f byFilesFilter) SelectAnn(a *ann.Ann) bool {
+	for _, ff := range f {
+		if a.File == ff || strings.HasPrefix(a.File, ff+"/") {
+			return true
+		}
+	}
+	return false
+}
 func (f byFilesFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	for _, unitFile := range unit.Files {
 		for _, ff := range f {
@@ -753,6 +1086,163 @@ func (f byFilesFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	return false
 }
 
+// ByFilePrefix returns a filter that selects defs and refs in files
+// whose paths begin with prefix, and source units that contain any
+// such file. Unlike ByFiles, prefix need not be a whole path
+// component ("cli/store_" selects "cli/store_cmds.go"). Because it is
+// a UnitFilter, stores only read the source units that could contain
+// matches. It panics if prefix is empty.
+func ByFilePrefix(prefix string) interface {
+	DefFilter
+	RefFilter
+	AnnFilter
+	UnitFilter
+} {
+	if prefix == "" {
+		panic("ByFilePrefix: empty")
+	}
+	return byFilePrefixFilter(strings.TrimPrefix(prefix, "./"))
+}
+
+type byFilePrefixFilter string
+
+func (f byFilePrefixFilter) String() string { return fmt.Sprintf("ByFilePrefix(%q)", string(f)) }
+func (f byFilePrefixFilter) SelectDef(def *graph.Def) bool {
+	return strings.HasPrefix(def.File, string(f))
+}
+func (f byFilePrefixFilter) SelectRef(ref *graph.Ref) bool {
+	return strings.HasPrefix(ref.File, string(f))
+}
+func (f byFilePrefixFilter) SelectAnn(a *ann.Ann) bool {
+	return strings.HasPrefix(a.File, string(f))
+}
+func (f byFilePrefixFilter) SelectUnit(unit *unit.SourceUnit) bool {
+	for _, file := range unit.Files {
+		if strings.HasPrefix(file, string(f)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ByAnnTypes returns a filter that selects anns whose type is any of
+// types. It panics if types is empty.
+func ByAnnTypes(types ...string) AnnFilter {
+	if len(types) == 0 {
+		panic("ByAnnTypes: empty")
+	}
+	return byAnnTypesFilter(types)
+}
+
+type byAnnTypesFilter []string
+
+func (f byAnnTypesFilter) String() string { return fmt.Sprintf("ByAnnTypes(%v)", []string(f)) }
+func (f byAnnTypesFilter) SelectAnn(a *ann.Ann) bool {
+	for _, t := range f {
+		if a.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// ByFileRange returns a filter that selects anns in file whose byte
+// range overlaps [start, end) (e.g., the anns to overlay on the part
+// of a file that's shown). Anns attached only to a def, not to a
+// range of a file, aren't selected.
+func ByFileRange(file string, start, end uint32) AnnFilter {
+	return byFileRangeFilter{file: file, start: start, end: end}
+}
+
+type byFileRangeFilter struct {
+	file       string
+	start, end uint32
+}
+
+func (f byFileRangeFilter) String() string {
+	return fmt.Sprintf("ByFileRange(%s, %d, %d)", f.file, f.start, f.end)
+}
+func (f byFileRangeFilter) SelectAnn(a *ann.Ann) bool {
+	return a.File == f.file && a.Start < f.end && (a.End > f.start || (a.End == a.Start && a.Start >= f.start))
+}
+
+// ByKindAny returns a filter that selects defs whose kind is any of
+// kinds. No index covers def kinds, so it is applied as defs are
+// read. It panics if kinds is empty.
+func ByKindAny(kinds ...string) DefFilter {
+	if len(kinds) == 0 {
+		panic("ByKindAny: no kinds")
+	}
+	return byKindAnyFilter(kinds)
+}
+
+type byKindAnyFilter []string
+
+func (f byKindAnyFilter) String() string { return fmt.Sprintf("ByKindAny(%v)", []string(f)) }
+func (f byKindAnyFilter) SelectDef(def *graph.Def) bool {
+	for _, kind := range f {
+		if def.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ByNameRegexp returns a filter that selects defs whose names match
+// re.
+//
+// If re is anchored at the start of the name and begins with a
+// case-sensitive literal (as in "^New.*Store$"), the filter also
+// implements ByDefQueryFilter with that literal, so that stores use
+// the def query indexes to find candidate defs instead of reading all
+// of them. Like ByDefQuery, it then doesn't select local or unnamed
+// defs, which those indexes omit.
+func ByNameRegexp(re *regexp.Regexp) DefFilter {
+	f := byNameRegexpFilter{re}
+	if prefix := anchoredLiteralPrefix(re.String()); prefix != "" {
+		return byNameRegexpQueryFilter{f, prefix}
+	}
+	return f
+}
+
+type byNameRegexpFilter struct{ re *regexp.Regexp }
+
+func (f byNameRegexpFilter) String() string { return fmt.Sprintf("ByNameRegexp(%q)", f.re) }
+func (f byNameRegexpFilter) SelectDef(def *graph.Def) bool {
+	return f.re.MatchString(def.Name)
+}
+
+// byNameRegexpQueryFilter is a byNameRegexpFilter whose matches all
+// begin with prefix.
+type byNameRegexpQueryFilter struct {
+	byNameRegexpFilter
+	prefix string
+}
+
+func (f byNameRegexpQueryFilter) ByDefQuery() string { return f.prefix }
+
+// anchoredLiteralPrefix returns the literal (case-sensitive) string
+// that every match of expr must begin with, if expr is anchored at the
+// beginning of the text. Otherwise it returns "".
+func anchoredLiteralPrefix(expr string) string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	re = re.Simplify()
+	if re.Op != syntax.OpConcat || len(re.Sub) < 2 || re.Sub[0].Op != syntax.OpBeginText {
+		return ""
+	}
+	var prefix []rune
+	for _, sub := range re.Sub[1:] {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			break
+		}
+		prefix = append(prefix, sub.Rune...)
+	}
+	return string(prefix)
+}
+
 // Limit is an EXPERIMENTAL filter for limiting the number of
 // results. It is not correct because it assumes that if it is called
 // on an object, it gets to decide whether that object appears in the
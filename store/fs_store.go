@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
@@ -20,6 +21,7 @@ import (
 	"sort"
 
 	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/ann"
 	"sourcegraph.com/sourcegraph/srclib/graph"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
@@ -37,9 +39,14 @@ type fsMultiRepoStore struct {
 	fs rwvfs.WalkableFileSystem
 	FSMultiRepoStoreConf
 	repoStores
+
+	xrefsMu sync.Mutex // guards the xref index's files
 }
 
-var _ MultiRepoStoreImporterIndexer = (*fsMultiRepoStore)(nil)
+var (
+	_ MultiRepoStoreImporterIndexer = (*fsMultiRepoStore)(nil)
+	_ XRefStore                     = (*fsMultiRepoStore)(nil)
+)
 
 // NewFSMultiRepoStore creates a new repository store (that can be
 // imported into) that is backed by files on a filesystem.
@@ -132,9 +139,12 @@ func (s *fsMultiRepoStore) Repos(f ...RepoFilter) ([]string, error) {
 			}
 			after = s.fs.Join(paths[len(paths)-1]...)
 		}
-		repos = make([]string, len(allPaths))
-		for i, path := range allPaths {
-			repos[i] = s.PathToRepo(path)
+		repos = make([]string, 0, len(allPaths))
+		for _, path := range allPaths {
+			if len(path) == 1 && path[0] == xrefsDir {
+				continue
+			}
+			repos = append(repos, s.PathToRepo(path))
 		}
 	}
 
@@ -168,6 +178,9 @@ func (s *fsMultiRepoStore) openAllRepoStores() (map[string]RepoStore, error) {
 var _ repoStoreOpener = (*fsMultiRepoStore)(nil)
 
 func (s *fsMultiRepoStore) Import(repo, commitID string, unit *unit.SourceUnit, data graph.Output) error {
+	u := unitID2(unit)
+	xrefs := xrefsFromRefs(repo, commitID, u, data.Refs)
+
 	if unit != nil {
 		cleanForImport(&data, repo, unit.Type, unit.Name)
 	}
@@ -175,7 +188,10 @@ func (s *fsMultiRepoStore) Import(repo, commitID string, unit *unit.SourceUnit,
 	if err := rwvfs.MkdirAll(s.fs, subpath); err != nil {
 		return err
 	}
-	return s.openRepoStore(repo).(RepoImporter).Import(commitID, unit, data)
+	if err := s.openRepoStore(repo).(RepoImporter).Import(commitID, unit, data); err != nil {
+		return err
+	}
+	return s.putXRefs(repo, commitID, u, xrefs)
 }
 
 func (s *fsMultiRepoStore) Index(repo, commitID string) error {
@@ -227,9 +243,15 @@ func (s *fsRepoStore) versionDirs() ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	dirs := make([]string, len(entries))
-	for i, e := range entries {
-		dirs[i] = e.Name()
+	dirs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		// Skip files (such as the labels file written by `src
+		// store import --label`) and the hidden dirs alongside the
+		// version dirs (the blobs dir, and the dirs that commits
+		// are staged in by `src store import`).
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			dirs = append(dirs, e.Name())
+		}
 	}
 	return dirs, nil
 }
@@ -249,19 +271,49 @@ func (s *fsRepoStore) Index(commitID string) error {
 	return nil // nothing to do
 }
 
+// treeStoreFS returns the filesystem of the commit's dir, in which
+// files that DedupeCommits moved to blobs are read from the blobs.
 func (s *fsRepoStore) treeStoreFS(commitID string) rwvfs.FileSystem {
-	return rwvfs.Sub(s.fs, commitID)
+	return newBlobFS(rwvfs.Sub(s.fs, commitID), rwvfs.Sub(s.fs, blobsDir))
 }
 
 func (s *fsRepoStore) newTreeStore(commitID string) TreeStoreImporter {
 	fs := s.treeStoreFS(commitID)
 	if useIndexedStore {
-		cacheKey := fs.String()
+		cacheKey := treeStoreCacheKey{fs: fs.String(), generation: s.importGeneration(commitID)}
 		return newIndexedTreeStore(fs, cacheKey)
 	}
 	return newFSTreeStore(fs)
 }
 
+// treeStoreCacheKey identifies the indexes of a commit's tree store in
+// the index cache.
+type treeStoreCacheKey struct {
+	fs string
+
+	// generation changes each time that the commit is imported (see
+	// importGeneration), so that indexes cached by a long-running
+	// process (such as `src store serve`) before the commit was
+	// re-imported aren't used afterwards.
+	generation string
+}
+
+// importGeneration returns the contents of the commit's import time
+// file (see SetImportTime), which every import rewrites, or "" if it
+// has none.
+func (s *fsRepoStore) importGeneration(commitID string) string {
+	f, err := s.fs.Open(path.Join(commitID, importTimeFile))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
 func (s *fsRepoStore) openTreeStore(commitID string) TreeStore {
 	return s.newTreeStore(commitID)
 }
@@ -332,6 +384,17 @@ func (s *fsTreeStore) Units(f ...UnitFilter) ([]*unit.SourceUnit, error) {
 	return units, nil
 }
 
+// Refs implements UnitStore. It resolves ByRefDefFiles filters (by
+// finding the defs defined in their files) before querying the
+// source unit stores.
+func (s *fsTreeStore) Refs(fs ...RefFilter) ([]*graph.Ref, error) {
+	fs, err := resolveRefDefFiles(s, fs)
+	if err != nil {
+		return nil, err
+	}
+	return s.unitStores.Refs(fs...)
+}
+
 func (s *fsTreeStore) openUnitFile(filename string) (u *unit.SourceUnit, err error) {
 	f, err := s.fs.Open(filename)
 	if err != nil {
@@ -413,6 +476,19 @@ func (s *fsTreeStore) openUnitStore(u unit.ID2) UnitStore {
 	return &fsUnitStore{fs: rwvfs.Sub(s.fs, dir), label: u.String()}
 }
 
+// openFSUnitStore is like openUnitStore, but it returns the
+// underlying fsUnitStore (which reads the unit's data files directly)
+// even if the unit store is indexed.
+func (s *fsTreeStore) openFSUnitStore(u unit.ID2) *fsUnitStore {
+	switch us := s.openUnitStore(u).(type) {
+	case *indexedUnitStore:
+		return us.fsUnitStore
+	case *fsUnitStore:
+		return us
+	}
+	panic("unreachable")
+}
+
 func (s *fsTreeStore) openAllUnitStores() (map[unit.ID2]UnitStore, error) {
 	unitFiles, err := s.unitFilenames()
 	if err != nil {
@@ -452,6 +528,7 @@ type fsUnitStore struct {
 const (
 	unitDefsFilename = "def.dat"
 	unitRefsFilename = "ref.dat"
+	unitAnnsFilename = "ann.dat"
 )
 
 func (s *fsUnitStore) Defs(fs ...DefFilter) (defs []*graph.Def, err error) {
@@ -459,8 +536,18 @@ func (s *fsUnitStore) Defs(fs ...DefFilter) (defs []*graph.Def, err error) {
 		return s.defsAtOffsets(byteOffsets(f), fs)
 	}
 
+	if hasDefTreeFilter(fs) {
+		// A def's parent depends on the unit's other defs, so read
+		// them all before selecting any.
+		all, _, err := s.readDefs()
+		if err != nil {
+			return nil, err
+		}
+		fs = withDefParents(fs, defParents(all))
+	}
+
 	vlog.Printf("%s: reading defs with filters %v...", s, fs)
-	f, err := s.fs.Open(unitDefsFilename)
+	f, err := openDataFile(s.fs, unitDefsFilename)
 	if err != nil {
 		return nil, err
 	}
@@ -556,7 +643,7 @@ func (s *fsUnitStore) defsAtOffsets(ofs byteOffsets, fs []DefFilter) (defs []*gr
 // along with their serialized byte offsets.
 func (s *fsUnitStore) readDefs() (defs []*graph.Def, ofs byteOffsets, err error) {
 	vlog.Printf("%s: reading defs and byte offsets...", s)
-	f, err := s.fs.Open(unitDefsFilename)
+	f, err := openDataFile(s.fs, unitDefsFilename)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -589,7 +676,7 @@ func (s *fsUnitStore) readDefs() (defs []*graph.Def, ofs byteOffsets, err error)
 
 func (s *fsUnitStore) Refs(fs ...RefFilter) (refs []*graph.Ref, err error) {
 	vlog.Printf("%s: reading refs with filters %v...", s, fs)
-	f, err := s.fs.Open(unitRefsFilename)
+	f, err := openDataFile(s.fs, unitRefsFilename)
 	if err != nil {
 		return nil, err
 	}
@@ -771,14 +858,26 @@ func parFetches(fs rwvfs.FileSystem, filters interface{}) int {
 // FetcherOpener interface; otherwise it calls fs.Open.
 func openFetcherOrOpen(fs rwvfs.FileSystem, name string) (vfs.ReadSeekCloser, error) {
 	if fo, ok := fs.(rwvfs.FetcherOpener); ok {
-		return fo.OpenFetcher(name)
+		f, err := fo.OpenFetcher(name)
+		if err != nil {
+			return nil, err
+		}
+		return maybeDecompress(f)
 	}
-	return fs.Open(name)
+	return openDataFile(fs, name)
 }
 
 // rangeReader calls ioutil.ReadAll on the given byte range [start, n). It uses
 // optimizations for different kinds of VFSs.
 func rangeReader(fs rwvfs.FileSystem, name string, f io.ReadSeeker, start, n int64) (io.Reader, error) {
+	switch f := f.(type) {
+	case decompressedFile:
+		// Already in memory (and possibly shared by parallel
+		// readers, so don't seek).
+		return io.NewSectionReader(f, start, f.Size()-start), nil
+	case mappedFile:
+		return io.NewSectionReader(f, start, f.Size()-start), nil
+	}
 	if fs, ok := fs.(rwvfs.FetcherOpener); ok {
 		// Clone f so we can parallelize it.
 		var err error
@@ -800,7 +899,7 @@ func rangeReader(fs rwvfs.FileSystem, name string, f io.ReadSeeker, start, n int
 // along with their serialized byte offsets.
 func (s *fsUnitStore) readRefs() (refs []*graph.Ref, fbrs fileByteRanges, ofs byteOffsets, err error) {
 	vlog.Println("fsUnitStore: reading all refs and byte ranges...")
-	f, err := s.fs.Open(unitRefsFilename)
+	f, err := openDataFile(s.fs, unitRefsFilename)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -859,6 +958,9 @@ func (s *fsUnitStore) Import(data graph.Output) error {
 	if _, _, err := s.writeRefs(data.Refs); err != nil {
 		return err
 	}
+	if err := s.writeAnns(data.Anns); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -867,7 +969,7 @@ func (s *fsUnitStore) Import(data graph.Output) error {
 // begins (which is used during index construction).
 func (s *fsUnitStore) writeDefs(defs []*graph.Def) (ofs byteOffsets, err error) {
 	vlog.Printf("%s: writing %d defs...", s, len(defs))
-	f, err := s.fs.Create(unitDefsFilename)
+	f, err := createDataFile(s.fs, unitDefsFilename)
 	if err != nil {
 		return nil, err
 	}
@@ -900,7 +1002,7 @@ func (s *fsUnitStore) writeDefs(defs []*graph.Def) (ofs byteOffsets, err error)
 // writeDefs writes the ref data file.
 func (s *fsUnitStore) writeRefs(refs []*graph.Ref) (fbr fileByteRanges, ofs byteOffsets, err error) {
 	vlog.Printf("%s: writing %d refs...", s, len(refs))
-	f, err := s.fs.Create(unitRefsFilename)
+	f, err := createDataFile(s.fs, unitRefsFilename)
 	if err != nil {
 		return nil, ofs, err
 	}
@@ -957,6 +1059,73 @@ func (s *fsUnitStore) writeRefs(refs []*graph.Ref) (fbr fileByteRanges, ofs byte
 	return fbr, ofs, nil
 }
 
+// writeAnns writes the ann data file. Most units have no anns, so
+// the file is only written (and an old one is removed) if needed.
+func (s *fsUnitStore) writeAnns(anns []*ann.Ann) (err error) {
+	if len(anns) == 0 {
+		if err := s.fs.Remove(unitAnnsFilename); err != nil && !isOSOrVFSNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	vlog.Printf("%s: writing %d anns...", s, len(anns))
+	f, err := createDataFile(s.fs, unitAnnsFilename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+
+	sort.Sort(ann.Anns(anns))
+	bw := bufio.NewWriter(f)
+	enc := Codec.NewEncoder(bw)
+	for _, a := range anns {
+		if _, err := enc.Encode(a); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Anns implements AnnStore.
+func (s *fsUnitStore) Anns(fs ...AnnFilter) (anns []*ann.Ann, err error) {
+	vlog.Printf("%s: reading anns with filters %v...", s, fs)
+	f, err := openDataFile(s.fs, unitAnnsFilename)
+	if isOSOrVFSNotExist(err) {
+		// The unit has no anns (or was imported before anns were
+		// stored).
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+
+	dec := Codec.NewDecoder(f)
+	for {
+		var a ann.Ann
+		if _, err := dec.Decode(&a); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if annFilters(fs).SelectAnn(&a) {
+			anns = append(anns, &a)
+		}
+	}
+	vlog.Printf("%s: read %d anns with filters %v.", s, len(anns), fs)
+	return anns, nil
+}
+
 func (s *fsUnitStore) String() string { return fmt.Sprintf("fsUnitStore(%v)", s.label) }
 
 // countingWriter wraps an io.Writer, counting the number of bytes
@@ -8,7 +8,7 @@ import (
 	"sourcegraph.com/sourcegraph/rwvfs"
 )
 
-var fsType = flag.String("test.fs", "map", "vfs type to use for tests (map|os)")
+var fsType = flag.String("test.fs", "map", "vfs type to use for tests (map|os|bolt)")
 
 func newTestFS() rwvfs.WalkableFileSystem {
 	switch *fsType {
@@ -23,6 +23,16 @@ func newTestFS() rwvfs.WalkableFileSystem {
 		fs := rwvfs.OS(tmpDir)
 		setCreateParentDirs(fs)
 		return rwvfs.Walkable(fs)
+	case "bolt":
+		tmpDir, err := ioutil.TempDir("", "srclib-test")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fs, err := BoltBackend{}.Open(tmpDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return rwvfs.Walkable(rwvfs.Sub(fs, "/testdata"))
 	default:
 		log.Fatalf("unrecognized -test.fs option: %q", *fsType)
 		panic("unreachable")
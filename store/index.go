@@ -8,6 +8,7 @@ import (
 	"log"
 
 	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store/phtable"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
 
@@ -48,6 +49,30 @@ type persistedIndex interface {
 	Read(io.Reader) error
 }
 
+// A mappedIndex is a persistedIndex whose serialized data is a
+// phtable, which can be used in place in a memory-mapped index file
+// instead of being read onto the heap. Its index file is written
+// uncompressed, so that it can be mapped.
+type mappedIndex interface {
+	persistedIndex
+
+	// Map populates an index from m, which holds the same data that
+	// the index previously wrote (using Write).
+	Map(m *phtable.Mapping) error
+}
+
+var (
+	_ mappedIndex = (*defPathIndex)(nil)
+	_ mappedIndex = (*defFilesIndex)(nil)
+	_ mappedIndex = (*defParentsIndex)(nil)
+	_ mappedIndex = (*defRefsIndex)(nil)
+	_ mappedIndex = (*defRefUnitsIndex)(nil)
+	_ mappedIndex = (*defFileRefsIndex)(nil)
+	_ mappedIndex = (*defRepoRefsIndex)(nil)
+	_ mappedIndex = (*refFileIndex)(nil)
+	_ mappedIndex = (*unitFilesIndex)(nil)
+)
+
 // The rest of this file contains helpers used by many index
 // implementations.
 
@@ -147,6 +172,13 @@ type defTreeIndex interface {
 	Defs(...DefFilter) (map[unit.ID2]byteOffsets, error)
 }
 
+type refTreeIndex interface {
+	// Refs returns the source units and byte offsets (within the
+	// source unit ref data file) of the refs that match the ref
+	// filters.
+	Refs(...RefFilter) (map[unit.ID2]byteOffsets, error)
+}
+
 // bestCoverageIndex returns the index that has the greatest coverage
 // for the given filters, or nil if no indexes have any coverage. If
 // test != nil, only indexes for which test(x) is true are considered.
@@ -169,6 +201,7 @@ func bestCoverageIndex(indexes map[string]Index, filters interface{}, test func(
 func isUnitIndex(x interface{}) bool    { _, ok := x.(unitIndex); return ok }
 func isDefIndex(x interface{}) bool     { _, ok := x.(defIndex); return ok }
 func isDefTreeIndex(x interface{}) bool { _, ok := x.(defTreeIndex); return ok }
+func isRefTreeIndex(x interface{}) bool { _, ok := x.(refTreeIndex); return ok }
 func isRefIndex(x interface{}) bool {
 	switch x.(type) {
 	case refIndexByteRanges, refIndexByteOffsets:
@@ -282,6 +315,12 @@ type defQueryTreeIndexBuilder interface {
 	Build(map[unit.ID2]*defQueryIndex) error
 }
 
+type unitDataIndexBuilder interface {
+	// Build constructs the index in memory from the def and ref data
+	// files of each of the source units.
+	Build(units []*unit.SourceUnit, readDefs func(unit.ID2) ([]*graph.Def, error), readRefs func(unit.ID2) ([]*graph.Ref, byteOffsets, error)) error
+}
+
 // unitIndexOnlyFilter wraps a non-UnitFilter that can be used by an
 // IndexedUnitStore to scope the list of source units. Currently there
 // is only a RefFilter that does this, so we simplify it by using that
@@ -354,6 +393,66 @@ func (f unitDefOffsetsFilter) SelectDef(*graph.Def) bool {
 	return true
 }
 
+// unitRefOffsetsFilter is an internal filter used by indexes. It
+// selects only refs at certain byte offsets in certain source units.
+type unitRefOffsetsFilter map[unit.ID2]byteOffsets
+
+var _ interface {
+	ByUnitsFilter
+	UnitFilter
+	RefFilter
+} = (*unitRefOffsetsFilter)(nil)
+
+func (f unitRefOffsetsFilter) String() string {
+	return fmt.Sprintf("unitRefOffsetsFilter(%v)", map[unit.ID2]byteOffsets(f))
+}
+
+func (f unitRefOffsetsFilter) ByUnits() []unit.ID2 {
+	units := make([]unit.ID2, 0, len(f))
+	for u := range f {
+		units = append(units, u)
+	}
+	return units
+}
+
+func (f unitRefOffsetsFilter) SelectUnit(u *unit.SourceUnit) bool {
+	_, present := f[u.ID2()]
+	return present
+}
+
+func (f unitRefOffsetsFilter) SelectRef(*graph.Ref) bool {
+	// Index-only filter (see unitDefOffsetsFilter.SelectDef).
+	return true
+}
+
+// refOffsetsFilter is an internal filter used by indexes. It selects
+// only refs at certain byte offsets in the ref.dat file.
+type refOffsetsFilter byteOffsets
+
+var _ interface {
+	RefFilter
+} = (*refOffsetsFilter)(nil)
+
+func (f refOffsetsFilter) String() string {
+	return fmt.Sprintf("refOffsetsFilter(%v)", byteOffsets(f))
+}
+
+func (f refOffsetsFilter) SelectRef(*graph.Ref) bool {
+	// Index-only filter (see defOffsetsFilter.SelectDef).
+	return true
+}
+
+// getRefOffsetsFilter returns a refOffsetsFilter in fs, if any
+// exists. Otherwise it returns nil.
+func getRefOffsetsFilter(fs []RefFilter) refOffsetsFilter {
+	for _, f := range fs {
+		if f, ok := f.(refOffsetsFilter); ok {
+			return f
+		}
+	}
+	return nil
+}
+
 // defOffsetsFilter is an internal filter used by indexes. It
 // selects only defs at certain byte offsets in the def.dat file.
 type defOffsetsFilter byteOffsets
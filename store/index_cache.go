@@ -95,7 +95,9 @@ func (c *indexCache) cachePut(store cacheableIndexStore, name string, index Inde
 	el := indexCacheElement{key: key, index: index}
 	c.indexes[key] = c.lru.PushFront(el)
 
-	// Evict least recently used
+	// Evict least recently used. The evicted index isn't closed,
+	// since queries may still be using it; if it's mapped, the
+	// mapping is unmapped when the index is garbage-collected.
 	if c.lru.Len() > c.maxLen {
 		dead := c.lru.Back()
 		deadKey := dead.Value.(indexCacheElement).key
@@ -3,7 +3,14 @@ package store
 import (
 	"container/list"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
 )
 
 type mockCacheableIndexStore struct{}
@@ -91,3 +98,48 @@ func TestLRU(t *testing.T) {
 		}
 	}
 }
+
+func TestFSRepoStore_reimportInvalidatesIndexCache(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "srclib-index-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	fs, err := FSBackend{}.Open(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := NewFSRepoStore(rwvfs.Walkable(fs))
+
+	importUnit := func(name string, imported time.Time) {
+		data := graph.Output{Defs: []*graph.Def{{DefKey: graph.DefKey{Path: name}, Name: name, File: name + ".go"}}}
+		if err := rs.Import("c", &unit.SourceUnit{Type: "t", Name: name, Files: []string{name + ".go"}}, data); err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.(RepoIndexer).Index("c"); err != nil {
+			t.Fatal(err)
+		}
+		if err := SetImportTime(rs, "", "c", imported); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Defs in files are found using the commit's file_to_units index.
+	defs := func() int {
+		defs, err := rs.Defs(ByCommitIDs("c"), ByFiles("u1.go", "u2.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(defs)
+	}
+
+	// The first query caches the commit's indexes, which mustn't be
+	// used after the commit is imported again.
+	importUnit("u1", time.Unix(1, 0))
+	if n := defs(); n != 1 {
+		t.Fatalf("got %d defs, want 1", n)
+	}
+	importUnit("u2", time.Unix(2, 0))
+	if n := defs(); n != 2 {
+		t.Errorf("after re-import, got %d defs, want 2", n)
+	}
+}
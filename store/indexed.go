@@ -1,11 +1,16 @@
 package store
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
-	"runtime"
 
 	"golang.org/x/tools/godoc/vfs"
 
@@ -19,6 +24,7 @@ import (
 
 	"sourcegraph.com/sourcegraph/rwvfs"
 	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store/phtable"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
 
@@ -63,7 +69,9 @@ var _ interface {
 } = (*indexedTreeStore)(nil)
 
 const (
-	unitsIndexName = "units"
+	unitsIndexName       = "units"
+	defFileRefsIndexName = "def_file_to_refs"
+	defRepoRefsIndexName = "def_repo_to_refs"
 )
 
 // newIndexedTreeStore creates a new indexed tree store that stores
@@ -74,6 +82,8 @@ func newIndexedTreeStore(fs rwvfs.FileSystem, cacheKey interface{}) TreeStoreImp
 			"file_to_units":       &unitFilesIndex{},
 			"def_to_ref_units":    &defRefUnitsIndex{},
 			"def_query_to_defs16": &defQueryTreeIndex{},
+			defFileRefsIndexName:  &defFileRefsIndex{},
+			defRepoRefsIndexName:  &defRepoRefsIndex{},
 			unitsIndexName:        &unitsIndex{},
 		},
 		cacheKey:    cacheKey,
@@ -112,12 +122,13 @@ func (s *indexedTreeStore) unitIDs(indexOnly bool, fs ...UnitFilter) ([]unit.ID2
 		if !bx.Ready() {
 			bx = cacheGet(s, xname, bx)
 		}
-		if err := prepareIndex(s.fs, xname, bx); err != nil {
+		if err := prepareIndex(s.fs, xname, bx); err == nil {
+			cachePut(s, xname, bx)
+			vlog.Printf("indexedTreeStore.unitIDs(%v): Found covering index %q (%v).", fs, xname, bx)
+			return bx.(unitIndex).Units(fs...)
+		} else if !isIndexCorrupt(err) {
 			return nil, err
 		}
-		cachePut(s, xname, bx)
-		vlog.Printf("indexedTreeStore.unitIDs(%v): Found covering index %q (%v).", fs, xname, bx)
-		return bx.(unitIndex).Units(fs...)
 	}
 	if indexOnly {
 		return nil, errNotIndexed
@@ -159,7 +170,9 @@ func (s *indexedTreeStore) Units(fs ...UnitFilter) ([]*unit.SourceUnit, error) {
 
 func (s *indexedTreeStore) unitsUsingFullIndex(fs ...UnitFilter) ([]*unit.SourceUnit, error) {
 	x := s.indexes[unitsIndexName]
-	if err := prepareIndex(s.fs, unitsIndexName, x); err != nil {
+	if err := prepareIndex(s.fs, unitsIndexName, x); isIndexCorrupt(err) {
+		return s.fsTreeStore.Units(fs...)
+	} else if err != nil {
 		return nil, err
 	}
 	return x.(unitFullIndex).Units(fs...)
@@ -169,17 +182,20 @@ func (s *indexedTreeStore) Defs(fs ...DefFilter) ([]*graph.Def, error) {
 	vlog.Printf("indexedTreeStore.Defs(%v)", fs)
 
 	// First, check if any defs indexes at the tree level cover this
-	// query.
-	if xname, bx := bestCoverageIndex(s.indexes, fs, isDefTreeIndex); bx != nil {
-		if err := prepareIndex(s.fs, xname, bx); err != nil {
-			return nil, err
-		}
-		vlog.Printf("indexedTreeStore.Defs(%v): Found covering index %q (%v).", fs, xname, bx)
-		uoffs, err := bx.(defTreeIndex).Defs(fs...)
-		if err != nil {
+	// query. They can't select defs by their place in a unit's tree
+	// of defs (only unit stores can), so they're skipped for
+	// ByDefParent and ByDefChild queries.
+	if xname, bx := bestCoverageIndex(s.indexes, fs, isDefTreeIndex); bx != nil && !hasDefTreeFilter(fs) {
+		if err := prepareIndex(s.fs, xname, bx); err == nil {
+			vlog.Printf("indexedTreeStore.Defs(%v): Found covering index %q (%v).", fs, xname, bx)
+			uoffs, err := bx.(defTreeIndex).Defs(fs...)
+			if err != nil {
+				return nil, err
+			}
+			fs = append(fs, unitDefOffsetsFilter(uoffs))
+		} else if !isIndexCorrupt(err) {
 			return nil, err
 		}
-		fs = append(fs, unitDefOffsetsFilter(uoffs))
 	}
 
 	// We have File->Unit index (that tells us which source units
@@ -222,6 +238,31 @@ func (s *indexedTreeStore) Defs(fs ...DefFilter) ([]*graph.Def, error) {
 }
 
 func (s *indexedTreeStore) Refs(fs ...RefFilter) ([]*graph.Ref, error) {
+	// First, check if any refs indexes at the tree level cover this
+	// query. A ByRefDefFiles filter that such an index covers can
+	// only be applied by the index (or by the fsTreeStore after it
+	// resolves the filter), so it is replaced by the index results.
+	// Other filters (such as ByRefDefRepos) are left in place; they
+	// still select the right refs from the indexed offsets.
+	if xname, bx := bestCoverageIndex(s.indexes, fs, isRefTreeIndex); bx != nil {
+		if err := prepareIndex(s.fs, xname, bx); err == nil {
+			vlog.Printf("indexedTreeStore.Refs(%v): Found covering index %q (%v).", fs, xname, bx)
+			uoffs, err := bx.(refTreeIndex).Refs(fs...)
+			if err != nil {
+				return nil, err
+			}
+			fsCopy := make([]RefFilter, 0, len(fs)+1)
+			for _, f := range fs {
+				if _, ok := f.(ByRefDefFilesFilter); !ok {
+					fsCopy = append(fsCopy, f)
+				}
+			}
+			fs = append(fsCopy, unitRefOffsetsFilter(uoffs))
+		} else if !isIndexCorrupt(err) {
+			return nil, err
+		}
+	}
+
 	// We have File->Unit index (that tells us which source units
 	// include a given file). If there's a ByFiles RefFilter, then we
 	// can convert that filter into a ByUnits scope filter (which is
@@ -371,7 +412,7 @@ func (s *indexedTreeStore) buildIndexes(xs map[string]Index, units []*unit.Sourc
 				}
 
 				unitRefIndexes = make(map[unit.ID2]*defRefsIndex, len(units))
-				par := parallel.NewRun(runtime.GOMAXPROCS(0))
+				par := parallel.NewRun(indexJobs())
 				for u_, us_ := range uss {
 					u := u_
 					us, ok := us_.(*indexedUnitStore)
@@ -421,7 +462,7 @@ func (s *indexedTreeStore) buildIndexes(xs map[string]Index, units []*unit.Sourc
 				}
 
 				unitDefQueryIndexes = make(map[unit.ID2]*defQueryIndex, len(units))
-				par := parallel.NewRun(runtime.GOMAXPROCS(0))
+				par := parallel.NewRun(indexJobs())
 				for u_, us_ := range uss {
 					u := u_
 					us, ok := us_.(*indexedUnitStore)
@@ -449,7 +490,7 @@ func (s *indexedTreeStore) buildIndexes(xs map[string]Index, units []*unit.Sourc
 		return unitDefQueryIndexes, getUnitDefQueryIndexesErr
 	}
 
-	par := parallel.NewRun(runtime.GOMAXPROCS(0))
+	par := parallel.NewRun(indexJobs())
 	for name_, x_ := range xs {
 		name, x := name_, x_
 		par.Do(func() error {
@@ -478,6 +519,22 @@ func (s *indexedTreeStore) buildIndexes(xs map[string]Index, units []*unit.Sourc
 				if err := x.Build(unitDefQueryIndexes); err != nil {
 					return err
 				}
+			case unitDataIndexBuilder:
+				units, err := getUnits()
+				if err != nil {
+					return err
+				}
+				readDefs := func(u unit.ID2) ([]*graph.Def, error) {
+					defs, _, err := s.fsTreeStore.openFSUnitStore(u).readDefs()
+					return defs, err
+				}
+				readRefs := func(u unit.ID2) ([]*graph.Ref, byteOffsets, error) {
+					refs, _, ofs, err := s.fsTreeStore.openFSUnitStore(u).readRefs()
+					return refs, ofs, err
+				}
+				if err := x.Build(units, readDefs, readRefs); err != nil {
+					return err
+				}
 			default:
 				return fmt.Errorf("don't know how to build index %q of type %T", name, x)
 			}
@@ -532,17 +589,23 @@ func newIndexedUnitStore(fs rwvfs.FileSystem, label string) UnitStoreImporter {
 				},
 				perFile: 7,
 			},
-			defToRefsIndexName: &defRefsIndex{},
-			defQueryIndexName:  &defQueryIndex{f: defQueryFilter},
+			defToRefsIndexName:   &defRefsIndex{},
+			defQueryIndexName:    &defQueryIndex{f: defQueryFilter},
+			defSearchIndexName:   &defSearchIndex{},
+			defDocTitleIndexName: &defSearchIndex{docTitles: true},
+			defParentsIndexName:  &defParentsIndex{},
 		},
 		fsUnitStore: &fsUnitStore{fs: fs, label: label},
 	}
 }
 
 const (
-	defToRefsIndexName = "def_to_refs"
-	defQueryIndexName  = "def_query"
-	indexFilename      = "%s.idx"
+	defToRefsIndexName   = "def_to_refs"
+	defQueryIndexName    = "def_query"
+	defSearchIndexName   = "def_search"
+	defDocTitleIndexName = "def_doc_title"
+	defParentsIndexName  = "def_parents"
+	indexFilename        = "%s.idx"
 )
 
 func (s *indexedUnitStore) Defs(fs ...DefFilter) ([]*graph.Def, error) {
@@ -550,17 +613,36 @@ func (s *indexedUnitStore) Defs(fs ...DefFilter) ([]*graph.Def, error) {
 	// consulting an index (since it already gives us the byte
 	// offsets).
 	if hasDefOffsetsFilter := getDefOffsetsFilter(fs) != nil; !hasDefOffsetsFilter {
-		// Try to find an index that covers this query.
-		if xname, bx := bestCoverageIndex(s.indexes, fs, isDefIndex); bx != nil {
-			if err := prepareIndex(s.fs, xname, bx); err != nil {
+		if hasDefTreeFilter(fs) {
+			// Only the def_parents index can select defs by their
+			// place in the unit's tree of defs; other indexes would
+			// return offsets of defs that the filters (which aren't
+			// resolved here) then reject.
+			x := s.indexes[defParentsIndexName]
+			if err := prepareIndex(s.fs, defParentsIndexName, x); err == nil {
+				ofs, err := x.(defIndex).Defs(fs...)
+				if err != nil {
+					return nil, err
+				}
+				return s.defsAtOffsets(ofs, withoutDefTreeFilters(fs))
+			} else if !isIndexCorrupt(err) {
 				return nil, err
 			}
-			vlog.Printf("indexedUnitStore.Defs(%v): Found covering index %q (%v).", fs, xname, bx)
-			ofs, err := bx.(defIndex).Defs(fs...)
-			if err != nil {
+			return s.fsUnitStore.Defs(fs...)
+		}
+
+		// Try to find an index that covers this query.
+		if xname, bx := bestCoverageIndex(s.indexes, fs, isDefIndex); bx != nil {
+			if err := prepareIndex(s.fs, xname, bx); err == nil {
+				vlog.Printf("indexedUnitStore.Defs(%v): Found covering index %q (%v).", fs, xname, bx)
+				ofs, err := bx.(defIndex).Defs(fs...)
+				if err != nil {
+					return nil, err
+				}
+				return s.defsAtOffsets(ofs, fs)
+			} else if !isIndexCorrupt(err) {
 				return nil, err
 			}
-			return s.defsAtOffsets(ofs, fs)
 		}
 	}
 
@@ -570,25 +652,32 @@ func (s *indexedUnitStore) Defs(fs ...DefFilter) ([]*graph.Def, error) {
 
 // Refs implements UnitStore.
 func (s *indexedUnitStore) Refs(fs ...RefFilter) ([]*graph.Ref, error) {
+	// If there's a refOffsetsFilter, it already gives us the byte
+	// offsets.
+	if ofs := getRefOffsetsFilter(fs); ofs != nil {
+		return s.refsAtOffsets(byteOffsets(ofs), fs)
+	}
+
 	// Try to find an index that covers this query.
 	if xname, bx := bestCoverageIndex(s.indexes, fs, isRefIndex); bx != nil {
-		if err := prepareIndex(s.fs, xname, bx); err != nil {
-			return nil, err
-		}
-		vlog.Printf("indexedUnitStore.Refs(%v): Found covering index %q (%v).", fs, xname, bx)
-		switch bx := bx.(type) {
-		case refIndexByteRanges:
-			brs, err := bx.Refs(fs...)
-			if err != nil {
-				return nil, err
-			}
-			return s.refsAtByteRanges(brs, fs)
-		case refIndexByteOffsets:
-			ofs, err := bx.Refs(fs...)
-			if err != nil {
-				return nil, err
+		if err := prepareIndex(s.fs, xname, bx); err == nil {
+			vlog.Printf("indexedUnitStore.Refs(%v): Found covering index %q (%v).", fs, xname, bx)
+			switch bx := bx.(type) {
+			case refIndexByteRanges:
+				brs, err := bx.Refs(fs...)
+				if err != nil {
+					return nil, err
+				}
+				return s.refsAtByteRanges(brs, fs)
+			case refIndexByteOffsets:
+				ofs, err := bx.Refs(fs...)
+				if err != nil {
+					return nil, err
+				}
+				return s.refsAtOffsets(ofs, fs)
 			}
-			return s.refsAtOffsets(ofs, fs)
+		} else if !isIndexCorrupt(err) {
+			return nil, err
 		}
 	}
 
@@ -596,15 +685,15 @@ func (s *indexedUnitStore) Refs(fs ...RefFilter) ([]*graph.Ref, error) {
 	return s.fsUnitStore.Refs(fs...)
 }
 
-// Import calls to the underlying fsUnitStore to write the def
-// and ref data files. It also builds and writes the indexes.
+// Import calls to the underlying fsUnitStore to write the def,
+// ref, and ann data files. It also builds and writes the indexes.
 func (s *indexedUnitStore) Import(data graph.Output) error {
 	cleanForImport(&data, "", "", "")
 
 	var defOfs, refOfs byteOffsets
 	var refFBRs fileByteRanges
 
-	par := parallel.NewRun(2)
+	par := parallel.NewRun(3)
 	par.Do(func() (err error) {
 		defOfs, err = s.fsUnitStore.writeDefs(data.Defs)
 		return err
@@ -613,14 +702,29 @@ func (s *indexedUnitStore) Import(data graph.Output) error {
 		refFBRs, refOfs, err = s.fsUnitStore.writeRefs(data.Refs)
 		return err
 	})
+	par.Do(func() error {
+		return s.fsUnitStore.writeAnns(data.Anns)
+	})
 	if err := par.Wait(); err != nil {
 		return err
 	}
 
-	if err := s.buildIndexes(s.Indexes(), &data, defOfs, refFBRs, refOfs); err != nil {
+	if SharedIndexDir == "" {
+		return s.buildIndexes(s.Indexes(), &data, defOfs, refFBRs, refOfs)
+	}
+
+	key, err := sharedIndexKey(s.fs)
+	if err != nil {
 		return err
 	}
-	return nil
+	xs, err := copySharedIndexes(s.fs, key, s.Indexes())
+	if err != nil {
+		return err
+	}
+	if err := s.buildIndexes(xs, &data, defOfs, refFBRs, refOfs); err != nil {
+		return err
+	}
+	return shareIndexes(s.fs, key, xs)
 }
 
 func (s *indexedUnitStore) Indexes() map[string]Index { return s.indexes }
@@ -680,7 +784,7 @@ func (s *indexedUnitStore) buildIndexes(xs map[string]Index, data *graph.Output,
 		return refs, refFBRs, refOfs, getRefsErr
 	}
 
-	par := parallel.NewRun(runtime.GOMAXPROCS(0))
+	par := parallel.NewRun(indexJobs())
 	for name_, x_ := range xs {
 		name, x := name_, x_
 		par.Do(func() error {
@@ -721,10 +825,22 @@ func (s *indexedUnitStore) statIndex(name string) (os.FileInfo, error) {
 
 func (s *indexedUnitStore) String() string { return "indexedUnitStore" }
 
-// writeIndex calls x.Write with the index's backing file.
+// writeIndex calls x.Write with the index's backing file. Index files
+// are gzipped, except for those of mapped indexes, which are written
+// uncompressed (followed by their data's CRC-32 checksum) so they can
+// be mapped into memory when they're read.
 func writeIndex(fs rwvfs.FileSystem, name string, x persistedIndex) (err error) {
 	vlog.Printf("%s: writing index...", name)
-	f, err := fs.Create(fmt.Sprintf(indexFilename, name))
+	filename := fmt.Sprintf(indexFilename, name)
+	_, mapped := x.(mappedIndex)
+	if mapped {
+		// The old index file may be mapped (by this or another
+		// process), and truncating it would make reads of the
+		// mapping fault, so replace it with a new file. Create
+		// reports any real problem with writing the file.
+		fs.Remove(filename)
+	}
+	f, err := fs.Create(filename)
 	if err != nil {
 		return err
 	}
@@ -735,17 +851,32 @@ func writeIndex(fs rwvfs.FileSystem, name string, x persistedIndex) (err error)
 		}
 	}()
 
-	w := gzip.NewWriter(f)
+	if mapped {
+		w := bufio.NewWriter(f)
+		h := crc32.NewIEEE()
+		if err := x.Write(io.MultiWriter(w, h)); err != nil {
+			return err
+		}
+		if _, err := w.Write(h.Sum(nil)); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	} else {
+		w := gzip.NewWriter(f)
 
-	if err := x.Write(w); err != nil {
-		return err
-	}
-	if err := w.Flush(); err != nil {
-		return err
-	}
-	if err := w.Close(); err != nil {
-		return err
+		if err := x.Write(w); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
 	}
+	unquarantineIndex(fs, name)
 	vlog.Printf("%s: done writing index.", name)
 	return nil
 }
@@ -754,12 +885,23 @@ func writeIndex(fs rwvfs.FileSystem, name string, x persistedIndex) (err error)
 // nothing happens. If it's not Ready and it's a persistedIndex,
 // prepareIndex calls readIndex(fs, name, x). Otherwise an
 // *errIndexNotReady is returned.
+//
+// If the index's persisted data is corrupt (or was found to be
+// corrupt earlier), the index is quarantined and an *errIndexCorrupt
+// is returned. Callers should fall back to a full scan in that case.
 func prepareIndex(fs rwvfs.FileSystem, name string, x Index) error {
 	if x.Ready() {
 		return nil
 	}
+	if err := quarantinedIndex(fs, name); err != nil {
+		return err
+	}
 	if x, ok := x.(persistedIndex); ok {
-		return readIndex(fs, name, x)
+		err := readIndex(fs, name, x)
+		if err, ok := err.(*errIndexCorrupt); ok {
+			quarantineIndex(fs, name, err)
+		}
+		return err
 	}
 	return &errIndexNotReady{name: name}
 }
@@ -779,7 +921,67 @@ func (e *errIndexNotExist) Error() string {
 	return fmt.Sprintf("index %q does not exist: %s", e.name, e.err)
 }
 
-// readIndex calls x.Read with the index's backing file.
+// errIndexCorrupt occurs when an index's backing file exists but
+// can't be read.
+type errIndexCorrupt struct {
+	name string
+	err  error
+}
+
+func (e *errIndexCorrupt) Error() string {
+	return fmt.Sprintf("index %q is corrupt: %s", e.name, e.err)
+}
+
+func isIndexCorrupt(err error) bool {
+	_, ok := err.(*errIndexCorrupt)
+	return ok
+}
+
+// quarantinedIndexes holds the indexes (keyed on their VFS and name)
+// that were found to be corrupt. They aren't read again (and queries
+// that would use them perform full scans instead) until they are
+// rebuilt. Quarantining never writes to the VFS, so it works for
+// read-only stores.
+var quarantinedIndexes = struct {
+	m map[string]*errIndexCorrupt
+	sync.Mutex
+}{m: map[string]*errIndexCorrupt{}}
+
+func quarantineKey(fs rwvfs.FileSystem, name string) string {
+	return fmt.Sprintf("%v:%s", fs, name)
+}
+
+// quarantineIndex quarantines a corrupt index and logs a warning
+// (once per index).
+func quarantineIndex(fs rwvfs.FileSystem, name string, err *errIndexCorrupt) {
+	key := quarantineKey(fs, name)
+	quarantinedIndexes.Lock()
+	defer quarantinedIndexes.Unlock()
+	if _, present := quarantinedIndexes.m[key]; !present {
+		log.Printf("Warning: quarantining corrupt index %q in %v (queries will use slower full scans until it is rebuilt with `src store index`): %s", name, fs, err.err)
+		quarantinedIndexes.m[key] = err
+	}
+}
+
+// quarantinedIndex returns the error that caused the index to be
+// quarantined, or nil if it isn't quarantined.
+func quarantinedIndex(fs rwvfs.FileSystem, name string) error {
+	quarantinedIndexes.Lock()
+	defer quarantinedIndexes.Unlock()
+	if err, present := quarantinedIndexes.m[quarantineKey(fs, name)]; present {
+		return err
+	}
+	return nil
+}
+
+func unquarantineIndex(fs rwvfs.FileSystem, name string) {
+	quarantinedIndexes.Lock()
+	defer quarantinedIndexes.Unlock()
+	delete(quarantinedIndexes.m, quarantineKey(fs, name))
+}
+
+// readIndex calls x.Read with the index's backing file (or x.Map, if
+// x is a mappedIndex whose file can be mapped into memory).
 func readIndex(fs rwvfs.FileSystem, name string, x persistedIndex) (err error) {
 	vlog.Printf("%s: reading index...", name)
 	var f vfs.ReadSeekCloser
@@ -798,21 +1000,83 @@ func readIndex(fs rwvfs.FileSystem, name string, x persistedIndex) (err error) {
 		}
 	}()
 
-	r, err := gzip.NewReader(f)
+	br := bufio.NewReader(f)
+	if magic, _ := br.Peek(2); !bytes.Equal(magic, GzipCompressor{}.Magic()[:2]) {
+		if err := readUncompressedIndex(f, br, x); err != nil {
+			return &errIndexCorrupt{name: name, err: err}
+		}
+		vlog.Printf("%s: done reading index.", name)
+		return nil
+	}
+
+	r, err := gzip.NewReader(br)
 	if err != nil {
-		return err
+		return &errIndexCorrupt{name: name, err: err}
 	}
 
 	if err := x.Read(r); err != nil {
-		return err
+		return &errIndexCorrupt{name: name, err: err}
 	}
 	if err := r.Close(); err != nil {
-		return err
+		return &errIndexCorrupt{name: name, err: err}
 	}
 	vlog.Printf("%s: done reading index.", name)
 	return nil
 }
 
+// readUncompressedIndex reads x from f, an uncompressed index file
+// written by writeIndex, whose contents r reads. If x is a
+// mappedIndex and f is a local file, f is mapped instead of read
+// (unless UseMmap is false).
+//
+// The checksum of a mapped file isn't verified, because that would
+// read the whole file each time it's opened (instead of only the pages
+// that are used). It is computed as the file is written and verified
+// when index files are copied from the shared index dir and by
+// CheckIndexes, which reads index files instead of mapping them.
+func readUncompressedIndex(f vfs.ReadSeekCloser, r io.Reader, x persistedIndex) error {
+	if mx, ok := x.(mappedIndex); ok && UseMmap {
+		if f, ok := f.(*os.File); ok {
+			fi, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			if n := fi.Size() - crc32.Size; n > 0 {
+				m, err := phtable.MapFile(f, n)
+				if err == nil {
+					if err = mx.Map(m); err != nil {
+						m.Close()
+					}
+					return err
+				}
+				vlog.Printf("Reading %s instead of mapping it: %s.", f.Name(), err)
+			}
+		}
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(data) < crc32.Size {
+		return errors.New("index file is truncated")
+	}
+	data, sum := data[:len(data)-crc32.Size], data[len(data)-crc32.Size:]
+	if err := checkIndexChecksum(data, sum); err != nil {
+		return err
+	}
+	return x.Read(bytes.NewReader(data))
+}
+
+// checkIndexChecksum returns an error if sum isn't the CRC-32
+// checksum of data (as written by writeIndex).
+func checkIndexChecksum(data, sum []byte) error {
+	if want, got := binary.BigEndian.Uint32(sum), crc32.ChecksumIEEE(data); got != want {
+		return fmt.Errorf("index file checksum mismatch (got %08x, want %08x)", got, want)
+	}
+	return nil
+}
+
 // statIndex calls fs.Stat on the index's backing file or dir.
 func statIndex(fs rwvfs.FileSystem, name string) (os.FileInfo, error) {
 	return fs.Stat(fmt.Sprintf(indexFilename, name))
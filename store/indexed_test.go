@@ -1,6 +1,12 @@
 package store
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
 
 func TestIndexedUnitStore(t *testing.T) {
 	useIndexedStore = true
@@ -30,6 +36,15 @@ func TestIndexedFSRepoStore(t *testing.T) {
 	})
 }
 
+func TestIndexedFSRepoStore_externalPhtables(t *testing.T) {
+	defer func(orig int) { externalPhtableMinKeys = orig }(externalPhtableMinKeys)
+	externalPhtableMinKeys = 0
+	useIndexedStore = true
+	testRepoStore(t, func() RepoStoreImporter {
+		return NewFSRepoStore(newTestFS())
+	})
+}
+
 func TestIndexedFSMultiRepoStore(t *testing.T) {
 	useIndexedStore = true
 	testMultiRepoStore(t, func() MultiRepoStoreImporter {
@@ -43,3 +58,53 @@ func TestIndexedFSMultiRepoStore_customRepoPaths(t *testing.T) {
 		return NewFSMultiRepoStore(newTestFS(), &FSMultiRepoStoreConf{RepoPaths: &customRepoPaths{}})
 	})
 }
+
+func TestIndexedUnitStore_corruptIndex(t *testing.T) {
+	useIndexedStore = true
+	fs := newTestFS()
+	data := graph.Output{
+		Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, Name: "n", File: "f"}},
+		Refs: []*graph.Ref{
+			{DefPath: "p", File: "f", Start: 1, End: 2},
+			{DefPath: "q", File: "f", Start: 3, End: 4},
+		},
+	}
+	if err := newIndexedUnitStore(fs, "").Import(data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{defQueryIndexName, defToRefsIndexName} {
+		f, err := fs.Create(fmt.Sprintf(indexFilename, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte("corrupt")); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Queries against a read-only store should fall back to full
+	// scans.
+	rofs := rwvfs.ReadOnly(fs)
+	us := newIndexedUnitStore(rofs, "")
+	defs, err := us.Defs(ByDefQuery("n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 1 {
+		t.Errorf("got %d defs, want 1", len(defs))
+	}
+	refs, err := us.Refs(ByRefDef(graph.RefDefKey{DefPath: "p"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 {
+		t.Errorf("got %d refs, want 1", len(refs))
+	}
+	if err := quarantinedIndex(rofs, defQueryIndexName); err == nil {
+		t.Errorf("index %q was not quarantined", defQueryIndexName)
+	}
+}
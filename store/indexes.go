@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"reflect"
+	"runtime"
 	"time"
 
 	"code.google.com/p/rog-go/parallel"
@@ -52,6 +53,10 @@ type IndexStatus struct {
 	// file.
 	Size int64 `json:",omitempty"`
 
+	// ModTime is when the index's backing file was last written
+	// (i.e., when the index was last built), if known.
+	ModTime *time.Time `json:",omitempty"`
+
 	// Error is the error encountered while determining this index's
 	// status, if any.
 	Error string `json:",omitempty"`
@@ -68,6 +73,17 @@ type IndexStatus struct {
 	// only returned by BuildIndexes (not Indexes).
 	BuildDuration time.Duration `json:",omitempty"`
 
+	// VerifyError is the error encountered while reading the index
+	// back from its backing file, or the problem found while checking
+	// its contents, if any. It is only returned by VerifyIndexes and
+	// CheckIndexes.
+	VerifyError string `json:",omitempty"`
+
+	// Repaired is true if the index had a problem (described in
+	// VerifyError) and was successfully rebuilt. It is only returned by
+	// CheckIndexes.
+	Repaired bool `json:",omitempty"`
+
 	// index is the actual index object. It is used to support Print.
 	index Index
 
@@ -192,6 +208,37 @@ func Indexes(store interface{}, c IndexCriteria, indexChan chan<- IndexStatus) (
 	return xs, err
 }
 
+// VerifyIndexes reads each index on store and its lower-level stores
+// that matches the specified criteria back from its backing file, to
+// check that it has been built and isn't corrupt. It returns the
+// status of each index, with VerifyError set if the index couldn't be
+// read or wasn't ready after being read.
+func VerifyIndexes(store interface{}, c IndexCriteria, indexChan chan<- IndexStatus) ([]IndexStatus, error) {
+	var xs []IndexStatus
+	indexChan2 := make(chan IndexStatus)
+	done := make(chan struct{})
+	go func() {
+		for sx := range indexChan2 {
+			if px, ok := sx.index.(persistedIndex); ok {
+				if err := sx.store.readIndex(sx.Name, px); err != nil {
+					sx.VerifyError = err.Error()
+				} else if !sx.index.Ready() {
+					sx.VerifyError = "index is not ready after being read"
+				}
+			}
+			xs = append(xs, sx)
+			if indexChan != nil {
+				indexChan <- sx
+			}
+		}
+		done <- struct{}{}
+	}()
+	err := listIndexes(store, c, indexChan2, nil)
+	close(indexChan2)
+	<-done
+	return xs, err
+}
+
 // listIndexes lists indexes in s (a store) asynchronously, sending
 // status objects to ch. If f != nil, it is called to set/modify
 // fields on each status object before the IndexStatus object is sent to
@@ -223,6 +270,9 @@ func listIndexes(s interface{}, c IndexCriteria, ch chan<- IndexStatus, f func(*
 				st.Error = err.Error()
 			} else {
 				st.Size = fi.Size()
+				if t := fi.ModTime(); !t.IsZero() {
+					st.ModTime = &t
+				}
 			}
 
 			switch x.(type) {
@@ -370,3 +420,18 @@ func listIndexes(s interface{}, c IndexCriteria, ch chan<- IndexStatus, f func(*
 }
 
 var MaxIndexParallel = 1
+
+// IndexJobs is the maximum number of indexes of a store (e.g., of
+// different types) that are built concurrently, and of source unit
+// indexes that are read concurrently to build a tree's indexes. Like
+// Codec, it should only be set at init time or when you can guarantee
+// that no indexes are being built.
+var IndexJobs = runtime.GOMAXPROCS(0)
+
+// indexJobs returns IndexJobs, or 1 if it is less than 1.
+func indexJobs() int {
+	if IndexJobs < 1 {
+		return 1
+	}
+	return IndexJobs
+}
@@ -1,8 +1,15 @@
 package store
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
 )
 
 func TestIndexes(t *testing.T) {
@@ -23,3 +30,165 @@ func TestIndexes(t *testing.T) {
 		}
 	}
 }
+
+func TestVerifyIndexes(t *testing.T) {
+	fs := newTestFS()
+	data := graph.Output{
+		Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, Name: "n", File: "f"}},
+		Refs: []*graph.Ref{{DefPath: "p", File: "f", Start: 1, End: 2}},
+	}
+	if err := newIndexedUnitStore(fs, "").Import(data); err != nil {
+		t.Fatal(err)
+	}
+
+	verifyErrors := func() map[string]string {
+		xs, err := VerifyIndexes(newIndexedUnitStore(fs, ""), IndexCriteria{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(xs) == 0 {
+			t.Fatal("no indexes verified")
+		}
+		errs := map[string]string{}
+		for _, x := range xs {
+			if x.VerifyError != "" {
+				errs[x.Name] = x.VerifyError
+			}
+		}
+		return errs
+	}
+
+	if errs := verifyErrors(); len(errs) != 0 {
+		t.Errorf("got verify errors %v for freshly built indexes, want none", errs)
+	}
+
+	f, err := fs.Create(fmt.Sprintf(indexFilename, defToRefsIndexName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("corrupt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if errs := verifyErrors(); len(errs) != 1 || errs[defToRefsIndexName] == "" {
+		t.Errorf("got verify errors %v, want only one for corrupt index %q", errs, defToRefsIndexName)
+	}
+}
+
+func TestCheckIndexes(t *testing.T) {
+	fs := newTestFS()
+	data := graph.Output{
+		Defs: []*graph.Def{
+			{DefKey: graph.DefKey{Path: "p"}, Name: "n", File: "f"},
+			{DefKey: graph.DefKey{Path: "q"}, Name: "m", File: "d/g"},
+		},
+		Refs: []*graph.Ref{
+			{DefPath: "p", File: "f", Start: 1, End: 2},
+			{DefPath: "q", File: "d/g", Start: 3, End: 4},
+		},
+	}
+	if err := newIndexedUnitStore(fs, "").Import(data); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(repair bool) map[string]IndexStatus {
+		xs, err := CheckIndexes(newIndexedUnitStore(fs, ""), IndexCriteria{}, repair, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(xs) == 0 {
+			t.Fatal("no indexes checked")
+		}
+		bad := map[string]IndexStatus{}
+		for _, x := range xs {
+			if x.VerifyError != "" || x.BuildError != "" {
+				bad[x.Name] = x
+			}
+		}
+		return bad
+	}
+
+	if bad := check(false); len(bad) != 0 {
+		t.Errorf("got problems %v with freshly built indexes, want none", bad)
+	}
+
+	// Truncate an index file (removing the gzip checksum), and replace
+	// another with a well-formed index whose offsets don't match the
+	// data files.
+	truncated := fmt.Sprintf(indexFilename, defQueryIndexName)
+	b, err := vfs.ReadFile(fs, truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Create(truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(b[:len(b)-4]); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wrong := &defPathIndex{}
+	if err := wrong.Build(data.Defs, byteOffsets{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeIndex(fs, "path_to_def", wrong); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := check(false)
+	if len(bad) != 2 || bad[defQueryIndexName].VerifyError == "" || bad["path_to_def"].VerifyError == "" {
+		t.Fatalf("got problems %v, want only ones with %q and path_to_def", bad, defQueryIndexName)
+	}
+	if msg := bad["path_to_def"].VerifyError; !strings.Contains(msg, "does not begin a def") {
+		t.Errorf("got path_to_def problem %q, want bad offset", msg)
+	}
+
+	for name, x := range check(true) {
+		if !x.Repaired || x.BuildError != "" {
+			t.Errorf("%s: not repaired: %+v", name, x)
+		}
+	}
+	if bad := check(false); len(bad) != 0 {
+		t.Errorf("got problems %v after repair, want none", bad)
+	}
+}
+
+func TestIndexJobs(t *testing.T) {
+	defer func(orig int) { IndexJobs = orig }(IndexJobs)
+
+	for _, jobs := range []int{0, 1, 8} {
+		IndexJobs = jobs
+		rs := NewFSRepoStore(newTestFS())
+		for _, name := range []string{"u1", "u2", "u3"} {
+			u := &unit.SourceUnit{Type: "t", Name: name, Files: []string{name + ".go"}}
+			data := graph.Output{
+				Defs: []*graph.Def{{DefKey: graph.DefKey{Path: name}, Name: name, File: name + ".go"}},
+				Refs: []*graph.Ref{{DefPath: name, File: name + ".go", Start: 1, End: 2}},
+			}
+			if err := rs.Import("c", u, data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rs.(RepoIndexer).Index("c"); err != nil {
+			t.Fatal(err)
+		}
+
+		xs, err := VerifyIndexes(rs, IndexCriteria{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(xs) == 0 {
+			t.Fatalf("jobs=%d: no indexes verified", jobs)
+		}
+		for _, x := range xs {
+			if x.Stale || x.VerifyError != "" {
+				t.Errorf("jobs=%d: index %s (unit %v) is stale or invalid: %+v", jobs, x.Name, x.Unit, x)
+			}
+		}
+	}
+}
@@ -170,6 +170,14 @@ func (s *memoryTreeStore) Units(f ...UnitFilter) ([]*unit.SourceUnit, error) {
 	return units, nil
 }
 
+func (s *memoryTreeStore) Refs(f ...RefFilter) ([]*graph.Ref, error) {
+	f, err := resolveRefDefFiles(s, f)
+	if err != nil {
+		return nil, err
+	}
+	return s.unitStores.Refs(f...)
+}
+
 func (s *memoryTreeStore) Import(u *unit.SourceUnit, data graph.Output) error {
 	if s.units == nil {
 		s.units = []*unit.SourceUnit{}
@@ -223,6 +231,9 @@ func (s *memoryUnitStore) Defs(f ...DefFilter) ([]*graph.Def, error) {
 	if s.data == nil {
 		return nil, errUnitNoInit
 	}
+	if hasDefTreeFilter(f) {
+		f = withDefParents(f, defParents(s.data.Defs))
+	}
 
 	var defs []*graph.Def
 	for _, def := range s.data.Defs {
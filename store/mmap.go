@@ -0,0 +1,48 @@
+package store
+
+import (
+	"bytes"
+	"os"
+
+	"golang.org/x/tools/godoc/vfs"
+	"sourcegraph.com/sourcegraph/srclib/store/phtable"
+)
+
+// UseMmap is whether local index and data files are read by mapping
+// them into memory. Mapped files are paged in lazily by the OS and
+// shared with its page cache, so queries on large stores neither
+// copy whole files onto the heap nor make a syscall per byte range.
+// Set it to false if the store's files may be truncated while they're
+// being read (e.g., by another tool) or the platform's mmap is
+// unreliable (e.g., on some network filesystems). Like Codec, it
+// should only be set at init time.
+var UseMmap = true
+
+// mappedFile is an uncompressed data file that's mapped into memory.
+type mappedFile struct {
+	*bytes.Reader
+	m *phtable.Mapping
+}
+
+func (f mappedFile) Close() error { return f.m.Close() }
+
+// maybeMap returns an in-memory mappedFile with f's contents if f is
+// a non-empty local file and UseMmap is set, in which case f is
+// closed. Otherwise (or if f can't be mapped), it returns f.
+func maybeMap(f vfs.ReadSeekCloser) vfs.ReadSeekCloser {
+	osf, ok := f.(*os.File)
+	if !ok || !UseMmap {
+		return f
+	}
+	fi, err := osf.Stat()
+	if err != nil || fi.Size() == 0 {
+		return f
+	}
+	m, err := phtable.MapFile(osf, fi.Size())
+	if err != nil {
+		vlog.Printf("Reading %s instead of mapping it: %s.", osf.Name(), err)
+		return f
+	}
+	f.Close()
+	return mappedFile{Reader: bytes.NewReader(m.Bytes()), m: m}
+}
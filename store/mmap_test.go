@@ -0,0 +1,188 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"golang.org/x/tools/godoc/vfs"
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// newMmapTestStore imports a unit's data into a new store in a
+// temporary dir (so that its files can be mapped).
+func newMmapTestStore(t *testing.T) (fs rwvfs.FileSystem, cleanup func()) {
+	useIndexedStore = true
+	dir, err := ioutil.TempDir("", "srclib-mmap-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs = rwvfs.OS(dir)
+	data := graph.Output{
+		Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, Name: "n", File: "f"}},
+		Refs: []*graph.Ref{
+			{DefPath: "p", File: "f", Start: 1, End: 2},
+			{DefPath: "q", File: "f", Start: 3, End: 4},
+			{DefPath: "p", File: "g", Start: 5, End: 6},
+		},
+	}
+	if err := newIndexedUnitStore(fs, "").Import(data); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return fs, func() { os.RemoveAll(dir) }
+}
+
+func readDefRefsIndex(t *testing.T, fs rwvfs.FileSystem) byteOffsets {
+	x := &defRefsIndex{}
+	if err := readIndex(fs, defToRefsIndexName, x); err != nil {
+		t.Fatal(err)
+	}
+	if !x.Ready() {
+		t.Fatal("index is not ready after being read")
+	}
+	ofs, found, err := x.getByDef(graph.RefDefKey{DefPath: "p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Fatal("def p not found in index")
+	}
+	return ofs
+}
+
+func TestReadIndex_mmap(t *testing.T) {
+	defer func(orig bool) { UseMmap = orig }(UseMmap)
+	fs, cleanup := newMmapTestStore(t)
+	defer cleanup()
+
+	UseMmap = true
+	mapped := readDefRefsIndex(t, fs)
+	if len(mapped) != 2 {
+		t.Errorf("got %d ref offsets, want 2", len(mapped))
+	}
+
+	UseMmap = false
+	if read := readDefRefsIndex(t, fs); fmt.Sprint(read) != fmt.Sprint(mapped) {
+		t.Errorf("got ref offsets %v when reading index, want %v (as when mapping it)", read, mapped)
+	}
+}
+
+func TestReadIndex_gzipped(t *testing.T) {
+	fs, cleanup := newMmapTestStore(t)
+	defer cleanup()
+	want := readDefRefsIndex(t, fs)
+
+	// Index files used to be gzipped (without a trailing checksum),
+	// and stores with such indexes must still be readable.
+	filename := fmt.Sprintf(indexFilename, defToRefsIndexName)
+	data, err := vfs.ReadFile(fs, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := gzip.NewWriter(f)
+	if _, err := w.Write(data[:len(data)-4]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readDefRefsIndex(t, fs); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got ref offsets %v, want %v", got, want)
+	}
+}
+
+func TestReadIndex_checksumMismatch(t *testing.T) {
+	defer func(orig bool) { UseMmap = orig }(UseMmap)
+	fs, cleanup := newMmapTestStore(t)
+	defer cleanup()
+
+	filename := fmt.Sprintf(indexFilename, defToRefsIndexName)
+	data, err := vfs.ReadFile(fs, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xFF
+	f, err := fs.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mapped index files' checksums aren't verified when they're
+	// read, but they are when the file is read in full (as
+	// CheckIndexes does).
+	for _, useMmap := range []bool{true, false} {
+		UseMmap = useMmap
+		err := readIndex(fs, defToRefsIndexName, rawIndex{})
+		if _, ok := err.(*errIndexCorrupt); !ok {
+			t.Errorf("UseMmap=%v: got error %v reading raw index, want *errIndexCorrupt", useMmap, err)
+		}
+	}
+	UseMmap = false
+	if err := readIndex(fs, defToRefsIndexName, &defRefsIndex{}); err == nil {
+		t.Errorf("UseMmap=false: got no error, want *errIndexCorrupt")
+	} else if _, ok := err.(*errIndexCorrupt); !ok {
+		t.Errorf("UseMmap=false: got error %v, want *errIndexCorrupt", err)
+	}
+}
+
+func TestOpenDataFile_mmap(t *testing.T) {
+	defer func(orig bool) { UseMmap = orig }(UseMmap)
+	fs, cleanup := newMmapTestStore(t)
+	defer cleanup()
+
+	var contents []string
+	for _, useMmap := range []bool{true, false} {
+		UseMmap = useMmap
+		f, err := openDataFile(fs, unitRefsFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, mapped := f.(mappedFile); mapped != useMmap {
+			t.Errorf("UseMmap=%v: got data file %T", useMmap, f)
+		}
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(data))
+	}
+	if contents[0] == "" || contents[0] != contents[1] {
+		t.Errorf("got mapped data file contents %q, want %q", contents[0], contents[1])
+	}
+
+	us := newIndexedUnitStore(rwvfs.ReadOnly(fs), "")
+	UseMmap = true
+	refs, err := us.Refs(ByRefDef(graph.RefDefKey{DefPath: "p"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files bytes.Buffer
+	for _, ref := range refs {
+		files.WriteString(ref.File)
+	}
+	if got, want := files.String(), "fg"; got != want {
+		t.Errorf("got refs in files %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,510 @@
+package phtable
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DefaultPartitionBytes is the default ExternalBuilder.PartitionBytes.
+const DefaultPartitionBytes = 64 << 20
+
+// An ExternalBuilder builds a CHD hash table with too many keys to
+// build in memory with CHDBuilder. It spills the added keys and values
+// to temporary files and, while building, keeps only a hash of each
+// key (8 bytes) and a few bytes per bucket and table slot in memory.
+// The table is written in its serialized form (as read by Read and
+// Mmap) one partition of slots at a time.
+//
+// The tables it builds are the same as CHDBuilder's, except for the
+// random hash functions chosen.
+type ExternalBuilder struct {
+	// PartitionBytes is the approximate size of the keys and values
+	// in each partition of the table's slots, which are held in
+	// memory one at a time while writing the table. If 0,
+	// DefaultPartitionBytes is used.
+	PartitionBytes int64
+
+	dir     string
+	varints bool
+
+	spill  *os.File
+	spillW *bufio.Writer
+	n      int   // number of entries added
+	bytes  int64 // size of the added keys and values
+	vb     [binary.MaxVarintLen64]byte
+}
+
+// NewExternalBuilder creates a builder that spills to a new temporary
+// dir in dir (or, if dir is empty, the default temporary dir). If
+// varints is true, its values are uint64s added with AddUvarint64 (as
+// with Uvarint64Builder); otherwise they are byte slices added with
+// Add. Call Close to remove the temporary files.
+func NewExternalBuilder(dir string, varints bool) (*ExternalBuilder, error) {
+	dir, err := ioutil.TempDir(dir, "phtable")
+	if err != nil {
+		return nil, err
+	}
+	spill, err := os.Create(filepath.Join(dir, "entries"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &ExternalBuilder{dir: dir, varints: varints, spill: spill, spillW: bufio.NewWriter(spill)}, nil
+}
+
+// Add a key and value to the hash table.
+func (b *ExternalBuilder) Add(key []byte, value []byte) error {
+	if b.varints {
+		panic("ValuesAreVarints")
+	}
+	return b.add(key, value, 0)
+}
+
+// AddUvarint64 adds a key and value to the hash table.
+func (b *ExternalBuilder) AddUvarint64(key []byte, value uint64) error {
+	if !b.varints {
+		panic("!ValuesAreVarints")
+	}
+	return b.add(key, nil, value)
+}
+
+func (b *ExternalBuilder) add(key, value []byte, v uint64) error {
+	if err := b.writeUvarint(b.spillW, uint64(len(key))); err != nil {
+		return err
+	}
+	if _, err := b.spillW.Write(key); err != nil {
+		return err
+	}
+	if b.varints {
+		if err := b.writeUvarint(b.spillW, v); err != nil {
+			return err
+		}
+	} else {
+		if err := b.writeUvarint(b.spillW, uint64(len(value))); err != nil {
+			return err
+		}
+		if _, err := b.spillW.Write(value); err != nil {
+			return err
+		}
+	}
+	b.bytes += int64(len(key) + len(value))
+	b.n++
+	return nil
+}
+
+func (b *ExternalBuilder) writeUvarint(w io.Writer, v uint64) error {
+	n := binary.PutUvarint(b.vb[:], v)
+	_, err := w.Write(b.vb[:n])
+	return err
+}
+
+// Len returns the number of entries added.
+func (b *ExternalBuilder) Len() int { return b.n }
+
+// Close removes the builder's temporary files.
+func (b *ExternalBuilder) Close() error {
+	b.spill.Close()
+	return os.RemoveAll(b.dir)
+}
+
+// entry is a spilled key and value.
+type entry struct {
+	slot  uint64
+	key   []byte
+	value []byte
+	v     uint64
+}
+
+// entryReader reads the entries spilled to a file.
+type entryReader struct {
+	r        *bufio.Reader
+	varints  bool
+	withSlot bool // entries are preceded by their slot
+
+	// reuse is whether the returned entry and its key and value may
+	// be overwritten by the next entry read.
+	reuse         bool
+	e             entry
+	keyBuf, vaBuf []byte
+}
+
+func (er *entryReader) next() (*entry, error) {
+	e := &er.e
+	if !er.reuse {
+		e = &entry{}
+	}
+	var err error
+	if er.withSlot {
+		if e.slot, err = binary.ReadUvarint(er.r); err != nil {
+			return nil, err
+		}
+	}
+	if e.key, err = er.readBytes(&er.keyBuf); err != nil {
+		return nil, unexpectedEOF(err, er.withSlot)
+	}
+	if er.varints {
+		if e.v, err = binary.ReadUvarint(er.r); err != nil {
+			return nil, unexpectedEOF(err, true)
+		}
+	} else if e.value, err = er.readBytes(&er.vaBuf); err != nil {
+		return nil, unexpectedEOF(err, true)
+	}
+	return e, nil
+}
+
+// readBytes reads a length-prefixed byte slice, into *buf if the
+// reader reuses entries.
+func (er *entryReader) readBytes(buf *[]byte) ([]byte, error) {
+	n, err := binary.ReadUvarint(er.r)
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	if er.reuse {
+		if uint64(cap(*buf)) < n {
+			*buf = make([]byte, n)
+		}
+		b = (*buf)[:n]
+	} else {
+		b = make([]byte, n)
+	}
+	_, err = io.ReadFull(er.r, b)
+	return b, err
+}
+
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF if the entry
+// was partially read.
+func unexpectedEOF(err error, partial bool) error {
+	if err == io.EOF && partial {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// eachEntry calls f for each spilled entry, in the order they were
+// added. The entry passed to f is only valid until f returns.
+func (b *ExternalBuilder) eachEntry(f func(*entry) error) error {
+	if _, err := b.spill.Seek(0, 0); err != nil {
+		return err
+	}
+	er := &entryReader{r: bufio.NewReader(b.spill), varints: b.varints, reuse: true}
+	for {
+		e, err := er.next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+}
+
+// Build builds the hash table and writes it to w in its serialized
+// form. If storeKeys is true, the keys are stored (as with
+// CHD.StoreKeys).
+func (b *ExternalBuilder) Build(w io.Writer, storeKeys bool) error {
+	if err := b.spillW.Flush(); err != nil {
+		return err
+	}
+
+	// Same table dimensions as CHDBuilder.
+	const c = 2
+	m := uint64(b.n)
+	if m == 0 {
+		m = 1
+	}
+	n := 1 + c*m
+	hasher := newCHDHasher(n, m)
+
+	// Group the key hashes by bucket (with a counting sort).
+	offsets := make([]uint32, m+1) // bucket i's hashes are hashes[offsets[i]:offsets[i+1]]
+	if err := b.eachEntry(func(e *entry) error {
+		offsets[hasher.HashIndexFromKey(e.key)+1]++
+		return nil
+	}); err != nil {
+		return err
+	}
+	for i := uint64(1); i <= m; i++ {
+		offsets[i] += offsets[i-1]
+	}
+	hashes := make([]uint64, b.n)
+	next := make([]uint32, m)
+	copy(next, offsets)
+	if err := b.eachEntry(func(e *entry) error {
+		oh := hasher.HashIndexFromKey(e.key)
+		hashes[next[oh]] = hasher.keyHash(e.key)
+		next[oh]++
+		return nil
+	}); err != nil {
+		return err
+	}
+	next = nil
+
+	indices, err := b.placeBuckets(hasher, hashes, offsets)
+	if err != nil {
+		return err
+	}
+	hashes, offsets = nil, nil
+	return b.writeTable(w, hasher, indices, storeKeys)
+}
+
+// keyHash is the hash of key that the bucket and table hashes are
+// derived from.
+func (h *chdHasher) keyHash(key []byte) uint64 {
+	return hasher(key) ^ h.r[0]
+}
+
+// placeBuckets chooses a hash function for each bucket (largest
+// first) that maps its keys to free table slots, like
+// CHDBuilder.Build, and returns the index of each bucket's function.
+func (b *ExternalBuilder) placeBuckets(hasher *chdHasher, hashes []uint64, offsets []uint32) ([]uint16, error) {
+	m := uint64(len(offsets) - 1)
+	indices := make([]uint16, m)
+	var order []uint32 // non-empty buckets
+	for i := range indices {
+		indices[i] = ^uint16(0)
+		if offsets[i+1] > offsets[i] {
+			order = append(order, uint32(i))
+		}
+	}
+	sort.Sort(bucketsBySize{order, offsets})
+
+	seen := make([]uint64, (hasher.size+63)/64) // bitset of used slots
+	slots := make([]uint64, 0, 16)
+	try := func(bucket []uint64, r uint64) bool {
+		slots = slots[:0]
+		for _, h := range bucket {
+			slot := (h ^ r) % hasher.size
+			if seen[slot/64]&(1<<(slot%64)) != 0 {
+				return false
+			}
+			for _, s := range slots {
+				if s == slot {
+					return false
+				}
+			}
+			slots = append(slots, slot)
+		}
+		for _, slot := range slots {
+			seen[slot/64] |= 1 << (slot % 64)
+		}
+		return true
+	}
+
+nextBucket:
+	for i, oh := range order {
+		bucket := hashes[offsets[oh]:offsets[oh+1]]
+		// Keys with the same hash can never be separated.
+		sort.Sort(uint64s(bucket))
+		for j := 1; j < len(bucket); j++ {
+			if bucket[j] == bucket[j-1] {
+				return nil, b.duplicateKeyError(hasher, bucket[j])
+			}
+		}
+
+		for ri, r := range hasher.r {
+			if try(bucket, r) {
+				indices[oh] = uint16(ri)
+				continue nextBucket
+			}
+		}
+		for j := 0; j < 10000000; j++ {
+			ri, r := hasher.Generate()
+			if try(bucket, r) {
+				hasher.Add(r)
+				indices[oh] = ri
+				continue nextBucket
+			}
+		}
+		return nil, fmt.Errorf("failed to find a collision-free hash function after ~10000000 attempts, for bucket %d/%d with %d entries", i, len(order), len(bucket))
+	}
+	return indices, nil
+}
+
+// duplicateKeyError returns an error naming the key (or keys, if their
+// 64-bit hashes collide) whose hash is h.
+func (b *ExternalBuilder) duplicateKeyError(hasher *chdHasher, h uint64) error {
+	var keys []string
+	if err := b.eachEntry(func(e *entry) error {
+		if hasher.keyHash(e.key) == h {
+			keys = append(keys, string(e.key))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if len(keys) >= 2 && keys[0] != keys[1] {
+		return fmt.Errorf("keys %q and %q have the same hash", keys[0], keys[1])
+	} else if len(keys) == 0 {
+		return errors.New("duplicate key")
+	}
+	return errors.New("duplicate key " + keys[0])
+}
+
+// writeTable writes the serialized table (in the format that
+// CHD.Write writes) to w. The entries are first distributed to
+// partition files by slot, and then each partition is sorted in memory
+// and written.
+func (b *ExternalBuilder) writeTable(w io.Writer, hasher *chdHasher, indices []uint16, storeKeys bool) error {
+	el := hasher.size
+	var storeKeysFlag uint32
+	if storeKeys {
+		storeKeysFlag = 1
+	}
+	for _, d := range []interface{}{
+		uint32(len(hasher.r)), hasher.r,
+		uint32(len(indices)), indices,
+		uint32(el),
+		storeKeysFlag,
+	} {
+		if err := binary.Write(w, binary.LittleEndian, d); err != nil {
+			return err
+		}
+	}
+
+	partitionBytes := b.PartitionBytes
+	if partitionBytes <= 0 {
+		partitionBytes = DefaultPartitionBytes
+	}
+	numParts := uint64(b.bytes/partitionBytes) + 1
+	if numParts > el {
+		numParts = el
+	}
+	partition := func(slot uint64) uint64 { return slot * numParts / el }
+
+	parts := make([]*os.File, numParts)
+	partWs := make([]*bufio.Writer, numParts)
+	defer func() {
+		for _, f := range parts {
+			if f != nil {
+				f.Close()
+				os.Remove(f.Name())
+			}
+		}
+	}()
+	for i := range parts {
+		f, err := os.Create(filepath.Join(b.dir, fmt.Sprintf("part%d", i)))
+		if err != nil {
+			return err
+		}
+		parts[i] = f
+		partWs[i] = bufio.NewWriterSize(f, 16<<10)
+	}
+	if err := b.eachEntry(func(e *entry) error {
+		h := hasher.keyHash(e.key)
+		slot := (h ^ hasher.r[indices[h%uint64(len(indices))]]) % el
+		pw := partWs[partition(slot)]
+		if err := b.writeUvarint(pw, slot); err != nil {
+			return err
+		}
+		if err := b.writeUvarint(pw, uint64(len(e.key))); err != nil {
+			return err
+		}
+		if _, err := pw.Write(e.key); err != nil {
+			return err
+		}
+		if b.varints {
+			return b.writeUvarint(pw, e.v)
+		}
+		if err := b.writeUvarint(pw, uint64(len(e.value))); err != nil {
+			return err
+		}
+		_, err := pw.Write(e.value)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	slot := uint64(0)
+	writeEntry := func(e *entry) error {
+		if storeKeys {
+			if err := b.writeUvarint(bw, uint64(len(e.key))); err != nil {
+				return err
+			}
+			if _, err := bw.Write(e.key); err != nil {
+				return err
+			}
+		}
+		if b.varints {
+			return b.writeUvarint(bw, e.v)
+		}
+		if err := b.writeUvarint(bw, uint64(len(e.value))); err != nil {
+			return err
+		}
+		_, err := bw.Write(e.value)
+		return err
+	}
+	for i, f := range parts {
+		if err := partWs[i].Flush(); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, 0); err != nil {
+			return err
+		}
+		var entries []*entry
+		er := &entryReader{r: bufio.NewReader(f), varints: b.varints, withSlot: true}
+		for {
+			e, err := er.next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			entries = append(entries, e)
+		}
+		sort.Sort(entriesBySlot(entries))
+
+		// Unused slots have empty keys and values.
+		for _, e := range entries {
+			for ; slot < e.slot; slot++ {
+				if err := writeEntry(&entry{}); err != nil {
+					return err
+				}
+			}
+			if err := writeEntry(e); err != nil {
+				return err
+			}
+			slot++
+		}
+	}
+	for ; slot < el; slot++ {
+		if err := writeEntry(&entry{}); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+type bucketsBySize struct {
+	buckets []uint32
+	offsets []uint32
+}
+
+func (v bucketsBySize) size(i int) uint32 {
+	return v.offsets[v.buckets[i]+1] - v.offsets[v.buckets[i]]
+}
+func (v bucketsBySize) Len() int           { return len(v.buckets) }
+func (v bucketsBySize) Less(i, j int) bool { return v.size(i) > v.size(j) }
+func (v bucketsBySize) Swap(i, j int)      { v.buckets[i], v.buckets[j] = v.buckets[j], v.buckets[i] }
+
+type uint64s []uint64
+
+func (v uint64s) Len() int           { return len(v) }
+func (v uint64s) Less(i, j int) bool { return v[i] < v[j] }
+func (v uint64s) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+
+type entriesBySlot []*entry
+
+func (v entriesBySlot) Len() int           { return len(v) }
+func (v entriesBySlot) Less(i, j int) bool { return v[i].slot < v[j].slot }
+func (v entriesBySlot) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
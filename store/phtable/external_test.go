@@ -0,0 +1,133 @@
+package phtable
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildExternal builds a table of n "keyI" -> "valueI" entries (or
+// I, if varints) with an ExternalBuilder and reads it.
+func buildExternal(t testing.TB, n int, varints, storeKeys bool, partitionBytes int64) *CHD {
+	b, err := NewExternalBuilder("", varints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	b.PartitionBytes = partitionBytes
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if varints {
+			err = b.AddUvarint64(key, uint64(i))
+		} else {
+			err = b.Add(key, []byte(fmt.Sprintf("value%d", i)))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := b.Build(&buf, storeKeys); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Mmap(buf.Bytes(), varints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestExternalBuilder(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 100, 5000} {
+		for _, storeKeys := range []bool{false, true} {
+			// Tiny partitions exercise the merging of many.
+			c := buildExternal(t, n, false, storeKeys, 1024)
+			if c.StoreKeys != storeKeys {
+				t.Errorf("n=%d: got StoreKeys %v, want %v", n, c.StoreKeys, storeKeys)
+			}
+			if got := c.ValueCount(); got != n {
+				t.Errorf("n=%d storeKeys=%v: got %d values, want %d", n, storeKeys, got, n)
+			}
+			for i := 0; i < n; i++ {
+				if v, want := c.Get([]byte(fmt.Sprintf("key%d", i))), fmt.Sprintf("value%d", i); string(v) != want {
+					t.Errorf("n=%d storeKeys=%v: got key%d value %q, want %q", n, storeKeys, i, v, want)
+					break
+				}
+			}
+			if storeKeys {
+				if v := c.Get([]byte("monkey")); v != nil {
+					t.Errorf("n=%d: for key 'monkey', got value %q, want nil", n, v)
+				}
+			}
+		}
+	}
+}
+
+func TestExternalBuilder_varints(t *testing.T) {
+	const n = 1000
+	c := buildExternal(t, n, true, true, 0)
+	for i := 0; i < n; i++ {
+		if v, found := c.GetUint64([]byte(fmt.Sprintf("key%d", i))); !found || v != uint64(i) {
+			t.Fatalf("got key%d value %d (found %v), want %d", i, v, found, i)
+		}
+	}
+	if _, found := c.GetUint64([]byte("monkey")); found {
+		t.Error("for key 'monkey', got found")
+	}
+}
+
+func TestExternalBuilder_duplicateKey(t *testing.T) {
+	b, err := NewExternalBuilder("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	for _, k := range []string{"a", "b", "a"} {
+		if err := b.Add([]byte(k), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Build(&bytes.Buffer{}, true); err == nil || !strings.Contains(err.Error(), `duplicate key a`) {
+		t.Errorf("got error %v, want duplicate key error", err)
+	}
+}
+
+const benchmarkKeys = 200000
+
+func BenchmarkBuild(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cb := Builder(benchmarkKeys)
+		for j := 0; j < benchmarkKeys; j++ {
+			cb.Add([]byte(fmt.Sprintf("key%d", j)), []byte(fmt.Sprintf("value%d", j)))
+		}
+		c, err := cb.Build()
+		if err != nil {
+			b.Fatal(err)
+		}
+		c.StoreKeys = true
+		if err := c.Write(&bytes.Buffer{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExternalBuild(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		eb, err := NewExternalBuilder("", false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < benchmarkKeys; j++ {
+			if err := eb.Add([]byte(fmt.Sprintf("key%d", j)), []byte(fmt.Sprintf("value%d", j))); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := eb.Build(&bytes.Buffer{}, true); err != nil {
+			b.Fatal(err)
+		}
+		eb.Close()
+	}
+}
@@ -0,0 +1,67 @@
+package phtable
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// A Mapping is a read-only memory mapping of the beginning of a
+// file. The file's pages are read lazily by the OS as they're first
+// accessed (and can be evicted again under memory pressure), so
+// mapping a large file is cheap and its contents never occupy the Go
+// heap.
+type Mapping struct {
+	mu sync.Mutex
+	b  []byte
+}
+
+// MapFile maps the first size bytes of f into memory. f may be closed
+// once MapFile returns. The file must not be truncated while it's
+// mapped; replace it with a new file instead.
+//
+// The mapping is unmapped when Close is called or, failing that, when
+// the Mapping is garbage-collected.
+func MapFile(f *os.File, size int64) (*Mapping, error) {
+	if size <= 0 || int64(int(size)) != size {
+		return nil, fmt.Errorf("phtable: can't map %d bytes of %s", size, f.Name())
+	}
+	b, err := mmap(f, int(size))
+	if err != nil {
+		return nil, &os.PathError{Op: "mmap", Path: f.Name(), Err: err}
+	}
+	m := &Mapping{b: b}
+	runtime.SetFinalizer(m, (*Mapping).Close)
+	return m, nil
+}
+
+// Bytes returns the mapped bytes. They're only valid until the
+// mapping is closed.
+func (m *Mapping) Bytes() []byte { return m.b }
+
+// Close unmaps the mapping.
+func (m *Mapping) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.b == nil {
+		return nil
+	}
+	runtime.SetFinalizer(m, nil)
+	b := m.b
+	m.b = nil
+	return munmap(b)
+}
+
+// ReadMapping creates a CHD over a serialized CHD in m, without
+// copying it. The CHD keeps m from being unmapped by the garbage
+// collector while the CHD is in use, but the values that Get returns
+// are only valid while the CHD is.
+func ReadMapping(m *Mapping, isVarints bool) (*CHD, error) {
+	c, err := Mmap(m.Bytes(), isVarints)
+	if err != nil {
+		return nil, err
+	}
+	c.mapping = m
+	return c, nil
+}
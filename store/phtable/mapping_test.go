@@ -0,0 +1,81 @@
+package phtable
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReadMapping(t *testing.T) {
+	b := Builder(100)
+	for i := 0; i < 100; i++ {
+		b.Add([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+	}
+	c, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.StoreKeys = true
+
+	f, err := ioutil.TempFile("", "phtable-mapping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := MapFile(f, int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if !bytes.Equal(m.Bytes(), buf.Bytes()) {
+		t.Fatal("mapped bytes differ from file contents")
+	}
+	mc, err := ReadMapping(m, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if v, want := mc.Get([]byte(fmt.Sprintf("key%d", i))), fmt.Sprintf("value%d", i); string(v) != want {
+			t.Fatalf("got key%d value %q, want %q", i, v, want)
+		}
+	}
+	if v := mc.Get([]byte("monkey")); v != nil {
+		t.Errorf("for key 'monkey', got value %q, want nil", v)
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Bytes() != nil {
+		t.Error("got mapped bytes after Close")
+	}
+}
+
+func TestMmap_truncated(t *testing.T) {
+	b := Builder(10)
+	for i := 0; i < 10; i++ {
+		b.Add([]byte(fmt.Sprintf("key%d", i)), []byte("value"))
+	}
+	c, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Mmap(buf.Bytes()[:buf.Len()/2], false); err == nil {
+		t.Error("got nil error for truncated table")
+	}
+}
@@ -0,0 +1,17 @@
+// +build windows
+
+package phtable
+
+import (
+	"errors"
+	"os"
+)
+
+// Files aren't mapped on Windows, so MapFile always fails there and
+// callers read files into memory instead.
+
+func mmap(f *os.File, size int) ([]byte, error) {
+	return nil, errors.New("mmap is not supported on windows")
+}
+
+func munmap(b []byte) error { return nil }
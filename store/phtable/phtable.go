@@ -3,6 +3,7 @@ package phtable
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"io/ioutil"
 )
@@ -23,6 +24,10 @@ type CHD struct {
 	StoreKeys        bool
 	ValuesAreVarints bool
 	valueVarints     []uint64
+
+	// mapping (if set) holds the table's data, which must stay
+	// mapped while the table is reachable.
+	mapping *Mapping
 }
 
 func hasher(data []byte) uint64 {
@@ -52,8 +57,16 @@ func ReadVarints(r io.Reader) (*CHD, error) {
 }
 
 // Mmap creates a new CHD aliasing the CHD structure over an existing byte region (typically mmapped).
-func Mmap(b []byte, isVarints bool) (*CHD, error) {
-	c := &CHD{ValuesAreVarints: isVarints}
+func Mmap(b []byte, isVarints bool) (c *CHD, err error) {
+	// Truncated or corrupt data makes the reads below index past
+	// the end of b.
+	defer func() {
+		if r := recover(); r != nil {
+			c, err = nil, fmt.Errorf("phtable: corrupt table: %v", r)
+		}
+	}()
+
+	c = &CHD{ValuesAreVarints: isVarints}
 
 	bi := &sliceReader{b: b}
 
@@ -147,6 +160,29 @@ func (c *CHD) Len() int {
 	return len(c.keys)
 }
 
+// ValueCount returns the number of non-empty values in the hash
+// table. Unused slots in the table have empty values (or 0, if
+// ValuesAreVarints), so this is the number of entries unless some
+// entries' values are themselves empty or 0. Unlike Len, it doesn't
+// require StoreKeys.
+func (c *CHD) ValueCount() int {
+	n := 0
+	if c.ValuesAreVarints {
+		for _, v := range c.valueVarints {
+			if v != 0 {
+				n++
+			}
+		}
+	} else {
+		for _, v := range c.values {
+			if len(v) != 0 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
 // Iterate over entries in the hash table.
 func (c *CHD) Iterate() *Iterator {
 	if len(c.keys) == 0 {
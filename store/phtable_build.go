@@ -0,0 +1,48 @@
+package store
+
+import (
+	"bytes"
+
+	"sourcegraph.com/sourcegraph/srclib/store/phtable"
+)
+
+// externalPhtableMinKeys is the number of keys at which buildPhtable
+// builds a phtable with phtable.ExternalBuilder instead of in memory.
+var externalPhtableMinKeys = 1000000
+
+// buildPhtable builds a phtable of the n entries that each adds. Large
+// tables are built with phtable.ExternalBuilder (which spills the
+// entries to disk), so that building one doesn't take much more
+// memory than the table itself.
+func buildPhtable(n int, storeKeys bool, each func(add func(k, v []byte) error) error) (*phtable.CHD, error) {
+	if n < externalPhtableMinKeys {
+		b := phtable.Builder(n)
+		if err := each(func(k, v []byte) error {
+			b.Add(k, v)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		h, err := b.Build()
+		if err != nil {
+			return nil, err
+		}
+		h.StoreKeys = storeKeys
+		return h, nil
+	}
+
+	vlog.Printf("Building phtable of %d keys with external builder...", n)
+	b, err := phtable.NewExternalBuilder("", false)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+	if err := each(b.Add); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := b.Build(&buf, storeKeys); err != nil {
+		return nil, err
+	}
+	return phtable.Mmap(buf.Bytes(), false)
+}
@@ -95,5 +95,13 @@ func (x *defPathIndex) Read(r io.Reader) error {
 	return err
 }
 
+// Map implements mappedIndex.
+func (x *defPathIndex) Map(m *phtable.Mapping) error {
+	var err error
+	x.phtable, err = phtable.ReadMapping(m, true)
+	x.ready = (err == nil)
+	return err
+}
+
 // Ready implements persistedIndex.
 func (x *defPathIndex) Ready() bool { return x.ready }
@@ -0,0 +1,252 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kr/fs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// PruneOptions specifies the commits that PruneCommits keeps. All
+// other commits are removed.
+type PruneOptions struct {
+	// KeepLast is the number of each repo's most recently imported
+	// commits to keep.
+	KeepLast int
+
+	// Keep, if set, is called for each commit that isn't one of its
+	// repo's KeepLast most recent, and the commit is kept if it
+	// returns true. Repo is "" for commits in a RepoStore.
+	Keep func(repo, commitID string) bool
+
+	// DryRun is whether to only report the commits that would be
+	// removed, without removing them.
+	DryRun bool
+}
+
+// A PrunedCommit is a commit removed (or, with DryRun, that would be
+// removed) by PruneCommits.
+type PrunedCommit struct {
+	Repo     string // "" for commits in a RepoStore
+	CommitID string
+
+	// Imported is when the commit was imported (see SetImportTime)
+	// or, if that wasn't recorded, when its data was last written.
+	Imported time.Time
+
+	// Bytes is the total size of the commit's data and indexes. Files
+	// that DedupeCommits moved to blobs are counted as the size of
+	// their pointers; the blobs are only removed (by
+	// CollectBlobGarbage) once no commit points to them.
+	Bytes int64
+}
+
+// PruneCommits removes the data and indexes of the commits in s (an
+// FS-backed RepoStore or MultiRepoStore) that opt doesn't keep, and
+// returns the removed commits. Commits are ordered by the import time
+// recorded by SetImportTime or, for commits without one, by the time
+// that their data was last written.
+func PruneCommits(s interface{}, opt PruneOptions) ([]*PrunedCommit, error) {
+	repos := map[string]*fsRepoStore{}
+	switch s := s.(type) {
+	case *fsRepoStore:
+		repos[""] = s
+	case *fsMultiRepoStore:
+		rss, err := s.openAllRepoStores()
+		if err != nil {
+			return nil, err
+		}
+		for repo, rs := range rss {
+			repos[repo] = rs.(*fsRepoStore)
+		}
+	default:
+		// Reuse fsRepoStoresIn's error for unsupported stores.
+		_, err := fsRepoStoresIn(s)
+		return nil, err
+	}
+
+	var pruned []*PrunedCommit
+	for repo, rs := range repos {
+		commits, err := rs.commitsByImportTime(repo)
+		if err != nil {
+			return nil, err
+		}
+		for i, c := range commits {
+			if i < opt.KeepLast || (opt.Keep != nil && opt.Keep(repo, c.CommitID)) {
+				continue
+			}
+			if !opt.DryRun {
+				if err := rs.removeCommit(c.CommitID); err != nil {
+					return nil, err
+				}
+				if mrs, ok := s.(*fsMultiRepoStore); ok {
+					if err := mrs.RemoveXRefs(repo, c.CommitID); err != nil {
+						return nil, err
+					}
+				}
+			}
+			pruned = append(pruned, c)
+		}
+	}
+	sort.Sort(prunedCommitsByRepo(pruned))
+	return pruned, nil
+}
+
+// commitsByImportTime returns the repo store's commits, most recently
+// imported first.
+func (s *fsRepoStore) commitsByImportTime(repo string) ([]*PrunedCommit, error) {
+	dirs, err := s.versionDirs()
+	if err != nil {
+		return nil, err
+	}
+	commits := make([]*PrunedCommit, len(dirs))
+	for i, dir := range dirs {
+		c := &PrunedCommit{Repo: repo, CommitID: dir}
+		imported, ok, err := s.importTime(dir)
+		if err != nil {
+			return nil, err
+		}
+		w := fs.WalkFS(dir, rwvfs.Walkable(s.fs))
+		for w.Step() {
+			if err := w.Err(); err != nil {
+				return nil, err
+			}
+			fi := w.Stat()
+			if fi.Mode().IsRegular() {
+				c.Bytes += fi.Size()
+			}
+			if fi.ModTime().After(c.Imported) {
+				c.Imported = fi.ModTime()
+			}
+		}
+		if ok {
+			c.Imported = imported
+		}
+		commits[i] = c
+	}
+	sort.Sort(commitsByImportTime(commits))
+	return commits, nil
+}
+
+// importTimeFile is the file in each commit's dir that holds the time
+// that the commit was imported (see SetImportTime). It is hidden, so
+// that it isn't taken for the commit's data.
+const importTimeFile = ".imported"
+
+// SetImportTime records t as the time that repo's commitID was
+// imported into s (an FS-backed RepoStore or MultiRepoStore; the repo
+// is only used for the latter). If the commit isn't in s, it does
+// nothing.
+//
+// PruneCommits orders commits by their import times, not by the times
+// that their files were last written, because other commands (such as
+// `src store compact`, `src store dedupe`, and `src store anns
+// --import`) rewrite the files of commits imported long before.
+func SetImportTime(s interface{}, repo, commitID string, t time.Time) error {
+	rs, err := fsRepoStoreFor(s, repo)
+	if err != nil {
+		return err
+	}
+	if _, err := rs.fs.Stat(commitID); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return writeFile(rs.fs, path.Join(commitID, importTimeFile), []byte(t.UTC().Format(time.RFC3339Nano)+"\n"))
+}
+
+// ImportTime returns the import time of repo's commitID in s recorded
+// by SetImportTime. If none was recorded, ok is false.
+func ImportTime(s interface{}, repo, commitID string) (t time.Time, ok bool, err error) {
+	rs, err := fsRepoStoreFor(s, repo)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return rs.importTime(commitID)
+}
+
+func (s *fsRepoStore) importTime(commitID string) (t time.Time, ok bool, err error) {
+	f, err := s.fs.Open(path.Join(commitID, importTimeFile))
+	if os.IsNotExist(err) {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	t, err = time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("commit %s: bad import time: %s", commitID, err)
+	}
+	return t, true, nil
+}
+
+// fsRepoStoreFor returns the FS-backed repo store in s that holds
+// repo's commits (s itself, if it is a RepoStore).
+func fsRepoStoreFor(s interface{}, repo string) (*fsRepoStore, error) {
+	switch s := s.(type) {
+	case *fsRepoStore:
+		return s, nil
+	case *fsMultiRepoStore:
+		return s.openRepoStore(repo).(*fsRepoStore), nil
+	}
+	return nil, fmt.Errorf("store (type %T) does not record import times", s)
+}
+
+// removeCommit removes the commit's dir and everything in it.
+func (s *fsRepoStore) removeCommit(commitID string) error {
+	var dirs []string
+	w := fs.WalkFS(commitID, rwvfs.Walkable(s.fs))
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			return err
+		}
+		if w.Stat().IsDir() {
+			dirs = append(dirs, w.Path())
+		} else if err := s.fs.Remove(w.Path()); err != nil {
+			return err
+		}
+	}
+	// Remove leaf dirs first. Some filesystems (e.g., in-memory and
+	// object storage ones) only have implicit dirs, which are gone
+	// once their files are removed.
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		if err := s.fs.Remove(dir); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+type commitsByImportTime []*PrunedCommit
+
+func (v commitsByImportTime) Len() int      { return len(v) }
+func (v commitsByImportTime) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v commitsByImportTime) Less(i, j int) bool {
+	if !v[i].Imported.Equal(v[j].Imported) {
+		return v[i].Imported.After(v[j].Imported)
+	}
+	return v[i].CommitID < v[j].CommitID
+}
+
+type prunedCommitsByRepo []*PrunedCommit
+
+func (v prunedCommitsByRepo) Len() int      { return len(v) }
+func (v prunedCommitsByRepo) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v prunedCommitsByRepo) Less(i, j int) bool {
+	if v[i].Repo != v[j].Repo {
+		return v[i].Repo < v[j].Repo
+	}
+	return v[i].CommitID < v[j].CommitID
+}
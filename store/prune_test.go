@@ -0,0 +1,153 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestPruneCommits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-prune-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rs := NewFSRepoStore(rwvfs.OS(dir))
+
+	// Import c1, c2, and c3, in that order.
+	commitIDs := []string{"c1", "c2", "c3"}
+	for i, commitID := range commitIDs {
+		data := graph.Output{Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, Name: "p", File: "f"}}}
+		if err := rs.Import(commitID, &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f"}}, data); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(time.Duration(i-len(commitIDs)) * time.Hour)
+		if err := filepath.Walk(filepath.Join(dir, commitID), func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Chtimes(path, mtime, mtime)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prunedIDs := func(pruned []*PrunedCommit) []string {
+		var ids []string
+		for _, c := range pruned {
+			if c.Bytes == 0 {
+				t.Errorf("commit %s: got 0 bytes", c.CommitID)
+			}
+			ids = append(ids, c.CommitID)
+		}
+		return ids
+	}
+	versionIDs := func() []string {
+		versions, err := rs.Versions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, v := range versions {
+			ids = append(ids, v.CommitID)
+		}
+		return ids
+	}
+
+	keepC1 := func(repo, commitID string) bool { return commitID == "c1" }
+	pruned, err := PruneCommits(rs, PruneOptions{KeepLast: 1, Keep: keepC1, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := prunedIDs(pruned), []string{"c2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dry run: got pruned %v, want %v", got, want)
+	}
+	if got := versionIDs(); !reflect.DeepEqual(got, commitIDs) {
+		t.Errorf("dry run: got versions %v, want %v", got, commitIDs)
+	}
+
+	pruned, err = PruneCommits(rs, PruneOptions{KeepLast: 1, Keep: keepC1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := prunedIDs(pruned), []string{"c2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got pruned %v, want %v", got, want)
+	}
+	if got, want := versionIDs(), []string{"c1", "c3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got versions %v, want %v", got, want)
+	}
+}
+
+func TestPruneCommits_importTime(t *testing.T) {
+	rs := NewFSRepoStore(rwvfs.Map(map[string]string{}))
+	for _, commitID := range []string{"c1", "c2"} {
+		if err := rs.Import(commitID, &unit.SourceUnit{Type: "t", Name: "u"}, graph.Output{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// c2 was imported after c1. (The in-memory filesystem's files
+	// have no mtimes, so without import times they'd be ordered by
+	// commit ID.)
+	now := time.Now()
+	if err := SetImportTime(rs, "", "c1", now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetImportTime(rs, "", "c2", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetImportTime(rs, "", "nonexistent", now); err != nil {
+		t.Fatal(err)
+	}
+	if imported, ok, err := ImportTime(rs, "", "c2"); err != nil || !ok || !imported.Equal(now) {
+		t.Errorf("got import time %v (ok %v, error %v), want %v", imported, ok, err, now)
+	}
+
+	pruned, err := PruneCommits(rs, PruneOptions{KeepLast: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 1 || pruned[0].CommitID != "c1" {
+		t.Errorf("got pruned %+v, want only c1", pruned)
+	}
+	versions, err := rs.Versions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].CommitID != "c2" {
+		t.Errorf("got versions %+v, want only c2", versions)
+	}
+}
+
+func TestPruneCommits_multiRepo(t *testing.T) {
+	mrs := NewFSMultiRepoStore(rwvfs.Walkable(rwvfs.Map(map[string]string{})), nil)
+	ref := &graph.Ref{DefRepo: "lib", DefUnitType: "t", DefUnit: "u", DefPath: "p", File: "f", Start: 1, End: 2}
+	for _, commitID := range []string{"c1", "c2"} {
+		if err := mrs.Import("a", commitID, &unit.SourceUnit{Type: "t", Name: "u"}, graph.Output{Refs: []*graph.Ref{ref}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pruned, err := PruneCommits(mrs, PruneOptions{Keep: func(repo, commitID string) bool { return commitID == "c2" }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 1 || pruned[0].Repo != "a" || pruned[0].CommitID != "c1" {
+		t.Errorf("got pruned %+v, want only a@c1", pruned)
+	}
+
+	// The pruned commit's xrefs are removed from the index.
+	xrefs, err := mrs.(XRefStore).XRefs(graph.RefDefKey{DefRepo: "lib", DefUnitType: "t", DefUnit: "u", DefPath: "p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(xrefs) != 1 || xrefs[0].CommitID != "c2" {
+		t.Errorf("got xrefs %+v, want only from a@c2", xrefs)
+	}
+}
@@ -116,5 +116,13 @@ func (x *refFileIndex) Read(r io.Reader) error {
 	return err
 }
 
+// Map implements mappedIndex.
+func (x *refFileIndex) Map(m *phtable.Mapping) error {
+	var err error
+	x.phtable, err = phtable.ReadMapping(m, false)
+	x.ready = (err == nil)
+	return err
+}
+
 // Ready implements persistedIndex.
 func (x *refFileIndex) Ready() bool { return x.ready }
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// SharedIndexDir, if set, is a local directory in which the indexes
+// of imported source units are shared between stores (e.g., those of
+// several checkouts of the same repo, or of CI jobs that re-clone it).
+// A unit's indexes are determined by the contents of its def and ref
+// data files, so they're kept under a SHA-256 hash of those files,
+// and importing a unit whose data files were already indexed (in any
+// store that uses the directory) copies the indexes from it instead
+// of building them. Like Codec, it should only be set at init time.
+var SharedIndexDir string
+
+// sharedIndexVersion is part of the path of shared index files. It
+// must be incremented when the format of an index file changes.
+const sharedIndexVersion = 1
+
+// sharedIndexKey returns the key of the unit's indexes in
+// SharedIndexDir: a hash of its (raw, possibly compressed) def and
+// ref data files.
+func sharedIndexKey(fs rwvfs.FileSystem) (string, error) {
+	h := sha256.New()
+	for _, name := range []string{unitDefsFilename, unitRefsFilename} {
+		f, err := fs.Open(name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00", name)
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sharedIndexFile returns the path of the named index file with the
+// given key in SharedIndexDir.
+func sharedIndexFile(key, name string) string {
+	return filepath.Join(SharedIndexDir, fmt.Sprintf("v%d", sharedIndexVersion), key[:2], key[2:], fmt.Sprintf(indexFilename, name))
+}
+
+// copySharedIndexes copies the indexes in xs that are in
+// SharedIndexDir under key into fs, and it returns the indexes that
+// must be built.
+func copySharedIndexes(fs rwvfs.FileSystem, key string, xs map[string]Index) (map[string]Index, error) {
+	build := make(map[string]Index, len(xs))
+	for name, x := range xs {
+		if _, ok := x.(persistedIndex); !ok {
+			build[name] = x
+			continue
+		}
+		src, err := os.Open(sharedIndexFile(key, name))
+		if os.IsNotExist(err) {
+			build[name] = x
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		err = copySharedIndex(fs, fmt.Sprintf(indexFilename, name), src)
+		src.Close()
+		if err != nil {
+			return nil, err
+		}
+		// Verify the copy's checksum now, since mapped index files'
+		// checksums aren't verified when they're read (see
+		// readUncompressedIndex).
+		if err := readIndex(fs, name, rawIndex{}); err != nil {
+			vlog.Printf("%s: rebuilding index instead of using the shared copy: %s.", name, err)
+			build[name] = x
+			continue
+		}
+		if x.Ready() {
+			// Don't leave the data of an index that was built or
+			// read earlier in memory.
+			if err := readIndex(fs, name, x.(persistedIndex)); err != nil {
+				return nil, err
+			}
+		}
+		vlog.Printf("%s: copied index from shared index dir (key %s).", name, key)
+	}
+	return build, nil
+}
+
+func copySharedIndex(fs rwvfs.FileSystem, filename string, src io.Reader) (err error) {
+	// Don't truncate an old index file that may be mapped (see
+	// writeIndex).
+	fs.Remove(filename)
+	f, err := fs.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+	}()
+	_, err = io.Copy(f, src)
+	return err
+}
+
+// shareIndexes adds the persisted indexes in xs, which were written
+// to fs, to SharedIndexDir under key.
+func shareIndexes(fs rwvfs.FileSystem, key string, xs map[string]Index) error {
+	for name, x := range xs {
+		if _, ok := x.(persistedIndex); !ok {
+			continue
+		}
+		if err := shareIndex(fs, name, sharedIndexFile(key, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func shareIndex(fs rwvfs.FileSystem, name, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	src, err := fs.Open(fmt.Sprintf(indexFilename, name))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Write to a temp file and rename it so that other processes
+	// sharing the dir never read a partially written index.
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
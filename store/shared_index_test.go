@@ -0,0 +1,86 @@
+package store
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"golang.org/x/tools/godoc/vfs"
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestSharedIndexDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-shared-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(orig string) { SharedIndexDir = orig }(SharedIndexDir)
+	SharedIndexDir = dir
+
+	data := graph.Output{
+		Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p"}, Name: "p", File: "f"}},
+		Refs: []*graph.Ref{{DefPath: "p", File: "f", Start: 1, End: 2}},
+	}
+	importUnit := func() rwvfs.FileSystem {
+		fs := rwvfs.Map(map[string]string{})
+		if err := newIndexedUnitStore(fs, "").(UnitStoreImporter).Import(data); err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	}
+
+	fs1 := importUnit()
+	key, err := sharedIndexKey(fs1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := defParentsIndexName
+	idx1, err := vfs.ReadFile(fs1, "def_parents.idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := ioutil.ReadFile(sharedIndexFile(key, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(shared) != string(idx1) {
+		t.Errorf("shared index differs from the one written to the store")
+	}
+
+	// Importing the same data copies the shared index instead of
+	// building it.
+	sum := make([]byte, crc32.Size)
+	binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE([]byte("shared")))
+	if err := ioutil.WriteFile(sharedIndexFile(key, name), append([]byte("shared"), sum...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	idx2, err := vfs.ReadFile(importUnit(), "def_parents.idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(idx2), "shared"+string(sum); got != want {
+		t.Errorf("got index %q, want %q", got, want)
+	}
+
+	// A shared index whose checksum doesn't match is rebuilt.
+	if err := ioutil.WriteFile(sharedIndexFile(key, name), []byte("corrupt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fs2 := importUnit()
+	if err := readIndex(fs2, name, rawIndex{}); err != nil {
+		t.Errorf("got error %v reading index rebuilt instead of corrupt shared index, want nil", err)
+	}
+
+	// Different data has a different key.
+	data.Defs[0].Name = "q"
+	fs3 := importUnit()
+	if key3, err := sharedIndexKey(fs3); err != nil {
+		t.Fatal(err)
+	} else if key3 == key {
+		t.Errorf("got same key %q for different data", key)
+	}
+}
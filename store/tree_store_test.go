@@ -3,6 +3,7 @@ package store
 import (
 	"fmt"
 	"reflect"
+	"regexp"
 	"testing"
 
 	"sort"
@@ -39,9 +40,13 @@ func testTreeStore(t *testing.T, newFn func() TreeStoreImporter) {
 	testTreeStore_Defs_Query_ByUnit(t, newFn())
 	testTreeStore_Defs_ByUnits(t, newFn())
 	testTreeStore_Defs_ByFiles(t, newFn())
+	testTreeStore_Defs_ByFilePrefix(t, newFn())
+	testTreeStore_Defs_ByNameRegexp(t, newFn())
 	testTreeStore_Refs(t, newFn())
 	testTreeStore_Refs_ByFiles(t, newFn())
 	testTreeStore_Refs_ByDef(t, newFn())
+	testTreeStore_Refs_ByRefDefFiles(t, newFn())
+	testTreeStore_Refs_ByRefDefRepos(t, newFn())
 }
 
 func testTreeStore_uninitialized(t *testing.T, ts TreeStore) {
@@ -598,6 +603,91 @@ func testTreeStore_Defs_ByFiles(t *testing.T, ts TreeStoreImporter) {
 	}
 }
 
+func testTreeStore_Defs_ByFilePrefix(t *testing.T, ts TreeStoreImporter) {
+	units := []*unit.SourceUnit{
+		{Type: "t1", Name: "u1", Files: []string{"a/f1"}},
+		{Type: "t2", Name: "u2", Files: []string{"b/f2", "b/g2"}},
+	}
+	for i, unit := range units {
+		var data graph.Output
+		for j, file := range unit.Files {
+			data.Defs = append(data.Defs, &graph.Def{DefKey: graph.DefKey{Path: fmt.Sprintf("p%d%d", i+1, j+1)}, File: file})
+		}
+		if err := ts.Import(unit, data); err != nil {
+			t.Errorf("%s: Import(%v, data): %s", ts, unit, err)
+		}
+	}
+	if ts, ok := ts.(TreeIndexer); ok {
+		if err := ts.Index(); err != nil {
+			t.Fatalf("%s: Index: %s", ts, err)
+		}
+	}
+
+	want := []*graph.Def{
+		{DefKey: graph.DefKey{UnitType: "t2", Unit: "u2", Path: "p21"}, File: "b/f2"},
+	}
+
+	c_fsTreeStore_unitsOpened.set(0)
+	defs, err := ts.Defs(ByFilePrefix("b/f"))
+	if err != nil {
+		t.Errorf("%s: Defs(ByFilePrefix b/f): %s", ts, err)
+	}
+	if !reflect.DeepEqual(defs, want) {
+		t.Errorf("%s: Defs(ByFilePrefix b/f): got defs %v, want %v", ts, defs, want)
+	}
+	if isIndexedStore(ts) {
+		if max := 1; c_fsTreeStore_unitsOpened.get() > max {
+			t.Errorf("%s: Defs(ByFilePrefix b/f): got %d units opened, want at most %d (should skip units without files with the prefix)", ts, c_fsTreeStore_unitsOpened.get(), max)
+		}
+	}
+}
+
+func testTreeStore_Defs_ByNameRegexp(t *testing.T, ts TreeStoreImporter) {
+	data := graph.Output{
+		Defs: []*graph.Def{
+			{DefKey: graph.DefKey{Path: "p1"}, Name: "NewStore"},
+			{DefKey: graph.DefKey{Path: "p2"}, Name: "NewUnitStore"},
+			{DefKey: graph.DefKey{Path: "p3"}, Name: "newStore"},
+			{DefKey: graph.DefKey{Path: "p4"}, Name: "OpenStore"},
+		},
+	}
+	if err := ts.Import(&unit.SourceUnit{Type: "t", Name: "u"}, data); err != nil {
+		t.Errorf("%s: Import(data): %s", ts, err)
+	}
+	if ts, ok := ts.(TreeIndexer); ok {
+		if err := ts.Index(); err != nil {
+			t.Fatalf("%s: Index: %s", ts, err)
+		}
+	}
+
+	tests := []struct {
+		re            string
+		wantDefPaths  []string
+		wantIndexHits int
+	}{
+		{re: "^New.*Store$", wantDefPaths: []string{"p1", "p2"}, wantIndexHits: 1},
+		{re: "^newS", wantDefPaths: []string{"p3"}, wantIndexHits: 1},
+		{re: "^(?i)newstore", wantDefPaths: []string{"p1", "p3"}},
+		{re: "nStore", wantDefPaths: []string{"p4"}},
+		{re: "^New|^Open", wantDefPaths: []string{"p1", "p2", "p4"}},
+	}
+	for _, test := range tests {
+		c_defQueryTreeIndex_getByQuery.set(0)
+		defs, err := ts.Defs(ByNameRegexp(regexp.MustCompile(test.re)))
+		if err != nil {
+			t.Errorf("%s: Defs(ByNameRegexp %q): %s", ts, test.re, err)
+		}
+		if got, want := defPaths(defs), test.wantDefPaths; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Defs(ByNameRegexp %q): got defs %v, want %v", ts, test.re, got, want)
+		}
+		if isIndexedStore(ts) {
+			if want := test.wantIndexHits; c_defQueryTreeIndex_getByQuery.get() != want {
+				t.Errorf("%s: Defs(ByNameRegexp %q): got %d index hits, want %d", ts, test.re, c_defQueryTreeIndex_getByQuery.get(), want)
+			}
+		}
+	}
+}
+
 func testTreeStore_Refs(t *testing.T, ts TreeStoreImporter) {
 	unit := &unit.SourceUnit{Type: "t", Name: "u", Files: []string{"f1", "f2"}}
 	data := graph.Output{
@@ -808,3 +898,135 @@ func testTreeStore_Refs_ByDef(t *testing.T, ts TreeStoreImporter) {
 		}
 	}
 }
+
+func testTreeStore_Refs_ByRefDefFiles(t *testing.T, ts TreeStoreImporter) {
+	dataByUnit := map[string]graph.Output{
+		"u1": {
+			Defs: []*graph.Def{
+				{DefKey: graph.DefKey{Path: "p1"}, File: "f1"},
+				{DefKey: graph.DefKey{Path: "p2"}, File: "f2"},
+			},
+			Refs: []*graph.Ref{
+				{DefPath: "p1", File: "f1", Start: 0, End: 1},
+				{DefPath: "p2", File: "f1", Start: 1, End: 2},
+				{DefPath: "q1", DefUnit: "u2", File: "f1", Start: 2, End: 3},
+				{DefPath: "p1", DefRepo: "r2", File: "f1", Start: 3, End: 4},
+			},
+		},
+		"u2": {
+			Defs: []*graph.Def{
+				{DefKey: graph.DefKey{Path: "q1"}, File: "f3"},
+			},
+			Refs: []*graph.Ref{
+				{DefPath: "p1", DefUnit: "u1", File: "f3", Start: 0, End: 1},
+				{DefPath: "q1", File: "f3", Start: 1, End: 2},
+			},
+		},
+	}
+	for unitName, data := range dataByUnit {
+		u := &unit.SourceUnit{Type: "t", Name: unitName}
+		for _, def := range data.Defs {
+			u.Files = append(u.Files, def.File)
+		}
+		if err := ts.Import(u, data); err != nil {
+			t.Errorf("%s: Import(%v, data): %s", ts, u, err)
+		}
+	}
+	if ts, ok := ts.(TreeIndexer); ok {
+		if err := ts.Index(); err != nil {
+			t.Fatalf("%s: Index: %s", ts, err)
+		}
+	}
+
+	tests := map[string][]string{
+		"f1": {"u1:f1:0", "u2:f3:0"},
+		"f2": {"u1:f1:1"},
+		"f3": {"u1:f1:2", "u2:f3:1"},
+		"f4": nil,
+	}
+	for file, want := range tests {
+		c_defFileRefsIndex_getByFile.set(0)
+		refs, err := ts.Refs(ByRefDefFiles(file))
+		if err != nil {
+			t.Fatalf("%s: Refs(ByRefDefFiles %s): %s", ts, file, err)
+		}
+		var got []string
+		for _, ref := range refs {
+			got = append(got, fmt.Sprintf("%s:%s:%d", ref.Unit, ref.File, ref.Start))
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Refs(ByRefDefFiles %s): got refs %v, want %v", ts, file, got, want)
+		}
+		if isIndexedStore(ts) {
+			if want := 1; c_defFileRefsIndex_getByFile.get() != want {
+				t.Errorf("%s: Refs(ByRefDefFiles %s): got %d index hits, want %d", ts, file, c_defFileRefsIndex_getByFile.get(), want)
+			}
+		}
+	}
+}
+
+func testTreeStore_Refs_ByRefDefRepos(t *testing.T, ts TreeStoreImporter) {
+	dataByUnit := map[string]graph.Output{
+		"u1": {
+			Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "p1"}, File: "f1"}},
+			Refs: []*graph.Ref{
+				{DefPath: "p1", File: "f1", Start: 0, End: 1},
+				{DefPath: "x", DefRepo: "r2", DefUnit: "v", File: "f1", Start: 1, End: 2},
+				{DefPath: "y", DefRepo: "r3", DefUnit: "v", File: "f1", Start: 2, End: 3},
+			},
+		},
+		"u2": {
+			Defs: []*graph.Def{{DefKey: graph.DefKey{Path: "q1"}, File: "f2"}},
+			Refs: []*graph.Ref{
+				{DefPath: "z", DefRepo: "r2", DefUnit: "w", File: "f2", Start: 0, End: 1},
+				{DefPath: "p1", DefUnit: "u1", File: "f2", Start: 1, End: 2},
+			},
+		},
+	}
+	for unitName, data := range dataByUnit {
+		u := &unit.SourceUnit{Type: "t", Name: unitName}
+		for _, def := range data.Defs {
+			u.Files = append(u.Files, def.File)
+		}
+		if err := ts.Import(u, data); err != nil {
+			t.Errorf("%s: Import(%v, data): %s", ts, u, err)
+		}
+	}
+	if ts, ok := ts.(TreeIndexer); ok {
+		if err := ts.Index(); err != nil {
+			t.Fatalf("%s: Index: %s", ts, err)
+		}
+	}
+
+	tests := []struct {
+		repos []string
+		want  []string
+	}{
+		{[]string{"r2"}, []string{"u1:f1:1", "u2:f2:0"}},
+		{[]string{"r3"}, []string{"u1:f1:2"}},
+		{[]string{"r2", "r3", "r2"}, []string{"u1:f1:1", "u1:f1:2", "u2:f2:0"}},
+		{[]string{"R2"}, []string{"u1:f1:1", "u2:f2:0"}},
+		{[]string{"r4"}, nil},
+	}
+	for _, test := range tests {
+		c_defRepoRefsIndex_getByRepo.set(0)
+		refs, err := ts.Refs(ByRefDefRepos(test.repos...))
+		if err != nil {
+			t.Fatalf("%s: Refs(ByRefDefRepos %v): %s", ts, test.repos, err)
+		}
+		var got []string
+		for _, ref := range refs {
+			got = append(got, fmt.Sprintf("%s:%s:%d", ref.Unit, ref.File, ref.Start))
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Refs(ByRefDefRepos %v): got refs %v, want %v", ts, test.repos, got, test.want)
+		}
+		if isIndexedStore(ts) {
+			if want := 1; c_defRepoRefsIndex_getByRepo.get() < want {
+				t.Errorf("%s: Refs(ByRefDefRepos %v): got %d index hits, want at least %d", ts, test.repos, c_defRepoRefsIndex_getByRepo.get(), want)
+			}
+		}
+	}
+}
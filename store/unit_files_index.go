@@ -163,5 +163,13 @@ func (x *unitFilesIndex) Read(r io.Reader) error {
 	return err
 }
 
+// Map implements mappedIndex.
+func (x *unitFilesIndex) Map(m *phtable.Mapping) error {
+	var err error
+	x.phtable, err = phtable.ReadMapping(m, false)
+	x.ready = (err == nil)
+	return err
+}
+
 // Ready implements persistedIndex.
 func (x *unitFilesIndex) Ready() bool { return x.ready }
@@ -105,6 +105,13 @@ func filtersForUnit(unit unit.ID2, filters interface{}) interface{} {
 				panic(fmt.Sprintf("in unitDefOffsetsFilter, no unit == %v", unit))
 			}
 
+		case unitRefOffsetsFilter:
+			ofs, found := f[unit]
+			if !found {
+				panic(fmt.Sprintf("in unitRefOffsetsFilter, no unit == %v", unit))
+			}
+			unitFilters[i+d] = refOffsetsFilter(ofs)
+
 		case byUnitsFilter:
 			found := false
 			for _, u := range f.ByUnits() {
@@ -0,0 +1,325 @@
+package store
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/godoc/vfs"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// xrefsDir is the dir (at the root of a multi-repo store) that holds
+// the store's xref index. It's hidden, so it isn't listed as a repo.
+//
+// The index is split into shards by a hash of each def's key, so that
+// a def's xrefs are found by reading a single shard file, and each
+// commit's sources file lists the shards that hold the xrefs from each
+// of its source units, so that they can be replaced without reading
+// every shard.
+const xrefsDir = ".srclib-xrefs"
+
+// An XRefStore is a multi-repo store that keeps an xref index: for
+// each def, the refs to it from the store's other repos. The index is
+// updated when data is imported, so that the usages of a def across
+// all repos can be found without scanning each repo.
+type XRefStore interface {
+	// XRefs returns the xrefs to the def with the given key, whose
+	// DefRepo must be set. Refs from the def's own repo aren't
+	// included.
+	XRefs(def graph.RefDefKey) ([]*XRef, error)
+
+	// IndexXRefs replaces the xrefs from a commit of repo with those
+	// from the commit's current data. Import keeps the index up to
+	// date, so this is only needed after data is written to the
+	// repo's store directly (e.g., by moving a commit into place).
+	IndexXRefs(repo, commitID string) error
+
+	// RemoveXRefs removes the xrefs from a commit of repo (e.g.,
+	// because the commit was removed from the store).
+	RemoveXRefs(repo, commitID string) error
+}
+
+// An XRef is a set of refs to a def from a source unit at a commit of
+// another repo.
+type XRef struct {
+	// Def is the key of the def that the refs are to.
+	Def graph.RefDefKey
+
+	// Repo, CommitID, UnitType, and Unit identify the source unit
+	// that the refs are in.
+	Repo     string
+	CommitID string
+	UnitType string
+	Unit     string
+
+	// Refs are the locations of the refs.
+	Refs []XRefRange
+}
+
+// An XRefRange is the location of a ref in an XRef's source unit.
+type XRefRange struct {
+	File  string
+	Start uint32
+	End   uint32
+}
+
+// xrefsFromRefs returns the xrefs in the given refs from a commit of
+// repo. Refs to defs in repo aren't xrefs. If u is non-nil, the refs
+// are all from source unit u; otherwise, each ref's UnitType and Unit
+// must be set. An empty DefUnitType or DefUnit refers to the ref's own
+// source unit.
+func xrefsFromRefs(repo, commitID string, u *unit.ID2, refs []*graph.Ref) []*XRef {
+	type key struct {
+		def            graph.RefDefKey
+		unitType, unit string
+	}
+	byKey := map[key]*XRef{}
+	var xs []*XRef
+	for _, ref := range refs {
+		if ref.DefRepo == "" || graph.URIEqual(ref.DefRepo, repo) {
+			continue
+		}
+		k := key{def: graph.RefDefKey{DefRepo: ref.DefRepo, DefUnitType: ref.DefUnitType, DefUnit: ref.DefUnit, DefPath: ref.DefPath}, unitType: ref.UnitType, unit: ref.Unit}
+		if u != nil {
+			k.unitType, k.unit = u.Type, u.Name
+		}
+		if k.def.DefUnitType == "" {
+			k.def.DefUnitType = k.unitType
+		}
+		if k.def.DefUnit == "" {
+			k.def.DefUnit = k.unit
+		}
+		x := byKey[k]
+		if x == nil {
+			x = &XRef{Def: k.def, Repo: repo, CommitID: commitID, UnitType: k.unitType, Unit: k.unit}
+			byKey[k] = x
+			xs = append(xs, x)
+		}
+		x.Refs = append(x.Refs, XRefRange{File: ref.File, Start: ref.Start, End: ref.End})
+	}
+	return xs
+}
+
+// unitID2 returns u's ID2, or nil if u is nil.
+func unitID2(u *unit.SourceUnit) *unit.ID2 {
+	if u == nil {
+		return nil
+	}
+	id := u.ID2()
+	return &id
+}
+
+// xrefShard returns the name of the shard file that holds the xrefs
+// to def. Repo URIs are case-insensitive (see graph.URIEqual).
+func xrefShard(def graph.RefDefKey) string {
+	h := sha1.Sum([]byte(strings.Join([]string{strings.ToLower(def.DefRepo), def.DefUnitType, def.DefUnit, def.DefPath}, "\x00")))
+	return fmt.Sprintf("%02x.json", h[0])
+}
+
+// xrefSourcesFile returns the name of the file that lists the shards
+// holding the xrefs from a commit of repo.
+func xrefSourcesFile(repo, commitID string) string {
+	h := sha1.Sum([]byte(strings.ToLower(repo) + "@" + commitID))
+	return fmt.Sprintf("sources/%x.json", h[:])
+}
+
+func (s *fsMultiRepoStore) XRefs(def graph.RefDefKey) ([]*XRef, error) {
+	if def.DefRepo == "" {
+		return nil, fmt.Errorf("xrefs: def %+v has no DefRepo", def)
+	}
+	var xs []*XRef
+	if err := readXRefsFile(s.fs, xrefShard(def), &xs); err != nil {
+		return nil, err
+	}
+	var matches []*XRef
+	for _, x := range xs {
+		if graph.URIEqual(x.Def.DefRepo, def.DefRepo) && x.Def.DefUnitType == def.DefUnitType && x.Def.DefUnit == def.DefUnit && x.Def.DefPath == def.DefPath {
+			matches = append(matches, x)
+		}
+	}
+	return matches, nil
+}
+
+func (s *fsMultiRepoStore) IndexXRefs(repo, commitID string) error {
+	// Read the commit's tree store directly instead of filtering the
+	// repo store by commit, which relies on the commit's unit index.
+	ts := s.openRepoStore(repo).(treeStoreOpener).openTreeStore(commitID)
+	refs, err := ts.Refs(RefFilterFunc(func(ref *graph.Ref) bool {
+		return ref.DefRepo != "" && !graph.URIEqual(ref.DefRepo, repo)
+	}))
+	if err != nil && !isStoreNotExist(err) {
+		return err
+	}
+	return s.putXRefs(repo, commitID, nil, xrefsFromRefs(repo, commitID, nil, refs))
+}
+
+// An xrefSource lists the shards that hold the xrefs from a source
+// unit. A commit's sources file holds the xrefSources of its units.
+type xrefSource struct {
+	UnitType string
+	Unit     string
+	Shards   []string
+}
+
+// putXRefs replaces the indexed xrefs from a commit of repo with xs.
+// If u is non-nil, only the xrefs from source unit u are replaced.
+func (s *fsMultiRepoStore) putXRefs(repo, commitID string, u *unit.ID2, xs []*XRef) error {
+	s.xrefsMu.Lock()
+	defer s.xrefsMu.Unlock()
+
+	sourcesFile := xrefSourcesFile(repo, commitID)
+	var sources []*xrefSource
+	if err := readXRefsFile(s.fs, sourcesFile, &sources); err != nil {
+		return err
+	}
+	replaced := func(unitType, unit string) bool {
+		return u == nil || (unitType == u.Type && unit == u.Name)
+	}
+
+	// Find the shards that hold the xrefs being replaced, and drop
+	// the replaced units from the sources.
+	var oldShards []string
+	keepSources := sources[:0]
+	for _, src := range sources {
+		if replaced(src.UnitType, src.Unit) {
+			oldShards = append(oldShards, src.Shards...)
+		} else {
+			keepSources = append(keepSources, src)
+		}
+	}
+	sources = keepSources
+
+	byShard := map[string][]*XRef{}
+	unitShards := map[unit.ID2][]string{}
+	for _, x := range xs {
+		shard := xrefShard(x.Def)
+		byShard[shard] = append(byShard[shard], x)
+		id := unit.ID2{Type: x.UnitType, Name: x.Unit}
+		unitShards[id] = append(unitShards[id], shard)
+	}
+	for id, shards := range unitShards {
+		sources = append(sources, &xrefSource{UnitType: id.Type, Unit: id.Name, Shards: unionStrings(nil, shards)})
+	}
+	sort.Sort(xrefSourcesByUnit(sources))
+
+	newShards := make([]string, 0, len(byShard))
+	for shard := range byShard {
+		newShards = append(newShards, shard)
+	}
+	for _, shard := range unionStrings(oldShards, newShards) {
+		var cur []*XRef
+		if err := readXRefsFile(s.fs, shard, &cur); err != nil {
+			return err
+		}
+		keep := cur[:0]
+		for _, x := range cur {
+			if graph.URIEqual(x.Repo, repo) && x.CommitID == commitID && replaced(x.UnitType, x.Unit) {
+				continue
+			}
+			keep = append(keep, x)
+		}
+		keep = append(keep, byShard[shard]...)
+		sort.Sort(xrefsByKey(keep))
+		if err := writeXRefsFile(s.fs, shard, len(keep), keep); err != nil {
+			return err
+		}
+	}
+
+	return writeXRefsFile(s.fs, sourcesFile, len(sources), sources)
+}
+
+func (s *fsMultiRepoStore) RemoveXRefs(repo, commitID string) error {
+	return s.putXRefs(repo, commitID, nil, nil)
+}
+
+// readXRefsFile decodes the JSON in the named file in the xref index
+// into v. If the file doesn't exist, v is left as it is.
+func readXRefsFile(fs rwvfs.FileSystem, name string, v interface{}) error {
+	data, err := vfs.ReadFile(fs, path.Join(xrefsDir, name))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("xref index file %s: %s", name, err)
+	}
+	return nil
+}
+
+// writeXRefsFile writes v (which has n elements) as JSON to the named
+// file in the xref index, or removes the file if n is 0.
+func writeXRefsFile(fs rwvfs.FileSystem, name string, n int, v interface{}) (err error) {
+	name = path.Join(xrefsDir, name)
+	if n == 0 {
+		if err := fs.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := rwvfs.MkdirAll(fs, path.Join(xrefsDir, "sources")); err != nil {
+		return err
+	}
+	f, err := fs.Create(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := f.Close(); err2 != nil && err == nil {
+			err = err2
+		}
+	}()
+	return json.NewEncoder(f).Encode(v)
+}
+
+// unionStrings returns the sorted union of a and b, without
+// duplicates.
+func unionStrings(a, b []string) []string {
+	seen := make(map[string]struct{}, len(a)+len(b))
+	var u []string
+	for _, ss := range [][]string{a, b} {
+		for _, s := range ss {
+			if _, seen2 := seen[s]; !seen2 {
+				seen[s] = struct{}{}
+				u = append(u, s)
+			}
+		}
+	}
+	sort.Strings(u)
+	return u
+}
+
+type xrefSourcesByUnit []*xrefSource
+
+func (v xrefSourcesByUnit) Len() int      { return len(v) }
+func (v xrefSourcesByUnit) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v xrefSourcesByUnit) Less(i, j int) bool {
+	if v[i].UnitType != v[j].UnitType {
+		return v[i].UnitType < v[j].UnitType
+	}
+	return v[i].Unit < v[j].Unit
+}
+
+type xrefsByKey []*XRef
+
+func (v xrefsByKey) Len() int      { return len(v) }
+func (v xrefsByKey) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v xrefsByKey) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	ka := []string{a.Def.DefRepo, a.Def.DefUnitType, a.Def.DefUnit, a.Def.DefPath, a.Repo, a.CommitID, a.UnitType, a.Unit}
+	kb := []string{b.Def.DefRepo, b.Def.DefUnitType, b.Def.DefUnit, b.Def.DefPath, b.Repo, b.CommitID, b.UnitType, b.Unit}
+	for k := range ka {
+		if ka[k] != kb[k] {
+			return ka[k] < kb[k]
+		}
+	}
+	return false
+}
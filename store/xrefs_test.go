@@ -0,0 +1,111 @@
+package store
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestFSMultiRepoStore_XRefs(t *testing.T) {
+	for label, conf := range map[string]*FSMultiRepoStoreConf{
+		"default":           nil,
+		"custom repo paths": {RepoPaths: &customRepoPaths{}},
+	} {
+		mrs := NewFSMultiRepoStore(newTestFS(), conf)
+		xs := mrs.(XRefStore)
+
+		def := graph.RefDefKey{DefRepo: "lib", DefUnitType: "t", DefUnit: "u", DefPath: "p"}
+		u1 := &unit.SourceUnit{Type: "t", Name: "u1"}
+		u2 := &unit.SourceUnit{Type: "t", Name: "u2"}
+		imports := []struct {
+			repo string
+			unit *unit.SourceUnit
+			refs []*graph.Ref
+		}{
+			{"lib", &unit.SourceUnit{Type: "t", Name: "u"}, []*graph.Ref{
+				{DefRepo: "lib", DefUnitType: "t", DefUnit: "u", DefPath: "p", File: "f", Start: 1, End: 2},
+			}},
+			{"a", u1, []*graph.Ref{
+				{DefRepo: "LIB", DefUnitType: "t", DefUnit: "u", DefPath: "p", File: "f", Start: 1, End: 2},
+				{DefRepo: "lib", DefUnitType: "t", DefUnit: "u", DefPath: "p", File: "f", Start: 3, End: 4},
+				{DefRepo: "lib", DefUnitType: "t", DefUnit: "u", DefPath: "q", File: "f", Start: 5, End: 6},
+				{DefPath: "p", File: "f", Start: 7, End: 8},
+			}},
+			{"a", u2, []*graph.Ref{
+				// The def's unit type is the same as the ref's, so
+				// it's implied.
+				{DefRepo: "lib", DefUnit: "u", DefPath: "p", File: "g", Start: 1, End: 2},
+			}},
+			{"b", u1, []*graph.Ref{
+				{DefRepo: "lib", DefUnitType: "t", DefUnit: "u", DefPath: "p", File: "h", Start: 1, End: 2},
+			}},
+		}
+		for _, imp := range imports {
+			if err := mrs.Import(imp.repo, "c", imp.unit, graph.Output{Refs: imp.refs}); err != nil {
+				t.Fatalf("%s: %s", label, err)
+			}
+		}
+
+		repos, err := mrs.Repos()
+		if err != nil {
+			t.Fatalf("%s: %s", label, err)
+		}
+		sort.Strings(repos)
+		if want := []string{"a", "b", "lib"}; !reflect.DeepEqual(repos, want) {
+			t.Errorf("%s: got repos %v, want %v", label, repos, want)
+		}
+
+		xrefLocs := func() map[string][]XRefRange {
+			xrefs, err := xs.XRefs(def)
+			if err != nil {
+				t.Fatalf("%s: %s", label, err)
+			}
+			locs := map[string][]XRefRange{}
+			for _, x := range xrefs {
+				if x.Def != def && !graph.URIEqual(x.Def.DefRepo, def.DefRepo) {
+					t.Errorf("%s: got xref to %+v, want to %+v", label, x.Def, def)
+				}
+				k := x.Repo + "@" + x.CommitID + "/" + x.Unit
+				locs[k] = append(locs[k], x.Refs...)
+			}
+			return locs
+		}
+		want := map[string][]XRefRange{
+			"a@c/u1": {{File: "f", Start: 1, End: 2}, {File: "f", Start: 3, End: 4}},
+			"a@c/u2": {{File: "g", Start: 1, End: 2}},
+			"b@c/u1": {{File: "h", Start: 1, End: 2}},
+		}
+		if got := xrefLocs(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got xrefs %+v, want %+v", label, got, want)
+		}
+
+		// Reimporting a source unit replaces only its xrefs.
+		if err := mrs.Import("a", "c", u1, graph.Output{}); err != nil {
+			t.Fatalf("%s: %s", label, err)
+		}
+		delete(want, "a@c/u1")
+		if got := xrefLocs(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: after reimport: got xrefs %+v, want %+v", label, got, want)
+		}
+
+		if err := xs.RemoveXRefs("b", "c"); err != nil {
+			t.Fatalf("%s: %s", label, err)
+		}
+		delete(want, "b@c/u1")
+		if got := xrefLocs(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: after remove: got xrefs %+v, want %+v", label, got, want)
+		}
+
+		// Reindexing reads the commit's refs from the repo's store.
+		if err := xs.IndexXRefs("b", "c"); err != nil {
+			t.Fatalf("%s: %s", label, err)
+		}
+		want["b@c/u1"] = []XRefRange{{File: "h", Start: 1, End: 2}}
+		if got := xrefLocs(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: after reindex: got xrefs %+v, want %+v", label, got, want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package toolchain
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Env describes the environment that a tool ran in. It is recorded
+// in the build data alongside the tool's output so that the tool run
+// can later be reproduced (see `srclib repro`).
+type Env struct {
+	// Toolchain and Subcmd identify the tool that ran.
+	Toolchain string
+	Subcmd    string
+
+	// Mode is the method the tool was run with: "program" or
+	// "docker".
+	Mode string
+
+	// Program is the path to the toolchain's executable program, for
+	// the program execution method.
+	Program string `json:",omitempty"`
+
+	// ToolchainVersion is the VCS revision of the toolchain's
+	// directory, if it is a git repository.
+	ToolchainVersion string `json:",omitempty"`
+
+	// ImageID is the ID (content digest) of the toolchain's Docker
+	// image, for the Docker execution method.
+	ImageID string `json:",omitempty"`
+
+	// Vars are the environment variables (in "key=value" form) that
+	// the tool ran with and that are listed in RecordedEnvVars or in
+	// $SRCLIB_RECORD_ENV. Other variables aren't recorded, since
+	// build data is shared and they may hold credentials.
+	Vars []string
+
+	// GOOS and GOARCH are the OS and architecture of the srclib
+	// binary that ran the tool.
+	GOOS, GOARCH string
+}
+
+// ModeName returns "program" or "docker" for a mode with only one of
+// AsProgram or AsDockerContainer set, and the empty string
+// otherwise.
+func ModeName(mode Mode) string {
+	switch mode {
+	case AsProgram:
+		return "program"
+	case AsDockerContainer:
+		return "docker"
+	}
+	return ""
+}
+
+// ModeByName returns the Mode for a name returned by ModeName.
+func ModeByName(name string) (Mode, error) {
+	switch name {
+	case "program":
+		return AsProgram, nil
+	case "docker":
+		return AsDockerContainer, nil
+	}
+	return 0, fmt.Errorf("unknown toolchain mode %q", name)
+}
+
+// CaptureEnv returns the environment that the tool would run in if it
+// were opened now with OpenTool(toolchainPath, subcmd, mode).
+func CaptureEnv(toolchainPath, subcmd string, mode Mode) (*Env, error) {
+	info, err := Lookup(toolchainPath)
+	if err != nil {
+		return nil, err
+	}
+	tc, err := Open(toolchainPath, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	env := &Env{
+		Toolchain:        toolchainPath,
+		Subcmd:           subcmd,
+		ToolchainVersion: gitRevision(info.Dir),
+		Vars:             recordedEnv(os.Environ()),
+		GOOS:             runtime.GOOS,
+		GOARCH:           runtime.GOARCH,
+	}
+	switch tc := tc.(type) {
+	case *programToolchain:
+		env.Mode = ModeName(AsProgram)
+		env.Program = tc.program
+	case *dockerToolchain:
+		env.Mode = ModeName(AsDockerContainer)
+		img, err := tc.docker.InspectImage(tc.imageName)
+		if err != nil {
+			return nil, err
+		}
+		env.ImageID = img.ID
+	}
+	return env, nil
+}
+
+// Diff returns human-readable descriptions of the differences between
+// e and other that could cause a tool to produce different output.
+func (e *Env) Diff(other *Env) []string {
+	var diffs []string
+	field := func(name, a, b string) {
+		if a != b {
+			diffs = append(diffs, fmt.Sprintf("%s: %q != %q", name, a, b))
+		}
+	}
+	field("Toolchain", e.Toolchain, other.Toolchain)
+	field("Subcmd", e.Subcmd, other.Subcmd)
+	field("Mode", e.Mode, other.Mode)
+	field("Program", e.Program, other.Program)
+	field("ToolchainVersion", e.ToolchainVersion, other.ToolchainVersion)
+	field("ImageID", e.ImageID, other.ImageID)
+	field("GOOS", e.GOOS, other.GOOS)
+	field("GOARCH", e.GOARCH, other.GOARCH)
+	return diffs
+}
+
+// gitRevision returns the git commit ID checked out in dir, or the
+// empty string if it can't be determined.
+func gitRevision(dir string) string {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return string(bytes.TrimSpace(out))
+}
+
+// RecordedEnvVars are the names of the environment variables that are
+// recorded in Env.Vars (if they are set). They are the ones that
+// commonly determine which language versions and dependencies a tool
+// finds.
+var RecordedEnvVars = []string{
+	"PATH", "LANG", "LC_ALL", "TZ", "SRCLIBPATH",
+	"GOPATH", "GOROOT", "GOOS", "GOARCH", "GOFLAGS", "GO111MODULE", "CGO_ENABLED",
+	"JAVA_HOME", "CLASSPATH",
+	"PYTHONPATH", "VIRTUAL_ENV",
+	"NODE_PATH", "NODE_ENV",
+	"GEM_HOME", "GEM_PATH", "RUBYLIB",
+}
+
+// RecordEnvVar is the name of the environment variable that lists
+// (comma-separated) the names of other environment variables to
+// record in Env.Vars.
+const RecordEnvVar = "SRCLIB_RECORD_ENV"
+
+// recordedEnv returns the variables in vars (in "key=value" form)
+// that are recorded in Env.Vars.
+func recordedEnv(vars []string) []string {
+	names := map[string]bool{}
+	for _, name := range RecordedEnvVars {
+		names[name] = true
+	}
+	for _, kv := range vars {
+		if strings.HasPrefix(kv, RecordEnvVar+"=") {
+			for _, name := range strings.Split(strings.TrimPrefix(kv, RecordEnvVar+"="), ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					names[name] = true
+				}
+			}
+		}
+	}
+
+	var recorded []string
+	for _, kv := range vars {
+		if names[strings.SplitN(kv, "=", 2)[0]] {
+			recorded = append(recorded, kv)
+		}
+	}
+	return recorded
+}
@@ -0,0 +1,94 @@
+package toolchain
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib"
+)
+
+func TestRecordedEnv(t *testing.T) {
+	vars := []string{
+		"PATH=/bin",
+		"GOPATH=/go",
+		"DATABASE_URL=postgres://u:p@h/db",
+		"AWS_SESSION=s",
+		"GH_PAT=x",
+		"MY_FLAGS=-v",
+		"SRCLIB_RECORD_ENV=MY_FLAGS, ",
+	}
+	want := []string{"PATH=/bin", "GOPATH=/go", "MY_FLAGS=-v"}
+	if got := recordedEnv(vars); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCaptureEnv(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "srclib-toolchain-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer func(orig string) {
+		srclib.Path = orig
+	}(srclib.Path)
+	srclib.Path = tmpdir
+
+	var extension string
+	if runtime.GOOS == "windows" {
+		extension = ".exe"
+	}
+	program := filepath.Join(tmpdir, "a", "a", ".bin", "a"+extension)
+	for f, mode := range map[string]os.FileMode{program: 0700, filepath.Join(tmpdir, "a", "a", "Srclibtoolchain"): 0700} {
+		if err := os.MkdirAll(filepath.Dir(f), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(f, nil, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, value := range map[string]string{"GOPATH": "/go", "GH_PAT": "secret", "SRCLIB_RECORD_ENV": ""} {
+		defer func(name, orig string) { os.Setenv(name, orig) }(name, os.Getenv(name))
+		os.Setenv(name, value)
+	}
+
+	env, err := CaptureEnv("a/a", "graph", AsProgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Toolchain != "a/a" || env.Subcmd != "graph" || env.Mode != "program" || env.Program != program {
+		t.Errorf("got env %+v, want program toolchain a/a graph (program %s)", env, program)
+	}
+	if env.GOOS != runtime.GOOS || env.GOARCH != runtime.GOARCH {
+		t.Errorf("got GOOS/GOARCH %s/%s, want %s/%s", env.GOOS, env.GOARCH, runtime.GOOS, runtime.GOARCH)
+	}
+	var gopath bool
+	for _, kv := range env.Vars {
+		switch kv {
+		case "GOPATH=/go":
+			gopath = true
+		case "GH_PAT=secret":
+			t.Errorf("got unlisted var %q recorded", kv)
+		}
+	}
+	if !gopath {
+		t.Errorf("got vars %v, want GOPATH recorded", env.Vars)
+	}
+}
+
+func TestEnv_Diff(t *testing.T) {
+	a := &Env{Toolchain: "a/a", Subcmd: "graph", Mode: "program", GOOS: "linux"}
+	b := *a
+	if diffs := a.Diff(&b); len(diffs) != 0 {
+		t.Errorf("got diffs %v for identical envs, want none", diffs)
+	}
+	b.Mode, b.GOOS = "docker", "darwin"
+	if diffs := a.Diff(&b); len(diffs) != 2 {
+		t.Errorf("got diffs %v, want Mode and GOOS", diffs)
+	}
+}
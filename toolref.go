@@ -14,9 +14,20 @@ type ToolRef struct {
 
 	// Subcmd is the name of the toolchain subcommand that runs this tool.
 	Subcmd string
+
+	// Version is the version of the toolchain (its VCS revision or
+	// Docker image ID) that ran the tool. It is only set on the tools
+	// recorded in imported source units' Ops, which identify the tool
+	// that produced each unit's data.
+	Version string `json:",omitempty"`
 }
 
-func (t ToolRef) String() string { return fmt.Sprintf("%s %s", t.Toolchain, t.Subcmd) }
+func (t ToolRef) String() string {
+	if t.Version != "" {
+		return fmt.Sprintf("%s %s (version %s)", t.Toolchain, t.Subcmd, t.Version)
+	}
+	return fmt.Sprintf("%s %s", t.Toolchain, t.Subcmd)
+}
 
 func (t *ToolRef) UnmarshalFlag(value string) error {
 	parts := strings.Split(value, ":")